@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/rebelopsio/duet/internal/config/hosts"
+	"github.com/rebelopsio/duet/internal/core/lua"
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/planner"
+	awsprovider "github.com/rebelopsio/duet/internal/iac/provider/aws"
+)
+
+// connectOptions holds the transport settings hosts are reached with. The
+// AWS config for SSM is filled in from the configured providers.
+var connectOptions hosts.ConnectOptions
+
+// hostEntry is a host from the infrastructure's "hosts" list, with the
+// table configure_instance is called with
+type hostEntry struct {
+	host  *hosts.Host
+	table map[string]interface{}
+}
+
+// loadHosts reads the infrastructure's "hosts" list. Hosts are configured
+// by the config's configure_instance function, which must be defined if
+// any are listed.
+func loadHosts(engine *lua.Engine, infra map[string]interface{}) ([]hostEntry, error) {
+	v, ok := infra["hosts"]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("hosts: expected a list of tables, got %T", v)
+	}
+
+	entries := make([]hostEntry, 0, len(list))
+	seen := make(map[string]bool, len(list))
+	for i, item := range list {
+		table, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("hosts[%d]: expected a table, got %T", i+1, item)
+		}
+		host, err := hosts.ParseHost(table)
+		if err != nil {
+			return nil, fmt.Errorf("hosts[%d]: %w", i+1, err)
+		}
+		if seen[host.Address] {
+			return nil, fmt.Errorf("host %s is listed more than once", host.Address)
+		}
+		seen[host.Address] = true
+		entries = append(entries, hostEntry{host: host, table: table})
+	}
+
+	if len(entries) > 0 && !engine.HasFunction("configure_instance") {
+		return nil, fmt.Errorf("hosts are listed but configure_instance is not defined")
+	}
+	return entries, nil
+}
+
+// configureHosts connects to each host in turn and runs configure_instance
+// against it, then marks its instance as configured in state. Hosts reached
+// over SSM use the AWS provider configuration their instance belongs to. It
+// returns a change record per host, and stops at the first host that fails.
+func configureHosts(ctx context.Context, w io.Writer, store *state.Store, engine *lua.Engine, p *planner.Planner, entries []hostEntry) ([]state.OperationChange, error) {
+	opts := connectOptions
+	opts.AWS = func(alias string) (aws.Config, error) {
+		key := planner.ProviderKey("aws", alias)
+		prov, ok := p.Provider(key)
+		if !ok {
+			return aws.Config{}, fmt.Errorf("provider %s is not configured", key)
+		}
+		awsProv, ok := prov.(*awsprovider.AWSProvider)
+		if !ok {
+			return aws.Config{}, fmt.Errorf("provider %s is not the AWS provider", key)
+		}
+		return awsProv.Config(), nil
+	}
+
+	var changes []state.OperationChange
+	for _, entry := range entries {
+		host := entry.host
+		instance, err := hosts.Instance(ctx, store, host.Address)
+		if err != nil {
+			return changes, fmt.Errorf("failed to configure %s: %w", host.Address, err)
+		}
+		record := state.OperationChange{
+			ResourceID: instance.ID,
+			Type:       instance.Type,
+			Name:       instance.Name,
+			Provider:   instance.Provider,
+			Action:     "configure",
+		}
+
+		if err := configureHost(ctx, store, engine, entry, opts); err != nil {
+			record.Error = err.Error()
+			changes = append(changes, record)
+			return changes, fmt.Errorf("failed to configure %s: %w", host.Address, err)
+		}
+		changes = append(changes, record)
+
+		instance.ConfigApplied = true
+		if err := store.SaveResource(ctx, instance); err != nil {
+			return changes, fmt.Errorf("failed to record %s as configured: %w", host.Address, err)
+		}
+		fmt.Fprintf(w, "Configured %s over %s\n", host.Address, host.Transport)
+	}
+	return changes, nil
+}
+
+func configureHost(ctx context.Context, store *state.Store, engine *lua.Engine, entry hostEntry, opts hosts.ConnectOptions) error {
+	conn, err := hosts.Connect(ctx, store, entry.host, opts)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	return engine.ConfigureHost(ctx, entry.table, conn)
+}
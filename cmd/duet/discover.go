@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/discover"
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+var discoverFlags struct {
+	provider string
+	region   string
+	profile  string
+	endpoint string
+	output   string
+	project  string
+	tags     []string
+	types    []string
+}
+
+var discoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "Import existing resources into state and write Lua declaring them",
+	Long: `Discover lists the resources in an account that state does not hold yet,
+writes a Lua config declaring them and records them in state, so applying it
+changes nothing. If anything fails, nothing is recorded and no file is left.
+
+Resources created outside duet carry no ownership tags and are always
+discovered. Resources duet created are only discovered when --project names
+the project that owns them and the current workspace matches theirs, to
+recover those state lost track of; others are never discovered. Merge the
+generated resources into your config before applying it in the same
+workspace, or they are planned for deletion.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleDiscover(cmd)
+	},
+}
+
+func init() {
+	discoverCmd.Flags().StringVar(&discoverFlags.provider, "provider", "aws", "provider to discover resources with")
+	discoverCmd.Flags().StringVar(&discoverFlags.region, "region", "", "region to discover resources in")
+	discoverCmd.Flags().StringVar(&discoverFlags.profile, "profile", "", "shared config profile to use")
+	discoverCmd.Flags().StringVar(&discoverFlags.endpoint, "endpoint", "", "send API calls to this endpoint instead of the provider's")
+	discoverCmd.Flags().StringArrayVar(&discoverFlags.tags, "tag", nil, "only discover resources with this tag, as key=value or key (repeatable)")
+	discoverCmd.Flags().StringArrayVar(&discoverFlags.types, "type", nil, "only discover resources of this type (repeatable)")
+	discoverCmd.Flags().StringVar(&discoverFlags.project, "project", "", "also discover resources duet created for this project in the current workspace")
+	discoverCmd.Flags().StringVarP(&discoverFlags.output, "output", "o", "discovered.lua", "file to write the Lua config to; it must not exist")
+}
+
+func handleDiscover(cmd *cobra.Command) (err error) {
+	ctx := context.Background()
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	settings := make(map[string]interface{})
+	for key, value := range map[string]string{
+		"region":   discoverFlags.region,
+		"profile":  discoverFlags.profile,
+		"endpoint": discoverFlags.endpoint,
+	} {
+		if value != "" {
+			settings[key] = value
+		}
+	}
+	prov, err := newProvider(ctx, store, discoverFlags.provider, settings)
+	if err != nil {
+		return err
+	}
+
+	tags := make(map[string]string, len(discoverFlags.tags))
+	for _, tag := range discoverFlags.tags {
+		key, value, _ := strings.Cut(tag, "=")
+		tags[key] = value
+	}
+
+	// Claim the output file before looking for anything, and write it before
+	// recording anything: resources recorded in state but declared nowhere
+	// would be planned for deletion
+	out, err := os.OpenFile(discoverFlags.output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s already exists; choose another file with --output", discoverFlags.output)
+	}
+	if err != nil {
+		return err
+	}
+	recorded := false
+	defer func() {
+		_ = out.Close()
+		// Without the resources in state the file declares nothing real, and
+		// leaving it would block a retry with the same --output
+		if !recorded {
+			_ = os.Remove(discoverFlags.output)
+		}
+	}()
+
+	op := newOperation("discover", discoverFlags.output)
+	defer func() {
+		err = recordOperation(ctx, store, op, err)
+	}()
+
+	resources, err := discover.Discover(ctx, store, prov, discover.Options{
+		Tags:      tags,
+		Types:     discoverFlags.types,
+		Ownership: provider.Ownership{Project: discoverFlags.project, Workspace: store.Workspace()},
+	})
+	if err != nil {
+		return err
+	}
+	if err := discover.WriteLua(out, discoverFlags.provider, settings, resources); err != nil {
+		return fmt.Errorf("failed to write %s: %w", discoverFlags.output, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", discoverFlags.output, err)
+	}
+	if err := discover.Record(ctx, store, resources); err != nil {
+		return err
+	}
+	recorded = true
+	for _, r := range resources {
+		op.Changes = append(op.Changes, state.OperationChange{
+			ResourceID: r.ID,
+			Type:       r.Type,
+			Name:       r.Name,
+			Provider:   r.Provider,
+			Action:     "import",
+		})
+	}
+
+	w := cmd.OutOrStdout()
+	for _, r := range resources {
+		fmt.Fprintf(w, "  + %s (%s)\n", r.Address(), r.ID)
+	}
+	fmt.Fprintf(w, "\nImported %d resource(s) into state and wrote %s\n", len(resources), discoverFlags.output)
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+)
+
+var historyFlags struct {
+	limit int
+	json  bool
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the audit log of past runs",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleHistory(cmd)
+	},
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show <run-id>",
+	Short: "Show a single run and the changes it made",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleHistoryShow(cmd, args[0])
+	},
+}
+
+func init() {
+	historyCmd.PersistentFlags().BoolVar(&historyFlags.json, "json", false, "output as JSON")
+	historyCmd.Flags().IntVar(&historyFlags.limit, "limit", 20, "maximum runs to show (0 for all)")
+
+	historyCmd.AddCommand(historyShowCmd)
+}
+
+// newOperation starts an audit record for a command run against configFile,
+// which is empty for commands that take no configuration
+func newOperation(command, configFile string) *state.Operation {
+	op := &state.Operation{
+		RunID:       state.NewRunID(),
+		Command:     command,
+		DuetVersion: version,
+		StartedAt:   time.Now().UTC(),
+		Changes:     []state.OperationChange{},
+	}
+	if configFile != "" {
+		op.ConfigCommit = gitCommit(configFile)
+	}
+	if u, err := user.Current(); err == nil {
+		op.User = u.Username
+	}
+	if h, err := os.Hostname(); err == nil {
+		op.Hostname = h
+	}
+	return op
+}
+
+// recordOperation completes op with the outcome of runErr and appends it to
+// the audit log. It returns runErr, or the recording error if the run itself
+// succeeded.
+func recordOperation(ctx context.Context, store *state.Store, op *state.Operation, runErr error) error {
+	op.FinishedAt = time.Now().UTC()
+	op.Outcome = state.OutcomeSucceeded
+	if runErr != nil {
+		op.Outcome = state.OutcomeFailed
+		op.Error = runErr.Error()
+	}
+
+	if err := store.RecordOperation(ctx, op); err != nil {
+		if runErr != nil {
+			log.Printf("Warning: %v", err)
+			return runErr
+		}
+		return err
+	}
+	return runErr
+}
+
+// gitCommit returns the commit checked out in the repository containing
+// path, or an empty string if it is not under git
+func gitCommit(path string) string {
+	dir := filepath.Dir(path)
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func handleHistory(cmd *cobra.Command) error {
+	ctx := context.Background()
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	ops, err := store.ListOperations(ctx, historyFlags.limit)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	if historyFlags.json {
+		return writeJSON(out, ops)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN ID\tCOMMAND\tUSER\tSTARTED\tDURATION\tCHANGES\tOUTCOME")
+	for _, op := range ops {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			op.RunID, op.Command, op.User,
+			op.StartedAt.Local().Format(time.DateTime),
+			op.Duration().Round(time.Millisecond),
+			len(op.Changes), op.Outcome)
+	}
+	return w.Flush()
+}
+
+func handleHistoryShow(cmd *cobra.Command, runID string) error {
+	ctx := context.Background()
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	op, err := store.GetOperation(ctx, runID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("no run with ID %s in workspace %s", runID, store.Workspace())
+	}
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	if historyFlags.json {
+		return writeJSON(out, op)
+	}
+
+	fmt.Fprintf(out, "Run:       %s\n", op.RunID)
+	fmt.Fprintf(out, "Command:   %s\n", op.Command)
+	fmt.Fprintf(out, "User:      %s@%s\n", op.User, op.Hostname)
+	fmt.Fprintf(out, "Version:   %s\n", op.DuetVersion)
+	if op.ConfigCommit != "" {
+		fmt.Fprintf(out, "Commit:    %s\n", op.ConfigCommit)
+	}
+	fmt.Fprintf(out, "Started:   %s\n", op.StartedAt.Local().Format(time.RFC3339))
+	fmt.Fprintf(out, "Finished:  %s\n", op.FinishedAt.Local().Format(time.RFC3339))
+	fmt.Fprintf(out, "Outcome:   %s\n", op.Outcome)
+	if op.Error != "" {
+		fmt.Fprintf(out, "Error:     %s\n", op.Error)
+	}
+
+	if len(op.Changes) == 0 {
+		fmt.Fprintln(out, "\nNo resource changes")
+		return nil
+	}
+
+	fmt.Fprintln(out)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tPROVIDER\tTYPE\tNAME\tID\tERROR")
+	for _, c := range op.Changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Action, c.Provider, c.Type, c.Name, c.ResourceID, c.Error)
+	}
+	return w.Flush()
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -2,19 +2,33 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
 	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/applier"
 )
 
+// Set at build time by goreleaser
 var (
-	cfgFile string
-	store   *state.Store
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+var (
+	cfgFile       string
+	stateFile     string
+	workspaceName string
 )
 
 func main() {
@@ -30,6 +44,7 @@ var rootCmd = &cobra.Command{
 	Long: `Duet is a tool that orchestrates both infrastructure provisioning
 and configuration management using Lua as its configuration language.
 Complete documentation is available at https://github.com/rebelopsio/duet`,
+	Version: fmt.Sprintf("%s (commit %s, built %s)", version, commit, date),
 }
 
 var applyCmd = &cobra.Command{
@@ -37,7 +52,7 @@ var applyCmd = &cobra.Command{
 	Short: "Apply infrastructure and configuration changes",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return handleApply(args[0])
+		return handleApply(cmd, args[0])
 	},
 }
 
@@ -46,24 +61,94 @@ var planCmd = &cobra.Command{
 	Short: "Show planned changes",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return handlePlan(args[0])
+		return handlePlan(cmd, args[0])
 	},
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	cobra.OnFinalize(closePlugins)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.duet.yaml)")
+	rootCmd.PersistentFlags().StringVar(&stateFile, "state", "duet.db", "path to the state database")
+	rootCmd.PersistentFlags().StringVar(&workspaceName, "workspace", "", "workspace to use instead of the selected one (or set DUET_WORKSPACE)")
 
 	rootCmd.AddCommand(applyCmd)
 	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(stateCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(workspaceCmd)
+	rootCmd.AddCommand(discoverCmd)
+}
 
-	// Initialize state store
-	var err error
-	store, err = state.NewStore("duet.db")
+// openStore opens the state database, applying any pending migrations, and
+// scopes it to the current workspace, which must exist
+func openStore() (*state.Store, error) {
+	store, err := openWorkspaceStore()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if _, err := store.GetWorkspace(context.Background(), store.Workspace()); err != nil {
+		return nil, err
 	}
+	return store, nil
+}
+
+// openWorkspaceStore is openStore for the workspace commands, which must
+// work while --workspace or DUET_WORKSPACE names a workspace that does not
+// exist yet or has just been deleted
+func openWorkspaceStore() (*state.Store, error) {
+	store, err := state.NewStore(stateFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state: %w", err)
+	}
+
+	name := currentWorkspaceOverride()
+	if name == "" {
+		if name, err = store.SelectedWorkspace(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
+	store.SetKeySource(stateKey)
+	return store.WithWorkspace(name), nil
+}
+
+// stateKey returns the passphrase sensitive values in state, such as
+// generated private keys, are encrypted with: DUET_STATE_KEY if set, or the
+// contents of a key file beside the state database, created on first use
+func stateKey() (string, error) {
+	if key := os.Getenv("DUET_STATE_KEY"); key != "" {
+		return key, nil
+	}
+
+	path := stateFile + ".key"
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(secret)
+	if err := os.WriteFile(path, []byte(key+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("failed to create state key file: %w", err)
+	}
+	return key, nil
+}
+
+// currentWorkspaceOverride returns the workspace named by --workspace or
+// DUET_WORKSPACE, if either is set
+func currentWorkspaceOverride() string {
+	if workspaceName != "" {
+		return workspaceName
+	}
+	return os.Getenv("DUET_WORKSPACE")
 }
 
 func initConfig() {
@@ -85,28 +170,125 @@ func initConfig() {
 	}
 }
 
-func handleApply(filename string) error {
+func handleApply(cmd *cobra.Command, filename string) (err error) {
 	ctx := context.Background()
-	resources, err := store.GetResources(ctx)
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	op := newOperation("apply", filename)
+	defer func() {
+		err = recordOperation(ctx, store, op, err)
+	}()
+
+	engine, infra, err := loadInfrastructure(filename, store.Workspace())
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	entries, err := loadHosts(engine, infra)
+	if err != nil {
+		return err
+	}
+
+	p, err := newPlanner(ctx, store, infra)
 	if err != nil {
-		return fmt.Errorf("failed to get resources: %w", err)
+		return err
+	}
+
+	// Reconcile anything an interrupted run left half-done before planning,
+	// so the plan is made against what really exists
+	a := applier.NewApplier(store, p, op.RunID)
+	a.SetProject(projectName(filename, infra))
+	recovered, err := a.Recover(ctx)
+	op.Changes = append(op.Changes, recovered...)
+	if err != nil {
+		return err
+	}
+	if len(recovered) > 0 {
+		log.Printf("Reconciled %d interrupted change(s) from a previous run", len(recovered))
+		if p, err = newPlanner(ctx, store, infra); err != nil {
+			return err
+		}
 	}
 
-	log.Printf("Found %d existing resources", len(resources))
+	plan, err := p.CreatePlan(ctx, infra)
+	if err != nil {
+		return fmt.Errorf("failed to create plan: %w", err)
+	}
+
+	out := cmd.OutOrStdout()
+	printPlan(out, plan)
+	if !plan.HasChanges() && len(entries) == 0 {
+		return nil
+	}
 
-	// TODO: Implement actual apply logic
+	changes, err := a.Apply(ctx, plan)
+	op.Changes = append(op.Changes, changes...)
+	if err != nil {
+		return err
+	}
+
+	// Hosts are configured on every apply, once the resources they run on
+	// are in place
+	if len(entries) > 0 {
+		fmt.Fprintln(out)
+	}
+	configured, err := configureHosts(ctx, out, store, engine, p, entries)
+	op.Changes = append(op.Changes, configured...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\nApply complete: %d change(s) in run %s\n", len(changes)+len(configured), op.RunID)
 	return nil
 }
 
-func handlePlan(filename string) error {
+func handlePlan(cmd *cobra.Command, filename string) error {
 	ctx := context.Background()
-	resources, err := store.GetResources(ctx)
+	store, err := openStore()
 	if err != nil {
-		return fmt.Errorf("failed to get resources: %w", err)
+		return err
 	}
 
-	log.Printf("Planning changes for %d existing resources", len(resources))
+	engine, infra, err := loadInfrastructure(filename, store.Workspace())
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	entries, err := loadHosts(engine, infra)
+	if err != nil {
+		return err
+	}
+
+	p, err := newPlanner(ctx, store, infra)
+	if err != nil {
+		return err
+	}
+
+	pending, err := store.PendingIntents(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		log.Printf("Warning: %d change(s) from an interrupted run will be reconciled on the next apply; see duet state intents list", len(pending))
+	}
+
+	plan, err := p.CreatePlan(ctx, infra)
+	if err != nil {
+		return fmt.Errorf("failed to create plan: %w", err)
+	}
 
-	// TODO: Implement actual plan logic
+	out := cmd.OutOrStdout()
+	printPlan(out, plan)
+	if len(entries) > 0 {
+		fmt.Fprintln(out, "\nHosts to configure after applying:")
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(out, "  * %s over %s\n", entry.host.Address, entry.host.Transport)
+	}
 	return nil
 }
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rebelopsio/duet/internal/core/lua"
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/planner"
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	awsprovider "github.com/rebelopsio/duet/internal/iac/provider/aws"
+	localprovider "github.com/rebelopsio/duet/internal/iac/provider/local"
+	"github.com/rebelopsio/duet/internal/iac/provider/plugin"
+	randomprovider "github.com/rebelopsio/duet/internal/iac/provider/random"
+	tlsprovider "github.com/rebelopsio/duet/internal/iac/provider/tls"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// loadInfrastructure evaluates a Lua config file for the given workspace and
+// returns the table built by its deploy_infrastructure function, along with
+// the engine, which the caller must close
+func loadInfrastructure(filename, workspace string) (*lua.Engine, map[string]interface{}, error) {
+	engine := lua.NewEngine()
+	engine.SetWorkspace(workspace)
+
+	if err := engine.LoadFile(filename); err != nil {
+		engine.Close()
+		return nil, nil, fmt.Errorf("failed to load %s: %w", filename, err)
+	}
+
+	infra, err := engine.Infrastructure()
+	if err != nil {
+		engine.Close()
+		return nil, nil, fmt.Errorf("failed to evaluate %s: %w", filename, err)
+	}
+	return engine, infra, nil
+}
+
+// projectName returns the infrastructure's "project" setting, or by default
+// the config file's name without its extension
+func projectName(filename string, infra map[string]interface{}) string {
+	if project, _ := infra["project"].(string); project != "" {
+		return project
+	}
+	base := filepath.Base(filename)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// newPlanner creates a planner with the providers configured in the
+// infrastructure's "providers" table, primed with the recorded state. Each
+// provider takes a settings table, or a list of them to configure it more
+// than once; all but one of those need an "alias" for resources to select
+// them by.
+func newPlanner(ctx context.Context, store *state.Store, infra map[string]interface{}) (*planner.Planner, error) {
+	p := planner.NewPlanner()
+
+	providers, _ := infra["providers"].(map[string]interface{})
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		configs, err := providerConfigs(name, providers[name])
+		if err != nil {
+			return nil, err
+		}
+
+		seen := make(map[string]bool, len(configs))
+		for _, settings := range configs {
+			alias, _ := settings["alias"].(string)
+			key := planner.ProviderKey(name, alias)
+			if seen[key] {
+				return nil, fmt.Errorf("provider %s is configured more than once", key)
+			}
+			seen[key] = true
+
+			prov, err := newProvider(ctx, store, name, settings)
+			if err != nil {
+				return nil, fmt.Errorf("provider %s: %w", key, err)
+			}
+			if alias == "" {
+				p.RegisterProvider(prov)
+			} else {
+				p.RegisterAlias(alias, prov)
+			}
+		}
+	}
+
+	resources, err := store.GetResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resources: %w", err)
+	}
+	p.SetState(resources)
+	p.SetSecrets(func(r *state.Resource) (map[string]interface{}, error) {
+		return store.OpenSecrets(ctx, r)
+	})
+
+	return p, nil
+}
+
+// providerConfigs reads a provider's settings, a table or a list of tables
+func providerConfigs(name string, v interface{}) ([]map[string]interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{v}, nil
+	case []interface{}:
+		configs := make([]map[string]interface{}, 0, len(v))
+		for i, item := range v {
+			settings, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("provider %s[%d]: expected a table, got %T", name, i+1, item)
+			}
+			configs = append(configs, settings)
+		}
+		return configs, nil
+	default:
+		return nil, fmt.Errorf("provider %s: expected a table, got %T", name, v)
+	}
+}
+
+// newProvider constructs the named provider from its Lua settings table.
+// Providers whose values exist only in state, such as random, read them from
+// store. Providers that are not built in are served by plugins, which are
+// started with the settings.
+func newProvider(ctx context.Context, store *state.Store, name string, settings map[string]interface{}) (provider.Provider, error) {
+	switch name {
+	case "aws":
+		region, _ := settings["region"].(string)
+		var opts []awsprovider.Option
+		if endpoint, _ := settings["endpoint"].(string); endpoint != "" {
+			opts = append(opts, awsprovider.WithEndpoint(endpoint))
+		}
+		if profile, _ := settings["profile"].(string); profile != "" {
+			opts = append(opts, awsprovider.WithProfile(profile))
+		}
+		if role, ok := settings["assume_role"].(map[string]interface{}); ok {
+			arn, _ := role["role_arn"].(string)
+			externalID, _ := role["external_id"].(string)
+			sessionName, _ := role["session_name"].(string)
+			opts = append(opts, awsprovider.WithAssumeRole(awsprovider.AssumeRole{
+				RoleARN:     arn,
+				ExternalID:  externalID,
+				SessionName: sessionName,
+			}))
+		}
+		if tags, ok := settings["default_tags"].(map[string]interface{}); ok {
+			defaultTags := make(map[string]string, len(tags))
+			for k, v := range tags {
+				s, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("default_tags.%s: expected a string, got %T", k, v)
+				}
+				defaultTags[k] = s
+			}
+			opts = append(opts, awsprovider.WithDefaultTags(defaultTags))
+		}
+		return awsprovider.NewAWSProvider(ctx, region, opts...)
+	case "local":
+		var opts []localprovider.Option
+		if root, _ := settings["root"].(string); root != "" {
+			opts = append(opts, localprovider.WithRoot(root))
+		}
+		return localprovider.NewLocalProvider(opts...)
+	case "random":
+		return randomprovider.NewRandomProvider(store), nil
+	case "tls":
+		return tlsprovider.NewTLSProvider(store), nil
+	default:
+		path, err := plugin.Find(name, plugin.Dirs())
+		if errors.Is(err, plugin.ErrNotInstalled) {
+			return nil, fmt.Errorf("unsupported provider %q: %w", name, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		client, err := plugin.Launch(ctx, path, name, settings)
+		if err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, client)
+		return client, nil
+	}
+}
+
+// plugins holds the plugins started by this command, which closePlugins
+// stops once it finishes
+var plugins []*plugin.Client
+
+func closePlugins() {
+	for _, client := range plugins {
+		if err := client.Close(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	plugins = nil
+}
+
+// printPlan writes a human-readable summary of plan
+func printPlan(w io.Writer, plan *planner.Plan) {
+	symbols := map[types.ChangeType]string{
+		types.ChangeTypeCreate: "+",
+		types.ChangeTypeUpdate: "~",
+		types.ChangeTypeDelete: "-",
+	}
+
+	for _, c := range plan.Changes {
+		symbol, ok := symbols[c.Action]
+		if !ok {
+			continue
+		}
+		line := fmt.Sprintf("  %s %s", symbol, c.Address())
+		if c.Alias != "" {
+			line += " (" + c.ProviderKey() + ")"
+		}
+		if len(c.Replace) > 0 {
+			line += " (replace: " + strings.Join(c.Replace, ", ") + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	summary := plan.Summary()
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to destroy.\n",
+		summary[types.ChangeTypeCreate], summary[types.ChangeTypeUpdate], summary[types.ChangeTypeDelete])
+}
@@ -0,0 +1,1886 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/rebelopsio/duet/internal/config/hosts"
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	awsprovider "github.com/rebelopsio/duet/internal/iac/provider/aws"
+	"github.com/rebelopsio/duet/internal/iac/provider/aws/awstest"
+	"github.com/rebelopsio/duet/internal/iac/provider/plugin"
+	sdk "github.com/rebelopsio/duet/pkg/plugin"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+const standInConfig = `
+function deploy_infrastructure()
+    return {
+        provider = "aws",
+        providers = {
+            aws = { region = "us-east-1", endpoint = %q },
+        },
+        resources = {
+            { type = "instance", name = "web", config = { ami = "ami-12345678", instance_type = %q } },
+        },
+    }
+end
+`
+
+// envServePlugin makes the test binary serve fakePlugin instead of running
+// tests, so duet can start it as a provider plugin
+const envServePlugin = "DUET_TEST_SERVE_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(envServePlugin) != "" {
+		if err := sdk.Serve(fakePlugin); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+var fakePlugin = sdk.Plugin{
+	Name:          "fake",
+	ResourceTypes: []string{"thing"},
+	New: func(ctx context.Context, settings map[string]interface{}) (sdk.Provider, error) {
+		prefix, _ := settings["prefix"].(string)
+		return &fakePluginProvider{prefix: prefix}, nil
+	},
+}
+
+// fakePluginProvider makes up resources without keeping them, since each
+// run of duet starts its plugins afresh
+type fakePluginProvider struct {
+	prefix string
+}
+
+func (p *fakePluginProvider) Name() string { return "fake" }
+
+func (p *fakePluginProvider) Create(ctx context.Context, resourceType string, config map[string]interface{}) (sdk.Resource, error) {
+	return &types.BaseResource{
+		ID:       fmt.Sprintf("%s-%v", p.prefix, config["size"]),
+		Type:     types.ResourceType(resourceType),
+		Provider: "fake",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{"size": config["size"]},
+	}, nil
+}
+
+func (p *fakePluginProvider) Read(ctx context.Context, resourceType string, id string) (sdk.Resource, error) {
+	return nil, sdk.ErrNotFound
+}
+
+func (p *fakePluginProvider) Update(ctx context.Context, resource sdk.Resource, config map[string]interface{}) error {
+	return nil
+}
+
+func (p *fakePluginProvider) Delete(ctx context.Context, resource sdk.Resource) error {
+	return nil
+}
+
+func (p *fakePluginProvider) ValidateConfig(ctx context.Context, resourceType string, config map[string]interface{}) error {
+	if _, ok := config["size"].(int); !ok {
+		return fmt.Errorf("size: expected a whole number, got %v", config["size"])
+	}
+	return nil
+}
+
+// runDuet executes the root command with args and returns its output
+func runDuet(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	rootCmd.SetErr(&out)
+	rootCmd.SetArgs(args)
+	t.Cleanup(func() { rootCmd.SetArgs(nil) })
+	err := rootCmd.Execute()
+	return out.String(), err
+}
+
+func TestApplyAgainstEC2StandIn(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "aws-credentials"))
+	t.Setenv("DUET_WORKSPACE", "")
+
+	ec2Server, server := awstest.NewEC2Server()
+	defer server.Close()
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	writeConfig := func(instanceType string) {
+		t.Helper()
+		content := fmt.Sprintf(standInConfig, server.URL, instanceType)
+		if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	writeConfig("t3.micro")
+	out, err := runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "+ aws.instance.web") {
+		t.Errorf("Expected plan to create aws.instance.web, got:\n%s", out)
+	}
+
+	instances := ec2Server.Instances()
+	if len(instances) != 1 || instances[0].InstanceType != "t3.micro" {
+		t.Fatalf("Expected one t3.micro instance, got %+v", instances)
+	}
+
+	store, err := state.NewStore(statePath)
+	if err != nil {
+		t.Fatalf("Failed to open state: %v", err)
+	}
+	resource, err := store.GetResource(context.Background(), instances[0].ID)
+	if err != nil {
+		t.Fatalf("Expected instance to be recorded in state: %v", err)
+	}
+	if resource.Name != "web" {
+		t.Errorf("Expected resource name web, got %s", resource.Name)
+	}
+
+	writeConfig("t3.small")
+	out, err = runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply update: %v\n%s", err, out)
+	}
+	if inst, _ := ec2Server.Instance(instances[0].ID); inst.InstanceType != "t3.small" {
+		t.Errorf("Expected instance type t3.small, got %s", inst.InstanceType)
+	}
+	if len(ec2Server.Instances()) != 1 {
+		t.Errorf("Expected the instance to be updated in place")
+	}
+}
+
+const networkConfig = `
+function deploy_infrastructure()
+    local resources = {
+        { type = "vpc", name = "main", config = { cidr_block = "10.0.0.0/16" } },
+    }
+    if with_instance then
+        table.insert(resources, {
+            type = "instance",
+            name = "web",
+            config = {
+                ami = "ami-12345678",
+                instance_type = "t3.micro",
+                subnet_id = "${aws.subnet.public.id}",
+                security_groups = { duet.ref("aws.security_group.web") },
+            },
+        })
+        table.insert(resources, {
+            type = "security_group",
+            name = "web",
+            config = {
+                name = "web",
+                vpc_id = duet.ref("aws.vpc.main"),
+                ingress = { { protocol = "tcp", from_port = 22, cidr_blocks = { "0.0.0.0/0" } } },
+            },
+        })
+        table.insert(resources, {
+            type = "subnet",
+            name = "public",
+            config = { vpc_id = duet.ref("aws.vpc.main"), cidr_block = subnet_cidr },
+        })
+    end
+    return {
+        provider = "aws",
+        providers = {
+            aws = { region = "us-east-1", endpoint = %q },
+        },
+        resources = resources,
+    }
+end
+`
+
+func TestApplyNetworkAgainstEC2StandIn(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "aws-credentials"))
+	t.Setenv("DUET_WORKSPACE", "")
+
+	ec2Server, server := awstest.NewEC2Server()
+	defer server.Close()
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	subnetCIDR := "10.0.1.0/24"
+	writeConfig := func(withInstance bool) {
+		t.Helper()
+		content := fmt.Sprintf("with_instance = %t\nsubnet_cidr = %q\n", withInstance, subnetCIDR) + fmt.Sprintf(networkConfig, server.URL)
+		if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	writeConfig(true)
+	out, err := runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	vpc := strings.Index(out, "+ aws.vpc.main")
+	subnet := strings.Index(out, "+ aws.subnet.public")
+	instance := strings.Index(out, "+ aws.instance.web")
+	if vpc < 0 || subnet < vpc || instance < subnet {
+		t.Errorf("Expected vpc, then subnet, then instance in the plan, got:\n%s", out)
+	}
+
+	instances := ec2Server.Instances()
+	if len(instances) != 1 {
+		t.Fatalf("Expected one instance, got %+v", instances)
+	}
+	inst := instances[0]
+	subnetRecord, ok := ec2Server.Subnet(inst.SubnetID)
+	if !ok {
+		t.Fatalf("Expected instance to be launched into a managed subnet, got %q", inst.SubnetID)
+	}
+	if len(inst.SecurityGroups) != 1 || !strings.HasPrefix(inst.SecurityGroups[0], "sg-") {
+		t.Errorf("Expected instance to use the managed security group, got %v", inst.SecurityGroups)
+	}
+
+	// Applying again resolves the references to the same values
+	out, err = runDuet(t, "--state", statePath, "plan", configFile)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v\n%s", err, out)
+	}
+	if strings.Contains(out, "aws.instance.web") {
+		t.Errorf("Expected no changes to the instance, got:\n%s", out)
+	}
+
+	// A subnet's CIDR block is fixed, so changing it replaces the subnet
+	// and the instance launched into it, deleting the instance first
+	subnetCIDR = "10.0.2.0/24"
+	writeConfig(true)
+	out, err = runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply the replacement: %v\n%s", err, out)
+	}
+	want := []string{
+		"- aws.instance.web (replace: subnet_id)",
+		"- aws.subnet.public (replace: cidr_block)",
+		"+ aws.subnet.public (replace: cidr_block)",
+		"+ aws.instance.web (replace: subnet_id)",
+	}
+	last := -1
+	for _, line := range want {
+		i := strings.Index(out, line)
+		if i < 0 || i < last {
+			t.Errorf("Expected %q after the changes before it, got:\n%s", line, out)
+		}
+		last = i
+	}
+	if _, ok := ec2Server.Subnet(subnetRecord.ID); ok {
+		t.Errorf("Expected subnet %s to be replaced", subnetRecord.ID)
+	}
+	var running []awstest.Instance
+	for _, instance := range ec2Server.Instances() {
+		if instance.State != "terminated" {
+			running = append(running, instance)
+		}
+	}
+	if len(running) != 1 || running[0].ID == inst.ID {
+		t.Fatalf("Expected the instance to be replaced, got %+v", running)
+	}
+	inst = running[0]
+	if subnetRecord, ok = ec2Server.Subnet(inst.SubnetID); !ok || subnetRecord.CidrBlock != subnetCIDR {
+		t.Errorf("Expected the new instance in the new subnet, got %q", inst.SubnetID)
+	}
+
+	// Dropping everything but the VPC deletes the instance before the
+	// subnet and security group it uses
+	writeConfig(false)
+	out, err = runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply deletes: %v\n%s", err, out)
+	}
+	if _, ok := ec2Server.Subnet(subnetRecord.ID); ok {
+		t.Errorf("Expected subnet %s to be deleted", subnetRecord.ID)
+	}
+	if _, ok := ec2Server.SecurityGroup(inst.SecurityGroups[0]); ok {
+		t.Errorf("Expected security group %s to be deleted", inst.SecurityGroups[0])
+	}
+}
+
+const dataConfig = `
+function deploy_infrastructure()
+    return {
+        provider = "aws",
+        providers = {
+            aws = { region = "us-east-1", endpoint = %q },
+        },
+        data = {
+            { type = "ami", name = "ubuntu", config = { owners = { "099720109477" }, name = "ubuntu-*", most_recent = true } },
+            { type = "availability_zones", name = "all" },
+        },
+        resources = {
+            { type = "vpc", name = "main", config = { cidr_block = "10.0.0.0/16" } },
+            {
+                type = "subnet",
+                name = "a",
+                config = {
+                    vpc_id = duet.ref("aws.vpc.main"),
+                    cidr_block = "10.0.1.0/24",
+                    availability_zone = duet.data("aws.availability_zones.all", "names", 1),
+                },
+            },
+            {
+                type = "instance",
+                name = "web",
+                config = { ami = duet.data("aws.ami.ubuntu"), instance_type = "t3.micro", subnet_id = duet.ref("aws.subnet.a") },
+            },
+        },
+    }
+end
+`
+
+func TestApplyWithDataSources(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "aws-credentials"))
+	t.Setenv("DUET_WORKSPACE", "")
+
+	cloud, server := awstest.NewCloudServer()
+	defer server.Close()
+	cloud.EC2.AddImage(awstest.Image{ID: "ami-jammy", Name: "ubuntu-jammy", OwnerID: "099720109477", CreationDate: time.Now().AddDate(0, -1, 0)})
+	cloud.EC2.AddImage(awstest.Image{ID: "ami-noble", Name: "ubuntu-noble", OwnerID: "099720109477", CreationDate: time.Now()})
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	if err := os.WriteFile(configFile, []byte(fmt.Sprintf(dataConfig, server.URL)), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	out, err := runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	instances := cloud.EC2.Instances()
+	if len(instances) != 1 || instances[0].ImageID != "ami-noble" {
+		t.Fatalf("Expected one instance of the newest AMI, got %+v", instances)
+	}
+	subnet, _ := cloud.EC2.Subnet(instances[0].SubnetID)
+	if subnet.AvailabilityZone != "us-east-1a" {
+		t.Errorf("Expected the subnet in the first zone, got %q", subnet.AvailabilityZone)
+	}
+
+	out, err = runDuet(t, "--state", statePath, "plan", configFile)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Plan: 0 to create, 0 to update, 0 to destroy.") {
+		t.Errorf("Expected no changes on a second plan, got:\n%s", out)
+	}
+}
+
+const aliasConfig = `
+function deploy_infrastructure()
+    return {
+        provider = "aws",
+        providers = {
+            aws = {
+                { region = "us-east-1", endpoint = %[1]q },
+                {
+                    alias = "audit",
+                    region = "us-east-1",
+                    endpoint = %[1]q,
+                    assume_role = { role_arn = "arn:aws:iam::123456789012:role/audit", external_id = "duet" },
+                    default_tags = { account = "audit" },
+                },
+            },
+        },
+        data = {
+            { type = "caller_identity", name = "audit", alias = "audit" },
+        },
+        resources = {
+            { type = "vpc", name = "main", config = { cidr_block = "10.0.0.0/16" } },
+            {
+                type = "vpc",
+                name = "logs",
+                alias = "audit",
+                config = { cidr_block = "10.1.0.0/16", tags = { owner = duet.data("aws.caller_identity.audit", "arn") } },
+            },
+        },
+    }
+end
+`
+
+func TestApplyWithProviderAliases(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "aws-credentials"))
+	t.Setenv("DUET_WORKSPACE", "")
+
+	cloud, server := awstest.NewCloudServer()
+	defer server.Close()
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	if err := os.WriteFile(configFile, []byte(fmt.Sprintf(aliasConfig, server.URL)), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	out, err := runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "+ aws.vpc.logs (aws.audit)") {
+		t.Errorf("Expected the plan to show the aliased provider, got:\n%s", out)
+	}
+	if assumed := cloud.STS.AssumedRoles(); len(assumed) == 0 || assumed[0].ExternalID != "duet" {
+		t.Errorf("Expected the audit provider to assume its role, got %+v", assumed)
+	}
+
+	out, err = runDuet(t, "--state", statePath, "state", "list")
+	if err != nil {
+		t.Fatalf("Failed to list state: %v\n%s", err, out)
+	}
+	var logsID string
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 3 && fields[2] == "logs" {
+			logsID = fields[0]
+			if fields[3] != "aws.audit" {
+				t.Errorf("Expected aws.vpc.logs to be recorded against aws.audit, got %s", fields[3])
+			}
+		}
+	}
+	vpc, ok := cloud.EC2.VPC(logsID)
+	if !ok {
+		t.Fatalf("Expected VPC %q to exist, state:\n%s", logsID, out)
+	}
+	if vpc.Tags["account"] != "audit" || vpc.Tags["owner"] != "arn:aws:sts::123456789012:assumed-role/audit/duet" {
+		t.Errorf("Expected default tags and the assumed identity, got %v", vpc.Tags)
+	}
+	if vpc.Tags["duet:project"] != "infra" || vpc.Tags["duet:workspace"] != "default" || vpc.Tags["duet:address"] != "aws.vpc.logs" || vpc.Tags["duet:run-id"] == "" {
+		t.Errorf("Expected ownership tags, got %v", vpc.Tags)
+	}
+
+	out, err = runDuet(t, "--state", statePath, "plan", configFile)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Plan: 0 to create, 0 to update, 0 to destroy.") {
+		t.Errorf("Expected no changes on a second plan, got:\n%s", out)
+	}
+}
+
+const loadBalancerConfig = `
+function deploy_infrastructure()
+    local resources = {
+        { type = "vpc", name = "main", config = { cidr_block = "10.0.0.0/16" } },
+    }
+    if with_load_balancer then
+        for i, zone in ipairs({ "us-east-1a", "us-east-1b" }) do
+            table.insert(resources, {
+                type = "subnet",
+                name = "public_" .. i,
+                config = { vpc_id = duet.ref("aws.vpc.main"), cidr_block = "10.0." .. i .. ".0/24", availability_zone = zone },
+            })
+        end
+        table.insert(resources, {
+            type = "instance",
+            name = "web",
+            config = { ami = "ami-12345678", instance_type = "t3.micro", subnet_id = duet.ref("aws.subnet.public_1") },
+        })
+        table.insert(resources, {
+            type = "lb",
+            name = "web",
+            config = { name = "web", subnets = { duet.ref("aws.subnet.public_1"), duet.ref("aws.subnet.public_2") } },
+        })
+        table.insert(resources, {
+            type = "lb_target_group",
+            name = "web",
+            config = { name = "web", port = 8080, vpc_id = duet.ref("aws.vpc.main"), health_check = { path = "/healthz" } },
+        })
+        table.insert(resources, {
+            type = "lb_listener",
+            name = "http",
+            config = {
+                load_balancer_arn = duet.ref("aws.lb.web"),
+                port = 80,
+                default_action = { type = "forward", target_group_arn = duet.ref("aws.lb_target_group.web") },
+            },
+        })
+        table.insert(resources, {
+            type = "lb_target_group_attachment",
+            name = "web",
+            config = { target_group_arn = duet.ref("aws.lb_target_group.web"), target_id = duet.ref("aws.instance.web") },
+        })
+    end
+    return {
+        provider = "aws",
+        providers = {
+            aws = { region = "us-east-1", endpoint = %q },
+        },
+        resources = resources,
+    }
+end
+`
+
+func TestApplyLoadBalancer(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "aws-credentials"))
+	t.Setenv("DUET_WORKSPACE", "")
+
+	cloud, server := awstest.NewCloudServer()
+	defer server.Close()
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	writeConfig := func(withLoadBalancer bool) {
+		t.Helper()
+		content := fmt.Sprintf("with_load_balancer = %t\n", withLoadBalancer) + fmt.Sprintf(loadBalancerConfig, server.URL)
+		if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	writeConfig(true)
+	out, err := runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	instance := strings.Index(out, "+ aws.instance.web")
+	group := strings.Index(out, "+ aws.lb_target_group.web")
+	attachment := strings.Index(out, "+ aws.lb_target_group_attachment.web")
+	if instance < 0 || group < 0 || attachment < instance || attachment < group {
+		t.Errorf("Expected the attachment after the instance and target group in the plan, got:\n%s", out)
+	}
+
+	instances := cloud.EC2.Instances()
+	if len(instances) != 1 {
+		t.Fatalf("Expected one instance, got %+v", instances)
+	}
+	tg, ok := cloud.ELB.TargetGroup("web")
+	if !ok {
+		t.Fatal("Expected target group web to exist")
+	}
+	if len(tg.Targets) != 1 || tg.Targets[0].ID != instances[0].ID {
+		t.Errorf("Expected instance %s to be registered, got %+v", instances[0].ID, tg.Targets)
+	}
+	if tg.HealthCheck.Path != "/healthz" {
+		t.Errorf("Expected health check path /healthz, got %q", tg.HealthCheck.Path)
+	}
+	if listeners := cloud.ELB.Listeners("web"); len(listeners) != 1 || listeners[0].DefaultActions[0].TargetGroupArn != tg.Arn {
+		t.Errorf("Expected one listener forwarding to the target group, got %+v", listeners)
+	}
+
+	out, err = runDuet(t, "--state", statePath, "plan", configFile)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Plan: 0 to create, 0 to update, 0 to destroy.") {
+		t.Errorf("Expected no changes on a second plan, got:\n%s", out)
+	}
+
+	// Removing the load balancer deletes the listener before the target
+	// group it forwards to, which would otherwise still be in use
+	writeConfig(false)
+	out, err = runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply deletes: %v\n%s", err, out)
+	}
+	if _, ok := cloud.ELB.TargetGroup("web"); ok {
+		t.Error("Expected target group web to be deleted")
+	}
+	if _, ok := cloud.ELB.LoadBalancer("web"); ok {
+		t.Error("Expected load balancer web to be deleted")
+	}
+}
+
+const autoScalingConfig = `
+function deploy_infrastructure()
+    return {
+        provider = "aws",
+        providers = {
+            aws = { region = "us-east-1", endpoint = %q },
+        },
+        resources = {
+            { type = "vpc", name = "main", config = { cidr_block = "10.0.0.0/16" } },
+            {
+                type = "subnet",
+                name = "a",
+                config = { vpc_id = duet.ref("aws.vpc.main"), cidr_block = "10.0.1.0/24", availability_zone = "us-east-1a" },
+            },
+            {
+                type = "launch_template",
+                name = "web",
+                config = { name = "web", ami = "ami-12345678", instance_type = %q },
+            },
+            {
+                type = "autoscaling_group",
+                name = "web",
+                config = {
+                    name = "web",
+                    min_size = 1,
+                    max_size = 2,
+                    subnets = { duet.ref("aws.subnet.a") },
+                    launch_template = {
+                        id = duet.ref("aws.launch_template.web"),
+                        version = duet.ref("aws.launch_template.web", "latest_version"),
+                    },
+                    instance_refresh = { min_healthy_percentage = 50 },
+                    wait_for_capacity = false,
+                },
+            },
+        },
+    }
+end
+`
+
+func TestApplyAutoScalingGroup(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "aws-credentials"))
+	t.Setenv("DUET_WORKSPACE", "")
+
+	cloud, server := awstest.NewCloudServer()
+	defer server.Close()
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	writeConfig := func(instanceType string) {
+		t.Helper()
+		if err := os.WriteFile(configFile, []byte(fmt.Sprintf(autoScalingConfig, server.URL, instanceType)), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	writeConfig("t3.micro")
+	out, err := runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	group, ok := cloud.AutoScaling.Group("web")
+	if !ok || group.LaunchTemplateVersion != "1" || len(group.Instances) != 1 {
+		t.Fatalf("Expected group web on version 1 with one instance, got %+v", group)
+	}
+
+	// A new template version changes the group in the same apply, which
+	// starts an instance refresh onto it
+	writeConfig("t3.small")
+	out, err = runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply template change: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "~ aws.launch_template.web") || !strings.Contains(out, "~ aws.autoscaling_group.web") {
+		t.Errorf("Expected the template and group to be updated, got:\n%s", out)
+	}
+	group, _ = cloud.AutoScaling.Group("web")
+	if group.LaunchTemplateVersion != "2" {
+		t.Errorf("Expected the group to launch from version 2, got %q", group.LaunchTemplateVersion)
+	}
+	if refreshes := cloud.AutoScaling.InstanceRefreshes("web"); len(refreshes) != 1 {
+		t.Errorf("Expected one instance refresh, got %+v", refreshes)
+	}
+
+	out, err = runDuet(t, "--state", statePath, "plan", configFile)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Plan: 0 to create, 0 to update, 0 to destroy.") {
+		t.Errorf("Expected no changes on a second plan, got:\n%s", out)
+	}
+}
+
+const dnsConfig = `
+function deploy_infrastructure()
+    return {
+        provider = "aws",
+        providers = {
+            aws = { region = "us-east-1", endpoint = %q },
+        },
+        resources = {
+            { type = "vpc", name = "main", config = { cidr_block = "10.0.0.0/16" } },
+            {
+                type = "subnet",
+                name = "a",
+                config = { vpc_id = duet.ref("aws.vpc.main"), cidr_block = "10.0.1.0/24", availability_zone = "us-east-1a" },
+            },
+            {
+                type = "subnet",
+                name = "b",
+                config = { vpc_id = duet.ref("aws.vpc.main"), cidr_block = "10.0.2.0/24", availability_zone = "us-east-1b" },
+            },
+            {
+                type = "instance",
+                name = "web",
+                config = { ami = "ami-12345678", instance_type = "t3.micro", subnet_id = duet.ref("aws.subnet.a") },
+            },
+            {
+                type = "lb",
+                name = "web",
+                config = { name = "web", subnets = { duet.ref("aws.subnet.a"), duet.ref("aws.subnet.b") } },
+            },
+            { type = "route53_zone", name = "example", config = { name = "example.com" } },
+            {
+                type = "route53_record",
+                name = "web",
+                config = {
+                    zone_id = duet.ref("aws.route53_zone.example"),
+                    name = "web",
+                    type = "A",
+                    ttl = %d,
+                    records = { duet.ref("aws.instance.web", "private_ip") },
+                },
+            },
+            {
+                type = "route53_record",
+                name = "apex",
+                config = {
+                    zone_id = duet.ref("aws.route53_zone.example"),
+                    name = "example.com",
+                    type = "A",
+                    alias = {
+                        name = duet.ref("aws.lb.web", "dns_name"),
+                        zone_id = duet.ref("aws.lb.web", "zone_id"),
+                    },
+                },
+            },
+        },
+    }
+end
+`
+
+func TestApplyDNSRecords(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "aws-credentials"))
+	t.Setenv("DUET_WORKSPACE", "")
+
+	cloud, server := awstest.NewCloudServer()
+	defer server.Close()
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	writeConfig := func(ttl int) {
+		t.Helper()
+		if err := os.WriteFile(configFile, []byte(fmt.Sprintf(dnsConfig, server.URL, ttl)), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	writeConfig(300)
+	out, err := runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+
+	instances := cloud.EC2.Instances()
+	lb, _ := cloud.ELB.LoadBalancer("web")
+	if len(instances) != 1 {
+		t.Fatalf("Expected one instance, got %+v", instances)
+	}
+	zones := cloud.Route53.HostedZones()
+	if len(zones) != 1 || zones[0].Name != "example.com." {
+		t.Fatalf("Expected hosted zone example.com, got %+v", zones)
+	}
+	zoneID := zones[0].ID
+	records := map[string]awstest.RecordSet{}
+	for _, rs := range cloud.Route53.Records(zoneID) {
+		records[rs.Name+" "+rs.Type] = rs
+	}
+	if web := records["web.example.com. A"]; len(web.Values) != 1 || web.Values[0] != instances[0].PrivateIP {
+		t.Errorf("Expected web.example.com to hold the instance's private IP %s, got %+v", instances[0].PrivateIP, web)
+	}
+	if apex := records["example.com. A"]; apex.Alias == nil || apex.Alias.DNSName != strings.ToLower(lb.DNSName)+"." {
+		t.Errorf("Expected example.com to alias the load balancer %s, got %+v", lb.DNSName, apex)
+	}
+
+	out, err = runDuet(t, "--state", statePath, "plan", configFile)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Plan: 0 to create, 0 to update, 0 to destroy.") {
+		t.Errorf("Expected no changes on a second plan, got:\n%s", out)
+	}
+
+	// Each record is its own resource, so changing one leaves the others
+	writeConfig(60)
+	out, err = runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply TTL change: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "~ aws.route53_record.web") || strings.Contains(out, "aws.route53_record.apex") {
+		t.Errorf("Expected only the web record to be updated, got:\n%s", out)
+	}
+	for _, rs := range cloud.Route53.Records(zoneID) {
+		if rs.Name == "web.example.com." && rs.TTL != 60 {
+			t.Errorf("Expected a TTL of 60, got %d", rs.TTL)
+		}
+	}
+}
+
+const keyPairConfig = `
+function deploy_infrastructure()
+    return {
+        provider = "aws",
+        providers = {
+            aws = { region = "us-east-1", endpoint = %q },
+        },
+        resources = {
+            { type = "key_pair", name = "deployer", config = { key_name = "deployer", tags = { team = %q } } },
+            {
+                type = "instance",
+                name = "web",
+                config = {
+                    ami = "ami-12345678",
+                    instance_type = "t3.micro",
+                    key_name = duet.ref("aws.key_pair.deployer", "key_name"),
+                },
+            },
+        },
+    }
+end
+`
+
+func TestApplyGeneratedKeyPair(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "aws-credentials"))
+	t.Setenv("DUET_WORKSPACE", "")
+	t.Setenv("DUET_STATE_KEY", "")
+
+	cloud, server := awstest.NewCloudServer()
+	defer server.Close()
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	writeConfig := func(team string) {
+		t.Helper()
+		if err := os.WriteFile(configFile, []byte(fmt.Sprintf(keyPairConfig, server.URL, team)), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	// checkSSHConfig checks that the instance's SSH config carries the
+	// private key of the key pair it was launched with
+	checkSSHConfig := func() {
+		t.Helper()
+		store, err := state.NewStore(statePath)
+		if err != nil {
+			t.Fatalf("Failed to open state: %v", err)
+		}
+		store.SetKeySource(stateKey)
+		config, err := hosts.SSHConfig(context.Background(), store, "aws.instance.web", "ubuntu")
+		if err != nil {
+			t.Fatalf("Failed to build SSH config: %v", err)
+		}
+
+		instances := cloud.EC2.Instances()
+		if len(instances) != 1 || instances[0].KeyName != "deployer" {
+			t.Fatalf("Expected one instance launched with key pair deployer, got %+v", instances)
+		}
+		if config.Host != instances[0].PublicIP {
+			t.Errorf("Expected host %s, got %s", instances[0].PublicIP, config.Host)
+		}
+		signer, err := ssh.ParsePrivateKey([]byte(config.PrivateKey))
+		if err != nil {
+			t.Fatalf("Failed to parse private key: %v", err)
+		}
+		resources, err := store.GetResources(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get resources: %v", err)
+		}
+		var imported awstest.KeyPair
+		for _, r := range resources {
+			if r.Type == "key_pair" {
+				imported, _ = cloud.EC2.KeyPair(r.ID)
+			}
+		}
+		public := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+		if !strings.HasPrefix(imported.PublicKey, public) {
+			t.Errorf("Expected private key to match the imported key %q, got %q", imported.PublicKey, public)
+		}
+	}
+
+	writeConfig("ops")
+	out, err := runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	checkSSHConfig()
+
+	// The private key is only stored encrypted, with a key kept beside state
+	info, err := os.Stat(statePath + ".key")
+	if err != nil {
+		t.Fatalf("Expected a state key file: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected state key file mode 0600, got %v", info.Mode().Perm())
+	}
+	raw, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+	if bytes.Contains(raw, []byte("PRIVATE KEY")) {
+		t.Error("Expected no plaintext private key in state")
+	}
+
+	out, err = runDuet(t, "--state", statePath, "plan", configFile)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Plan: 0 to create, 0 to update, 0 to destroy.") {
+		t.Errorf("Expected no changes on a second plan, got:\n%s", out)
+	}
+
+	// Updating the key pair keeps its private key
+	writeConfig("platform")
+	out, err = runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply tag change: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "~ aws.key_pair.deployer") {
+		t.Errorf("Expected the key pair to be updated, got:\n%s", out)
+	}
+	checkSSHConfig()
+}
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "aws-credentials"))
+	t.Setenv("DUET_WORKSPACE", "")
+
+	_, server := awstest.NewCloudServer()
+	defer server.Close()
+
+	// Resources created by hand, outside duet
+	ctx := context.Background()
+	prov, err := awsprovider.NewAWSProvider(ctx, "us-east-1", awsprovider.WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	tags := map[string]interface{}{"team": "web", "Name": "main"}
+	vpc, err := prov.Create(ctx, "vpc", map[string]interface{}{"cidr_block": "10.0.0.0/16", "tags": tags})
+	if err != nil {
+		t.Fatalf("Failed to create VPC: %v", err)
+	}
+	group, err := prov.Create(ctx, "security_group", map[string]interface{}{
+		"name":    "web",
+		"vpc_id":  vpc.GetID(),
+		"ingress": []interface{}{map[string]interface{}{"protocol": "tcp", "from_port": 443, "cidr_blocks": []interface{}{"0.0.0.0/0"}}},
+		"tags":    tags,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create security group: %v", err)
+	}
+	if _, err := prov.Create(ctx, "instance", map[string]interface{}{
+		"ami":             "ami-12345678",
+		"instance_type":   "t3.micro",
+		"security_groups": []interface{}{group.GetID()},
+		"tags":            tags,
+	}); err != nil {
+		t.Fatalf("Failed to create instance: %v", err)
+	}
+	if _, err := prov.Create(ctx, "vpc", map[string]interface{}{"cidr_block": "10.1.0.0/16", "tags": map[string]interface{}{"team": "data"}}); err != nil {
+		t.Fatalf("Failed to create VPC: %v", err)
+	}
+	// And one a duet config created, whose state was lost
+	owned := provider.WithOwnership(ctx, provider.Ownership{Project: "recovered", Workspace: "default", Address: "aws.vpc.core"})
+	if _, err := prov.Create(owned, "vpc", map[string]interface{}{"cidr_block": "10.2.0.0/16", "tags": map[string]interface{}{"team": "web"}}); err != nil {
+		t.Fatalf("Failed to create VPC: %v", err)
+	}
+
+	statePath := filepath.Join(dir, "duet.db")
+	configFile := filepath.Join(dir, "discovered.lua")
+	out, err := runDuet(t, "--state", statePath, "discover", "--endpoint", server.URL, "--region", "us-east-1", "--tag", "team=web", "-o", configFile)
+	if err != nil {
+		t.Fatalf("Failed to discover: %v\n%s", err, out)
+	}
+	for _, address := range []string{"aws.vpc.main", "aws.security_group.main", "aws.instance.main"} {
+		if !strings.Contains(out, "+ "+address+" ") {
+			t.Errorf("Expected %s to be imported, got:\n%s", address, out)
+		}
+	}
+	if !strings.Contains(out, "Imported 3 resource(s)") {
+		t.Errorf("Expected only the web team's resources to be imported, got:\n%s", out)
+	}
+
+	content, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatalf("Failed to read generated config: %v", err)
+	}
+	if !strings.Contains(string(content), `vpc_id = duet.ref("aws.vpc.main")`) {
+		t.Errorf("Expected the security group to refer to the VPC, got:\n%s", content)
+	}
+
+	// The generated config matches what exists, so nothing changes
+	out, err = runDuet(t, "--state", statePath, "plan", configFile)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Plan: 0 to create, 0 to update, 0 to destroy.") {
+		t.Errorf("Expected no changes for the discovered resources, got:\n%s", out)
+	}
+
+	// Resources duet created are discovered for their project, under the
+	// address they were created with
+	recoveredFile := filepath.Join(dir, "recovered.lua")
+	out, err = runDuet(t, "--state", statePath, "discover", "--endpoint", server.URL, "--region", "us-east-1", "--tag", "team=web", "--project", "recovered", "-o", recoveredFile)
+	if err != nil {
+		t.Fatalf("Failed to discover: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "+ aws.vpc.core ") || !strings.Contains(out, "Imported 1 resource(s)") {
+		t.Errorf("Expected only the project's VPC to be imported, got:\n%s", out)
+	}
+
+	// Finding only resources another configuration owns is an error, not an
+	// empty import
+	if _, err := prov.Create(provider.WithOwnership(ctx, provider.Ownership{Project: "other", Workspace: "default", Address: "aws.vpc.ops"}), "vpc", map[string]interface{}{"cidr_block": "10.3.0.0/16", "tags": map[string]interface{}{"team": "ops"}}); err != nil {
+		t.Fatalf("Failed to create VPC: %v", err)
+	}
+	opsFile := filepath.Join(dir, "ops.lua")
+	out, err = runDuet(t, "--state", statePath, "discover", "--endpoint", server.URL, "--region", "us-east-1", "--tag", "team=ops", "--project", "", "-o", opsFile)
+	if err == nil || !strings.Contains(err.Error(), "found 1 resource(s), all carrying duet ownership tags") {
+		t.Errorf("Expected an error for resources owned elsewhere, got %v\n%s", err, out)
+	}
+	if _, err := os.Stat(opsFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no file after a failed discover, got %v", err)
+	}
+
+	// An existing file is never overwritten
+	if out, err := runDuet(t, "--state", statePath, "discover", "--endpoint", server.URL, "--region", "us-east-1", "-o", configFile); err == nil {
+		t.Errorf("Expected an error writing over an existing file, got:\n%s", out)
+	}
+
+	// A failed run records nothing and leaves no file behind
+	failedFile := filepath.Join(dir, "failed.lua")
+	if out, err := runDuet(t, "--state", statePath, "discover", "--endpoint", server.URL, "--region", "us-east-1", "--type", "vpc", "--type", "nonsense", "-o", failedFile); err == nil {
+		t.Errorf("Expected an error discovering an unknown type, got:\n%s", out)
+	}
+	if _, err := os.Stat(failedFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no file after a failed discover, got %v", err)
+	}
+	out, err = runDuet(t, "--state", statePath, "state", "list")
+	if err != nil {
+		t.Fatalf("Failed to list state: %v\n%s", err, out)
+	}
+	vpcs := 0
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[1] == "vpc" {
+			vpcs++
+		}
+	}
+	if vpcs != 2 {
+		t.Errorf("Expected only the discovered VPCs in state, got:\n%s", out)
+	}
+}
+
+const pluginConfig = `
+function deploy_infrastructure()
+    return {
+        provider = "fake",
+        providers = {
+            fake = { prefix = "thing" },
+        },
+        resources = {
+            { type = "thing", name = "a", config = { size = %s } },
+        },
+    }
+end
+`
+
+func TestApplyWithPlugin(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DUET_WORKSPACE", "")
+
+	// The test binary serves the plugin when started by duet
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Failed to find test binary: %v", err)
+	}
+	pluginDir := filepath.Join(dir, "plugins")
+	if err := os.Mkdir(pluginDir, 0o755); err != nil {
+		t.Fatalf("Failed to create plugin directory: %v", err)
+	}
+	if err := os.Symlink(exe, filepath.Join(pluginDir, plugin.BinaryPrefix+"fake")); err != nil {
+		t.Fatalf("Failed to install plugin: %v", err)
+	}
+	t.Setenv(plugin.EnvPath, pluginDir)
+	t.Setenv(envServePlugin, "1")
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	writeConfig := func(size string) {
+		t.Helper()
+		if err := os.WriteFile(configFile, []byte(fmt.Sprintf(pluginConfig, size)), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	writeConfig("3")
+	out, err := runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "+ fake.thing.a") {
+		t.Errorf("Expected the plugin's resource to be created, got:\n%s", out)
+	}
+
+	store, err := state.NewStore(statePath)
+	if err != nil {
+		t.Fatalf("Failed to open state: %v", err)
+	}
+	if _, err := store.GetResource(context.Background(), "thing-3"); err != nil {
+		t.Errorf("Expected the plugin's resource in state: %v", err)
+	}
+
+	out, err = runDuet(t, "--state", statePath, "plan", configFile)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Plan: 0 to create, 0 to update, 0 to destroy.") {
+		t.Errorf("Expected no changes on a second plan, got:\n%s", out)
+	}
+
+	// The plugin checks configs before anything is planned
+	writeConfig(`"big"`)
+	out, err = runDuet(t, "--state", statePath, "plan", configFile)
+	if err == nil || !strings.Contains(err.Error(), "resource fake.thing.a: size: expected a whole number, got big") {
+		t.Errorf("Expected the plugin to reject the config, got %v\n%s", err, out)
+	}
+
+	t.Setenv(plugin.EnvPath, t.TempDir())
+	writeConfig("3")
+	if _, err := runDuet(t, "--state", statePath, "plan", configFile); err == nil || !strings.Contains(err.Error(), "no duet-provider-fake in") {
+		t.Errorf("Expected an error naming the missing plugin, got %v", err)
+	}
+}
+
+const localConfig = `
+function deploy_infrastructure()
+    return {
+        provider = "local",
+        providers = {
+            ["local"] = { root = %q },
+        },
+        resources = {
+            { type = "directory", name = "out", config = { path = "out", mode = "0750" } },
+            {
+                type = "file",
+                name = "hosts",
+                config = {
+                    path = duet.ref("local.directory.out", "path") .. "/hosts.ini",
+                    content = %q,
+                    mode = "0600",
+                },
+            },
+            { type = "symlink", name = "current", config = { path = "current", target = "out/hosts.ini" } },
+        },
+    }
+end
+`
+
+func TestApplyLocalFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DUET_WORKSPACE", "")
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	root := filepath.Join(dir, "root")
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(configFile, []byte(fmt.Sprintf(localConfig, root, content)), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	writeConfig("[web]\n10.0.0.1\n")
+	out, err := runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	content, err := os.ReadFile(filepath.Join(root, "current"))
+	if err != nil || string(content) != "[web]\n10.0.0.1\n" {
+		t.Errorf("Expected the inventory through the symlink, got %q, %v", content, err)
+	}
+	if info, err := os.Stat(filepath.Join(root, "out")); err != nil || info.Mode().Perm() != 0o750 {
+		t.Errorf("Expected the directory with mode 0750, got %v, %v", info, err)
+	}
+
+	out, err = runDuet(t, "--state", statePath, "plan", configFile)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Plan: 0 to create, 0 to update, 0 to destroy.") {
+		t.Errorf("Expected no changes on a second plan, got:\n%s", out)
+	}
+
+	writeConfig("[web]\n10.0.0.2\n")
+	out, err = runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "~ local.file.hosts") {
+		t.Errorf("Expected the file to be updated, got:\n%s", out)
+	}
+	content, _ = os.ReadFile(filepath.Join(root, "out", "hosts.ini"))
+	if string(content) != "[web]\n10.0.0.2\n" {
+		t.Errorf("Expected the new content, got %q", content)
+	}
+
+	// Files edited or removed by hand are written again
+	if err := os.WriteFile(filepath.Join(root, "out", "hosts.ini"), []byte("edited\n"), 0o600); err != nil {
+		t.Fatalf("Failed to edit file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(root, "current")); err != nil {
+		t.Fatalf("Failed to remove symlink: %v", err)
+	}
+	out, err = runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "~ local.file.hosts") || !strings.Contains(out, "+ local.symlink.current") {
+		t.Errorf("Expected the drifted file to be updated and the link created, got:\n%s", out)
+	}
+	content, _ = os.ReadFile(filepath.Join(root, "current"))
+	if string(content) != "[web]\n10.0.0.2\n" {
+		t.Errorf("Expected the content restored through the symlink, got %q", content)
+	}
+	out, err = runDuet(t, "--state", statePath, "plan", configFile)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Plan: 0 to create, 0 to update, 0 to destroy.") {
+		t.Errorf("Expected no changes after restoring drift, got:\n%s", out)
+	}
+}
+
+const generatedConfig = `
+function deploy_infrastructure()
+    return {
+        provider = "tls",
+        providers = {
+            random = {},
+            tls = {},
+            ["local"] = { root = %q },
+        },
+        resources = {
+            { type = "password", name = "db", provider = "random", config = { length = 20 } },
+            { type = "private_key", name = "ca", config = { algorithm = "ecdsa", keepers = { generation = %q } } },
+            {
+                type = "self_signed_cert",
+                name = "ca",
+                config = {
+                    private_key_id = duet.ref("tls.private_key.ca", "id"),
+                    subject = { common_name = "ca.internal" },
+                    is_ca_certificate = true,
+                    allowed_uses = { "cert_signing", "crl_signing" },
+                    keepers = { key = duet.ref("tls.private_key.ca", "public_key_fingerprint_sha256") },
+                },
+            },
+            {
+                type = "file",
+                name = "ca",
+                provider = "local",
+                config = { path = "ca.pem", content = duet.ref("tls.self_signed_cert.ca", "cert_pem") },
+            },
+            {
+                type = "file",
+                name = "env",
+                provider = "local",
+                config = { path = "db.env", content = "DB_PASSWORD=${random.password.db.result}\n", mode = "0600" },
+            },
+        },
+    }
+end
+`
+
+func TestApplyGeneratedValues(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DUET_WORKSPACE", "")
+	t.Setenv("DUET_STATE_KEY", "")
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	root := filepath.Join(dir, "root")
+	writeConfig := func(generation string) {
+		t.Helper()
+		if err := os.WriteFile(configFile, []byte(fmt.Sprintf(generatedConfig, root, generation)), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	// readCert parses the certificate written out by the local provider
+	readCert := func() *x509.Certificate {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(root, "ca.pem"))
+		if err != nil {
+			t.Fatalf("Failed to read certificate: %v", err)
+		}
+		block, _ := pem.Decode(content)
+		if block == nil {
+			t.Fatalf("Expected a PEM encoded certificate, got %q", content)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("Failed to parse certificate: %v", err)
+		}
+		return cert
+	}
+
+	// password returns the generated password, opened from state
+	password := func() string {
+		t.Helper()
+		store, err := state.NewStore(statePath)
+		if err != nil {
+			t.Fatalf("Failed to open state: %v", err)
+		}
+		store.SetKeySource(stateKey)
+		resources, err := store.GetResources(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get resources: %v", err)
+		}
+		for _, r := range resources {
+			if r.Type == "password" {
+				secrets, err := store.OpenSecrets(context.Background(), &r)
+				if err != nil {
+					t.Fatalf("Failed to open secrets: %v", err)
+				}
+				result, _ := secrets["result"].(string)
+				return result
+			}
+		}
+		t.Fatal("Expected a password in state")
+		return ""
+	}
+
+	writeConfig("1")
+	out, err := runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	cert := readCert()
+	if cert.Subject.CommonName != "ca.internal" || !cert.IsCA {
+		t.Errorf("Expected a CA certificate for ca.internal, got %v", cert.Subject)
+	}
+	secret := password()
+	if len(secret) != 20 {
+		t.Errorf("Expected a password of 20 characters, got %q", secret)
+	}
+	raw, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+	if bytes.Contains(raw, []byte("PRIVATE KEY")) || bytes.Contains(raw, []byte(secret)) {
+		t.Error("Expected no plaintext private key or password in state")
+	}
+	if strings.Contains(out, secret) {
+		t.Errorf("Expected the password to be kept out of the output, got:\n%s", out)
+	}
+
+	// The sensitive password is filled in for the provider only
+	env, err := os.ReadFile(filepath.Join(root, "db.env"))
+	if err != nil {
+		t.Fatalf("Failed to read env file: %v", err)
+	}
+	if string(env) != "DB_PASSWORD="+secret+"\n" {
+		t.Errorf("Expected the password in the env file, got %q", env)
+	}
+
+	out, err = runDuet(t, "--state", statePath, "plan", configFile)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Plan: 0 to create, 0 to update, 0 to destroy.") {
+		t.Errorf("Expected no changes on a second plan, got:\n%s", out)
+	}
+
+	// A new key reissues the certificate signed with it, and the password
+	// is kept
+	writeConfig("2")
+	out, err = runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	for _, address := range []string{"~ tls.private_key.ca", "~ tls.self_signed_cert.ca", "~ local.file.ca"} {
+		if !strings.Contains(out, address) {
+			t.Errorf("Expected %s in the plan, got:\n%s", address, out)
+		}
+	}
+	reissued := readCert()
+	if bytes.Equal(reissued.RawSubjectPublicKeyInfo, cert.RawSubjectPublicKeyInfo) {
+		t.Error("Expected the certificate to be reissued for the new key")
+	}
+	if got := password(); got != secret {
+		t.Errorf("Expected the password to be kept, got %q", got)
+	}
+}
+
+func TestWorkspaceDeleteRecordsForgottenResources(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DUET_WORKSPACE", "")
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	if err := os.WriteFile(configFile, []byte(fmt.Sprintf(localConfig, filepath.Join(dir, "root"), "[web]\n")), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	if out, err := runDuet(t, "--state", statePath, "workspace", "new", "staging"); err != nil {
+		t.Fatalf("Failed to create workspace: %v\n%s", err, out)
+	}
+	if out, err := runDuet(t, "--state", statePath, "apply", configFile); err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	if out, err := runDuet(t, "--state", statePath, "workspace", "select", "default"); err != nil {
+		t.Fatalf("Failed to select workspace: %v\n%s", err, out)
+	}
+
+	if out, err := runDuet(t, "--state", statePath, "workspace", "delete", "--force=false", "staging"); err == nil {
+		t.Fatalf("Expected deleting a workspace with resources to fail, got:\n%s", out)
+	}
+	out, err := runDuet(t, "--state", statePath, "workspace", "delete", "--force", "staging")
+	if err != nil {
+		t.Fatalf("Failed to delete workspace: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Forgot 3 resource(s)") {
+		t.Errorf("Expected the forgotten resources to be reported, got:\n%s", out)
+	}
+
+	out, err = runDuet(t, "--state", statePath, "history", "--json=false")
+	if err != nil {
+		t.Fatalf("Failed to show history: %v\n%s", err, out)
+	}
+	var runID string
+	for _, line := range strings.Split(out, "\n") {
+		// Newest first, so the forced deletion comes before the refused one
+		if fields := strings.Fields(line); runID == "" && len(fields) > 0 && strings.Contains(line, "workspace delete staging") {
+			runID = fields[0]
+		}
+	}
+	if runID == "" {
+		t.Fatalf("Expected the deletions to be in the history, got:\n%s", out)
+	}
+
+	out, err = runDuet(t, "--state", statePath, "history", "show", runID)
+	if err != nil {
+		t.Fatalf("Failed to show run: %v\n%s", err, out)
+	}
+	forgotten := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 3 && fields[0] == "forget" && fields[1] == "local" {
+			forgotten[fields[2]] = true
+		}
+	}
+	for _, resourceType := range []string{"directory", "file", "symlink"} {
+		if !forgotten[resourceType] {
+			t.Errorf("Expected the %s to be recorded as forgotten, got:\n%s", resourceType, out)
+		}
+	}
+}
+
+func TestStateIntents(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DUET_WORKSPACE", "")
+	statePath := filepath.Join(dir, "duet.db")
+
+	store, err := state.NewStore(statePath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	intent := &state.Intent{RunID: "r1", Action: state.IntentCreate, Provider: "local", Type: "file", Name: "f", Token: "r1-local.file.f"}
+	if err := store.BeginIntent(context.Background(), intent); err != nil {
+		t.Fatalf("Failed to journal intent: %v", err)
+	}
+
+	out, err := runDuet(t, "--state", statePath, "state", "intents", "list")
+	if err != nil {
+		t.Fatalf("Failed to list intents: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "local.file.f") || !strings.Contains(out, "r1") {
+		t.Errorf("Expected the unfinished create to be listed, got:\n%s", out)
+	}
+
+	for _, arg := range []string{"x", "99"} {
+		if out, err := runDuet(t, "--state", statePath, "state", "intents", "abandon", fmt.Sprint(intent.ID), arg); err == nil {
+			t.Errorf("Expected abandoning %q to fail, got:\n%s", arg, out)
+		}
+	}
+	if pending, _ := store.PendingIntents(context.Background()); len(pending) != 1 {
+		t.Fatalf("Expected a failed abandon to leave the journal alone, got %d entries", len(pending))
+	}
+
+	out, err = runDuet(t, "--state", statePath, "state", "intents", "abandon", fmt.Sprint(intent.ID))
+	if err != nil {
+		t.Fatalf("Failed to abandon intent: %v\n%s", err, out)
+	}
+	out, err = runDuet(t, "--state", statePath, "state", "intents", "list")
+	if err != nil || !strings.Contains(out, "No unfinished changes") {
+		t.Errorf("Expected the journal to be empty, got %v:\n%s", err, out)
+	}
+
+	ops, err := store.ListOperations(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Failed to list operations: %v", err)
+	}
+	var abandoned bool
+	for _, op := range ops {
+		if op.Command == "state intents abandon" && op.Outcome == state.OutcomeSucceeded {
+			abandoned = len(op.Changes) == 1 && op.Changes[0].Action == "abandon-create"
+		}
+	}
+	if !abandoned {
+		t.Errorf("Expected the abandon to be recorded in the history, got %+v", ops)
+	}
+}
+
+func TestWorkspaceOverrideNotYetCreated(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "duet.db")
+	t.Setenv("DUET_WORKSPACE", "staging")
+
+	if out, err := runDuet(t, "--state", statePath, "state", "list"); err == nil {
+		t.Fatalf("Expected a missing override workspace to be refused, got:\n%s", out)
+	}
+
+	out, err := runDuet(t, "--state", statePath, "workspace", "new", "staging")
+	if err != nil {
+		t.Fatalf("Failed to create the override workspace: %v\n%s", err, out)
+	}
+	out, err = runDuet(t, "--state", statePath, "workspace", "list")
+	if err != nil {
+		t.Fatalf("Failed to list workspaces: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "* staging") {
+		t.Errorf("Expected staging to be current, got:\n%s", out)
+	}
+	if out, err := runDuet(t, "--state", statePath, "state", "list"); err != nil {
+		t.Errorf("Expected the override workspace to be usable once created, got %v:\n%s", err, out)
+	}
+}
+
+const configureSSHConfig = `
+function deploy_infrastructure()
+    return {
+        provider = "aws",
+        providers = {
+            aws = { region = "us-east-1", endpoint = %q },
+        },
+        resources = {
+            { type = "key_pair", name = "deployer", config = { key_name = "deployer" } },
+            {
+                type = "instance",
+                name = "web",
+                config = {
+                    ami = "ami-12345678",
+                    instance_type = "t3.micro",
+                    key_name = duet.ref("aws.key_pair.deployer", "key_name"),
+                },
+            },
+        },
+        hosts = {
+            { address = "aws.instance.web", user = "ubuntu" },
+        },
+    }
+end
+
+function configure_instance(host)
+    local ok, err = install_package("cowsay", host)
+    if not ok then
+        error("Failed to install cowsay: " .. err)
+    end
+    return true
+end
+`
+
+// sshServer runs no commands: it accepts any key, records each command it
+// is asked to run with the user and key that asked, and reports success
+type sshServer struct {
+	listener net.Listener
+	commands []sshCommand
+	mu       sync.Mutex
+}
+
+type sshCommand struct {
+	User    string
+	Key     string
+	Command string
+}
+
+func newSSHServer(t *testing.T) *sshServer {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("Failed to create host key signer: %v", err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return &ssh.Permissions{Extensions: map[string]string{"key": strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))}}, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &sshServer{listener: listener}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *sshServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	server, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer func() { _ = channel.Close() }()
+			for req := range requests {
+				var exec struct{ Command string }
+				if req.Type != "exec" || ssh.Unmarshal(req.Payload, &exec) != nil {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				_ = req.Reply(true, nil)
+				s.mu.Lock()
+				s.commands = append(s.commands, sshCommand{User: server.User(), Key: server.Permissions.Extensions["key"], Command: exec.Command})
+				s.mu.Unlock()
+				_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				return
+			}
+		}()
+	}
+}
+
+func (s *sshServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *sshServer) Commands() []sshCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]sshCommand(nil), s.commands...)
+}
+
+func TestConfigureHostsOverSSH(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "aws-credentials"))
+	t.Setenv("DUET_WORKSPACE", "")
+	t.Setenv("DUET_STATE_KEY", "")
+
+	cloud, server := awstest.NewCloudServer()
+	defer server.Close()
+	sshd := newSSHServer(t)
+	// The instance's public address leads to the test server
+	cloud.EC2.SetPublicIP("127.0.0.1")
+	connectOptions.SSH.Port = sshd.port()
+	t.Cleanup(func() { connectOptions = hosts.ConnectOptions{} })
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	if err := os.WriteFile(configFile, []byte(fmt.Sprintf(configureSSHConfig, server.URL)), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	out, err := runDuet(t, "--state", statePath, "plan", configFile)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "* aws.instance.web over ssh") {
+		t.Errorf("Expected the host in the plan, got:\n%s", out)
+	}
+
+	out, err = runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Configured aws.instance.web over ssh") {
+		t.Errorf("Expected the host to be configured, got:\n%s", out)
+	}
+
+	// The command ran as the host's user with the generated key pair
+	commands := sshd.Commands()
+	if len(commands) != 1 || commands[0].Command != "apt-get install -y cowsay" || commands[0].User != "ubuntu" {
+		t.Fatalf("Unexpected commands: %+v", commands)
+	}
+	store, err := state.NewStore(statePath)
+	if err != nil {
+		t.Fatalf("Failed to open state: %v", err)
+	}
+	keyPairs, err := store.Query().Type("key_pair").Find(context.Background())
+	if err != nil || len(keyPairs.Resources) != 1 {
+		t.Fatalf("Expected one key pair in state, got %v, %v", keyPairs, err)
+	}
+	imported, _ := cloud.EC2.KeyPair(keyPairs.Resources[0].ID)
+	if !strings.HasPrefix(imported.PublicKey, commands[0].Key) {
+		t.Errorf("Expected the key of the generated key pair %q, got %q", imported.PublicKey, commands[0].Key)
+	}
+
+	configured, err := store.Query().Type("instance").ConfigApplied(true).Find(context.Background())
+	if err != nil || len(configured.Resources) != 1 {
+		t.Errorf("Expected the instance to be recorded as configured, got %v, %v", configured, err)
+	}
+
+	// Hosts are configured again on every apply
+	out, err = runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	if len(sshd.Commands()) != 2 || !strings.Contains(out, "Apply complete: 1 change(s)") {
+		t.Errorf("Expected only the host to be configured again, got %d commands:\n%s", len(sshd.Commands()), out)
+	}
+}
+
+const configureSSMConfig = `
+function deploy_infrastructure()
+    return {
+        provider = "aws",
+        providers = {
+            aws = { region = "us-east-1", endpoint = %q },
+        },
+        resources = {
+            { type = "instance", name = "worker", config = { ami = "ami-12345678", instance_type = "t3.micro" } },
+        },
+        hosts = {
+            { address = "aws.instance.worker", transport = "ssm" },
+        },
+    }
+end
+
+function configure_instance(host)
+    local ok, err = install_package(%q, host)
+    if not ok then
+        error("Failed to install: " .. err)
+    end
+    local out, err = run_command("hostname", host)
+    return out == "worker\n"
+end
+`
+
+func TestConfigureHostsOverSSM(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "aws-credentials"))
+	t.Setenv("DUET_WORKSPACE", "")
+
+	cloud, server := awstest.NewCloudServer()
+	defer server.Close()
+	cloud.SSM.SetHandler(func(instanceID, command string) awstest.CommandResult {
+		switch command {
+		case "hostname":
+			return awstest.CommandResult{Stdout: "worker\n"}
+		case "apt-get install -y missing":
+			return awstest.CommandResult{Stderr: "E: Unable to locate package missing", ExitCode: 100}
+		}
+		return awstest.CommandResult{}
+	})
+	connectOptions.SSM.PollInterval = time.Millisecond
+	t.Cleanup(func() { connectOptions = hosts.ConnectOptions{} })
+
+	configFile := filepath.Join(dir, "infra.lua")
+	statePath := filepath.Join(dir, "duet.db")
+	writeConfig := func(pkg string) {
+		t.Helper()
+		if err := os.WriteFile(configFile, []byte(fmt.Sprintf(configureSSMConfig, server.URL, pkg)), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	writeConfig("cowsay")
+	out, err := runDuet(t, "--state", statePath, "apply", configFile)
+	if err != nil {
+		t.Fatalf("Failed to apply: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Configured aws.instance.worker over ssm") {
+		t.Errorf("Expected the host to be configured, got:\n%s", out)
+	}
+
+	// Commands went through SSM to the instance created in the same run
+	instances := cloud.EC2.Instances()
+	commands := cloud.SSM.Commands()
+	if len(instances) != 1 || len(commands) != 2 {
+		t.Fatalf("Expected two commands to one instance, got %+v, %+v", instances, commands)
+	}
+	for i, want := range []string{"apt-get install -y cowsay", "hostname"} {
+		if commands[i].InstanceID != instances[0].ID || commands[i].Commands[0] != want {
+			t.Errorf("Expected %q on %s, got %+v", want, instances[0].ID, commands[i])
+		}
+	}
+
+	// A failed command fails the apply and is recorded against the host
+	writeConfig("missing")
+	out, err = runDuet(t, "--state", statePath, "apply", configFile)
+	if err == nil || !strings.Contains(err.Error(), "Unable to locate package missing") {
+		t.Fatalf("Expected the failed install to fail the apply, got %v:\n%s", err, out)
+	}
+	store, err := state.NewStore(statePath)
+	if err != nil {
+		t.Fatalf("Failed to open state: %v", err)
+	}
+	ops, err := store.ListOperations(context.Background(), 1)
+	if err != nil || len(ops) != 1 {
+		t.Fatalf("Failed to list operations: %v", err)
+	}
+	changes := ops[0].Changes
+	if len(changes) != 1 || changes[0].Action != "configure" || changes[0].ResourceID != instances[0].ID || changes[0].Error == "" {
+		t.Errorf("Expected the failed configure to be recorded, got %+v", changes)
+	}
+}
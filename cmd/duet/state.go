@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/planner"
+)
+
+var migrateDryRun bool
+
+var listFlags struct {
+	provider      string
+	resourceType  string
+	status        string
+	sortBy        string
+	after         string
+	tags          []string
+	limit         int
+	configApplied bool
+	descending    bool
+}
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Inspect and manage the state database",
+}
+
+var stateMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending state schema migrations",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleStateMigrate(cmd, migrateDryRun)
+	},
+}
+
+var stateListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List resources recorded in state",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleStateList(cmd)
+	},
+}
+
+var stateIntentsCmd = &cobra.Command{
+	Use:   "intents",
+	Short: "Inspect changes left unfinished by interrupted runs",
+	Long: `Every provider call is journaled before it is made and cleared once its
+result is recorded in state. Entries that remain were left by runs that
+stopped in between, and are reconciled by the next apply. When reconciling
+cannot tell what happened, check the resource by hand and abandon the entry.`,
+}
+
+var stateIntentsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List journal entries left by interrupted runs",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleStateIntentsList(cmd)
+	},
+}
+
+var stateIntentsAbandonCmd = &cobra.Command{
+	Use:   "abandon <id>...",
+	Short: "Clear journal entries without changing state",
+	Long: `Clear journal entries without changing state. An abandoned create is
+forgotten, so the next apply creates the resource again; import or delete
+anything the interrupted run did create first. An abandoned update or delete
+leaves the recorded resource as it was.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleStateIntentsAbandon(cmd, args)
+	},
+}
+
+func init() {
+	stateListCmd.Flags().StringVar(&listFlags.provider, "provider", "", "only list resources from this provider")
+	stateListCmd.Flags().StringVar(&listFlags.resourceType, "type", "", "only list resources of this type")
+	stateListCmd.Flags().StringVar(&listFlags.status, "status", "", "only list resources in this status")
+	stateListCmd.Flags().StringArrayVar(&listFlags.tags, "tag", nil, "only list resources with this tag, as key=value or key (repeatable)")
+	stateListCmd.Flags().BoolVar(&listFlags.configApplied, "config-applied", false, "only list resources whose configuration has (true) or has not (false) been applied")
+	stateListCmd.Flags().StringVar(&listFlags.sortBy, "sort", "id", "sort by id, name, type, provider, status or last_updated")
+	stateListCmd.Flags().BoolVar(&listFlags.descending, "desc", false, "sort in descending order")
+	stateListCmd.Flags().IntVar(&listFlags.limit, "limit", 50, "maximum resources per page (0 for all)")
+	stateListCmd.Flags().StringVar(&listFlags.after, "after", "", "cursor from a previous page")
+
+	stateMigrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "show pending migrations without applying them")
+
+	stateIntentsCmd.AddCommand(stateIntentsListCmd)
+	stateIntentsCmd.AddCommand(stateIntentsAbandonCmd)
+
+	stateCmd.AddCommand(stateListCmd)
+	stateCmd.AddCommand(stateMigrateCmd)
+	stateCmd.AddCommand(stateIntentsCmd)
+}
+
+func handleStateList(cmd *cobra.Command) error {
+	ctx := context.Background()
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	sortBy, err := state.ParseSortField(listFlags.sortBy)
+	if err != nil {
+		return err
+	}
+
+	query := store.Query().
+		Provider(listFlags.provider).
+		Type(listFlags.resourceType).
+		Status(listFlags.status).
+		OrderBy(sortBy, listFlags.descending).
+		Limit(listFlags.limit).
+		After(listFlags.after)
+
+	for _, tag := range listFlags.tags {
+		if key, value, ok := strings.Cut(tag, "="); ok {
+			query.Tag(key, value)
+		} else {
+			query.HasTag(tag)
+		}
+	}
+	if cmd.Flags().Changed("config-applied") {
+		query.ConfigApplied(listFlags.configApplied)
+	}
+
+	page, err := query.Find(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTYPE\tNAME\tPROVIDER\tSTATUS\tCONFIGURED\tLAST UPDATED")
+	for _, r := range page.Resources {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%s\n", r.ID, r.Type, r.Name, planner.ProviderKey(r.Provider, r.Alias), r.Status, r.ConfigApplied, r.LastUpdated)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if page.NextCursor != "" {
+		fmt.Fprintf(cmd.OutOrStdout(), "\nMore results available: --after %s\n", page.NextCursor)
+	}
+	return nil
+}
+
+func handleStateMigrate(cmd *cobra.Command, dryRun bool) error {
+	ctx := context.Background()
+
+	// Open without migrating so a dry run leaves the database untouched
+	store, err := state.Open(stateFile)
+	if err != nil {
+		return fmt.Errorf("failed to open state: %w", err)
+	}
+
+	current, err := store.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	pending, err := store.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Schema version: %d (latest %d)\n", current, state.LatestSchemaVersion())
+	if len(pending) == 0 {
+		fmt.Fprintln(out, "No pending migrations")
+		return nil
+	}
+
+	for _, m := range pending {
+		fmt.Fprintf(out, "  %04d  %s\n", m.Version, m.Name)
+	}
+
+	if dryRun {
+		fmt.Fprintf(out, "%d migration(s) pending; run without --dry-run to apply\n", len(pending))
+		return nil
+	}
+
+	if err := store.Migrate(ctx); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Applied %d migration(s)\n", len(pending))
+	return nil
+}
+
+func handleStateIntentsList(cmd *cobra.Command) error {
+	ctx := context.Background()
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	intents, err := store.PendingIntents(ctx)
+	if err != nil {
+		return err
+	}
+	if len(intents) == 0 {
+		fmt.Fprintln(cmd.OutOrStdout(), "No unfinished changes")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tRUN ID\tACTION\tRESOURCE\tRESOURCE ID\tSTARTED")
+	for _, i := range intents {
+		address := planner.ProviderKey(i.Provider, i.Alias) + "." + i.Type + "." + i.Name
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", i.ID, i.RunID, i.Action, address, i.ResourceID, i.CreatedAt.Local().Format(time.DateTime))
+	}
+	return w.Flush()
+}
+
+func handleStateIntentsAbandon(cmd *cobra.Command, ids []string) (err error) {
+	ctx := context.Background()
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	op := newOperation("state intents abandon", "")
+	defer func() {
+		err = recordOperation(ctx, store, op, err)
+	}()
+
+	intents, err := store.PendingIntents(ctx)
+	if err != nil {
+		return err
+	}
+	byID := make(map[uint]*state.Intent, len(intents))
+	for i := range intents {
+		byID[intents[i].ID] = &intents[i]
+	}
+
+	// Check every ID before clearing any, so a typo abandons nothing
+	var abandon []*state.Intent
+	for _, arg := range ids {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid journal entry ID %q", arg)
+		}
+		intent, ok := byID[uint(id)]
+		if !ok {
+			return fmt.Errorf("no journal entry %d in workspace %s", id, store.Workspace())
+		}
+		abandon = append(abandon, intent)
+	}
+
+	out := cmd.OutOrStdout()
+	for _, intent := range abandon {
+		if err := store.AbandonIntent(ctx, intent); err != nil {
+			return err
+		}
+		op.Changes = append(op.Changes, state.OperationChange{
+			ResourceID: intent.ResourceID,
+			Type:       intent.Type,
+			Name:       intent.Name,
+			Provider:   intent.Provider,
+			Action:     "abandon-" + intent.Action,
+		})
+		fmt.Fprintf(out, "Abandoned %s of %s.%s.%s from run %s\n", intent.Action, intent.Provider, intent.Type, intent.Name, intent.RunID)
+	}
+	return nil
+}
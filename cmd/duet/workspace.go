@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+)
+
+var workspaceDeleteForce bool
+
+var workspaceCmd = &cobra.Command{
+	Use:   "workspace",
+	Short: "Manage workspaces, isolated state for each environment",
+}
+
+var workspaceNewCmd = &cobra.Command{
+	Use:   "new <name>",
+	Short: "Create a workspace and select it",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleWorkspaceNew(cmd, args[0])
+	},
+}
+
+var workspaceSelectCmd = &cobra.Command{
+	Use:   "select <name>",
+	Short: "Select the workspace later commands use",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleWorkspaceSelect(cmd, args[0])
+	},
+}
+
+var workspaceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List workspaces, marking the current one",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleWorkspaceList(cmd)
+	},
+}
+
+var workspaceDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a workspace",
+	Long: `Delete a workspace. Workspaces that still track resources are refused
+unless --force is given, in which case their state is forgotten but the
+resources themselves are left running.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleWorkspaceDelete(cmd, args[0], workspaceDeleteForce)
+	},
+}
+
+func init() {
+	workspaceDeleteCmd.Flags().BoolVar(&workspaceDeleteForce, "force", false, "delete even if the workspace still tracks resources")
+
+	workspaceCmd.AddCommand(workspaceNewCmd)
+	workspaceCmd.AddCommand(workspaceSelectCmd)
+	workspaceCmd.AddCommand(workspaceListCmd)
+	workspaceCmd.AddCommand(workspaceDeleteCmd)
+}
+
+func handleWorkspaceNew(cmd *cobra.Command, name string) error {
+	ctx := context.Background()
+	store, err := openWorkspaceStore()
+	if err != nil {
+		return err
+	}
+
+	if err := store.CreateWorkspace(ctx, name); err != nil {
+		return err
+	}
+	if err := store.SelectWorkspace(ctx, name); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Created and selected workspace %q\n", name)
+	return nil
+}
+
+func handleWorkspaceSelect(cmd *cobra.Command, name string) error {
+	ctx := context.Background()
+	store, err := openWorkspaceStore()
+	if err != nil {
+		return err
+	}
+
+	if err := store.SelectWorkspace(ctx, name); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Selected workspace %q\n", name)
+	if override := currentWorkspaceOverride(); override != "" && override != name {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: --workspace/DUET_WORKSPACE still overrides the selection with %q\n", override)
+	}
+	return nil
+}
+
+func handleWorkspaceList(cmd *cobra.Command) error {
+	ctx := context.Background()
+	store, err := openWorkspaceStore()
+	if err != nil {
+		return err
+	}
+
+	workspaces, err := store.ListWorkspaces(ctx)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	for _, w := range workspaces {
+		marker := " "
+		if w.Name == store.Workspace() {
+			marker = "*"
+		}
+		fmt.Fprintf(out, "%s %s\n", marker, w.Name)
+	}
+	return nil
+}
+
+func handleWorkspaceDelete(cmd *cobra.Command, name string, force bool) (err error) {
+	ctx := context.Background()
+	store, err := openWorkspaceStore()
+	if err != nil {
+		return err
+	}
+
+	if name == store.Workspace() {
+		return fmt.Errorf("workspace %s is in use; select another workspace first", name)
+	}
+
+	// The deleted workspace's history goes with it, so the run is recorded
+	// in the workspace it was made from
+	op := newOperation("workspace delete "+name, "")
+	defer func() {
+		err = recordOperation(ctx, store, op, err)
+	}()
+
+	forgotten, err := store.DeleteWorkspace(ctx, name, force)
+	if err != nil {
+		return err
+	}
+	for _, r := range forgotten {
+		op.Changes = append(op.Changes, state.OperationChange{
+			ResourceID: r.ID,
+			Type:       r.Type,
+			Name:       r.Name,
+			Provider:   r.Provider,
+			Action:     "forget",
+		})
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Deleted workspace %q\n", name)
+	if len(forgotten) > 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "Forgot %d resource(s) without destroying them; see run %s\n", len(forgotten), op.RunID)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+// Package hosts describes how to reach the machines duet configures, from
+// what state records about the instances it created
+package hosts
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/rebelopsio/duet/internal/config/ssh"
+	"github.com/rebelopsio/duet/internal/config/ssm"
+	"github.com/rebelopsio/duet/internal/core/state"
+)
+
+// DefaultSSHPort is the port SSH connections are made to
+const DefaultSSHPort = 22
+
+// awsProvider is the provider whose instances SSM can reach
+const awsProvider = "aws"
+
+// Transports commands can reach a host over
+const (
+	TransportSSH = "ssh"
+	TransportSSM = "ssm"
+)
+
+// Host is a machine to configure: the address in state of the instance,
+// the user to log in as over SSH, and the transport to reach it over
+type Host struct {
+	Address   string
+	User      string
+	Transport string
+}
+
+// ParseHost reads a host from its Lua table, such as
+// { address = "aws.instance.web", user = "ubuntu", transport = "ssm" }.
+// The transport defaults to SSH.
+func ParseHost(v map[string]interface{}) (*Host, error) {
+	h := &Host{Transport: TransportSSH}
+	for key, value := range v {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("host %s must be a string, got %T", key, value)
+		}
+		switch key {
+		case "address":
+			h.Address = s
+		case "user":
+			h.User = s
+		case "transport":
+			h.Transport = s
+		default:
+			return nil, fmt.Errorf("unknown host attribute %q", key)
+		}
+	}
+
+	if h.Address == "" {
+		return nil, fmt.Errorf("host address is required")
+	}
+	switch h.Transport {
+	case TransportSSH:
+		if h.User == "" {
+			return nil, fmt.Errorf("host %s: a user is required to connect over SSH", h.Address)
+		}
+	case TransportSSM:
+	default:
+		return nil, fmt.Errorf("host %s: transport must be %s or %s, got %q", h.Address, TransportSSH, TransportSSM, h.Transport)
+	}
+	return h, nil
+}
+
+// Connection runs commands on a host until it is closed. It satisfies the
+// executor interfaces of the executor and tasks packages.
+type Connection interface {
+	Execute(ctx context.Context, command string) (string, error)
+	Close() error
+}
+
+// ConnectOptions holds what Connect needs beyond state to reach a host
+type ConnectOptions struct {
+	// AWS returns the config of the AWS provider configuration with the
+	// given alias, empty for the default one, to reach the SSM API of an
+	// instance's account and region
+	AWS func(alias string) (aws.Config, error)
+	// SSM holds the command timeout and poll interval; the instance ID is
+	// filled in from state
+	SSM ssm.Config
+	// SSH holds the port and timeout, if not the defaults; the address,
+	// user and private key come from SSHConfig
+	SSH ssh.Config
+}
+
+// Connect opens a connection to host over its transport. SSH connects with
+// the settings from SSHConfig. SSM needs no inbound access to the host: it
+// sends commands to the instance's ID in state through the SSM API.
+func Connect(ctx context.Context, store *state.Store, host *Host, opts ConnectOptions) (Connection, error) {
+	switch host.Transport {
+	case TransportSSM:
+		instance, err := Instance(ctx, store, host.Address)
+		if err != nil {
+			return nil, err
+		}
+		if instance.Provider != awsProvider || opts.AWS == nil {
+			return nil, fmt.Errorf("%s is not an AWS instance, so it cannot be reached over SSM", host.Address)
+		}
+		cfg, err := opts.AWS(instance.Alias)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", host.Address, err)
+		}
+		config := opts.SSM
+		config.InstanceID = instance.ID
+		client, err := ssm.NewClient(cfg, &config)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	default:
+		config, err := SSHConfig(ctx, store, host.Address, host.User)
+		if err != nil {
+			return nil, err
+		}
+		if opts.SSH.Port != 0 {
+			config.Port = opts.SSH.Port
+		}
+		config.Timeout = opts.SSH.Timeout
+		client, err := ssh.NewClient(config)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+}
+
+// Instance returns the state record of the instance at address, such as
+// "aws.instance.web"
+func Instance(ctx context.Context, store *state.Store, address string) (*state.Resource, error) {
+	resources, err := store.GetResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	instance, ok := findAddress(resources, address)
+	if !ok {
+		return nil, fmt.Errorf("%s is not in state", address)
+	}
+	return instance, nil
+}
+
+// keyPairType is the state type of key pairs, whose key_name instances
+// refer to
+const keyPairType = "key_pair"
+
+// SSHConfig returns the SSH settings for logging in as user to the instance
+// at address, such as "aws.instance.web". The host is the address recorded
+// in the instance's metadata. The private key is that of the key pair in
+// state, from the same provider configuration, whose key_name the instance
+// was launched with, so an instance created with a key pair duet generated
+// can be configured in the same run without supplying the key by hand.
+func SSHConfig(ctx context.Context, store *state.Store, address, user string) (*ssh.Config, error) {
+	resources, err := store.GetResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	instance, ok := findAddress(resources, address)
+	if !ok {
+		return nil, fmt.Errorf("%s is not in state", address)
+	}
+	decoded, err := instance.ToResource()
+	if err != nil {
+		return nil, err
+	}
+	host, _ := decoded.Metadata["host"].(string)
+	if host == "" {
+		return nil, fmt.Errorf("%s has no address to connect to", address)
+	}
+	keyName, _ := decoded.Metadata["key_name"].(string)
+	if keyName == "" {
+		return nil, fmt.Errorf("%s was launched without a key pair", address)
+	}
+
+	privateKey, err := privateKeyFor(ctx, store, resources, instance, keyName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", address, err)
+	}
+	return &ssh.Config{
+		Host:       host,
+		User:       user,
+		PrivateKey: privateKey,
+		Port:       DefaultSSHPort,
+	}, nil
+}
+
+// privateKeyFor returns the private key of the key pair named keyName that
+// belongs to the same provider configuration as instance
+func privateKeyFor(ctx context.Context, store *state.Store, resources []state.Resource, instance *state.Resource, keyName string) (string, error) {
+	for i := range resources {
+		r := &resources[i]
+		if r.Type != keyPairType || r.Provider != instance.Provider || r.Alias != instance.Alias {
+			continue
+		}
+		keyPair, err := r.ToResource()
+		if err != nil {
+			return "", err
+		}
+		if keyPair.Metadata["key_name"] != keyName {
+			continue
+		}
+
+		secrets, err := store.OpenSecrets(ctx, r)
+		if err != nil {
+			return "", err
+		}
+		privateKey, _ := secrets["private_key"].(string)
+		if privateKey == "" {
+			return "", fmt.Errorf("the private key of key pair %s was not generated by duet", keyName)
+		}
+		return privateKey, nil
+	}
+	return "", fmt.Errorf("key pair %s is not in state", keyName)
+}
+
+// findAddress returns the resource at an address of the form
+// provider.type.name
+func findAddress(resources []state.Resource, address string) (*state.Resource, bool) {
+	parts := strings.SplitN(address, ".", 3)
+	if len(parts) != 3 {
+		return nil, false
+	}
+	for i := range resources {
+		r := &resources[i]
+		if r.Provider == parts[0] && r.Type == parts[1] && r.Name == parts[2] {
+			return r, true
+		}
+	}
+	return nil, false
+}
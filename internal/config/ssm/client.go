@@ -0,0 +1,184 @@
+// Package ssm runs commands on instances through AWS Systems Manager Run
+// Command, for hosts that accept no inbound SSH
+package ssm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+)
+
+// shellDocument is the SSM document that runs shell commands on Linux
+const shellDocument = "AWS-RunShellScript"
+
+// Config holds the SSM client configuration
+type Config struct {
+	// InstanceID is the managed instance commands run on
+	InstanceID string
+	// Timeout bounds how long a command may run, including waiting for
+	// the agent to pick it up
+	Timeout time.Duration
+	// PollInterval is how long to wait between checks on a running command
+	PollInterval time.Duration
+}
+
+// Client runs commands on one instance through SSM
+type Client struct {
+	config *Config
+	client *ssm.Client
+}
+
+// ExitError reports a command that ran but did not succeed. Code is the
+// command's exit code, or -1 when SSM gave none, as when the command timed
+// out or was cancelled.
+type ExitError struct {
+	Status string
+	Stderr string
+	Code   int
+}
+
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("command %s with exit code %d", strings.ToLower(e.Status), e.Code)
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+// NewClient creates an SSM client for the instance in config, using the
+// region, credentials and endpoint of cfg
+func NewClient(cfg aws.Config, config *Config) (*Client, error) {
+	if config.InstanceID == "" {
+		return nil, fmt.Errorf("an instance ID is required")
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Minute
+	}
+	if config.PollInterval == 0 {
+		config.PollInterval = 2 * time.Second
+	}
+	return &Client{
+		config: config,
+		client: ssm.NewFromConfig(cfg),
+	}, nil
+}
+
+// Close releases the client. SSM holds no connection open, so there is
+// nothing to close; it is here so the client can stand in for an SSH one.
+func (c *Client) Close() error {
+	return nil
+}
+
+// Execute runs a command with the shell on the instance and waits for it to
+// finish, returning its standard output. A command that exits non-zero
+// returns an *ExitError. If ctx ends or the timeout passes first, the
+// command is cancelled.
+func (c *Client) Execute(ctx context.Context, command string) (string, error) {
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	// TimeoutSeconds only bounds how long the command waits to be
+	// delivered; the document's executionTimeout bounds how long it runs.
+	// Both are whole seconds, so round up.
+	seconds := int((c.config.Timeout + time.Second - 1) / time.Second)
+	sent, err := c.client.SendCommand(ctx, &ssm.SendCommandInput{
+		DocumentName: aws.String(shellDocument),
+		InstanceIds:  []string{c.config.InstanceID},
+		Parameters: map[string][]string{
+			"commands":         {command},
+			"executionTimeout": {strconv.Itoa(seconds)},
+		},
+		TimeoutSeconds: aws.Int32(int32(seconds)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to send command to %s: %w", c.config.InstanceID, err)
+	}
+	commandID := aws.ToString(sent.Command.CommandId)
+
+	ticker := time.NewTicker(c.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.cancel(commandID)
+			return "", c.stopped(parent, ctx, commandID)
+		case <-ticker.C:
+		}
+
+		invocation, err := c.client.GetCommandInvocation(ctx, &ssm.GetCommandInvocationInput{
+			CommandId:  aws.String(commandID),
+			InstanceId: aws.String(c.config.InstanceID),
+		})
+		// The invocation can take a moment to exist after the command is sent
+		if errorCode(err) == "InvocationDoesNotExist" {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				c.cancel(commandID)
+				return "", c.stopped(parent, ctx, commandID)
+			}
+			return "", fmt.Errorf("failed to get result of command %s: %w", commandID, err)
+		}
+
+		switch invocation.Status {
+		case ssmtypes.CommandInvocationStatusPending,
+			ssmtypes.CommandInvocationStatusInProgress,
+			ssmtypes.CommandInvocationStatusDelayed,
+			ssmtypes.CommandInvocationStatusCancelling:
+			continue
+		case ssmtypes.CommandInvocationStatusSuccess:
+			return aws.ToString(invocation.StandardOutputContent), nil
+		default:
+			code := int(invocation.ResponseCode)
+			if invocation.Status != ssmtypes.CommandInvocationStatusFailed {
+				code = -1
+			}
+			return aws.ToString(invocation.StandardOutputContent), &ExitError{
+				Status: string(invocation.Status),
+				Stderr: aws.ToString(invocation.StandardErrorContent),
+				Code:   code,
+			}
+		}
+	}
+}
+
+// cancel asks SSM to stop a command the caller has given up on. It uses a
+// fresh context since the caller's has already ended.
+func (c *Client) cancel(commandID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := c.client.CancelCommand(ctx, &ssm.CancelCommandInput{
+		CommandId:   aws.String(commandID),
+		InstanceIds: []string{c.config.InstanceID},
+	}); err != nil {
+		fmt.Printf("error cancelling command %s: %v\n", commandID, err)
+	}
+}
+
+// stopped returns the error for a command given up on when ctx ended,
+// saying so when the timeout passed before the caller's parent ended
+func (c *Client) stopped(parent, ctx context.Context, commandID string) error {
+	if parent.Err() == nil {
+		return fmt.Errorf("command %s did not finish within %s: %w", commandID, c.config.Timeout, ctx.Err())
+	}
+	return parent.Err()
+}
+
+// errorCode returns the API error code of err, or "" if it has none
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+package ssm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+
+	"github.com/rebelopsio/duet/internal/iac/provider/aws/awstest"
+)
+
+func newTestClient(t *testing.T, instanceID string) (*Client, *awstest.SSM) {
+	t.Helper()
+	ssmServer, server := awstest.NewSSMServer()
+	t.Cleanup(server.Close)
+
+	cfg := aws.Config{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		BaseEndpoint: aws.String(server.URL),
+	}
+	client, err := NewClient(cfg, &Config{InstanceID: instanceID, PollInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return client, ssmServer
+}
+
+func TestClient(t *testing.T) {
+	client, ssmServer := newTestClient(t, "i-0123456789abcdef0")
+	ssmServer.SetHandler(func(instanceID, command string) awstest.CommandResult {
+		switch command {
+		case "apt-get install -y cowsay":
+			return awstest.CommandResult{Stdout: "cowsay installed\n"}
+		default:
+			return awstest.CommandResult{Stderr: command + ": not found\n", ExitCode: 127}
+		}
+	})
+	ctx := context.Background()
+
+	t.Run("Success", func(t *testing.T) {
+		output, err := client.Execute(ctx, "apt-get install -y cowsay")
+		if err != nil {
+			t.Fatalf("Failed to execute command: %v", err)
+		}
+		if output != "cowsay installed\n" {
+			t.Errorf("Expected command output, got %q", output)
+		}
+
+		sent := ssmServer.Commands()[0]
+		if sent.InstanceID != "i-0123456789abcdef0" || sent.Document != shellDocument || sent.TimeoutSeconds != 600 || sent.ExecutionTimeout != "600" {
+			t.Errorf("Unexpected command: %+v", sent)
+		}
+		if len(sent.Commands) != 1 || sent.Commands[0] != "apt-get install -y cowsay" {
+			t.Errorf("Expected the command to be sent as one line, got %v", sent.Commands)
+		}
+	})
+
+	t.Run("ExitCode", func(t *testing.T) {
+		_, err := client.Execute(ctx, "cowsya")
+		var exitErr *ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatalf("Expected an ExitError, got %v", err)
+		}
+		if exitErr.Code != 127 || exitErr.Status != "Failed" || !strings.Contains(err.Error(), "cowsya: not found") {
+			t.Errorf("Unexpected error: %v", exitErr)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		slow, ssmServer := newTestClient(t, "i-0123456789abcdef0")
+		slow.config.PollInterval = time.Hour
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+
+		if _, err := slow.Execute(ctx, "sleep 3600"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context deadline exceeded, got %v", err)
+		}
+		if status := ssmServer.Commands()[0].Status; status != "Cancelled" {
+			t.Errorf("Expected the command to be cancelled, got %s", status)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		slow, ssmServer := newTestClient(t, "i-0123456789abcdef0")
+		slow.config.Timeout = 50 * time.Millisecond
+		ssmServer.SetHandler(func(instanceID, command string) awstest.CommandResult {
+			return awstest.CommandResult{Running: true}
+		})
+
+		_, err := slow.Execute(ctx, "sleep 3600")
+		if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "did not finish within 50ms") {
+			t.Fatalf("Expected the command to time out, got %v", err)
+		}
+		sent := ssmServer.Commands()[0]
+		if sent.Status != "Cancelled" || sent.ExecutionTimeout != "1" {
+			t.Errorf("Expected the command to be cancelled, got %+v", sent)
+		}
+	})
+
+	t.Run("NoInstance", func(t *testing.T) {
+		if _, err := NewClient(aws.Config{}, &Config{}); err == nil {
+			t.Error("Expected an error without an instance ID, got nil")
+		}
+	})
+}
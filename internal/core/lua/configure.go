@@ -0,0 +1,103 @@
+package lua
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/rebelopsio/duet/internal/config/tasks"
+)
+
+// configureFunction is the Lua function that configures each host
+const configureFunction = "configure_instance"
+
+// Executor runs shell commands on the host being configured
+type Executor interface {
+	Execute(ctx context.Context, command string) (string, error)
+}
+
+// HasFunction reports whether the config defines a global function name
+func (e *Engine) HasFunction(name string) bool {
+	_, ok := e.state.GetGlobal(name).(*lua.LFunction)
+	return ok
+}
+
+// ConfigureHost calls the config's configure_instance function with host as
+// a Lua table. While it runs, install_package(name, host) and
+// run_command(command, host) run commands through exec; the host argument
+// is accepted for readability, as exec only reaches the one host. Both
+// return a result, or false (install_package) or nil (run_command) and an
+// error message. Configuring fails if the function raises an error or
+// returns false.
+func (e *Engine) ConfigureHost(ctx context.Context, host map[string]interface{}, exec Executor) error {
+	L := e.state
+	packages := tasks.NewPackageManager(exec)
+	L.SetGlobal("install_package", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		if err := packages.Install(ctx, name); err != nil {
+			L.Push(lua.LFalse)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(lua.LTrue)
+		return 1
+	}))
+	L.SetGlobal("run_command", L.NewFunction(func(L *lua.LState) int {
+		output, err := exec.Execute(ctx, L.CheckString(1))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(lua.LString(output))
+		return 1
+	}))
+	defer func() {
+		L.SetGlobal("install_package", lua.LNil)
+		L.SetGlobal("run_command", lua.LNil)
+	}()
+
+	ret, err := e.CallFunction(configureFunction, fromGo(L, host))
+	if err != nil {
+		return err
+	}
+	if ret == lua.LFalse {
+		return fmt.Errorf("%s returned false", configureFunction)
+	}
+	return nil
+}
+
+// fromGo converts plain Go values, as returned by ToGo, to Lua values
+func fromGo(L *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case bool:
+		return lua.LBool(v)
+	case string:
+		return lua.LString(v)
+	case int:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case []interface{}:
+		t := L.NewTable()
+		for _, item := range v {
+			t.Append(fromGo(L, item))
+		}
+		return t
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		t := L.NewTable()
+		for _, k := range keys {
+			t.RawSetString(k, fromGo(L, v[k]))
+		}
+		return t
+	default:
+		return lua.LNil
+	}
+}
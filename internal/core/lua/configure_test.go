@@ -0,0 +1,93 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// recorder runs no commands, recording them and failing those in fail
+type recorder struct {
+	fail     map[string]bool
+	commands []string
+}
+
+func (r *recorder) Execute(ctx context.Context, command string) (string, error) {
+	r.commands = append(r.commands, command)
+	if r.fail[command] {
+		return "", errors.New("exit status 100")
+	}
+	return "output of " + command, nil
+}
+
+func TestConfigureHost(t *testing.T) {
+	ctx := context.Background()
+	host := map[string]interface{}{"address": "aws.instance.web", "transport": "ssm"}
+
+	configure := func(t *testing.T, script string, exec Executor) error {
+		t.Helper()
+		engine := NewEngine()
+		defer engine.Close()
+		if err := engine.state.DoString(script); err != nil {
+			t.Fatalf("Failed to load script: %v", err)
+		}
+		if !engine.HasFunction("configure_instance") {
+			t.Fatal("Expected configure_instance to be defined")
+		}
+		return engine.ConfigureHost(ctx, host, exec)
+	}
+
+	t.Run("Commands", func(t *testing.T) {
+		exec := &recorder{}
+		err := configure(t, `
+			function configure_instance(host)
+				if host.address ~= "aws.instance.web" or host.transport ~= "ssm" then
+					error("unexpected host")
+				end
+				local ok, err = install_package("cowsay", host)
+				if not ok then error(err) end
+				local out, err = run_command("uptime", host)
+				if out ~= "output of uptime" then error("unexpected output " .. tostring(out)) end
+				return true
+			end
+		`, exec)
+		if err != nil {
+			t.Fatalf("Failed to configure host: %v", err)
+		}
+		if strings.Join(exec.commands, ";") != "apt-get install -y cowsay;uptime" {
+			t.Errorf("Unexpected commands: %v", exec.commands)
+		}
+	})
+
+	t.Run("CommandFailure", func(t *testing.T) {
+		exec := &recorder{fail: map[string]bool{"apt-get install -y missing": true}}
+		err := configure(t, `
+			function configure_instance(host)
+				local ok, err = install_package("missing", host)
+				if not ok then error("Failed to install missing: " .. err) end
+				return true
+			end
+		`, exec)
+		if err == nil || !strings.Contains(err.Error(), "Failed to install missing: exit status 100") {
+			t.Errorf("Expected the command error to reach the caller, got %v", err)
+		}
+	})
+
+	t.Run("ReturnsFalse", func(t *testing.T) {
+		if err := configure(t, `function configure_instance(host) return false end`, &recorder{}); err == nil {
+			t.Error("Expected error when configure_instance returns false, got nil")
+		}
+	})
+
+	t.Run("NotDefined", func(t *testing.T) {
+		engine := NewEngine()
+		defer engine.Close()
+		if engine.HasFunction("configure_instance") {
+			t.Error("Expected configure_instance not to be defined")
+		}
+		if err := engine.ConfigureHost(ctx, host, &recorder{}); err == nil {
+			t.Error("Expected error without configure_instance, got nil")
+		}
+	})
+}
@@ -2,6 +2,8 @@ package lua
 
 import (
 	"fmt"
+	"math"
+	"strings"
 
 	lua "github.com/yuin/gopher-lua"
 )
@@ -11,9 +13,59 @@ type Engine struct {
 }
 
 func NewEngine() *Engine {
+	L := lua.NewState()
+
+	// The duet table exposes run context to configurations
+	duet := L.NewTable()
+	duet.RawSetString("workspace", lua.LString("default"))
+	duet.RawSetString("ref", L.NewFunction(luaRef))
+	duet.RawSetString("data", L.NewFunction(luaData))
+	L.SetGlobal("duet", duet)
+
 	return &Engine{
-		state: lua.NewState(),
+		state: L,
+	}
+}
+
+// SetWorkspace makes the current workspace name available to configurations
+// as duet.workspace
+func (e *Engine) SetWorkspace(name string) {
+	e.duetTable().RawSetString("workspace", lua.LString(name))
+}
+
+// luaRef implements duet.ref(address, attribute), which returns a reference
+// to another resource's attribute, e.g. duet.ref("aws.vpc.main", "id")
+// returns "${aws.vpc.main.id}". The planner resolves it once that resource
+// exists and orders the two accordingly.
+func luaRef(L *lua.LState) int {
+	address := L.CheckString(1)
+	attribute := L.OptString(2, "id")
+	L.Push(lua.LString("${" + address + "." + attribute + "}"))
+	return 1
+}
+
+// luaData implements duet.data(address, attribute, ...), which returns a
+// reference to a data source's attribute, e.g.
+// duet.data("aws.availability_zones.all", "names", 1) returns
+// "${data.aws.availability_zones.all.names.1}". Further arguments index into
+// tables and lists. The planner replaces it with the value it reads.
+func luaData(L *lua.LState) int {
+	address := L.CheckString(1)
+	path := []string{L.OptString(2, "id")}
+	for i := 3; i <= L.GetTop(); i++ {
+		path = append(path, L.CheckAny(i).String())
+	}
+	L.Push(lua.LString("${data." + address + "." + strings.Join(path, ".") + "}"))
+	return 1
+}
+
+func (e *Engine) duetTable() *lua.LTable {
+	if t, ok := e.state.GetGlobal("duet").(*lua.LTable); ok {
+		return t
 	}
+	t := e.state.NewTable()
+	e.state.SetGlobal("duet", t)
+	return t
 }
 
 func (e *Engine) Close() {
@@ -45,3 +97,56 @@ func (e *Engine) CallFunction(name string, args ...lua.LValue) (lua.LValue, erro
 	e.state.Pop(1)
 	return ret, nil
 }
+
+// Infrastructure calls the config's deploy_infrastructure function and
+// returns the table it builds as Go values
+func (e *Engine) Infrastructure() (map[string]interface{}, error) {
+	ret, err := e.CallFunction("deploy_infrastructure")
+	if err != nil {
+		return nil, err
+	}
+
+	infra, ok := ToGo(ret).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("deploy_infrastructure must return a table, got %s", ret.Type())
+	}
+	return infra, nil
+}
+
+// ToGo converts a Lua value to plain Go values. Tables with keys 1..n become
+// []interface{}, other tables become map[string]interface{}, and whole
+// numbers become int.
+func ToGo(lv lua.LValue) interface{} {
+	switch v := lv.(type) {
+	case lua.LBool:
+		return bool(v)
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		if f := float64(v); f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int(f)
+		}
+		return float64(v)
+	case *lua.LTable:
+		if n := v.MaxN(); n > 0 && n == countKeys(v) {
+			list := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				list = append(list, ToGo(v.RawGetInt(i)))
+			}
+			return list
+		}
+		m := make(map[string]interface{})
+		v.ForEach(func(key, value lua.LValue) {
+			m[key.String()] = ToGo(value)
+		})
+		return m
+	default:
+		return nil
+	}
+}
+
+func countKeys(t *lua.LTable) int {
+	n := 0
+	t.ForEach(func(lua.LValue, lua.LValue) { n++ })
+	return n
+}
@@ -79,4 +79,124 @@ func TestEngine(t *testing.T) {
 			t.Error("Expected error for non-existent function, got nil")
 		}
 	})
+	t.Run("Infrastructure", func(t *testing.T) {
+		engine := NewEngine()
+		defer engine.Close()
+
+		script := `
+			function deploy_infrastructure()
+				return {
+					provider = "aws",
+					resources = {
+						{ type = "instance", name = "web", config = { count = 2, ratio = 0.5, public = true } },
+					},
+				}
+			end
+		`
+
+		if err := engine.state.DoString(script); err != nil {
+			t.Fatalf("Failed to load script: %v", err)
+		}
+
+		infra, err := engine.Infrastructure()
+		if err != nil {
+			t.Fatalf("Failed to get infrastructure: %v", err)
+		}
+
+		resources, ok := infra["resources"].([]interface{})
+		if !ok || len(resources) != 1 {
+			t.Fatalf("Expected a list of 1 resource, got %#v", infra["resources"])
+		}
+		config := resources[0].(map[string]interface{})["config"].(map[string]interface{})
+		if config["count"] != 2 || config["ratio"] != 0.5 || config["public"] != true {
+			t.Errorf("Unexpected config conversion: %#v", config)
+		}
+	})
+	t.Run("Workspace", func(t *testing.T) {
+		engine := NewEngine()
+		defer engine.Close()
+
+		script := `
+			function instance_type()
+				if duet.workspace == "prod" then
+					return "m5.large"
+				end
+				return "t3.micro"
+			end
+		`
+
+		if err := engine.state.DoString(script); err != nil {
+			t.Fatalf("Failed to load script: %v", err)
+		}
+
+		result, err := engine.CallFunction("instance_type")
+		if err != nil {
+			t.Fatalf("Failed to call function: %v", err)
+		}
+		if result.String() != "t3.micro" {
+			t.Errorf("Expected default workspace to pick t3.micro, got %s", result.String())
+		}
+
+		engine.SetWorkspace("prod")
+		result, err = engine.CallFunction("instance_type")
+		if err != nil {
+			t.Fatalf("Failed to call function: %v", err)
+		}
+		if result.String() != "m5.large" {
+			t.Errorf("Expected prod workspace to pick m5.large, got %s", result.String())
+		}
+	})
+
+	t.Run("Ref", func(t *testing.T) {
+		engine := NewEngine()
+		defer engine.Close()
+
+		script := `
+			function refs()
+				return duet.ref("aws.vpc.main"), duet.ref("aws.instance.web", "private_ip")
+			end
+		`
+		if err := engine.state.DoString(script); err != nil {
+			t.Fatalf("Failed to load script: %v", err)
+		}
+
+		if err := engine.state.CallByParam(lua.P{Fn: engine.state.GetGlobal("refs"), NRet: 2, Protect: true}); err != nil {
+			t.Fatalf("Failed to call function: %v", err)
+		}
+		ip, id := engine.state.Get(-1).String(), engine.state.Get(-2).String()
+		engine.state.Pop(2)
+
+		if id != "${aws.vpc.main.id}" {
+			t.Errorf("Expected ${aws.vpc.main.id}, got %s", id)
+		}
+		if ip != "${aws.instance.web.private_ip}" {
+			t.Errorf("Expected ${aws.instance.web.private_ip}, got %s", ip)
+		}
+	})
+	t.Run("Data", func(t *testing.T) {
+		engine := NewEngine()
+		defer engine.Close()
+
+		script := `
+			function refs()
+				return duet.data("aws.ami.ubuntu"), duet.data("aws.availability_zones.all", "names", 1)
+			end
+		`
+		if err := engine.state.DoString(script); err != nil {
+			t.Fatalf("Failed to load script: %v", err)
+		}
+
+		if err := engine.state.CallByParam(lua.P{Fn: engine.state.GetGlobal("refs"), NRet: 2, Protect: true}); err != nil {
+			t.Fatalf("Failed to call function: %v", err)
+		}
+		zone, id := engine.state.Get(-1).String(), engine.state.Get(-2).String()
+		engine.state.Pop(2)
+
+		if id != "${data.aws.ami.ubuntu.id}" {
+			t.Errorf("Expected ${data.aws.ami.ubuntu.id}, got %s", id)
+		}
+		if zone != "${data.aws.availability_zones.all.names.1}" {
+			t.Errorf("Expected ${data.aws.availability_zones.all.names.1}, got %s", zone)
+		}
+	})
 }
@@ -0,0 +1,100 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Intent actions
+const (
+	IntentCreate = "create"
+	IntentUpdate = "update"
+	IntentDelete = "delete"
+)
+
+// Intent is a journal entry written before a provider call and removed, in
+// the same transaction as the resulting state change, once the call's result
+// has been recorded. An intent that survives a run means duet stopped between
+// the provider call and the commit, and the provider must be consulted to
+// find out what happened.
+type Intent struct {
+	CreatedAt    time.Time
+	Workspace    string
+	RunID        string
+	Action       string
+	Provider     string
+	Alias        string
+	Type         string
+	Name         string
+	ResourceID   string
+	Token        string
+	Config       []byte
+	Dependencies []string `gorm:"serializer:json"`
+	ID           uint     `gorm:"primaryKey"`
+}
+
+// TableName stores intents in the journal table
+func (Intent) TableName() string {
+	return "journal"
+}
+
+// BeginIntent records that a provider call is about to be made
+func (s *Store) BeginIntent(ctx context.Context, intent *Intent) error {
+	intent.Workspace = s.workspace
+	if intent.CreatedAt.IsZero() {
+		intent.CreatedAt = time.Now().UTC()
+	}
+	if err := s.db.WithContext(ctx).Create(intent).Error; err != nil {
+		return fmt.Errorf("failed to journal %s of %s.%s: %w", intent.Action, intent.Type, intent.Name, err)
+	}
+	return nil
+}
+
+// CommitIntent atomically records the outcome of a provider call and clears
+// the intent. A nil resource removes intent.ResourceID from state, and a
+// resource with another ID replaces it, as when one that had gone is
+// created again.
+func (s *Store) CommitIntent(ctx context.Context, intent *Intent, resource *Resource) error {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if resource != nil {
+			if intent.ResourceID != "" && intent.ResourceID != resource.ID {
+				if err := deleteResource(tx, intent.Workspace, intent.ResourceID); err != nil {
+					return err
+				}
+			}
+			resource.Workspace = intent.Workspace
+			if err := saveResource(tx, resource); err != nil {
+				return err
+			}
+		} else if err := deleteResource(tx, intent.Workspace, intent.ResourceID); err != nil {
+			return err
+		}
+		return tx.Delete(&Intent{}, intent.ID).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit %s of %s.%s: %w", intent.Action, intent.Type, intent.Name, err)
+	}
+	return nil
+}
+
+// AbandonIntent clears an intent without changing state, for provider calls
+// that are known to have had no effect
+func (s *Store) AbandonIntent(ctx context.Context, intent *Intent) error {
+	if err := s.db.WithContext(ctx).Delete(&Intent{}, intent.ID).Error; err != nil {
+		return fmt.Errorf("failed to clear journal entry %d: %w", intent.ID, err)
+	}
+	return nil
+}
+
+// PendingIntents returns journal entries left behind by interrupted runs,
+// oldest first
+func (s *Store) PendingIntents(ctx context.Context) ([]Intent, error) {
+	var intents []Intent
+	if err := s.db.WithContext(ctx).Order("id").Find(&intents, "workspace = ?", s.workspace).Error; err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	return intents, nil
+}
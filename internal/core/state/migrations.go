@@ -0,0 +1,319 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrSchemaTooNew is returned when the state database was written by a newer
+// version of duet than the one trying to open it.
+var ErrSchemaTooNew = errors.New("state schema is newer than this version of duet supports")
+
+// Migration is a single numbered, forward-only change to the state schema.
+// Migrations are never edited once released; changes to the model are made
+// by appending a new migration.
+type Migration struct {
+	Up      func(tx *gorm.DB) error
+	Name    string
+	Version int
+}
+
+// schemaMigration records an applied migration in the schema_migrations table
+type schemaMigration struct {
+	AppliedAt time.Time
+	Name      string
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// migrations is the ordered list of schema changes. Versions must be
+// contiguous and start at 1.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create resources table",
+		Up: func(tx *gorm.DB) error {
+			// IF NOT EXISTS adopts databases created by earlier releases that
+			// relied on AutoMigrate and have no schema_migrations table.
+			return tx.Exec(`CREATE TABLE IF NOT EXISTS resources (
+				id TEXT NOT NULL,
+				type TEXT,
+				name TEXT,
+				provider TEXT,
+				status TEXT,
+				last_updated TEXT,
+				metadata BLOB,
+				config_applied NUMERIC,
+				PRIMARY KEY (id)
+			)`).Error
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add resource tags and query indexes",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`CREATE TABLE resource_tags (
+					resource_id TEXT NOT NULL,
+					key TEXT NOT NULL,
+					value TEXT,
+					PRIMARY KEY (resource_id, key)
+				)`,
+				`CREATE INDEX idx_resource_tags_key_value ON resource_tags (key, value)`,
+				`CREATE INDEX idx_resources_provider ON resources (provider)`,
+				`CREATE INDEX idx_resources_type ON resources (type)`,
+				`CREATE INDEX idx_resources_status ON resources (status)`,
+				`CREATE INDEX idx_resources_config_applied ON resources (config_applied)`,
+				`CREATE INDEX idx_resources_name ON resources (name, id)`,
+				`CREATE INDEX idx_resources_last_updated ON resources (last_updated, id)`,
+			)
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add operations audit log",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`CREATE TABLE operations (
+					run_id TEXT NOT NULL,
+					command TEXT,
+					user TEXT,
+					hostname TEXT,
+					duet_version TEXT,
+					config_commit TEXT,
+					started_at DATETIME,
+					finished_at DATETIME,
+					outcome TEXT,
+					error TEXT,
+					changes TEXT,
+					PRIMARY KEY (run_id)
+				)`,
+				`CREATE INDEX idx_operations_started_at ON operations (started_at)`,
+				// The audit log is append-only; reject edits at the database level
+				`CREATE TRIGGER operations_no_update BEFORE UPDATE ON operations
+				BEGIN SELECT RAISE(ABORT, 'operations are append-only'); END`,
+				`CREATE TRIGGER operations_no_delete BEFORE DELETE ON operations
+				BEGIN SELECT RAISE(ABORT, 'operations are append-only'); END`,
+			)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add apply journal and desired config",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`ALTER TABLE resources ADD COLUMN config BLOB`,
+				`CREATE TABLE journal (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					run_id TEXT,
+					action TEXT,
+					provider TEXT,
+					type TEXT,
+					name TEXT,
+					resource_id TEXT,
+					token TEXT,
+					config BLOB,
+					created_at DATETIME
+				)`,
+			)
+		},
+	},
+	{
+		Version: 5,
+		Name:    "partition state by workspace",
+		Up: func(tx *gorm.DB) error {
+			// SQLite cannot change a primary key in place, so resources and
+			// their tags are rebuilt keyed by (workspace, id)
+			return execAll(tx,
+				`CREATE TABLE workspaces (
+					name TEXT NOT NULL,
+					created_at DATETIME,
+					PRIMARY KEY (name)
+				)`,
+				`INSERT INTO workspaces (name, created_at) VALUES ('default', CURRENT_TIMESTAMP)`,
+				`CREATE TABLE settings (
+					key TEXT NOT NULL,
+					value TEXT,
+					PRIMARY KEY (key)
+				)`,
+
+				`CREATE TABLE resources_new (
+					workspace TEXT NOT NULL DEFAULT 'default',
+					id TEXT NOT NULL,
+					type TEXT,
+					name TEXT,
+					provider TEXT,
+					status TEXT,
+					last_updated TEXT,
+					metadata BLOB,
+					config BLOB,
+					config_applied NUMERIC,
+					PRIMARY KEY (workspace, id)
+				)`,
+				`INSERT INTO resources_new (workspace, id, type, name, provider, status, last_updated, metadata, config, config_applied)
+				SELECT 'default', id, type, name, provider, status, last_updated, metadata, config, config_applied FROM resources`,
+				`DROP TABLE resources`,
+				`ALTER TABLE resources_new RENAME TO resources`,
+				`CREATE INDEX idx_resources_provider ON resources (workspace, provider)`,
+				`CREATE INDEX idx_resources_type ON resources (workspace, type)`,
+				`CREATE INDEX idx_resources_status ON resources (workspace, status)`,
+				`CREATE INDEX idx_resources_config_applied ON resources (workspace, config_applied)`,
+				`CREATE INDEX idx_resources_name ON resources (workspace, name, id)`,
+				`CREATE INDEX idx_resources_last_updated ON resources (workspace, last_updated, id)`,
+
+				`CREATE TABLE resource_tags_new (
+					workspace TEXT NOT NULL DEFAULT 'default',
+					resource_id TEXT NOT NULL,
+					key TEXT NOT NULL,
+					value TEXT,
+					PRIMARY KEY (workspace, resource_id, key)
+				)`,
+				`INSERT INTO resource_tags_new (workspace, resource_id, key, value)
+				SELECT 'default', resource_id, key, value FROM resource_tags`,
+				`DROP TABLE resource_tags`,
+				`ALTER TABLE resource_tags_new RENAME TO resource_tags`,
+				`CREATE INDEX idx_resource_tags_key_value ON resource_tags (workspace, key, value)`,
+
+				`ALTER TABLE journal ADD COLUMN workspace TEXT NOT NULL DEFAULT 'default'`,
+				`ALTER TABLE operations ADD COLUMN workspace TEXT NOT NULL DEFAULT 'default'`,
+				`CREATE INDEX idx_operations_workspace ON operations (workspace, started_at)`,
+			)
+		},
+	},
+	{
+		Version: 6,
+		Name:    "record resource dependencies",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`ALTER TABLE resources ADD COLUMN dependencies TEXT`,
+				`ALTER TABLE journal ADD COLUMN dependencies TEXT`,
+			)
+		},
+	},
+	{
+		Version: 7,
+		Name:    "record provider aliases",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`ALTER TABLE resources ADD COLUMN alias TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE journal ADD COLUMN alias TEXT NOT NULL DEFAULT ''`,
+			)
+		},
+	},
+	{
+		Version: 8,
+		Name:    "store encrypted sensitive attributes",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec(`ALTER TABLE resources ADD COLUMN secrets BLOB`).Error
+		},
+	},
+}
+
+// execAll runs each statement in order, stopping at the first error
+func execAll(tx *gorm.DB, statements ...string) error {
+	for _, stmt := range statements {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LatestSchemaVersion returns the schema version this build of duet writes
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version of the most recent migration applied to
+// the database, or 0 if it has never been migrated.
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	return schemaVersion(s.db.WithContext(ctx))
+}
+
+func schemaVersion(db *gorm.DB) (int, error) {
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		return 0, nil
+	}
+
+	var version int
+	result := db.Model(&schemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", result.Error)
+	}
+	return version, nil
+}
+
+// PendingMigrations returns the migrations that have not yet been applied, in
+// the order Migrate would apply them.
+func (s *Store) PendingMigrations(ctx context.Context) ([]Migration, error) {
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations. Each migration runs in its own
+// transaction together with its schema_migrations record, so a failure leaves
+// the database at the last successfully applied version.
+func (s *Store) Migrate(ctx context.Context) error {
+	db := s.db.WithContext(ctx)
+
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL,
+		name TEXT,
+		applied_at DATETIME,
+		PRIMARY KEY (version)
+	)`).Error; err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	pending, err := s.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now().UTC(),
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkSchemaVersion refuses databases written by a newer duet, since this
+// binary cannot know what the unknown migrations changed.
+func checkSchemaVersion(db *gorm.DB) error {
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if latest := LatestSchemaVersion(); current > latest {
+		return fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, current, latest)
+	}
+	return nil
+}
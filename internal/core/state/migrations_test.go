@@ -0,0 +1,129 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("VersionsAreContiguous", func(t *testing.T) {
+		for i, m := range migrations {
+			if m.Version != i+1 {
+				t.Errorf("Expected migration %d to have version %d, got %d", i, i+1, m.Version)
+			}
+		}
+	})
+
+	t.Run("FreshDatabase", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "fresh.db")
+
+		store, err := Open(dbPath)
+		if err != nil {
+			t.Fatalf("Failed to open store: %v", err)
+		}
+
+		pending, err := store.PendingMigrations(ctx)
+		if err != nil {
+			t.Fatalf("Failed to list pending migrations: %v", err)
+		}
+		if len(pending) != len(migrations) {
+			t.Errorf("Expected %d pending migrations, got %d", len(migrations), len(pending))
+		}
+
+		if err := store.Migrate(ctx); err != nil {
+			t.Fatalf("Failed to migrate: %v", err)
+		}
+
+		version, err := store.SchemaVersion(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get schema version: %v", err)
+		}
+		if version != LatestSchemaVersion() {
+			t.Errorf("Expected schema version %d, got %d", LatestSchemaVersion(), version)
+		}
+
+		pending, err = store.PendingMigrations(ctx)
+		if err != nil {
+			t.Fatalf("Failed to list pending migrations: %v", err)
+		}
+		if len(pending) != 0 {
+			t.Errorf("Expected no pending migrations, got %d", len(pending))
+		}
+
+		// Migrating twice is a no-op
+		if err := store.Migrate(ctx); err != nil {
+			t.Fatalf("Failed to re-run migrate: %v", err)
+		}
+	})
+
+	t.Run("AdoptsAutoMigratedDatabase", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+		// Databases created before schema versioning were built by AutoMigrate
+		// from the original Resource model
+		type legacyResource struct {
+			ID            string `gorm:"primaryKey"`
+			Type          string
+			Name          string
+			Provider      string
+			Status        string
+			LastUpdated   string
+			Metadata      []byte
+			ConfigApplied bool
+		}
+		db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+		if err != nil {
+			t.Fatalf("Failed to open database: %v", err)
+		}
+		if err := db.Table("resources").AutoMigrate(&legacyResource{}); err != nil {
+			t.Fatalf("Failed to auto-migrate: %v", err)
+		}
+		if err := db.Table("resources").Create(&legacyResource{ID: "legacy-1", Type: "instance"}).Error; err != nil {
+			t.Fatalf("Failed to seed resource: %v", err)
+		}
+
+		store, err := NewStore(dbPath)
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+
+		resource, err := store.GetResource(ctx, "legacy-1")
+		if err != nil {
+			t.Fatalf("Expected legacy resource to survive migration: %v", err)
+		}
+		if resource.Type != "instance" {
+			t.Errorf("Expected resource type instance, got %s", resource.Type)
+		}
+	})
+
+	t.Run("RefusesNewerSchema", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "newer.db")
+
+		store, err := NewStore(dbPath)
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+
+		future := schemaMigration{
+			Version:   LatestSchemaVersion() + 1,
+			Name:      "from the future",
+			AppliedAt: time.Now(),
+		}
+		if err := store.db.Create(&future).Error; err != nil {
+			t.Fatalf("Failed to record future migration: %v", err)
+		}
+
+		_, err = NewStore(dbPath)
+		if !errors.Is(err, ErrSchemaTooNew) {
+			t.Errorf("Expected ErrSchemaTooNew, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package state
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Operation outcomes
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+)
+
+// Operation is an audit record of a single duet run. Operations are
+// append-only: once recorded they cannot be updated or deleted.
+type Operation struct {
+	StartedAt    time.Time         `json:"started_at"`
+	FinishedAt   time.Time         `json:"finished_at"`
+	RunID        string            `json:"run_id" gorm:"primaryKey"`
+	Workspace    string            `json:"workspace"`
+	Command      string            `json:"command"`
+	User         string            `json:"user"`
+	Hostname     string            `json:"hostname"`
+	DuetVersion  string            `json:"duet_version"`
+	ConfigCommit string            `json:"config_commit,omitempty"`
+	Outcome      string            `json:"outcome"`
+	Error        string            `json:"error,omitempty"`
+	Changes      []OperationChange `json:"changes" gorm:"serializer:json"`
+}
+
+// OperationChange records what a run did to a single resource
+type OperationChange struct {
+	ResourceID string `json:"resource_id"`
+	Type       string `json:"type"`
+	Name       string `json:"name"`
+	Provider   string `json:"provider"`
+	Action     string `json:"action"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Duration returns how long the run took
+func (o *Operation) Duration() time.Duration {
+	return o.FinishedAt.Sub(o.StartedAt)
+}
+
+// NewRunID returns a unique run identifier. IDs sort by creation time.
+func NewRunID() string {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("failed to generate run ID: %v", err))
+	}
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b[:])
+}
+
+// RecordOperation appends an operation to the audit log
+func (s *Store) RecordOperation(ctx context.Context, op *Operation) error {
+	if op.RunID == "" {
+		return fmt.Errorf("operation has no run ID")
+	}
+	op.Workspace = s.workspace
+	if err := s.db.WithContext(ctx).Create(op).Error; err != nil {
+		return fmt.Errorf("failed to record operation %s: %w", op.RunID, err)
+	}
+	return nil
+}
+
+// ListOperations returns the workspace's most recent operations, newest
+// first. A limit of zero returns the full history.
+func (s *Store) ListOperations(ctx context.Context, limit int) ([]Operation, error) {
+	db := s.db.WithContext(ctx).Where("workspace = ?", s.workspace).Order("started_at DESC, run_id DESC")
+	if limit > 0 {
+		db = db.Limit(limit)
+	}
+
+	var ops []Operation
+	if err := db.Find(&ops).Error; err != nil {
+		return nil, fmt.Errorf("failed to list operations: %w", err)
+	}
+	return ops, nil
+}
+
+// GetOperation retrieves a single operation in the workspace by run ID
+func (s *Store) GetOperation(ctx context.Context, runID string) (*Operation, error) {
+	var op Operation
+	result := s.db.WithContext(ctx).First(&op, "workspace = ? AND run_id = ?", s.workspace, runID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &op, nil
+}
@@ -0,0 +1,112 @@
+package state
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOperations(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "operations.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	first := &Operation{
+		RunID:       NewRunID(),
+		Command:     "apply",
+		User:        "alice",
+		Hostname:    "build-1",
+		DuetVersion: "v1.0.0",
+		StartedAt:   start,
+		FinishedAt:  start.Add(time.Minute),
+		Outcome:     OutcomeSucceeded,
+		Changes: []OperationChange{
+			{ResourceID: "i-123", Type: "instance", Name: "web", Provider: "aws", Action: "create"},
+		},
+	}
+	second := &Operation{
+		RunID:      NewRunID(),
+		Command:    "apply",
+		StartedAt:  start.Add(time.Hour),
+		FinishedAt: start.Add(time.Hour + time.Second),
+		Outcome:    OutcomeFailed,
+		Error:      "boom",
+	}
+
+	t.Run("RecordAndGet", func(t *testing.T) {
+		for _, op := range []*Operation{first, second} {
+			if err := store.RecordOperation(ctx, op); err != nil {
+				t.Fatalf("Failed to record operation: %v", err)
+			}
+		}
+
+		op, err := store.GetOperation(ctx, first.RunID)
+		if err != nil {
+			t.Fatalf("Failed to get operation: %v", err)
+		}
+		if op.User != "alice" || op.Outcome != OutcomeSucceeded {
+			t.Errorf("Unexpected operation: %+v", op)
+		}
+		if len(op.Changes) != 1 || op.Changes[0].ResourceID != "i-123" {
+			t.Errorf("Expected change set to round-trip, got %+v", op.Changes)
+		}
+		if op.Duration() != time.Minute {
+			t.Errorf("Expected duration 1m, got %s", op.Duration())
+		}
+	})
+
+	t.Run("ListNewestFirst", func(t *testing.T) {
+		ops, err := store.ListOperations(ctx, 0)
+		if err != nil {
+			t.Fatalf("Failed to list operations: %v", err)
+		}
+		if len(ops) != 2 {
+			t.Fatalf("Expected 2 operations, got %d", len(ops))
+		}
+		if ops[0].RunID != second.RunID {
+			t.Errorf("Expected newest operation first, got %s", ops[0].RunID)
+		}
+
+		ops, err = store.ListOperations(ctx, 1)
+		if err != nil {
+			t.Fatalf("Failed to list operations: %v", err)
+		}
+		if len(ops) != 1 {
+			t.Errorf("Expected limit to apply, got %d operations", len(ops))
+		}
+	})
+
+	t.Run("OtherWorkspace", func(t *testing.T) {
+		other := store.WithWorkspace("other")
+		if _, err := other.GetOperation(ctx, first.RunID); err == nil {
+			t.Error("Expected error getting an operation from another workspace, got nil")
+		}
+		ops, err := other.ListOperations(ctx, 0)
+		if err != nil {
+			t.Fatalf("Failed to list operations: %v", err)
+		}
+		if len(ops) != 0 {
+			t.Errorf("Expected no operations in another workspace, got %d", len(ops))
+		}
+	})
+
+	t.Run("AppendOnly", func(t *testing.T) {
+		if err := store.RecordOperation(ctx, first); err == nil {
+			t.Error("Expected error re-recording an existing run, got nil")
+		}
+		if err := store.db.Model(&Operation{}).Where("run_id = ?", first.RunID).Update("outcome", OutcomeFailed).Error; err == nil {
+			t.Error("Expected error updating an operation, got nil")
+		}
+		if err := store.db.Delete(&Operation{}, "run_id = ?", first.RunID).Error; err == nil {
+			t.Error("Expected error deleting an operation, got nil")
+		}
+		if err := store.RecordOperation(ctx, &Operation{}); err == nil {
+			t.Error("Expected error recording an operation without run ID, got nil")
+		}
+	})
+}
@@ -0,0 +1,258 @@
+package state
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// SortField is a resource column that query results can be ordered by
+type SortField string
+
+// Sortable resource columns
+const (
+	SortByID          SortField = "id"
+	SortByName        SortField = "name"
+	SortByType        SortField = "type"
+	SortByProvider    SortField = "provider"
+	SortByStatus      SortField = "status"
+	SortByLastUpdated SortField = "last_updated"
+)
+
+// ParseSortField validates a sort field name given on the command line
+func ParseSortField(name string) (SortField, error) {
+	switch f := SortField(name); f {
+	case SortByID, SortByName, SortByType, SortByProvider, SortByStatus, SortByLastUpdated:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown sort field %q", name)
+	}
+}
+
+// Query builds a filtered, sorted and paginated resource lookup. Create one
+// with Store.Query, chain filters, then call Find.
+type Query struct {
+	store         *Store
+	configApplied *bool
+	tags          map[string]*string
+	provider      string
+	resourceType  string
+	status        string
+	sortBy        SortField
+	cursor        string
+	limit         int
+	descending    bool
+}
+
+// Page is one page of query results. NextCursor is empty on the last page.
+type Page struct {
+	NextCursor string
+	Resources  []Resource
+}
+
+// cursor identifies the last row of a page for keyset pagination
+type cursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// Query starts a new resource query. Without filters it matches every
+// resource, ordered by ID.
+func (s *Store) Query() *Query {
+	return &Query{
+		store:  s,
+		tags:   make(map[string]*string),
+		sortBy: SortByID,
+	}
+}
+
+// Provider restricts results to resources managed by the given provider
+func (q *Query) Provider(provider string) *Query {
+	q.provider = provider
+	return q
+}
+
+// Type restricts results to resources of the given type
+func (q *Query) Type(resourceType string) *Query {
+	q.resourceType = resourceType
+	return q
+}
+
+// Status restricts results to resources in the given status
+func (q *Query) Status(status string) *Query {
+	q.status = status
+	return q
+}
+
+// Tag restricts results to resources tagged key=value
+func (q *Query) Tag(key, value string) *Query {
+	q.tags[key] = &value
+	return q
+}
+
+// HasTag restricts results to resources carrying the tag key, whatever its value
+func (q *Query) HasTag(key string) *Query {
+	q.tags[key] = nil
+	return q
+}
+
+// ConfigApplied restricts results by whether configuration has been applied
+func (q *Query) ConfigApplied(applied bool) *Query {
+	q.configApplied = &applied
+	return q
+}
+
+// OrderBy sets the sort column. Ties are broken by ID so pages are stable.
+func (q *Query) OrderBy(field SortField, descending bool) *Query {
+	q.sortBy = field
+	q.descending = descending
+	return q
+}
+
+// Limit sets the page size. Zero returns all matching resources.
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+// After continues from the NextCursor of a previous page
+func (q *Query) After(cursor string) *Query {
+	q.cursor = cursor
+	return q
+}
+
+// Find runs the query and returns a page of matching resources
+func (q *Query) Find(ctx context.Context) (*Page, error) {
+	if _, err := ParseSortField(string(q.sortBy)); err != nil {
+		return nil, err
+	}
+	if q.limit < 0 {
+		return nil, fmt.Errorf("invalid limit %d", q.limit)
+	}
+
+	db, err := q.build(q.store.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []Resource
+	if err := db.Preload("Tags").Find(&resources).Error; err != nil {
+		return nil, fmt.Errorf("failed to query resources: %w", err)
+	}
+
+	page := &Page{Resources: resources}
+	if q.limit > 0 && len(resources) > q.limit {
+		page.Resources = resources[:q.limit]
+		last := page.Resources[q.limit-1]
+		page.NextCursor = encodeCursor(cursor{Value: sortValue(&last, q.sortBy), ID: last.ID})
+	}
+	return page, nil
+}
+
+// Count returns the number of resources matching the query's filters,
+// ignoring pagination
+func (q *Query) Count(ctx context.Context) (int64, error) {
+	db := q.filter(q.store.db.WithContext(ctx).Model(&Resource{}))
+
+	var count int64
+	if err := db.Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count resources: %w", err)
+	}
+	return count, nil
+}
+
+func (q *Query) filter(db *gorm.DB) *gorm.DB {
+	db = db.Where("workspace = ?", q.store.workspace)
+	if q.provider != "" {
+		db = db.Where("provider = ?", q.provider)
+	}
+	if q.resourceType != "" {
+		db = db.Where("type = ?", q.resourceType)
+	}
+	if q.status != "" {
+		db = db.Where("status = ?", q.status)
+	}
+	if q.configApplied != nil {
+		db = db.Where("config_applied = ?", *q.configApplied)
+	}
+	for key, value := range q.tags {
+		if value == nil {
+			db = db.Where("id IN (SELECT resource_id FROM resource_tags WHERE workspace = ? AND key = ?)", q.store.workspace, key)
+		} else {
+			db = db.Where("id IN (SELECT resource_id FROM resource_tags WHERE workspace = ? AND key = ? AND value = ?)", q.store.workspace, key, *value)
+		}
+	}
+	return db
+}
+
+func (q *Query) build(db *gorm.DB) (*gorm.DB, error) {
+	db = q.filter(db)
+
+	col := string(q.sortBy)
+	dir, cmp := "ASC", ">"
+	if q.descending {
+		dir, cmp = "DESC", "<"
+	}
+
+	if q.cursor != "" {
+		c, err := decodeCursor(q.cursor)
+		if err != nil {
+			return nil, err
+		}
+		if q.sortBy == SortByID {
+			db = db.Where(fmt.Sprintf("id %s ?", cmp), c.ID)
+		} else {
+			db = db.Where(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", col, cmp), c.Value, c.Value, c.ID)
+		}
+	}
+
+	if q.sortBy == SortByID {
+		db = db.Order("id " + dir)
+	} else {
+		db = db.Order(fmt.Sprintf("%s %s, id %s", col, dir, dir))
+	}
+
+	if q.limit > 0 {
+		// Fetch one extra row to learn whether another page follows
+		db = db.Limit(q.limit + 1)
+	}
+	return db, nil
+}
+
+func sortValue(r *Resource, field SortField) string {
+	switch field {
+	case SortByName:
+		return r.Name
+	case SortByType:
+		return r.Type
+	case SortByProvider:
+		return r.Provider
+	case SortByStatus:
+		return r.Status
+	case SortByLastUpdated:
+		return r.LastUpdated
+	default:
+		return r.ID
+	}
+}
+
+func encodeCursor(c cursor) string {
+	// Marshalling two strings cannot fail
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,157 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "query.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		resource := &Resource{
+			ID:            fmt.Sprintf("res-%02d", i),
+			Type:          "instance",
+			Name:          fmt.Sprintf("node-%d", 9-i),
+			Provider:      "aws",
+			Status:        "running",
+			ConfigApplied: i%2 == 0,
+		}
+		if i >= 7 {
+			resource.Type = "volume"
+			resource.Provider = "local"
+		}
+		team := "web"
+		if i%3 == 0 {
+			team = "data"
+		}
+		resource.SetTags(map[string]string{"team": team, "index": fmt.Sprint(i)})
+
+		if err := store.SaveResource(ctx, resource); err != nil {
+			t.Fatalf("Failed to save resource: %v", err)
+		}
+	}
+
+	ids := func(resources []Resource) []string {
+		out := make([]string, len(resources))
+		for i, r := range resources {
+			out[i] = r.ID
+		}
+		return out
+	}
+
+	t.Run("Filters", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			query *Query
+			want  int
+		}{
+			{"All", store.Query(), 10},
+			{"Provider", store.Query().Provider("aws"), 7},
+			{"Type", store.Query().Type("volume"), 3},
+			{"Status", store.Query().Status("stopped"), 0},
+			{"ConfigApplied", store.Query().ConfigApplied(true), 5},
+			{"Tag", store.Query().Tag("team", "data"), 4},
+			{"HasTag", store.Query().HasTag("index"), 10},
+			{"Combined", store.Query().Provider("aws").Tag("team", "web").ConfigApplied(false), 2},
+		}
+
+		for _, tt := range tests {
+			page, err := tt.query.Find(ctx)
+			if err != nil {
+				t.Fatalf("%s: failed to query: %v", tt.name, err)
+			}
+			if len(page.Resources) != tt.want {
+				t.Errorf("%s: expected %d resources, got %d (%v)", tt.name, tt.want, len(page.Resources), ids(page.Resources))
+			}
+			count, err := tt.query.Count(ctx)
+			if err != nil {
+				t.Fatalf("%s: failed to count: %v", tt.name, err)
+			}
+			if count != int64(tt.want) {
+				t.Errorf("%s: expected count %d, got %d", tt.name, tt.want, count)
+			}
+		}
+	})
+
+	t.Run("TagsArePreloaded", func(t *testing.T) {
+		page, err := store.Query().Tag("index", "3").Find(ctx)
+		if err != nil {
+			t.Fatalf("Failed to query: %v", err)
+		}
+		if len(page.Resources) != 1 {
+			t.Fatalf("Expected 1 resource, got %d", len(page.Resources))
+		}
+		if tags := page.Resources[0].TagMap(); tags["team"] != "data" {
+			t.Errorf("Expected team tag data, got %v", tags)
+		}
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		for _, desc := range []bool{false, true} {
+			var seen []string
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > 10 {
+					t.Fatal("Pagination did not terminate")
+				}
+				page, err := store.Query().OrderBy(SortByName, desc).Limit(3).After(cursor).Find(ctx)
+				if err != nil {
+					t.Fatalf("Failed to query page: %v", err)
+				}
+				seen = append(seen, ids(page.Resources)...)
+				if page.NextCursor == "" {
+					break
+				}
+				cursor = page.NextCursor
+			}
+
+			if len(seen) != 10 {
+				t.Fatalf("Expected 10 resources across pages, got %d: %v", len(seen), seen)
+			}
+			// Names run in the opposite order to IDs
+			first, last := "res-09", "res-00"
+			if desc {
+				first, last = last, first
+			}
+			if seen[0] != first || seen[9] != last {
+				t.Errorf("Unexpected order (desc=%v): %v", desc, seen)
+			}
+		}
+	})
+
+	t.Run("InvalidInput", func(t *testing.T) {
+		if _, err := store.Query().After("not a cursor!").Find(ctx); err == nil {
+			t.Error("Expected error for invalid cursor, got nil")
+		}
+		if _, err := store.Query().OrderBy("metadata", false).Find(ctx); err == nil {
+			t.Error("Expected error for unknown sort field, got nil")
+		}
+	})
+
+	t.Run("SaveReplacesTags", func(t *testing.T) {
+		resource, err := store.GetResource(ctx, "res-00")
+		if err != nil {
+			t.Fatalf("Failed to get resource: %v", err)
+		}
+		resource.SetTags(map[string]string{"team": "ops"})
+		if err := store.SaveResource(ctx, resource); err != nil {
+			t.Fatalf("Failed to save resource: %v", err)
+		}
+
+		resource, err = store.GetResource(ctx, "res-00")
+		if err != nil {
+			t.Fatalf("Failed to get resource: %v", err)
+		}
+		if tags := resource.TagMap(); len(tags) != 1 || tags["team"] != "ops" {
+			t.Errorf("Expected tags to be replaced, got %v", tags)
+		}
+	})
+}
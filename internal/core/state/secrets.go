@@ -0,0 +1,160 @@
+package state
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNoSecretKey is returned when sensitive values are sealed or opened by
+// a store that has no key source
+var ErrNoSecretKey = errors.New("no key to encrypt sensitive state with")
+
+// secretsSaltKey is the settings key holding the salt the secrets key is
+// derived with. The salt is not secret; it keeps the same passphrase from
+// giving the same key for every database.
+const secretsSaltKey = "secrets_salt"
+
+// KeySource returns the passphrase sensitive values in state are encrypted
+// with. It is called at most once, the first time a secret is sealed or
+// opened.
+type KeySource func() (string, error)
+
+// secretBox holds the cipher for a database's secrets, shared by every
+// workspace of the store and derived on first use
+type secretBox struct {
+	source KeySource
+	aead   cipher.AEAD
+	err    error
+	once   sync.Once
+}
+
+// SetKeySource sets where the store gets the passphrase for sensitive
+// values from, for it and every store WithWorkspace returns. Stores
+// without one can read and write everything else. It must be called
+// before any secret is sealed or opened.
+func (s *Store) SetKeySource(source KeySource) {
+	s.secrets.source = source
+}
+
+// SealSecrets encrypts values into the resource's Secrets, replacing any
+// there already. An empty map clears them.
+func (s *Store) SealSecrets(ctx context.Context, r *Resource, values map[string]interface{}) error {
+	if len(values) == 0 {
+		r.Secrets = nil
+		return nil
+	}
+	aead, err := s.cipher(ctx)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("failed to encode secrets for %s: %w", r.ID, err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to seal secrets for %s: %w", r.ID, err)
+	}
+	// The resource ID is authenticated so sealed values cannot be moved
+	// between resources
+	r.Secrets = aead.Seal(nonce, nonce, plaintext, []byte(r.ID))
+	return nil
+}
+
+// OpenSecrets decrypts the resource's sensitive values. It returns nil for
+// resources without any.
+func (s *Store) OpenSecrets(ctx context.Context, r *Resource) (map[string]interface{}, error) {
+	if len(r.Secrets) == 0 {
+		return nil, nil
+	}
+	aead, err := s.cipher(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.Secrets) < aead.NonceSize() {
+		return nil, fmt.Errorf("failed to open secrets for %s: value is truncated", r.ID)
+	}
+	nonce, sealed := r.Secrets[:aead.NonceSize()], r.Secrets[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, []byte(r.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open secrets for %s: wrong key or corrupted state", r.ID)
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, fmt.Errorf("failed to decode secrets for %s: %w", r.ID, err)
+	}
+	return values, nil
+}
+
+// cipher returns the AES-GCM cipher for the store's secrets, deriving its
+// key from the key source and the database's salt the first time
+func (s *Store) cipher(ctx context.Context) (cipher.AEAD, error) {
+	box := s.secrets
+	box.once.Do(func() {
+		source := box.source
+		if source == nil {
+			box.err = ErrNoSecretKey
+			return
+		}
+		passphrase, err := source()
+		if err != nil {
+			box.err = fmt.Errorf("failed to get state key: %w", err)
+			return
+		}
+		if passphrase == "" {
+			box.err = ErrNoSecretKey
+			return
+		}
+		salt, err := s.secretsSalt(ctx)
+		if err != nil {
+			box.err = err
+			return
+		}
+		key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+		if err != nil {
+			box.err = fmt.Errorf("failed to derive state key: %w", err)
+			return
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			box.err = fmt.Errorf("failed to derive state key: %w", err)
+			return
+		}
+		box.aead, box.err = cipher.NewGCM(block)
+	})
+	return box.aead, box.err
+}
+
+// secretsSalt returns the database's salt, creating it if needed
+func (s *Store) secretsSalt(ctx context.Context) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to create state key salt: %w", err)
+	}
+	// A salt written by another process first wins
+	err := s.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&setting{Key: secretsSaltKey, Value: hex.EncodeToString(salt)}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to save state key salt: %w", err)
+	}
+
+	var stored setting
+	if err := s.db.WithContext(ctx).First(&stored, "key = ?", secretsSaltKey).Error; err != nil {
+		return nil, fmt.Errorf("failed to read state key salt: %w", err)
+	}
+	return hex.DecodeString(stored.Value)
+}
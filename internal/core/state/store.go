@@ -3,60 +3,175 @@ package state
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/rebelopsio/duet/pkg/types"
 )
 
+// Store is the state database, scoped to a single workspace. Use
+// WithWorkspace to work with another partition of the same database.
 type Store struct {
-	db *gorm.DB
+	db        *gorm.DB
+	secrets   *secretBox
+	workspace string
 }
 
 type Resource struct {
+	Workspace     string `gorm:"primaryKey"`
 	ID            string `gorm:"primaryKey"`
 	Type          string
 	Name          string
 	Provider      string
+	Alias         string
 	Status        string
 	LastUpdated   string
 	Metadata      []byte
+	Config        []byte
+	Secrets       []byte
+	Tags          []ResourceTag `gorm:"foreignKey:Workspace,ResourceID;references:Workspace,ID"`
+	Dependencies  []string      `gorm:"serializer:json"`
 	ConfigApplied bool
 }
 
+// ResourceTag is a single key/value tag attached to a resource
+type ResourceTag struct {
+	Workspace  string `gorm:"primaryKey"`
+	ResourceID string `gorm:"primaryKey"`
+	Key        string `gorm:"primaryKey"`
+	Value      string
+}
+
+// TagMap returns the resource's tags as a map
+func (r *Resource) TagMap() map[string]string {
+	tags := make(map[string]string, len(r.Tags))
+	for _, t := range r.Tags {
+		tags[t.Key] = t.Value
+	}
+	return tags
+}
+
+// SetTags replaces the resource's tags with the given map
+func (r *Resource) SetTags(tags map[string]string) {
+	r.Tags = make([]ResourceTag, 0, len(tags))
+	for k, v := range tags {
+		r.Tags = append(r.Tags, ResourceTag{Workspace: r.Workspace, ResourceID: r.ID, Key: k, Value: v})
+	}
+	sort.Slice(r.Tags, func(i, j int) bool { return r.Tags[i].Key < r.Tags[j].Key })
+}
+
+// ToResource converts the stored record into a types.Resource that can be
+// handed back to its provider
+func (r *Resource) ToResource() (*types.BaseResource, error) {
+	metadata := make(map[string]interface{})
+	if len(r.Metadata) > 0 {
+		if err := json.Unmarshal(r.Metadata, &metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode metadata for %s: %w", r.ID, err)
+		}
+	}
+
+	// Records written before timestamps were normalised may not parse
+	updated, _ := time.Parse(time.RFC3339, r.LastUpdated)
+
+	return &types.BaseResource{
+		ID:        r.ID,
+		Type:      types.ResourceType(r.Type),
+		Provider:  r.Provider,
+		Status:    types.ResourceStatus(r.Status),
+		Metadata:  metadata,
+		Tags:      r.TagMap(),
+		UpdatedAt: updated,
+	}, nil
+}
+
+// NewStore opens the state database and applies any pending migrations
 func NewStore(dbPath string) (*Store, error) {
+	store, err := Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.Migrate(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return store, nil
+}
+
+// Open opens the state database without migrating it. It fails with
+// ErrSchemaTooNew if the database was written by a newer version of duet.
+func Open(dbPath string) (*Store, error) {
 	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	if err := db.AutoMigrate(&Resource{}); err != nil {
-		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	if err := checkSchemaVersion(db); err != nil {
+		return nil, err
 	}
 
-	return &Store{db: db}, nil
+	return &Store{db: db, secrets: &secretBox{}, workspace: DefaultWorkspace}, nil
 }
 
 // GetResources retrieves all resources from the store
 func (s *Store) GetResources(ctx context.Context) ([]Resource, error) {
 	var resources []Resource
-	result := s.db.WithContext(ctx).Find(&resources)
+	result := s.db.WithContext(ctx).Preload("Tags").Find(&resources, "workspace = ?", s.workspace)
 	if result.Error != nil {
 		return nil, fmt.Errorf("failed to get resources: %w", result.Error)
 	}
 	return resources, nil
 }
 
-// SaveResource saves a resource to the store
+// SaveResource saves a resource to the store, replacing its tags
 func (s *Store) SaveResource(ctx context.Context, resource *Resource) error {
-	result := s.db.WithContext(ctx).Save(resource)
-	return result.Error
+	resource.Workspace = s.workspace
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return saveResource(tx, resource)
+	})
+}
+
+// SaveResources saves resources to the store in one transaction, so either
+// all of them are saved or none are
+func (s *Store) SaveResources(ctx context.Context, resources []*Resource) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, resource := range resources {
+			resource.Workspace = s.workspace
+			if err := saveResource(tx, resource); err != nil {
+				return fmt.Errorf("failed to save %s: %w", resource.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+func saveResource(tx *gorm.DB, resource *Resource) error {
+	if err := tx.Omit(clause.Associations).Save(resource).Error; err != nil {
+		return err
+	}
+	if err := tx.Delete(&ResourceTag{}, "workspace = ? AND resource_id = ?", resource.Workspace, resource.ID).Error; err != nil {
+		return err
+	}
+	if len(resource.Tags) == 0 {
+		return nil
+	}
+	for i := range resource.Tags {
+		resource.Tags[i].Workspace = resource.Workspace
+		resource.Tags[i].ResourceID = resource.ID
+	}
+	return tx.Create(&resource.Tags).Error
 }
 
 // GetResource retrieves a single resource by ID
 func (s *Store) GetResource(ctx context.Context, id string) (*Resource, error) {
 	var resource Resource
-	result := s.db.WithContext(ctx).First(&resource, "id = ?", id)
+	result := s.db.WithContext(ctx).Preload("Tags").First(&resource, "workspace = ? AND id = ?", s.workspace, id)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -65,6 +180,14 @@ func (s *Store) GetResource(ctx context.Context, id string) (*Resource, error) {
 
 // DeleteResource removes a resource from the store
 func (s *Store) DeleteResource(ctx context.Context, id string) error {
-	result := s.db.WithContext(ctx).Delete(&Resource{}, "id = ?", id)
-	return result.Error
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return deleteResource(tx, s.workspace, id)
+	})
+}
+
+func deleteResource(tx *gorm.DB, workspace, id string) error {
+	if err := tx.Delete(&ResourceTag{}, "workspace = ? AND resource_id = ?", workspace, id).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&Resource{}, "workspace = ? AND id = ?", workspace, id).Error
 }
@@ -112,4 +112,29 @@ func TestStore(t *testing.T) {
 			t.Errorf("Expected 1 resource after deletion, got %d", len(resources))
 		}
 	})
+
+	t.Run("SaveResources", func(t *testing.T) {
+		valid := &Resource{ID: "test-resource-3", Type: "test", Name: "Test Resource 3", Provider: "test-provider"}
+		// A repeated tag key breaks the tags' primary key
+		invalid := &Resource{ID: "test-resource-4", Type: "test", Name: "Test Resource 4", Provider: "test-provider",
+			Tags: []ResourceTag{{Key: "team", Value: "a"}, {Key: "team", Value: "b"}}}
+		if err := store.SaveResources(ctx, []*Resource{valid, invalid}); err == nil {
+			t.Fatal("Expected error saving a resource with repeated tags, got nil")
+		}
+		if _, err := store.GetResource(ctx, valid.ID); err == nil {
+			t.Error("Expected no resource to be saved when one fails, got test-resource-3")
+		}
+
+		invalid.SetTags(map[string]string{"team": "a"})
+		if err := store.SaveResources(ctx, []*Resource{valid, invalid}); err != nil {
+			t.Fatalf("Failed to save resources: %v", err)
+		}
+		resources, err := store.GetResources(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get resources: %v", err)
+		}
+		if len(resources) != 3 {
+			t.Errorf("Expected 3 resources, got %d", len(resources))
+		}
+	})
 }
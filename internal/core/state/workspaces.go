@@ -0,0 +1,163 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultWorkspace always exists and holds state created before workspaces
+const DefaultWorkspace = "default"
+
+// selectedWorkspaceKey is the settings key holding the selected workspace
+const selectedWorkspaceKey = "workspace"
+
+// ErrWorkspaceNotFound is returned for operations on unknown workspaces
+var ErrWorkspaceNotFound = errors.New("workspace not found")
+
+var workspaceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// Workspace is an isolated partition of state sharing one configuration
+type Workspace struct {
+	CreatedAt time.Time
+	Name      string `gorm:"primaryKey"`
+}
+
+// setting is a key/value pair in the settings table
+type setting struct {
+	Key   string `gorm:"primaryKey"`
+	Value string
+}
+
+// Workspace returns the name of the workspace the store is scoped to
+func (s *Store) Workspace() string {
+	return s.workspace
+}
+
+// WithWorkspace returns a store sharing the same database, scoped to the
+// named workspace. It does not check that the workspace exists.
+func (s *Store) WithWorkspace(name string) *Store {
+	return &Store{db: s.db, secrets: s.secrets, workspace: name}
+}
+
+// ValidateWorkspaceName checks that name can be used as a workspace name
+func ValidateWorkspaceName(name string) error {
+	if !workspaceNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid workspace name %q: use letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// ListWorkspaces returns all workspaces ordered by name
+func (s *Store) ListWorkspaces(ctx context.Context) ([]Workspace, error) {
+	var workspaces []Workspace
+	if err := s.db.WithContext(ctx).Order("name").Find(&workspaces).Error; err != nil {
+		return nil, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+	return workspaces, nil
+}
+
+// GetWorkspace returns the named workspace, or ErrWorkspaceNotFound
+func (s *Store) GetWorkspace(ctx context.Context, name string) (*Workspace, error) {
+	var workspaces []Workspace
+	if err := s.db.WithContext(ctx).Limit(1).Find(&workspaces, "name = ?", name).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workspace %s: %w", name, err)
+	}
+	if len(workspaces) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrWorkspaceNotFound, name)
+	}
+	return &workspaces[0], nil
+}
+
+// CreateWorkspace adds a new, empty workspace
+func (s *Store) CreateWorkspace(ctx context.Context, name string) error {
+	if err := ValidateWorkspaceName(name); err != nil {
+		return err
+	}
+
+	result := s.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Workspace{Name: name, CreatedAt: time.Now().UTC()})
+	if result.Error != nil {
+		return fmt.Errorf("failed to create workspace %s: %w", name, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("workspace %s already exists", name)
+	}
+	return nil
+}
+
+// DeleteWorkspace removes a workspace and returns the resources it still
+// tracked. It refuses to delete the default or selected workspace, or one
+// that still tracks resources unless force is set. Forcing only forgets the
+// resources; it does not destroy them.
+func (s *Store) DeleteWorkspace(ctx context.Context, name string, force bool) ([]Resource, error) {
+	if name == DefaultWorkspace {
+		return nil, fmt.Errorf("the %s workspace cannot be deleted", DefaultWorkspace)
+	}
+	if _, err := s.GetWorkspace(ctx, name); err != nil {
+		return nil, err
+	}
+
+	selected, err := s.SelectedWorkspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if name == selected {
+		return nil, fmt.Errorf("workspace %s is selected; select another workspace first", name)
+	}
+
+	var forgotten []Resource
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Order("id").Find(&forgotten, "workspace = ?", name).Error; err != nil {
+			return fmt.Errorf("failed to list resources in workspace %s: %w", name, err)
+		}
+		if len(forgotten) > 0 && !force {
+			return fmt.Errorf("workspace %s still tracks %d resource(s); destroy them first or force deletion", name, len(forgotten))
+		}
+
+		for _, model := range []interface{}{&ResourceTag{}, &Resource{}, &Intent{}} {
+			if err := tx.Where("workspace = ?", name).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&Workspace{}, "name = ?", name).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return forgotten, nil
+}
+
+// SelectedWorkspace returns the workspace chosen with SelectWorkspace, or
+// the default workspace if none has been selected
+func (s *Store) SelectedWorkspace(ctx context.Context) (string, error) {
+	var settings []setting
+	if err := s.db.WithContext(ctx).Limit(1).Find(&settings, "key = ?", selectedWorkspaceKey).Error; err != nil {
+		return "", fmt.Errorf("failed to read selected workspace: %w", err)
+	}
+	if len(settings) == 0 || settings[0].Value == "" {
+		return DefaultWorkspace, nil
+	}
+	return settings[0].Value, nil
+}
+
+// SelectWorkspace records the workspace later runs should use
+func (s *Store) SelectWorkspace(ctx context.Context, name string) error {
+	if _, err := s.GetWorkspace(ctx, name); err != nil {
+		return err
+	}
+
+	err := s.db.WithContext(ctx).
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&setting{Key: selectedWorkspaceKey, Value: name}).Error
+	if err != nil {
+		return fmt.Errorf("failed to select workspace %s: %w", name, err)
+	}
+	return nil
+}
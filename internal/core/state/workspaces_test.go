@@ -0,0 +1,154 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkspaces(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "workspaces.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	ctx := context.Background()
+
+	t.Run("DefaultExists", func(t *testing.T) {
+		if store.Workspace() != DefaultWorkspace {
+			t.Errorf("Expected store to start in %s, got %s", DefaultWorkspace, store.Workspace())
+		}
+		selected, err := store.SelectedWorkspace(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get selected workspace: %v", err)
+		}
+		if selected != DefaultWorkspace {
+			t.Errorf("Expected %s to be selected, got %s", DefaultWorkspace, selected)
+		}
+	})
+
+	t.Run("CreateAndList", func(t *testing.T) {
+		for _, name := range []string{"staging", "prod"} {
+			if err := store.CreateWorkspace(ctx, name); err != nil {
+				t.Fatalf("Failed to create workspace %s: %v", name, err)
+			}
+		}
+		if err := store.CreateWorkspace(ctx, "prod"); err == nil {
+			t.Error("Expected error creating duplicate workspace, got nil")
+		}
+		if err := store.CreateWorkspace(ctx, "../prod"); err == nil {
+			t.Error("Expected error for invalid workspace name, got nil")
+		}
+
+		workspaces, err := store.ListWorkspaces(ctx)
+		if err != nil {
+			t.Fatalf("Failed to list workspaces: %v", err)
+		}
+		var names []string
+		for _, w := range workspaces {
+			names = append(names, w.Name)
+		}
+		if len(names) != 3 || names[0] != "default" || names[1] != "prod" || names[2] != "staging" {
+			t.Errorf("Unexpected workspaces: %v", names)
+		}
+	})
+
+	t.Run("Isolation", func(t *testing.T) {
+		staging := store.WithWorkspace("staging")
+		prod := store.WithWorkspace("prod")
+
+		// The same provider ID may exist in two workspaces
+		for _, ws := range []*Store{staging, prod} {
+			resource := &Resource{ID: "shared-id", Type: "instance", Name: ws.Workspace()}
+			resource.SetTags(map[string]string{"env": ws.Workspace()})
+			if err := ws.SaveResource(ctx, resource); err != nil {
+				t.Fatalf("Failed to save resource in %s: %v", ws.Workspace(), err)
+			}
+		}
+
+		for _, ws := range []*Store{staging, prod} {
+			resources, err := ws.GetResources(ctx)
+			if err != nil {
+				t.Fatalf("Failed to get resources: %v", err)
+			}
+			if len(resources) != 1 || resources[0].Name != ws.Workspace() {
+				t.Errorf("Expected only %s's resource, got %+v", ws.Workspace(), resources)
+			}
+			if env := resources[0].TagMap()["env"]; env != ws.Workspace() {
+				t.Errorf("Expected env tag %s, got %s", ws.Workspace(), env)
+			}
+
+			page, err := ws.Query().Tag("env", "prod").Find(ctx)
+			if err != nil {
+				t.Fatalf("Failed to query: %v", err)
+			}
+			want := 0
+			if ws.Workspace() == "prod" {
+				want = 1
+			}
+			if len(page.Resources) != want {
+				t.Errorf("Expected %d tagged resources in %s, got %d", want, ws.Workspace(), len(page.Resources))
+			}
+		}
+
+		if err := staging.DeleteResource(ctx, "shared-id"); err != nil {
+			t.Fatalf("Failed to delete resource: %v", err)
+		}
+		if _, err := prod.GetResource(ctx, "shared-id"); err != nil {
+			t.Errorf("Deleting from staging removed prod's resource: %v", err)
+		}
+
+		resources, err := store.GetResources(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get resources: %v", err)
+		}
+		if len(resources) != 0 {
+			t.Errorf("Expected default workspace to be empty, got %d resources", len(resources))
+		}
+	})
+
+	t.Run("Select", func(t *testing.T) {
+		if err := store.SelectWorkspace(ctx, "missing"); !errors.Is(err, ErrWorkspaceNotFound) {
+			t.Errorf("Expected ErrWorkspaceNotFound, got %v", err)
+		}
+		if err := store.SelectWorkspace(ctx, "prod"); err != nil {
+			t.Fatalf("Failed to select workspace: %v", err)
+		}
+		selected, err := store.SelectedWorkspace(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get selected workspace: %v", err)
+		}
+		if selected != "prod" {
+			t.Errorf("Expected prod to be selected, got %s", selected)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if _, err := store.DeleteWorkspace(ctx, DefaultWorkspace, true); err == nil {
+			t.Error("Expected error deleting the default workspace, got nil")
+		}
+		if _, err := store.DeleteWorkspace(ctx, "prod", true); err == nil {
+			t.Error("Expected error deleting the selected workspace, got nil")
+		}
+		if err := store.SelectWorkspace(ctx, "staging"); err != nil {
+			t.Fatalf("Failed to select workspace: %v", err)
+		}
+		if _, err := store.DeleteWorkspace(ctx, "prod", false); err == nil {
+			t.Error("Expected error deleting a workspace with resources, got nil")
+		}
+		forgotten, err := store.DeleteWorkspace(ctx, "prod", true)
+		if err != nil {
+			t.Fatalf("Failed to force-delete workspace: %v", err)
+		}
+		if len(forgotten) != 1 || forgotten[0].ID != "shared-id" {
+			t.Errorf("Expected the forgotten resource to be returned, got %+v", forgotten)
+		}
+		if _, err := store.GetWorkspace(ctx, "prod"); !errors.Is(err, ErrWorkspaceNotFound) {
+			t.Errorf("Expected prod to be gone, got %v", err)
+		}
+		if _, err := store.WithWorkspace("prod").GetResource(ctx, "shared-id"); err == nil {
+			t.Error("Expected prod's resources to be forgotten")
+		}
+	})
+}
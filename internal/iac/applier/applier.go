@@ -0,0 +1,422 @@
+package applier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/planner"
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// ProviderSource looks up providers by key, as returned by
+// planner.ProviderKey
+type ProviderSource interface {
+	Provider(key string) (provider.Provider, bool)
+}
+
+// Journal stages, reported to the step hook
+const (
+	stageIntent   = "intent"
+	stageProvider = "provider"
+	stageCommit   = "commit"
+)
+
+// Applier executes a plan against providers, journalling each provider call
+// so an interrupted run can be reconciled by the next one
+type Applier struct {
+	store     *state.Store
+	providers ProviderSource
+	// step is called after each journal stage; tests use it to simulate duet
+	// being killed at that point
+	step    func(stage string, change *planner.Change) error
+	runID   string
+	project string
+}
+
+func NewApplier(store *state.Store, providers ProviderSource, runID string) *Applier {
+	return &Applier{
+		store:     store,
+		providers: providers,
+		runID:     runID,
+		step:      func(string, *planner.Change) error { return nil },
+	}
+}
+
+// SetProject names the project that resources are tagged as belonging to,
+// along with the workspace, their address and the run ID
+func (a *Applier) SetProject(name string) {
+	a.project = name
+}
+
+// Apply executes each change in order, stopping at the first failure. It
+// returns the changes that were attempted, for the audit log.
+func (a *Applier) Apply(ctx context.Context, plan *planner.Plan) ([]state.OperationChange, error) {
+	var changes []state.OperationChange
+	for i := range plan.Changes {
+		change := &plan.Changes[i]
+		if change.Action == types.ChangeTypeNoOp {
+			continue
+		}
+
+		record := state.OperationChange{
+			Type:     change.Type,
+			Name:     change.Name,
+			Provider: change.Provider,
+			Action:   string(change.Action),
+		}
+		if change.Resource != nil {
+			record.ResourceID = change.Resource.GetID()
+		}
+
+		id, err := a.applyChange(ctx, change)
+		if id != "" {
+			record.ResourceID = id
+		}
+		if err != nil {
+			record.Error = err.Error()
+			changes = append(changes, record)
+			return changes, fmt.Errorf("failed to %s %s: %w", change.Action, change.Address(), err)
+		}
+		changes = append(changes, record)
+	}
+	return changes, nil
+}
+
+func (a *Applier) applyChange(ctx context.Context, change *planner.Change) (string, error) {
+	prov, ok := a.providers.Provider(change.ProviderKey())
+	if !ok {
+		return "", fmt.Errorf("unknown provider %q", change.ProviderKey())
+	}
+
+	// Everything a change refers to was applied before it, so references
+	// can now be resolved from state
+	resolved, recorded, err := a.resolve(ctx, change)
+	if err != nil {
+		return "", err
+	}
+	config, err := json.Marshal(recorded)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	intent := &state.Intent{
+		RunID:        a.runID,
+		Action:       string(change.Action),
+		Provider:     change.Provider,
+		Alias:        change.Alias,
+		Type:         change.Type,
+		Name:         change.Name,
+		Config:       config,
+		Dependencies: change.DependsOn,
+	}
+	if change.Resource != nil {
+		intent.ResourceID = change.Resource.GetID()
+	}
+	if change.Action == types.ChangeTypeCreate {
+		intent.Token = state.NewRunID()
+	}
+
+	if err := a.store.BeginIntent(ctx, intent); err != nil {
+		return "", err
+	}
+	if err := a.step(stageIntent, change); err != nil {
+		return "", err
+	}
+
+	ctx = provider.WithOwnership(ctx, provider.Ownership{
+		Project:   a.project,
+		Workspace: a.store.Workspace(),
+		Address:   change.Address(),
+		RunID:     a.runID,
+	})
+
+	var result provider.Resource
+	switch change.Action {
+	case types.ChangeTypeCreate:
+		result, err = prov.Create(provider.WithIdempotencyToken(ctx, intent.Token), change.Type, resolved)
+	case types.ChangeTypeUpdate:
+		if err = prov.Update(ctx, change.Resource, resolved); err == nil {
+			result, err = prov.Read(ctx, change.Type, change.Resource.GetID())
+		}
+	case types.ChangeTypeDelete:
+		err = prov.Delete(ctx, change.Resource)
+	default:
+		err = fmt.Errorf("unknown change type %q", change.Action)
+	}
+	if err != nil {
+		if result != nil && change.Action == types.ChangeTypeCreate {
+			// The resource was created but never became ready; keep the
+			// intent so the next run finds it by token instead of leaking it
+			return result.GetID(), err
+		}
+		// The call failed cleanly, so there is nothing to reconcile later
+		if abandonErr := a.store.AbandonIntent(ctx, intent); abandonErr != nil {
+			return "", errors.Join(err, abandonErr)
+		}
+		return "", err
+	}
+	if err := a.step(stageProvider, change); err != nil {
+		if result != nil {
+			return result.GetID(), err
+		}
+		return "", err
+	}
+
+	var record *state.Resource
+	if result != nil {
+		record, err = a.toStateResource(ctx, prov, result, intent)
+		if err != nil {
+			return result.GetID(), err
+		}
+	}
+	if err := a.store.CommitIntent(ctx, intent, record); err != nil {
+		return "", err
+	}
+	if err := a.step(stageCommit, change); err != nil {
+		return "", err
+	}
+
+	if record != nil {
+		return record.ID, nil
+	}
+	return intent.ResourceID, nil
+}
+
+// resolve replaces the references in a change's config with values from
+// state. It returns the config for the provider, and the config to record,
+// which keeps references to sensitive attributes as written so state does
+// not hold them in the clear. Deletes need no config.
+func (a *Applier) resolve(ctx context.Context, change *planner.Change) (map[string]interface{}, map[string]interface{}, error) {
+	if change.Action == types.ChangeTypeDelete || len(change.DependsOn) == 0 {
+		return change.Config, change.Config, nil
+	}
+
+	resources, err := a.store.GetResources(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get resources: %w", err)
+	}
+	byAddress := make(map[string]*state.Resource, len(resources))
+	for i := range resources {
+		r := &resources[i]
+		byAddress[r.Provider+"."+r.Type+"."+r.Name] = r
+	}
+	lookup := func(address string) (*state.Resource, bool) {
+		r, ok := byAddress[address]
+		return r, ok
+	}
+
+	resolved, complete, err := planner.ResolveSecrets(change.Config, lookup, func(r *state.Resource) (map[string]interface{}, error) {
+		return a.store.OpenSecrets(ctx, r)
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", change.Address(), err)
+	}
+	if !complete {
+		return nil, nil, fmt.Errorf("%s refers to a resource that has not been created", change.Address())
+	}
+	recorded, _, err := planner.Resolve(change.Config, lookup)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", change.Address(), err)
+	}
+	return resolved, recorded, nil
+}
+
+// Recover reconciles intents left behind by interrupted runs, asking each
+// provider what actually happened. It returns a change record per intent.
+func (a *Applier) Recover(ctx context.Context) ([]state.OperationChange, error) {
+	intents, err := a.store.PendingIntents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []state.OperationChange
+	for i := range intents {
+		intent := &intents[i]
+		record := state.OperationChange{
+			ResourceID: intent.ResourceID,
+			Type:       intent.Type,
+			Name:       intent.Name,
+			Provider:   intent.Provider,
+			Action:     "reconcile-" + intent.Action,
+		}
+
+		id, err := a.reconcile(ctx, intent)
+		if id != "" {
+			record.ResourceID = id
+		}
+		if err != nil {
+			record.Error = err.Error()
+			changes = append(changes, record)
+			return changes, fmt.Errorf("failed to reconcile %s of %s.%s.%s from run %s: %w",
+				intent.Action, intent.Provider, intent.Type, intent.Name, intent.RunID, err)
+		}
+		changes = append(changes, record)
+	}
+	return changes, nil
+}
+
+func (a *Applier) reconcile(ctx context.Context, intent *state.Intent) (string, error) {
+	key := planner.ProviderKey(intent.Provider, intent.Alias)
+	prov, ok := a.providers.Provider(key)
+	if !ok {
+		return "", fmt.Errorf("unknown provider %q", key)
+	}
+
+	switch intent.Action {
+	case state.IntentCreate:
+		found, err := a.findCreated(ctx, prov, intent)
+		if errors.Is(err, errUnknownOutcome) {
+			return "", fmt.Errorf("provider %s cannot look up what an interrupted create made, so whether it happened is unknown; "+
+				"check for the resource, then clear the journal entry with `duet state intents abandon %d`", key, intent.ID)
+		}
+		if errors.Is(err, provider.ErrNotFound) {
+			// The create never reached the provider
+			return "", a.store.AbandonIntent(ctx, intent)
+		}
+		if err != nil {
+			return "", err
+		}
+		// Only adopt what this configuration created. Providers that tag
+		// resources stamped the ownership tags on it along with the token.
+		if tags := found.GetTags(); len(tags) > 0 {
+			owner := provider.Ownership{
+				Project:   a.project,
+				Workspace: a.store.Workspace(),
+				Address:   intent.Provider + "." + intent.Type + "." + intent.Name,
+			}
+			if err := owner.Check(tags); err != nil {
+				return found.GetID(), fmt.Errorf("refusing to adopt %s: %w", found.GetID(), err)
+			}
+		}
+		record, err := a.toStateResource(ctx, prov, found, intent)
+		if err != nil {
+			return found.GetID(), err
+		}
+		return record.ID, a.store.CommitIntent(ctx, intent, record)
+
+	case state.IntentUpdate:
+		current, err := prov.Read(ctx, intent.Type, intent.ResourceID)
+		if errors.Is(err, provider.ErrNotFound) {
+			return intent.ResourceID, a.store.CommitIntent(ctx, intent, nil)
+		}
+		if err != nil {
+			return intent.ResourceID, err
+		}
+		// Whether the update landed is unknown, so keep the previously applied
+		// config; the next plan will re-apply the update if it is still needed
+		previous, err := a.store.GetResource(ctx, intent.ResourceID)
+		if err != nil {
+			return intent.ResourceID, err
+		}
+		record, err := a.toStateResource(ctx, prov, current, intent)
+		if err != nil {
+			return intent.ResourceID, err
+		}
+		record.Config = previous.Config
+		record.ConfigApplied = previous.ConfigApplied
+		return intent.ResourceID, a.store.CommitIntent(ctx, intent, record)
+
+	case state.IntentDelete:
+		_, err := prov.Read(ctx, intent.Type, intent.ResourceID)
+		if errors.Is(err, provider.ErrNotFound) {
+			return intent.ResourceID, a.store.CommitIntent(ctx, intent, nil)
+		}
+		if err != nil {
+			return intent.ResourceID, err
+		}
+		// Still there; leave it in state so the next plan deletes it again
+		return intent.ResourceID, a.store.AbandonIntent(ctx, intent)
+
+	default:
+		return "", fmt.Errorf("unknown journal action %q", intent.Action)
+	}
+}
+
+// errUnknownOutcome is returned by findCreated for providers that cannot
+// look up what an interrupted create made
+var errUnknownOutcome = errors.New("cannot find created resource")
+
+// findCreated asks the provider for the resource an interrupted create
+// made: by its token, or by the config it was created with where the
+// provider's IDs follow from it. It returns provider.ErrNotFound if there
+// is none.
+func (a *Applier) findCreated(ctx context.Context, prov provider.Provider, intent *state.Intent) (provider.Resource, error) {
+	switch finder := prov.(type) {
+	case provider.TokenFinder:
+		return finder.FindByToken(ctx, intent.Type, intent.Token)
+	case provider.ConfigFinder:
+		var config map[string]interface{}
+		if err := json.Unmarshal(intent.Config, &config); err != nil {
+			return nil, fmt.Errorf("failed to decode config: %w", err)
+		}
+		return finder.FindByConfig(ctx, intent.Type, config)
+	default:
+		return nil, errUnknownOutcome
+	}
+}
+
+// toStateResource builds the state record for a provider result, keeping the
+// address and desired config from the intent. Attributes the provider marks
+// sensitive are sealed into the record's secrets; a result without them,
+// such as a read after an update, keeps those already in state.
+func (a *Applier) toStateResource(ctx context.Context, prov provider.Provider, res provider.Resource, intent *state.Intent) (*state.Resource, error) {
+	attributes := res.GetMetadata()
+	var sensitive []string
+	if marker, ok := prov.(provider.SensitiveMarker); ok {
+		sensitive = marker.SensitiveAttributes(intent.Type)
+	}
+	secrets := make(map[string]interface{})
+	if len(sensitive) > 0 {
+		attributes = maps.Clone(attributes)
+		for _, name := range sensitive {
+			if value, ok := attributes[name]; ok {
+				secrets[name] = value
+				delete(attributes, name)
+			}
+		}
+	}
+
+	metadata, err := json.Marshal(attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata for %s: %w", res.GetID(), err)
+	}
+
+	updated := res.GetUpdatedAt()
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+
+	record := &state.Resource{
+		ID:           res.GetID(),
+		Type:         intent.Type,
+		Name:         intent.Name,
+		Provider:     intent.Provider,
+		Alias:        intent.Alias,
+		Status:       string(res.GetStatus()),
+		LastUpdated:  updated.UTC().Format(time.RFC3339),
+		Metadata:     metadata,
+		Config:       intent.Config,
+		Dependencies: intent.Dependencies,
+	}
+	record.SetTags(res.GetTags())
+
+	switch {
+	case len(secrets) > 0:
+		if err := a.store.SealSecrets(ctx, record, secrets); err != nil {
+			return nil, fmt.Errorf("failed to store sensitive attributes of %s: %w", res.GetID(), err)
+		}
+	case len(sensitive) > 0 && intent.ResourceID != "":
+		previous, err := a.store.GetResource(ctx, intent.ResourceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", intent.ResourceID, err)
+		}
+		record.Secrets = previous.Secrets
+	}
+	return record, nil
+}
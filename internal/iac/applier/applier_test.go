@@ -0,0 +1,643 @@
+package applier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/planner"
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/internal/iac/provider/local"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+var errKilled = errors.New("killed")
+
+// fakeProvider keeps resources in memory and remembers creation tokens
+type fakeProvider struct {
+	resources map[string]*types.BaseResource
+	tokens    map[string]string
+	// owners records the ownership each create and update was made with
+	owners []provider.Ownership
+	// notReady, if set, is returned by Create alongside the created resource
+	notReady error
+	nextID   int
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{
+		resources: make(map[string]*types.BaseResource),
+		tokens:    make(map[string]string),
+	}
+}
+
+func (f *fakeProvider) Name() string { return "fake" }
+
+func (f *fakeProvider) Create(ctx context.Context, resourceType string, config map[string]interface{}) (provider.Resource, error) {
+	if token := provider.IdempotencyToken(ctx); token != "" {
+		if id, ok := f.tokens[token]; ok {
+			return f.resources[id], nil
+		}
+	}
+	f.nextID++
+	res := &types.BaseResource{
+		ID:        fmt.Sprintf("fake-%d", f.nextID),
+		Type:      types.ResourceType(resourceType),
+		Provider:  "fake",
+		Status:    types.StatusRunning,
+		Metadata:  config,
+		Tags:      f.tags(ctx, config),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	f.resources[res.ID] = res
+	f.tokens[provider.IdempotencyToken(ctx)] = res.ID
+	if f.notReady != nil {
+		return res, f.notReady
+	}
+	return res, nil
+}
+
+func (f *fakeProvider) Read(ctx context.Context, resourceType string, id string) (provider.Resource, error) {
+	res, ok := f.resources[id]
+	if !ok {
+		return nil, provider.ErrNotFound
+	}
+	return res, nil
+}
+
+func (f *fakeProvider) Update(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	res, ok := f.resources[resource.GetID()]
+	if !ok {
+		return provider.ErrNotFound
+	}
+	res.Metadata = config
+	res.Tags = f.tags(ctx, config)
+	return nil
+}
+
+// tags returns the tags of a resource with config, stamped like the AWS
+// provider's with the ownership in ctx, which it records
+func (f *fakeProvider) tags(ctx context.Context, config map[string]interface{}) map[string]string {
+	tags := map[string]string{"size": fmt.Sprint(config["size"])}
+	if o, ok := provider.OwnershipFromContext(ctx); ok {
+		f.owners = append(f.owners, o)
+		maps.Copy(tags, o.Tags())
+	}
+	return tags
+}
+
+func (f *fakeProvider) Delete(ctx context.Context, resource provider.Resource) error {
+	delete(f.resources, resource.GetID())
+	return nil
+}
+
+// fakeFinderProvider adds token lookups to fakeProvider
+type fakeFinderProvider struct {
+	*fakeProvider
+}
+
+func (f fakeFinderProvider) FindByToken(ctx context.Context, resourceType string, token string) (provider.Resource, error) {
+	id, ok := f.tokens[token]
+	if !ok {
+		return nil, provider.ErrNotFound
+	}
+	return f.Read(ctx, resourceType, id)
+}
+
+// fakeDriftProvider reports fakeProvider's resources as drifted when they
+// are gone
+type fakeDriftProvider struct {
+	*fakeProvider
+}
+
+func (f fakeDriftProvider) Drifted(ctx context.Context, resource provider.Resource, config map[string]interface{}) (bool, error) {
+	if _, ok := f.resources[resource.GetID()]; !ok {
+		return false, provider.ErrNotFound
+	}
+	return false, nil
+}
+
+// fakeSecretProvider gives fakeProvider's resources a generated password,
+// which like a real secret is only returned by Create
+type fakeSecretProvider struct {
+	*fakeProvider
+}
+
+func (f fakeSecretProvider) Create(ctx context.Context, resourceType string, config map[string]interface{}) (provider.Resource, error) {
+	res, err := f.fakeProvider.Create(ctx, resourceType, config)
+	if res == nil {
+		return nil, err
+	}
+	created := *res.(*types.BaseResource)
+	created.Metadata = maps.Clone(created.Metadata)
+	created.Metadata["password"] = "hunter2"
+	return &created, err
+}
+
+func (f fakeSecretProvider) SensitiveAttributes(resourceType string) []string {
+	return []string{"password"}
+}
+
+type providerSet map[string]provider.Provider
+
+func (s providerSet) Provider(name string) (provider.Provider, bool) {
+	p, ok := s[name]
+	return p, ok
+}
+
+func newStore(t *testing.T) *state.Store {
+	t.Helper()
+	store, err := state.NewStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	return store
+}
+
+// plan builds a plan for a single fake resource against the current state
+func plan(t *testing.T, store *state.Store, fake provider.Provider, resources ...map[string]interface{}) *planner.Plan {
+	t.Helper()
+	p := planner.NewPlanner()
+	p.RegisterProvider(fake)
+
+	current, err := store.GetResources(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get resources: %v", err)
+	}
+	p.SetState(current)
+
+	list := make([]interface{}, len(resources))
+	for i, r := range resources {
+		list[i] = r
+	}
+	result, err := p.CreatePlan(context.Background(), map[string]interface{}{
+		"provider":  "fake",
+		"resources": list,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+	return result
+}
+
+func disk(size int) map[string]interface{} {
+	return map[string]interface{}{
+		"type":   "disk",
+		"name":   "data",
+		"config": map[string]interface{}{"size": size},
+	}
+}
+
+// killAt returns a step hook that fails at the given stage
+func killAt(stage string) func(string, *planner.Change) error {
+	return func(s string, _ *planner.Change) error {
+		if s == stage {
+			return errKilled
+		}
+		return nil
+	}
+}
+
+// assertConsistent checks that state and the provider agree exactly
+func assertConsistent(t *testing.T, store *state.Store, fake *fakeProvider, wantSize string) {
+	t.Helper()
+	ctx := context.Background()
+
+	intents, err := store.PendingIntents(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read journal: %v", err)
+	}
+	if len(intents) != 0 {
+		t.Errorf("Expected empty journal, got %d intents", len(intents))
+	}
+
+	resources, err := store.GetResources(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get resources: %v", err)
+	}
+	if len(resources) != len(fake.resources) {
+		t.Fatalf("State has %d resources, provider has %d", len(resources), len(fake.resources))
+	}
+	for _, r := range resources {
+		if _, ok := fake.resources[r.ID]; !ok {
+			t.Errorf("State tracks %s, which the provider does not have", r.ID)
+		}
+		if wantSize != "" && r.TagMap()["size"] != wantSize {
+			t.Errorf("Expected size tag %s, got %s", wantSize, r.TagMap()["size"])
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Ownership", func(t *testing.T) {
+		store := newStore(t)
+		fake := newFakeProvider()
+		a := NewApplier(store, providerSet{"fake": fake}, "run-1")
+		a.SetProject("shop")
+		if _, err := a.Apply(ctx, plan(t, store, fake, disk(10))); err != nil {
+			t.Fatalf("Failed to apply: %v", err)
+		}
+		a = NewApplier(store, providerSet{"fake": fake}, "run-2")
+		a.SetProject("shop")
+		if _, err := a.Apply(ctx, plan(t, store, fake, disk(20))); err != nil {
+			t.Fatalf("Failed to apply update: %v", err)
+		}
+
+		want := []provider.Ownership{
+			{Project: "shop", Workspace: "default", Address: "fake.disk.data", RunID: "run-1"},
+			{Project: "shop", Workspace: "default", Address: "fake.disk.data", RunID: "run-2"},
+		}
+		if !reflect.DeepEqual(fake.owners, want) {
+			t.Errorf("Expected ownership %+v, got %+v", want, fake.owners)
+		}
+	})
+
+	t.Run("CreateUpdateDelete", func(t *testing.T) {
+		store := newStore(t)
+		fake := newFakeProvider()
+		providers := providerSet{"fake": fake}
+
+		changes, err := NewApplier(store, providers, "run-1").Apply(ctx, plan(t, store, fake, disk(10)))
+		if err != nil {
+			t.Fatalf("Failed to apply: %v", err)
+		}
+		if len(changes) != 1 || changes[0].Action != "create" || changes[0].ResourceID != "fake-1" {
+			t.Errorf("Unexpected changes: %+v", changes)
+		}
+		assertConsistent(t, store, fake, "10")
+
+		// Unchanged config is a no-op
+		p := plan(t, store, fake, disk(10))
+		if p.HasChanges() {
+			t.Errorf("Expected no changes, got %+v", p.Changes)
+		}
+
+		if _, err := NewApplier(store, providers, "run-2").Apply(ctx, plan(t, store, fake, disk(20))); err != nil {
+			t.Fatalf("Failed to apply update: %v", err)
+		}
+		assertConsistent(t, store, fake, "20")
+
+		if _, err := NewApplier(store, providers, "run-3").Apply(ctx, plan(t, store, fake)); err != nil {
+			t.Fatalf("Failed to apply delete: %v", err)
+		}
+		assertConsistent(t, store, fake, "")
+		if len(fake.resources) != 0 {
+			t.Errorf("Expected resource to be deleted, got %d", len(fake.resources))
+		}
+	})
+
+	t.Run("ProviderFailureClearsIntent", func(t *testing.T) {
+		store := newStore(t)
+		fake := newFakeProvider()
+
+		p := plan(t, store, fake, disk(10))
+		p.Changes[0].Action = types.ChangeTypeUpdate
+		p.Changes[0].Resource = &types.BaseResource{ID: "missing"}
+
+		_, err := NewApplier(store, providerSet{"fake": fake}, "run-1").Apply(ctx, p)
+		if !errors.Is(err, provider.ErrNotFound) {
+			t.Fatalf("Expected ErrNotFound, got %v", err)
+		}
+		assertConsistent(t, store, fake, "")
+	})
+
+	t.Run("RecreateMissing", func(t *testing.T) {
+		store := newStore(t)
+		fake := newFakeProvider()
+		providers := providerSet{"fake": fakeDriftProvider{fake}}
+		if _, err := NewApplier(store, providers, "run-1").Apply(ctx, plan(t, store, providers["fake"], disk(10))); err != nil {
+			t.Fatalf("Failed to apply: %v", err)
+		}
+
+		delete(fake.resources, "fake-1")
+		p := plan(t, store, providers["fake"], disk(10))
+		if p.Changes[0].Action != types.ChangeTypeCreate {
+			t.Fatalf("Expected a create for the missing resource, got %s", p.Changes[0].Action)
+		}
+		if _, err := NewApplier(store, providers, "run-2").Apply(ctx, p); err != nil {
+			t.Fatalf("Failed to apply: %v", err)
+		}
+		// The new resource's record replaces the missing one's
+		assertConsistent(t, store, fake, "10")
+	})
+
+	t.Run("References", func(t *testing.T) {
+		store := newStore(t)
+		fake := newFakeProvider()
+		providers := providerSet{"fake": fake}
+
+		attached := map[string]interface{}{
+			"type": "attachment",
+			"name": "data",
+			"config": map[string]interface{}{
+				"disk_id": "${fake.disk.data.id}",
+				"label":   "size-${fake.disk.data.size}",
+			},
+		}
+		// Declared before the disk it refers to
+		p := plan(t, store, fake, attached, disk(10))
+		if p.Changes[0].Type != "disk" || p.Changes[1].Type != "attachment" {
+			t.Fatalf("Expected the disk to be created first, got %+v", p.Changes)
+		}
+
+		if _, err := NewApplier(store, providers, "run-1").Apply(ctx, p); err != nil {
+			t.Fatalf("Failed to apply: %v", err)
+		}
+		attachment, err := store.GetResource(ctx, "fake-2")
+		if err != nil {
+			t.Fatalf("Failed to get attachment: %v", err)
+		}
+		if got := fake.resources["fake-2"].Metadata; got["disk_id"] != "fake-1" || got["label"] != "size-10" {
+			t.Errorf("Expected references to be resolved, got %v", got)
+		}
+		if len(attachment.Dependencies) != 1 || attachment.Dependencies[0] != "fake.disk.data" {
+			t.Errorf("Expected dependency on fake.disk.data, got %v", attachment.Dependencies)
+		}
+
+		if p := plan(t, store, fake, attached, disk(10)); p.HasChanges() {
+			t.Errorf("Expected no changes, got %+v", p.Changes)
+		}
+
+		// Destroying both removes the attachment first
+		p = plan(t, store, fake)
+		if len(p.Changes) != 2 || p.Changes[0].Type != "attachment" {
+			t.Fatalf("Expected the attachment to be deleted first, got %+v", p.Changes)
+		}
+		if _, err := NewApplier(store, providers, "run-2").Apply(ctx, p); err != nil {
+			t.Fatalf("Failed to apply delete: %v", err)
+		}
+		assertConsistent(t, store, fake, "")
+	})
+
+	t.Run("SensitiveAttributes", func(t *testing.T) {
+		store := newStore(t)
+		store.SetKeySource(func() (string, error) { return "passphrase", nil })
+		fake := fakeSecretProvider{newFakeProvider()}
+		providers := providerSet{"fake": fake}
+
+		assertSecret := func(t *testing.T) {
+			t.Helper()
+			record, err := store.GetResource(ctx, "fake-1")
+			if err != nil {
+				t.Fatalf("Failed to get resource: %v", err)
+			}
+			res, err := record.ToResource()
+			if err != nil {
+				t.Fatalf("Failed to convert resource: %v", err)
+			}
+			if _, ok := res.Metadata["password"]; ok {
+				t.Error("Expected password to be kept out of metadata")
+			}
+			secrets, err := store.OpenSecrets(ctx, record)
+			if err != nil {
+				t.Fatalf("Failed to open secrets: %v", err)
+			}
+			if secrets["password"] != "hunter2" {
+				t.Errorf("Expected password in secrets, got %v", secrets)
+			}
+		}
+
+		if _, err := NewApplier(store, providers, "run-1").Apply(ctx, plan(t, store, fake, disk(10))); err != nil {
+			t.Fatalf("Failed to apply: %v", err)
+		}
+		assertSecret(t)
+
+		// The read after an update cannot return the password, so it is kept
+		if _, err := NewApplier(store, providers, "run-2").Apply(ctx, plan(t, store, fake, disk(20))); err != nil {
+			t.Fatalf("Failed to apply update: %v", err)
+		}
+		assertSecret(t)
+	})
+}
+
+func TestRecover(t *testing.T) {
+	ctx := context.Background()
+
+	// Each case runs the setup applies, then kills the last apply at every
+	// journal stage and checks that recovery leaves state matching the provider
+	cases := []struct {
+		name     string
+		setup    []int
+		final    []map[string]interface{}
+		wantSize string
+	}{
+		{name: "Create", final: []map[string]interface{}{disk(10)}, wantSize: "10"},
+		{name: "Update", setup: []int{10}, final: []map[string]interface{}{disk(20)}},
+		{name: "Delete", setup: []int{10}, final: nil},
+	}
+
+	for _, tc := range cases {
+		for _, stage := range []string{stageIntent, stageProvider, stageCommit} {
+			t.Run(tc.name+"/"+stage, func(t *testing.T) {
+				store := newStore(t)
+				fake := newFakeProvider()
+				providers := providerSet{"fake": fakeFinderProvider{fake}}
+
+				for _, size := range tc.setup {
+					if _, err := NewApplier(store, providers, "setup").Apply(ctx, plan(t, store, fake, disk(size))); err != nil {
+						t.Fatalf("Failed to apply setup: %v", err)
+					}
+				}
+
+				a := NewApplier(store, providers, "killed")
+				a.step = killAt(stage)
+				if _, err := a.Apply(ctx, plan(t, store, fake, tc.final...)); !errors.Is(err, errKilled) {
+					t.Fatalf("Expected apply to be killed, got %v", err)
+				}
+
+				if _, err := NewApplier(store, providers, "recovery").Recover(ctx); err != nil {
+					t.Fatalf("Failed to recover: %v", err)
+				}
+				assertConsistent(t, store, fake, tc.wantSize)
+
+				// A fresh run converges on the desired config
+				if _, err := NewApplier(store, providers, "rerun").Apply(ctx, plan(t, store, fake, tc.final...)); err != nil {
+					t.Fatalf("Failed to re-apply: %v", err)
+				}
+				assertConsistent(t, store, fake, "")
+				if p := plan(t, store, fake, tc.final...); p.HasChanges() {
+					t.Errorf("Expected convergence, got %+v", p.Changes)
+				}
+				if len(fake.resources) != len(tc.final) {
+					t.Errorf("Expected %d resources at the provider, got %d", len(tc.final), len(fake.resources))
+				}
+			})
+		}
+	}
+
+	t.Run("CreateNotReady", func(t *testing.T) {
+		store := newStore(t)
+		fake := newFakeProvider()
+		fake.notReady = errors.New("timed out waiting")
+		providers := providerSet{"fake": fakeFinderProvider{fake}}
+
+		changes, err := NewApplier(store, providers, "slow").Apply(ctx, plan(t, store, fake, disk(10)))
+		if err == nil {
+			t.Fatal("Expected apply to fail, got nil")
+		}
+		if len(changes) != 1 || changes[0].ResourceID != "fake-1" {
+			t.Errorf("Expected the failed create to name fake-1, got %+v", changes)
+		}
+
+		intents, err := store.PendingIntents(ctx)
+		if err != nil {
+			t.Fatalf("Failed to read journal: %v", err)
+		}
+		if len(intents) != 1 {
+			t.Fatalf("Expected the intent to be kept for recovery, got %d", len(intents))
+		}
+
+		fake.notReady = nil
+		if _, err := NewApplier(store, providers, "recovery").Recover(ctx); err != nil {
+			t.Fatalf("Failed to recover: %v", err)
+		}
+		assertConsistent(t, store, fake, "10")
+	})
+
+	t.Run("CreateWithoutTokenLookup", func(t *testing.T) {
+		store := newStore(t)
+		fake := newFakeProvider()
+		providers := providerSet{"fake": fake}
+
+		a := NewApplier(store, providers, "killed")
+		a.step = killAt(stageProvider)
+		if _, err := a.Apply(ctx, plan(t, store, fake, disk(10))); !errors.Is(err, errKilled) {
+			t.Fatalf("Expected apply to be killed, got %v", err)
+		}
+
+		changes, err := NewApplier(store, providers, "recovery").Recover(ctx)
+		if err == nil {
+			t.Fatal("Expected recovery to report the untracked resource, got nil")
+		}
+		if len(changes) != 1 || changes[0].Error == "" {
+			t.Errorf("Expected a failed reconcile record, got %+v", changes)
+		}
+		intents, _ := store.PendingIntents(ctx)
+		if len(intents) != 1 || !strings.Contains(err.Error(), fmt.Sprintf("duet state intents abandon %d", intents[0].ID)) {
+			t.Errorf("Expected the error to name the command that clears the entry, got %v", err)
+		}
+	})
+
+	t.Run("CreateOwnedElsewhere", func(t *testing.T) {
+		store := newStore(t)
+		fake := newFakeProvider()
+		providers := providerSet{"fake": fakeFinderProvider{fake}}
+
+		a := NewApplier(store, providers, "killed")
+		a.SetProject("shop")
+		a.step = killAt(stageProvider)
+		if _, err := a.Apply(ctx, plan(t, store, fake, disk(10))); !errors.Is(err, errKilled) {
+			t.Fatalf("Expected apply to be killed, got %v", err)
+		}
+
+		// The resource found by token is not tagged as this project's
+		recovery := NewApplier(store, providers, "recovery")
+		recovery.SetProject("blog")
+		if _, err := recovery.Recover(ctx); err == nil || !strings.Contains(err.Error(), "refusing to adopt fake-1") {
+			t.Fatalf("Expected recovery to refuse the resource, got %v", err)
+		}
+		if _, err := store.GetResource(ctx, "fake-1"); err == nil {
+			t.Error("Expected the refused resource not to be recorded")
+		}
+	})
+}
+
+func TestRecoverLocal(t *testing.T) {
+	ctx := context.Background()
+	file := func(content string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":     "file",
+			"name":     "motd",
+			"provider": "local",
+			"config":   map[string]interface{}{"path": "motd", "content": content},
+		}
+	}
+
+	// As with TestRecover, but the provider finds an interrupted create by
+	// the path in its config rather than by token
+	cases := []struct {
+		name  string
+		setup []string
+		final []map[string]interface{}
+		want  string
+	}{
+		{name: "Create", final: []map[string]interface{}{file("hello")}, want: "hello"},
+		{name: "Update", setup: []string{"hello"}, final: []map[string]interface{}{file("goodbye")}, want: "goodbye"},
+		{name: "Delete", setup: []string{"hello"}, final: nil},
+	}
+
+	for _, tc := range cases {
+		for _, stage := range []string{stageIntent, stageProvider, stageCommit} {
+			t.Run(tc.name+"/"+stage, func(t *testing.T) {
+				store := newStore(t)
+				root := t.TempDir()
+				prov, err := local.NewLocalProvider(local.WithRoot(root))
+				if err != nil {
+					t.Fatalf("Failed to create provider: %v", err)
+				}
+				providers := providerSet{"local": prov}
+				path := filepath.Join(root, "motd")
+
+				for _, content := range tc.setup {
+					if _, err := NewApplier(store, providers, "setup").Apply(ctx, plan(t, store, prov, file(content))); err != nil {
+						t.Fatalf("Failed to apply setup: %v", err)
+					}
+				}
+
+				a := NewApplier(store, providers, "killed")
+				a.step = killAt(stage)
+				if _, err := a.Apply(ctx, plan(t, store, prov, tc.final...)); !errors.Is(err, errKilled) {
+					t.Fatalf("Expected apply to be killed, got %v", err)
+				}
+
+				if _, err := NewApplier(store, providers, "recovery").Recover(ctx); err != nil {
+					t.Fatalf("Failed to recover: %v", err)
+				}
+				intents, err := store.PendingIntents(ctx)
+				if err != nil {
+					t.Fatalf("Failed to read journal: %v", err)
+				}
+				if len(intents) != 0 {
+					t.Errorf("Expected empty journal, got %d intents", len(intents))
+				}
+				resources, err := store.GetResources(ctx)
+				if err != nil {
+					t.Fatalf("Failed to get resources: %v", err)
+				}
+				_, statErr := os.Stat(path)
+				switch {
+				case statErr == nil && (len(resources) != 1 || resources[0].ID != path):
+					t.Errorf("Expected state to track %s, got %+v", path, resources)
+				case os.IsNotExist(statErr) && len(resources) != 0:
+					t.Errorf("Expected no resources in state without the file, got %+v", resources)
+				}
+
+				// A fresh run converges on the desired config
+				if _, err := NewApplier(store, providers, "rerun").Apply(ctx, plan(t, store, prov, tc.final...)); err != nil {
+					t.Fatalf("Failed to re-apply: %v", err)
+				}
+				if p := plan(t, store, prov, tc.final...); p.HasChanges() {
+					t.Errorf("Expected convergence, got %+v", p.Changes)
+				}
+				content, err := os.ReadFile(path)
+				switch {
+				case tc.want == "" && !os.IsNotExist(err):
+					t.Errorf("Expected %s to be deleted, got %v", path, err)
+				case tc.want != "" && string(content) != tc.want:
+					t.Errorf("Expected %s to hold %q, got %q (%v)", path, tc.want, content, err)
+				}
+			})
+		}
+	}
+}
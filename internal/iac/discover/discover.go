@@ -0,0 +1,271 @@
+// Package discover brings resources created outside duet under management:
+// it lists what a provider finds in an account, records it in state and
+// declares it in Lua, so applying the result changes nothing
+package discover
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/planner"
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+// Options selects the resources Discover looks for
+type Options struct {
+	// Tags the resources must all carry; an empty value matches any value
+	Tags map[string]string
+	// Types to look for, or every type the provider can discover
+	Types []string
+	// Ownership identifies the configuration discovering. Resources without
+	// ownership tags, such as those created by hand, are always discovered;
+	// those duet created only if they pass Ownership.Check with a project
+	// set, to recover resources state lost track of. Resources another
+	// configuration owns are never discovered.
+	Ownership provider.Ownership
+}
+
+// Resource is a resource Discover found, to be recorded in state by Record
+type Resource struct {
+	// Config declares the resource, referring to other resources in state
+	// by reference rather than by ID
+	Config       map[string]interface{}
+	ID           string
+	Type         string
+	Name         string
+	Provider     string
+	Dependencies []string
+
+	record *state.Resource
+}
+
+// Address returns the resource's address, such as aws.vpc.main
+func (r *Resource) Address() string {
+	return r.Provider + "." + r.Type + "." + r.Name
+}
+
+// Discover returns the resources prov finds that state does not hold yet,
+// in the order they were found. Each is named after its Name tag, or its ID
+// without one. IDs in their configs that belong to resources in state,
+// including those just discovered, become references, so the resources are
+// ordered and replaced together like declared ones. Nothing is recorded
+// until Record is called, so the resources can be declared first. If
+// everything found is owned by duet configurations opts do not match, it
+// returns an error rather than nothing, naming how many were skipped.
+func Discover(ctx context.Context, store *state.Store, prov provider.Provider, opts Options) ([]Resource, error) {
+	discoverer, ok := prov.(provider.Discoverer)
+	if !ok {
+		return nil, fmt.Errorf("provider %s cannot discover resources", prov.Name())
+	}
+	types := opts.Types
+	if len(types) == 0 {
+		types = discoverer.DiscoverableTypes()
+	}
+
+	existing, err := store.GetResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resources: %w", err)
+	}
+	managed := make(map[string]bool, len(existing))
+	addresses := make(map[string]string, len(existing))
+	taken := make(map[string]bool, len(existing))
+	for _, r := range existing {
+		managed[r.ID] = true
+		address := r.Provider + "." + r.Type + "." + r.Name
+		taken[address] = true
+		if r.Provider == prov.Name() && r.Alias == "" {
+			addresses[r.ID] = address
+		}
+	}
+
+	var found []provider.Discovered
+	var out []Resource
+	skipped := 0
+	for _, resourceType := range types {
+		resources, err := discoverer.Discover(ctx, resourceType, opts.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover %s resources: %w", resourceType, err)
+		}
+		for _, d := range resources {
+			id := d.Resource.GetID()
+			if managed[id] {
+				continue
+			}
+			if !importable(opts, d.Resource.GetTags()) {
+				skipped++
+				continue
+			}
+			managed[id] = true
+
+			prefix := prov.Name() + "." + resourceType + "."
+			name := resourceName(d.Resource)
+			if address := d.Resource.GetTags()[provider.TagAddress]; strings.HasPrefix(address, prefix) {
+				// Keep the name the resource was declared with
+				name = strings.TrimPrefix(address, prefix)
+			}
+			r := Resource{
+				ID:       id,
+				Type:     resourceType,
+				Name:     uniqueName(taken, prefix, name),
+				Provider: prov.Name(),
+			}
+			addresses[id] = r.Address()
+			found = append(found, d)
+			out = append(out, r)
+		}
+	}
+
+	if len(out) == 0 && skipped > 0 {
+		if opts.Ownership.Project == "" {
+			return nil, fmt.Errorf("found %d resource(s), all carrying duet ownership tags; give the project they belong to, to recover them", skipped)
+		}
+		return nil, fmt.Errorf("found %d resource(s), all owned by duet configurations other than project %s in workspace %s", skipped, opts.Ownership.Project, opts.Ownership.Workspace)
+	}
+
+	for i := range out {
+		out[i].Config = withReferences(found[i].Config, addresses, out[i].ID)
+		out[i].Dependencies = planner.References(out[i].Config)
+
+		record, err := toStateResource(&out[i], found[i])
+		if err != nil {
+			return nil, err
+		}
+		out[i].record = record
+	}
+	return out, nil
+}
+
+// Record saves resources returned by Discover to state, all of them or, on
+// error, none
+func Record(ctx context.Context, store *state.Store, resources []Resource) error {
+	records := make([]*state.Resource, len(resources))
+	for i := range resources {
+		records[i] = resources[i].record
+	}
+	if err := store.SaveResources(ctx, records); err != nil {
+		return fmt.Errorf("failed to record discovered resources: %w", err)
+	}
+	return nil
+}
+
+// importable reports whether opts allow importing a resource with tags:
+// any without ownership tags, and those owned by opts.Ownership
+func importable(opts Options, tags map[string]string) bool {
+	for _, key := range []string{provider.TagProject, provider.TagWorkspace, provider.TagAddress} {
+		if _, ok := tags[key]; ok {
+			return opts.Ownership.Project != "" && opts.Ownership.Check(tags) == nil
+		}
+	}
+	return true
+}
+
+// toStateResource builds the state record of a discovered resource. Like
+// an applied resource, it records the config with references resolved.
+func toStateResource(r *Resource, d provider.Discovered) (*state.Resource, error) {
+	metadata, err := json.Marshal(d.Resource.GetMetadata())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata for %s: %w", r.ID, err)
+	}
+	config, err := json.Marshal(d.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config for %s: %w", r.ID, err)
+	}
+
+	updated := d.Resource.GetUpdatedAt()
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	record := &state.Resource{
+		ID:           r.ID,
+		Type:         r.Type,
+		Name:         r.Name,
+		Provider:     r.Provider,
+		Status:       string(d.Resource.GetStatus()),
+		LastUpdated:  updated.UTC().Format(time.RFC3339),
+		Metadata:     metadata,
+		Config:       config,
+		Dependencies: r.Dependencies,
+	}
+	record.SetTags(d.Resource.GetTags())
+	return record, nil
+}
+
+// withReferences returns a copy of config with each string that is the ID
+// of another resource in addresses replaced by a reference to it. Tags are
+// left as they are, since they only describe resources.
+func withReferences(config map[string]interface{}, addresses map[string]string, self string) map[string]interface{} {
+	var replace func(v interface{}) interface{}
+	replace = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case string:
+			if address, ok := addresses[v]; ok && v != self {
+				return "${" + address + ".id}"
+			}
+			return v
+		case []interface{}:
+			out := make([]interface{}, len(v))
+			for i, item := range v {
+				out[i] = replace(item)
+			}
+			return out
+		case map[string]interface{}:
+			out := make(map[string]interface{}, len(v))
+			for k, item := range v {
+				out[k] = replace(item)
+			}
+			return out
+		default:
+			return v
+		}
+	}
+
+	out := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		if k == "tags" {
+			out[k] = v
+			continue
+		}
+		out[k] = replace(v)
+	}
+	return out
+}
+
+// namePattern matches the runs of characters a name cannot hold
+var namePattern = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// resourceName derives a name from the resource's Name tag, or its ID
+func resourceName(r provider.Resource) string {
+	name := namePattern.ReplaceAllString(strings.ToLower(r.GetTags()["Name"]), "_")
+	name = strings.Trim(name, "_")
+	if name == "" {
+		name = namePattern.ReplaceAllString(strings.ToLower(r.GetID()), "_")
+	}
+	return name
+}
+
+// uniqueName returns name, or name with the lowest numeric suffix that makes
+// prefix + name unused, and marks it taken
+func uniqueName(taken map[string]bool, prefix, name string) string {
+	candidate := name
+	for i := 2; taken[prefix+candidate]; i++ {
+		candidate = fmt.Sprintf("%s_%d", name, i)
+	}
+	taken[prefix+candidate] = true
+	return candidate
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
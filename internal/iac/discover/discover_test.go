@@ -0,0 +1,216 @@
+package discover
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// fakeDiscoverer returns fixed resources from Discover
+type fakeDiscoverer struct {
+	found map[string][]provider.Discovered
+}
+
+func (f *fakeDiscoverer) Name() string { return "fake" }
+
+func (f *fakeDiscoverer) Create(ctx context.Context, resourceType string, config map[string]interface{}) (provider.Resource, error) {
+	return nil, nil
+}
+
+func (f *fakeDiscoverer) Read(ctx context.Context, resourceType string, id string) (provider.Resource, error) {
+	return nil, provider.ErrNotFound
+}
+
+func (f *fakeDiscoverer) Update(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	return nil
+}
+
+func (f *fakeDiscoverer) Delete(ctx context.Context, resource provider.Resource) error {
+	return nil
+}
+
+func (f *fakeDiscoverer) DiscoverableTypes() []string {
+	return []string{"network", "server"}
+}
+
+func (f *fakeDiscoverer) Discover(ctx context.Context, resourceType string, tags map[string]string) ([]provider.Discovered, error) {
+	return f.found[resourceType], nil
+}
+
+func found(id, name string, config map[string]interface{}) provider.Discovered {
+	tags := map[string]string{}
+	if name != "" {
+		tags["Name"] = name
+	}
+	return provider.Discovered{
+		Resource: &types.BaseResource{ID: id, Provider: "fake", Status: types.StatusRunning, Tags: tags},
+		Config:   config,
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	ctx := context.Background()
+	store, err := state.NewStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	if err := store.SaveResource(ctx, &state.Resource{ID: "net-0", Type: "network", Name: "shared", Provider: "fake"}); err != nil {
+		t.Fatalf("Failed to save resource: %v", err)
+	}
+
+	prov := &fakeDiscoverer{found: map[string][]provider.Discovered{
+		"network": {
+			found("net-0", "Shared", map[string]interface{}{}),
+			found("net-1", "Web Tier", map[string]interface{}{"cidr": "10.0.0.0/16"}),
+		},
+		"server": {
+			found("srv-1", "web", map[string]interface{}{
+				"network_id": "net-1",
+				"groups":     []interface{}{"net-0", "other"},
+				"tags":       map[string]interface{}{"peer": "net-1"},
+			}),
+			found("srv-2", "web", map[string]interface{}{}),
+			found("srv-3", "", map[string]interface{}{}),
+		},
+	}}
+
+	resources, err := Discover(ctx, store, prov, Options{})
+	if err != nil {
+		t.Fatalf("Failed to discover: %v", err)
+	}
+
+	t.Run("Names", func(t *testing.T) {
+		var got []string
+		for _, r := range resources {
+			got = append(got, r.Address())
+		}
+		want := []string{"fake.network.web_tier", "fake.server.web", "fake.server.web_2", "fake.server.srv_3"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("References", func(t *testing.T) {
+		want := map[string]interface{}{
+			"network_id": "${fake.network.web_tier.id}",
+			"groups":     []interface{}{"${fake.network.shared.id}", "other"},
+			"tags":       map[string]interface{}{"peer": "net-1"},
+		}
+		if !reflect.DeepEqual(resources[1].Config, want) {
+			t.Errorf("Expected config %v, got %v", want, resources[1].Config)
+		}
+		deps := []string{"fake.network.shared", "fake.network.web_tier"}
+		if !reflect.DeepEqual(resources[1].Dependencies, deps) {
+			t.Errorf("Expected dependencies %v, got %v", deps, resources[1].Dependencies)
+		}
+	})
+
+	t.Run("State", func(t *testing.T) {
+		if _, err := store.GetResource(ctx, "srv-1"); err == nil {
+			t.Fatal("Expected nothing to be recorded before Record")
+		}
+		if err := Record(ctx, store, resources); err != nil {
+			t.Fatalf("Failed to record resources: %v", err)
+		}
+
+		record, err := store.GetResource(ctx, "srv-1")
+		if err != nil {
+			t.Fatalf("Failed to get resource: %v", err)
+		}
+		if record.Name != "web" || record.Type != "server" || record.Status != string(types.StatusRunning) {
+			t.Errorf("Expected server web to be recorded, got %+v", record)
+		}
+		var config map[string]interface{}
+		if err := json.Unmarshal(record.Config, &config); err != nil {
+			t.Fatalf("Failed to decode config: %v", err)
+		}
+		// State holds the config with references resolved, as after an apply
+		if config["network_id"] != "net-1" {
+			t.Errorf("Expected the resolved network ID in state, got %v", config["network_id"])
+		}
+		if record.TagMap()["Name"] != "web" {
+			t.Errorf("Expected tags to be recorded, got %v", record.TagMap())
+		}
+	})
+
+	t.Run("Again", func(t *testing.T) {
+		again, err := Discover(ctx, store, prov, Options{Types: []string{"server"}})
+		if err != nil {
+			t.Fatalf("Failed to discover: %v", err)
+		}
+		if len(again) != 0 {
+			t.Errorf("Expected resources in state to be skipped, got %d", len(again))
+		}
+	})
+}
+
+func TestDiscoverOwnership(t *testing.T) {
+	ctx := context.Background()
+	store, err := state.NewStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	owned := func(id, project, address string) provider.Discovered {
+		d := found(id, "", map[string]interface{}{})
+		d.Resource.(*types.BaseResource).Tags = map[string]string{
+			provider.TagProject:   project,
+			provider.TagWorkspace: "default",
+			provider.TagAddress:   address,
+		}
+		return d
+	}
+	prov := &fakeDiscoverer{found: map[string][]provider.Discovered{
+		"server": {
+			owned("srv-1", "shop", "fake.server.api"),
+			owned("srv-2", "blog", "fake.server.web"),
+			found("srv-3", "manual", map[string]interface{}{}),
+		},
+	}}
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"Owned", Options{Ownership: provider.Ownership{Project: "shop", Workspace: "default"}}, []string{"fake.server.api", "fake.server.manual"}},
+		{"OtherWorkspace", Options{Ownership: provider.Ownership{Project: "shop", Workspace: "staging"}}, []string{"fake.server.manual"}},
+		{"NoProject", Options{Ownership: provider.Ownership{Workspace: "default"}}, []string{"fake.server.manual"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resources, err := Discover(ctx, store, prov, tt.opts)
+			if err != nil {
+				t.Fatalf("Failed to discover: %v", err)
+			}
+			var got []string
+			for _, r := range resources {
+				got = append(got, r.Address())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("AllOwnedElsewhere", func(t *testing.T) {
+		prov := &fakeDiscoverer{found: map[string][]provider.Discovered{
+			"server": {owned("srv-4", "blog", "fake.server.web")},
+		}}
+		_, err := Discover(ctx, store, prov, Options{Ownership: provider.Ownership{Project: "shop", Workspace: "default"}})
+		if err == nil || !strings.Contains(err.Error(), "found 1 resource(s), all owned by duet configurations other than project shop") {
+			t.Errorf("Expected an error naming the skipped resources, got %v", err)
+		}
+		_, err = Discover(ctx, store, prov, Options{Ownership: provider.Ownership{Workspace: "default"}})
+		if err == nil || !strings.Contains(err.Error(), "give the project they belong to") {
+			t.Errorf("Expected an error asking for the project, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package discover
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// refOnlyPattern matches a string that is exactly a reference to an ID,
+// which is written as duet.ref
+var refOnlyPattern = regexp.MustCompile(`^\$\{([A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+)\.id\}$`)
+
+// identifierPattern matches keys that can be written bare in a Lua table
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// WriteLua writes a config file declaring resources, with a
+// deploy_infrastructure function that configures providerName with
+// settings. Applying it with the state Discover wrote changes nothing.
+func WriteLua(w io.Writer, providerName string, settings map[string]interface{}, resources []Resource) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "-- Generated by duet discover. Each resource below is already recorded in")
+	fmt.Fprintln(b, "-- state, so applying this file as it is changes nothing.")
+	fmt.Fprintln(b, "function deploy_infrastructure()")
+	fmt.Fprintln(b, "    return {")
+	fmt.Fprintf(b, "        provider = %s,\n", luaString(providerName))
+	fmt.Fprintln(b, "        providers = {")
+	fmt.Fprintf(b, "            %s = %s,\n", luaKey(providerName), luaValue(settings, 3))
+	fmt.Fprintln(b, "        },")
+	fmt.Fprintln(b, "        resources = {")
+	for _, r := range resources {
+		fmt.Fprintln(b, "            {")
+		fmt.Fprintf(b, "                type = %s,\n", luaString(r.Type))
+		fmt.Fprintf(b, "                name = %s,\n", luaString(r.Name))
+		fmt.Fprintf(b, "                -- %s\n", r.ID)
+		fmt.Fprintf(b, "                config = %s,\n", luaValue(r.Config, 4))
+		fmt.Fprintln(b, "            },")
+	}
+	fmt.Fprintln(b, "        },")
+	fmt.Fprintln(b, "    }")
+	fmt.Fprintln(b, "end")
+	return b.Flush()
+}
+
+// luaValue formats v as a Lua expression indented depth levels deep.
+// Tables with keys are sorted by key; references become duet.ref calls.
+func luaValue(v interface{}, depth int) string {
+	indent := strings.Repeat("    ", depth)
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case string:
+		if m := refOnlyPattern.FindStringSubmatch(v); m != nil {
+			return "duet.ref(" + luaString(m[1]) + ")"
+		}
+		return luaString(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		if len(v) == 0 {
+			return "{}"
+		}
+		items := make([]string, len(v))
+		simple := true
+		for i, item := range v {
+			items[i] = luaValue(item, depth+1)
+			if _, ok := item.(map[string]interface{}); ok {
+				simple = false
+			}
+		}
+		if simple {
+			return "{ " + strings.Join(items, ", ") + " }"
+		}
+		return "{\n" + indent + "    " + strings.Join(items, ",\n"+indent+"    ") + ",\n" + indent + "}"
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return "{}"
+		}
+		var sb strings.Builder
+		sb.WriteString("{\n")
+		for _, k := range sortedKeys(v) {
+			fmt.Fprintf(&sb, "%s    %s = %s,\n", indent, luaKey(k), luaValue(v[k], depth+1))
+		}
+		sb.WriteString(indent + "}")
+		return sb.String()
+	default:
+		return luaString(fmt.Sprint(v))
+	}
+}
+
+// luaKeywords cannot be used as bare table keys
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "goto": true, "if": true, "in": true,
+	"local": true, "nil": true, "not": true, "or": true, "repeat": true, "return": true,
+	"then": true, "true": true, "until": true, "while": true,
+}
+
+// luaKey formats a table key, bracketing those that are not identifiers
+func luaKey(k string) string {
+	if identifierPattern.MatchString(k) && !luaKeywords[k] {
+		return k
+	}
+	return "[" + luaString(k) + "]"
+}
+
+// luaString quotes s as a Lua string literal. Control characters use
+// decimal escapes, which every Lua version reads.
+func luaString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&sb, `\%03d`, c)
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,87 @@
+package discover
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/core/lua"
+)
+
+func TestWriteLua(t *testing.T) {
+	resources := []Resource{
+		{
+			ID:       "net-1",
+			Type:     "network",
+			Name:     "main",
+			Provider: "fake",
+			Config: map[string]interface{}{
+				"cidr":    "10.0.0.0/16",
+				"enabled": true,
+				"ratio":   0.5,
+				"tags":    map[string]interface{}{"Name": "main", "end": "x", "cost-center": "42"},
+			},
+		},
+		{
+			ID:       "srv-1",
+			Type:     "server",
+			Name:     "web",
+			Provider: "fake",
+			Config: map[string]interface{}{
+				"network_id": "${fake.network.main.id}",
+				"size":       20,
+				"user_data":  "#!/bin/sh\necho \"hi\\there\"\t\x01\n",
+				"rules": []interface{}{
+					map[string]interface{}{"port": 443, "peers": []interface{}{"${fake.network.main.id}", "other"}},
+				},
+			},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "discovered.lua")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := WriteLua(f, "fake", map[string]interface{}{"region": "us-east-1"}, resources); err != nil {
+		t.Fatalf("Failed to write Lua: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Failed to close file: %v", err)
+	}
+
+	engine := lua.NewEngine()
+	defer engine.Close()
+	if err := engine.LoadFile(path); err != nil {
+		t.Fatalf("Failed to load generated Lua: %v", err)
+	}
+	infra, err := engine.Infrastructure()
+	if err != nil {
+		t.Fatalf("Failed to evaluate generated Lua: %v", err)
+	}
+
+	t.Run("Provider", func(t *testing.T) {
+		if infra["provider"] != "fake" {
+			t.Errorf("Expected provider fake, got %v", infra["provider"])
+		}
+		want := map[string]interface{}{"fake": map[string]interface{}{"region": "us-east-1"}}
+		if !reflect.DeepEqual(infra["providers"], want) {
+			t.Errorf("Expected providers %v, got %v", want, infra["providers"])
+		}
+	})
+
+	t.Run("Resources", func(t *testing.T) {
+		list, ok := infra["resources"].([]interface{})
+		if !ok || len(list) != len(resources) {
+			t.Fatalf("Expected %d resources, got %v", len(resources), infra["resources"])
+		}
+		for i, r := range resources {
+			got := list[i].(map[string]interface{})
+			want := map[string]interface{}{"type": r.Type, "name": r.Name, "config": r.Config}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected resource %v, got %v", want, got)
+			}
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package planner
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+// dataPattern matches a data source reference such as
+// ${data.aws.ami.ubuntu.id}: the data source's address followed by a path
+// into its attributes. Path segments name table keys or, counting from 1 as
+// Lua does, list elements, e.g. ${data.aws.availability_zones.all.names.1}.
+var dataPattern = regexp.MustCompile(`\$\{data\.([A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+)\.([A-Za-z0-9_-]+(?:\.[A-Za-z0-9_-]+)*)\}`)
+
+// dataSpec is one data source declared in the configuration
+type dataSpec struct {
+	config   map[string]interface{}
+	provider string
+	alias    string
+	typ      string
+	name     string
+}
+
+func (d dataSpec) key() string {
+	return d.provider + "." + d.typ + "." + d.name
+}
+
+// parseData reads the optional "data" list. Entries take the same fields
+// as resources, including alias, apart from depends_on.
+func parseData(config map[string]interface{}) ([]dataSpec, error) {
+	defaultProvider, _ := config["provider"].(string)
+
+	entries, err := tableList(config, "data")
+	if err != nil {
+		return nil, err
+	}
+
+	specs := make([]dataSpec, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		spec := dataSpec{provider: defaultProvider}
+		spec.typ, _ = entry["type"].(string)
+		spec.name, _ = entry["name"].(string)
+		if p, ok := entry["provider"].(string); ok {
+			spec.provider = p
+		}
+		spec.alias, _ = entry["alias"].(string)
+
+		if spec.typ == "" || spec.name == "" {
+			return nil, fmt.Errorf("data[%d]: type and name are required", i)
+		}
+		if spec.provider == "" {
+			return nil, fmt.Errorf("data[%d]: no provider set for %s.%s", i, spec.typ, spec.name)
+		}
+
+		switch cfg := entry["config"].(type) {
+		case nil:
+			spec.config = make(map[string]interface{})
+		case map[string]interface{}:
+			spec.config = cfg
+		default:
+			return nil, fmt.Errorf("data source %s: config must be a table, got %T", spec.key(), cfg)
+		}
+
+		if seen[spec.key()] {
+			return nil, fmt.Errorf("data source %s is declared more than once", spec.key())
+		}
+		seen[spec.key()] = true
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// readData reads every declared data source, in declaration order, and
+// returns their attributes by address. A data source's config may refer to
+// those declared before it, but not to resources, which may not exist yet.
+func (p *Planner) readData(ctx context.Context, specs []dataSpec) (map[string]map[string]interface{}, error) {
+	values := make(map[string]map[string]interface{}, len(specs))
+	for _, spec := range specs {
+		key := ProviderKey(spec.provider, spec.alias)
+		prov, ok := p.providers[key]
+		if !ok {
+			return nil, fmt.Errorf("data source %s: unknown provider %q", spec.key(), key)
+		}
+		reader, ok := prov.(provider.DataReader)
+		if !ok {
+			return nil, fmt.Errorf("data source %s: provider %s has no data sources", spec.key(), key)
+		}
+		if refs := References(spec.config); len(refs) > 0 {
+			return nil, fmt.Errorf("data source %s refers to resource %s; data sources can only refer to other data sources", spec.key(), refs[0])
+		}
+
+		config, err := resolveData(spec.config, values)
+		if err != nil {
+			return nil, fmt.Errorf("data source %s: %w", spec.key(), err)
+		}
+		attributes, err := reader.ReadData(ctx, spec.typ, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read data source %s: %w", spec.key(), err)
+		}
+		values[spec.key()] = attributes
+	}
+	return values, nil
+}
+
+// resolveData returns a copy of config with each data source reference
+// replaced by the value it names. As with resource references, a string
+// that is exactly one reference takes the value itself, so lists pass
+// through.
+func resolveData(config map[string]interface{}, values map[string]map[string]interface{}) (map[string]interface{}, error) {
+	var resolveErr error
+	fail := func(err error) {
+		if resolveErr == nil {
+			resolveErr = err
+		}
+	}
+
+	lookup := func(m []string) interface{} {
+		attributes, ok := values[m[1]]
+		if !ok {
+			fail(fmt.Errorf("%s refers to data source data.%s, which is not declared before it", m[0], m[1]))
+			return nil
+		}
+		value, err := dataPath(attributes, strings.Split(m[2], "."))
+		if err != nil {
+			fail(fmt.Errorf("%s: %w", m[0], err))
+		}
+		return value
+	}
+
+	resolved := mapStrings(config, func(s string) interface{} {
+		if m := dataPattern.FindStringSubmatch(s); m != nil && m[0] == s {
+			return lookup(m)
+		}
+		return dataPattern.ReplaceAllStringFunc(s, func(ref string) string {
+			value := lookup(dataPattern.FindStringSubmatch(ref))
+			switch value.(type) {
+			case map[string]interface{}, []interface{}:
+				fail(fmt.Errorf("%s is a table and cannot be embedded in a string", ref))
+				return ref
+			}
+			return fmt.Sprint(value)
+		})
+	})
+	if resolveErr != nil {
+		return nil, resolveErr
+	}
+	out, _ := resolved.(map[string]interface{})
+	return out, nil
+}
+
+// dataPath follows a path of table keys and 1-based list indexes into a
+// data source's attributes
+func dataPath(attributes map[string]interface{}, path []string) (interface{}, error) {
+	var value interface{} = attributes
+	for i, segment := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			item, ok := v[segment]
+			if !ok {
+				return nil, fmt.Errorf("no attribute %q", strings.Join(path[:i+1], "."))
+			}
+			value = item
+		case []interface{}:
+			n, err := strconv.Atoi(segment)
+			if err != nil || n < 1 || n > len(v) {
+				return nil, fmt.Errorf("%s has %d elements, so %q is not an index", strings.Join(path[:i], "."), len(v), segment)
+			}
+			value = v[n-1]
+		default:
+			return nil, fmt.Errorf("%s is not a table", strings.Join(path[:i], "."))
+		}
+	}
+	return value, nil
+}
+
+// mapStrings returns a copy of a config value with fn applied to every
+// string in it
+func mapStrings(v interface{}, fn func(string) interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = mapStrings(item, fn)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = mapStrings(item, fn)
+		}
+		return out
+	case []map[string]interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = mapStrings(item, fn)
+		}
+		return out
+	case string:
+		return fn(v)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,161 @@
+package planner
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+// dataProvider serves fixed data sources and records the configs they are
+// read with
+type dataProvider struct {
+	mockProvider
+	reads map[string]map[string]interface{}
+}
+
+func (d *dataProvider) ReadData(ctx context.Context, dataType string, config map[string]interface{}) (map[string]interface{}, error) {
+	d.reads[dataType] = config
+	switch dataType {
+	case "availability_zones":
+		return map[string]interface{}{"id": "us-east-1", "names": []interface{}{"us-east-1a", "us-east-1b"}}, nil
+	case "ami":
+		return map[string]interface{}{"id": "ami-" + config["zone"].(string)}, nil
+	}
+	return nil, provider.ErrNotFound
+}
+
+func TestData(t *testing.T) {
+	ctx := context.Background()
+	newPlanner := func() (*Planner, *dataProvider) {
+		p := NewPlanner()
+		prov := &dataProvider{mockProvider: mockProvider{name: "aws"}, reads: make(map[string]map[string]interface{})}
+		p.RegisterProvider(prov)
+		return p, prov
+	}
+	resource := func(typ, name string, config map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"type": typ, "name": name, "config": config}
+	}
+	data := []interface{}{
+		map[string]interface{}{"type": "availability_zones", "name": "all"},
+		map[string]interface{}{
+			"type":   "ami",
+			"name":   "base",
+			"config": map[string]interface{}{"zone": "${data.aws.availability_zones.all.names.2}"},
+		},
+	}
+
+	t.Run("Resolve", func(t *testing.T) {
+		p, prov := newPlanner()
+		plan, err := p.CreatePlan(ctx, map[string]interface{}{
+			"provider": "aws",
+			"data":     data,
+			"resources": []interface{}{
+				resource("instance", "web", map[string]interface{}{
+					"ami":       "${data.aws.ami.base.id}",
+					"zones":     "${data.aws.availability_zones.all.names}",
+					"name":      "web-${data.aws.availability_zones.all.names.1}",
+					"subnet_id": "${aws.subnet.a.id}",
+				}),
+				resource("subnet", "a", nil),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+
+		if zone := prov.reads["ami"]["zone"]; zone != "us-east-1b" {
+			t.Errorf("Expected the ami lookup to see zone us-east-1b, got %v", zone)
+		}
+		var config map[string]interface{}
+		for _, c := range plan.Changes {
+			if c.Address() == "aws.instance.web" {
+				config = c.Config
+			}
+		}
+		want := map[string]interface{}{
+			"ami":       "ami-us-east-1b",
+			"zones":     []interface{}{"us-east-1a", "us-east-1b"},
+			"name":      "web-us-east-1a",
+			"subnet_id": "${aws.subnet.a.id}",
+		}
+		if !reflect.DeepEqual(config, want) {
+			t.Errorf("Expected config %v, got %v", want, config)
+		}
+	})
+
+	tests := []struct {
+		name string
+		data []interface{}
+		ref  string
+		want string
+	}{
+		{
+			name: "Undeclared",
+			ref:  "${data.aws.ami.missing.id}",
+			want: "not declared",
+		},
+		{
+			name: "UnknownAttribute",
+			data: data[:1],
+			ref:  "${data.aws.availability_zones.all.zones}",
+			want: `no attribute "zones"`,
+		},
+		{
+			name: "IndexOutOfRange",
+			data: data[:1],
+			ref:  "${data.aws.availability_zones.all.names.3}",
+			want: "not an index",
+		},
+		{
+			name: "TableInString",
+			data: data[:1],
+			ref:  "zones: ${data.aws.availability_zones.all.names}",
+			want: "cannot be embedded",
+		},
+		{
+			name: "DeclaredOutOfOrder",
+			data: []interface{}{data[1], data[0]},
+			ref:  "x",
+			want: "not declared before it",
+		},
+		{
+			name: "RefersToResource",
+			data: []interface{}{
+				map[string]interface{}{"type": "ami", "name": "x", "config": map[string]interface{}{"zone": "${aws.subnet.a.availability_zone}"}},
+			},
+			ref:  "x",
+			want: "can only refer to other data sources",
+		},
+		{
+			name: "Duplicate",
+			data: []interface{}{data[0], data[0]},
+			ref:  "x",
+			want: "declared more than once",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newPlanner()
+			_, err := p.CreatePlan(ctx, map[string]interface{}{
+				"provider":  "aws",
+				"data":      tt.data,
+				"resources": []interface{}{resource("instance", "web", map[string]interface{}{"ami": tt.ref})},
+			})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	t.Run("ProviderWithoutData", func(t *testing.T) {
+		p := NewPlanner()
+		p.RegisterProvider(&mockProvider{name: "aws"})
+		_, err := p.CreatePlan(ctx, map[string]interface{}{"provider": "aws", "data": data[:1]})
+		if err == nil || !strings.Contains(err.Error(), "has no data sources") {
+			t.Errorf("Expected a missing data sources error, got %v", err)
+		}
+	})
+}
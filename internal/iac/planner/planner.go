@@ -2,24 +2,83 @@ package planner
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 
+	"github.com/rebelopsio/duet/internal/core/state"
 	"github.com/rebelopsio/duet/internal/iac/provider"
 	"github.com/rebelopsio/duet/pkg/types"
 )
 
+// Change is a single planned action against a resource. Resource is the
+// existing resource for updates and deletes, and nil for creates except of
+// a resource that has gone, whose record the new one replaces. Config may
+// still contain references, which are resolved when the change is applied.
 type Change struct {
-	Resource types.Resource
-	Config   map[string]interface{}
-	Type     string
-	Provider string
+	Resource  types.Resource
+	Config    map[string]interface{}
+	Type      string
+	Name      string
+	Provider  string
+	Alias     string
+	Action    types.ChangeType
+	DependsOn []string
+	// Replace names the attributes that force the resource to be replaced.
+	// It is set on both the delete of the old resource and the create of the
+	// new one.
+	Replace []string
+}
+
+// Address returns the change's resource address, e.g. aws.instance.web
+func (c *Change) Address() string {
+	return fmt.Sprintf("%s.%s.%s", c.Provider, c.Type, c.Name)
+}
+
+// ProviderKey returns the key of the provider the change is applied with
+func (c *Change) ProviderKey() string {
+	return ProviderKey(c.Provider, c.Alias)
+}
+
+// ProviderKey identifies a provider configuration: its name, or name.alias
+// for an aliased one such as aws.west
+func ProviderKey(name, alias string) string {
+	if alias == "" {
+		return name
+	}
+	return name + "." + alias
 }
 
 type Plan struct {
 	Changes []Change
 }
 
+// HasChanges reports whether applying the plan would do anything
+func (p *Plan) HasChanges() bool {
+	for _, c := range p.Changes {
+		if c.Action != types.ChangeTypeNoOp {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary counts the plan's changes by action
+func (p *Plan) Summary() map[types.ChangeType]int {
+	summary := make(map[types.ChangeType]int)
+	for _, c := range p.Changes {
+		summary[c.Action]++
+	}
+	return summary
+}
+
 type Planner struct {
 	providers map[string]provider.Provider
+	state     []state.Resource
+	secrets   Secrets
 }
 
 func NewPlanner() *Planner {
@@ -32,7 +91,415 @@ func (p *Planner) RegisterProvider(provider provider.Provider) {
 	p.providers[provider.Name()] = provider
 }
 
+// RegisterAlias registers an additional configuration of a provider, which
+// resources select with their "alias" field
+func (p *Planner) RegisterAlias(alias string, provider provider.Provider) {
+	p.providers[ProviderKey(provider.Name(), alias)] = provider
+}
+
+// Provider returns the registered provider with the given key, as returned
+// by ProviderKey
+func (p *Planner) Provider(key string) (provider.Provider, bool) {
+	prov, ok := p.providers[key]
+	return prov, ok
+}
+
+// SetState gives the planner the resources recorded by previous runs, so the
+// plan only contains what has changed since
+func (p *Planner) SetState(resources []state.Resource) {
+	p.state = resources
+}
+
+// SetSecrets lets the planner open the sensitive attributes of recorded
+// resources, so providers checking for drift compare resources with configs
+// that refer to them as they were applied
+func (p *Planner) SetSecrets(secrets Secrets) {
+	p.secrets = secrets
+}
+
+// resourceSpec is one resource declared in the configuration
+type resourceSpec struct {
+	config    map[string]interface{}
+	provider  string
+	alias     string
+	typ       string
+	name      string
+	dependsOn []string
+}
+
+func (r resourceSpec) key() string {
+	return r.provider + "." + r.typ + "." + r.name
+}
+
+func (r resourceSpec) providerKey() string {
+	return ProviderKey(r.provider, r.alias)
+}
+
+// CreatePlan compares the resources declared in config with the recorded
+// state. The config holds an optional default "provider" and a "resources"
+// list; each resource has a "type", a "name", an optional "provider" and an
+// optional "config" table of provider attributes. An "alias" selects one of
+// the provider's aliased configurations, registered with RegisterAlias; the
+// address does not include it. Resources are ordered
+// after those they reference, either through ${provider.type.name.attribute}
+// strings in their config or an explicit "depends_on" list of addresses.
+//
+// An optional "data" list declares read-only data sources in the same form.
+// They are read while planning, and ${data.provider.type.name.attribute}
+// references to them are replaced by their values in the planned configs.
+//
+// Configs are checked against the schemas of providers that describe their
+// resource types, and every problem found is reported together. A resource
+// whose change touches attributes its schema marks ForceNew, or refers to
+// a resource being replaced in one, is replaced: the old resource is
+// deleted, dependants first, before the first replacement is created.
 func (p *Planner) CreatePlan(ctx context.Context, config map[string]interface{}) (*Plan, error) {
-	// Implementation will go here
-	return &Plan{}, nil
+	specs, err := parseResources(config)
+	if err != nil {
+		return nil, err
+	}
+	if specs, err = orderSpecs(specs); err != nil {
+		return nil, err
+	}
+
+	data, err := parseData(config)
+	if err != nil {
+		return nil, err
+	}
+	values, err := p.readData(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	for i := range specs {
+		if specs[i].config, err = resolveData(specs[i].config, values); err != nil {
+			return nil, fmt.Errorf("resource %s: %w", specs[i].key(), err)
+		}
+	}
+	if err := p.validateSchemas(specs); err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]*state.Resource, len(p.state))
+	for i := range p.state {
+		r := &p.state[i]
+		existing[r.Provider+"."+r.Type+"."+r.Name] = r
+	}
+	lookup := func(address string) (*state.Resource, bool) {
+		r, ok := existing[address]
+		return r, ok
+	}
+
+	plan := &Plan{}
+	declared := make(map[string]bool, len(specs))
+	changing := make(map[string]bool, len(specs))
+	replacing := make(map[string]bool)
+	var replaced []*state.Resource
+	firstReplacement := -1
+	for _, spec := range specs {
+		prov, ok := p.providers[spec.providerKey()]
+		if !ok {
+			return nil, fmt.Errorf("resource %s: unknown provider %q", spec.key(), spec.providerKey())
+		}
+		if v, ok := prov.(provider.ConfigValidator); ok {
+			if err := v.ValidateConfig(ctx, spec.typ, spec.config); err != nil {
+				return nil, fmt.Errorf("resource %s: %w", spec.key(), err)
+			}
+		}
+		declared[spec.key()] = true
+
+		change := Change{
+			Config:    spec.config,
+			Type:      spec.typ,
+			Name:      spec.name,
+			Provider:  spec.provider,
+			Alias:     spec.alias,
+			Action:    types.ChangeTypeCreate,
+			DependsOn: spec.dependsOn,
+		}
+
+		if current, ok := existing[spec.key()]; ok {
+			// Another configuration may be another region or account, where
+			// the resource cannot simply be updated
+			if current.Alias != spec.alias {
+				return nil, fmt.Errorf("resource %s is managed by provider %s and cannot move to %s; remove it and add it back to recreate it",
+					spec.key(), ProviderKey(current.Provider, current.Alias), spec.providerKey())
+			}
+			resource, err := current.ToResource()
+			if err != nil {
+				return nil, err
+			}
+			change.Resource = resource
+
+			// State holds the resolved config. If a reference cannot be
+			// resolved yet, what it points to is new, so this must change.
+			resolved, complete, err := Resolve(spec.config, lookup)
+			if err != nil {
+				return nil, fmt.Errorf("resource %s: %w", spec.key(), err)
+			}
+			change.Action = types.ChangeTypeUpdate
+			schema, _ := p.resourceSchema(spec)
+			replace := forceNewReferences(schema, spec.config, replacing)
+			if complete {
+				changed, err := forceNewChanges(schema, current.Config, resource.GetMetadata(), resolved)
+				if err != nil {
+					return nil, fmt.Errorf("resource %s: %w", spec.key(), err)
+				}
+				replace = mergeAddresses(replace, changed)
+			}
+			if len(replace) > 0 {
+				change.Action = types.ChangeTypeCreate
+				change.Resource = nil
+				change.Replace = replace
+				replacing[spec.key()] = true
+				replaced = append(replaced, current)
+				if firstReplacement < 0 {
+					firstReplacement = len(plan.Changes)
+				}
+			} else if complete {
+				var normalize func(map[string]interface{}) map[string]interface{}
+				if n, ok := prov.(provider.ConfigNormalizer); ok {
+					normalize = func(config map[string]interface{}) map[string]interface{} {
+						return n.NormalizeConfig(spec.typ, config)
+					}
+				}
+				same, err := sameConfig(current.Config, resolved, normalize)
+				if err != nil {
+					return nil, fmt.Errorf("resource %s: %w", spec.key(), err)
+				}
+				// Only IDs are stable across an update; any other
+				// attribute of a changing resource may come out different,
+				// and a replaced resource gets a new ID too
+				if same && len(changingReferences(spec.config, changing)) == 0 && !refersTo(spec.config, replacing) {
+					change.Action = types.ChangeTypeNoOp
+					if d, ok := prov.(provider.DriftDetector); ok {
+						applied := resolved
+						if p.secrets != nil {
+							if applied, _, err = ResolveSecrets(spec.config, lookup, p.secrets); err != nil {
+								return nil, fmt.Errorf("resource %s: %w", spec.key(), err)
+							}
+						}
+						drifted, err := d.Drifted(ctx, resource, applied)
+						switch {
+						case errors.Is(err, provider.ErrNotFound):
+							change.Action = types.ChangeTypeCreate
+						case err != nil:
+							return nil, fmt.Errorf("resource %s: %w", spec.key(), err)
+						case drifted:
+							change.Action = types.ChangeTypeUpdate
+						}
+					}
+				}
+			}
+		}
+
+		if change.Action != types.ChangeTypeNoOp {
+			changing[spec.key()] = true
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	// Replaced resources go before the first replacement is created, so
+	// each is deleted before the resources it depends on
+	if len(replaced) > 0 {
+		deletes := make([]Change, 0, len(replaced))
+		for _, current := range orderDeletes(replaced) {
+			resource, err := current.ToResource()
+			if err != nil {
+				return nil, err
+			}
+			var replace []string
+			for _, c := range plan.Changes {
+				if c.Address() == current.Provider+"."+current.Type+"."+current.Name {
+					replace = c.Replace
+				}
+			}
+			deletes = append(deletes, Change{
+				Resource: resource,
+				Type:     current.Type,
+				Name:     current.Name,
+				Provider: current.Provider,
+				Alias:    current.Alias,
+				Action:   types.ChangeTypeDelete,
+				Replace:  replace,
+			})
+		}
+		plan.Changes = append(plan.Changes[:firstReplacement], append(deletes, plan.Changes[firstReplacement:]...)...)
+	}
+
+	// Anything recorded but no longer declared is destroyed, dependants
+	// before what they depend on and otherwise newest first. Resources from
+	// providers that are not configured are left alone.
+	var removed []*state.Resource
+	for i := range p.state {
+		current := &p.state[i]
+		key := current.Provider + "." + current.Type + "." + current.Name
+		if declared[key] {
+			continue
+		}
+		if _, ok := p.providers[ProviderKey(current.Provider, current.Alias)]; !ok {
+			continue
+		}
+		removed = append(removed, current)
+	}
+
+	for _, current := range orderDeletes(removed) {
+		resource, err := current.ToResource()
+		if err != nil {
+			return nil, err
+		}
+		plan.Changes = append(plan.Changes, Change{
+			Resource: resource,
+			Type:     current.Type,
+			Name:     current.Name,
+			Provider: current.Provider,
+			Alias:    current.Alias,
+			Action:   types.ChangeTypeDelete,
+		})
+	}
+
+	return plan, nil
+}
+
+func parseResources(config map[string]interface{}) ([]resourceSpec, error) {
+	defaultProvider, _ := config["provider"].(string)
+
+	entries, err := tableList(config, "resources")
+	if err != nil {
+		return nil, err
+	}
+
+	specs := make([]resourceSpec, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		spec := resourceSpec{provider: defaultProvider}
+		spec.typ, _ = entry["type"].(string)
+		spec.name, _ = entry["name"].(string)
+		if p, ok := entry["provider"].(string); ok {
+			spec.provider = p
+		}
+		spec.alias, _ = entry["alias"].(string)
+
+		if spec.typ == "" || spec.name == "" {
+			return nil, fmt.Errorf("resources[%d]: type and name are required", i)
+		}
+		if spec.provider == "" {
+			return nil, fmt.Errorf("resources[%d]: no provider set for %s.%s", i, spec.typ, spec.name)
+		}
+
+		switch cfg := entry["config"].(type) {
+		case nil:
+			spec.config = make(map[string]interface{})
+		case map[string]interface{}:
+			spec.config = cfg
+		default:
+			return nil, fmt.Errorf("resource %s: config must be a table, got %T", spec.key(), cfg)
+		}
+
+		dependsOn, err := parseDependsOn(entry["depends_on"])
+		if err != nil {
+			return nil, fmt.Errorf("resource %s: %w", spec.key(), err)
+		}
+		spec.dependsOn = mergeAddresses(dependsOn, References(spec.config))
+
+		if seen[spec.key()] {
+			return nil, fmt.Errorf("resource %s is declared more than once", spec.key())
+		}
+		seen[spec.key()] = true
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// tableList reads the list of tables at key, such as the resources list
+func tableList(config map[string]interface{}, key string) ([]map[string]interface{}, error) {
+	var entries []map[string]interface{}
+	switch list := config[key].(type) {
+	case nil:
+	case map[string]interface{}:
+		// An empty Lua table converts to an empty map
+		if len(list) != 0 {
+			return nil, fmt.Errorf("%s: expected a list, got a table with named keys", key)
+		}
+	case []map[string]interface{}:
+		entries = list
+	case []interface{}:
+		for i, item := range list {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s[%d]: expected a table, got %T", key, i, item)
+			}
+			entries = append(entries, entry)
+		}
+	default:
+		return nil, fmt.Errorf("%s: expected a list, got %T", key, list)
+	}
+	return entries, nil
+}
+
+// parseDependsOn reads an explicit depends_on list of resource addresses
+func parseDependsOn(v interface{}) ([]string, error) {
+	switch list := v.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		if len(list) == 0 {
+			return nil, nil
+		}
+	case []interface{}:
+		addresses := make([]string, 0, len(list))
+		for _, item := range list {
+			address, ok := item.(string)
+			if !ok || strings.Count(address, ".") != 2 {
+				return nil, fmt.Errorf("depends_on: expected addresses like aws.vpc.main, got %v", item)
+			}
+			addresses = append(addresses, address)
+		}
+		return addresses, nil
+	}
+	return nil, fmt.Errorf("depends_on: expected a list of addresses, got %T", v)
+}
+
+// mergeAddresses returns the sorted union of address lists
+func mergeAddresses(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, list := range lists {
+		for _, address := range list {
+			if !seen[address] {
+				seen[address] = true
+				out = append(out, address)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// sameConfig compares the recorded config with the desired one after a JSON
+// round trip, so numeric types and key order do not cause spurious updates.
+// When normalize is set both configs are passed through it first.
+func sameConfig(recorded []byte, desired map[string]interface{}, normalize func(map[string]interface{}) map[string]interface{}) (bool, error) {
+	if len(recorded) == 0 {
+		return false, nil
+	}
+
+	var old map[string]interface{}
+	if err := json.Unmarshal(recorded, &old); err != nil {
+		return false, fmt.Errorf("failed to decode recorded config: %w", err)
+	}
+	if normalize != nil {
+		old = normalize(old)
+		desired = normalize(desired)
+	}
+
+	data, err := json.Marshal(desired)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode config: %w", err)
+	}
+	var cur map[string]interface{}
+	if err := json.Unmarshal(data, &cur); err != nil {
+		return false, fmt.Errorf("failed to decode config: %w", err)
+	}
+
+	return reflect.DeepEqual(old, cur), nil
 }
@@ -2,10 +2,17 @@ package planner
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/rebelopsio/duet/internal/core/state"
 	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/internal/iac/provider/local"
 	"github.com/rebelopsio/duet/pkg/types"
 )
 
@@ -91,3 +98,282 @@ func TestPlanner(t *testing.T) {
 		}
 	})
 }
+
+func TestCreatePlanValidation(t *testing.T) {
+	planner := NewPlanner()
+	planner.RegisterProvider(&mockProvider{name: "aws"})
+
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{
+			name: "UnknownProvider",
+			config: map[string]interface{}{
+				"resources": []interface{}{
+					map[string]interface{}{"provider": "gcp", "type": "instance", "name": "web"},
+				},
+			},
+		},
+		{
+			name: "MissingName",
+			config: map[string]interface{}{
+				"provider":  "aws",
+				"resources": []interface{}{map[string]interface{}{"type": "instance"}},
+			},
+		},
+		{
+			name: "Duplicate",
+			config: map[string]interface{}{
+				"provider": "aws",
+				"resources": []interface{}{
+					map[string]interface{}{"type": "instance", "name": "web"},
+					map[string]interface{}{"type": "instance", "name": "web"},
+				},
+			},
+		},
+		{
+			name: "ConfigNotATable",
+			config: map[string]interface{}{
+				"provider": "aws",
+				"resources": []interface{}{
+					map[string]interface{}{"type": "instance", "name": "web", "config": "t2.micro"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := planner.CreatePlan(context.Background(), tt.config); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
+
+// normalizingProvider treats document values as equal regardless of case
+type normalizingProvider struct {
+	mockProvider
+}
+
+func (n *normalizingProvider) NormalizeConfig(resourceType string, config map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		out[k] = v
+	}
+	if doc, ok := config["document"].(string); ok {
+		out["document"] = strings.ToLower(doc)
+	}
+	return out
+}
+
+func TestCreatePlanNormalizesConfig(t *testing.T) {
+	ctx := context.Background()
+	p := NewPlanner()
+	p.RegisterProvider(&normalizingProvider{mockProvider{name: "aws"}})
+	p.SetState([]state.Resource{{
+		ID: "role-1", Type: "iam_role", Name: "app", Provider: "aws",
+		Config: []byte(`{"document":"ALLOW","path":"/"}`),
+	}})
+
+	plan := func(document string) types.ChangeType {
+		t.Helper()
+		plan, err := p.CreatePlan(ctx, map[string]interface{}{
+			"provider": "aws",
+			"resources": []interface{}{
+				map[string]interface{}{
+					"type":   "iam_role",
+					"name":   "app",
+					"config": map[string]interface{}{"document": document, "path": "/"},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+		return plan.Changes[0].Action
+	}
+
+	t.Run("EquivalentIsNoOp", func(t *testing.T) {
+		if action := plan("allow"); action != types.ChangeTypeNoOp {
+			t.Errorf("Expected no-op for an equivalent document, got %s", action)
+		}
+	})
+
+	t.Run("DifferentIsUpdate", func(t *testing.T) {
+		if action := plan("deny"); action != types.ChangeTypeUpdate {
+			t.Errorf("Expected update for a different document, got %s", action)
+		}
+	})
+}
+
+func TestCreatePlanDetectsDrift(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	prov, err := local.NewLocalProvider(local.WithRoot(root))
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	p := NewPlanner()
+	p.RegisterProvider(prov)
+
+	config := map[string]interface{}{"path": "hosts.ini", "content": "[web]\n10.0.0.1\n"}
+	infra := map[string]interface{}{
+		"provider":  "local",
+		"resources": []interface{}{map[string]interface{}{"type": "file", "name": "hosts", "config": config}},
+	}
+	// apply writes the file and records it the way the applier does
+	apply := func() {
+		t.Helper()
+		file, err := prov.Create(ctx, "file", config)
+		if err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		encoded, _ := json.Marshal(config)
+		metadata, _ := json.Marshal(file.GetMetadata())
+		p.SetState([]state.Resource{{
+			ID: file.GetID(), Type: "file", Name: "hosts", Provider: "local",
+			Config: encoded, Metadata: metadata,
+		}})
+	}
+	plan := func() Change {
+		t.Helper()
+		plan, err := p.CreatePlan(ctx, infra)
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+		return plan.Changes[0]
+	}
+
+	apply()
+	if change := plan(); change.Action != types.ChangeTypeNoOp {
+		t.Errorf("Expected no-op for an unchanged file, got %s", change.Action)
+	}
+
+	path := filepath.Join(root, "hosts.ini")
+	if err := os.WriteFile(path, []byte("[web]\n10.9.9.9\n"), 0o644); err != nil {
+		t.Fatalf("Failed to edit file: %v", err)
+	}
+	if change := plan(); change.Action != types.ChangeTypeUpdate {
+		t.Errorf("Expected update for a file edited by hand, got %s", change.Action)
+	}
+
+	apply()
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	change := plan()
+	if change.Action != types.ChangeTypeCreate || change.Resource == nil || change.Resource.GetID() != path {
+		t.Errorf("Expected a create replacing the removed file's record, got %s of %v", change.Action, change.Resource)
+	}
+}
+
+// validatingProvider requires a size on every resource
+type validatingProvider struct {
+	mockProvider
+}
+
+func (v *validatingProvider) ValidateConfig(ctx context.Context, resourceType string, config map[string]interface{}) error {
+	if _, ok := config["size"]; !ok {
+		return fmt.Errorf("%s: size is required", resourceType)
+	}
+	return nil
+}
+
+func TestCreatePlanValidatesConfig(t *testing.T) {
+	p := NewPlanner()
+	p.RegisterProvider(&validatingProvider{mockProvider{name: "aws"}})
+
+	plan := func(config map[string]interface{}) error {
+		_, err := p.CreatePlan(context.Background(), map[string]interface{}{
+			"provider":  "aws",
+			"resources": []interface{}{map[string]interface{}{"type": "volume", "name": "data", "config": config}},
+		})
+		return err
+	}
+
+	if err := plan(map[string]interface{}{"size": 20}); err != nil {
+		t.Errorf("Expected a valid config to plan, got %v", err)
+	}
+	err := plan(map[string]interface{}{"sise": 20})
+	if err == nil || err.Error() != "resource aws.volume.data: volume: size is required" {
+		t.Errorf("Expected the provider's error for the resource, got %v", err)
+	}
+}
+
+func TestCreatePlanAliases(t *testing.T) {
+	ctx := context.Background()
+	east := &mockProvider{name: "aws"}
+	west := &mockProvider{name: "aws"}
+	p := NewPlanner()
+	p.RegisterProvider(east)
+	p.RegisterAlias("west", west)
+
+	t.Run("SelectsAlias", func(t *testing.T) {
+		plan, err := p.CreatePlan(ctx, map[string]interface{}{
+			"provider": "aws",
+			"resources": []interface{}{
+				map[string]interface{}{"type": "vpc", "name": "east"},
+				map[string]interface{}{"type": "vpc", "name": "west", "alias": "west"},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+		for _, c := range plan.Changes {
+			want := map[string]string{"aws.vpc.east": "aws", "aws.vpc.west": "aws.west"}[c.Address()]
+			if c.ProviderKey() != want {
+				t.Errorf("Expected %s to use provider %s, got %s", c.Address(), want, c.ProviderKey())
+			}
+		}
+		if prov, ok := p.Provider("aws.west"); !ok || prov != west {
+			t.Error("Expected the aliased provider to be registered as aws.west")
+		}
+	})
+
+	t.Run("UnknownAlias", func(t *testing.T) {
+		_, err := p.CreatePlan(ctx, map[string]interface{}{
+			"provider":  "aws",
+			"resources": []interface{}{map[string]interface{}{"type": "vpc", "name": "main", "alias": "eu"}},
+		})
+		if err == nil || !strings.Contains(err.Error(), `unknown provider "aws.eu"`) {
+			t.Errorf("Expected an unknown provider error, got %v", err)
+		}
+	})
+
+	t.Run("State", func(t *testing.T) {
+		p.SetState([]state.Resource{
+			{ID: "vpc-1", Type: "vpc", Name: "main", Provider: "aws", Alias: "west", Config: []byte(`{}`)},
+			{ID: "vpc-2", Type: "vpc", Name: "old", Provider: "aws", Alias: "west"},
+			{ID: "vpc-3", Type: "vpc", Name: "gone", Provider: "aws", Alias: "eu"},
+		})
+		defer p.SetState(nil)
+
+		plan, err := p.CreatePlan(ctx, map[string]interface{}{
+			"provider":  "aws",
+			"resources": []interface{}{map[string]interface{}{"type": "vpc", "name": "main", "alias": "west"}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+		if len(plan.Changes) != 2 {
+			t.Fatalf("Expected 2 changes, got %d", len(plan.Changes))
+		}
+		if c := plan.Changes[0]; c.Action != types.ChangeTypeNoOp {
+			t.Errorf("Expected no change to aws.vpc.main, got %s", c.Action)
+		}
+		// Resources of an alias that is no longer configured are left alone
+		if c := plan.Changes[1]; c.Action != types.ChangeTypeDelete || c.Name != "old" || c.Alias != "west" {
+			t.Errorf("Expected aws.vpc.old to be deleted through aws.west, got %s of %s via %s", c.Action, c.Address(), c.ProviderKey())
+		}
+
+		_, err = p.CreatePlan(ctx, map[string]interface{}{
+			"provider":  "aws",
+			"resources": []interface{}{map[string]interface{}{"type": "vpc", "name": "main"}},
+		})
+		if err == nil || !strings.Contains(err.Error(), "cannot move to aws") {
+			t.Errorf("Expected an error moving a resource between providers, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,349 @@
+package planner
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+)
+
+// refPattern matches a reference such as ${aws.vpc.main.id}: the address of
+// another resource followed by the attribute to read from it. The id
+// attribute is the resource's ID; anything else is read from its metadata.
+var refPattern = regexp.MustCompile(`\$\{([A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+)\.([A-Za-z0-9_-]+)\}`)
+
+// References returns the addresses of the resources config refers to,
+// sorted
+func References(config map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	walkStrings(config, func(s string) {
+		for _, m := range refPattern.FindAllStringSubmatch(s, -1) {
+			seen[m[1]] = true
+		}
+	})
+
+	addresses := make([]string, 0, len(seen))
+	for address := range seen {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// changingReferences returns the references in config, such as
+// ${aws.launch_template.web.latest_version}, to attributes other than the ID
+// of the resources in changing, sorted. Their values are only known once
+// those resources have been applied.
+func changingReferences(config map[string]interface{}, changing map[string]bool) []string {
+	seen := make(map[string]bool)
+	walkStrings(config, func(s string) {
+		for _, m := range refPattern.FindAllStringSubmatch(s, -1) {
+			if m[2] != "id" && changing[m[1]] {
+				seen[m[0]] = true
+			}
+		}
+	})
+
+	refs := make([]string, 0, len(seen))
+	for ref := range seen {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// refersTo reports whether config refers to any of the addresses
+func refersTo(config map[string]interface{}, addresses map[string]bool) bool {
+	for _, address := range References(config) {
+		if addresses[address] {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the recorded resource at an address
+type Lookup func(address string) (*state.Resource, bool)
+
+// Secrets opens the sensitive attributes of a recorded resource, which
+// state keeps sealed apart from its metadata, as Store.OpenSecrets does
+type Secrets func(r *state.Resource) (map[string]interface{}, error)
+
+// Resolve returns a copy of config with each reference replaced by the
+// attribute it names. A string that is exactly one reference takes the
+// attribute's value, so lists and numbers pass through; references inside
+// longer strings are formatted in place. The second result is false if a
+// referenced resource has not been created yet, in which case the copy
+// still holds that reference.
+//
+// References to sensitive attributes are left as written, since resolved
+// configs are recorded in state, which must not hold them in the clear.
+// ResolveSecrets fills them in for the provider.
+func Resolve(config map[string]interface{}, lookup Lookup) (map[string]interface{}, bool, error) {
+	return resolve(config, lookup, nil)
+}
+
+// ResolveSecrets is Resolve with references to sensitive attributes
+// replaced by their values, opened with secrets
+func ResolveSecrets(config map[string]interface{}, lookup Lookup, secrets Secrets) (map[string]interface{}, bool, error) {
+	return resolve(config, lookup, secrets)
+}
+
+func resolve(config map[string]interface{}, lookup Lookup, secrets Secrets) (map[string]interface{}, bool, error) {
+	r := &resolver{
+		lookup:   lookup,
+		secrets:  secrets,
+		metadata: make(map[string]map[string]interface{}),
+		opened:   make(map[string]map[string]interface{}),
+		complete: true,
+	}
+	resolved := r.value(config)
+	if r.err != nil {
+		return nil, false, r.err
+	}
+	out, _ := resolved.(map[string]interface{})
+	return out, r.complete, nil
+}
+
+type resolver struct {
+	lookup   Lookup
+	secrets  Secrets
+	metadata map[string]map[string]interface{}
+	opened   map[string]map[string]interface{}
+	err      error
+	complete bool
+}
+
+func (r *resolver) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = r.value(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = r.value(item)
+		}
+		return out
+	case []map[string]interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = r.value(item)
+		}
+		return out
+	case string:
+		return r.string(v)
+	default:
+		return v
+	}
+}
+
+func (r *resolver) string(s string) interface{} {
+	if m := refPattern.FindStringSubmatch(s); m != nil && m[0] == s {
+		value, ok := r.attribute(m[1], m[2])
+		if !ok {
+			return s
+		}
+		return value
+	}
+
+	return refPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		m := refPattern.FindStringSubmatch(ref)
+		value, ok := r.attribute(m[1], m[2])
+		if !ok {
+			return ref
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			r.fail(fmt.Errorf("%s is a table and cannot be embedded in a string", ref))
+			return ref
+		}
+		return fmt.Sprint(value)
+	})
+}
+
+// attribute reads one attribute of the resource at address. It returns false
+// if the value is not known yet, or is sensitive and left as written.
+func (r *resolver) attribute(address, name string) (interface{}, bool) {
+	resource, ok := r.lookup(address)
+	if !ok {
+		r.complete = false
+		return nil, false
+	}
+	if name == "id" {
+		return resource.ID, true
+	}
+
+	metadata, ok := r.metadata[address]
+	if !ok {
+		metadata = make(map[string]interface{})
+		if len(resource.Metadata) > 0 {
+			if err := json.Unmarshal(resource.Metadata, &metadata); err != nil {
+				r.fail(fmt.Errorf("failed to decode metadata of %s: %w", address, err))
+				return nil, false
+			}
+		}
+		r.metadata[address] = metadata
+	}
+
+	if value, ok := metadata[name]; ok {
+		return value, true
+	}
+	if len(resource.Secrets) > 0 {
+		// Possibly sensitive; without secrets it is kept as written
+		if r.secrets == nil {
+			return nil, false
+		}
+		secrets, ok := r.opened[address]
+		if !ok {
+			var err error
+			if secrets, err = r.secrets(resource); err != nil {
+				r.fail(err)
+				return nil, false
+			}
+			r.opened[address] = secrets
+		}
+		if value, ok := secrets[name]; ok {
+			return value, true
+		}
+	}
+	r.fail(fmt.Errorf("%s has no attribute %q", address, name))
+	return nil, false
+}
+
+func (r *resolver) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// walkStrings calls fn for every string in a config value
+func walkStrings(v interface{}, fn func(string)) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, item := range v {
+			walkStrings(item, fn)
+		}
+	case []interface{}:
+		for _, item := range v {
+			walkStrings(item, fn)
+		}
+	case []map[string]interface{}:
+		for _, item := range v {
+			walkStrings(item, fn)
+		}
+	case string:
+		fn(v)
+	}
+}
+
+// orderSpecs sorts specs so every resource follows the resources it depends
+// on, keeping declaration order where there is no dependency
+func orderSpecs(specs []resourceSpec) ([]resourceSpec, error) {
+	index := make(map[string]int, len(specs))
+	for i, spec := range specs {
+		index[spec.key()] = i
+	}
+
+	pending := make([]int, len(specs))
+	dependants := make([][]int, len(specs))
+	for i, spec := range specs {
+		for _, dep := range spec.dependsOn {
+			j, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("resource %s refers to %s, which is not declared", spec.key(), dep)
+			}
+			if j == i {
+				return nil, fmt.Errorf("resource %s refers to itself", spec.key())
+			}
+			pending[i]++
+			dependants[j] = append(dependants[j], i)
+		}
+	}
+
+	ordered := make([]resourceSpec, 0, len(specs))
+	done := make([]bool, len(specs))
+	for len(ordered) < len(specs) {
+		next := -1
+		for i := range specs {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var cycle []string
+			for i, spec := range specs {
+				if !done[i] {
+					cycle = append(cycle, spec.key())
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between %v", cycle)
+		}
+
+		done[next] = true
+		ordered = append(ordered, specs[next])
+		for _, d := range dependants[next] {
+			pending[d]--
+		}
+	}
+	return ordered, nil
+}
+
+// orderDeletes sorts resources being destroyed so each goes before the
+// resources it depends on. Without dependencies, newer resources go first.
+func orderDeletes(resources []*state.Resource) []*state.Resource {
+	address := func(r *state.Resource) string {
+		return r.Provider + "." + r.Type + "." + r.Name
+	}
+	deleting := make(map[string]bool, len(resources))
+	for _, r := range resources {
+		deleting[address(r)] = true
+	}
+
+	// A resource can go once nothing left to delete depends on it
+	blockers := make(map[string]int, len(resources))
+	for _, r := range resources {
+		for _, dep := range r.Dependencies {
+			if deleting[dep] {
+				blockers[dep]++
+			}
+		}
+	}
+
+	ordered := make([]*state.Resource, 0, len(resources))
+	done := make(map[string]bool, len(resources))
+	for len(ordered) < len(resources) {
+		next := -1
+		for i := len(resources) - 1; i >= 0; i-- {
+			if !done[address(resources[i])] && blockers[address(resources[i])] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			// A cycle recorded in state; fall back to newest first
+			for i := len(resources) - 1; i >= 0; i-- {
+				if !done[address(resources[i])] {
+					next = i
+					break
+				}
+			}
+		}
+
+		r := resources[next]
+		done[address(r)] = true
+		ordered = append(ordered, r)
+		for _, dep := range r.Dependencies {
+			if deleting[dep] {
+				blockers[dep]--
+			}
+		}
+	}
+	return ordered
+}
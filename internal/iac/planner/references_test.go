@@ -0,0 +1,323 @@
+package planner
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+func TestReferences(t *testing.T) {
+	config := map[string]interface{}{
+		"vpc_id": "${aws.vpc.main.id}",
+		"name":   "web-${aws.subnet.a.availability_zone}",
+		"security_groups": []interface{}{
+			"${aws.security_group.web.id}",
+			"sg-fixed",
+		},
+		"tags": map[string]interface{}{"Vpc": "${aws.vpc.main.cidr_block}"},
+	}
+
+	got := References(config)
+	want := []string{"aws.security_group.web", "aws.subnet.a", "aws.vpc.main"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	recorded := map[string]*state.Resource{
+		"aws.vpc.main": {
+			ID:       "vpc-1",
+			Metadata: []byte(`{"cidr_block":"10.0.0.0/16","route_table_ids":["rtb-1"],"port":22}`),
+		},
+	}
+	lookup := func(address string) (*state.Resource, bool) {
+		r, ok := recorded[address]
+		return r, ok
+	}
+
+	t.Run("Values", func(t *testing.T) {
+		resolved, complete, err := Resolve(map[string]interface{}{
+			"vpc_id": "${aws.vpc.main.id}",
+			"cidr":   "${aws.vpc.main.cidr_block}",
+			"tables": "${aws.vpc.main.route_table_ids}",
+			"label":  "port ${aws.vpc.main.port} in ${aws.vpc.main.id}",
+			"nested": []interface{}{map[string]interface{}{"id": "${aws.vpc.main.id}"}},
+		}, lookup)
+		if err != nil {
+			t.Fatalf("Failed to resolve: %v", err)
+		}
+		if !complete {
+			t.Fatal("Expected resolution to be complete")
+		}
+
+		want := map[string]interface{}{
+			"vpc_id": "vpc-1",
+			"cidr":   "10.0.0.0/16",
+			"tables": []interface{}{"rtb-1"},
+			"label":  "port 22 in vpc-1",
+			"nested": []interface{}{map[string]interface{}{"id": "vpc-1"}},
+		}
+		if !reflect.DeepEqual(resolved, want) {
+			t.Errorf("Expected %v, got %v", want, resolved)
+		}
+	})
+
+	t.Run("Incomplete", func(t *testing.T) {
+		resolved, complete, err := Resolve(map[string]interface{}{
+			"vpc_id":    "${aws.vpc.main.id}",
+			"subnet_id": "${aws.subnet.a.id}",
+		}, lookup)
+		if err != nil {
+			t.Fatalf("Failed to resolve: %v", err)
+		}
+		if complete {
+			t.Error("Expected resolution to be incomplete")
+		}
+		if resolved["vpc_id"] != "vpc-1" || resolved["subnet_id"] != "${aws.subnet.a.id}" {
+			t.Errorf("Expected only known references to be resolved, got %v", resolved)
+		}
+	})
+
+	t.Run("UnknownAttribute", func(t *testing.T) {
+		_, _, err := Resolve(map[string]interface{}{"x": "${aws.vpc.main.nope}"}, lookup)
+		if err == nil || !strings.Contains(err.Error(), `no attribute "nope"`) {
+			t.Errorf("Expected unknown attribute error, got %v", err)
+		}
+	})
+
+	t.Run("Sensitive", func(t *testing.T) {
+		recorded["random.password.db"] = &state.Resource{ID: "pw-1", Metadata: []byte(`{"length":20}`), Secrets: []byte("sealed")}
+		defer delete(recorded, "random.password.db")
+		config := map[string]interface{}{
+			"password": "${random.password.db.result}",
+			"url":      "postgres://app:${random.password.db.result}@db",
+		}
+
+		// Left as written for state, which must not hold it in the clear
+		resolved, complete, err := Resolve(config, lookup)
+		if err != nil {
+			t.Fatalf("Failed to resolve: %v", err)
+		}
+		if !complete || !reflect.DeepEqual(resolved, config) {
+			t.Errorf("Expected sensitive references to be kept, got %v", resolved)
+		}
+
+		opened := 0
+		secrets := func(r *state.Resource) (map[string]interface{}, error) {
+			opened++
+			return map[string]interface{}{"result": "hunter2"}, nil
+		}
+		resolved, _, err = ResolveSecrets(config, lookup, secrets)
+		if err != nil {
+			t.Fatalf("Failed to resolve: %v", err)
+		}
+		want := map[string]interface{}{"password": "hunter2", "url": "postgres://app:hunter2@db"}
+		if !reflect.DeepEqual(resolved, want) {
+			t.Errorf("Expected %v, got %v", want, resolved)
+		}
+		if opened != 1 {
+			t.Errorf("Expected the secrets to be opened once, got %d", opened)
+		}
+
+		_, _, err = ResolveSecrets(map[string]interface{}{"x": "${random.password.db.nope}"}, lookup, secrets)
+		if err == nil || !strings.Contains(err.Error(), `no attribute "nope"`) {
+			t.Errorf("Expected unknown attribute error, got %v", err)
+		}
+	})
+
+	t.Run("EmbeddedTable", func(t *testing.T) {
+		_, _, err := Resolve(map[string]interface{}{"x": "ids: ${aws.vpc.main.route_table_ids}"}, lookup)
+		if err == nil || !strings.Contains(err.Error(), "cannot be embedded") {
+			t.Errorf("Expected embedded table error, got %v", err)
+		}
+	})
+}
+
+func TestPlanOrdering(t *testing.T) {
+	ctx := context.Background()
+	newPlanner := func() *Planner {
+		p := NewPlanner()
+		p.RegisterProvider(&mockProvider{name: "aws"})
+		return p
+	}
+	resource := func(typ, name string, config map[string]interface{}, dependsOn ...interface{}) map[string]interface{} {
+		r := map[string]interface{}{"type": typ, "name": name, "config": config}
+		if len(dependsOn) > 0 {
+			r["depends_on"] = dependsOn
+		}
+		return r
+	}
+	addresses := func(plan *Plan) []string {
+		var out []string
+		for _, c := range plan.Changes {
+			out = append(out, c.Address())
+		}
+		return out
+	}
+
+	t.Run("DependenciesFirst", func(t *testing.T) {
+		plan, err := newPlanner().CreatePlan(ctx, map[string]interface{}{
+			"provider": "aws",
+			"resources": []interface{}{
+				resource("instance", "web", map[string]interface{}{"subnet_id": "${aws.subnet.a.id}"}),
+				resource("subnet", "a", map[string]interface{}{"vpc_id": "${aws.vpc.main.id}"}),
+				resource("bucket", "logs", nil),
+				resource("vpc", "main", nil, "aws.bucket.logs"),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+
+		want := []string{"aws.bucket.logs", "aws.vpc.main", "aws.subnet.a", "aws.instance.web"}
+		if got := addresses(plan); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected order %v, got %v", want, got)
+		}
+		if deps := plan.Changes[3].DependsOn; !reflect.DeepEqual(deps, []string{"aws.subnet.a"}) {
+			t.Errorf("Expected instance to depend on the subnet, got %v", deps)
+		}
+	})
+
+	t.Run("UpdateWhenReferenceIsNew", func(t *testing.T) {
+		p := newPlanner()
+		p.SetState([]state.Resource{{
+			ID: "i-1", Type: "instance", Name: "web", Provider: "aws",
+			Config: []byte(`{"subnet_id":"subnet-old"}`),
+		}})
+		plan, err := p.CreatePlan(ctx, map[string]interface{}{
+			"provider": "aws",
+			"resources": []interface{}{
+				resource("subnet", "a", nil),
+				resource("instance", "web", map[string]interface{}{"subnet_id": "${aws.subnet.a.id}"}),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+		if plan.Changes[1].Action != types.ChangeTypeUpdate {
+			t.Errorf("Expected instance to be updated, got %s", plan.Changes[1].Action)
+		}
+	})
+
+	t.Run("NoOpWhenResolvedConfigMatches", func(t *testing.T) {
+		p := newPlanner()
+		p.SetState([]state.Resource{
+			{ID: "subnet-1", Type: "subnet", Name: "a", Provider: "aws", Config: []byte(`{}`)},
+			{ID: "i-1", Type: "instance", Name: "web", Provider: "aws", Config: []byte(`{"subnet_id":"subnet-1"}`)},
+		})
+		plan, err := p.CreatePlan(ctx, map[string]interface{}{
+			"provider": "aws",
+			"resources": []interface{}{
+				resource("subnet", "a", map[string]interface{}{}),
+				resource("instance", "web", map[string]interface{}{"subnet_id": "${aws.subnet.a.id}"}),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+		if plan.HasChanges() {
+			t.Errorf("Expected no changes, got %+v", plan.Changes)
+		}
+	})
+
+	t.Run("UpdateWhenReferencedAttributeMayChange", func(t *testing.T) {
+		p := newPlanner()
+		p.SetState([]state.Resource{
+			{ID: "lt-1", Type: "launch_template", Name: "web", Provider: "aws", Config: []byte(`{"instance_type":"t3.micro"}`), Metadata: []byte(`{"latest_version":1}`)},
+			{ID: "asg-web", Type: "autoscaling_group", Name: "web", Provider: "aws", Config: []byte(`{"version":1}`)},
+			{ID: "i-1", Type: "instance", Name: "bastion", Provider: "aws", Config: []byte(`{"template":"lt-1"}`)},
+		})
+		plan, err := p.CreatePlan(ctx, map[string]interface{}{
+			"provider": "aws",
+			"resources": []interface{}{
+				resource("launch_template", "web", map[string]interface{}{"instance_type": "t3.small"}),
+				resource("autoscaling_group", "web", map[string]interface{}{"version": "${aws.launch_template.web.latest_version}"}),
+				resource("instance", "bastion", map[string]interface{}{"template": "${aws.launch_template.web.id}"}),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+
+		want := map[string]types.ChangeType{
+			"aws.launch_template.web":   types.ChangeTypeUpdate,
+			"aws.autoscaling_group.web": types.ChangeTypeUpdate,
+			"aws.instance.bastion":      types.ChangeTypeNoOp,
+		}
+		for _, c := range plan.Changes {
+			if c.Action != want[c.Address()] {
+				t.Errorf("Expected %s to be %s, got %s", c.Address(), want[c.Address()], c.Action)
+			}
+		}
+	})
+
+	t.Run("DeletesDependantsFirst", func(t *testing.T) {
+		p := newPlanner()
+		p.SetState([]state.Resource{
+			{ID: "i-1", Type: "instance", Name: "web", Provider: "aws", Dependencies: []string{"aws.subnet.a"}},
+			{ID: "vpc-1", Type: "vpc", Name: "main", Provider: "aws"},
+			{ID: "subnet-1", Type: "subnet", Name: "a", Provider: "aws", Dependencies: []string{"aws.vpc.main"}},
+		})
+		plan, err := p.CreatePlan(ctx, map[string]interface{}{"provider": "aws"})
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+
+		want := []string{"aws.instance.web", "aws.subnet.a", "aws.vpc.main"}
+		if got := addresses(plan); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected order %v, got %v", want, got)
+		}
+	})
+
+	errorCases := []struct {
+		name      string
+		resources []interface{}
+		want      string
+	}{
+		{
+			name: "Undeclared",
+			resources: []interface{}{
+				resource("subnet", "a", map[string]interface{}{"vpc_id": "${aws.vpc.main.id}"}),
+			},
+			want: "aws.vpc.main, which is not declared",
+		},
+		{
+			name: "SelfReference",
+			resources: []interface{}{
+				resource("vpc", "main", map[string]interface{}{"name": "${aws.vpc.main.id}"}),
+			},
+			want: "refers to itself",
+		},
+		{
+			name: "Cycle",
+			resources: []interface{}{
+				resource("vpc", "main", nil, "aws.subnet.a"),
+				resource("subnet", "a", map[string]interface{}{"vpc_id": "${aws.vpc.main.id}"}),
+			},
+			want: "dependency cycle",
+		},
+		{
+			name: "BadDependsOn",
+			resources: []interface{}{
+				resource("vpc", "main", nil, "main"),
+			},
+			want: "depends_on",
+		},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newPlanner().CreatePlan(ctx, map[string]interface{}{
+				"provider":  "aws",
+				"resources": tc.resources,
+			})
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
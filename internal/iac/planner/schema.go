@@ -0,0 +1,127 @@
+package planner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+// validateSchemas checks the config of each resource against the schema of
+// its provider, where the provider has one, along with the attributes it
+// refers to, and reports every problem in every resource at once
+func (p *Planner) validateSchemas(specs []resourceSpec) error {
+	declared := make(map[string]resourceSpec, len(specs))
+	for _, spec := range specs {
+		declared[spec.key()] = spec
+	}
+
+	var errs []error
+	for _, spec := range specs {
+		schema, err := p.resourceSchema(spec)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("resource %s: %w", spec.key(), err))
+		case schema != nil:
+			for _, diag := range schema.Validate(spec.config) {
+				errs = append(errs, fmt.Errorf("resource %s: %w", spec.key(), diag))
+			}
+		}
+		errs = append(errs, p.checkReferences(spec, declared)...)
+	}
+	return errors.Join(errs...)
+}
+
+// checkReferences returns an error for each reference in the config of spec
+// to an attribute that the schema of the declared resource it refers to does
+// not describe. Otherwise it would only fail when applied, after the
+// resources before it were created.
+func (p *Planner) checkReferences(spec resourceSpec, declared map[string]resourceSpec) []error {
+	seen := make(map[string]bool)
+	var problems []string
+	walkStrings(spec.config, func(s string) {
+		for _, m := range refPattern.FindAllStringSubmatch(s, -1) {
+			target, ok := declared[m[1]]
+			if !ok || m[2] == "id" || seen[m[0]] {
+				continue
+			}
+			seen[m[0]] = true
+			if schema, _ := p.resourceSchema(target); schema != nil && schema.Attributes[m[2]] == nil {
+				problems = append(problems, fmt.Sprintf("resource %s: %s has no attribute %q", spec.key(), m[1], m[2]))
+			}
+		}
+	})
+
+	sort.Strings(problems)
+	errs := make([]error, len(problems))
+	for i, problem := range problems {
+		errs[i] = errors.New(problem)
+	}
+	return errs
+}
+
+// resourceSchema returns the schema of the resource type of spec, or nil if
+// its provider does not describe one. An unknown provider is reported when
+// the resource is planned.
+func (p *Planner) resourceSchema(spec resourceSpec) (*provider.ResourceSchema, error) {
+	describer, ok := p.providers[spec.providerKey()].(provider.SchemaDescriber)
+	if !ok {
+		return nil, nil
+	}
+	schema := describer.Schema()
+	if schema == nil {
+		return nil, nil
+	}
+	return schema.Resource(spec.typ)
+}
+
+// forceNewChanges returns the attributes that the resolved config of an
+// existing resource changes and its schema marks ForceNew, so that the
+// resource must be replaced. Attributes the recorded config left unset are
+// compared with the metadata of the same name, which holds the value the
+// provider chose, such as an instance's subnet.
+func forceNewChanges(schema *provider.ResourceSchema, recorded []byte, metadata, resolved map[string]interface{}) ([]string, error) {
+	if schema == nil || len(recorded) == 0 {
+		return nil, nil
+	}
+	var old map[string]interface{}
+	if err := json.Unmarshal(recorded, &old); err != nil {
+		return nil, fmt.Errorf("failed to decode recorded config: %w", err)
+	}
+	current := make(map[string]interface{}, len(metadata)+len(old))
+	for k, v := range metadata {
+		current[k] = v
+	}
+	for k, v := range old {
+		if v != nil {
+			current[k] = v
+		}
+	}
+	return schema.ForceNewChanges(current, resolved), nil
+}
+
+// forceNewReferences returns the ForceNew attributes of config that refer
+// to a resource being replaced. Their values resolve to the old resource
+// while planning but change once it is replaced, so the resource referring
+// to it must be replaced too.
+func forceNewReferences(schema *provider.ResourceSchema, config map[string]interface{}, replacing map[string]bool) []string {
+	if schema == nil || len(replacing) == 0 {
+		return nil
+	}
+	var names []string
+	for name, attr := range schema.Attributes {
+		if !attr.ForceNew {
+			continue
+		}
+		for _, address := range References(map[string]interface{}{name: config[name]}) {
+			if replacing[address] {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
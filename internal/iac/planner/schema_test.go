@@ -0,0 +1,168 @@
+package planner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// describingProvider describes vpc and subnet resources
+type describingProvider struct {
+	mockProvider
+}
+
+func (d *describingProvider) Schema() *provider.Schema {
+	return &provider.Schema{Resources: map[string]*provider.ResourceSchema{
+		"vpc": {Attributes: map[string]*provider.Attribute{
+			"cidr_block":         {Type: provider.TypeString, Required: true, ForceNew: true},
+			"enable_dns_support": {Type: provider.TypeBool, Default: true},
+		}},
+		"subnet": {Attributes: map[string]*provider.Attribute{
+			"vpc_id":            {Type: provider.TypeString, Required: true, ForceNew: true},
+			"cidr_block":        {Type: provider.TypeString, Required: true},
+			"availability_zone": {Type: provider.TypeString, ForceNew: true},
+		}},
+	}}
+}
+
+func TestCreatePlanSchema(t *testing.T) {
+	ctx := context.Background()
+	p := NewPlanner()
+	p.RegisterProvider(&describingProvider{mockProvider{name: "aws"}})
+	plan := func(resources ...interface{}) (*Plan, error) {
+		return p.CreatePlan(ctx, map[string]interface{}{"provider": "aws", "resources": resources})
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		_, err := plan(
+			map[string]interface{}{"type": "vpc", "name": "main", "config": map[string]interface{}{"cidr_block": "10.0.0.0/16"}},
+			map[string]interface{}{"type": "subnet", "name": "a", "config": map[string]interface{}{
+				"vpc_id":     "${aws.vpc.main.id}",
+				"cidr_block": "10.0.1.0/24",
+			}},
+		)
+		if err != nil {
+			t.Errorf("Expected a valid config to plan, got %v", err)
+		}
+	})
+
+	t.Run("Diagnostics", func(t *testing.T) {
+		_, err := plan(
+			map[string]interface{}{"type": "vpc", "name": "main", "config": map[string]interface{}{
+				"cidr_blok":          "10.0.0.0/16",
+				"enable_dns_support": "yes",
+			}},
+			map[string]interface{}{"type": "subent", "name": "a"},
+		)
+		want := []string{
+			"resource aws.vpc.main: attribute cidr_block: required but not set",
+			"resource aws.vpc.main: attribute cidr_blok: unknown attribute, did you mean cidr_block?",
+			"resource aws.vpc.main: attribute enable_dns_support: expected a boolean, got string",
+			`resource aws.subent.a: unknown resource type "subent", did you mean "subnet"?`,
+		}
+		if err == nil || err.Error() != strings.Join(want, "\n") {
+			t.Errorf("Expected every problem to be reported, got %v", err)
+		}
+	})
+
+	t.Run("References", func(t *testing.T) {
+		_, err := plan(
+			map[string]interface{}{"type": "vpc", "name": "main", "config": map[string]interface{}{"cidr_block": "10.0.0.0/16"}},
+			map[string]interface{}{"type": "subnet", "name": "a", "config": map[string]interface{}{
+				"vpc_id":            "${aws.vpc.main.id}",
+				"cidr_block":        "${aws.vpc.main.cidr_block}",
+				"availability_zone": "zone of ${aws.vpc.main.zone}",
+			}},
+		)
+		if err == nil || err.Error() != `resource aws.subnet.a: aws.vpc.main has no attribute "zone"` {
+			t.Errorf("Expected the unknown attribute to be reported, got %v", err)
+		}
+	})
+
+	t.Run("ForceNew", func(t *testing.T) {
+		p.SetState([]state.Resource{
+			{ID: "vpc-1", Type: "vpc", Name: "main", Provider: "aws", Config: []byte(`{"cidr_block":"10.0.0.0/16"}`)},
+			{
+				ID: "subnet-1", Type: "subnet", Name: "a", Provider: "aws",
+				Config:       []byte(`{"vpc_id":"vpc-1","cidr_block":"10.0.1.0/24"}`),
+				Dependencies: []string{"aws.vpc.main"},
+				Metadata:     []byte(`{"availability_zone":"us-east-1a"}`),
+			},
+		})
+		defer p.SetState(nil)
+
+		changes, err := plan(map[string]interface{}{"type": "vpc", "name": "main", "config": map[string]interface{}{
+			"cidr_block":         "10.0.0.0/16",
+			"enable_dns_support": false,
+		}})
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+		if c := changes.Changes[0]; c.Action != types.ChangeTypeUpdate {
+			t.Errorf("Expected an update, got %s", c.Action)
+		}
+
+		// Changing the CIDR block replaces the VPC, and with it the subnet,
+		// whose VPC ID is set when created; the subnet goes first
+		changes, err = plan(
+			map[string]interface{}{"type": "vpc", "name": "main", "config": map[string]interface{}{"cidr_block": "10.1.0.0/16"}},
+			map[string]interface{}{"type": "subnet", "name": "a", "config": map[string]interface{}{
+				"vpc_id":     "${aws.vpc.main.id}",
+				"cidr_block": "10.0.1.0/24",
+			}},
+		)
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+		want := []struct {
+			action  types.ChangeType
+			address string
+			replace string
+		}{
+			{types.ChangeTypeDelete, "aws.subnet.a", "vpc_id"},
+			{types.ChangeTypeDelete, "aws.vpc.main", "cidr_block"},
+			{types.ChangeTypeCreate, "aws.vpc.main", "cidr_block"},
+			{types.ChangeTypeCreate, "aws.subnet.a", "vpc_id"},
+		}
+		if len(changes.Changes) != len(want) {
+			t.Fatalf("Expected %d changes, got %d", len(want), len(changes.Changes))
+		}
+		for i, w := range want {
+			c := changes.Changes[i]
+			if c.Action != w.action || c.Address() != w.address || strings.Join(c.Replace, ",") != w.replace {
+				t.Errorf("Expected change %d to %s %s replacing %s, got %s %s replacing %v", i, w.action, w.address, w.replace, c.Action, c.Address(), c.Replace)
+			}
+			if c.Action == types.ChangeTypeCreate && c.Resource != nil {
+				t.Errorf("Expected %s to be created anew, got %s", c.Address(), c.Resource.GetID())
+			}
+		}
+
+		// The zone AWS chose is compared with the one now written down
+		subnet := func(zone string) error {
+			_, err := plan(map[string]interface{}{"type": "subnet", "name": "a", "config": map[string]interface{}{
+				"vpc_id":            "vpc-1",
+				"cidr_block":        "10.0.1.0/24",
+				"availability_zone": zone,
+			}})
+			return err
+		}
+		if err := subnet("us-east-1a"); err != nil {
+			t.Errorf("Expected setting the zone the subnet is in to plan, got %v", err)
+		}
+		changes, err = plan(map[string]interface{}{"type": "subnet", "name": "a", "config": map[string]interface{}{
+			"vpc_id":            "vpc-1",
+			"cidr_block":        "10.0.1.0/24",
+			"availability_zone": "us-east-1b",
+		}})
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+		if len(changes.Changes) < 2 || changes.Changes[0].Action != types.ChangeTypeDelete || strings.Join(changes.Changes[0].Replace, ",") != "availability_zone" {
+			t.Errorf("Expected moving the subnet to replace it, got %+v", changes.Changes)
+		}
+	})
+}
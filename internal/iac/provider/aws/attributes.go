@@ -0,0 +1,252 @@
+package aws
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// attributes reads typed values from a resource config. Config values come
+// from Lua or from JSON in state, so numbers may be int or float64 and lists
+// may be []interface{}. The first type mismatch is kept and reported by Err.
+type attributes struct {
+	values map[string]interface{}
+	err    error
+}
+
+func newAttributes(config map[string]interface{}) *attributes {
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	return &attributes{values: config}
+}
+
+// Err returns the first error encountered while reading attributes
+func (a *attributes) Err() error {
+	return a.err
+}
+
+// Has reports whether key is set
+func (a *attributes) Has(key string) bool {
+	v, ok := a.values[key]
+	return ok && v != nil
+}
+
+func (a *attributes) fail(key string, want string, got interface{}) {
+	if a.err == nil {
+		a.err = fmt.Errorf("attribute %s: expected %s, got %T", key, want, got)
+	}
+}
+
+// Require records an error for each key that is not set
+func (a *attributes) Require(keys ...string) {
+	for _, key := range keys {
+		if !a.Has(key) && a.err == nil {
+			a.err = fmt.Errorf("attribute %s is required", key)
+		}
+	}
+}
+
+// String returns the string at key, or "" if unset
+func (a *attributes) String(key string) string {
+	v, ok := a.values[key]
+	if !ok || v == nil {
+		return ""
+	}
+	s, ok := v.(string)
+	if !ok {
+		a.fail(key, "a string", v)
+	}
+	return s
+}
+
+// Int returns the whole number at key, or 0 if unset
+func (a *attributes) Int(key string) int {
+	v, ok := a.values[key]
+	if !ok || v == nil {
+		return 0
+	}
+	switch n := v.(type) {
+	case int:
+		return n
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case float64:
+		if n != math.Trunc(n) {
+			a.fail(key, "a whole number", v)
+		}
+		return int(n)
+	default:
+		a.fail(key, "a number", v)
+		return 0
+	}
+}
+
+// Bool returns the boolean at key, or false if unset
+func (a *attributes) Bool(key string) bool {
+	v, ok := a.values[key]
+	if !ok || v == nil {
+		return false
+	}
+	b, ok := v.(bool)
+	if !ok {
+		a.fail(key, "a boolean", v)
+	}
+	return b
+}
+
+// Duration returns the duration at key, given as a string such as "90s" or
+// "10m" or as a number of seconds, or 0 if unset
+func (a *attributes) Duration(key string) time.Duration {
+	v, ok := a.values[key]
+	if !ok || v == nil {
+		return 0
+	}
+	if s, ok := v.(string); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			a.fail(key, "a duration", v)
+		}
+		return d
+	}
+	return time.Duration(a.Int(key)) * time.Second
+}
+
+// StringList returns the list of strings at key, or nil if unset
+func (a *attributes) StringList(key string) []string {
+	v, ok := a.values[key]
+	if !ok || v == nil {
+		return nil
+	}
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				a.fail(key, "a list of strings", v)
+				return nil
+			}
+			out = append(out, s)
+		}
+		return out
+	case map[string]interface{}:
+		// An empty Lua table converts to an empty map
+		if len(list) == 0 {
+			return []string{}
+		}
+	}
+	a.fail(key, "a list of strings", v)
+	return nil
+}
+
+// StringMap returns the string-to-string table at key, or nil if unset
+func (a *attributes) StringMap(key string) map[string]string {
+	v, ok := a.values[key]
+	if !ok || v == nil {
+		return nil
+	}
+	switch m := v.(type) {
+	case map[string]string:
+		return m
+	case map[string]interface{}:
+		out := make(map[string]string, len(m))
+		for k, item := range m {
+			s, ok := item.(string)
+			if !ok {
+				a.fail(key, "a table of strings", v)
+				return nil
+			}
+			out[k] = s
+		}
+		return out
+	case []interface{}:
+		if len(m) == 0 {
+			return map[string]string{}
+		}
+	}
+	a.fail(key, "a table of strings", v)
+	return nil
+}
+
+// Table returns the table at key wrapped as attributes. Errors reading it
+// are folded back with collectTable.
+func (a *attributes) Table(key string) *attributes {
+	v, ok := a.values[key]
+	if !ok || v == nil {
+		return newAttributes(nil)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		a.fail(key, "a table", v)
+		return newAttributes(nil)
+	}
+	return newAttributes(m)
+}
+
+// List returns the list of tables at key, each wrapped as attributes
+func (a *attributes) List(key string) []*attributes {
+	v, ok := a.values[key]
+	if !ok || v == nil {
+		return nil
+	}
+	var items []interface{}
+	switch list := v.(type) {
+	case []interface{}:
+		items = list
+	case []map[string]interface{}:
+		for _, m := range list {
+			items = append(items, m)
+		}
+	case map[string]interface{}:
+		if len(list) != 0 {
+			a.fail(key, "a list of tables", v)
+		}
+		return nil
+	default:
+		a.fail(key, "a list of tables", v)
+		return nil
+	}
+
+	out := make([]*attributes, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			a.fail(key, "a list of tables", v)
+			return nil
+		}
+		out = append(out, newAttributes(m))
+	}
+	return out
+}
+
+// collect folds errors from nested attributes into a, prefixing the key
+func (a *attributes) collect(key string, nested ...*attributes) {
+	for i, n := range nested {
+		if n.err != nil && a.err == nil {
+			a.err = fmt.Errorf("%s[%d]: %w", key, i+1, n.err)
+		}
+	}
+}
+
+// collectTable folds the error from a table read with Table into a
+func (a *attributes) collectTable(key string, table *attributes) {
+	if table.err != nil && a.err == nil {
+		a.err = fmt.Errorf("%s: %w", key, table.err)
+	}
+}
+
+// sortedKeys returns the keys of m in order, for deterministic API calls
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,695 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	astypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// defaultLaunchTemplateVersion is the version a group launches from when
+// the config names none
+const defaultLaunchTemplateVersion = "$Default"
+
+type AutoScalingClient struct {
+	client       *autoscaling.Client
+	pollInterval time.Duration
+}
+
+func NewAutoScalingClient(cfg aws.Config) (*AutoScalingClient, error) {
+	return &AutoScalingClient{
+		client:       autoscaling.NewFromConfig(cfg),
+		pollInterval: defaultPollInterval,
+	}, nil
+}
+
+// autoScalingGroupSpec is the desired configuration of an auto scaling
+// group
+type autoScalingGroupSpec struct {
+	tags            map[string]string
+	desiredCapacity *int
+	refresh         *instanceRefreshSpec
+	name            string
+	templateID      string
+	templateName    string
+	templateVersion string
+	healthCheckType string
+	subnets         []string
+	targetGroupARNs []string
+	timeouts        timeouts
+	minSize         int
+	maxSize         int
+	gracePeriod     int
+	waitForCapacity bool
+}
+
+// instanceRefreshSpec holds the instance_refresh settings, which replace a
+// group's instances when its launch template changes
+type instanceRefreshSpec struct {
+	minHealthyPercentage int
+	instanceWarmup       int
+	skipMatching         bool
+	wait                 bool
+}
+
+func parseAutoScalingGroupSpec(config map[string]interface{}) (*autoScalingGroupSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("name", "min_size", "max_size", "subnets", "launch_template")
+
+	spec := &autoScalingGroupSpec{
+		name:            attrs.String("name"),
+		minSize:         attrs.Int("min_size"),
+		maxSize:         attrs.Int("max_size"),
+		subnets:         attrs.StringList("subnets"),
+		targetGroupARNs: attrs.StringList("target_group_arns"),
+		healthCheckType: attrs.String("health_check_type"),
+		gracePeriod:     attrs.Int("health_check_grace_period"),
+		tags:            attrs.StringMap("tags"),
+		timeouts:        parseTimeouts(attrs),
+		waitForCapacity: true,
+	}
+	if attrs.Has("desired_capacity") {
+		desired := attrs.Int("desired_capacity")
+		spec.desiredCapacity = &desired
+	}
+	if attrs.Has("wait_for_capacity") {
+		spec.waitForCapacity = attrs.Bool("wait_for_capacity")
+	}
+	if spec.healthCheckType == "" {
+		spec.healthCheckType = "EC2"
+	}
+
+	template := attrs.Table("launch_template")
+	spec.templateID = template.String("id")
+	spec.templateName = template.String("name")
+	spec.templateVersion = template.Version("version")
+	attrs.collectTable("launch_template", template)
+	if spec.templateVersion == "" {
+		spec.templateVersion = defaultLaunchTemplateVersion
+	}
+
+	if attrs.Has("instance_refresh") {
+		refresh := attrs.Table("instance_refresh")
+		spec.refresh = &instanceRefreshSpec{
+			minHealthyPercentage: 90,
+			instanceWarmup:       refresh.Int("instance_warmup"),
+			skipMatching:         refresh.Bool("skip_matching"),
+			wait:                 refresh.Bool("wait"),
+		}
+		if refresh.Has("min_healthy_percentage") {
+			spec.refresh.minHealthyPercentage = refresh.Int("min_healthy_percentage")
+		}
+		attrs.collectTable("instance_refresh", refresh)
+	}
+
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	if (spec.templateID == "") == (spec.templateName == "") {
+		return nil, fmt.Errorf("launch_template must set exactly one of id and name")
+	}
+	if spec.healthCheckType != "EC2" && spec.healthCheckType != "ELB" {
+		return nil, fmt.Errorf("health_check_type must be EC2 or ELB, got %q", spec.healthCheckType)
+	}
+	return spec, nil
+}
+
+// Version returns the launch template version at key, given as a number,
+// such as a launch template's latest_version, or as $Latest or $Default
+func (a *attributes) Version(key string) string {
+	if s, ok := a.values[key].(string); ok {
+		return s
+	}
+	if !a.Has(key) {
+		return ""
+	}
+	return strconv.Itoa(a.Int(key))
+}
+
+func (s *autoScalingGroupSpec) launchTemplate() *astypes.LaunchTemplateSpecification {
+	t := &astypes.LaunchTemplateSpecification{Version: aws.String(s.templateVersion)}
+	if s.templateID != "" {
+		t.LaunchTemplateId = aws.String(s.templateID)
+	} else {
+		t.LaunchTemplateName = aws.String(s.templateName)
+	}
+	return t
+}
+
+// sameTemplate reports whether the group already launches from the
+// template and version the spec gives
+func (s *autoScalingGroupSpec) sameTemplate(g astypes.AutoScalingGroup) bool {
+	t := g.LaunchTemplate
+	if t == nil {
+		return false
+	}
+	if s.templateID != "" && s.templateID != aws.ToString(t.LaunchTemplateId) {
+		return false
+	}
+	if s.templateName != "" && s.templateName != aws.ToString(t.LaunchTemplateName) {
+		return false
+	}
+	return s.templateVersion == aws.ToString(t.Version)
+}
+
+// groupTags converts tags into auto scaling group tags. Every tag except
+// the idempotency token is copied to the instances the group launches.
+func groupTags(name string, tags map[string]string) []astypes.Tag {
+	out := make([]astypes.Tag, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		out = append(out, astypes.Tag{
+			ResourceId:        aws.String(name),
+			ResourceType:      aws.String("auto-scaling-group"),
+			Key:               aws.String(k),
+			Value:             aws.String(tags[k]),
+			PropagateAtLaunch: aws.Bool(k != tokenTag),
+		})
+	}
+	return out
+}
+
+func fromGroupTags(tags []astypes.TagDescription) map[string]string {
+	out := make(map[string]string, len(tags))
+	for _, t := range tags {
+		out[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return out
+}
+
+// CreateAutoScalingGroup creates an auto scaling group and, unless
+// wait_for_capacity is false, waits until its desired capacity of
+// instances is in service. Groups are named by the config, so the
+// idempotency token tag only lets a retried create adopt its own group.
+func (c *AutoScalingClient) CreateAutoScalingGroup(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseAutoScalingGroupSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string, len(spec.tags)+1)
+	for k, v := range spec.tags {
+		tags[k] = v
+	}
+	if token := provider.IdempotencyToken(ctx); token != "" {
+		tags[tokenTag] = token
+	}
+	input := &autoscaling.CreateAutoScalingGroupInput{
+		AutoScalingGroupName:   aws.String(spec.name),
+		MinSize:                aws.Int32(int32(spec.minSize)),
+		MaxSize:                aws.Int32(int32(spec.maxSize)),
+		LaunchTemplate:         spec.launchTemplate(),
+		VPCZoneIdentifier:      aws.String(strings.Join(spec.subnets, ",")),
+		TargetGroupARNs:        spec.targetGroupARNs,
+		HealthCheckType:        aws.String(spec.healthCheckType),
+		HealthCheckGracePeriod: optionalInt32(spec.gracePeriod),
+		Tags:                   groupTags(spec.name, tags),
+	}
+	if spec.desiredCapacity != nil {
+		input.DesiredCapacity = aws.Int32(int32(*spec.desiredCapacity))
+	}
+
+	_, err = c.client.CreateAutoScalingGroup(ctx, input)
+	switch {
+	case errorCode(err) == "AlreadyExists":
+		if _, err := adopt(ctx, "auto scaling group", spec.name, c.ReadAutoScalingGroup); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to create auto scaling group %s: %w", spec.name, err)
+	}
+
+	if spec.waitForCapacity {
+		if _, err := c.waitForCapacity(ctx, spec.name, spec.timeouts.create); err != nil {
+			return &types.BaseResource{
+				ID:       spec.name,
+				Type:     types.ResourceTypeAutoScalingGroup,
+				Provider: "aws",
+				Status:   types.StatusCreating,
+			}, err
+		}
+	}
+	return c.ReadAutoScalingGroup(ctx, spec.name)
+}
+
+// ReadAutoScalingGroup returns the current state of an auto scaling group.
+// A group being deleted is reported as provider.ErrNotFound.
+func (c *AutoScalingClient) ReadAutoScalingGroup(ctx context.Context, name string) (*types.BaseResource, error) {
+	g, err := c.describeGroup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return autoScalingGroupResource(*g), nil
+}
+
+// FindAutoScalingGroupByToken returns the group tagged with the given
+// idempotency token
+func (c *AutoScalingClient) FindAutoScalingGroupByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	pages := autoscaling.NewDescribeAutoScalingGroupsPaginator(c.client, &autoscaling.DescribeAutoScalingGroupsInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find auto scaling group by token: %w", err)
+		}
+		for _, g := range page.AutoScalingGroups {
+			if g.Status == nil && fromGroupTags(g.Tags)[tokenTag] == token {
+				return autoScalingGroupResource(g), nil
+			}
+		}
+	}
+	return nil, provider.ErrNotFound
+}
+
+// UpdateAutoScalingGroup applies changes to a group's sizes, subnets,
+// launch template, health check, target groups and tags. When the launch
+// template or its version changes and instance_refresh is set, an instance
+// refresh replaces the running instances, cancelling any refresh already
+// under way. The name is fixed at creation.
+func (c *AutoScalingClient) UpdateAutoScalingGroup(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseAutoScalingGroupSpec(config)
+	if err != nil {
+		return err
+	}
+
+	name := resource.GetID()
+	if spec.name != name {
+		return fmt.Errorf("changing name of auto scaling group %s requires replacing it", name)
+	}
+	g, err := c.describeGroup(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	templateChanged := !spec.sameTemplate(*g)
+	if templateChanged ||
+		spec.minSize != int(aws.ToInt32(g.MinSize)) ||
+		spec.maxSize != int(aws.ToInt32(g.MaxSize)) ||
+		(spec.desiredCapacity != nil && *spec.desiredCapacity != int(aws.ToInt32(g.DesiredCapacity))) ||
+		!sameStrings(spec.subnets, groupSubnets(*g)) ||
+		spec.healthCheckType != aws.ToString(g.HealthCheckType) ||
+		(spec.gracePeriod != 0 && spec.gracePeriod != int(aws.ToInt32(g.HealthCheckGracePeriod))) {
+		input := &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName:   aws.String(name),
+			MinSize:                aws.Int32(int32(spec.minSize)),
+			MaxSize:                aws.Int32(int32(spec.maxSize)),
+			LaunchTemplate:         spec.launchTemplate(),
+			VPCZoneIdentifier:      aws.String(strings.Join(spec.subnets, ",")),
+			HealthCheckType:        aws.String(spec.healthCheckType),
+			HealthCheckGracePeriod: optionalInt32(spec.gracePeriod),
+		}
+		if spec.desiredCapacity != nil {
+			input.DesiredCapacity = aws.Int32(int32(*spec.desiredCapacity))
+		}
+		if _, err := c.client.UpdateAutoScalingGroup(ctx, input); err != nil {
+			return fmt.Errorf("failed to update auto scaling group %s: %w", name, err)
+		}
+	}
+
+	if err := c.updateTargetGroups(ctx, name, g.TargetGroupARNs, spec.targetGroupARNs); err != nil {
+		return err
+	}
+	if err := c.updateTags(ctx, name, fromGroupTags(g.Tags), spec.tags); err != nil {
+		return err
+	}
+
+	if templateChanged && spec.refresh != nil {
+		id, err := c.startInstanceRefresh(ctx, name, *spec.refresh, spec.timeouts.update)
+		if err != nil {
+			return err
+		}
+		if spec.refresh.wait {
+			return c.waitForInstanceRefresh(ctx, name, id, spec.timeouts.update)
+		}
+		return nil
+	}
+	if spec.waitForCapacity {
+		_, err := c.waitForCapacity(ctx, name, spec.timeouts.update)
+		return err
+	}
+	return nil
+}
+
+// DeleteAutoScalingGroup deletes a group together with its instances and
+// waits until it is gone. Groups that are already gone are not an error.
+func (c *AutoScalingClient) DeleteAutoScalingGroup(ctx context.Context, resource provider.Resource) error {
+	name := resource.GetID()
+	_, err := c.client.DeleteAutoScalingGroup(ctx, &autoscaling.DeleteAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(name),
+		ForceDelete:          aws.Bool(true),
+	})
+	if err != nil && !isGroupNotFound(err) && errorCode(err) != "ScalingActivityInProgress" {
+		return fmt.Errorf("failed to delete auto scaling group %s: %w", name, err)
+	}
+	return c.waitForGroupDeleted(ctx, name, defaultDeleteTimeout)
+}
+
+// startInstanceRefresh starts a rolling replacement of the group's
+// instances. A refresh already in progress was started for an older
+// template, so it is cancelled first.
+func (c *AutoScalingClient) startInstanceRefresh(ctx context.Context, name string, refresh instanceRefreshSpec, timeout time.Duration) (string, error) {
+	input := &autoscaling.StartInstanceRefreshInput{
+		AutoScalingGroupName: aws.String(name),
+		Strategy:             astypes.RefreshStrategyRolling,
+		Preferences: &astypes.RefreshPreferences{
+			MinHealthyPercentage: aws.Int32(int32(refresh.minHealthyPercentage)),
+			InstanceWarmup:       optionalInt32(refresh.instanceWarmup),
+			SkipMatching:         aws.Bool(refresh.skipMatching),
+		},
+	}
+	result, err := c.client.StartInstanceRefresh(ctx, input)
+	if errorCode(err) == "InstanceRefreshInProgress" {
+		_, err = c.client.CancelInstanceRefresh(ctx, &autoscaling.CancelInstanceRefreshInput{AutoScalingGroupName: aws.String(name)})
+		if err != nil && errorCode(err) != "ActiveInstanceRefreshNotFound" {
+			return "", fmt.Errorf("failed to cancel instance refresh of %s: %w", name, err)
+		}
+		if err := c.waitForNoActiveRefresh(ctx, name, timeout); err != nil {
+			return "", err
+		}
+		result, err = c.client.StartInstanceRefresh(ctx, input)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to start instance refresh of %s: %w", name, err)
+	}
+	return aws.ToString(result.InstanceRefreshId), nil
+}
+
+func (c *AutoScalingClient) updateTargetGroups(ctx context.Context, name string, current, desired []string) error {
+	var attach, detach []string
+	for _, arn := range desired {
+		if !slices.Contains(current, arn) {
+			attach = append(attach, arn)
+		}
+	}
+	for _, arn := range current {
+		if !slices.Contains(desired, arn) {
+			detach = append(detach, arn)
+		}
+	}
+
+	if len(attach) > 0 {
+		_, err := c.client.AttachLoadBalancerTargetGroups(ctx, &autoscaling.AttachLoadBalancerTargetGroupsInput{
+			AutoScalingGroupName: aws.String(name),
+			TargetGroupARNs:      attach,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to attach target groups to %s: %w", name, err)
+		}
+	}
+	if len(detach) > 0 {
+		_, err := c.client.DetachLoadBalancerTargetGroups(ctx, &autoscaling.DetachLoadBalancerTargetGroupsInput{
+			AutoScalingGroupName: aws.String(name),
+			TargetGroupARNs:      detach,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to detach target groups from %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// updateTags applies the difference between current and desired tags.
+// Reserved tags are never removed.
+func (c *AutoScalingClient) updateTags(ctx context.Context, name string, current, desired map[string]string) error {
+	if desired == nil {
+		return nil
+	}
+
+	changed := make(map[string]string)
+	for k, v := range desired {
+		if cur, ok := current[k]; !ok || cur != v {
+			changed[k] = v
+		}
+	}
+	removed := make(map[string]string)
+	for k, v := range current {
+		if _, ok := desired[k]; !ok && !isReservedTag(k) {
+			removed[k] = v
+		}
+	}
+
+	if len(changed) > 0 {
+		_, err := c.client.CreateOrUpdateTags(ctx, &autoscaling.CreateOrUpdateTagsInput{Tags: groupTags(name, changed)})
+		if err != nil {
+			return fmt.Errorf("failed to tag %s: %w", name, err)
+		}
+	}
+	if len(removed) > 0 {
+		_, err := c.client.DeleteTags(ctx, &autoscaling.DeleteTagsInput{Tags: groupTags(name, removed)})
+		if err != nil {
+			return fmt.Errorf("failed to remove tags from %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// describeGroup returns the named group. Auto scaling reports an unknown
+// group as an empty list, or as a validation error for most other calls.
+func (c *AutoScalingClient) describeGroup(ctx context.Context, name string) (*astypes.AutoScalingGroup, error) {
+	result, err := c.client.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{name},
+	})
+	if isGroupNotFound(err) {
+		return nil, fmt.Errorf("auto scaling group %s: %w", name, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auto scaling group %s: %w", name, err)
+	}
+	if len(result.AutoScalingGroups) == 0 || result.AutoScalingGroups[0].Status != nil {
+		return nil, fmt.Errorf("auto scaling group %s: %w", name, provider.ErrNotFound)
+	}
+	return &result.AutoScalingGroups[0], nil
+}
+
+func isGroupNotFound(err error) bool {
+	return errorCode(err) == "ValidationError" && strings.Contains(err.Error(), "not found")
+}
+
+func groupSubnets(g astypes.AutoScalingGroup) []string {
+	if aws.ToString(g.VPCZoneIdentifier) == "" {
+		return nil
+	}
+	return strings.Split(aws.ToString(g.VPCZoneIdentifier), ",")
+}
+
+// inService returns the IDs of the group's healthy instances that are in
+// service, in order
+func inService(g astypes.AutoScalingGroup) []string {
+	var ids []string
+	for _, inst := range g.Instances {
+		if inst.LifecycleState == astypes.LifecycleStateInService && aws.ToString(inst.HealthStatus) == "Healthy" {
+			ids = append(ids, aws.ToString(inst.InstanceId))
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func autoScalingGroupResource(g astypes.AutoScalingGroup) *types.BaseResource {
+	template := map[string]interface{}{}
+	if t := g.LaunchTemplate; t != nil {
+		template["id"] = aws.ToString(t.LaunchTemplateId)
+		template["name"] = aws.ToString(t.LaunchTemplateName)
+		template["version"] = aws.ToString(t.Version)
+	}
+	return &types.BaseResource{
+		ID:       aws.ToString(g.AutoScalingGroupName),
+		Type:     types.ResourceTypeAutoScalingGroup,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"name":                      aws.ToString(g.AutoScalingGroupName),
+			"arn":                       aws.ToString(g.AutoScalingGroupARN),
+			"min_size":                  int(aws.ToInt32(g.MinSize)),
+			"max_size":                  int(aws.ToInt32(g.MaxSize)),
+			"desired_capacity":          int(aws.ToInt32(g.DesiredCapacity)),
+			"launch_template":           template,
+			"subnets":                   interfaceList(groupSubnets(g)),
+			"target_group_arns":         interfaceList(g.TargetGroupARNs),
+			"health_check_type":         aws.ToString(g.HealthCheckType),
+			"health_check_grace_period": int(aws.ToInt32(g.HealthCheckGracePeriod)),
+			"instance_ids":              interfaceList(inService(g)),
+		},
+		Tags:      fromGroupTags(g.Tags),
+		CreatedAt: aws.ToTime(g.CreatedTime),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// readAutoScalingGroupData returns a data source listing the in-service
+// instances of a group with the addresses config management connects to.
+// The instances change as the group scales, so it is read on every plan.
+func readAutoScalingGroupData(asg *AutoScalingClient, ec2Client *EC2Client) dataSource {
+	return func(ctx context.Context, config map[string]interface{}) (map[string]interface{}, error) {
+		attrs := newAttributes(config)
+		attrs.Require("name")
+		name := attrs.String("name")
+		if err := attrs.Err(); err != nil {
+			return nil, err
+		}
+
+		g, err := asg.describeGroup(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		data := dataAttributes(autoScalingGroupResource(*g))
+
+		ids := inService(*g)
+		instances := make([]interface{}, 0, len(ids))
+		hosts := make([]interface{}, 0, len(ids))
+		privateIPs := make([]interface{}, 0, len(ids))
+		if len(ids) > 0 {
+			result, err := ec2Client.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: ids})
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe instances of auto scaling group %s: %w", name, err)
+			}
+			var described []*types.BaseResource
+			for _, r := range result.Reservations {
+				for _, inst := range r.Instances {
+					described = append(described, instanceResource(inst))
+				}
+			}
+			sort.Slice(described, func(i, j int) bool { return described[i].ID < described[j].ID })
+			for _, inst := range described {
+				meta := inst.Metadata
+				instances = append(instances, map[string]interface{}{
+					"id":                inst.ID,
+					"host":              meta["host"],
+					"private_ip":        meta["private_ip"],
+					"public_ip":         meta["public_ip"],
+					"availability_zone": meta["availability_zone"],
+				})
+				hosts = append(hosts, meta["host"])
+				privateIPs = append(privateIPs, meta["private_ip"])
+			}
+		}
+		data["instances"] = instances
+		data["hosts"] = hosts
+		data["private_ips"] = privateIPs
+		return data, nil
+	}
+}
+
+// waitForCapacity polls until the group has at least its desired capacity
+// of healthy instances in service
+func (c *AutoScalingClient) waitForCapacity(ctx context.Context, name string, timeout time.Duration) (*astypes.AutoScalingGroup, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		g, err := c.describeGroup(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(inService(*g)) >= int(aws.ToInt32(g.DesiredCapacity)) {
+			return g, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out after %s waiting for auto scaling group %s to reach its desired capacity", timeout, name)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// waitForInstanceRefresh polls until a refresh succeeds. A refresh that
+// fails, is cancelled or rolls back is an error.
+func (c *AutoScalingClient) waitForInstanceRefresh(ctx context.Context, name, id string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		result, err := c.client.DescribeInstanceRefreshes(ctx, &autoscaling.DescribeInstanceRefreshesInput{
+			AutoScalingGroupName: aws.String(name),
+			InstanceRefreshIds:   []string{id},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read instance refresh %s of %s: %w", id, name, err)
+		}
+		if len(result.InstanceRefreshes) == 0 {
+			return fmt.Errorf("instance refresh %s of %s: %w", id, name, provider.ErrNotFound)
+		}
+		refresh := result.InstanceRefreshes[0]
+		switch refresh.Status {
+		case astypes.InstanceRefreshStatusSuccessful:
+			return nil
+		case astypes.InstanceRefreshStatusFailed, astypes.InstanceRefreshStatusCancelled,
+			astypes.InstanceRefreshStatusRollbackFailed, astypes.InstanceRefreshStatusRollbackSuccessful:
+			return fmt.Errorf("instance refresh %s of %s ended %s: %s", id, name, refresh.Status, aws.ToString(refresh.StatusReason))
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for instance refresh %s of %s", timeout, id, name)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// waitForNoActiveRefresh polls until no refresh of the group is pending,
+// in progress or being cancelled
+func (c *AutoScalingClient) waitForNoActiveRefresh(ctx context.Context, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		result, err := c.client.DescribeInstanceRefreshes(ctx, &autoscaling.DescribeInstanceRefreshesInput{
+			AutoScalingGroupName: aws.String(name),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read instance refreshes of %s: %w", name, err)
+		}
+		active := false
+		for _, r := range result.InstanceRefreshes {
+			switch r.Status {
+			case astypes.InstanceRefreshStatusPending, astypes.InstanceRefreshStatusInProgress,
+				astypes.InstanceRefreshStatusCancelling, astypes.InstanceRefreshStatusRollbackInProgress,
+				astypes.InstanceRefreshStatusBaking:
+				active = true
+			}
+		}
+		if !active {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for instance refresh of %s to be cancelled", timeout, name)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// waitForGroupDeleted polls until the group can no longer be found
+func (c *AutoScalingClient) waitForGroupDeleted(ctx context.Context, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		result, err := c.client.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []string{name},
+		})
+		if err != nil && !isGroupNotFound(err) {
+			return fmt.Errorf("failed to read auto scaling group %s: %w", name, err)
+		}
+		if err != nil || len(result.AutoScalingGroups) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for auto scaling group %s to be deleted", timeout, name)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+func TestAutoScalingGroups(t *testing.T) {
+	p, cloud := newCloudTestProvider(t)
+	ctx := context.Background()
+	_, subnets := newLoadBalancerNetwork(t, p)
+
+	templateConfig := map[string]interface{}{"name": "web", "ami": "ami-12345678", "instance_type": "t3.micro"}
+	lt, err := p.Create(ctx, "launch_template", templateConfig)
+	if err != nil {
+		t.Fatalf("Failed to create launch template: %v", err)
+	}
+	groupConfig := func(version interface{}, desired int) map[string]interface{} {
+		return map[string]interface{}{
+			"name":             "web",
+			"min_size":         1,
+			"max_size":         4,
+			"desired_capacity": desired,
+			"subnets":          subnets[:2],
+			"launch_template":  map[string]interface{}{"id": lt.GetID(), "version": version},
+			"instance_refresh": map[string]interface{}{"min_healthy_percentage": 50, "wait": true},
+			"tags":             map[string]interface{}{"team": "web"},
+		}
+	}
+	// Versions arrive as numbers from launch template metadata
+	config := groupConfig(int64(1), 2)
+
+	var asg provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		asg, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-asg"), "autoscaling_group", config)
+		if err != nil {
+			t.Fatalf("Failed to create auto scaling group: %v", err)
+		}
+		if asg.GetID() != "web" {
+			t.Errorf("Expected the group to be identified by name, got %s", asg.GetID())
+		}
+		if ids := asg.GetMetadata()["instance_ids"].([]interface{}); len(ids) != 2 {
+			t.Errorf("Expected two instances in service, got %v", ids)
+		}
+
+		got, _ := cloud.AutoScaling.Group("web")
+		if got.LaunchTemplateVersion != "1" || got.Tags[tokenTag] != "run-1-asg" {
+			t.Errorf("Unexpected group: %+v", got)
+		}
+		inst, _ := cloud.EC2.Instance(got.Instances[0].ID)
+		if inst.Tags["team"] != "web" || inst.Tags["aws:autoscaling:groupName"] != "web" {
+			t.Errorf("Expected group tags on the instances, got %v", inst.Tags)
+		}
+		if _, ok := inst.Tags[tokenTag]; ok {
+			t.Errorf("Expected the token tag not to propagate, got %v", inst.Tags)
+		}
+
+		again, err := p.Create(provider.WithIdempotencyToken(ctx, "run-1-asg"), "autoscaling_group", config)
+		if err != nil || again.GetID() != "web" {
+			t.Errorf("Expected retried create to return the group, got %v, %v", again, err)
+		}
+		if _, err := p.Create(provider.WithIdempotencyToken(ctx, "run-2-asg"), "autoscaling_group", config); err == nil || !strings.Contains(err.Error(), "not managed by this run") {
+			t.Errorf("Expected an error for a name taken by another run, got %v", err)
+		}
+
+		found, err := p.FindByToken(ctx, "autoscaling_group", "run-1-asg")
+		if err != nil || found.GetID() != "web" {
+			t.Errorf("Expected to find the group by token, got %v, %v", found, err)
+		}
+	})
+
+	t.Run("HostGroup", func(t *testing.T) {
+		data, err := p.ReadData(ctx, "autoscaling_group", map[string]interface{}{"name": "web"})
+		if err != nil {
+			t.Fatalf("Failed to read auto scaling group data: %v", err)
+		}
+		hosts := data["hosts"].([]interface{})
+		instances := data["instances"].([]interface{})
+		if len(hosts) != 2 || len(instances) != 2 {
+			t.Fatalf("Expected two hosts, got %v", data)
+		}
+		first := instances[0].(map[string]interface{})
+		if first["host"] != hosts[0] || first["private_ip"] == "" || first["availability_zone"] == "" {
+			t.Errorf("Unexpected instance: %v", first)
+		}
+
+		if _, err := p.ReadData(ctx, "autoscaling_group", map[string]interface{}{"name": "missing"}); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown group, got %v", err)
+		}
+	})
+
+	t.Run("UnchangedDoesNothing", func(t *testing.T) {
+		if err := p.Update(ctx, asg, config); err != nil {
+			t.Fatalf("Failed to reapply config: %v", err)
+		}
+		if n := cloud.AutoScaling.Calls("UpdateAutoScalingGroup"); n != 0 {
+			t.Errorf("Expected no UpdateAutoScalingGroup call, got %d", n)
+		}
+		if n := cloud.AutoScaling.Calls("StartInstanceRefresh"); n != 0 {
+			t.Errorf("Expected no instance refresh, got %d", n)
+		}
+	})
+
+	t.Run("TemplateChangeRefreshes", func(t *testing.T) {
+		before, _ := cloud.AutoScaling.Group("web")
+
+		templateConfig["instance_type"] = "t3.small"
+		if err := p.Update(ctx, lt, templateConfig); err != nil {
+			t.Fatalf("Failed to update launch template: %v", err)
+		}
+		config = groupConfig(float64(2), 2)
+		if err := p.Update(ctx, asg, config); err != nil {
+			t.Fatalf("Failed to update auto scaling group: %v", err)
+		}
+
+		refreshes := cloud.AutoScaling.InstanceRefreshes("web")
+		if len(refreshes) != 1 || refreshes[0].Status != "Successful" || refreshes[0].MinHealthyPercentage != 50 {
+			t.Fatalf("Expected one successful refresh, got %+v", refreshes)
+		}
+		after, _ := cloud.AutoScaling.Group("web")
+		if len(after.Instances) != 2 {
+			t.Fatalf("Expected two instances after the refresh, got %+v", after.Instances)
+		}
+		for i, inst := range after.Instances {
+			if inst.Version != 2 || inst.ID == before.Instances[i].ID {
+				t.Errorf("Expected instance %d to be replaced from version 2, got %+v", i, inst)
+			}
+		}
+	})
+
+	t.Run("Resize", func(t *testing.T) {
+		config = groupConfig(float64(2), 3)
+		if err := p.Update(ctx, asg, config); err != nil {
+			t.Fatalf("Failed to resize auto scaling group: %v", err)
+		}
+		got, _ := cloud.AutoScaling.Group("web")
+		if got.DesiredCapacity != 3 || len(got.Instances) != 3 {
+			t.Errorf("Expected three instances, got %+v", got)
+		}
+		if n := cloud.AutoScaling.Calls("StartInstanceRefresh"); n != 1 {
+			t.Errorf("Expected resizing not to start a refresh, got %d refreshes", n)
+		}
+	})
+
+	t.Run("TargetGroups", func(t *testing.T) {
+		config["target_group_arns"] = []interface{}{"arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/web/1"}
+		if err := p.Update(ctx, asg, config); err != nil {
+			t.Fatalf("Failed to attach target group: %v", err)
+		}
+		got, _ := cloud.AutoScaling.Group("web")
+		if len(got.TargetGroupARNs) != 1 {
+			t.Errorf("Expected one target group, got %v", got.TargetGroupARNs)
+		}
+
+		delete(config, "target_group_arns")
+		if err := p.Update(ctx, asg, config); err != nil {
+			t.Fatalf("Failed to detach target group: %v", err)
+		}
+		got, _ = cloud.AutoScaling.Group("web")
+		if len(got.TargetGroupARNs) != 0 {
+			t.Errorf("Expected no target groups, got %v", got.TargetGroupARNs)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		for name, change := range map[string]map[string]interface{}{
+			"NoTemplate":      {"launch_template": map[string]interface{}{"version": "$Latest"}},
+			"BothTemplates":   {"launch_template": map[string]interface{}{"id": lt.GetID(), "name": "web"}},
+			"BadHealthCheck":  {"health_check_type": "TCP"},
+			"MissingMinSize":  {"min_size": nil},
+			"DesiredTooLarge": {"desired_capacity": 10},
+		} {
+			invalid := groupConfig("$Latest", 1)
+			invalid["name"] = "invalid"
+			for k, v := range change {
+				invalid[k] = v
+			}
+			if _, err := p.Create(ctx, "autoscaling_group", invalid); err == nil {
+				t.Errorf("%s: expected error, got nil", name)
+			}
+		}
+	})
+
+	t.Run("ReplaceRequired", func(t *testing.T) {
+		renamed := groupConfig(float64(2), 3)
+		renamed["name"] = "api"
+		if err := p.Update(ctx, asg, renamed); err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected renaming to require replacement, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		group, _ := cloud.AutoScaling.Group("web")
+		if err := p.Delete(ctx, asg); err != nil {
+			t.Fatalf("Failed to delete auto scaling group: %v", err)
+		}
+		if _, err := p.Read(ctx, "autoscaling_group", "web"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		if inst, _ := cloud.EC2.Instance(group.Instances[0].ID); inst.State != "terminated" {
+			t.Errorf("Expected the group's instances to be terminated, got %s", inst.State)
+		}
+		if err := p.Delete(ctx, asg); err != nil {
+			t.Errorf("Expected deleting again to succeed, got %v", err)
+		}
+	})
+}
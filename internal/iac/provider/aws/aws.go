@@ -4,31 +4,536 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
 )
 
+// resourceHandler implements the provider operations for one resource type
+type resourceHandler struct {
+	create      func(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error)
+	read        func(ctx context.Context, id string) (*types.BaseResource, error)
+	update      func(ctx context.Context, resource provider.Resource, config map[string]interface{}) error
+	delete      func(ctx context.Context, resource provider.Resource) error
+	findByToken func(ctx context.Context, token string) (*types.BaseResource, error)
+	// discover lists existing resources matching EC2 filters
+	discover func(ctx context.Context, filters []ec2types.Filter) ([]discovered, error)
+	// schema describes the resource type's attributes
+	schema *provider.ResourceSchema
+	// taggable resources accept a tags table, so default tags apply to them
+	taggable bool
+}
+
 type AWSProvider struct {
-	ec2Client *EC2Client
-	region    string
+	cfg         aws.Config
+	ec2Client   *EC2Client
+	iamClient   *IAMClient
+	s3Client    *S3Client
+	elbClient   *ELBClient
+	asgClient   *AutoScalingClient
+	r53Client   *Route53Client
+	handlers    map[string]resourceHandler
+	dataSources map[string]dataSource
+	defaultTags map[string]string
+	region      string
+}
+
+// Option configures how NewAWSProvider connects to AWS
+type Option func(*options)
+
+type options struct {
+	endpoint    string
+	profile     string
+	assumeRole  *AssumeRole
+	defaultTags map[string]string
+}
+
+// AssumeRole describes a role the provider assumes with the credentials it
+// loads, typically to reach another account
+type AssumeRole struct {
+	RoleARN     string
+	ExternalID  string
+	SessionName string
+}
+
+// defaultSessionName names assumed role sessions when no name is given
+const defaultSessionName = "duet"
+
+// WithEndpoint sends every API call to endpoint instead of the AWS service
+// endpoints, for example a local stand-in such as the awstest package
+func WithEndpoint(endpoint string) Option {
+	return func(o *options) {
+		o.endpoint = endpoint
+	}
 }
 
-func NewAWSProvider(ctx context.Context, region string) (*AWSProvider, error) {
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+// WithProfile loads credentials and settings from a named profile in the
+// shared AWS config files instead of the default one
+func WithProfile(profile string) Option {
+	return func(o *options) {
+		o.profile = profile
+	}
+}
+
+// WithAssumeRole makes every API call as the given role, assumed through
+// STS with the loaded credentials and refreshed before it expires
+func WithAssumeRole(role AssumeRole) Option {
+	return func(o *options) {
+		o.assumeRole = &role
+	}
+}
+
+// WithDefaultTags adds tags to every taggable resource the provider creates
+// or updates. Tags set on a resource take precedence, and ownership tags
+// from the context take precedence over both.
+func WithDefaultTags(tags map[string]string) Option {
+	return func(o *options) {
+		o.defaultTags = tags
+	}
+}
+
+func NewAWSProvider(ctx context.Context, region string, opts ...Option) (*AWSProvider, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	loadOpts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+	if o.profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(o.profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load AWS config: %w", err)
 	}
+	if o.endpoint != "" {
+		cfg.BaseEndpoint = aws.String(o.endpoint)
+	}
+
+	if role := o.assumeRole; role != nil {
+		if role.RoleARN == "" {
+			return nil, fmt.Errorf("assume role: a role ARN is required")
+		}
+		creds := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), role.RoleARN, func(ao *stscreds.AssumeRoleOptions) {
+			ao.RoleSessionName = role.SessionName
+			if ao.RoleSessionName == "" {
+				ao.RoleSessionName = defaultSessionName
+			}
+			if role.ExternalID != "" {
+				ao.ExternalID = aws.String(role.ExternalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(creds)
+	}
+
+	p, err := newAWSProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p.defaultTags = o.defaultTags
+	return p, nil
+}
+
+// Config returns the AWS config the provider makes calls with, for clients
+// of other services that should reach the same account and region
+func (p *AWSProvider) Config() aws.Config {
+	return p.cfg
+}
 
+// newAWSProvider builds a provider from a loaded AWS config
+func newAWSProvider(cfg aws.Config) (*AWSProvider, error) {
 	ec2Client, err := NewEC2Client(cfg)
 	if err != nil {
 		return nil, err
 	}
+	iamClient, err := NewIAMClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s3Client, err := NewS3Client(cfg)
+	if err != nil {
+		return nil, err
+	}
+	elbClient, err := NewELBClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	asgClient, err := NewAutoScalingClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r53Client, err := NewRoute53Client(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	return &AWSProvider{
-		region:    region,
+	p := &AWSProvider{
+		cfg:       cfg,
+		region:    cfg.Region,
 		ec2Client: ec2Client,
-	}, nil
+		iamClient: iamClient,
+		s3Client:  s3Client,
+		elbClient: elbClient,
+		asgClient: asgClient,
+		r53Client: r53Client,
+	}
+	p.handlers = map[string]resourceHandler{
+		string(types.ResourceTypeInstance): {
+			create:      ec2Client.CreateInstance,
+			read:        ec2Client.ReadInstance,
+			update:      ec2Client.UpdateInstance,
+			delete:      ec2Client.DeleteInstance,
+			findByToken: ec2Client.FindInstanceByToken,
+			schema:      instanceSchema,
+			discover:    ec2Client.DiscoverInstances,
+			taggable:    true,
+		},
+		string(types.ResourceTypeVolume): {
+			create:      ec2Client.CreateVolume,
+			read:        ec2Client.ReadVolume,
+			update:      ec2Client.UpdateVolume,
+			delete:      ec2Client.DeleteVolume,
+			findByToken: ec2Client.FindVolumeByToken,
+			schema:      volumeSchema,
+			discover:    ec2Client.DiscoverVolumes,
+			taggable:    true,
+		},
+		string(types.ResourceTypeVolumeAttachment): {
+			create:      ec2Client.CreateVolumeAttachment,
+			read:        ec2Client.ReadVolumeAttachment,
+			update:      ec2Client.UpdateVolumeAttachment,
+			delete:      ec2Client.DeleteVolumeAttachment,
+			findByToken: ec2Client.FindVolumeAttachmentByToken,
+			schema:      volumeAttachmentSchema,
+		},
+		string(types.ResourceTypeKeyPair): {
+			create:      ec2Client.CreateKeyPair,
+			read:        ec2Client.ReadKeyPair,
+			update:      ec2Client.UpdateKeyPair,
+			delete:      ec2Client.DeleteKeyPair,
+			findByToken: ec2Client.FindKeyPairByToken,
+			schema:      keyPairSchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeVPC): {
+			create:      ec2Client.CreateVpc,
+			read:        ec2Client.ReadVpc,
+			update:      ec2Client.UpdateVpc,
+			delete:      ec2Client.DeleteVpc,
+			findByToken: ec2Client.FindVpcByToken,
+			schema:      vpcSchema,
+			discover:    ec2Client.DiscoverVpcs,
+			taggable:    true,
+		},
+		string(types.ResourceTypeSubnet): {
+			create:      ec2Client.CreateSubnet,
+			read:        ec2Client.ReadSubnet,
+			update:      ec2Client.UpdateSubnet,
+			delete:      ec2Client.DeleteSubnet,
+			findByToken: ec2Client.FindSubnetByToken,
+			schema:      subnetSchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeInternetGateway): {
+			create:      ec2Client.CreateInternetGateway,
+			read:        ec2Client.ReadInternetGateway,
+			update:      ec2Client.UpdateInternetGateway,
+			delete:      ec2Client.DeleteInternetGateway,
+			findByToken: ec2Client.FindInternetGatewayByToken,
+			schema:      internetGatewaySchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeElasticIP): {
+			create:      ec2Client.CreateElasticIP,
+			read:        ec2Client.ReadElasticIP,
+			update:      ec2Client.UpdateElasticIP,
+			delete:      ec2Client.DeleteElasticIP,
+			findByToken: ec2Client.FindElasticIPByToken,
+			schema:      elasticIPSchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeNATGateway): {
+			create:      ec2Client.CreateNatGateway,
+			read:        ec2Client.ReadNatGateway,
+			update:      ec2Client.UpdateNatGateway,
+			delete:      ec2Client.DeleteNatGateway,
+			findByToken: ec2Client.FindNatGatewayByToken,
+			schema:      natGatewaySchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeRouteTable): {
+			create:      ec2Client.CreateRouteTable,
+			read:        ec2Client.ReadRouteTable,
+			update:      ec2Client.UpdateRouteTable,
+			delete:      ec2Client.DeleteRouteTable,
+			findByToken: ec2Client.FindRouteTableByToken,
+			schema:      routeTableSchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeRouteTableAssociation): {
+			create:      ec2Client.CreateRouteTableAssociation,
+			read:        ec2Client.ReadRouteTableAssociation,
+			update:      ec2Client.UpdateRouteTableAssociation,
+			delete:      ec2Client.DeleteRouteTableAssociation,
+			findByToken: ec2Client.FindRouteTableAssociationByToken,
+			schema:      routeTableAssociationSchema,
+		},
+		string(types.ResourceTypeSecurityGroup): {
+			create:      ec2Client.CreateSecurityGroup,
+			read:        ec2Client.ReadSecurityGroup,
+			update:      ec2Client.UpdateSecurityGroup,
+			delete:      ec2Client.DeleteSecurityGroup,
+			findByToken: ec2Client.FindSecurityGroupByToken,
+			schema:      securityGroupSchema,
+			discover:    ec2Client.DiscoverSecurityGroups,
+			taggable:    true,
+		},
+		string(types.ResourceTypeStorage): {
+			create:      s3Client.CreateBucket,
+			read:        s3Client.ReadBucket,
+			update:      s3Client.UpdateBucket,
+			delete:      s3Client.DeleteBucket,
+			findByToken: s3Client.FindBucketByToken,
+			schema:      bucketSchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeIAMRole): {
+			create:      iamClient.CreateRole,
+			read:        iamClient.ReadRole,
+			update:      iamClient.UpdateRole,
+			delete:      iamClient.DeleteRole,
+			findByToken: iamClient.FindRoleByToken,
+			schema:      roleSchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeIAMPolicy): {
+			create:      iamClient.CreatePolicy,
+			read:        iamClient.ReadPolicy,
+			update:      iamClient.UpdatePolicy,
+			delete:      iamClient.DeletePolicy,
+			findByToken: iamClient.FindPolicyByToken,
+			schema:      policySchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeIAMRolePolicy): {
+			create:      iamClient.CreateRolePolicy,
+			read:        iamClient.ReadRolePolicy,
+			update:      iamClient.UpdateRolePolicy,
+			delete:      iamClient.DeleteRolePolicy,
+			findByToken: iamClient.FindRolePolicyByToken,
+			schema:      rolePolicySchema,
+		},
+		string(types.ResourceTypeIAMRolePolicyAttachment): {
+			create:      iamClient.CreateRolePolicyAttachment,
+			read:        iamClient.ReadRolePolicyAttachment,
+			update:      iamClient.UpdateRolePolicyAttachment,
+			delete:      iamClient.DeleteRolePolicyAttachment,
+			findByToken: iamClient.FindRolePolicyAttachmentByToken,
+			schema:      rolePolicyAttachmentSchema,
+		},
+		string(types.ResourceTypeIAMInstanceProfile): {
+			create:      iamClient.CreateInstanceProfile,
+			read:        iamClient.ReadInstanceProfile,
+			update:      iamClient.UpdateInstanceProfile,
+			delete:      iamClient.DeleteInstanceProfile,
+			findByToken: iamClient.FindInstanceProfileByToken,
+			schema:      instanceProfileSchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeLoadBalancer): {
+			create:      elbClient.CreateLoadBalancer,
+			read:        elbClient.ReadLoadBalancer,
+			update:      elbClient.UpdateLoadBalancer,
+			delete:      elbClient.DeleteLoadBalancer,
+			findByToken: elbClient.FindLoadBalancerByToken,
+			schema:      loadBalancerSchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeLoadBalancerTargetGroup): {
+			create:      elbClient.CreateTargetGroup,
+			read:        elbClient.ReadTargetGroup,
+			update:      elbClient.UpdateTargetGroup,
+			delete:      elbClient.DeleteTargetGroup,
+			findByToken: elbClient.FindTargetGroupByToken,
+			schema:      targetGroupSchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeLoadBalancerListener): {
+			create:      elbClient.CreateListener,
+			read:        elbClient.ReadListener,
+			update:      elbClient.UpdateListener,
+			delete:      elbClient.DeleteListener,
+			findByToken: elbClient.FindListenerByToken,
+			schema:      listenerSchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeLoadBalancerTargetGroupAttachment): {
+			create:      elbClient.CreateTargetGroupAttachment,
+			read:        elbClient.ReadTargetGroupAttachment,
+			update:      elbClient.UpdateTargetGroupAttachment,
+			delete:      elbClient.DeleteTargetGroupAttachment,
+			findByToken: elbClient.FindTargetGroupAttachmentByToken,
+			schema:      targetGroupAttachmentSchema,
+		},
+		string(types.ResourceTypeLaunchTemplate): {
+			create:      ec2Client.CreateLaunchTemplate,
+			read:        ec2Client.ReadLaunchTemplate,
+			update:      ec2Client.UpdateLaunchTemplate,
+			delete:      ec2Client.DeleteLaunchTemplate,
+			findByToken: ec2Client.FindLaunchTemplateByToken,
+			schema:      launchTemplateSchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeAutoScalingGroup): {
+			create:      asgClient.CreateAutoScalingGroup,
+			read:        asgClient.ReadAutoScalingGroup,
+			update:      asgClient.UpdateAutoScalingGroup,
+			delete:      asgClient.DeleteAutoScalingGroup,
+			findByToken: asgClient.FindAutoScalingGroupByToken,
+			schema:      autoScalingGroupSchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeRoute53Zone): {
+			create:      r53Client.CreateHostedZone,
+			read:        r53Client.ReadHostedZone,
+			update:      r53Client.UpdateHostedZone,
+			delete:      r53Client.DeleteHostedZone,
+			findByToken: r53Client.FindHostedZoneByToken,
+			schema:      hostedZoneSchema,
+			taggable:    true,
+		},
+		string(types.ResourceTypeRoute53Record): {
+			create:      r53Client.CreateRecordSet,
+			read:        r53Client.ReadRecordSet,
+			update:      r53Client.UpdateRecordSet,
+			delete:      r53Client.DeleteRecordSet,
+			findByToken: r53Client.FindRecordSetByToken,
+			schema:      recordSetSchema,
+		},
+	}
+	p.dataSources = map[string]dataSource{
+		"ami":                ec2Client.ReadAMI,
+		"autoscaling_group":  readAutoScalingGroupData(asgClient, ec2Client),
+		"availability_zones": ec2Client.ReadAvailabilityZones,
+		"caller_identity":    readCallerIdentity(sts.NewFromConfig(cfg)),
+		"route53_zone":       r53Client.ReadHostedZoneData,
+		"vpc":                ec2Client.ReadVpcData,
+		"subnet":             ec2Client.ReadSubnetData,
+	}
+	return p, nil
 }
 
 func (p *AWSProvider) Name() string {
 	return "aws"
 }
+
+func (p *AWSProvider) handler(resourceType string) (resourceHandler, error) {
+	h, ok := p.handlers[resourceType]
+	if !ok {
+		return resourceHandler{}, fmt.Errorf("aws: unsupported resource type %q", resourceType)
+	}
+	return h, nil
+}
+
+// Create creates a resource of the given type
+func (p *AWSProvider) Create(ctx context.Context, resourceType string, config map[string]interface{}) (provider.Resource, error) {
+	h, err := p.handler(resourceType)
+	if err != nil {
+		return nil, err
+	}
+	return asResource(h.create(ctx, p.withTags(ctx, h, config)))
+}
+
+// Read returns the current state of a resource, or provider.ErrNotFound
+func (p *AWSProvider) Read(ctx context.Context, resourceType string, id string) (provider.Resource, error) {
+	h, err := p.handler(resourceType)
+	if err != nil {
+		return nil, err
+	}
+	return asResource(h.read(ctx, id))
+}
+
+// Update brings an existing resource in line with config
+func (p *AWSProvider) Update(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	h, err := p.handler(string(resource.GetType()))
+	if err != nil {
+		return err
+	}
+	return h.update(ctx, resource, p.withTags(ctx, h, config))
+}
+
+// withTags returns config with the provider's default tags merged under its
+// own and the ownership tags from ctx over them. A tags value that is not a
+// table is left for the resource to reject.
+func (p *AWSProvider) withTags(ctx context.Context, h resourceHandler, config map[string]interface{}) map[string]interface{} {
+	ownership, _ := provider.OwnershipFromContext(ctx)
+	owned := ownership.Tags()
+	if !h.taggable || len(p.defaultTags)+len(owned) == 0 {
+		return config
+	}
+	tags := make(map[string]interface{}, len(p.defaultTags)+len(owned))
+	for k, v := range p.defaultTags {
+		tags[k] = v
+	}
+	switch own := config["tags"].(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, v := range own {
+			tags[k] = v
+		}
+	default:
+		return config
+	}
+	for k, v := range owned {
+		tags[k] = v
+	}
+
+	merged := make(map[string]interface{}, len(config)+1)
+	for k, v := range config {
+		merged[k] = v
+	}
+	merged["tags"] = tags
+	return merged
+}
+
+// Delete removes a resource
+func (p *AWSProvider) Delete(ctx context.Context, resource provider.Resource) error {
+	h, err := p.handler(string(resource.GetType()))
+	if err != nil {
+		return err
+	}
+	return h.delete(ctx, resource)
+}
+
+// FindByToken returns the resource created with an idempotency token
+func (p *AWSProvider) FindByToken(ctx context.Context, resourceType string, token string) (provider.Resource, error) {
+	h, err := p.handler(resourceType)
+	if err != nil {
+		return nil, err
+	}
+	if h.findByToken == nil {
+		return nil, fmt.Errorf("aws: %s resources cannot be found by token", resourceType)
+	}
+	return asResource(h.findByToken(ctx, token))
+}
+
+// SensitiveAttributes returns the metadata attributes of a resource type
+// that are secret, such as the private keys of generated key pairs
+func (p *AWSProvider) SensitiveAttributes(resourceType string) []string {
+	return p.handlers[resourceType].schema.SensitiveAttributes()
+}
+
+// asResource converts a handler result to the interface, keeping a nil
+// pointer from becoming a non-nil provider.Resource that callers would
+// mistake for a created resource
+func asResource(r *types.BaseResource, err error) (provider.Resource, error) {
+	if r == nil {
+		return nil, err
+	}
+	return r, err
+}
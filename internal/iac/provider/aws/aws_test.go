@@ -0,0 +1,116 @@
+package aws
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/internal/iac/provider/aws/awstest"
+)
+
+func TestProviderOptions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+
+	cloud, server := awstest.NewCloudServer()
+	t.Cleanup(server.Close)
+
+	t.Run("Profile", func(t *testing.T) {
+		config := "[profile west]\nregion = us-west-2\n"
+		if err := os.WriteFile(filepath.Join(dir, "config"), []byte(config), 0o600); err != nil {
+			t.Fatalf("Failed to write AWS config: %v", err)
+		}
+		p, err := NewAWSProvider(ctx, "", WithEndpoint(server.URL), WithProfile("west"))
+		if err != nil {
+			t.Fatalf("Failed to create provider: %v", err)
+		}
+		if p.region != "us-west-2" {
+			t.Errorf("Expected the profile's region us-west-2, got %q", p.region)
+		}
+
+		if _, err := NewAWSProvider(ctx, "us-east-1", WithProfile("missing")); err == nil {
+			t.Error("Expected error for a missing profile, got nil")
+		}
+	})
+
+	t.Run("AssumeRole", func(t *testing.T) {
+		p, err := NewAWSProvider(ctx, "us-east-1", WithEndpoint(server.URL), WithAssumeRole(AssumeRole{
+			RoleARN:    "arn:aws:iam::123456789012:role/deploy",
+			ExternalID: "duet-ci",
+		}))
+		if err != nil {
+			t.Fatalf("Failed to create provider: %v", err)
+		}
+
+		identity, err := p.ReadData(ctx, "caller_identity", nil)
+		if err != nil {
+			t.Fatalf("Failed to read caller identity: %v", err)
+		}
+		if want := "arn:aws:sts::123456789012:assumed-role/deploy/duet"; identity["arn"] != want {
+			t.Errorf("Expected to act as %s, got %v", want, identity["arn"])
+		}
+		assumed := cloud.STS.AssumedRoles()
+		if len(assumed) != 1 || assumed[0].ExternalID != "duet-ci" {
+			t.Errorf("Expected one AssumeRole call with the external ID, got %+v", assumed)
+		}
+
+		if _, err := NewAWSProvider(ctx, "us-east-1", WithAssumeRole(AssumeRole{})); err == nil {
+			t.Error("Expected error without a role ARN, got nil")
+		}
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		p, err := NewAWSProvider(ctx, "us-east-1", WithEndpoint(server.URL), WithDefaultTags(map[string]string{
+			"team":  "platform",
+			"stage": "prod",
+		}))
+		if err != nil {
+			t.Fatalf("Failed to create provider: %v", err)
+		}
+
+		config := map[string]interface{}{
+			"cidr_block": "10.0.0.0/16",
+			"tags":       map[string]interface{}{"Name": "main", "stage": "staging"},
+		}
+		vpc, err := p.Create(ctx, "vpc", config)
+		if err != nil {
+			t.Fatalf("Failed to create VPC: %v", err)
+		}
+		got, _ := cloud.EC2.VPC(vpc.GetID())
+		want := map[string]string{"Name": "main", "team": "platform", "stage": "staging"}
+		for k, v := range want {
+			if got.Tags[k] != v {
+				t.Errorf("Expected tag %s=%s, got %q", k, v, got.Tags[k])
+			}
+		}
+		if len(config["tags"].(map[string]interface{})) != 2 {
+			t.Errorf("Expected the caller's config to be left alone, got %v", config["tags"])
+		}
+
+		owned := provider.WithOwnership(ctx, provider.Ownership{Project: "shop", Workspace: "prod", Address: "aws.vpc.edge", RunID: "run-1"})
+		vpc, err = p.Create(owned, "vpc", map[string]interface{}{
+			"cidr_block": "10.1.0.0/16",
+			"tags":       map[string]interface{}{provider.TagProject: "other"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create VPC: %v", err)
+		}
+		got, _ = cloud.EC2.VPC(vpc.GetID())
+		if got.Tags[provider.TagProject] != "shop" || got.Tags[provider.TagAddress] != "aws.vpc.edge" || got.Tags["team"] != "platform" {
+			t.Errorf("Expected ownership tags over the resource's own, got %v", got.Tags)
+		}
+
+		untagged := map[string]interface{}{"volume_id": "vol-1", "instance_id": "i-1"}
+		h, _ := p.handler("volume_attachment")
+		if merged := p.withTags(owned, h, untagged); !reflect.DeepEqual(merged, untagged) {
+			t.Errorf("Expected no tags on a resource that cannot be tagged, got %v", merged)
+		}
+	})
+}
@@ -0,0 +1,716 @@
+package awstest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// groupNameTag is the tag Auto Scaling puts on the instances it launches
+const groupNameTag = "aws:autoscaling:groupName"
+
+// AutoScaling serves the subset of the Auto Scaling Query API duet uses:
+// groups launched from EC2 launch templates and the instance refreshes that
+// roll them onto a new template version. Instances are pending until the
+// group is next described and in service after that. Refreshes start
+// pending, are in progress when first described and replace the group's
+// instances when described again.
+type AutoScaling struct {
+	groups    map[string]*AutoScalingGroup
+	refreshes map[string][]*InstanceRefresh
+	ec2       *EC2
+	calls     []string
+	mu        sync.Mutex
+	nextID    int
+}
+
+// AutoScalingGroup is a snapshot of a group held by the stand-in
+type AutoScalingGroup struct {
+	CreatedTime            time.Time
+	Tags                   map[string]string
+	Name                   string
+	ARN                    string
+	LaunchTemplateID       string
+	LaunchTemplateName     string
+	LaunchTemplateVersion  string
+	HealthCheckType        string
+	Status                 string
+	Subnets                []string
+	TargetGroupARNs        []string
+	Instances              []GroupInstance
+	MinSize                int
+	MaxSize                int
+	DesiredCapacity        int
+	HealthCheckGracePeriod int
+	// propagate lists the tags copied onto launched instances
+	propagate   map[string]bool
+	deletePolls int
+}
+
+// GroupInstance is an instance in an Auto Scaling group, with the launch
+// template version it was launched from
+type GroupInstance struct {
+	ID               string
+	AvailabilityZone string
+	LifecycleState   string
+	HealthStatus     string
+	Version          int
+}
+
+// InstanceRefresh is a snapshot of an instance refresh
+type InstanceRefresh struct {
+	StartTime            time.Time
+	EndTime              time.Time
+	ID                   string
+	Status               string
+	MinHealthyPercentage int
+	InstanceWarmup       int
+	SkipMatching         bool
+}
+
+// NewAutoScaling returns an empty Auto Scaling stand-in. It is an
+// http.Handler, so it can be served by httptest or by a real listener.
+func NewAutoScaling() *AutoScaling {
+	return &AutoScaling{
+		groups:    make(map[string]*AutoScalingGroup),
+		refreshes: make(map[string][]*InstanceRefresh),
+	}
+}
+
+// NewAutoScalingServer starts an Auto Scaling stand-in on a local port.
+// Point a provider at server.URL and call server.Close when done.
+func NewAutoScalingServer() (*AutoScaling, *httptest.Server) {
+	a := NewAutoScaling()
+	return a, httptest.NewServer(a)
+}
+
+// Group returns a copy of the named group
+func (a *AutoScaling) Group(name string) (AutoScalingGroup, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	g, ok := a.groups[name]
+	if !ok {
+		return AutoScalingGroup{}, false
+	}
+	out := *g
+	out.Tags = copyTags(g.Tags)
+	out.Subnets = append([]string(nil), g.Subnets...)
+	out.TargetGroupARNs = append([]string(nil), g.TargetGroupARNs...)
+	out.Instances = append([]GroupInstance(nil), g.Instances...)
+	out.propagate = nil
+	return out, true
+}
+
+// InstanceRefreshes returns copies of the named group's instance refreshes,
+// newest first
+func (a *AutoScaling) InstanceRefreshes(name string) []InstanceRefresh {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []InstanceRefresh
+	for i := len(a.refreshes[name]) - 1; i >= 0; i-- {
+		out = append(out, *a.refreshes[name][i])
+	}
+	return out
+}
+
+// Calls returns how many times the named API action has been called
+func (a *AutoScaling) Calls(action string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := 0
+	for _, c := range a.calls {
+		if c == action {
+			n++
+		}
+	}
+	return n
+}
+
+func (a *AutoScaling) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	action := r.Form.Get("Action")
+	a.calls = append(a.calls, action)
+
+	var (
+		result *autoScalingResult
+		err    *iamError
+	)
+	switch action {
+	case "CreateAutoScalingGroup":
+		result, err = a.createGroup(r.Form)
+	case "DescribeAutoScalingGroups":
+		result, err = a.describeGroups(r.Form)
+	case "UpdateAutoScalingGroup":
+		result, err = a.updateGroup(r.Form)
+	case "DeleteAutoScalingGroup":
+		result, err = a.deleteGroup(r.Form)
+	case "AttachLoadBalancerTargetGroups", "DetachLoadBalancerTargetGroups":
+		result, err = a.changeTargetGroups(action, r.Form)
+	case "CreateOrUpdateTags", "DeleteTags":
+		result, err = a.changeTags(action, r.Form)
+	case "StartInstanceRefresh", "DescribeInstanceRefreshes", "CancelInstanceRefresh":
+		result, err = a.serveRefreshes(action, r.Form)
+	default:
+		err = elbError("InvalidAction", "unsupported action "+action)
+	}
+
+	writeAutoScalingResponse(w, action, result, err)
+}
+
+func (a *AutoScaling) lookup(name string) (*AutoScalingGroup, *iamError) {
+	g, ok := a.groups[name]
+	if !ok || g.deleted() {
+		return nil, elbError("ValidationError", "AutoScalingGroup name not found - AutoScalingGroup '"+name+"' not found")
+	}
+	return g, nil
+}
+
+func (a *AutoScaling) createGroup(form url.Values) (*autoScalingResult, *iamError) {
+	name := form.Get("AutoScalingGroupName")
+	if _, ok := a.groups[name]; ok {
+		return nil, elbError("AlreadyExists", "AutoScalingGroup by this name already exists - A group with the name "+name+" already exists")
+	}
+	if form.Get("LaunchTemplate.LaunchTemplateId") == "" && form.Get("LaunchTemplate.LaunchTemplateName") == "" {
+		return nil, elbError("ValidationError", "Valid requests must contain either LaunchTemplate, LaunchConfigurationName, InstanceId or MixedInstancesPolicy parameter.")
+	}
+	if form.Get("VPCZoneIdentifier") == "" {
+		return nil, elbError("ValidationError", "At least one Availability Zone or VPC Subnet is required.")
+	}
+
+	a.nextID++
+	g := &AutoScalingGroup{
+		Name:        name,
+		ARN:         fmt.Sprintf("arn:aws:autoscaling:us-east-1:%s:autoScalingGroup:%08x-0000-4000-8000-%012x:autoScalingGroupName/%s", accountID, a.nextID, a.nextID, name),
+		CreatedTime: time.Now().UTC().Truncate(time.Second),
+		Tags:        make(map[string]string),
+		propagate:   make(map[string]bool),
+	}
+	g.setLaunchTemplate(form)
+	g.Subnets = strings.Split(form.Get("VPCZoneIdentifier"), ",")
+	g.TargetGroupARNs = indexed(form, "TargetGroupARNs.member")
+	g.HealthCheckType = form.Get("HealthCheckType")
+	if g.HealthCheckType == "" {
+		g.HealthCheckType = "EC2"
+	}
+	g.HealthCheckGracePeriod, _ = strconv.Atoi(form.Get("HealthCheckGracePeriod"))
+	g.MinSize, _ = strconv.Atoi(form.Get("MinSize"))
+	g.MaxSize, _ = strconv.Atoi(form.Get("MaxSize"))
+	g.DesiredCapacity = g.MinSize
+	if form.Has("DesiredCapacity") {
+		g.DesiredCapacity, _ = strconv.Atoi(form.Get("DesiredCapacity"))
+	}
+	if err := g.checkSizes(); err != nil {
+		return nil, err
+	}
+	for _, tag := range groupTags(form) {
+		g.Tags[tag.Key] = tag.Value
+		g.propagate[tag.Key] = tag.PropagateAtLaunch
+	}
+
+	a.groups[name] = g
+	if err := a.scale(g); err != nil {
+		delete(a.groups, name)
+		return nil, err
+	}
+	return &autoScalingResult{}, nil
+}
+
+func (a *AutoScaling) describeGroups(form url.Values) (*autoScalingResult, *iamError) {
+	names := indexed(form, "AutoScalingGroupNames.member")
+	result := &autoScalingResult{}
+	for _, name := range sortedIDs(a.groups) {
+		g := a.groups[name]
+		if len(names) > 0 && !contains(names, name) {
+			continue
+		}
+		if g.deleted() {
+			delete(a.groups, name)
+			continue
+		}
+		result.AutoScalingGroups = append(result.AutoScalingGroups, g.xml())
+		g.advance()
+	}
+	return result, nil
+}
+
+func (a *AutoScaling) updateGroup(form url.Values) (*autoScalingResult, *iamError) {
+	g, err := a.lookup(form.Get("AutoScalingGroupName"))
+	if err != nil {
+		return nil, err
+	}
+	updated := *g
+	updated.setLaunchTemplate(form)
+	if v := form.Get("VPCZoneIdentifier"); v != "" {
+		updated.Subnets = strings.Split(v, ",")
+	}
+	for key, field := range map[string]*int{
+		"MinSize":                &updated.MinSize,
+		"MaxSize":                &updated.MaxSize,
+		"DesiredCapacity":        &updated.DesiredCapacity,
+		"HealthCheckGracePeriod": &updated.HealthCheckGracePeriod,
+	} {
+		if form.Has(key) {
+			*field, _ = strconv.Atoi(form.Get(key))
+		}
+	}
+	if v := form.Get("HealthCheckType"); v != "" {
+		updated.HealthCheckType = v
+	}
+	// Like the real service, resizing the bounds drags the desired
+	// capacity along
+	if !form.Has("DesiredCapacity") {
+		updated.DesiredCapacity = max(updated.MinSize, min(updated.MaxSize, updated.DesiredCapacity))
+	}
+	if err := updated.checkSizes(); err != nil {
+		return nil, err
+	}
+	*g = updated
+	if err := a.scale(g); err != nil {
+		return nil, err
+	}
+	return &autoScalingResult{}, nil
+}
+
+func (a *AutoScaling) deleteGroup(form url.Values) (*autoScalingResult, *iamError) {
+	g, err := a.lookup(form.Get("AutoScalingGroupName"))
+	if err != nil {
+		return nil, err
+	}
+	if g.Status != "" {
+		return nil, elbError("ScalingActivityInProgress", "An AutoScalingGroup delete is already in progress")
+	}
+	if len(g.Instances) > 0 && form.Get("ForceDelete") != "true" {
+		return nil, elbError("ResourceInUse", "You cannot delete an AutoScalingGroup while there are instances or pending Spot instance request(s) still in the group.")
+	}
+	for _, inst := range g.Instances {
+		a.terminate(inst.ID)
+	}
+	g.Instances = nil
+	g.Status = "Delete in progress"
+	g.deletePolls = 1
+	return &autoScalingResult{}, nil
+}
+
+func (a *AutoScaling) changeTargetGroups(action string, form url.Values) (*autoScalingResult, *iamError) {
+	g, err := a.lookup(form.Get("AutoScalingGroupName"))
+	if err != nil {
+		return nil, err
+	}
+	arns := indexed(form, "TargetGroupARNs.member")
+	var kept []string
+	for _, arn := range g.TargetGroupARNs {
+		if !contains(arns, arn) {
+			kept = append(kept, arn)
+		}
+	}
+	if action == "AttachLoadBalancerTargetGroups" {
+		kept = append(kept, arns...)
+	}
+	g.TargetGroupARNs = kept
+	return &autoScalingResult{}, nil
+}
+
+func (a *AutoScaling) changeTags(action string, form url.Values) (*autoScalingResult, *iamError) {
+	for _, tag := range groupTags(form) {
+		g, err := a.lookup(tag.ResourceID)
+		if err != nil {
+			return nil, err
+		}
+		if action == "DeleteTags" {
+			delete(g.Tags, tag.Key)
+			delete(g.propagate, tag.Key)
+			continue
+		}
+		g.Tags[tag.Key] = tag.Value
+		g.propagate[tag.Key] = tag.PropagateAtLaunch
+	}
+	return &autoScalingResult{}, nil
+}
+
+func (a *AutoScaling) serveRefreshes(action string, form url.Values) (*autoScalingResult, *iamError) {
+	name := form.Get("AutoScalingGroupName")
+	g, err := a.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	var active *InstanceRefresh
+	for _, r := range a.refreshes[name] {
+		if r.Status == "Pending" || r.Status == "InProgress" || r.Status == "Cancelling" {
+			active = r
+		}
+	}
+
+	switch action {
+	case "StartInstanceRefresh":
+		if active != nil {
+			return nil, elbError("InstanceRefreshInProgress", "An Instance Refresh is already in progress and blocks the execution of this Instance Refresh.")
+		}
+		a.nextID++
+		r := &InstanceRefresh{
+			ID:           fmt.Sprintf("%08x-0000-4000-8000-%012x", a.nextID, a.nextID),
+			Status:       "Pending",
+			StartTime:    time.Now().UTC().Truncate(time.Second),
+			SkipMatching: form.Get("Preferences.SkipMatching") == "true",
+		}
+		r.MinHealthyPercentage, _ = strconv.Atoi(form.Get("Preferences.MinHealthyPercentage"))
+		r.InstanceWarmup, _ = strconv.Atoi(form.Get("Preferences.InstanceWarmup"))
+		a.refreshes[name] = append(a.refreshes[name], r)
+		return &autoScalingResult{InstanceRefreshID: r.ID}, nil
+
+	case "CancelInstanceRefresh":
+		if active == nil {
+			return nil, elbError("ActiveInstanceRefreshNotFound", "No in progress or pending Instance Refresh found for Auto Scaling group "+name)
+		}
+		active.Status = "Cancelling"
+		return &autoScalingResult{InstanceRefreshID: active.ID}, nil
+	}
+
+	ids := indexed(form, "InstanceRefreshIds.member")
+	result := &autoScalingResult{}
+	for i := len(a.refreshes[name]) - 1; i >= 0; i-- {
+		r := a.refreshes[name][i]
+		if len(ids) > 0 && !contains(ids, r.ID) {
+			continue
+		}
+		result.InstanceRefreshes = append(result.InstanceRefreshes, r.xml(name))
+		if err := a.advanceRefresh(g, r); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// advanceRefresh moves a refresh on a step, replacing the group's instances
+// as it completes
+func (a *AutoScaling) advanceRefresh(g *AutoScalingGroup, r *InstanceRefresh) *iamError {
+	switch r.Status {
+	case "Pending":
+		r.Status = "InProgress"
+	case "Cancelling":
+		r.Status = "Cancelled"
+		r.EndTime = time.Now().UTC().Truncate(time.Second)
+	case "InProgress":
+		current := g.Instances
+		g.Instances = nil
+		for _, inst := range current {
+			if r.SkipMatching && inst.Version == a.templateVersion(g) {
+				g.Instances = append(g.Instances, inst)
+				continue
+			}
+			a.terminate(inst.ID)
+		}
+		if err := a.scale(g); err != nil {
+			return err
+		}
+		r.Status = "Successful"
+		r.EndTime = time.Now().UTC().Truncate(time.Second)
+	}
+	return nil
+}
+
+// scale launches or terminates instances until the group has its desired
+// capacity, spreading launches across its subnets
+func (a *AutoScaling) scale(g *AutoScalingGroup) *iamError {
+	for len(g.Instances) > g.DesiredCapacity {
+		last := g.Instances[len(g.Instances)-1]
+		a.terminate(last.ID)
+		g.Instances = g.Instances[:len(g.Instances)-1]
+	}
+	for len(g.Instances) < g.DesiredCapacity {
+		subnet := g.Subnets[len(g.Instances)%len(g.Subnets)]
+		inst, err := a.launch(g, subnet)
+		if err != nil {
+			return err
+		}
+		g.Instances = append(g.Instances, inst)
+	}
+	return nil
+}
+
+// launch starts one instance for the group. Without an EC2 stand-in the
+// instance only exists in the group.
+func (a *AutoScaling) launch(g *AutoScalingGroup, subnet string) (GroupInstance, *iamError) {
+	inst := GroupInstance{LifecycleState: "Pending", HealthStatus: "Healthy", AvailabilityZone: "us-east-1a", Version: 1}
+	if a.ec2 == nil {
+		a.nextID++
+		inst.ID = fmt.Sprintf("i-%017x", 0xa5000+a.nextID)
+		return inst, nil
+	}
+
+	tags := map[string]string{groupNameTag: g.Name}
+	for k, v := range g.Tags {
+		if g.propagate[k] {
+			tags[k] = v
+		}
+	}
+	launched, version, err := a.ec2.launchFromTemplate(g.templateRef(), g.LaunchTemplateVersion, subnet, tags)
+	if err != nil {
+		return inst, elbError("ValidationError", err.Error())
+	}
+	inst.ID = launched.ID
+	inst.AvailabilityZone = launched.zone
+	inst.Version = version
+	return inst, nil
+}
+
+// templateVersion returns the version number new instances of the group
+// are launched from
+func (a *AutoScaling) templateVersion(g *AutoScalingGroup) int {
+	if a.ec2 == nil {
+		return 1
+	}
+	a.ec2.mu.Lock()
+	defer a.ec2.mu.Unlock()
+	for _, t := range a.ec2.launchTemplates {
+		if t.ID == g.templateRef() || t.Name == g.templateRef() {
+			n, _ := t.resolve(g.LaunchTemplateVersion)
+			return n
+		}
+	}
+	return 0
+}
+
+func (a *AutoScaling) terminate(id string) {
+	if a.ec2 != nil {
+		a.ec2.terminate(id)
+	}
+}
+
+func (g *AutoScalingGroup) setLaunchTemplate(form url.Values) {
+	if id := form.Get("LaunchTemplate.LaunchTemplateId"); id != "" {
+		g.LaunchTemplateID, g.LaunchTemplateName = id, ""
+	}
+	if name := form.Get("LaunchTemplate.LaunchTemplateName"); name != "" {
+		g.LaunchTemplateID, g.LaunchTemplateName = "", name
+	}
+	if form.Has("LaunchTemplate.LaunchTemplateId") || form.Has("LaunchTemplate.LaunchTemplateName") {
+		g.LaunchTemplateVersion = form.Get("LaunchTemplate.Version")
+		if g.LaunchTemplateVersion == "" {
+			g.LaunchTemplateVersion = "$Default"
+		}
+	}
+}
+
+// deleted reports whether a group being deleted is gone
+func (g *AutoScalingGroup) deleted() bool {
+	return g.Status != "" && g.deletePolls <= 0
+}
+
+func (g *AutoScalingGroup) templateRef() string {
+	if g.LaunchTemplateID != "" {
+		return g.LaunchTemplateID
+	}
+	return g.LaunchTemplateName
+}
+
+func (g *AutoScalingGroup) checkSizes() *iamError {
+	if g.MinSize > g.MaxSize {
+		return elbError("ValidationError", fmt.Sprintf("Max bound, %d, must be greater than or equal to min bound, %d", g.MaxSize, g.MinSize))
+	}
+	if g.DesiredCapacity < g.MinSize || g.DesiredCapacity > g.MaxSize {
+		return elbError("ValidationError", fmt.Sprintf("Desired capacity:%d must be between the specified min size:%d and max size:%d", g.DesiredCapacity, g.MinSize, g.MaxSize))
+	}
+	return nil
+}
+
+// advance brings pending instances into service and deleted groups closer
+// to disappearing, each time the group is described
+func (g *AutoScalingGroup) advance() {
+	for i := range g.Instances {
+		if g.Instances[i].LifecycleState == "Pending" {
+			g.Instances[i].LifecycleState = "InService"
+		}
+	}
+	if g.Status != "" {
+		g.deletePolls--
+	}
+}
+
+// groupTag is one entry of a Tags.member list
+type groupTag struct {
+	ResourceID        string
+	Key               string
+	Value             string
+	PropagateAtLaunch bool
+}
+
+func groupTags(form url.Values) []groupTag {
+	var tags []groupTag
+	for i := 1; form.Has(fmt.Sprintf("Tags.member.%d.Key", i)); i++ {
+		prefix := fmt.Sprintf("Tags.member.%d.", i)
+		tags = append(tags, groupTag{
+			ResourceID:        form.Get(prefix + "ResourceId"),
+			Key:               form.Get(prefix + "Key"),
+			Value:             form.Get(prefix + "Value"),
+			PropagateAtLaunch: form.Get(prefix+"PropagateAtLaunch") == "true",
+		})
+	}
+	return tags
+}
+
+func (g *AutoScalingGroup) xml() xmlAutoScalingGroup {
+	out := xmlAutoScalingGroup{
+		AutoScalingGroupName:   g.Name,
+		AutoScalingGroupARN:    g.ARN,
+		LaunchTemplate:         &xmlGroupTemplate{LaunchTemplateID: g.LaunchTemplateID, LaunchTemplateName: g.LaunchTemplateName, Version: g.LaunchTemplateVersion},
+		MinSize:                g.MinSize,
+		MaxSize:                g.MaxSize,
+		DesiredCapacity:        g.DesiredCapacity,
+		DefaultCooldown:        300,
+		HealthCheckType:        g.HealthCheckType,
+		HealthCheckGracePeriod: g.HealthCheckGracePeriod,
+		CreatedTime:            g.CreatedTime.Format(time.RFC3339),
+		VPCZoneIdentifier:      strings.Join(g.Subnets, ","),
+		TargetGroupARNs:        g.TargetGroupARNs,
+		Status:                 g.Status,
+	}
+	zones := make(map[string]bool)
+	for _, inst := range g.Instances {
+		zones[inst.AvailabilityZone] = true
+		out.Instances = append(out.Instances, xmlGroupInstance{
+			InstanceID:       inst.ID,
+			AvailabilityZone: inst.AvailabilityZone,
+			LifecycleState:   inst.LifecycleState,
+			HealthStatus:     inst.HealthStatus,
+			LaunchTemplate:   xmlGroupTemplate{LaunchTemplateID: g.LaunchTemplateID, LaunchTemplateName: g.LaunchTemplateName, Version: strconv.Itoa(inst.Version)},
+		})
+	}
+	for zone := range zones {
+		out.AvailabilityZones = append(out.AvailabilityZones, zone)
+	}
+	sort.Strings(out.AvailabilityZones)
+	for _, k := range sortedKeys(g.Tags) {
+		out.Tags = append(out.Tags, xmlGroupTag{
+			ResourceID:        g.Name,
+			ResourceType:      "auto-scaling-group",
+			Key:               k,
+			Value:             g.Tags[k],
+			PropagateAtLaunch: g.propagate[k],
+		})
+	}
+	return out
+}
+
+func (r *InstanceRefresh) xml(group string) xmlInstanceRefresh {
+	out := xmlInstanceRefresh{
+		InstanceRefreshID:    r.ID,
+		AutoScalingGroupName: group,
+		Status:               r.Status,
+		StartTime:            r.StartTime.Format(time.RFC3339),
+		Preferences: xmlRefreshPreferences{
+			MinHealthyPercentage: r.MinHealthyPercentage,
+			InstanceWarmup:       r.InstanceWarmup,
+			SkipMatching:         r.SkipMatching,
+		},
+	}
+	if r.Status == "Successful" {
+		out.PercentageComplete = 100
+	}
+	if !r.EndTime.IsZero() {
+		out.EndTime = r.EndTime.Format(time.RFC3339)
+	}
+	return out
+}
+
+// writeAutoScalingResponse encodes result, or err as a Query protocol error
+// document
+func writeAutoScalingResponse(w http.ResponseWriter, action string, result *autoScalingResult, err *iamError) {
+	w.Header().Set("Content-Type", "text/xml")
+	if err != nil {
+		w.WriteHeader(err.status)
+		_ = xml.NewEncoder(w).Encode(iamErrorResponse{Error: *err, RequestID: "awstest"})
+		return
+	}
+	result.XMLName = xml.Name{Local: action + "Result"}
+	_ = xml.NewEncoder(w).Encode(autoScalingResponse{
+		XMLName:   xml.Name{Local: action + "Response"},
+		Result:    result,
+		RequestID: "awstest",
+	})
+}
+
+type autoScalingResponse struct {
+	XMLName   xml.Name
+	Result    *autoScalingResult
+	RequestID string `xml:"ResponseMetadata>RequestId"`
+}
+
+// autoScalingResult holds the fields of every result shape the stand-in
+// returns; each action sets only its own
+type autoScalingResult struct {
+	XMLName           xml.Name
+	AutoScalingGroups []xmlAutoScalingGroup `xml:"AutoScalingGroups>member"`
+	InstanceRefreshes []xmlInstanceRefresh  `xml:"InstanceRefreshes>member"`
+	InstanceRefreshID string                `xml:"InstanceRefreshId,omitempty"`
+}
+
+type xmlGroupTemplate struct {
+	LaunchTemplateID   string `xml:"LaunchTemplateId,omitempty"`
+	LaunchTemplateName string `xml:"LaunchTemplateName,omitempty"`
+	Version            string `xml:"Version"`
+}
+
+type xmlGroupInstance struct {
+	InstanceID       string           `xml:"InstanceId"`
+	AvailabilityZone string           `xml:"AvailabilityZone"`
+	LifecycleState   string           `xml:"LifecycleState"`
+	HealthStatus     string           `xml:"HealthStatus"`
+	LaunchTemplate   xmlGroupTemplate `xml:"LaunchTemplate"`
+}
+
+type xmlGroupTag struct {
+	ResourceID        string `xml:"ResourceId"`
+	ResourceType      string `xml:"ResourceType"`
+	Key               string `xml:"Key"`
+	Value             string `xml:"Value"`
+	PropagateAtLaunch bool   `xml:"PropagateAtLaunch"`
+}
+
+type xmlAutoScalingGroup struct {
+	AutoScalingGroupName   string             `xml:"AutoScalingGroupName"`
+	AutoScalingGroupARN    string             `xml:"AutoScalingGroupARN"`
+	LaunchTemplate         *xmlGroupTemplate  `xml:"LaunchTemplate,omitempty"`
+	MinSize                int                `xml:"MinSize"`
+	MaxSize                int                `xml:"MaxSize"`
+	DesiredCapacity        int                `xml:"DesiredCapacity"`
+	DefaultCooldown        int                `xml:"DefaultCooldown"`
+	HealthCheckType        string             `xml:"HealthCheckType"`
+	HealthCheckGracePeriod int                `xml:"HealthCheckGracePeriod"`
+	CreatedTime            string             `xml:"CreatedTime"`
+	VPCZoneIdentifier      string             `xml:"VPCZoneIdentifier"`
+	Status                 string             `xml:"Status,omitempty"`
+	AvailabilityZones      []string           `xml:"AvailabilityZones>member"`
+	TargetGroupARNs        []string           `xml:"TargetGroupARNs>member"`
+	Instances              []xmlGroupInstance `xml:"Instances>member"`
+	Tags                   []xmlGroupTag      `xml:"Tags>member"`
+}
+
+type xmlRefreshPreferences struct {
+	MinHealthyPercentage int  `xml:"MinHealthyPercentage"`
+	InstanceWarmup       int  `xml:"InstanceWarmup"`
+	SkipMatching         bool `xml:"SkipMatching"`
+}
+
+type xmlInstanceRefresh struct {
+	InstanceRefreshID    string                `xml:"InstanceRefreshId"`
+	AutoScalingGroupName string                `xml:"AutoScalingGroupName"`
+	Status               string                `xml:"Status"`
+	StartTime            string                `xml:"StartTime"`
+	EndTime              string                `xml:"EndTime,omitempty"`
+	PercentageComplete   int                   `xml:"PercentageComplete"`
+	Preferences          xmlRefreshPreferences `xml:"Preferences"`
+}
@@ -0,0 +1,83 @@
+package awstest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// API versions the Query protocol services send, which tell them apart
+const (
+	iamVersion         = "2010-05-08"
+	stsVersion         = "2011-06-15"
+	elbVersion         = "2015-12-01"
+	autoScalingVersion = "2011-01-01"
+)
+
+// Cloud serves the EC2, IAM, STS, ELB, Auto Scaling, Route 53, SSM and S3
+// stand-ins from a single endpoint, the way LocalStack does, so one provider
+// endpoint reaches them all. Route 53 is told apart by its path prefix and
+// SSM by its X-Amz-Target header. The rest but S3 share the Query protocol
+// and are told apart by API version; everything else is S3.
+type Cloud struct {
+	EC2         *EC2
+	IAM         *IAM
+	STS         *STS
+	ELB         *ELB
+	AutoScaling *AutoScaling
+	Route53     *Route53
+	SSM         *SSM
+	S3          *S3
+}
+
+// NewCloud returns empty EC2, IAM, STS, ELB, Auto Scaling, Route 53, SSM
+// and S3 stand-ins behind one handler. The ELB stand-in checks subnets and
+// targets against EC2, Auto Scaling groups launch their instances there,
+// private hosted zones must name one of its VPCs, and SSM only runs commands
+// on its running instances.
+func NewCloud() *Cloud {
+	c := &Cloud{EC2: NewEC2(), IAM: NewIAM(), STS: NewSTS(), ELB: NewELB(), AutoScaling: NewAutoScaling(), Route53: NewRoute53(), SSM: NewSSM(), S3: NewS3()}
+	c.ELB.ec2 = c.EC2
+	c.AutoScaling.ec2 = c.EC2
+	c.Route53.ec2 = c.EC2
+	c.SSM.ec2 = c.EC2
+	return c
+}
+
+// NewCloudServer starts the combined stand-in on a local port. Point a
+// provider at server.URL and call server.Close when done.
+func NewCloudServer() (*Cloud, *httptest.Server) {
+	c := NewCloud()
+	return c, httptest.NewServer(c)
+}
+
+func (c *Cloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, route53Prefix) {
+		c.Route53.ServeHTTP(w, r)
+		return
+	}
+	if strings.HasPrefix(r.Header.Get("X-Amz-Target"), ssmTargetPrefix) {
+		c.SSM.ServeHTTP(w, r)
+		return
+	}
+	if r.Method != http.MethodPost || r.URL.Path != "/" {
+		c.S3.ServeHTTP(w, r)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch r.Form.Get("Version") {
+	case iamVersion:
+		c.IAM.ServeHTTP(w, r)
+	case stsVersion:
+		c.STS.ServeHTTP(w, r)
+	case elbVersion:
+		c.ELB.ServeHTTP(w, r)
+	case autoScalingVersion:
+		c.AutoScaling.ServeHTTP(w, r)
+	default:
+		c.EC2.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,841 @@
+// Package awstest provides in-memory stand-ins for the AWS APIs duet uses, so
+// the AWS provider can be exercised without real credentials or resources.
+package awstest
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// EC2 serves the subset of the EC2 Query API duet uses: instances, the VPC
+// networking they run in, the EBS volumes attached to them, the launch
+// templates they can be started from and the key pairs they are accessed
+// with. By default instances are running with passing status checks as
+// soon as they are described; SetLaunchDelay makes them take longer.
+type EC2 struct {
+	instances        map[string]*Instance
+	vpcs             map[string]*VPC
+	subnets          map[string]*Subnet
+	internetGateways map[string]*InternetGateway
+	addresses        map[string]*Address
+	natGateways      map[string]*NatGateway
+	routeTables      map[string]*RouteTable
+	securityGroups   map[string]*SecurityGroup
+	volumes          map[string]*Volume
+	images           map[string]*Image
+	launchTemplates  map[string]*LaunchTemplate
+	keyPairs         map[string]*KeyPair
+	zones            []AvailabilityZone
+	publicIP         string
+	calls            []string
+	order            []string
+	mu               sync.Mutex
+	nextID           int
+	launchDelay      int
+}
+
+// Instance is a snapshot of an instance held by the stand-in
+type Instance struct {
+	LaunchTime     time.Time
+	Tags           map[string]string
+	ID             string
+	ImageID        string
+	InstanceType   string
+	SubnetID       string
+	KeyName        string
+	UserData       string
+	ClientToken    string
+	State          string
+	PrivateIP      string
+	PublicIP       string
+	SecurityGroups []string
+	BlockDevices   []BlockDevice
+	// IamInstanceProfile is the ARN of the instance profile, if any
+	IamInstanceProfile string
+	vpcID              string
+	zone               string
+	associationID      string
+	// Polls left before the instance is running and passes status checks
+	pendingPolls int
+	statusPolls  int
+}
+
+// BlockDevice is an EBS volume attached to an instance at launch
+type BlockDevice struct {
+	DeviceName          string
+	VolumeID            string
+	DeleteOnTermination bool
+}
+
+// NewEC2 returns an empty EC2 stand-in. It is an http.Handler, so it can be
+// served by httptest or by a real listener.
+func NewEC2() *EC2 {
+	return &EC2{
+		instances:        make(map[string]*Instance),
+		vpcs:             make(map[string]*VPC),
+		subnets:          make(map[string]*Subnet),
+		internetGateways: make(map[string]*InternetGateway),
+		addresses:        make(map[string]*Address),
+		natGateways:      make(map[string]*NatGateway),
+		routeTables:      make(map[string]*RouteTable),
+		securityGroups:   make(map[string]*SecurityGroup),
+		volumes:          make(map[string]*Volume),
+		images:           make(map[string]*Image),
+		launchTemplates:  make(map[string]*LaunchTemplate),
+		keyPairs:         make(map[string]*KeyPair),
+		zones:            append([]AvailabilityZone(nil), defaultZones...),
+	}
+}
+
+// NewEC2Server starts an EC2 stand-in on a local port. Point a provider at
+// server.URL and call server.Close when done.
+func NewEC2Server() (*EC2, *httptest.Server) {
+	e := NewEC2()
+	return e, httptest.NewServer(e)
+}
+
+// SetLaunchDelay makes instances that are launched or started afterwards
+// stay pending for the given number of DescribeInstances calls, then fail
+// their status checks for as many DescribeInstanceStatus calls
+func (e *EC2) SetLaunchDelay(polls int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.launchDelay = polls
+}
+
+// SetPublicIP gives instances launched afterwards with a public address
+// the address ip, instead of one from 203.0.113.0/24, so tests can reach a
+// server of their own through it
+func (e *EC2) SetPublicIP(ip string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.publicIP = ip
+}
+
+// Instance returns a copy of the instance with the given ID
+func (e *EC2) Instance(id string) (Instance, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	inst, ok := e.instances[id]
+	if !ok {
+		return Instance{}, false
+	}
+	return inst.snapshot(), true
+}
+
+// Instances returns copies of every instance, in launch order, including
+// terminated ones
+func (e *EC2) Instances() []Instance {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]Instance, 0, len(e.order))
+	for _, id := range e.order {
+		out = append(out, e.instances[id].snapshot())
+	}
+	return out
+}
+
+// Calls returns how many times the named API action has been called
+func (e *EC2) Calls(action string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := 0
+	for _, c := range e.calls {
+		if c == action {
+			n++
+		}
+	}
+	return n
+}
+
+func (e *EC2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	action := r.Form.Get("Action")
+	e.calls = append(e.calls, action)
+
+	var (
+		resp interface{}
+		err  *apiError
+	)
+	switch action {
+	case "RunInstances":
+		resp, err = e.runInstances(r.Form)
+	case "DescribeInstances":
+		resp, err = e.describeInstances(r.Form)
+	case "TerminateInstances":
+		resp, err = e.changeState(r.Form, "terminated", "TerminateInstancesResponse")
+	case "StopInstances":
+		resp, err = e.changeState(r.Form, "stopped", "StopInstancesResponse")
+	case "StartInstances":
+		resp, err = e.changeState(r.Form, "pending", "StartInstancesResponse")
+	case "ModifyInstanceAttribute":
+		resp, err = e.modifyInstanceAttribute(r.Form)
+	case "DescribeInstanceStatus":
+		resp, err = e.describeInstanceStatus(r.Form)
+	case "DescribeInstanceAttribute":
+		resp, err = e.describeInstanceAttribute(r.Form)
+	case "CreateTags":
+		resp, err = e.createTags(r.Form)
+	case "DeleteTags":
+		resp, err = e.deleteTags(r.Form)
+	case "AssociateIamInstanceProfile", "DescribeIamInstanceProfileAssociations",
+		"ReplaceIamInstanceProfileAssociation", "DisassociateIamInstanceProfile":
+		resp, err = e.serveProfileAssociations(action, r.Form)
+	default:
+		resp, err = e.serveVolumes(action, r.Form)
+	}
+
+	writeResponse(w, resp, err)
+}
+
+func (e *EC2) lookup(id string) (*Instance, *apiError) {
+	inst, ok := e.instances[id]
+	if !ok {
+		return nil, &apiError{Code: "InvalidInstanceID.NotFound", Message: "The instance ID '" + id + "' does not exist"}
+	}
+	return inst, nil
+}
+
+func (e *EC2) runInstances(form url.Values) (interface{}, *apiError) {
+	token := form.Get("ClientToken")
+	if token != "" {
+		// A retried launch returns the original instance
+		for _, id := range e.order {
+			if inst := e.instances[id]; inst.ClientToken == token {
+				return runInstancesResponse{ReservationID: "r-" + id, Instances: []xmlInstance{inst.xml()}}, nil
+			}
+		}
+	}
+	if form.Get("ImageId") == "" {
+		return nil, &apiError{Code: "MissingParameter", Message: "The request must contain the parameter ImageId"}
+	}
+
+	inst := e.newInstance()
+	inst.ImageID = form.Get("ImageId")
+	inst.InstanceType = form.Get("InstanceType")
+	inst.SubnetID = form.Get("SubnetId")
+	inst.KeyName = form.Get("KeyName")
+	inst.ClientToken = token
+	inst.SecurityGroups = indexed(form, "SecurityGroupId")
+	if data := form.Get("UserData"); data != "" {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, &apiError{Code: "InvalidParameterValue", Message: "Invalid BASE64 encoding of user data"}
+		}
+		inst.UserData = string(decoded)
+	}
+	for i := 1; form.Has(fmt.Sprintf("BlockDeviceMapping.%d.DeviceName", i)); i++ {
+		prefix := fmt.Sprintf("BlockDeviceMapping.%d.", i)
+		inst.BlockDevices = append(inst.BlockDevices, BlockDevice{
+			DeviceName:          form.Get(prefix + "DeviceName"),
+			VolumeID:            fmt.Sprintf("vol-%017x", e.nextID*100+i),
+			DeleteOnTermination: form.Get(prefix+"Ebs.DeleteOnTermination") == "true",
+		})
+	}
+	for k, v := range tagSpecifications(form, "instance") {
+		inst.Tags[k] = v
+	}
+	inst.IamInstanceProfile = profileArn(form)
+
+	e.place(inst)
+	return runInstancesResponse{ReservationID: "r-" + inst.ID, Instances: []xmlInstance{inst.xml()}}, nil
+}
+
+// newInstance returns a pending instance with a fresh ID and private address
+func (e *EC2) newInstance() *Instance {
+	e.nextID++
+	return &Instance{
+		ID:         fmt.Sprintf("i-%017x", e.nextID),
+		State:      "pending",
+		PrivateIP:  fmt.Sprintf("10.0.%d.%d", e.nextID/250, e.nextID%250+4),
+		Tags:       make(map[string]string),
+		LaunchTime: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// place records a new instance in its subnet's VPC and zone
+func (e *EC2) place(inst *Instance) {
+	if inst.IamInstanceProfile != "" {
+		inst.associationID = fmt.Sprintf("iip-assoc-%017x", e.nextID)
+	}
+
+	// Like the default VPC, instances launched without a subnet get a public
+	// address, as do those in subnets that map one on launch
+	publicIP := inst.SubnetID == ""
+	if inst.SubnetID != "" {
+		inst.vpcID = "vpc-" + inst.SubnetID
+		if subnet, ok := e.subnets[inst.SubnetID]; ok {
+			inst.vpcID = subnet.VpcID
+			publicIP = subnet.MapPublicIPOnLaunch
+		}
+	}
+	inst.zone = e.zoneOf(inst)
+	if publicIP {
+		inst.PublicIP = fmt.Sprintf("203.0.113.%d", e.nextID%250+1)
+		if e.publicIP != "" {
+			inst.PublicIP = e.publicIP
+		}
+	}
+	inst.pendingPolls = e.launchDelay
+	inst.statusPolls = e.launchDelay
+
+	e.instances[inst.ID] = inst
+	e.order = append(e.order, inst.ID)
+}
+
+func (e *EC2) describeInstances(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "InstanceId")
+	for _, id := range ids {
+		if _, err := e.lookup(id); err != nil {
+			return nil, err
+		}
+	}
+
+	filters := make(map[string][]string)
+	for i := 1; form.Has(fmt.Sprintf("Filter.%d.Name", i)); i++ {
+		prefix := fmt.Sprintf("Filter.%d.", i)
+		filters[form.Get(prefix+"Name")] = indexed(form, prefix+"Value")
+	}
+
+	resp := describeInstancesResponse{}
+	for _, id := range e.order {
+		inst := e.instances[id]
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		if !inst.matches(filters) {
+			continue
+		}
+		inst.advance()
+		resp.Reservations = append(resp.Reservations, xmlReservation{
+			ReservationID: "r-" + id,
+			Instances:     []xmlInstance{inst.xml()},
+		})
+	}
+	return resp, nil
+}
+
+func (e *EC2) changeState(form url.Values, state string, name string) (interface{}, *apiError) {
+	resp := stateChangeResponse{XMLName: xml.Name{Local: name}}
+	for _, id := range indexed(form, "InstanceId") {
+		inst, err := e.lookup(id)
+		if err != nil {
+			return nil, err
+		}
+		if inst.State == "terminated" && state != "terminated" {
+			return nil, &apiError{Code: "IncorrectInstanceState", Message: "The instance '" + id + "' is not in a state from which it can be modified"}
+		}
+		previous := inst.State
+		inst.State = state
+		if state == "terminated" {
+			e.detachAll(id)
+		}
+		if state == "pending" {
+			inst.pendingPolls = e.launchDelay
+			inst.statusPolls = e.launchDelay
+		}
+		resp.Changes = append(resp.Changes, xmlStateChange{
+			InstanceID:    id,
+			CurrentState:  xmlState{Code: stateCode(state), Name: state},
+			PreviousState: xmlState{Code: stateCode(previous), Name: previous},
+		})
+	}
+	return resp, nil
+}
+
+func (e *EC2) describeInstanceStatus(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "InstanceId")
+	for _, id := range ids {
+		if _, err := e.lookup(id); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := describeInstanceStatusResponse{}
+	for _, id := range e.order {
+		inst := e.instances[id]
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		// Only running instances are reported unless all are requested
+		if inst.State != "running" && form.Get("IncludeAllInstances") != "true" {
+			continue
+		}
+
+		status := "ok"
+		if inst.State != "running" {
+			status = "not-applicable"
+		} else if inst.statusPolls > 0 {
+			inst.statusPolls--
+			status = "initializing"
+		}
+		resp.Statuses = append(resp.Statuses, xmlInstanceStatus{
+			InstanceID:     id,
+			State:          xmlState{Code: stateCode(inst.State), Name: inst.State},
+			InstanceStatus: xmlStatusSummary{Status: status},
+			SystemStatus:   xmlStatusSummary{Status: status},
+		})
+	}
+	return resp, nil
+}
+
+func (e *EC2) modifyInstanceAttribute(form url.Values) (interface{}, *apiError) {
+	inst, err := e.lookup(form.Get("InstanceId"))
+	if err != nil {
+		return nil, err
+	}
+
+	if form.Has("InstanceType.Value") || form.Has("UserData.Value") {
+		if inst.State != "stopped" {
+			return nil, &apiError{Code: "IncorrectInstanceState", Message: "The instance '" + inst.ID + "' is not in the 'stopped' state"}
+		}
+	}
+	if form.Has("InstanceType.Value") {
+		inst.InstanceType = form.Get("InstanceType.Value")
+	}
+	if form.Has("UserData.Value") {
+		decoded, decodeErr := base64.StdEncoding.DecodeString(form.Get("UserData.Value"))
+		if decodeErr != nil {
+			return nil, &apiError{Code: "InvalidParameterValue", Message: "Invalid BASE64 encoding of user data"}
+		}
+		inst.UserData = string(decoded)
+	}
+	if groups := indexed(form, "GroupId"); len(groups) > 0 {
+		inst.SecurityGroups = groups
+	}
+	return booleanResponse{XMLName: xml.Name{Local: "ModifyInstanceAttributeResponse"}, Return: true}, nil
+}
+
+func (e *EC2) describeInstanceAttribute(form url.Values) (interface{}, *apiError) {
+	inst, err := e.lookup(form.Get("InstanceId"))
+	if err != nil {
+		return nil, err
+	}
+	if form.Get("Attribute") != "userData" {
+		return nil, &apiError{Code: "InvalidParameterValue", Message: "unsupported attribute " + form.Get("Attribute")}
+	}
+	resp := describeInstanceAttributeResponse{InstanceID: inst.ID}
+	if inst.UserData != "" {
+		resp.UserData = &xmlValue{Value: base64.StdEncoding.EncodeToString([]byte(inst.UserData))}
+	}
+	return resp, nil
+}
+
+func (e *EC2) createTags(form url.Values) (interface{}, *apiError) {
+	tags := indexedTags(form, "Tag")
+	for _, id := range indexed(form, "ResourceId") {
+		current, err := e.tagsOf(id)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range tags {
+			current[k] = v
+		}
+	}
+	return booleanResponse{XMLName: xml.Name{Local: "CreateTagsResponse"}, Return: true}, nil
+}
+
+func (e *EC2) deleteTags(form url.Values) (interface{}, *apiError) {
+	tags := indexedTags(form, "Tag")
+	for _, id := range indexed(form, "ResourceId") {
+		current, err := e.tagsOf(id)
+		if err != nil {
+			return nil, err
+		}
+		for k := range tags {
+			delete(current, k)
+		}
+	}
+	return booleanResponse{XMLName: xml.Name{Local: "DeleteTagsResponse"}, Return: true}, nil
+}
+
+// matches reports whether the instance satisfies every DescribeInstances
+// filter the stand-in understands
+func (i *Instance) matches(filters map[string][]string) bool {
+	for name, values := range filters {
+		var actual string
+		switch {
+		case name == "client-token":
+			actual = i.ClientToken
+		case name == "instance-state-name":
+			actual = i.State
+		case name == "tag-key":
+			for k := range i.Tags {
+				if contains(values, k) {
+					actual = k
+				}
+			}
+			if actual == "" {
+				return false
+			}
+			continue
+		case len(name) > 4 && name[:4] == "tag:":
+			v, ok := i.Tags[name[4:]]
+			if !ok {
+				return false
+			}
+			actual = v
+		default:
+			continue
+		}
+		if !contains(values, actual) {
+			return false
+		}
+	}
+	return true
+}
+
+// advance moves a pending instance towards running each time it is described
+func (i *Instance) advance() {
+	if i.State != "pending" {
+		return
+	}
+	if i.pendingPolls > 0 {
+		i.pendingPolls--
+		return
+	}
+	i.State = "running"
+}
+
+func (i *Instance) snapshot() Instance {
+	out := *i
+	out.Tags = make(map[string]string, len(i.Tags))
+	for k, v := range i.Tags {
+		out.Tags[k] = v
+	}
+	out.SecurityGroups = append([]string(nil), i.SecurityGroups...)
+	out.BlockDevices = append([]BlockDevice(nil), i.BlockDevices...)
+	return out
+}
+
+func (i *Instance) xml() xmlInstance {
+	out := xmlInstance{
+		InstanceID:   i.ID,
+		ImageID:      i.ImageID,
+		InstanceType: i.InstanceType,
+		State:        xmlState{Code: stateCode(i.State), Name: i.State},
+		SubnetID:     i.SubnetID,
+		KeyName:      i.KeyName,
+		ClientToken:  i.ClientToken,
+		LaunchTime:   i.LaunchTime.Format(time.RFC3339),
+		Placement:    xmlPlacement{AvailabilityZone: i.zone},
+	}
+	out.VpcID = i.vpcID
+	if i.IamInstanceProfile != "" {
+		out.IamInstanceProfile = &xmlProfile{Arn: i.IamInstanceProfile, ID: profileID(i.IamInstanceProfile)}
+	}
+	if i.State != "terminated" {
+		out.PrivateIP = i.PrivateIP
+		out.PrivateDNS = "ip-" + i.PrivateIP + ".ec2.internal"
+		out.PublicIP = i.PublicIP
+	}
+	for _, g := range i.SecurityGroups {
+		out.Groups = append(out.Groups, xmlGroup{GroupID: g})
+	}
+	out.Tags = xmlTags(i.Tags)
+	for _, d := range i.BlockDevices {
+		out.BlockDevices = append(out.BlockDevices, xmlBlockDevice{
+			DeviceName: d.DeviceName,
+			Ebs: xmlEbs{
+				VolumeID:            d.VolumeID,
+				Status:              "attached",
+				DeleteOnTermination: d.DeleteOnTermination,
+			},
+		})
+	}
+	return out
+}
+
+// serveProfileAssociations handles the actions that change which instance
+// profile a running instance uses
+func (e *EC2) serveProfileAssociations(action string, form url.Values) (interface{}, *apiError) {
+	if action == "DescribeIamInstanceProfileAssociations" {
+		ids := indexed(form, "AssociationId")
+		filters := parseFilters(form)
+		resp := describeAssociationsResponse{}
+		for _, id := range e.order {
+			inst := e.instances[id]
+			if inst.associationID == "" || inst.State == "terminated" {
+				continue
+			}
+			if len(ids) > 0 && !contains(ids, inst.associationID) {
+				continue
+			}
+			if want, ok := filters["instance-id"]; ok && !contains(want, id) {
+				continue
+			}
+			resp.Associations = append(resp.Associations, inst.association("associated"))
+		}
+		return resp, nil
+	}
+
+	if action == "AssociateIamInstanceProfile" {
+		inst, err := e.lookup(form.Get("InstanceId"))
+		if err != nil {
+			return nil, err
+		}
+		if inst.associationID != "" {
+			return nil, &apiError{Code: "IncorrectState", Message: "There is an existing association for instance " + inst.ID}
+		}
+		e.nextID++
+		inst.IamInstanceProfile = profileArn(form)
+		inst.associationID = fmt.Sprintf("iip-assoc-%017x", e.nextID)
+		return associationResponse{XMLName: xml.Name{Local: action + "Response"}, Association: inst.association("associating")}, nil
+	}
+
+	var inst *Instance
+	for _, candidate := range e.instances {
+		if candidate.associationID != "" && candidate.associationID == form.Get("AssociationId") {
+			inst = candidate
+		}
+	}
+	if inst == nil {
+		return nil, &apiError{Code: "InvalidAssociationID.NotFound", Message: "An association ID '" + form.Get("AssociationId") + "' does not exist"}
+	}
+	if action == "ReplaceIamInstanceProfileAssociation" {
+		e.nextID++
+		inst.IamInstanceProfile = profileArn(form)
+		inst.associationID = fmt.Sprintf("iip-assoc-%017x", e.nextID)
+		return associationResponse{XMLName: xml.Name{Local: action + "Response"}, Association: inst.association("associating")}, nil
+	}
+	resp := associationResponse{XMLName: xml.Name{Local: action + "Response"}, Association: inst.association("disassociating")}
+	inst.IamInstanceProfile = ""
+	inst.associationID = ""
+	return resp, nil
+}
+
+func (i *Instance) association(state string) xmlAssociation {
+	return xmlAssociation{
+		AssociationID: i.associationID,
+		InstanceID:    i.ID,
+		Profile:       xmlProfile{Arn: i.IamInstanceProfile, ID: profileID(i.IamInstanceProfile)},
+		State:         state,
+	}
+}
+
+// profileArn returns the instance profile a request names, as an ARN
+func profileArn(form url.Values) string {
+	if arn := form.Get("IamInstanceProfile.Arn"); arn != "" {
+		return arn
+	}
+	if name := form.Get("IamInstanceProfile.Name"); name != "" {
+		return "arn:aws:iam::" + accountID + ":instance-profile/" + name
+	}
+	return ""
+}
+
+// profileID makes up a stable instance profile ID from its ARN
+func profileID(arn string) string {
+	h := fnv.New64a()
+	h.Write([]byte(arn))
+	return fmt.Sprintf("AIPA%017X", h.Sum64())
+}
+
+// writeResponse encodes resp, or err as an EC2 error document
+func writeResponse(w http.ResponseWriter, resp interface{}, err *apiError) {
+	w.Header().Set("Content-Type", "text/xml")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = xml.NewEncoder(w).Encode(errorResponse{Errors: []*apiError{err}, RequestID: "awstest"})
+		return
+	}
+	_ = xml.NewEncoder(w).Encode(resp)
+}
+
+// indexed returns the values of prefix.1, prefix.2, ... until one is missing
+func indexed(form url.Values, prefix string) []string {
+	var out []string
+	for i := 1; form.Has(fmt.Sprintf("%s.%d", prefix, i)); i++ {
+		out = append(out, form.Get(fmt.Sprintf("%s.%d", prefix, i)))
+	}
+	return out
+}
+
+// indexedTags reads prefix.N.Key and prefix.N.Value pairs
+func indexedTags(form url.Values, prefix string) map[string]string {
+	tags := make(map[string]string)
+	for i := 1; form.Has(fmt.Sprintf("%s.%d.Key", prefix, i)); i++ {
+		tags[form.Get(fmt.Sprintf("%s.%d.Key", prefix, i))] = form.Get(fmt.Sprintf("%s.%d.Value", prefix, i))
+	}
+	return tags
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func stateCode(state string) int {
+	switch state {
+	case "pending":
+		return 0
+	case "running":
+		return 16
+	case "shutting-down":
+		return 32
+	case "terminated":
+		return 48
+	case "stopping":
+		return 64
+	default:
+		return 80
+	}
+}
+
+type apiError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type errorResponse struct {
+	XMLName   xml.Name    `xml:"Response"`
+	RequestID string      `xml:"RequestID"`
+	Errors    []*apiError `xml:"Errors>Error"`
+}
+
+type xmlState struct {
+	Name string `xml:"name"`
+	Code int    `xml:"code"`
+}
+
+type xmlPlacement struct {
+	AvailabilityZone string `xml:"availabilityZone"`
+}
+
+type xmlGroup struct {
+	GroupID string `xml:"groupId"`
+}
+
+type xmlTag struct {
+	Key   string `xml:"key"`
+	Value string `xml:"value"`
+}
+
+type xmlEbs struct {
+	VolumeID            string `xml:"volumeId"`
+	Status              string `xml:"status"`
+	DeleteOnTermination bool   `xml:"deleteOnTermination"`
+}
+
+type xmlBlockDevice struct {
+	DeviceName string `xml:"deviceName"`
+	Ebs        xmlEbs `xml:"ebs"`
+}
+
+type xmlValue struct {
+	Value string `xml:"value"`
+}
+
+type xmlInstance struct {
+	State              xmlState         `xml:"instanceState"`
+	Placement          xmlPlacement     `xml:"placement"`
+	InstanceID         string           `xml:"instanceId"`
+	ImageID            string           `xml:"imageId"`
+	InstanceType       string           `xml:"instanceType"`
+	SubnetID           string           `xml:"subnetId,omitempty"`
+	VpcID              string           `xml:"vpcId,omitempty"`
+	KeyName            string           `xml:"keyName,omitempty"`
+	ClientToken        string           `xml:"clientToken,omitempty"`
+	LaunchTime         string           `xml:"launchTime"`
+	PrivateIP          string           `xml:"privateIpAddress,omitempty"`
+	PrivateDNS         string           `xml:"privateDnsName,omitempty"`
+	PublicIP           string           `xml:"ipAddress,omitempty"`
+	Groups             []xmlGroup       `xml:"groupSet>item"`
+	Tags               []xmlTag         `xml:"tagSet>item"`
+	BlockDevices       []xmlBlockDevice `xml:"blockDeviceMapping>item"`
+	IamInstanceProfile *xmlProfile      `xml:"iamInstanceProfile,omitempty"`
+}
+
+type xmlReservation struct {
+	ReservationID string        `xml:"reservationId"`
+	Instances     []xmlInstance `xml:"instancesSet>item"`
+}
+
+type runInstancesResponse struct {
+	XMLName       xml.Name      `xml:"RunInstancesResponse"`
+	ReservationID string        `xml:"reservationId"`
+	Instances     []xmlInstance `xml:"instancesSet>item"`
+}
+
+type describeInstancesResponse struct {
+	XMLName      xml.Name         `xml:"DescribeInstancesResponse"`
+	Reservations []xmlReservation `xml:"reservationSet>item"`
+}
+
+type xmlStateChange struct {
+	InstanceID    string   `xml:"instanceId"`
+	CurrentState  xmlState `xml:"currentState"`
+	PreviousState xmlState `xml:"previousState"`
+}
+
+type stateChangeResponse struct {
+	XMLName xml.Name
+	Changes []xmlStateChange `xml:"instancesSet>item"`
+}
+
+type xmlStatusSummary struct {
+	Status string `xml:"status"`
+}
+
+type xmlInstanceStatus struct {
+	InstanceID     string           `xml:"instanceId"`
+	State          xmlState         `xml:"instanceState"`
+	InstanceStatus xmlStatusSummary `xml:"instanceStatus"`
+	SystemStatus   xmlStatusSummary `xml:"systemStatus"`
+}
+
+type describeInstanceStatusResponse struct {
+	XMLName  xml.Name            `xml:"DescribeInstanceStatusResponse"`
+	Statuses []xmlInstanceStatus `xml:"instanceStatusSet>item"`
+}
+
+type describeInstanceAttributeResponse struct {
+	XMLName    xml.Name  `xml:"DescribeInstanceAttributeResponse"`
+	UserData   *xmlValue `xml:"userData,omitempty"`
+	InstanceID string    `xml:"instanceId"`
+}
+
+type booleanResponse struct {
+	XMLName xml.Name
+	Return  bool `xml:"return"`
+}
+
+type xmlProfile struct {
+	Arn string `xml:"arn"`
+	ID  string `xml:"id"`
+}
+
+type xmlAssociation struct {
+	AssociationID string     `xml:"associationId"`
+	InstanceID    string     `xml:"instanceId"`
+	Profile       xmlProfile `xml:"iamInstanceProfile"`
+	State         string     `xml:"state"`
+}
+
+type associationResponse struct {
+	XMLName     xml.Name
+	Association xmlAssociation `xml:"iamInstanceProfileAssociation"`
+}
+
+type describeAssociationsResponse struct {
+	XMLName      xml.Name         `xml:"DescribeIamInstanceProfileAssociationsResponse"`
+	Associations []xmlAssociation `xml:"iamInstanceProfileAssociationSet>item"`
+}
@@ -0,0 +1,114 @@
+package awstest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+func newClient(t *testing.T) (*EC2, *ec2.Client) {
+	t.Helper()
+	e, server := NewEC2Server()
+	t.Cleanup(server.Close)
+
+	client := ec2.NewFromConfig(aws.Config{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		BaseEndpoint: aws.String(server.URL),
+	})
+	return e, client
+}
+
+func TestEC2(t *testing.T) {
+	e, client := newClient(t)
+	ctx := context.Background()
+
+	run, err := client.RunInstances(ctx, &ec2.RunInstancesInput{
+		ImageId:      aws.String("ami-1"),
+		InstanceType: ec2types.InstanceTypeT3Micro,
+		MinCount:     aws.Int32(1),
+		MaxCount:     aws.Int32(1),
+		ClientToken:  aws.String("token-1"),
+		TagSpecifications: []ec2types.TagSpecification{{
+			ResourceType: ec2types.ResourceTypeInstance,
+			Tags:         []ec2types.Tag{{Key: aws.String("env"), Value: aws.String("test")}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to run instance: %v", err)
+	}
+	id := aws.ToString(run.Instances[0].InstanceId)
+
+	t.Run("Describe", func(t *testing.T) {
+		out, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+			Filters: []ec2types.Filter{{Name: aws.String("tag:env"), Values: []string{"test"}}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to describe instances: %v", err)
+		}
+		if len(out.Reservations) != 1 || aws.ToString(out.Reservations[0].Instances[0].InstanceId) != id {
+			t.Fatalf("Expected to find %s by tag, got %+v", id, out.Reservations)
+		}
+		inst := out.Reservations[0].Instances[0]
+		if inst.State.Name != ec2types.InstanceStateNameRunning || inst.InstanceType != ec2types.InstanceTypeT3Micro {
+			t.Errorf("Unexpected instance: %+v", inst)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{"i-missing"}})
+		var apiErr smithy.APIError
+		if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "InvalidInstanceID.NotFound" {
+			t.Errorf("Expected InvalidInstanceID.NotFound, got %v", err)
+		}
+	})
+
+	t.Run("ModifyRequiresStopped", func(t *testing.T) {
+		_, err := client.ModifyInstanceAttribute(ctx, &ec2.ModifyInstanceAttributeInput{
+			InstanceId:   aws.String(id),
+			InstanceType: &ec2types.AttributeValue{Value: aws.String("t3.small")},
+		})
+		if err == nil {
+			t.Error("Expected error changing the type of a running instance, got nil")
+		}
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		_, err := client.CreateTags(ctx, &ec2.CreateTagsInput{
+			Resources: []string{id},
+			Tags:      []ec2types.Tag{{Key: aws.String("team"), Value: aws.String("ops")}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create tags: %v", err)
+		}
+		_, err = client.DeleteTags(ctx, &ec2.DeleteTagsInput{
+			Resources: []string{id},
+			Tags:      []ec2types.Tag{{Key: aws.String("env")}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to delete tags: %v", err)
+		}
+		inst, _ := e.Instance(id)
+		if len(inst.Tags) != 1 || inst.Tags["team"] != "ops" {
+			t.Errorf("Expected tags {team: ops}, got %v", inst.Tags)
+		}
+	})
+
+	t.Run("Terminate", func(t *testing.T) {
+		if _, err := client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{InstanceIds: []string{id}}); err != nil {
+			t.Fatalf("Failed to terminate instance: %v", err)
+		}
+		inst, _ := e.Instance(id)
+		if inst.State != "terminated" {
+			t.Errorf("Expected instance to be terminated, got %s", inst.State)
+		}
+		if e.Calls("TerminateInstances") != 1 {
+			t.Errorf("Expected one TerminateInstances call, got %d", e.Calls("TerminateInstances"))
+		}
+	})
+}
@@ -0,0 +1,1081 @@
+package awstest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// elbARNPrefix starts the ARN of every Elastic Load Balancing resource
+const elbARNPrefix = "arn:aws:elasticloadbalancing:us-east-1:" + accountID + ":"
+
+// Canonical hosted zones of load balancers in us-east-1, which Route 53
+// alias records point at
+const (
+	applicationZoneID = "Z35SXDOTRQ7X7K"
+	networkZoneID     = "Z26RNL4JYFTOTI"
+)
+
+// ELB serves the subset of the Elastic Load Balancing v2 Query API duet
+// uses: load balancers, target groups, listeners and their rules, and
+// target registrations. Load balancers are described as provisioning the
+// first time and active after that. When backed by an EC2 stand-in, as in a
+// Cloud, subnets and instance targets must exist there.
+type ELB struct {
+	loadBalancers map[string]*LoadBalancer
+	targetGroups  map[string]*TargetGroup
+	listeners     map[string]*Listener
+	ec2           *EC2
+	calls         []string
+	mu            sync.Mutex
+	nextID        int
+}
+
+// LoadBalancer is a snapshot of a load balancer held by the stand-in
+type LoadBalancer struct {
+	CreatedTime    time.Time
+	Tags           map[string]string
+	Arn            string
+	Name           string
+	Type           string
+	Scheme         string
+	VpcID          string
+	State          string
+	DNSName        string
+	Subnets        []string
+	SecurityGroups []string
+}
+
+// TargetGroup is a snapshot of a target group held by the stand-in
+type TargetGroup struct {
+	Tags        map[string]string
+	HealthCheck HealthCheck
+	Arn         string
+	Name        string
+	Protocol    string
+	VpcID       string
+	TargetType  string
+	Targets     []Target
+	Port        int
+}
+
+// HealthCheck is how a target group checks its targets
+type HealthCheck struct {
+	Protocol           string
+	Port               string
+	Path               string
+	Matcher            string
+	Interval           int
+	Timeout            int
+	HealthyThreshold   int
+	UnhealthyThreshold int
+	Enabled            bool
+}
+
+// Target is a registered target. Port is 0 when it takes the target
+// group's port.
+type Target struct {
+	ID   string
+	Port int
+}
+
+// Listener is a snapshot of a listener held by the stand-in
+type Listener struct {
+	Tags            map[string]string
+	Arn             string
+	LoadBalancerArn string
+	Protocol        string
+	CertificateArn  string
+	SslPolicy       string
+	DefaultActions  []Action
+	Rules           []Rule
+	Port            int
+}
+
+// Action is what a listener or rule does with a request
+type Action struct {
+	Type           string
+	TargetGroupArn string
+	// Redirect and fixed response settings, by their API names
+	Config map[string]string
+}
+
+// Rule is a listener rule other than the default one
+type Rule struct {
+	Arn          string
+	PathPatterns []string
+	HostHeaders  []string
+	Actions      []Action
+	Priority     int
+}
+
+// NewELB returns an empty Elastic Load Balancing stand-in that takes any
+// subnet and target as existing
+func NewELB() *ELB {
+	return &ELB{
+		loadBalancers: make(map[string]*LoadBalancer),
+		targetGroups:  make(map[string]*TargetGroup),
+		listeners:     make(map[string]*Listener),
+	}
+}
+
+// NewELBServer starts an Elastic Load Balancing stand-in on a local port.
+// Point a provider at server.URL and call server.Close when done.
+func NewELBServer() (*ELB, *httptest.Server) {
+	e := NewELB()
+	return e, httptest.NewServer(e)
+}
+
+// LoadBalancer returns a copy of the named load balancer
+func (e *ELB) LoadBalancer(name string) (LoadBalancer, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	lb, ok := e.loadBalancerByName(name)
+	if !ok {
+		return LoadBalancer{}, false
+	}
+	out := *lb
+	out.Tags = copyTags(lb.Tags)
+	out.Subnets = append([]string(nil), lb.Subnets...)
+	out.SecurityGroups = append([]string(nil), lb.SecurityGroups...)
+	return out, true
+}
+
+// TargetGroup returns a copy of the named target group
+func (e *ELB) TargetGroup(name string) (TargetGroup, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, tg := range e.targetGroups {
+		if tg.Name == name {
+			out := *tg
+			out.Tags = copyTags(tg.Tags)
+			out.Targets = append([]Target(nil), tg.Targets...)
+			return out, true
+		}
+	}
+	return TargetGroup{}, false
+}
+
+// Listeners returns copies of the listeners of the named load balancer,
+// ordered by port
+func (e *ELB) Listeners(loadBalancer string) []Listener {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	lb, ok := e.loadBalancerByName(loadBalancer)
+	if !ok {
+		return nil
+	}
+	var out []Listener
+	for _, l := range e.listenersOf(lb.Arn) {
+		c := *l
+		c.Tags = copyTags(l.Tags)
+		c.DefaultActions = append([]Action(nil), l.DefaultActions...)
+		c.Rules = append([]Rule(nil), l.Rules...)
+		out = append(out, c)
+	}
+	return out
+}
+
+// Calls returns how many times the named API action has been called
+func (e *ELB) Calls(action string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := 0
+	for _, c := range e.calls {
+		if c == action {
+			n++
+		}
+	}
+	return n
+}
+
+func (e *ELB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	action := r.Form.Get("Action")
+	e.calls = append(e.calls, action)
+
+	var (
+		result *elbResult
+		err    *iamError
+	)
+	switch action {
+	case "CreateLoadBalancer", "DescribeLoadBalancers", "DeleteLoadBalancer", "SetSubnets", "SetSecurityGroups":
+		result, err = e.serveLoadBalancers(action, r.Form)
+	case "CreateTargetGroup", "DescribeTargetGroups", "ModifyTargetGroup", "DeleteTargetGroup",
+		"RegisterTargets", "DeregisterTargets", "DescribeTargetHealth":
+		result, err = e.serveTargetGroups(action, r.Form)
+	case "CreateListener", "DescribeListeners", "ModifyListener", "DeleteListener",
+		"CreateRule", "DescribeRules", "ModifyRule", "DeleteRule":
+		result, err = e.serveListeners(action, r.Form)
+	case "AddTags", "RemoveTags", "DescribeTags":
+		result, err = e.serveTags(action, r.Form)
+	default:
+		err = elbError("InvalidAction", "unsupported action "+action)
+	}
+
+	writeELBResponse(w, action, result, err)
+}
+
+func (e *ELB) newID() string {
+	e.nextID++
+	return fmt.Sprintf("%016x", e.nextID)
+}
+
+func (e *ELB) loadBalancerByName(name string) (*LoadBalancer, bool) {
+	for _, lb := range e.loadBalancers {
+		if lb.Name == name {
+			return lb, true
+		}
+	}
+	return nil, false
+}
+
+func (e *ELB) listenersOf(lbArn string) []*Listener {
+	var out []*Listener
+	for _, arn := range sortedKeys(e.listeners) {
+		if l := e.listeners[arn]; l.LoadBalancerArn == lbArn {
+			out = append(out, l)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Port < out[j].Port })
+	return out
+}
+
+func (e *ELB) serveLoadBalancers(action string, form url.Values) (*elbResult, *iamError) {
+	switch action {
+	case "CreateLoadBalancer":
+		name := form.Get("Name")
+		if _, ok := e.loadBalancerByName(name); ok {
+			return nil, elbError("DuplicateLoadBalancerName", "A load balancer with the same name '"+name+"' exists")
+		}
+		lb := &LoadBalancer{
+			Name:           name,
+			Type:           valueOr(form.Get("Type"), "application"),
+			Scheme:         valueOr(form.Get("Scheme"), "internet-facing"),
+			State:          "provisioning",
+			Subnets:        indexed(form, "Subnets.member"),
+			SecurityGroups: indexed(form, "SecurityGroups.member"),
+			Tags:           indexedTags(form, "Tags.member"),
+			CreatedTime:    time.Now().UTC().Truncate(time.Second),
+		}
+		if len(lb.Subnets) < 2 && lb.Type == "application" {
+			return nil, elbError("ValidationError", "At least two subnets in two different Availability Zones must be specified")
+		}
+		vpcID, err := e.subnetsVPC(lb.Subnets)
+		if err != nil {
+			return nil, err
+		}
+		lb.VpcID = vpcID
+		kind := "app"
+		if lb.Type == "network" {
+			kind = "net"
+			lb.SecurityGroups = nil
+		}
+		id := e.newID()
+		lb.Arn = elbARNPrefix + "loadbalancer/" + kind + "/" + name + "/" + id
+		lb.DNSName = fmt.Sprintf("%s-%s.us-east-1.elb.amazonaws.com", name, id[len(id)-8:])
+		e.loadBalancers[lb.Arn] = lb
+		return &elbResult{LoadBalancers: []xmlLoadBalancer{e.loadBalancerXML(lb)}}, nil
+
+	case "DescribeLoadBalancers":
+		out := &elbResult{}
+		arns, names := indexed(form, "LoadBalancerArns.member"), indexed(form, "Names.member")
+		for _, arn := range arns {
+			lb, ok := e.loadBalancers[arn]
+			if !ok {
+				return nil, loadBalancerNotFound()
+			}
+			out.LoadBalancers = append(out.LoadBalancers, e.loadBalancerXML(lb))
+		}
+		for _, name := range names {
+			lb, ok := e.loadBalancerByName(name)
+			if !ok {
+				return nil, loadBalancerNotFound()
+			}
+			out.LoadBalancers = append(out.LoadBalancers, e.loadBalancerXML(lb))
+		}
+		if len(arns)+len(names) == 0 {
+			for _, arn := range sortedKeys(e.loadBalancers) {
+				out.LoadBalancers = append(out.LoadBalancers, e.loadBalancerXML(e.loadBalancers[arn]))
+			}
+		}
+		// Describing a load balancer moves it along, as time would
+		for _, arn := range arns {
+			e.loadBalancers[arn].State = "active"
+		}
+		for _, name := range names {
+			lb, _ := e.loadBalancerByName(name)
+			lb.State = "active"
+		}
+		return out, nil
+	}
+
+	lb, ok := e.loadBalancers[form.Get("LoadBalancerArn")]
+	if !ok {
+		if action == "DeleteLoadBalancer" {
+			// Deleting a load balancer that does not exist succeeds
+			return &elbResult{}, nil
+		}
+		return nil, loadBalancerNotFound()
+	}
+	switch action {
+	case "SetSubnets":
+		subnets := indexed(form, "Subnets.member")
+		vpcID, err := e.subnetsVPC(subnets)
+		if err != nil {
+			return nil, err
+		}
+		if vpcID != lb.VpcID {
+			return nil, elbError("InvalidSubnet", "subnets must be in VPC "+lb.VpcID)
+		}
+		lb.Subnets = subnets
+		return &elbResult{}, nil
+	case "SetSecurityGroups":
+		if lb.Type != "application" {
+			return nil, elbError("InvalidConfigurationRequest", "security groups can only be set on application load balancers created with them")
+		}
+		lb.SecurityGroups = indexed(form, "SecurityGroups.member")
+		return &elbResult{SecurityGroupIds: lb.SecurityGroups}, nil
+	case "DeleteLoadBalancer":
+		for _, l := range e.listenersOf(lb.Arn) {
+			delete(e.listeners, l.Arn)
+		}
+		delete(e.loadBalancers, lb.Arn)
+	}
+	return &elbResult{}, nil
+}
+
+// subnetsVPC returns the VPC the subnets are in, which must be the same
+// one. Without an EC2 stand-in every subnet is in vpc-awstest.
+func (e *ELB) subnetsVPC(subnets []string) (string, *iamError) {
+	if e.ec2 == nil {
+		return "vpc-awstest", nil
+	}
+	vpcID := ""
+	for _, id := range subnets {
+		subnet, ok := e.ec2.Subnet(id)
+		if !ok {
+			return "", &iamError{Type: "Sender", Code: "SubnetNotFound", Message: "The subnet ID '" + id + "' is not valid", status: http.StatusBadRequest}
+		}
+		if vpcID != "" && subnet.VpcID != vpcID {
+			return "", elbError("InvalidSubnet", "subnets must all be in the same VPC")
+		}
+		vpcID = subnet.VpcID
+	}
+	return vpcID, nil
+}
+
+func (e *ELB) serveTargetGroups(action string, form url.Values) (*elbResult, *iamError) {
+	switch action {
+	case "CreateTargetGroup":
+		name := form.Get("Name")
+		for _, tg := range e.targetGroups {
+			if tg.Name == name {
+				return nil, elbError("DuplicateTargetGroupName", "A target group with the same name '"+name+"' exists")
+			}
+		}
+		port, _ := strconv.Atoi(form.Get("Port"))
+		tg := &TargetGroup{
+			Name:       name,
+			Protocol:   form.Get("Protocol"),
+			Port:       port,
+			VpcID:      form.Get("VpcId"),
+			TargetType: valueOr(form.Get("TargetType"), "instance"),
+			Tags:       indexedTags(form, "Tags.member"),
+			HealthCheck: HealthCheck{
+				Enabled:            true,
+				Protocol:           form.Get("Protocol"),
+				Port:               "traffic-port",
+				Path:               "/",
+				Matcher:            "200",
+				Interval:           30,
+				Timeout:            5,
+				HealthyThreshold:   5,
+				UnhealthyThreshold: 2,
+			},
+		}
+		tg.modifyHealthCheck(form)
+		tg.Arn = elbARNPrefix + "targetgroup/" + name + "/" + e.newID()
+		e.targetGroups[tg.Arn] = tg
+		return &elbResult{TargetGroups: []xmlTargetGroup{e.targetGroupXML(tg)}}, nil
+
+	case "DescribeTargetGroups":
+		out := &elbResult{}
+		arns, names := indexed(form, "TargetGroupArns.member"), indexed(form, "Names.member")
+		for _, arn := range sortedKeys(e.targetGroups) {
+			tg := e.targetGroups[arn]
+			if (len(arns)+len(names) == 0) || contains(arns, arn) || contains(names, tg.Name) {
+				out.TargetGroups = append(out.TargetGroups, e.targetGroupXML(tg))
+			}
+		}
+		if len(out.TargetGroups) < len(arns)+len(names) {
+			return nil, targetGroupNotFound()
+		}
+		return out, nil
+	}
+
+	tg, ok := e.targetGroups[form.Get("TargetGroupArn")]
+	if !ok {
+		return nil, targetGroupNotFound()
+	}
+	switch action {
+	case "ModifyTargetGroup":
+		tg.modifyHealthCheck(form)
+		return &elbResult{TargetGroups: []xmlTargetGroup{e.targetGroupXML(tg)}}, nil
+	case "DeleteTargetGroup":
+		if len(e.targetGroupLoadBalancers(tg.Arn)) > 0 {
+			return nil, &iamError{Type: "Sender", Code: "ResourceInUse", Message: "Target group '" + tg.Arn + "' is currently in use by a listener or a rule", status: http.StatusBadRequest}
+		}
+		delete(e.targetGroups, tg.Arn)
+	case "RegisterTargets":
+		targets := formTargets(form)
+		for _, t := range targets {
+			if err := e.checkTarget(tg, t); err != nil {
+				return nil, err
+			}
+		}
+		for _, t := range targets {
+			if !containsTarget(tg.Targets, t) {
+				tg.Targets = append(tg.Targets, t)
+			}
+		}
+	case "DeregisterTargets":
+		targets := formTargets(form)
+		kept := tg.Targets[:0]
+		for _, t := range tg.Targets {
+			if !containsTarget(targets, t) {
+				kept = append(kept, t)
+			}
+		}
+		tg.Targets = kept
+	case "DescribeTargetHealth":
+		out := &elbResult{TargetHealthDescriptions: []xmlTargetHealthDescription{}}
+		targets := formTargets(form)
+		if len(targets) == 0 {
+			targets = tg.Targets
+		}
+		for _, t := range targets {
+			d := xmlTargetHealthDescription{Target: xmlTarget{ID: t.ID, Port: t.Port}}
+			if d.Target.Port == 0 {
+				d.Target.Port = tg.Port
+			}
+			if containsTarget(tg.Targets, t) {
+				d.TargetHealth.State = "healthy"
+			} else {
+				d.TargetHealth.State = "unused"
+				d.TargetHealth.Reason = "Target.NotRegistered"
+			}
+			out.TargetHealthDescriptions = append(out.TargetHealthDescriptions, d)
+		}
+		return out, nil
+	}
+	return &elbResult{}, nil
+}
+
+// checkTarget rejects instance targets the EC2 stand-in has no running
+// instance for
+func (e *ELB) checkTarget(tg *TargetGroup, t Target) *iamError {
+	if e.ec2 == nil || tg.TargetType != "instance" {
+		return nil
+	}
+	inst, ok := e.ec2.Instance(t.ID)
+	if !ok || inst.State == "terminated" || inst.State == "shutting-down" {
+		return elbError("InvalidTarget", "The following targets are not valid instances: '"+t.ID+"'")
+	}
+	return nil
+}
+
+func (tg *TargetGroup) modifyHealthCheck(form url.Values) {
+	hc := &tg.HealthCheck
+	for param, field := range map[string]*string{
+		"HealthCheckProtocol": &hc.Protocol,
+		"HealthCheckPort":     &hc.Port,
+		"HealthCheckPath":     &hc.Path,
+		"Matcher.HttpCode":    &hc.Matcher,
+	} {
+		if form.Has(param) {
+			*field = form.Get(param)
+		}
+	}
+	for param, field := range map[string]*int{
+		"HealthCheckIntervalSeconds": &hc.Interval,
+		"HealthCheckTimeoutSeconds":  &hc.Timeout,
+		"HealthyThresholdCount":      &hc.HealthyThreshold,
+		"UnhealthyThresholdCount":    &hc.UnhealthyThreshold,
+	} {
+		if form.Has(param) {
+			*field, _ = strconv.Atoi(form.Get(param))
+		}
+	}
+	if form.Has("HealthCheckEnabled") {
+		hc.Enabled = form.Get("HealthCheckEnabled") == "true"
+	}
+}
+
+// targetGroupLoadBalancers returns the load balancers whose listeners or
+// rules forward to the target group
+func (e *ELB) targetGroupLoadBalancers(arn string) []string {
+	var out []string
+	for _, l := range e.listeners {
+		actions := append([]Action(nil), l.DefaultActions...)
+		for _, r := range l.Rules {
+			actions = append(actions, r.Actions...)
+		}
+		for _, a := range actions {
+			if a.TargetGroupArn == arn && !contains(out, l.LoadBalancerArn) {
+				out = append(out, l.LoadBalancerArn)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (e *ELB) serveListeners(action string, form url.Values) (*elbResult, *iamError) {
+	switch action {
+	case "CreateListener":
+		lb, ok := e.loadBalancers[form.Get("LoadBalancerArn")]
+		if !ok {
+			return nil, loadBalancerNotFound()
+		}
+		port, _ := strconv.Atoi(form.Get("Port"))
+		for _, l := range e.listenersOf(lb.Arn) {
+			if l.Port == port {
+				return nil, elbError("DuplicateListener", fmt.Sprintf("A listener already exists on port %d", port))
+			}
+		}
+		actions, err := e.formActions(form, "DefaultActions.member")
+		if err != nil {
+			return nil, err
+		}
+		l := &Listener{
+			LoadBalancerArn: lb.Arn,
+			Port:            port,
+			Protocol:        form.Get("Protocol"),
+			CertificateArn:  form.Get("Certificates.member.1.CertificateArn"),
+			SslPolicy:       form.Get("SslPolicy"),
+			DefaultActions:  actions,
+			Tags:            indexedTags(form, "Tags.member"),
+		}
+		l.Arn = strings.Replace(lb.Arn, ":loadbalancer/", ":listener/", 1) + "/" + e.newID()
+		e.listeners[l.Arn] = l
+		return &elbResult{Listeners: []xmlListener{l.xml()}}, nil
+
+	case "DescribeListeners":
+		out := &elbResult{}
+		if lbArn := form.Get("LoadBalancerArn"); lbArn != "" {
+			if _, ok := e.loadBalancers[lbArn]; !ok {
+				return nil, loadBalancerNotFound()
+			}
+			for _, l := range e.listenersOf(lbArn) {
+				out.Listeners = append(out.Listeners, l.xml())
+			}
+			return out, nil
+		}
+		for _, arn := range indexed(form, "ListenerArns.member") {
+			l, ok := e.listeners[arn]
+			if !ok {
+				return nil, listenerNotFound()
+			}
+			out.Listeners = append(out.Listeners, l.xml())
+		}
+		return out, nil
+
+	case "DescribeRules":
+		out := &elbResult{}
+		l, ok := e.listeners[form.Get("ListenerArn")]
+		if !ok {
+			return nil, listenerNotFound()
+		}
+		for _, r := range l.Rules {
+			out.Rules = append(out.Rules, r.xml())
+		}
+		out.Rules = append(out.Rules, xmlRule{
+			RuleArn:   strings.Replace(l.Arn, ":listener/", ":listener-rule/", 1) + "/default",
+			Priority:  "default",
+			IsDefault: true,
+			Actions:   actionsXML(l.DefaultActions),
+		})
+		return out, nil
+
+	case "ModifyRule", "DeleteRule":
+		l, i := e.rule(form.Get("RuleArn"))
+		if l == nil {
+			return nil, &iamError{Type: "Sender", Code: "RuleNotFound", Message: "One or more rules not found", status: http.StatusBadRequest}
+		}
+		if action == "DeleteRule" {
+			l.Rules = append(l.Rules[:i], l.Rules[i+1:]...)
+			return &elbResult{}, nil
+		}
+		rule := &l.Rules[i]
+		if form.Has("Conditions.member.1.Field") {
+			rule.PathPatterns, rule.HostHeaders = formConditions(form)
+		}
+		if form.Has("Actions.member.1.Type") {
+			actions, err := e.formActions(form, "Actions.member")
+			if err != nil {
+				return nil, err
+			}
+			rule.Actions = actions
+		}
+		return &elbResult{Rules: []xmlRule{rule.xml()}}, nil
+	}
+
+	l, ok := e.listeners[form.Get("ListenerArn")]
+	if !ok {
+		return nil, listenerNotFound()
+	}
+	switch action {
+	case "ModifyListener":
+		if form.Has("Port") {
+			port, _ := strconv.Atoi(form.Get("Port"))
+			for _, other := range e.listenersOf(l.LoadBalancerArn) {
+				if other != l && other.Port == port {
+					return nil, elbError("DuplicateListener", fmt.Sprintf("A listener already exists on port %d", port))
+				}
+			}
+			l.Port = port
+		}
+		if form.Has("Protocol") {
+			l.Protocol = form.Get("Protocol")
+		}
+		if form.Has("Certificates.member.1.CertificateArn") {
+			l.CertificateArn = form.Get("Certificates.member.1.CertificateArn")
+		}
+		if form.Has("SslPolicy") {
+			l.SslPolicy = form.Get("SslPolicy")
+		}
+		if form.Has("DefaultActions.member.1.Type") {
+			actions, err := e.formActions(form, "DefaultActions.member")
+			if err != nil {
+				return nil, err
+			}
+			l.DefaultActions = actions
+		}
+		return &elbResult{Listeners: []xmlListener{l.xml()}}, nil
+	case "DeleteListener":
+		delete(e.listeners, l.Arn)
+	case "CreateRule":
+		priority, _ := strconv.Atoi(form.Get("Priority"))
+		for _, r := range l.Rules {
+			if r.Priority == priority {
+				return nil, elbError("PriorityInUse", fmt.Sprintf("Priority '%d' is currently in use", priority))
+			}
+		}
+		actions, err := e.formActions(form, "Actions.member")
+		if err != nil {
+			return nil, err
+		}
+		rule := Rule{
+			Arn:      strings.Replace(l.Arn, ":listener/", ":listener-rule/", 1) + "/" + e.newID(),
+			Priority: priority,
+			Actions:  actions,
+		}
+		rule.PathPatterns, rule.HostHeaders = formConditions(form)
+		l.Rules = append(l.Rules, rule)
+		sort.Slice(l.Rules, func(i, j int) bool { return l.Rules[i].Priority < l.Rules[j].Priority })
+		return &elbResult{Rules: []xmlRule{rule.xml()}}, nil
+	}
+	return &elbResult{}, nil
+}
+
+// rule returns the listener holding the rule with the given ARN and its
+// index among the listener's rules
+func (e *ELB) rule(arn string) (*Listener, int) {
+	for _, l := range e.listeners {
+		for i, r := range l.Rules {
+			if r.Arn == arn {
+				return l, i
+			}
+		}
+	}
+	return nil, 0
+}
+
+// formActions reads the actions under prefix, checking that target groups
+// they forward to exist
+func (e *ELB) formActions(form url.Values, prefix string) ([]Action, *iamError) {
+	var actions []Action
+	for i := 1; form.Has(fmt.Sprintf("%s.%d.Type", prefix, i)); i++ {
+		p := fmt.Sprintf("%s.%d.", prefix, i)
+		a := Action{Type: form.Get(p + "Type"), TargetGroupArn: form.Get(p + "TargetGroupArn"), Config: map[string]string{}}
+		for key := range form {
+			if rest, ok := strings.CutPrefix(key, p); ok && strings.Contains(rest, "Config.") {
+				a.Config[rest] = form.Get(key)
+			}
+		}
+		switch a.Type {
+		case "forward":
+			if _, ok := e.targetGroups[a.TargetGroupArn]; !ok {
+				return nil, targetGroupNotFound()
+			}
+		case "redirect", "fixed-response":
+		default:
+			return nil, elbError("ValidationError", "unsupported action type "+a.Type)
+		}
+		actions = append(actions, a)
+	}
+	if len(actions) == 0 {
+		return nil, elbError("ValidationError", "at least one action is required")
+	}
+	return actions, nil
+}
+
+// formConditions reads the path pattern and host header conditions of a
+// rule
+func formConditions(form url.Values) (paths, hosts []string) {
+	for i := 1; form.Has(fmt.Sprintf("Conditions.member.%d.Field", i)); i++ {
+		p := fmt.Sprintf("Conditions.member.%d.", i)
+		switch form.Get(p + "Field") {
+		case "path-pattern":
+			paths = append(paths, indexed(form, p+"PathPatternConfig.Values.member")...)
+		case "host-header":
+			hosts = append(hosts, indexed(form, p+"HostHeaderConfig.Values.member")...)
+		}
+	}
+	return paths, hosts
+}
+
+func formTargets(form url.Values) []Target {
+	var targets []Target
+	for i := 1; form.Has(fmt.Sprintf("Targets.member.%d.Id", i)); i++ {
+		port, _ := strconv.Atoi(form.Get(fmt.Sprintf("Targets.member.%d.Port", i)))
+		targets = append(targets, Target{ID: form.Get(fmt.Sprintf("Targets.member.%d.Id", i)), Port: port})
+	}
+	return targets
+}
+
+func containsTarget(targets []Target, t Target) bool {
+	for _, item := range targets {
+		if item == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *ELB) serveTags(action string, form url.Values) (*elbResult, *iamError) {
+	out := &elbResult{}
+	for _, arn := range indexed(form, "ResourceArns.member") {
+		tags := e.tagsOf(arn)
+		if tags == nil {
+			return nil, &iamError{Type: "Sender", Code: "LoadBalancerNotFound", Message: "Resource " + arn + " not found", status: http.StatusBadRequest}
+		}
+		switch action {
+		case "AddTags":
+			for k, v := range indexedTags(form, "Tags.member") {
+				tags[k] = v
+			}
+		case "RemoveTags":
+			for _, k := range indexed(form, "TagKeys.member") {
+				delete(tags, k)
+			}
+		case "DescribeTags":
+			out.TagDescriptions = append(out.TagDescriptions, xmlTagDescription{ResourceArn: arn, Tags: iamTags(tags)})
+		}
+	}
+	return out, nil
+}
+
+// tagsOf returns the tags of the resource with the given ARN, or nil if
+// there is none
+func (e *ELB) tagsOf(arn string) map[string]string {
+	if lb, ok := e.loadBalancers[arn]; ok {
+		return lb.Tags
+	}
+	if tg, ok := e.targetGroups[arn]; ok {
+		return tg.Tags
+	}
+	if l, ok := e.listeners[arn]; ok {
+		return l.Tags
+	}
+	return nil
+}
+
+func (e *ELB) loadBalancerXML(lb *LoadBalancer) xmlLoadBalancer {
+	out := xmlLoadBalancer{
+		LoadBalancerArn:       lb.Arn,
+		LoadBalancerName:      lb.Name,
+		DNSName:               lb.DNSName,
+		CanonicalHostedZoneID: applicationZoneID,
+		Scheme:                lb.Scheme,
+		Type:                  lb.Type,
+		VpcID:                 lb.VpcID,
+		State:                 lb.State,
+		CreatedTime:           lb.CreatedTime.Format(time.RFC3339),
+		SecurityGroups:        lb.SecurityGroups,
+	}
+	if lb.Type == "network" {
+		out.CanonicalHostedZoneID = networkZoneID
+	}
+	for _, id := range lb.Subnets {
+		zone := "us-east-1a"
+		if e.ec2 != nil {
+			if subnet, ok := e.ec2.Subnet(id); ok {
+				zone = subnet.AvailabilityZone
+			}
+		}
+		out.AvailabilityZones = append(out.AvailabilityZones, xmlELBZone{ZoneName: zone, SubnetID: id})
+	}
+	return out
+}
+
+func (e *ELB) targetGroupXML(tg *TargetGroup) xmlTargetGroup {
+	hc := tg.HealthCheck
+	return xmlTargetGroup{
+		TargetGroupArn:             tg.Arn,
+		TargetGroupName:            tg.Name,
+		Protocol:                   tg.Protocol,
+		Port:                       tg.Port,
+		VpcID:                      tg.VpcID,
+		TargetType:                 tg.TargetType,
+		HealthCheckEnabled:         hc.Enabled,
+		HealthCheckProtocol:        hc.Protocol,
+		HealthCheckPort:            hc.Port,
+		HealthCheckPath:            hc.Path,
+		HealthCheckIntervalSeconds: hc.Interval,
+		HealthCheckTimeoutSeconds:  hc.Timeout,
+		HealthyThresholdCount:      hc.HealthyThreshold,
+		UnhealthyThresholdCount:    hc.UnhealthyThreshold,
+		Matcher:                    hc.Matcher,
+		LoadBalancerArns:           e.targetGroupLoadBalancers(tg.Arn),
+	}
+}
+
+func (l *Listener) xml() xmlListener {
+	out := xmlListener{
+		ListenerArn:     l.Arn,
+		LoadBalancerArn: l.LoadBalancerArn,
+		Port:            l.Port,
+		Protocol:        l.Protocol,
+		SslPolicy:       l.SslPolicy,
+		DefaultActions:  actionsXML(l.DefaultActions),
+	}
+	if l.CertificateArn != "" {
+		out.Certificates = []xmlCertificate{{CertificateArn: l.CertificateArn}}
+	}
+	return out
+}
+
+func (r Rule) xml() xmlRule {
+	out := xmlRule{
+		RuleArn:  r.Arn,
+		Priority: strconv.Itoa(r.Priority),
+		Actions:  actionsXML(r.Actions),
+	}
+	if len(r.PathPatterns) > 0 {
+		out.Conditions = append(out.Conditions, xmlCondition{Field: "path-pattern", PathPatterns: r.PathPatterns})
+	}
+	if len(r.HostHeaders) > 0 {
+		out.Conditions = append(out.Conditions, xmlCondition{Field: "host-header", HostHeaders: r.HostHeaders})
+	}
+	return out
+}
+
+func actionsXML(actions []Action) []xmlAction {
+	out := make([]xmlAction, 0, len(actions))
+	for i, a := range actions {
+		x := xmlAction{Type: a.Type, TargetGroupArn: a.TargetGroupArn, Order: i + 1}
+		switch a.Type {
+		case "redirect":
+			x.Redirect = &xmlRedirect{
+				StatusCode: a.Config["RedirectConfig.StatusCode"],
+				Protocol:   a.Config["RedirectConfig.Protocol"],
+				Port:       a.Config["RedirectConfig.Port"],
+				Host:       a.Config["RedirectConfig.Host"],
+				Path:       a.Config["RedirectConfig.Path"],
+				Query:      a.Config["RedirectConfig.Query"],
+			}
+		case "fixed-response":
+			x.FixedResponse = &xmlFixedResponse{
+				StatusCode:  a.Config["FixedResponseConfig.StatusCode"],
+				ContentType: a.Config["FixedResponseConfig.ContentType"],
+				MessageBody: a.Config["FixedResponseConfig.MessageBody"],
+			}
+		}
+		out = append(out, x)
+	}
+	return out
+}
+
+func valueOr(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+func elbError(code, message string) *iamError {
+	return &iamError{Type: "Sender", Code: code, Message: message, status: http.StatusBadRequest}
+}
+
+func loadBalancerNotFound() *iamError {
+	return elbError("LoadBalancerNotFound", "One or more load balancers not found")
+}
+
+func targetGroupNotFound() *iamError {
+	return elbError("TargetGroupNotFound", "One or more target groups not found")
+}
+
+func listenerNotFound() *iamError {
+	return elbError("ListenerNotFound", "One or more listeners not found")
+}
+
+// writeELBResponse encodes result the way writeIAMResponse does; the two
+// APIs share the Query protocol's response and error documents
+func writeELBResponse(w http.ResponseWriter, action string, result *elbResult, err *iamError) {
+	w.Header().Set("Content-Type", "text/xml")
+	if err != nil {
+		w.WriteHeader(err.status)
+		_ = xml.NewEncoder(w).Encode(iamErrorResponse{Error: *err, RequestID: "awstest"})
+		return
+	}
+	result.XMLName = xml.Name{Local: action + "Result"}
+	_ = xml.NewEncoder(w).Encode(elbResponse{
+		XMLName:   xml.Name{Local: action + "Response"},
+		Result:    result,
+		RequestID: "awstest",
+	})
+}
+
+type elbResponse struct {
+	XMLName   xml.Name
+	Result    *elbResult
+	RequestID string `xml:"ResponseMetadata>RequestId"`
+}
+
+// elbResult holds the fields of every result shape the stand-in returns;
+// each action sets only its own
+type elbResult struct {
+	XMLName                  xml.Name
+	LoadBalancers            []xmlLoadBalancer            `xml:"LoadBalancers>member"`
+	TargetGroups             []xmlTargetGroup             `xml:"TargetGroups>member"`
+	Listeners                []xmlListener                `xml:"Listeners>member"`
+	Rules                    []xmlRule                    `xml:"Rules>member"`
+	TargetHealthDescriptions []xmlTargetHealthDescription `xml:"TargetHealthDescriptions>member"`
+	TagDescriptions          []xmlTagDescription          `xml:"TagDescriptions>member"`
+	SecurityGroupIds         []string                     `xml:"SecurityGroupIds>member"`
+}
+
+type xmlLoadBalancer struct {
+	LoadBalancerArn       string       `xml:"LoadBalancerArn"`
+	LoadBalancerName      string       `xml:"LoadBalancerName"`
+	DNSName               string       `xml:"DNSName"`
+	CanonicalHostedZoneID string       `xml:"CanonicalHostedZoneId"`
+	Scheme                string       `xml:"Scheme"`
+	Type                  string       `xml:"Type"`
+	VpcID                 string       `xml:"VpcId"`
+	State                 string       `xml:"State>Code"`
+	CreatedTime           string       `xml:"CreatedTime"`
+	AvailabilityZones     []xmlELBZone `xml:"AvailabilityZones>member"`
+	SecurityGroups        []string     `xml:"SecurityGroups>member"`
+}
+
+type xmlELBZone struct {
+	ZoneName string `xml:"ZoneName"`
+	SubnetID string `xml:"SubnetId"`
+}
+
+type xmlTargetGroup struct {
+	TargetGroupArn             string   `xml:"TargetGroupArn"`
+	TargetGroupName            string   `xml:"TargetGroupName"`
+	Protocol                   string   `xml:"Protocol,omitempty"`
+	VpcID                      string   `xml:"VpcId,omitempty"`
+	TargetType                 string   `xml:"TargetType"`
+	HealthCheckProtocol        string   `xml:"HealthCheckProtocol,omitempty"`
+	HealthCheckPort            string   `xml:"HealthCheckPort,omitempty"`
+	HealthCheckPath            string   `xml:"HealthCheckPath,omitempty"`
+	Matcher                    string   `xml:"Matcher>HttpCode"`
+	LoadBalancerArns           []string `xml:"LoadBalancerArns>member"`
+	Port                       int      `xml:"Port"`
+	HealthCheckIntervalSeconds int      `xml:"HealthCheckIntervalSeconds"`
+	HealthCheckTimeoutSeconds  int      `xml:"HealthCheckTimeoutSeconds"`
+	HealthyThresholdCount      int      `xml:"HealthyThresholdCount"`
+	UnhealthyThresholdCount    int      `xml:"UnhealthyThresholdCount"`
+	HealthCheckEnabled         bool     `xml:"HealthCheckEnabled"`
+}
+
+type xmlListener struct {
+	ListenerArn     string           `xml:"ListenerArn"`
+	LoadBalancerArn string           `xml:"LoadBalancerArn"`
+	Protocol        string           `xml:"Protocol"`
+	SslPolicy       string           `xml:"SslPolicy,omitempty"`
+	Certificates    []xmlCertificate `xml:"Certificates>member"`
+	DefaultActions  []xmlAction      `xml:"DefaultActions>member"`
+	Port            int              `xml:"Port"`
+}
+
+type xmlCertificate struct {
+	CertificateArn string `xml:"CertificateArn"`
+}
+
+type xmlAction struct {
+	Redirect       *xmlRedirect      `xml:"RedirectConfig,omitempty"`
+	FixedResponse  *xmlFixedResponse `xml:"FixedResponseConfig,omitempty"`
+	Type           string            `xml:"Type"`
+	TargetGroupArn string            `xml:"TargetGroupArn,omitempty"`
+	Order          int               `xml:"Order"`
+}
+
+type xmlRedirect struct {
+	StatusCode string `xml:"StatusCode"`
+	Protocol   string `xml:"Protocol,omitempty"`
+	Port       string `xml:"Port,omitempty"`
+	Host       string `xml:"Host,omitempty"`
+	Path       string `xml:"Path,omitempty"`
+	Query      string `xml:"Query,omitempty"`
+}
+
+type xmlFixedResponse struct {
+	StatusCode  string `xml:"StatusCode"`
+	ContentType string `xml:"ContentType,omitempty"`
+	MessageBody string `xml:"MessageBody,omitempty"`
+}
+
+type xmlRule struct {
+	RuleArn    string         `xml:"RuleArn"`
+	Priority   string         `xml:"Priority"`
+	Conditions []xmlCondition `xml:"Conditions>member"`
+	Actions    []xmlAction    `xml:"Actions>member"`
+	IsDefault  bool           `xml:"IsDefault"`
+}
+
+type xmlCondition struct {
+	Field        string   `xml:"Field"`
+	PathPatterns []string `xml:"PathPatternConfig>Values>member,omitempty"`
+	HostHeaders  []string `xml:"HostHeaderConfig>Values>member,omitempty"`
+}
+
+type xmlTarget struct {
+	ID   string `xml:"Id"`
+	Port int    `xml:"Port"`
+}
+
+type xmlTargetHealth struct {
+	State  string `xml:"State"`
+	Reason string `xml:"Reason,omitempty"`
+}
+
+type xmlTargetHealthDescription struct {
+	Target       xmlTarget       `xml:"Target"`
+	TargetHealth xmlTargetHealth `xml:"TargetHealth"`
+}
+
+type xmlTagDescription struct {
+	ResourceArn string      `xml:"ResourceArn"`
+	Tags        []xmlIAMTag `xml:"Tags>member"`
+}
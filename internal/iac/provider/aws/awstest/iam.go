@@ -0,0 +1,758 @@
+package awstest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accountID is the account every stand-in resource belongs to
+const accountID = "123456789012"
+
+// IAM serves the subset of the IAM Query API duet uses: roles, managed and
+// inline policies, policy attachments and instance profiles. Policies
+// managed by AWS, with ARNs under arn:aws:iam::aws:policy/, always exist.
+type IAM struct {
+	roles            map[string]*Role
+	policies         map[string]*Policy
+	instanceProfiles map[string]*InstanceProfile
+	calls            []string
+	mu               sync.Mutex
+	nextID           int
+}
+
+// Role is a snapshot of a role held by the stand-in
+type Role struct {
+	CreateDate          time.Time
+	Tags                map[string]string
+	InlinePolicies      map[string]string
+	Name                string
+	ID                  string
+	Path                string
+	Description         string
+	AssumeRolePolicy    string
+	PermissionsBoundary string
+	AttachedPolicies    []string
+	MaxSessionDuration  int
+}
+
+// Policy is a snapshot of a customer managed policy held by the stand-in
+type Policy struct {
+	CreateDate       time.Time
+	Tags             map[string]string
+	Name             string
+	ID               string
+	Arn              string
+	Path             string
+	Description      string
+	DefaultVersionID string
+	Versions         []PolicyVersion
+	nextVersion      int
+}
+
+// PolicyVersion is one version of a managed policy's document
+type PolicyVersion struct {
+	CreateDate time.Time
+	ID         string
+	Document   string
+}
+
+// InstanceProfile is a snapshot of an instance profile held by the stand-in
+type InstanceProfile struct {
+	CreateDate time.Time
+	Tags       map[string]string
+	Name       string
+	ID         string
+	Path       string
+	Roles      []string
+}
+
+// NewIAM returns an empty IAM stand-in
+func NewIAM() *IAM {
+	return &IAM{
+		roles:            make(map[string]*Role),
+		policies:         make(map[string]*Policy),
+		instanceProfiles: make(map[string]*InstanceProfile),
+	}
+}
+
+// NewIAMServer starts an IAM stand-in on a local port. Point a provider at
+// server.URL and call server.Close when done.
+func NewIAMServer() (*IAM, *httptest.Server) {
+	i := NewIAM()
+	return i, httptest.NewServer(i)
+}
+
+// Role returns a copy of the named role
+func (i *IAM) Role(name string) (Role, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	r, ok := i.roles[name]
+	if !ok {
+		return Role{}, false
+	}
+	out := *r
+	out.Tags = copyTags(r.Tags)
+	out.InlinePolicies = copyTags(r.InlinePolicies)
+	out.AttachedPolicies = append([]string(nil), r.AttachedPolicies...)
+	return out, true
+}
+
+// Policy returns a copy of the managed policy with the given ARN
+func (i *IAM) Policy(arn string) (Policy, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	p, ok := i.policies[arn]
+	if !ok {
+		return Policy{}, false
+	}
+	out := *p
+	out.Tags = copyTags(p.Tags)
+	out.Versions = append([]PolicyVersion(nil), p.Versions...)
+	return out, true
+}
+
+// InstanceProfile returns a copy of the named instance profile
+func (i *IAM) InstanceProfile(name string) (InstanceProfile, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	p, ok := i.instanceProfiles[name]
+	if !ok {
+		return InstanceProfile{}, false
+	}
+	out := *p
+	out.Tags = copyTags(p.Tags)
+	out.Roles = append([]string(nil), p.Roles...)
+	return out, true
+}
+
+// Calls returns how many times the named API action has been called
+func (i *IAM) Calls(action string) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	n := 0
+	for _, c := range i.calls {
+		if c == action {
+			n++
+		}
+	}
+	return n
+}
+
+func (i *IAM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	action := r.Form.Get("Action")
+	i.calls = append(i.calls, action)
+
+	var (
+		result *iamResult
+		err    *iamError
+	)
+	switch action {
+	case "CreateRole", "GetRole", "UpdateRole", "UpdateAssumeRolePolicy", "DeleteRole", "ListRoles",
+		"PutRolePermissionsBoundary", "DeleteRolePermissionsBoundary", "TagRole", "UntagRole", "ListRoleTags":
+		result, err = i.serveRoles(action, r.Form)
+	case "PutRolePolicy", "GetRolePolicy", "DeleteRolePolicy", "ListRolePolicies",
+		"AttachRolePolicy", "DetachRolePolicy", "ListAttachedRolePolicies":
+		result, err = i.serveRolePolicies(action, r.Form)
+	case "CreatePolicy", "GetPolicy", "DeletePolicy", "ListPolicies", "GetPolicyVersion", "CreatePolicyVersion",
+		"DeletePolicyVersion", "ListPolicyVersions", "TagPolicy", "UntagPolicy", "ListPolicyTags":
+		result, err = i.servePolicies(action, r.Form)
+	case "CreateInstanceProfile", "GetInstanceProfile", "DeleteInstanceProfile", "ListInstanceProfiles",
+		"AddRoleToInstanceProfile", "RemoveRoleFromInstanceProfile", "TagInstanceProfile",
+		"UntagInstanceProfile", "ListInstanceProfileTags":
+		result, err = i.serveInstanceProfiles(action, r.Form)
+	default:
+		err = &iamError{Code: "InvalidAction", Message: "unsupported action " + action, status: http.StatusBadRequest}
+	}
+
+	writeIAMResponse(w, action, result, err)
+}
+
+func (i *IAM) newID(prefix string) string {
+	i.nextID++
+	return fmt.Sprintf("%s%017X", prefix, i.nextID)
+}
+
+func (i *IAM) role(name string) (*Role, *iamError) {
+	r, ok := i.roles[name]
+	if !ok {
+		return nil, noSuchEntity("The role with name " + name + " cannot be found.")
+	}
+	return r, nil
+}
+
+func (i *IAM) serveRoles(action string, form url.Values) (*iamResult, *iamError) {
+	name := form.Get("RoleName")
+	if action == "CreateRole" {
+		if _, ok := i.roles[name]; ok {
+			return nil, &iamError{Code: "EntityAlreadyExists", Message: "Role with name " + name + " already exists.", status: http.StatusConflict}
+		}
+		doc, err := validateDocument(form.Get("AssumeRolePolicyDocument"))
+		if err != nil {
+			return nil, err
+		}
+		role := &Role{
+			Name:                name,
+			ID:                  i.newID("AROA"),
+			Path:                pathOr(form.Get("Path")),
+			Description:         form.Get("Description"),
+			AssumeRolePolicy:    doc,
+			PermissionsBoundary: form.Get("PermissionsBoundary"),
+			MaxSessionDuration:  3600,
+			Tags:                indexedTags(form, "Tags.member"),
+			InlinePolicies:      make(map[string]string),
+			CreateDate:          time.Now().UTC().Truncate(time.Second),
+		}
+		if d := form.Get("MaxSessionDuration"); d != "" {
+			fmt.Sscan(d, &role.MaxSessionDuration)
+		}
+		i.roles[name] = role
+		return &iamResult{Role: role.xml()}, nil
+	}
+	if action == "ListRoles" {
+		out := &iamResult{Roles: []xmlRole{}}
+		for _, n := range sortedKeys(i.roles) {
+			if r := i.roles[n]; strings.HasPrefix(r.Path, pathOr(form.Get("PathPrefix"))) {
+				out.Roles = append(out.Roles, *r.xml())
+			}
+		}
+		return out, nil
+	}
+
+	role, err := i.role(name)
+	if err != nil {
+		return nil, err
+	}
+	switch action {
+	case "GetRole":
+		return &iamResult{Role: role.xml()}, nil
+	case "UpdateRole":
+		if form.Has("Description") {
+			role.Description = form.Get("Description")
+		}
+		if d := form.Get("MaxSessionDuration"); d != "" {
+			fmt.Sscan(d, &role.MaxSessionDuration)
+		}
+	case "UpdateAssumeRolePolicy":
+		doc, err := validateDocument(form.Get("PolicyDocument"))
+		if err != nil {
+			return nil, err
+		}
+		role.AssumeRolePolicy = doc
+	case "PutRolePermissionsBoundary":
+		role.PermissionsBoundary = form.Get("PermissionsBoundary")
+	case "DeleteRolePermissionsBoundary":
+		role.PermissionsBoundary = ""
+	case "TagRole":
+		for k, v := range indexedTags(form, "Tags.member") {
+			role.Tags[k] = v
+		}
+	case "UntagRole":
+		for _, k := range indexed(form, "TagKeys.member") {
+			delete(role.Tags, k)
+		}
+	case "ListRoleTags":
+		return &iamResult{Tags: iamTags(role.Tags)}, nil
+	case "DeleteRole":
+		if len(role.AttachedPolicies) > 0 || len(role.InlinePolicies) > 0 {
+			return nil, deleteConflict("Cannot delete entity, must detach all policies first.")
+		}
+		for _, p := range i.instanceProfiles {
+			if contains(p.Roles, name) {
+				return nil, deleteConflict("Cannot delete entity, must remove roles from instance profile first.")
+			}
+		}
+		delete(i.roles, name)
+	}
+	return &iamResult{}, nil
+}
+
+func (i *IAM) serveRolePolicies(action string, form url.Values) (*iamResult, *iamError) {
+	role, err := i.role(form.Get("RoleName"))
+	if err != nil {
+		return nil, err
+	}
+	name := form.Get("PolicyName")
+	arn := form.Get("PolicyArn")
+
+	switch action {
+	case "PutRolePolicy":
+		doc, err := validateDocument(form.Get("PolicyDocument"))
+		if err != nil {
+			return nil, err
+		}
+		role.InlinePolicies[name] = doc
+	case "GetRolePolicy":
+		doc, ok := role.InlinePolicies[name]
+		if !ok {
+			return nil, noSuchEntity("The role policy with name " + name + " cannot be found.")
+		}
+		return &iamResult{RoleName: role.Name, PolicyName: name, PolicyDocument: encodeDocument(doc)}, nil
+	case "DeleteRolePolicy":
+		if _, ok := role.InlinePolicies[name]; !ok {
+			return nil, noSuchEntity("The role policy with name " + name + " cannot be found.")
+		}
+		delete(role.InlinePolicies, name)
+	case "ListRolePolicies":
+		return &iamResult{PolicyNames: sortedKeys(role.InlinePolicies)}, nil
+	case "AttachRolePolicy":
+		if _, err := i.policyName(arn); err != nil {
+			return nil, err
+		}
+		if !contains(role.AttachedPolicies, arn) {
+			role.AttachedPolicies = append(role.AttachedPolicies, arn)
+		}
+	case "DetachRolePolicy":
+		for n, a := range role.AttachedPolicies {
+			if a == arn {
+				role.AttachedPolicies = append(role.AttachedPolicies[:n], role.AttachedPolicies[n+1:]...)
+				return &iamResult{}, nil
+			}
+		}
+		return nil, noSuchEntity("Policy " + arn + " was not found.")
+	case "ListAttachedRolePolicies":
+		out := &iamResult{AttachedPolicies: []xmlAttachedPolicy{}}
+		for _, a := range role.AttachedPolicies {
+			name, _ := i.policyName(a)
+			out.AttachedPolicies = append(out.AttachedPolicies, xmlAttachedPolicy{PolicyName: name, PolicyArn: a})
+		}
+		return out, nil
+	}
+	return &iamResult{}, nil
+}
+
+// policyName returns the name of the managed policy with the given ARN
+func (i *IAM) policyName(arn string) (string, *iamError) {
+	if name, ok := strings.CutPrefix(arn, "arn:aws:iam::aws:policy/"); ok {
+		return name[strings.LastIndex(name, "/")+1:], nil
+	}
+	p, ok := i.policies[arn]
+	if !ok {
+		return "", noSuchEntity("Policy " + arn + " does not exist or is not attachable.")
+	}
+	return p.Name, nil
+}
+
+func (i *IAM) servePolicies(action string, form url.Values) (*iamResult, *iamError) {
+	if action == "CreatePolicy" {
+		name := form.Get("PolicyName")
+		path := pathOr(form.Get("Path"))
+		arn := "arn:aws:iam::" + accountID + ":policy" + path + name
+		if _, ok := i.policies[arn]; ok {
+			return nil, &iamError{Code: "EntityAlreadyExists", Message: "A policy called " + name + " already exists.", status: http.StatusConflict}
+		}
+		doc, err := validateDocument(form.Get("PolicyDocument"))
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		policy := &Policy{
+			Name:             name,
+			ID:               i.newID("ANPA"),
+			Arn:              arn,
+			Path:             path,
+			Description:      form.Get("Description"),
+			DefaultVersionID: "v1",
+			Versions:         []PolicyVersion{{ID: "v1", Document: doc, CreateDate: now}},
+			Tags:             indexedTags(form, "Tags.member"),
+			CreateDate:       now,
+			nextVersion:      2,
+		}
+		i.policies[arn] = policy
+		return &iamResult{Policy: policy.xml(i.attachments(arn))}, nil
+	}
+	if action == "ListPolicies" {
+		out := &iamResult{Policies: []xmlPolicy{}}
+		for _, arn := range sortedKeys(i.policies) {
+			out.Policies = append(out.Policies, *i.policies[arn].xml(i.attachments(arn)))
+		}
+		return out, nil
+	}
+
+	arn := form.Get("PolicyArn")
+	policy, ok := i.policies[arn]
+	if !ok {
+		return nil, noSuchEntity("Policy " + arn + " was not found.")
+	}
+	versionID := form.Get("VersionId")
+	version := -1
+	for n, v := range policy.Versions {
+		if v.ID == versionID {
+			version = n
+		}
+	}
+
+	switch action {
+	case "GetPolicy":
+		return &iamResult{Policy: policy.xml(i.attachments(arn))}, nil
+	case "GetPolicyVersion":
+		if version < 0 {
+			return nil, noSuchEntity("Policy " + arn + " version " + versionID + " does not exist.")
+		}
+		return &iamResult{PolicyVersion: policy.versionXML(policy.Versions[version])}, nil
+	case "ListPolicyVersions":
+		out := &iamResult{Versions: []xmlPolicyVersion{}}
+		for n := len(policy.Versions) - 1; n >= 0; n-- {
+			v := policy.versionXML(policy.Versions[n])
+			v.Document = ""
+			out.Versions = append(out.Versions, *v)
+		}
+		return out, nil
+	case "CreatePolicyVersion":
+		if len(policy.Versions) >= 5 {
+			return nil, &iamError{Code: "LimitExceeded", Message: "A managed policy can have up to 5 versions.", status: http.StatusConflict}
+		}
+		doc, err := validateDocument(form.Get("PolicyDocument"))
+		if err != nil {
+			return nil, err
+		}
+		v := PolicyVersion{ID: fmt.Sprintf("v%d", policy.nextVersion), Document: doc, CreateDate: time.Now().UTC().Truncate(time.Second)}
+		policy.nextVersion++
+		policy.Versions = append(policy.Versions, v)
+		if form.Get("SetAsDefault") == "true" {
+			policy.DefaultVersionID = v.ID
+		}
+		return &iamResult{PolicyVersion: policy.versionXML(v)}, nil
+	case "DeletePolicyVersion":
+		if version < 0 {
+			return nil, noSuchEntity("Policy " + arn + " version " + versionID + " does not exist.")
+		}
+		if versionID == policy.DefaultVersionID {
+			return nil, deleteConflict("Cannot delete the default version of a policy.")
+		}
+		policy.Versions = append(policy.Versions[:version], policy.Versions[version+1:]...)
+	case "TagPolicy":
+		for k, v := range indexedTags(form, "Tags.member") {
+			policy.Tags[k] = v
+		}
+	case "UntagPolicy":
+		for _, k := range indexed(form, "TagKeys.member") {
+			delete(policy.Tags, k)
+		}
+	case "ListPolicyTags":
+		return &iamResult{Tags: iamTags(policy.Tags)}, nil
+	case "DeletePolicy":
+		if i.attachments(arn) > 0 {
+			return nil, deleteConflict("Cannot delete a policy attached to entities.")
+		}
+		if len(policy.Versions) > 1 {
+			return nil, deleteConflict("Cannot delete a policy with non-default versions.")
+		}
+		delete(i.policies, arn)
+	}
+	return &iamResult{}, nil
+}
+
+// attachments counts the roles a managed policy is attached to
+func (i *IAM) attachments(arn string) int {
+	n := 0
+	for _, r := range i.roles {
+		if contains(r.AttachedPolicies, arn) {
+			n++
+		}
+	}
+	return n
+}
+
+func (i *IAM) serveInstanceProfiles(action string, form url.Values) (*iamResult, *iamError) {
+	name := form.Get("InstanceProfileName")
+	if action == "CreateInstanceProfile" {
+		if _, ok := i.instanceProfiles[name]; ok {
+			return nil, &iamError{Code: "EntityAlreadyExists", Message: "Instance Profile " + name + " already exists.", status: http.StatusConflict}
+		}
+		profile := &InstanceProfile{
+			Name:       name,
+			ID:         i.newID("AIPA"),
+			Path:       pathOr(form.Get("Path")),
+			Tags:       indexedTags(form, "Tags.member"),
+			CreateDate: time.Now().UTC().Truncate(time.Second),
+		}
+		i.instanceProfiles[name] = profile
+		return &iamResult{InstanceProfile: i.profileXML(profile)}, nil
+	}
+	if action == "ListInstanceProfiles" {
+		out := &iamResult{InstanceProfiles: []xmlInstanceProfile{}}
+		for _, n := range sortedKeys(i.instanceProfiles) {
+			out.InstanceProfiles = append(out.InstanceProfiles, *i.profileXML(i.instanceProfiles[n]))
+		}
+		return out, nil
+	}
+
+	profile, ok := i.instanceProfiles[name]
+	if !ok {
+		return nil, noSuchEntity("Instance Profile " + name + " cannot be found.")
+	}
+	switch action {
+	case "GetInstanceProfile":
+		return &iamResult{InstanceProfile: i.profileXML(profile)}, nil
+	case "AddRoleToInstanceProfile":
+		role, err := i.role(form.Get("RoleName"))
+		if err != nil {
+			return nil, err
+		}
+		if len(profile.Roles) > 0 {
+			return nil, &iamError{Code: "LimitExceeded", Message: "Cannot exceed quota for InstanceSessionsPerInstanceProfile: 1", status: http.StatusConflict}
+		}
+		profile.Roles = append(profile.Roles, role.Name)
+	case "RemoveRoleFromInstanceProfile":
+		roleName := form.Get("RoleName")
+		if !contains(profile.Roles, roleName) {
+			return nil, noSuchEntity("The role " + roleName + " is not in instance profile " + name + ".")
+		}
+		profile.Roles = nil
+	case "TagInstanceProfile":
+		for k, v := range indexedTags(form, "Tags.member") {
+			profile.Tags[k] = v
+		}
+	case "UntagInstanceProfile":
+		for _, k := range indexed(form, "TagKeys.member") {
+			delete(profile.Tags, k)
+		}
+	case "ListInstanceProfileTags":
+		return &iamResult{Tags: iamTags(profile.Tags)}, nil
+	case "DeleteInstanceProfile":
+		if len(profile.Roles) > 0 {
+			return nil, deleteConflict("Cannot delete entity, must remove roles from instance profile first.")
+		}
+		delete(i.instanceProfiles, name)
+	}
+	return &iamResult{}, nil
+}
+
+func (r *Role) arn() string {
+	return "arn:aws:iam::" + accountID + ":role" + r.Path + r.Name
+}
+
+func (r *Role) xml() *xmlRole {
+	out := &xmlRole{
+		Path:                     r.Path,
+		RoleName:                 r.Name,
+		RoleID:                   r.ID,
+		Arn:                      r.arn(),
+		CreateDate:               r.CreateDate.Format(time.RFC3339),
+		AssumeRolePolicyDocument: encodeDocument(r.AssumeRolePolicy),
+		Description:              r.Description,
+		MaxSessionDuration:       r.MaxSessionDuration,
+		Tags:                     iamTags(r.Tags),
+	}
+	if r.PermissionsBoundary != "" {
+		out.PermissionsBoundary = &xmlBoundary{Type: "Policy", Arn: r.PermissionsBoundary}
+	}
+	return out
+}
+
+func (p *Policy) xml(attachments int) *xmlPolicy {
+	return &xmlPolicy{
+		PolicyName:       p.Name,
+		PolicyID:         p.ID,
+		Arn:              p.Arn,
+		Path:             p.Path,
+		Description:      p.Description,
+		DefaultVersionID: p.DefaultVersionID,
+		AttachmentCount:  attachments,
+		IsAttachable:     true,
+		CreateDate:       p.CreateDate.Format(time.RFC3339),
+		Tags:             iamTags(p.Tags),
+	}
+}
+
+func (p *Policy) versionXML(v PolicyVersion) *xmlPolicyVersion {
+	return &xmlPolicyVersion{
+		VersionID:        v.ID,
+		Document:         encodeDocument(v.Document),
+		IsDefaultVersion: v.ID == p.DefaultVersionID,
+		CreateDate:       v.CreateDate.Format(time.RFC3339),
+	}
+}
+
+func (i *IAM) profileXML(p *InstanceProfile) *xmlInstanceProfile {
+	out := &xmlInstanceProfile{
+		InstanceProfileName: p.Name,
+		InstanceProfileID:   p.ID,
+		Arn:                 "arn:aws:iam::" + accountID + ":instance-profile" + p.Path + p.Name,
+		Path:                p.Path,
+		CreateDate:          p.CreateDate.Format(time.RFC3339),
+		Roles:               []xmlRole{},
+		Tags:                iamTags(p.Tags),
+	}
+	for _, name := range p.Roles {
+		if r, ok := i.roles[name]; ok {
+			out.Roles = append(out.Roles, *r.xml())
+		}
+	}
+	return out
+}
+
+// validateDocument checks that a policy document is a JSON object, as IAM
+// does, and returns it unchanged
+func validateDocument(doc string) (string, *iamError) {
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		return "", &iamError{Code: "MalformedPolicyDocument", Message: "Syntax errors in policy.", status: http.StatusBadRequest}
+	}
+	return doc, nil
+}
+
+// encodeDocument URL-encodes a policy document the way IAM returns them
+func encodeDocument(doc string) string {
+	return strings.ReplaceAll(url.QueryEscape(doc), "+", "%20")
+}
+
+func pathOr(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func iamTags(tags map[string]string) []xmlIAMTag {
+	out := []xmlIAMTag{}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		out = append(out, xmlIAMTag{Key: k, Value: tags[k]})
+	}
+	return out
+}
+
+func noSuchEntity(message string) *iamError {
+	return &iamError{Code: "NoSuchEntity", Message: message, status: http.StatusNotFound}
+}
+
+func deleteConflict(message string) *iamError {
+	return &iamError{Code: "DeleteConflict", Message: message, status: http.StatusConflict}
+}
+
+// writeIAMResponse encodes result inside the <Action>Response and
+// <Action>Result elements the Query protocol expects, or err as an IAM error
+// document
+func writeIAMResponse(w http.ResponseWriter, action string, result *iamResult, err *iamError) {
+	w.Header().Set("Content-Type", "text/xml")
+	if err != nil {
+		w.WriteHeader(err.status)
+		_ = xml.NewEncoder(w).Encode(iamErrorResponse{Error: *err, RequestID: "awstest"})
+		return
+	}
+	result.XMLName = xml.Name{Local: action + "Result"}
+	_ = xml.NewEncoder(w).Encode(iamResponse{
+		XMLName:   xml.Name{Local: action + "Response"},
+		Result:    result,
+		RequestID: "awstest",
+	})
+}
+
+type iamError struct {
+	Type    string `xml:"Type"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+	status  int
+}
+
+type iamErrorResponse struct {
+	XMLName   xml.Name `xml:"ErrorResponse"`
+	Error     iamError `xml:"Error"`
+	RequestID string   `xml:"RequestId"`
+}
+
+type iamResponse struct {
+	XMLName   xml.Name
+	Result    *iamResult
+	RequestID string `xml:"ResponseMetadata>RequestId"`
+}
+
+// iamResult holds the fields of every result shape the stand-in returns;
+// each action sets only its own
+type iamResult struct {
+	XMLName          xml.Name
+	Role             *xmlRole             `xml:"Role,omitempty"`
+	Roles            []xmlRole            `xml:"Roles>member"`
+	Policy           *xmlPolicy           `xml:"Policy,omitempty"`
+	Policies         []xmlPolicy          `xml:"Policies>member"`
+	PolicyVersion    *xmlPolicyVersion    `xml:"PolicyVersion,omitempty"`
+	Versions         []xmlPolicyVersion   `xml:"Versions>member"`
+	InstanceProfile  *xmlInstanceProfile  `xml:"InstanceProfile,omitempty"`
+	InstanceProfiles []xmlInstanceProfile `xml:"InstanceProfiles>member"`
+	AttachedPolicies []xmlAttachedPolicy  `xml:"AttachedPolicies>member"`
+	Tags             []xmlIAMTag          `xml:"Tags>member"`
+	PolicyNames      []string             `xml:"PolicyNames>member"`
+	RoleName         string               `xml:"RoleName,omitempty"`
+	PolicyName       string               `xml:"PolicyName,omitempty"`
+	PolicyDocument   string               `xml:"PolicyDocument,omitempty"`
+	IsTruncated      bool                 `xml:"IsTruncated"`
+}
+
+type xmlIAMTag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+type xmlBoundary struct {
+	Type string `xml:"PermissionsBoundaryType"`
+	Arn  string `xml:"PermissionsBoundaryArn"`
+}
+
+type xmlRole struct {
+	PermissionsBoundary      *xmlBoundary `xml:"PermissionsBoundary,omitempty"`
+	Path                     string       `xml:"Path"`
+	RoleName                 string       `xml:"RoleName"`
+	RoleID                   string       `xml:"RoleId"`
+	Arn                      string       `xml:"Arn"`
+	CreateDate               string       `xml:"CreateDate"`
+	AssumeRolePolicyDocument string       `xml:"AssumeRolePolicyDocument"`
+	Description              string       `xml:"Description,omitempty"`
+	Tags                     []xmlIAMTag  `xml:"Tags>member"`
+	MaxSessionDuration       int          `xml:"MaxSessionDuration"`
+}
+
+type xmlPolicy struct {
+	PolicyName       string      `xml:"PolicyName"`
+	PolicyID         string      `xml:"PolicyId"`
+	Arn              string      `xml:"Arn"`
+	Path             string      `xml:"Path"`
+	Description      string      `xml:"Description,omitempty"`
+	DefaultVersionID string      `xml:"DefaultVersionId"`
+	CreateDate       string      `xml:"CreateDate"`
+	Tags             []xmlIAMTag `xml:"Tags>member"`
+	AttachmentCount  int         `xml:"AttachmentCount"`
+	IsAttachable     bool        `xml:"IsAttachable"`
+}
+
+type xmlPolicyVersion struct {
+	VersionID        string `xml:"VersionId"`
+	Document         string `xml:"Document,omitempty"`
+	CreateDate       string `xml:"CreateDate"`
+	IsDefaultVersion bool   `xml:"IsDefaultVersion"`
+}
+
+type xmlInstanceProfile struct {
+	InstanceProfileName string      `xml:"InstanceProfileName"`
+	InstanceProfileID   string      `xml:"InstanceProfileId"`
+	Arn                 string      `xml:"Arn"`
+	Path                string      `xml:"Path"`
+	CreateDate          string      `xml:"CreateDate"`
+	Roles               []xmlRole   `xml:"Roles>member"`
+	Tags                []xmlIAMTag `xml:"Tags>member"`
+}
+
+type xmlAttachedPolicy struct {
+	PolicyName string `xml:"PolicyName"`
+	PolicyArn  string `xml:"PolicyArn"`
+}
@@ -0,0 +1,200 @@
+package awstest
+
+import (
+	"encoding/xml"
+	"net/url"
+	"path"
+	"time"
+)
+
+// Image is an AMI the stand-in can describe. Images are only ever added by
+// tests, through AddImage.
+type Image struct {
+	CreationDate       time.Time
+	Tags               map[string]string
+	ID                 string
+	Name               string
+	OwnerID            string
+	Description        string
+	Architecture       string
+	RootDeviceName     string
+	VirtualizationType string
+}
+
+// AvailabilityZone is a zone returned by DescribeAvailabilityZones
+type AvailabilityZone struct {
+	Name  string
+	ID    string
+	State string
+}
+
+// defaultZones are the zones a new stand-in reports
+var defaultZones = []AvailabilityZone{
+	{Name: "us-east-1a", ID: "use1-az1", State: "available"},
+	{Name: "us-east-1b", ID: "use1-az2", State: "available"},
+	{Name: "us-east-1c", ID: "use1-az3", State: "available"},
+}
+
+// AddImage makes an image visible to DescribeImages. Its architecture,
+// root device and virtualization type default to those of a typical x86
+// HVM image.
+func (e *EC2) AddImage(image Image) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if image.Architecture == "" {
+		image.Architecture = "x86_64"
+	}
+	if image.RootDeviceName == "" {
+		image.RootDeviceName = "/dev/xvda"
+	}
+	if image.VirtualizationType == "" {
+		image.VirtualizationType = "hvm"
+	}
+	image.Tags = copyTags(image.Tags)
+	e.images[image.ID] = &image
+}
+
+// SetAvailabilityZones replaces the zones DescribeAvailabilityZones reports
+func (e *EC2) SetAvailabilityZones(zones ...AvailabilityZone) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.zones = append([]AvailabilityZone(nil), zones...)
+}
+
+// serveImages handles the read-only image and zone actions
+func (e *EC2) serveImages(action string, form url.Values) (interface{}, *apiError) {
+	switch action {
+	case "DescribeImages":
+		return e.describeImages(form)
+	case "DescribeAvailabilityZones":
+		return e.describeAvailabilityZones(form)
+	default:
+		return e.serveLaunchTemplates(action, form)
+	}
+}
+
+func (e *EC2) describeImages(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "ImageId")
+	owners := indexed(form, "Owner")
+	filters := parseFilters(form)
+
+	resp := describeImagesResponse{}
+	for _, id := range sortedIDs(e.images) {
+		image := e.images[id]
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		if len(owners) > 0 && !contains(owners, image.OwnerID) && !(contains(owners, "self") && image.OwnerID == accountID) {
+			continue
+		}
+		// Names are matched as patterns, so they are checked here
+		if names, ok := filters["name"]; ok && !matchesPattern(names, image.Name) {
+			continue
+		}
+		if !matchFilters(filters, image.Tags, func(name string) ([]string, bool) {
+			switch name {
+			case "image-id":
+				return []string{image.ID}, true
+			case "owner-id":
+				return []string{image.OwnerID}, true
+			case "architecture":
+				return []string{image.Architecture}, true
+			case "virtualization-type":
+				return []string{image.VirtualizationType}, true
+			case "state":
+				return []string{"available"}, true
+			}
+			return nil, false
+		}) {
+			continue
+		}
+		resp.Images = append(resp.Images, image.xml())
+	}
+	return resp, nil
+}
+
+// matchesPattern reports whether s matches any of the patterns, in which *
+// matches any run of characters and ? any one
+func matchesPattern(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *EC2) describeAvailabilityZones(form url.Values) (interface{}, *apiError) {
+	names := indexed(form, "ZoneName")
+	filters := parseFilters(form)
+
+	resp := describeAvailabilityZonesResponse{}
+	for _, zone := range e.zones {
+		if len(names) > 0 && !contains(names, zone.Name) {
+			continue
+		}
+		if !matchFilters(filters, nil, func(name string) ([]string, bool) {
+			switch name {
+			case "state":
+				return []string{zone.State}, true
+			case "zone-name":
+				return []string{zone.Name}, true
+			case "zone-id":
+				return []string{zone.ID}, true
+			}
+			return nil, false
+		}) {
+			continue
+		}
+		resp.Zones = append(resp.Zones, xmlAvailabilityZone{
+			ZoneName: zone.Name,
+			ZoneID:   zone.ID,
+			State:    zone.State,
+		})
+	}
+	return resp, nil
+}
+
+func (i *Image) xml() xmlImage {
+	return xmlImage{
+		ImageID:            i.ID,
+		Name:               i.Name,
+		OwnerID:            i.OwnerID,
+		Description:        i.Description,
+		Architecture:       i.Architecture,
+		RootDeviceName:     i.RootDeviceName,
+		VirtualizationType: i.VirtualizationType,
+		CreationDate:       i.CreationDate.UTC().Format("2006-01-02T15:04:05.000Z"),
+		State:              "available",
+		Tags:               xmlTags(i.Tags),
+	}
+}
+
+type xmlImage struct {
+	ImageID            string   `xml:"imageId"`
+	Name               string   `xml:"name"`
+	OwnerID            string   `xml:"imageOwnerId"`
+	Description        string   `xml:"description"`
+	Architecture       string   `xml:"architecture"`
+	RootDeviceName     string   `xml:"rootDeviceName"`
+	VirtualizationType string   `xml:"virtualizationType"`
+	CreationDate       string   `xml:"creationDate"`
+	State              string   `xml:"imageState"`
+	Tags               []xmlTag `xml:"tagSet>item"`
+}
+
+type describeImagesResponse struct {
+	XMLName xml.Name   `xml:"DescribeImagesResponse"`
+	Images  []xmlImage `xml:"imagesSet>item"`
+}
+
+type xmlAvailabilityZone struct {
+	ZoneName string `xml:"zoneName"`
+	ZoneID   string `xml:"zoneId"`
+	State    string `xml:"zoneState"`
+}
+
+type describeAvailabilityZonesResponse struct {
+	XMLName xml.Name              `xml:"DescribeAvailabilityZonesResponse"`
+	Zones   []xmlAvailabilityZone `xml:"availabilityZoneInfo>item"`
+}
@@ -0,0 +1,204 @@
+package awstest
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyPair is a snapshot of an imported key pair held by the stand-in. EC2
+// never stores private keys, so the stand-in only knows the public half.
+type KeyPair struct {
+	CreateTime  time.Time
+	Tags        map[string]string
+	ID          string
+	Name        string
+	Type        string
+	Fingerprint string
+	PublicKey   string
+}
+
+// KeyPair returns a copy of the key pair with the given ID
+func (e *EC2) KeyPair(id string) (KeyPair, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	k, ok := e.keyPairs[id]
+	if !ok {
+		return KeyPair{}, false
+	}
+	out := *k
+	out.Tags = copyTags(k.Tags)
+	return out, true
+}
+
+// serveKeyPairs handles the key pair actions
+func (e *EC2) serveKeyPairs(action string, form url.Values) (interface{}, *apiError) {
+	switch action {
+	case "ImportKeyPair":
+		return e.importKeyPair(form)
+	case "DescribeKeyPairs":
+		return e.describeKeyPairs(form)
+	case "DeleteKeyPair":
+		return e.deleteKeyPair(form)
+	default:
+		return nil, &apiError{Code: "InvalidAction", Message: "unsupported action " + action}
+	}
+}
+
+func (e *EC2) importKeyPair(form url.Values) (interface{}, *apiError) {
+	name := form.Get("KeyName")
+	if name == "" {
+		return nil, &apiError{Code: "MissingParameter", Message: "The request must contain the parameter KeyName"}
+	}
+	for _, k := range e.keyPairs {
+		if k.Name == name {
+			return nil, &apiError{Code: "InvalidKeyPair.Duplicate", Message: "The keypair '" + name + "' already exists."}
+		}
+	}
+
+	material, err := base64.StdEncoding.DecodeString(form.Get("PublicKeyMaterial"))
+	if err != nil {
+		return nil, &apiError{Code: "InvalidKey.Format", Message: "Key is not in valid OpenSSH public key format"}
+	}
+	public, _, _, _, err := ssh.ParseAuthorizedKey(material)
+	if err != nil {
+		return nil, &apiError{Code: "InvalidKey.Format", Message: "Key is not in valid OpenSSH public key format"}
+	}
+
+	k := &KeyPair{
+		ID:         e.newID("key"),
+		Name:       name,
+		PublicKey:  strings.TrimSpace(string(ssh.MarshalAuthorizedKey(public))) + " " + name,
+		CreateTime: time.Now().UTC().Truncate(time.Second),
+		Tags:       tagSpecifications(form, "key-pair"),
+	}
+	// EC2 fingerprints imported RSA keys with MD5 and ED25519 keys with
+	// SHA-256
+	switch public.Type() {
+	case ssh.KeyAlgoRSA:
+		k.Type = "rsa"
+		k.Fingerprint = strings.TrimPrefix(ssh.FingerprintLegacyMD5(public), "MD5:")
+	case ssh.KeyAlgoED25519:
+		k.Type = "ed25519"
+		k.Fingerprint = strings.TrimPrefix(ssh.FingerprintSHA256(public), "SHA256:") + "="
+	default:
+		return nil, &apiError{Code: "InvalidKey.Format", Message: "Key type " + public.Type() + " is not supported"}
+	}
+
+	e.keyPairs[k.ID] = k
+	return importKeyPairResponse{
+		KeyPairID:   k.ID,
+		KeyName:     k.Name,
+		Fingerprint: k.Fingerprint,
+		Tags:        xmlTags(k.Tags),
+	}, nil
+}
+
+func (e *EC2) describeKeyPairs(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "KeyPairId")
+	names := indexed(form, "KeyName")
+	for _, id := range ids {
+		if _, ok := e.keyPairs[id]; !ok {
+			return nil, &apiError{Code: "InvalidKeyPair.NotFound", Message: "The key pair '" + id + "' does not exist"}
+		}
+	}
+	filters := parseFilters(form)
+	includePublic := form.Get("IncludePublicKey") == "true"
+
+	resp := describeKeyPairsResponse{}
+	found := make(map[string]bool)
+	for _, id := range sortedIDs(e.keyPairs) {
+		k := e.keyPairs[id]
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		if len(names) > 0 && !contains(names, k.Name) {
+			continue
+		}
+		if !matchFilters(filters, k.Tags, func(name string) ([]string, bool) {
+			switch name {
+			case "key-name":
+				return []string{k.Name}, true
+			case "key-pair-id":
+				return []string{k.ID}, true
+			case "fingerprint":
+				return []string{k.Fingerprint}, true
+			}
+			return nil, false
+		}) {
+			continue
+		}
+		found[k.Name] = true
+		resp.KeyPairs = append(resp.KeyPairs, k.xml(includePublic))
+	}
+	for _, name := range names {
+		if !found[name] {
+			return nil, &apiError{Code: "InvalidKeyPair.NotFound", Message: "The key pair '" + name + "' does not exist"}
+		}
+	}
+	return resp, nil
+}
+
+// deleteKeyPair removes a key pair by ID or name. Like EC2, it succeeds
+// for key pairs that do not exist.
+func (e *EC2) deleteKeyPair(form url.Values) (interface{}, *apiError) {
+	id, name := form.Get("KeyPairId"), form.Get("KeyName")
+	if id == "" && name == "" {
+		return nil, &apiError{Code: "MissingParameter", Message: "The request must contain the parameter KeyName or KeyPairId"}
+	}
+	for _, k := range e.keyPairs {
+		if k.ID == id || (id == "" && k.Name == name) {
+			delete(e.keyPairs, k.ID)
+			return deleteKeyPairResponse{Return: true, KeyPairID: k.ID}, nil
+		}
+	}
+	return deleteKeyPairResponse{Return: true}, nil
+}
+
+func (k *KeyPair) xml(includePublic bool) xmlKeyPair {
+	out := xmlKeyPair{
+		KeyPairID:   k.ID,
+		KeyName:     k.Name,
+		KeyType:     k.Type,
+		Fingerprint: k.Fingerprint,
+		CreateTime:  k.CreateTime.Format(time.RFC3339),
+		Tags:        xmlTags(k.Tags),
+	}
+	if includePublic {
+		out.PublicKey = k.PublicKey
+	}
+	return out
+}
+
+type xmlKeyPair struct {
+	KeyPairID   string   `xml:"keyPairId"`
+	KeyName     string   `xml:"keyName"`
+	KeyType     string   `xml:"keyType"`
+	Fingerprint string   `xml:"keyFingerprint"`
+	PublicKey   string   `xml:"publicKey,omitempty"`
+	CreateTime  string   `xml:"createTime"`
+	Tags        []xmlTag `xml:"tagSet>item"`
+}
+
+type importKeyPairResponse struct {
+	XMLName     xml.Name `xml:"ImportKeyPairResponse"`
+	KeyPairID   string   `xml:"keyPairId"`
+	KeyName     string   `xml:"keyName"`
+	Fingerprint string   `xml:"keyFingerprint"`
+	Tags        []xmlTag `xml:"tagSet>item"`
+}
+
+type describeKeyPairsResponse struct {
+	XMLName  xml.Name     `xml:"DescribeKeyPairsResponse"`
+	KeyPairs []xmlKeyPair `xml:"keySet>item"`
+}
+
+type deleteKeyPairResponse struct {
+	XMLName   xml.Name `xml:"DeleteKeyPairResponse"`
+	Return    bool     `xml:"return"`
+	KeyPairID string   `xml:"keyPairId,omitempty"`
+}
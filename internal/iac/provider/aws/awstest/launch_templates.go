@@ -0,0 +1,488 @@
+package awstest
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// LaunchTemplate is a snapshot of a launch template held by the stand-in.
+// Versions are numbered from 1 in creation order.
+type LaunchTemplate struct {
+	CreateTime     time.Time
+	Tags           map[string]string
+	ID             string
+	Name           string
+	Versions       []LaunchTemplateVersion
+	DefaultVersion int
+	clientToken    string
+}
+
+// LaunchTemplateVersion is one version of a launch template's instance
+// settings. Tags are those applied to the instances it launches.
+type LaunchTemplateVersion struct {
+	Tags               map[string]string
+	ImageID            string
+	InstanceType       string
+	KeyName            string
+	UserData           string
+	Description        string
+	IamInstanceProfile string
+	SecurityGroups     []string
+	BlockDevices       []TemplateBlockDevice
+	Number             int
+}
+
+// TemplateBlockDevice is an EBS volume a launch template attaches at launch
+type TemplateBlockDevice struct {
+	DeviceName          string
+	VolumeType          string
+	KmsKeyID            string
+	VolumeSize          int
+	Iops                int
+	Throughput          int
+	Encrypted           bool
+	DeleteOnTermination bool
+}
+
+// LatestVersion returns the number of the template's newest version
+func (t LaunchTemplate) LatestVersion() int {
+	return len(t.Versions)
+}
+
+// LaunchTemplate returns a copy of the launch template with the given ID
+func (e *EC2) LaunchTemplate(id string) (LaunchTemplate, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t, ok := e.launchTemplates[id]
+	if !ok {
+		return LaunchTemplate{}, false
+	}
+	out := *t
+	out.Tags = copyTags(t.Tags)
+	out.Versions = append([]LaunchTemplateVersion(nil), t.Versions...)
+	return out, true
+}
+
+// serveLaunchTemplates handles the launch template actions
+func (e *EC2) serveLaunchTemplates(action string, form url.Values) (interface{}, *apiError) {
+	switch action {
+	case "CreateLaunchTemplate":
+		return e.createLaunchTemplate(form)
+	case "CreateLaunchTemplateVersion":
+		return e.createLaunchTemplateVersion(form)
+	case "DescribeLaunchTemplates":
+		return e.describeLaunchTemplates(form)
+	case "DescribeLaunchTemplateVersions":
+		return e.describeLaunchTemplateVersions(form)
+	case "ModifyLaunchTemplate":
+		return e.modifyLaunchTemplate(form)
+	case "DeleteLaunchTemplate":
+		return e.deleteLaunchTemplate(form)
+	default:
+		return e.serveKeyPairs(action, form)
+	}
+}
+
+func (e *EC2) createLaunchTemplate(form url.Values) (interface{}, *apiError) {
+	name := form.Get("LaunchTemplateName")
+	if token := form.Get("ClientToken"); token != "" {
+		for _, id := range sortedIDs(e.launchTemplates) {
+			if t := e.launchTemplates[id]; t.clientToken == token {
+				return launchTemplateResponse{XMLName: xml.Name{Local: "CreateLaunchTemplateResponse"}, LaunchTemplate: t.xml()}, nil
+			}
+		}
+	}
+	if name == "" {
+		return nil, &apiError{Code: "MissingParameter", Message: "The request must contain the parameter LaunchTemplateName"}
+	}
+	for _, t := range e.launchTemplates {
+		if t.Name == name {
+			return nil, &apiError{Code: "InvalidLaunchTemplateName.AlreadyExistsException", Message: "Launch template name already in use."}
+		}
+	}
+
+	version, err := parseTemplateData(form)
+	if err != nil {
+		return nil, err
+	}
+	version.Number = 1
+	t := &LaunchTemplate{
+		ID:             e.newID("lt"),
+		Name:           name,
+		CreateTime:     time.Now().UTC().Truncate(time.Second),
+		Tags:           tagSpecifications(form, "launch-template"),
+		Versions:       []LaunchTemplateVersion{version},
+		DefaultVersion: 1,
+		clientToken:    form.Get("ClientToken"),
+	}
+	e.launchTemplates[t.ID] = t
+	return launchTemplateResponse{XMLName: xml.Name{Local: "CreateLaunchTemplateResponse"}, LaunchTemplate: t.xml()}, nil
+}
+
+func (e *EC2) createLaunchTemplateVersion(form url.Values) (interface{}, *apiError) {
+	t, err := e.lookupLaunchTemplate(form)
+	if err != nil {
+		return nil, err
+	}
+	version, err := parseTemplateData(form)
+	if err != nil {
+		return nil, err
+	}
+	version.Number = t.LatestVersion() + 1
+	t.Versions = append(t.Versions, version)
+	return launchTemplateVersionResponse{LaunchTemplateVersion: t.versionXML(version)}, nil
+}
+
+func (e *EC2) describeLaunchTemplates(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "LaunchTemplateId")
+	names := indexed(form, "LaunchTemplateName")
+	for _, id := range ids {
+		if _, ok := e.launchTemplates[id]; !ok {
+			return nil, &apiError{Code: "InvalidLaunchTemplateId.NotFound", Message: "The specified launch template, with template ID " + id + ", does not exist."}
+		}
+	}
+	filters := parseFilters(form)
+
+	resp := describeLaunchTemplatesResponse{}
+	for _, id := range sortedIDs(e.launchTemplates) {
+		t := e.launchTemplates[id]
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		if len(names) > 0 && !contains(names, t.Name) {
+			continue
+		}
+		if !matchFilters(filters, t.Tags, func(string) ([]string, bool) { return nil, false }) {
+			continue
+		}
+		resp.LaunchTemplates = append(resp.LaunchTemplates, t.xml())
+	}
+	if len(names) > 0 && len(resp.LaunchTemplates) == 0 {
+		return nil, &apiError{Code: "InvalidLaunchTemplateName.NotFoundException", Message: "At least one of the launch templates specified in the request does not exist."}
+	}
+	return resp, nil
+}
+
+func (e *EC2) describeLaunchTemplateVersions(form url.Values) (interface{}, *apiError) {
+	t, err := e.lookupLaunchTemplate(form)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := describeLaunchTemplateVersionsResponse{}
+	requested := indexed(form, "LaunchTemplateVersion")
+	if len(requested) == 0 {
+		for _, v := range t.Versions {
+			resp.Versions = append(resp.Versions, t.versionXML(v))
+		}
+		return resp, nil
+	}
+	for _, r := range requested {
+		n, ok := t.resolve(r)
+		if !ok {
+			return nil, &apiError{Code: "InvalidLaunchTemplateId.VersionNotFound", Message: "Could not find launch template version " + r + " for template " + t.ID}
+		}
+		resp.Versions = append(resp.Versions, t.versionXML(t.Versions[n-1]))
+	}
+	return resp, nil
+}
+
+func (e *EC2) modifyLaunchTemplate(form url.Values) (interface{}, *apiError) {
+	t, err := e.lookupLaunchTemplate(form)
+	if err != nil {
+		return nil, err
+	}
+	if v := form.Get("SetDefaultVersion"); v != "" {
+		n, ok := t.resolve(v)
+		if !ok {
+			return nil, &apiError{Code: "InvalidLaunchTemplateId.VersionNotFound", Message: "Could not find launch template version " + v + " for template " + t.ID}
+		}
+		t.DefaultVersion = n
+	}
+	return launchTemplateResponse{XMLName: xml.Name{Local: "ModifyLaunchTemplateResponse"}, LaunchTemplate: t.xml()}, nil
+}
+
+func (e *EC2) deleteLaunchTemplate(form url.Values) (interface{}, *apiError) {
+	t, err := e.lookupLaunchTemplate(form)
+	if err != nil {
+		return nil, err
+	}
+	delete(e.launchTemplates, t.ID)
+	return launchTemplateResponse{XMLName: xml.Name{Local: "DeleteLaunchTemplateResponse"}, LaunchTemplate: t.xml()}, nil
+}
+
+// lookupLaunchTemplate finds the template a request names by ID or name
+func (e *EC2) lookupLaunchTemplate(form url.Values) (*LaunchTemplate, *apiError) {
+	if id := form.Get("LaunchTemplateId"); id != "" {
+		t, ok := e.launchTemplates[id]
+		if !ok {
+			return nil, &apiError{Code: "InvalidLaunchTemplateId.NotFound", Message: "The specified launch template, with template ID " + id + ", does not exist."}
+		}
+		return t, nil
+	}
+	name := form.Get("LaunchTemplateName")
+	for _, t := range e.launchTemplates {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return nil, &apiError{Code: "InvalidLaunchTemplateName.NotFoundException", Message: "The specified launch template, with template name " + name + ", does not exist."}
+}
+
+// launchFromTemplate starts an instance from a launch template, named by ID
+// or name, at the given version, and returns it with the version number used
+func (e *EC2) launchFromTemplate(ref, version, subnetID string, tags map[string]string) (Instance, int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var t *LaunchTemplate
+	for _, candidate := range e.launchTemplates {
+		if candidate.ID == ref || candidate.Name == ref {
+			t = candidate
+		}
+	}
+	if t == nil {
+		return Instance{}, 0, fmt.Errorf("the specified launch template %s does not exist", ref)
+	}
+	n, ok := t.resolve(version)
+	if !ok {
+		return Instance{}, 0, fmt.Errorf("launch template %s has no version %s", t.ID, version)
+	}
+	v := t.Versions[n-1]
+
+	inst := e.newInstance()
+	inst.ImageID = v.ImageID
+	inst.InstanceType = v.InstanceType
+	inst.KeyName = v.KeyName
+	inst.UserData = v.UserData
+	inst.SubnetID = subnetID
+	inst.SecurityGroups = append([]string(nil), v.SecurityGroups...)
+	inst.IamInstanceProfile = v.IamInstanceProfile
+	if inst.IamInstanceProfile != "" && !isARN(inst.IamInstanceProfile) {
+		inst.IamInstanceProfile = "arn:aws:iam::" + accountID + ":instance-profile/" + inst.IamInstanceProfile
+	}
+	for k, val := range v.Tags {
+		inst.Tags[k] = val
+	}
+	for k, val := range tags {
+		inst.Tags[k] = val
+	}
+	e.place(inst)
+	return inst.snapshot(), n, nil
+}
+
+// terminate ends an instance launched on another service's behalf
+func (e *EC2) terminate(id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if inst, ok := e.instances[id]; ok {
+		inst.State = "terminated"
+		e.detachAll(id)
+	}
+}
+
+// resolve turns a version, a number or $Latest or $Default, into a number
+func (t *LaunchTemplate) resolve(version string) (int, bool) {
+	switch version {
+	case "", "$Default":
+		return t.DefaultVersion, true
+	case "$Latest":
+		return t.LatestVersion(), true
+	}
+	n, err := strconv.Atoi(version)
+	if err != nil || n < 1 || n > t.LatestVersion() {
+		return 0, false
+	}
+	return n, true
+}
+
+// parseTemplateData reads the LaunchTemplateData parameters of a request
+func parseTemplateData(form url.Values) (LaunchTemplateVersion, *apiError) {
+	const prefix = "LaunchTemplateData."
+	v := LaunchTemplateVersion{
+		ImageID:        form.Get(prefix + "ImageId"),
+		InstanceType:   form.Get(prefix + "InstanceType"),
+		KeyName:        form.Get(prefix + "KeyName"),
+		Description:    form.Get("VersionDescription"),
+		SecurityGroups: indexed(form, prefix+"SecurityGroupId"),
+		Tags:           make(map[string]string),
+	}
+	if data := form.Get(prefix + "UserData"); data != "" {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return v, &apiError{Code: "InvalidUserData.Malformed", Message: "User data must be base64 encoded"}
+		}
+		v.UserData = string(decoded)
+	}
+	if arn := form.Get(prefix + "IamInstanceProfile.Arn"); arn != "" {
+		v.IamInstanceProfile = arn
+	} else {
+		v.IamInstanceProfile = form.Get(prefix + "IamInstanceProfile.Name")
+	}
+	for i := 1; form.Has(fmt.Sprintf("%sBlockDeviceMapping.%d.DeviceName", prefix, i)); i++ {
+		p := fmt.Sprintf("%sBlockDeviceMapping.%d.", prefix, i)
+		d := TemplateBlockDevice{
+			DeviceName:          form.Get(p + "DeviceName"),
+			VolumeType:          form.Get(p + "Ebs.VolumeType"),
+			KmsKeyID:            form.Get(p + "Ebs.KmsKeyId"),
+			Encrypted:           form.Get(p+"Ebs.Encrypted") == "true",
+			DeleteOnTermination: form.Get(p+"Ebs.DeleteOnTermination") == "true",
+		}
+		d.VolumeSize, _ = strconv.Atoi(form.Get(p + "Ebs.VolumeSize"))
+		d.Iops, _ = strconv.Atoi(form.Get(p + "Ebs.Iops"))
+		d.Throughput, _ = strconv.Atoi(form.Get(p + "Ebs.Throughput"))
+		v.BlockDevices = append(v.BlockDevices, d)
+	}
+	for i := 1; form.Has(fmt.Sprintf("%sTagSpecification.%d.ResourceType", prefix, i)); i++ {
+		p := fmt.Sprintf("%sTagSpecification.%d.", prefix, i)
+		if form.Get(p+"ResourceType") != "instance" {
+			continue
+		}
+		for k, val := range indexedTags(form, p+"Tag") {
+			v.Tags[k] = val
+		}
+	}
+	return v, nil
+}
+
+func isARN(s string) bool {
+	return len(s) > 4 && s[:4] == "arn:"
+}
+
+func (t *LaunchTemplate) xml() xmlLaunchTemplate {
+	return xmlLaunchTemplate{
+		LaunchTemplateID:     t.ID,
+		LaunchTemplateName:   t.Name,
+		CreateTime:           t.CreateTime.Format(time.RFC3339),
+		CreatedBy:            "arn:aws:iam::" + accountID + ":root",
+		DefaultVersionNumber: t.DefaultVersion,
+		LatestVersionNumber:  t.LatestVersion(),
+		Tags:                 xmlTags(t.Tags),
+	}
+}
+
+func (t *LaunchTemplate) versionXML(v LaunchTemplateVersion) xmlLaunchTemplateVersion {
+	data := xmlLaunchTemplateData{
+		ImageID:        v.ImageID,
+		InstanceType:   v.InstanceType,
+		KeyName:        v.KeyName,
+		SecurityGroups: v.SecurityGroups,
+	}
+	if v.UserData != "" {
+		data.UserData = base64.StdEncoding.EncodeToString([]byte(v.UserData))
+	}
+	if v.IamInstanceProfile != "" {
+		data.IamInstanceProfile = &xmlTemplateProfile{}
+		if isARN(v.IamInstanceProfile) {
+			data.IamInstanceProfile.Arn = v.IamInstanceProfile
+		} else {
+			data.IamInstanceProfile.Name = v.IamInstanceProfile
+		}
+	}
+	for _, d := range v.BlockDevices {
+		data.BlockDevices = append(data.BlockDevices, xmlTemplateBlockDevice{
+			DeviceName: d.DeviceName,
+			Ebs: xmlTemplateEbs{
+				VolumeType:          d.VolumeType,
+				KmsKeyID:            d.KmsKeyID,
+				VolumeSize:          d.VolumeSize,
+				Iops:                d.Iops,
+				Throughput:          d.Throughput,
+				Encrypted:           d.Encrypted,
+				DeleteOnTermination: d.DeleteOnTermination,
+			},
+		})
+	}
+	if len(v.Tags) > 0 {
+		data.TagSpecifications = []xmlTemplateTagSpecification{{ResourceType: "instance", Tags: xmlTags(v.Tags)}}
+	}
+	return xmlLaunchTemplateVersion{
+		LaunchTemplateID:   t.ID,
+		LaunchTemplateName: t.Name,
+		VersionNumber:      v.Number,
+		VersionDescription: v.Description,
+		DefaultVersion:     v.Number == t.DefaultVersion,
+		CreateTime:         t.CreateTime.Format(time.RFC3339),
+		Data:               data,
+	}
+}
+
+type xmlLaunchTemplate struct {
+	LaunchTemplateID     string   `xml:"launchTemplateId"`
+	LaunchTemplateName   string   `xml:"launchTemplateName"`
+	CreateTime           string   `xml:"createTime"`
+	CreatedBy            string   `xml:"createdBy"`
+	DefaultVersionNumber int      `xml:"defaultVersionNumber"`
+	LatestVersionNumber  int      `xml:"latestVersionNumber"`
+	Tags                 []xmlTag `xml:"tagSet>item"`
+}
+
+type xmlTemplateProfile struct {
+	Arn  string `xml:"arn,omitempty"`
+	Name string `xml:"name,omitempty"`
+}
+
+type xmlTemplateEbs struct {
+	VolumeType          string `xml:"volumeType,omitempty"`
+	KmsKeyID            string `xml:"kmsKeyId,omitempty"`
+	VolumeSize          int    `xml:"volumeSize,omitempty"`
+	Iops                int    `xml:"iops,omitempty"`
+	Throughput          int    `xml:"throughput,omitempty"`
+	Encrypted           bool   `xml:"encrypted"`
+	DeleteOnTermination bool   `xml:"deleteOnTermination"`
+}
+
+type xmlTemplateBlockDevice struct {
+	DeviceName string         `xml:"deviceName"`
+	Ebs        xmlTemplateEbs `xml:"ebs"`
+}
+
+type xmlTemplateTagSpecification struct {
+	ResourceType string   `xml:"resourceType"`
+	Tags         []xmlTag `xml:"tagSet>item"`
+}
+
+type xmlLaunchTemplateData struct {
+	ImageID            string                        `xml:"imageId,omitempty"`
+	InstanceType       string                        `xml:"instanceType,omitempty"`
+	KeyName            string                        `xml:"keyName,omitempty"`
+	UserData           string                        `xml:"userData,omitempty"`
+	IamInstanceProfile *xmlTemplateProfile           `xml:"iamInstanceProfile,omitempty"`
+	SecurityGroups     []string                      `xml:"securityGroupIdSet>item"`
+	BlockDevices       []xmlTemplateBlockDevice      `xml:"blockDeviceMappingSet>item"`
+	TagSpecifications  []xmlTemplateTagSpecification `xml:"tagSpecificationSet>item"`
+}
+
+type xmlLaunchTemplateVersion struct {
+	LaunchTemplateID   string                `xml:"launchTemplateId"`
+	LaunchTemplateName string                `xml:"launchTemplateName"`
+	VersionNumber      int                   `xml:"versionNumber"`
+	VersionDescription string                `xml:"versionDescription,omitempty"`
+	DefaultVersion     bool                  `xml:"defaultVersion"`
+	CreateTime         string                `xml:"createTime"`
+	Data               xmlLaunchTemplateData `xml:"launchTemplateData"`
+}
+
+type launchTemplateResponse struct {
+	XMLName        xml.Name
+	LaunchTemplate xmlLaunchTemplate `xml:"launchTemplate"`
+}
+
+type launchTemplateVersionResponse struct {
+	XMLName               xml.Name                 `xml:"CreateLaunchTemplateVersionResponse"`
+	LaunchTemplateVersion xmlLaunchTemplateVersion `xml:"launchTemplateVersion"`
+}
+
+type describeLaunchTemplatesResponse struct {
+	XMLName         xml.Name            `xml:"DescribeLaunchTemplatesResponse"`
+	LaunchTemplates []xmlLaunchTemplate `xml:"launchTemplates>item"`
+}
+
+type describeLaunchTemplateVersionsResponse struct {
+	XMLName  xml.Name                   `xml:"DescribeLaunchTemplateVersionsResponse"`
+	Versions []xmlLaunchTemplateVersion `xml:"launchTemplateVersionSet>item"`
+}
@@ -0,0 +1,871 @@
+package awstest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// route53Prefix starts the path of every Route 53 request. Route 53 speaks
+// REST-XML rather than the Query protocol, so Cloud routes it by path.
+const route53Prefix = "/2013-04-01/"
+
+// Route53 serves the subset of the Route 53 REST API duet uses: public and
+// private hosted zones, their record sets and tags. Every change is PENDING
+// when submitted and INSYNC from the first GetChange on.
+type Route53 struct {
+	zones   map[string]*HostedZone
+	changes map[string]string
+	ec2     *EC2
+	calls   []string
+	mu      sync.Mutex
+	nextID  int
+}
+
+// HostedZone is a snapshot of a hosted zone held by the stand-in. Names end
+// in a dot, as Route 53 returns them.
+type HostedZone struct {
+	Tags            map[string]string
+	ID              string
+	Name            string
+	CallerReference string
+	Comment         string
+	VPCID           string
+	VPCRegion       string
+	Private         bool
+	NameServers     []string
+	records         []*RecordSet
+}
+
+// RecordSet is a record set in a hosted zone. Weight is set for weighted
+// records, and Alias replaces TTL and Values for alias records.
+type RecordSet struct {
+	Weight        *int64
+	Alias         *AliasTarget
+	Name          string
+	Type          string
+	SetIdentifier string
+	TTL           int64
+	Values        []string
+}
+
+// AliasTarget is the resource an alias record points at
+type AliasTarget struct {
+	HostedZoneID         string
+	DNSName              string
+	EvaluateTargetHealth bool
+}
+
+// NewRoute53 returns an empty Route 53 stand-in. It is an http.Handler, so
+// it can be served by httptest or by a real listener.
+func NewRoute53() *Route53 {
+	return &Route53{
+		zones:   make(map[string]*HostedZone),
+		changes: make(map[string]string),
+	}
+}
+
+// NewRoute53Server starts a Route 53 stand-in on a local port. Point a
+// provider at server.URL and call server.Close when done.
+func NewRoute53Server() (*Route53, *httptest.Server) {
+	r := NewRoute53()
+	return r, httptest.NewServer(r)
+}
+
+// HostedZone returns a copy of the hosted zone with the given ID, with or
+// without the /hostedzone/ prefix
+func (r *Route53) HostedZone(id string) (HostedZone, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	z, ok := r.zones[strings.TrimPrefix(id, "/hostedzone/")]
+	if !ok {
+		return HostedZone{}, false
+	}
+	return z.copy(), true
+}
+
+// HostedZones returns copies of all hosted zones, ordered by ID
+func (r *Route53) HostedZones() []HostedZone {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]HostedZone, 0, len(r.zones))
+	for _, id := range sortedIDs(r.zones) {
+		out = append(out, r.zones[id].copy())
+	}
+	return out
+}
+
+// Records returns copies of the record sets in a hosted zone, including the
+// SOA and NS records Route 53 creates with it, in the order Route 53 lists
+// them
+func (r *Route53) Records(zoneID string) []RecordSet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	z, ok := r.zones[strings.TrimPrefix(zoneID, "/hostedzone/")]
+	if !ok {
+		return nil
+	}
+	out := make([]RecordSet, 0, len(z.records))
+	for _, rs := range z.records {
+		out = append(out, rs.copy())
+	}
+	return out
+}
+
+// Calls returns how many times the named operation has been called. Names
+// follow the SDK, e.g. ChangeResourceRecordSets.
+func (r *Route53) Calls(operation string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, c := range r.calls {
+		if c == operation {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Route53) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Paths are /hostedzone[/{id}[/rrset]], /hostedzonesbyname, /change/{id}
+	// and /tags/hostedzone/{id}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, route53Prefix), "/"), "/")
+	query := req.URL.Query()
+	id := parts[len(parts)-1]
+	route := req.Method + " " + parts[0]
+	if parts[0] == "hostedzone" && len(parts) == 2 {
+		route += "/{id}"
+	} else if parts[0] == "hostedzone" && len(parts) == 3 {
+		id = parts[1]
+		route += "/{id}/" + parts[2]
+	}
+
+	switch route {
+	case "POST hostedzone":
+		r.serve(w, "CreateHostedZone", func() (interface{}, *iamError) { return r.createZone(w, body) })
+	case "GET hostedzone":
+		r.serve(w, "ListHostedZones", r.listZones)
+	case "GET hostedzonesbyname":
+		r.serve(w, "ListHostedZonesByName", func() (interface{}, *iamError) { return r.listZonesByName(query) })
+	case "GET hostedzone/{id}":
+		r.serve(w, "GetHostedZone", func() (interface{}, *iamError) { return r.getZone(id) })
+	case "POST hostedzone/{id}":
+		r.serve(w, "UpdateHostedZoneComment", func() (interface{}, *iamError) { return r.updateComment(id, body) })
+	case "DELETE hostedzone/{id}":
+		r.serve(w, "DeleteHostedZone", func() (interface{}, *iamError) { return r.deleteZone(id) })
+	case "POST hostedzone/{id}/rrset":
+		r.calls = append(r.calls, "ChangeResourceRecordSets")
+		resp, messages, err := r.changeRecords(id, body)
+		switch {
+		case err != nil:
+			writeRoute53Error(w, err)
+		case len(messages) > 0:
+			w.Header().Set("Content-Type", "text/xml")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = xml.NewEncoder(w).Encode(invalidChangeBatch{Messages: messages, RequestID: "awstest"})
+		default:
+			writeXML(w, resp)
+		}
+	case "GET hostedzone/{id}/rrset":
+		r.serve(w, "ListResourceRecordSets", func() (interface{}, *iamError) { return r.listRecords(id, query) })
+	case "GET change":
+		r.serve(w, "GetChange", func() (interface{}, *iamError) { return r.getChange(id) })
+	case "POST tags":
+		r.serve(w, "ChangeTagsForResource", func() (interface{}, *iamError) { return r.changeTags(parts, body) })
+	case "GET tags":
+		r.serve(w, "ListTagsForResource", func() (interface{}, *iamError) { return r.listTags(parts) })
+	default:
+		writeRoute53Error(w, route53Error(http.StatusBadRequest, "InvalidInput", "unsupported operation "+req.Method+" "+req.URL.Path))
+	}
+}
+
+// serve records a call to operation and writes what handle returns
+func (r *Route53) serve(w http.ResponseWriter, operation string, handle func() (interface{}, *iamError)) {
+	r.calls = append(r.calls, operation)
+	resp, err := handle()
+	if err != nil {
+		writeRoute53Error(w, err)
+		return
+	}
+	if resp != nil {
+		writeXML(w, resp)
+	}
+}
+
+func (r *Route53) lookup(id string) (*HostedZone, *iamError) {
+	z, ok := r.zones[id]
+	if !ok {
+		return nil, route53Error(http.StatusNotFound, "NoSuchHostedZone", "No hosted zone found with ID: "+id)
+	}
+	return z, nil
+}
+
+// change records a submitted change, which stays PENDING until GetChange
+// reports it
+func (r *Route53) change() xmlChangeInfo {
+	r.nextID++
+	id := fmt.Sprintf("C%013X", r.nextID)
+	r.changes[id] = "PENDING"
+	return xmlChangeInfo{ID: "/change/" + id, Status: "PENDING", SubmittedAt: time.Now().UTC().Format(time.RFC3339)}
+}
+
+func (r *Route53) createZone(w http.ResponseWriter, body []byte) (interface{}, *iamError) {
+	var in struct {
+		XMLName         xml.Name `xml:"CreateHostedZoneRequest"`
+		Name            string   `xml:"Name"`
+		CallerReference string   `xml:"CallerReference"`
+		VPCRegion       string   `xml:"VPC>VPCRegion"`
+		VPCID           string   `xml:"VPC>VPCId"`
+		Comment         string   `xml:"HostedZoneConfig>Comment"`
+	}
+	if err := xml.Unmarshal(body, &in); err != nil {
+		return nil, route53Error(http.StatusBadRequest, "InvalidInput", err.Error())
+	}
+	if in.Name == "" || in.CallerReference == "" {
+		return nil, route53Error(http.StatusBadRequest, "InvalidInput", "Name and CallerReference are required")
+	}
+	for _, z := range r.zones {
+		if z.CallerReference == in.CallerReference {
+			return nil, route53Error(http.StatusConflict, "HostedZoneAlreadyExists", "A hosted zone has already been created with the specified caller reference.")
+		}
+	}
+	if in.VPCID != "" && r.ec2 != nil {
+		if _, ok := r.ec2.VPC(in.VPCID); !ok {
+			return nil, route53Error(http.StatusBadRequest, "InvalidVPCId", "The VPC ID '"+in.VPCID+"' is not valid.")
+		}
+	}
+
+	r.nextID++
+	z := &HostedZone{
+		ID:              fmt.Sprintf("Z%013X", r.nextID),
+		Name:            canonicalName(in.Name),
+		CallerReference: in.CallerReference,
+		Comment:         in.Comment,
+		Tags:            make(map[string]string),
+	}
+	if in.VPCID != "" {
+		z.Private = true
+		z.VPCID = in.VPCID
+		z.VPCRegion = in.VPCRegion
+		if z.VPCRegion == "" {
+			z.VPCRegion = "us-east-1"
+		}
+		z.NameServers = []string{"ns-0.awsdns-00.com."}
+	} else {
+		for i := 0; i < 4; i++ {
+			z.NameServers = append(z.NameServers, fmt.Sprintf("ns-%d.awsdns-%02d.%s.", r.nextID*4+i, r.nextID%64, []string{"com", "net", "org", "co.uk"}[i]))
+		}
+	}
+	z.records = []*RecordSet{
+		{Name: z.Name, Type: "NS", TTL: 172800, Values: append([]string(nil), z.NameServers...)},
+		{Name: z.Name, Type: "SOA", TTL: 900, Values: []string{z.NameServers[0] + " awsdns-hostmaster.amazon.com. 1 7200 900 1209600 86400"}},
+	}
+	sortRecords(z.records)
+	r.zones[z.ID] = z
+
+	resp := createHostedZoneResponse{HostedZone: z.xml(), ChangeInfo: r.change()}
+	if z.Private {
+		resp.VPC = &xmlVPC{Region: z.VPCRegion, ID: z.VPCID}
+	} else {
+		resp.DelegationSet = &xmlDelegationSet{NameServers: z.NameServers}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Location", "https://route53.amazonaws.com/2013-04-01/hostedzone/"+z.ID)
+	w.WriteHeader(http.StatusCreated)
+	_ = xml.NewEncoder(w).Encode(resp)
+	return nil, nil
+}
+
+func (r *Route53) listZones() (interface{}, *iamError) {
+	resp := listHostedZonesResponse{MaxItems: "100"}
+	for _, z := range r.sortedZones() {
+		resp.HostedZones = append(resp.HostedZones, z.xml())
+	}
+	return resp, nil
+}
+
+func (r *Route53) listZonesByName(query url.Values) (interface{}, *iamError) {
+	resp := listHostedZonesByNameResponse{DNSName: query.Get("dnsname"), MaxItems: "100"}
+	start := ""
+	if name := query.Get("dnsname"); name != "" {
+		start = reversedName(canonicalName(name))
+	}
+	for _, z := range r.sortedZones() {
+		if reversedName(z.Name) >= start {
+			resp.HostedZones = append(resp.HostedZones, z.xml())
+		}
+	}
+	return resp, nil
+}
+
+// sortedZones returns the zones in the order ListHostedZonesByName uses:
+// by name with the labels reversed, then by ID
+func (r *Route53) sortedZones() []*HostedZone {
+	zones := make([]*HostedZone, 0, len(r.zones))
+	for _, id := range sortedIDs(r.zones) {
+		zones = append(zones, r.zones[id])
+	}
+	sort.SliceStable(zones, func(i, j int) bool {
+		return reversedName(zones[i].Name) < reversedName(zones[j].Name)
+	})
+	return zones
+}
+
+func (r *Route53) getZone(id string) (interface{}, *iamError) {
+	z, err := r.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	resp := getHostedZoneResponse{HostedZone: z.xml()}
+	if z.Private {
+		resp.VPCs = []xmlVPC{{Region: z.VPCRegion, ID: z.VPCID}}
+	} else {
+		resp.DelegationSet = &xmlDelegationSet{NameServers: z.NameServers}
+	}
+	return resp, nil
+}
+
+func (r *Route53) updateComment(id string, body []byte) (interface{}, *iamError) {
+	var in struct {
+		XMLName xml.Name `xml:"UpdateHostedZoneCommentRequest"`
+		Comment string   `xml:"Comment"`
+	}
+	if err := xml.Unmarshal(body, &in); err != nil {
+		return nil, route53Error(http.StatusBadRequest, "InvalidInput", err.Error())
+	}
+	z, err := r.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	z.Comment = in.Comment
+	return updateHostedZoneCommentResponse{HostedZone: z.xml()}, nil
+}
+
+func (r *Route53) deleteZone(id string) (interface{}, *iamError) {
+	z, err := r.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, rs := range z.records {
+		if !rs.apex(z) {
+			return nil, route53Error(http.StatusBadRequest, "HostedZoneNotEmpty", "The specified hosted zone contains non-required resource record sets and so cannot be deleted.")
+		}
+	}
+	delete(r.zones, id)
+	return deleteHostedZoneResponse{ChangeInfo: r.change()}, nil
+}
+
+// changeRecords applies a change batch atomically. Problems with the
+// changes themselves are returned as messages, which Route 53 reports in an
+// InvalidChangeBatch document rather than the usual error response.
+func (r *Route53) changeRecords(id string, body []byte) (interface{}, []string, *iamError) {
+	var in struct {
+		XMLName xml.Name    `xml:"ChangeResourceRecordSetsRequest"`
+		Changes []xmlChange `xml:"ChangeBatch>Changes>Change"`
+	}
+	if err := xml.Unmarshal(body, &in); err != nil {
+		return nil, nil, route53Error(http.StatusBadRequest, "InvalidInput", err.Error())
+	}
+	z, err := r.lookup(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(in.Changes) == 0 {
+		return nil, nil, route53Error(http.StatusBadRequest, "InvalidInput", "ChangeBatch must contain at least one change")
+	}
+
+	records := make([]*RecordSet, len(z.records))
+	copy(records, z.records)
+	var messages []string
+	for _, c := range in.Changes {
+		rs := c.Record.recordSet()
+		if msg := rs.validate(z); msg != "" {
+			messages = append(messages, msg)
+			continue
+		}
+		i := findRecord(records, rs)
+		switch c.Action {
+		case "CREATE":
+			if i >= 0 {
+				messages = append(messages, fmt.Sprintf("Tried to create resource record set [name='%s', type='%s'%s] but it already exists", rs.Name, rs.Type, rs.identifierMessage()))
+				continue
+			}
+			if msg := rs.conflicts(records, z); msg != "" {
+				messages = append(messages, msg)
+				continue
+			}
+			records = append(records, rs)
+		case "UPSERT":
+			if i >= 0 {
+				records[i] = rs
+				continue
+			}
+			if msg := rs.conflicts(records, z); msg != "" {
+				messages = append(messages, msg)
+				continue
+			}
+			records = append(records, rs)
+		case "DELETE":
+			if i < 0 || !records[i].equal(rs) {
+				messages = append(messages, fmt.Sprintf("Tried to delete resource record set [name='%s', type='%s'%s] but it was not found", rs.Name, rs.Type, rs.identifierMessage()))
+				continue
+			}
+			if rs.apex(z) {
+				messages = append(messages, fmt.Sprintf("A HostedZone must contain at least one %s record for the zone itself.", rs.Type))
+				continue
+			}
+			records = append(records[:i], records[i+1:]...)
+		default:
+			return nil, nil, route53Error(http.StatusBadRequest, "InvalidInput", "invalid action "+c.Action)
+		}
+	}
+	if len(messages) > 0 {
+		return nil, messages, nil
+	}
+	sortRecords(records)
+	z.records = records
+	return changeResourceRecordSetsResponse{ChangeInfo: r.change()}, nil, nil
+}
+
+func (r *Route53) listRecords(id string, query url.Values) (interface{}, *iamError) {
+	z, err := r.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	maxItems := 300
+	if s := query.Get("maxitems"); s != "" {
+		n, convErr := strconv.Atoi(s)
+		if convErr != nil || n < 1 {
+			return nil, route53Error(http.StatusBadRequest, "InvalidInput", "invalid maxitems "+s)
+		}
+		maxItems = n
+	}
+	start := &RecordSet{Name: canonicalName(query.Get("name")), Type: query.Get("type"), SetIdentifier: query.Get("identifier")}
+	if query.Get("type") != "" && query.Get("name") == "" {
+		return nil, route53Error(http.StatusBadRequest, "InvalidInput", "the type parameter requires the name parameter")
+	}
+
+	resp := listResourceRecordSetsResponse{MaxItems: strconv.Itoa(maxItems)}
+	for _, rs := range z.records {
+		if query.Get("name") != "" && recordLess(rs, start) {
+			continue
+		}
+		if len(resp.Records) == maxItems {
+			resp.IsTruncated = true
+			resp.NextRecordName = escapeName(rs.Name)
+			resp.NextRecordType = rs.Type
+			resp.NextRecordIdentifier = rs.SetIdentifier
+			break
+		}
+		resp.Records = append(resp.Records, rs.xml())
+	}
+	return resp, nil
+}
+
+func (r *Route53) getChange(id string) (interface{}, *iamError) {
+	if _, ok := r.changes[id]; !ok {
+		return nil, route53Error(http.StatusNotFound, "NoSuchChange", "A change with the specified change ID does not exist.")
+	}
+	r.changes[id] = "INSYNC"
+	return getChangeResponse{ChangeInfo: xmlChangeInfo{ID: "/change/" + id, Status: "INSYNC", SubmittedAt: time.Now().UTC().Format(time.RFC3339)}}, nil
+}
+
+// taggedZone returns the hosted zone a tags path names
+func (r *Route53) taggedZone(parts []string) (*HostedZone, *iamError) {
+	if len(parts) != 3 || parts[1] != "hostedzone" {
+		return nil, route53Error(http.StatusBadRequest, "InvalidInput", "only hosted zones can be tagged")
+	}
+	return r.lookup(parts[2])
+}
+
+func (r *Route53) changeTags(parts []string, body []byte) (interface{}, *iamError) {
+	var in struct {
+		XMLName    xml.Name `xml:"ChangeTagsForResourceRequest"`
+		AddTags    []S3Tag  `xml:"AddTags>Tag"`
+		RemoveKeys []string `xml:"RemoveTagKeys>Key"`
+	}
+	if err := xml.Unmarshal(body, &in); err != nil {
+		return nil, route53Error(http.StatusBadRequest, "InvalidInput", err.Error())
+	}
+	z, err := r.taggedZone(parts)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range in.AddTags {
+		z.Tags[t.Key] = t.Value
+	}
+	for _, k := range in.RemoveKeys {
+		delete(z.Tags, k)
+	}
+	return changeTagsForResourceResponse{}, nil
+}
+
+func (r *Route53) listTags(parts []string) (interface{}, *iamError) {
+	z, err := r.taggedZone(parts)
+	if err != nil {
+		return nil, err
+	}
+	resp := listTagsForResourceResponse{ResourceType: "hostedzone", ResourceID: z.ID}
+	for _, k := range sortedKeys(z.Tags) {
+		resp.Tags = append(resp.Tags, S3Tag{Key: k, Value: z.Tags[k]})
+	}
+	return resp, nil
+}
+
+func (z *HostedZone) copy() HostedZone {
+	out := *z
+	out.Tags = copyTags(z.Tags)
+	out.NameServers = append([]string(nil), z.NameServers...)
+	out.records = nil
+	return out
+}
+
+func (z *HostedZone) xml() xmlHostedZone {
+	return xmlHostedZone{
+		ID:              "/hostedzone/" + z.ID,
+		Name:            z.Name,
+		CallerReference: z.CallerReference,
+		Comment:         z.Comment,
+		PrivateZone:     z.Private,
+		RecordCount:     len(z.records),
+	}
+}
+
+func (rs *RecordSet) copy() RecordSet {
+	out := *rs
+	out.Values = append([]string(nil), rs.Values...)
+	if rs.Weight != nil {
+		w := *rs.Weight
+		out.Weight = &w
+	}
+	if rs.Alias != nil {
+		a := *rs.Alias
+		out.Alias = &a
+	}
+	return out
+}
+
+// apex reports whether rs is one of the SOA and NS records Route 53 keeps
+// for the zone itself
+func (rs *RecordSet) apex(z *HostedZone) bool {
+	return rs.Name == z.Name && (rs.Type == "SOA" || rs.Type == "NS")
+}
+
+func (rs *RecordSet) identifierMessage() string {
+	if rs.SetIdentifier == "" {
+		return ""
+	}
+	return ", set-identifier='" + rs.SetIdentifier + "'"
+}
+
+// validate returns why Route 53 would reject rs in z, or an empty string
+func (rs *RecordSet) validate(z *HostedZone) string {
+	if rs.Name != z.Name && !strings.HasSuffix(rs.Name, "."+z.Name) {
+		return fmt.Sprintf("RRSet with DNS name %s is not permitted in zone %s", rs.Name, z.Name)
+	}
+	switch rs.Type {
+	case "A", "AAAA", "CNAME", "TXT", "MX", "NS", "SOA", "SRV", "CAA", "PTR":
+	default:
+		return fmt.Sprintf("Invalid type %q for resource record set %s", rs.Type, rs.Name)
+	}
+	if (rs.SetIdentifier == "") != (rs.Weight == nil) {
+		return fmt.Sprintf("Invalid request: Expected exactly one of [Weight, Region, Failover, GeoLocation, MultiValueAnswer, GeoProximityLocation, or CidrRoutingConfig], but found none in Change with [Action=?, Name=%s, Type=%s, SetIdentifier=%s]", rs.Name, rs.Type, rs.SetIdentifier)
+	}
+	if rs.Alias != nil {
+		if rs.TTL != 0 || len(rs.Values) > 0 {
+			return fmt.Sprintf("Invalid Resource Record: alias record %s cannot have a TTL or resource records", rs.Name)
+		}
+		return ""
+	}
+	if len(rs.Values) == 0 {
+		return fmt.Sprintf("Invalid Resource Record: %s %s must have at least one resource record", rs.Name, rs.Type)
+	}
+	if rs.Type == "CNAME" && len(rs.Values) > 1 {
+		return fmt.Sprintf("RRSet of type CNAME with DNS name %s has more than one resource record", rs.Name)
+	}
+	if rs.Type == "CNAME" && rs.Name == z.Name {
+		return fmt.Sprintf("RRSet of type CNAME with DNS name %s is not permitted at apex in zone %s", rs.Name, z.Name)
+	}
+	for _, v := range rs.Values {
+		if rs.Type == "TXT" && !(strings.HasPrefix(v, `"`) && strings.HasSuffix(v, `"`)) {
+			return "Invalid Resource Record: 'FATAL problem: InvalidCharacterString (Value should be enclosed in quotation marks) encountered with '" + v + "''"
+		}
+	}
+	return ""
+}
+
+// conflicts returns why rs cannot be added alongside records: CNAME
+// records cannot share a name with any other record, and simple and
+// weighted records of the same name and type cannot be mixed
+func (rs *RecordSet) conflicts(records []*RecordSet, z *HostedZone) string {
+	for _, other := range records {
+		if other.Name != rs.Name {
+			continue
+		}
+		if (rs.Type == "CNAME") != (other.Type == "CNAME") {
+			return fmt.Sprintf("RRSet of type %s with DNS name %s is not permitted as it conflicts with other records with the same DNS name in zone %s", rs.Type, rs.Name, z.Name)
+		}
+		if other.Type == rs.Type && (other.SetIdentifier == "") != (rs.SetIdentifier == "") {
+			return fmt.Sprintf("RRSet of type %s with DNS name %s is not permitted because a conflicting RRSet of type %s with the same DNS name already exists in zone %s", rs.Type, rs.Name, rs.Type, z.Name)
+		}
+	}
+	return ""
+}
+
+// equal reports whether rs matches other exactly, as a DELETE must
+func (rs *RecordSet) equal(other *RecordSet) bool {
+	if rs.Name != other.Name || rs.Type != other.Type || rs.SetIdentifier != other.SetIdentifier || rs.TTL != other.TTL {
+		return false
+	}
+	if (rs.Weight == nil) != (other.Weight == nil) || (rs.Weight != nil && *rs.Weight != *other.Weight) {
+		return false
+	}
+	if (rs.Alias == nil) != (other.Alias == nil) || (rs.Alias != nil && *rs.Alias != *other.Alias) {
+		return false
+	}
+	a := append([]string(nil), rs.Values...)
+	b := append([]string(nil), other.Values...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return strings.Join(a, "\n") == strings.Join(b, "\n")
+}
+
+func (rs *RecordSet) xml() xmlRecordSet {
+	out := xmlRecordSet{
+		Name:          escapeName(rs.Name),
+		Type:          rs.Type,
+		SetIdentifier: rs.SetIdentifier,
+		Weight:        rs.Weight,
+	}
+	if rs.Alias != nil {
+		out.Alias = &xmlAliasTarget{HostedZoneID: rs.Alias.HostedZoneID, DNSName: rs.Alias.DNSName, EvaluateTargetHealth: rs.Alias.EvaluateTargetHealth}
+		return out
+	}
+	out.TTL = &rs.TTL
+	for _, v := range rs.Values {
+		out.Values = append(out.Values, xmlRecord{Value: v})
+	}
+	return out
+}
+
+func findRecord(records []*RecordSet, rs *RecordSet) int {
+	for i, other := range records {
+		if other.Name == rs.Name && other.Type == rs.Type && other.SetIdentifier == rs.SetIdentifier {
+			return i
+		}
+	}
+	return -1
+}
+
+// sortRecords puts records in the order ListResourceRecordSets returns
+// them: by name with the labels reversed, then by type and set identifier
+func sortRecords(records []*RecordSet) {
+	sort.SliceStable(records, func(i, j int) bool { return recordLess(records[i], records[j]) })
+}
+
+func recordLess(a, b *RecordSet) bool {
+	if an, bn := reversedName(a.Name), reversedName(b.Name); an != bn {
+		return an < bn
+	}
+	if a.Type != b.Type {
+		return a.Type < b.Type
+	}
+	return a.SetIdentifier < b.SetIdentifier
+}
+
+// canonicalName lower-cases a DNS name, unescapes the wildcard label and
+// ends it with a dot, the way Route 53 stores names
+func canonicalName(name string) string {
+	name = strings.ToLower(strings.ReplaceAll(name, `\052`, "*"))
+	if name != "" && !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// escapeName writes a wildcard label the way Route 53 returns it
+func escapeName(name string) string {
+	return strings.ReplaceAll(name, "*", `\052`)
+}
+
+func reversedName(name string) string {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, ".")
+}
+
+func route53Error(status int, code, message string) *iamError {
+	return &iamError{Type: "Sender", Code: code, Message: message, status: status}
+}
+
+func writeRoute53Error(w http.ResponseWriter, err *iamError) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(err.status)
+	_ = xml.NewEncoder(w).Encode(iamErrorResponse{Error: *err, RequestID: "awstest"})
+}
+
+type xmlChange struct {
+	Action string       `xml:"Action"`
+	Record xmlRecordSet `xml:"ResourceRecordSet"`
+}
+
+type xmlRecordSet struct {
+	Name          string          `xml:"Name"`
+	Type          string          `xml:"Type"`
+	SetIdentifier string          `xml:"SetIdentifier,omitempty"`
+	Weight        *int64          `xml:"Weight,omitempty"`
+	TTL           *int64          `xml:"TTL,omitempty"`
+	Values        []xmlRecord     `xml:"ResourceRecords>ResourceRecord,omitempty"`
+	Alias         *xmlAliasTarget `xml:"AliasTarget,omitempty"`
+}
+
+func (x xmlRecordSet) recordSet() *RecordSet {
+	rs := &RecordSet{
+		Name:          canonicalName(x.Name),
+		Type:          x.Type,
+		SetIdentifier: x.SetIdentifier,
+		Weight:        x.Weight,
+	}
+	for _, v := range x.Values {
+		rs.Values = append(rs.Values, v.Value)
+	}
+	if x.TTL != nil {
+		rs.TTL = *x.TTL
+	}
+	if x.Alias != nil {
+		rs.Alias = &AliasTarget{HostedZoneID: x.Alias.HostedZoneID, DNSName: canonicalName(x.Alias.DNSName), EvaluateTargetHealth: x.Alias.EvaluateTargetHealth}
+	}
+	return rs
+}
+
+type xmlRecord struct {
+	Value string `xml:"Value"`
+}
+
+type xmlAliasTarget struct {
+	HostedZoneID         string `xml:"HostedZoneId"`
+	DNSName              string `xml:"DNSName"`
+	EvaluateTargetHealth bool   `xml:"EvaluateTargetHealth"`
+}
+
+type xmlHostedZone struct {
+	ID              string `xml:"Id"`
+	Name            string `xml:"Name"`
+	CallerReference string `xml:"CallerReference"`
+	Comment         string `xml:"Config>Comment,omitempty"`
+	PrivateZone     bool   `xml:"Config>PrivateZone"`
+	RecordCount     int    `xml:"ResourceRecordSetCount"`
+}
+
+type xmlChangeInfo struct {
+	ID          string `xml:"Id"`
+	Status      string `xml:"Status"`
+	SubmittedAt string `xml:"SubmittedAt"`
+}
+
+type xmlDelegationSet struct {
+	NameServers []string `xml:"NameServers>NameServer"`
+}
+
+type xmlVPC struct {
+	Region string `xml:"VPCRegion"`
+	ID     string `xml:"VPCId"`
+}
+
+type createHostedZoneResponse struct {
+	XMLName       xml.Name          `xml:"CreateHostedZoneResponse"`
+	HostedZone    xmlHostedZone     `xml:"HostedZone"`
+	ChangeInfo    xmlChangeInfo     `xml:"ChangeInfo"`
+	DelegationSet *xmlDelegationSet `xml:"DelegationSet,omitempty"`
+	VPC           *xmlVPC           `xml:"VPC,omitempty"`
+}
+
+type getHostedZoneResponse struct {
+	XMLName       xml.Name          `xml:"GetHostedZoneResponse"`
+	HostedZone    xmlHostedZone     `xml:"HostedZone"`
+	DelegationSet *xmlDelegationSet `xml:"DelegationSet,omitempty"`
+	VPCs          []xmlVPC          `xml:"VPCs>VPC,omitempty"`
+}
+
+type listHostedZonesResponse struct {
+	XMLName     xml.Name        `xml:"ListHostedZonesResponse"`
+	HostedZones []xmlHostedZone `xml:"HostedZones>HostedZone"`
+	IsTruncated bool            `xml:"IsTruncated"`
+	MaxItems    string          `xml:"MaxItems"`
+}
+
+type listHostedZonesByNameResponse struct {
+	XMLName     xml.Name        `xml:"ListHostedZonesByNameResponse"`
+	HostedZones []xmlHostedZone `xml:"HostedZones>HostedZone"`
+	DNSName     string          `xml:"DNSName,omitempty"`
+	IsTruncated bool            `xml:"IsTruncated"`
+	MaxItems    string          `xml:"MaxItems"`
+}
+
+type updateHostedZoneCommentResponse struct {
+	XMLName    xml.Name      `xml:"UpdateHostedZoneCommentResponse"`
+	HostedZone xmlHostedZone `xml:"HostedZone"`
+}
+
+type deleteHostedZoneResponse struct {
+	XMLName    xml.Name      `xml:"DeleteHostedZoneResponse"`
+	ChangeInfo xmlChangeInfo `xml:"ChangeInfo"`
+}
+
+type changeResourceRecordSetsResponse struct {
+	XMLName    xml.Name      `xml:"ChangeResourceRecordSetsResponse"`
+	ChangeInfo xmlChangeInfo `xml:"ChangeInfo"`
+}
+
+type getChangeResponse struct {
+	XMLName    xml.Name      `xml:"GetChangeResponse"`
+	ChangeInfo xmlChangeInfo `xml:"ChangeInfo"`
+}
+
+type listResourceRecordSetsResponse struct {
+	XMLName              xml.Name       `xml:"ListResourceRecordSetsResponse"`
+	Records              []xmlRecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	IsTruncated          bool           `xml:"IsTruncated"`
+	NextRecordName       string         `xml:"NextRecordName,omitempty"`
+	NextRecordType       string         `xml:"NextRecordType,omitempty"`
+	NextRecordIdentifier string         `xml:"NextRecordIdentifier,omitempty"`
+	MaxItems             string         `xml:"MaxItems"`
+}
+
+type changeTagsForResourceResponse struct {
+	XMLName xml.Name `xml:"ChangeTagsForResourceResponse"`
+}
+
+type listTagsForResourceResponse struct {
+	XMLName      xml.Name `xml:"ListTagsForResourceResponse"`
+	ResourceType string   `xml:"ResourceTagSet>ResourceType"`
+	ResourceID   string   `xml:"ResourceTagSet>ResourceId"`
+	Tags         []S3Tag  `xml:"ResourceTagSet>Tags>Tag"`
+}
+
+// invalidChangeBatch is the document Route 53 answers a rejected change
+// batch with, in place of the usual error response
+type invalidChangeBatch struct {
+	XMLName   xml.Name `xml:"InvalidChangeBatch"`
+	Messages  []string `xml:"Messages>Message"`
+	RequestID string   `xml:"RequestId"`
+}
@@ -0,0 +1,335 @@
+package awstest
+
+import (
+	"encoding/xml"
+	"net/url"
+)
+
+// RouteTable is a snapshot of a route table held by the stand-in
+type RouteTable struct {
+	Tags         map[string]string
+	ID           string
+	VpcID        string
+	Routes       []Route
+	Associations []RouteTableAssociation
+}
+
+// Route is one route in a route table. The VPC's local route has GatewayID
+// "local" and origin CreateRouteTable; routes added later have origin
+// CreateRoute.
+type Route struct {
+	DestinationCidrBlock string
+	GatewayID            string
+	NatGatewayID         string
+	Origin               string
+}
+
+// RouteTableAssociation links a route table to a subnet, or marks the main
+// route table of a VPC
+type RouteTableAssociation struct {
+	ID       string
+	SubnetID string
+	Main     bool
+}
+
+// RouteTable returns a copy of the route table with the given ID
+func (e *EC2) RouteTable(id string) (RouteTable, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	rt, ok := e.routeTables[id]
+	if !ok {
+		return RouteTable{}, false
+	}
+	out := *rt
+	out.Tags = copyTags(rt.Tags)
+	out.Routes = append([]Route(nil), rt.Routes...)
+	out.Associations = append([]RouteTableAssociation(nil), rt.Associations...)
+	return out, true
+}
+
+func (e *EC2) createRouteTable(form url.Values) (interface{}, *apiError) {
+	vpc, err := e.lookupVpc(form.Get("VpcId"))
+	if err != nil {
+		return nil, err
+	}
+	rt := &RouteTable{
+		ID:     e.newID("rtb"),
+		VpcID:  vpc.ID,
+		Routes: []Route{{DestinationCidrBlock: vpc.CidrBlock, GatewayID: "local", Origin: "CreateRouteTable"}},
+		Tags:   tagSpecifications(form, "route-table"),
+	}
+	e.routeTables[rt.ID] = rt
+	return createRouteTableResponse{RouteTable: rt.xml()}, nil
+}
+
+func (e *EC2) lookupRouteTable(id string) (*RouteTable, *apiError) {
+	rt, ok := e.routeTables[id]
+	if !ok {
+		return nil, &apiError{Code: "InvalidRouteTableID.NotFound", Message: "The routeTable ID '" + id + "' does not exist"}
+	}
+	return rt, nil
+}
+
+func (e *EC2) describeRouteTables(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "RouteTableId")
+	for _, id := range ids {
+		if _, err := e.lookupRouteTable(id); err != nil {
+			return nil, err
+		}
+	}
+	filters := parseFilters(form)
+
+	resp := describeRouteTablesResponse{}
+	for _, id := range sortedIDs(e.routeTables) {
+		rt := e.routeTables[id]
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		if !matchFilters(filters, rt.Tags, func(name string) ([]string, bool) {
+			var values []string
+			switch name {
+			case "vpc-id":
+				values = append(values, rt.VpcID)
+			case "association.main":
+				values = append(values, boolString(rt.isMain()))
+			case "association.route-table-association-id":
+				for _, a := range rt.Associations {
+					values = append(values, a.ID)
+				}
+			case "association.subnet-id":
+				for _, a := range rt.Associations {
+					values = append(values, a.SubnetID)
+				}
+			default:
+				return nil, false
+			}
+			return values, true
+		}) {
+			continue
+		}
+		resp.RouteTables = append(resp.RouteTables, rt.xml())
+	}
+	return resp, nil
+}
+
+// route reads the target of a CreateRoute or ReplaceRoute call
+func (e *EC2) route(form url.Values) (Route, *apiError) {
+	r := Route{
+		DestinationCidrBlock: form.Get("DestinationCidrBlock"),
+		GatewayID:            form.Get("GatewayId"),
+		NatGatewayID:         form.Get("NatGatewayId"),
+		Origin:               "CreateRoute",
+	}
+	if r.DestinationCidrBlock == "" {
+		return r, &apiError{Code: "MissingParameter", Message: "The request must contain the parameter DestinationCidrBlock"}
+	}
+	switch {
+	case r.GatewayID != "" && r.NatGatewayID != "":
+		return r, &apiError{Code: "InvalidParameterCombination", Message: "More than one target specified"}
+	case r.GatewayID != "":
+		if _, err := e.lookupInternetGateway(r.GatewayID); err != nil {
+			return r, &apiError{Code: "InvalidGatewayID.NotFound", Message: "The gateway ID '" + r.GatewayID + "' does not exist"}
+		}
+	case r.NatGatewayID != "":
+		if _, err := e.lookupNatGateway(r.NatGatewayID); err != nil {
+			return r, err
+		}
+	default:
+		return r, &apiError{Code: "InvalidParameterCombination", Message: "No route target specified"}
+	}
+	return r, nil
+}
+
+func (e *EC2) createRoute(form url.Values) (interface{}, *apiError) {
+	rt, err := e.lookupRouteTable(form.Get("RouteTableId"))
+	if err != nil {
+		return nil, err
+	}
+	r, err := e.route(form)
+	if err != nil {
+		return nil, err
+	}
+	if rt.findRoute(r.DestinationCidrBlock) >= 0 {
+		return nil, &apiError{Code: "RouteAlreadyExists", Message: "The route identified by " + r.DestinationCidrBlock + " already exists"}
+	}
+	rt.Routes = append(rt.Routes, r)
+	return booleanResponse{XMLName: xml.Name{Local: "CreateRouteResponse"}, Return: true}, nil
+}
+
+func (e *EC2) replaceRoute(form url.Values) (interface{}, *apiError) {
+	rt, err := e.lookupRouteTable(form.Get("RouteTableId"))
+	if err != nil {
+		return nil, err
+	}
+	r, err := e.route(form)
+	if err != nil {
+		return nil, err
+	}
+	i := rt.findRoute(r.DestinationCidrBlock)
+	if i < 0 || rt.Routes[i].GatewayID == "local" {
+		return nil, &apiError{Code: "InvalidRoute.NotFound", Message: "no route with destination-cidr-block " + r.DestinationCidrBlock + " in route table " + rt.ID}
+	}
+	rt.Routes[i] = r
+	return booleanResponse{XMLName: xml.Name{Local: "ReplaceRouteResponse"}, Return: true}, nil
+}
+
+func (e *EC2) deleteRoute(form url.Values) (interface{}, *apiError) {
+	rt, err := e.lookupRouteTable(form.Get("RouteTableId"))
+	if err != nil {
+		return nil, err
+	}
+	i := rt.findRoute(form.Get("DestinationCidrBlock"))
+	if i < 0 {
+		return nil, &apiError{Code: "InvalidRoute.NotFound", Message: "no route with destination-cidr-block " + form.Get("DestinationCidrBlock") + " in route table " + rt.ID}
+	}
+	if rt.Routes[i].GatewayID == "local" {
+		return nil, &apiError{Code: "InvalidParameterValue", Message: "cannot remove local route " + rt.Routes[i].DestinationCidrBlock + " in route table " + rt.ID}
+	}
+	rt.Routes = append(rt.Routes[:i], rt.Routes[i+1:]...)
+	return booleanResponse{XMLName: xml.Name{Local: "DeleteRouteResponse"}, Return: true}, nil
+}
+
+func (e *EC2) associateRouteTable(form url.Values) (interface{}, *apiError) {
+	rt, err := e.lookupRouteTable(form.Get("RouteTableId"))
+	if err != nil {
+		return nil, err
+	}
+	subnet, err := e.lookupSubnet(form.Get("SubnetId"))
+	if err != nil {
+		return nil, err
+	}
+	if subnet.VpcID != rt.VpcID {
+		return nil, &apiError{Code: "InvalidParameterValue", Message: "route table " + rt.ID + " and subnet " + subnet.ID + " belong to different networks"}
+	}
+	for _, other := range e.routeTables {
+		for _, a := range other.Associations {
+			if a.SubnetID == subnet.ID {
+				return nil, &apiError{Code: "Resource.AlreadyAssociated", Message: "the specified association for route table " + other.ID + " conflicts with an existing association"}
+			}
+		}
+	}
+
+	id := e.newID("rtbassoc")
+	rt.Associations = append(rt.Associations, RouteTableAssociation{ID: id, SubnetID: subnet.ID})
+	return associateRouteTableResponse{AssociationID: id}, nil
+}
+
+func (e *EC2) disassociateRouteTable(form url.Values) (interface{}, *apiError) {
+	id := form.Get("AssociationId")
+	for _, rt := range e.routeTables {
+		for i, a := range rt.Associations {
+			if a.ID != id {
+				continue
+			}
+			if a.Main {
+				return nil, &apiError{Code: "InvalidParameterValue", Message: "cannot disassociate the main route table association " + id}
+			}
+			rt.Associations = append(rt.Associations[:i], rt.Associations[i+1:]...)
+			return booleanResponse{XMLName: xml.Name{Local: "DisassociateRouteTableResponse"}, Return: true}, nil
+		}
+	}
+	return nil, &apiError{Code: "InvalidAssociationID.NotFound", Message: "The association ID '" + id + "' does not exist"}
+}
+
+func (e *EC2) deleteRouteTable(form url.Values) (interface{}, *apiError) {
+	rt, err := e.lookupRouteTable(form.Get("RouteTableId"))
+	if err != nil {
+		return nil, err
+	}
+	if len(rt.Associations) > 0 {
+		return nil, &apiError{Code: "DependencyViolation", Message: "The routeTable '" + rt.ID + "' has dependencies and cannot be deleted"}
+	}
+	delete(e.routeTables, rt.ID)
+	return booleanResponse{XMLName: xml.Name{Local: "DeleteRouteTableResponse"}, Return: true}, nil
+}
+
+func (rt *RouteTable) isMain() bool {
+	for _, a := range rt.Associations {
+		if a.Main {
+			return true
+		}
+	}
+	return false
+}
+
+func (rt *RouteTable) findRoute(destination string) int {
+	for i, r := range rt.Routes {
+		if r.DestinationCidrBlock == destination {
+			return i
+		}
+	}
+	return -1
+}
+
+func (rt *RouteTable) xml() xmlRouteTable {
+	out := xmlRouteTable{RouteTableID: rt.ID, VpcID: rt.VpcID, Tags: xmlTags(rt.Tags)}
+	for _, r := range rt.Routes {
+		out.Routes = append(out.Routes, xmlRoute{
+			DestinationCidrBlock: r.DestinationCidrBlock,
+			GatewayID:            r.GatewayID,
+			NatGatewayID:         r.NatGatewayID,
+			State:                "active",
+			Origin:               r.Origin,
+		})
+	}
+	for _, a := range rt.Associations {
+		out.Associations = append(out.Associations, xmlRouteTableAssociation{
+			AssociationID: a.ID,
+			RouteTableID:  rt.ID,
+			SubnetID:      a.SubnetID,
+			Main:          a.Main,
+			State:         xmlAssociationState{State: "associated"},
+		})
+	}
+	return out
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+type xmlRoute struct {
+	DestinationCidrBlock string `xml:"destinationCidrBlock"`
+	GatewayID            string `xml:"gatewayId,omitempty"`
+	NatGatewayID         string `xml:"natGatewayId,omitempty"`
+	State                string `xml:"state"`
+	Origin               string `xml:"origin"`
+}
+
+type xmlAssociationState struct {
+	State string `xml:"state"`
+}
+
+type xmlRouteTableAssociation struct {
+	AssociationID string              `xml:"routeTableAssociationId"`
+	RouteTableID  string              `xml:"routeTableId"`
+	SubnetID      string              `xml:"subnetId,omitempty"`
+	State         xmlAssociationState `xml:"associationState"`
+	Main          bool                `xml:"main"`
+}
+
+type xmlRouteTable struct {
+	RouteTableID string                     `xml:"routeTableId"`
+	VpcID        string                     `xml:"vpcId"`
+	Routes       []xmlRoute                 `xml:"routeSet>item"`
+	Associations []xmlRouteTableAssociation `xml:"associationSet>item"`
+	Tags         []xmlTag                   `xml:"tagSet>item"`
+}
+
+type createRouteTableResponse struct {
+	XMLName    xml.Name      `xml:"CreateRouteTableResponse"`
+	RouteTable xmlRouteTable `xml:"routeTable"`
+}
+
+type describeRouteTablesResponse struct {
+	XMLName     xml.Name        `xml:"DescribeRouteTablesResponse"`
+	RouteTables []xmlRouteTable `xml:"routeTableSet>item"`
+}
+
+type associateRouteTableResponse struct {
+	XMLName       xml.Name `xml:"AssociateRouteTableResponse"`
+	AssociationID string   `xml:"associationId"`
+}
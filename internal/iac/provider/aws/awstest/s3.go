@@ -0,0 +1,591 @@
+package awstest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3 serves the bucket-level subset of the S3 REST API duet uses, with
+// path-style addressing: buckets and their versioning, encryption,
+// lifecycle, public access block, policy and tags, plus enough of the object
+// API to fill and empty them.
+type S3 struct {
+	buckets map[string]*Bucket
+	calls   []string
+	mu      sync.Mutex
+	nextID  int
+}
+
+// Bucket is a snapshot of a bucket held by the stand-in. Configuration is
+// kept as the XML or JSON documents S3 was given; nil means unset.
+type Bucket struct {
+	CreationDate      time.Time
+	Tags              map[string]string
+	Encryption        *ServerSideEncryptionConfiguration
+	Lifecycle         *LifecycleConfiguration
+	PublicAccessBlock *PublicAccessBlockConfiguration
+	objects           map[string][]objectVersion
+	Name              string
+	Region            string
+	Versioning        string
+	Policy            string
+}
+
+// objectVersion is one version of an object, or a delete marker
+type objectVersion struct {
+	versionID    string
+	body         []byte
+	deleteMarker bool
+}
+
+// NewS3 returns an empty S3 stand-in
+func NewS3() *S3 {
+	return &S3{buckets: make(map[string]*Bucket)}
+}
+
+// NewS3Server starts an S3 stand-in on a local port. Point a provider at
+// server.URL and call server.Close when done.
+func NewS3Server() (*S3, *httptest.Server) {
+	s := NewS3()
+	return s, httptest.NewServer(s)
+}
+
+// Bucket returns a copy of the named bucket
+func (s *S3) Bucket(name string) (Bucket, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[name]
+	if !ok {
+		return Bucket{}, false
+	}
+	out := *b
+	out.Tags = copyTags(b.Tags)
+	out.objects = nil
+	return out, true
+}
+
+// PutObject stores an object directly, for tests that need a bucket with
+// contents
+func (s *S3) PutObject(bucket, key string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return fmt.Errorf("no such bucket %s", bucket)
+	}
+	s.putObject(b, key, body)
+	return nil
+}
+
+// Objects returns the number of object versions and delete markers in a
+// bucket
+func (s *S3) Objects(bucket string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	if b, ok := s.buckets[bucket]; ok {
+		for _, versions := range b.objects {
+			n += len(versions)
+		}
+	}
+	return n
+}
+
+// Calls returns how many times the named operation has been called. Names
+// follow the SDK, e.g. PutBucketVersioning.
+func (s *S3) Calls(operation string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, c := range s.calls {
+		if c == operation {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *S3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	subresource := ""
+	for _, sub := range []string{"versioning", "encryption", "lifecycle", "publicAccessBlock", "policy", "tagging", "location", "versions", "delete"} {
+		if query.Has(sub) {
+			subresource = sub
+		}
+	}
+
+	operation := operationName(r.Method, name, key, subresource)
+	s.calls = append(s.calls, operation)
+
+	if name == "" {
+		s.listBuckets(w)
+		return
+	}
+	if operation == "CreateBucket" {
+		s.createBucket(w, r, name, body)
+		return
+	}
+	b, ok := s.buckets[name]
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist", r.Method)
+		return
+	}
+
+	switch operation {
+	case "HeadBucket":
+		w.WriteHeader(http.StatusOK)
+	case "DeleteBucket":
+		s.deleteBucket(w, b)
+	case "GetBucketLocation":
+		writeXML(w, locationConstraint{Region: strings.TrimPrefix(b.Region, "us-east-1")})
+	case "GetBucketVersioning":
+		writeXML(w, VersioningConfiguration{Status: b.Versioning})
+	case "PutBucketVersioning":
+		var config VersioningConfiguration
+		if decode(w, body, &config) {
+			b.Versioning = config.Status
+			w.WriteHeader(http.StatusOK)
+		}
+	case "GetBucketEncryption":
+		if b.Encryption == nil {
+			writeS3Error(w, http.StatusNotFound, "ServerSideEncryptionConfigurationNotFoundError", "The server side encryption configuration was not found", r.Method)
+			return
+		}
+		writeXML(w, b.Encryption)
+	case "PutBucketEncryption":
+		var config ServerSideEncryptionConfiguration
+		if decode(w, body, &config) {
+			b.Encryption = &config
+			w.WriteHeader(http.StatusOK)
+		}
+	case "DeleteBucketEncryption":
+		b.Encryption = nil
+		w.WriteHeader(http.StatusNoContent)
+	case "GetBucketLifecycleConfiguration":
+		if b.Lifecycle == nil {
+			writeS3Error(w, http.StatusNotFound, "NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist", r.Method)
+			return
+		}
+		writeXML(w, b.Lifecycle)
+	case "PutBucketLifecycleConfiguration":
+		var config LifecycleConfiguration
+		if decode(w, body, &config) {
+			b.Lifecycle = &config
+			w.WriteHeader(http.StatusOK)
+		}
+	case "DeleteBucketLifecycle":
+		b.Lifecycle = nil
+		w.WriteHeader(http.StatusNoContent)
+	case "GetPublicAccessBlock":
+		if b.PublicAccessBlock == nil {
+			writeS3Error(w, http.StatusNotFound, "NoSuchPublicAccessBlockConfiguration", "The public access block configuration was not found", r.Method)
+			return
+		}
+		writeXML(w, b.PublicAccessBlock)
+	case "PutPublicAccessBlock":
+		var config PublicAccessBlockConfiguration
+		if decode(w, body, &config) {
+			b.PublicAccessBlock = &config
+			w.WriteHeader(http.StatusOK)
+		}
+	case "DeletePublicAccessBlock":
+		b.PublicAccessBlock = nil
+		w.WriteHeader(http.StatusNoContent)
+	case "GetBucketPolicy":
+		if b.Policy == "" {
+			writeS3Error(w, http.StatusNotFound, "NoSuchBucketPolicy", "The bucket policy does not exist", r.Method)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(b.Policy))
+	case "PutBucketPolicy":
+		if !strings.HasPrefix(strings.TrimSpace(string(body)), "{") {
+			writeS3Error(w, http.StatusBadRequest, "MalformedPolicy", "Policies must be valid JSON", r.Method)
+			return
+		}
+		b.Policy = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	case "DeleteBucketPolicy":
+		b.Policy = ""
+		w.WriteHeader(http.StatusNoContent)
+	case "GetBucketTagging":
+		if len(b.Tags) == 0 {
+			writeS3Error(w, http.StatusNotFound, "NoSuchTagSet", "The TagSet does not exist", r.Method)
+			return
+		}
+		tagging := Tagging{}
+		for _, k := range sortedKeys(b.Tags) {
+			tagging.TagSet = append(tagging.TagSet, S3Tag{Key: k, Value: b.Tags[k]})
+		}
+		writeXML(w, tagging)
+	case "PutBucketTagging":
+		var tagging Tagging
+		if decode(w, body, &tagging) {
+			b.Tags = make(map[string]string, len(tagging.TagSet))
+			for _, t := range tagging.TagSet {
+				b.Tags[t.Key] = t.Value
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case "DeleteBucketTagging":
+		b.Tags = nil
+		w.WriteHeader(http.StatusNoContent)
+	case "ListObjectVersions":
+		s.listObjectVersions(w, b)
+	case "DeleteObjects":
+		s.deleteObjects(w, b, body)
+	case "PutObject":
+		versionID := s.putObject(b, key, body)
+		if versionID != "null" {
+			w.Header().Set("x-amz-version-id", versionID)
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "unsupported operation "+operation, r.Method)
+	}
+}
+
+// operationName maps a request to the S3 operation it performs
+func operationName(method, bucket, key, subresource string) string {
+	if bucket == "" {
+		return "ListBuckets"
+	}
+	if key != "" {
+		if method == http.MethodPut {
+			return "PutObject"
+		}
+		return method + "Object"
+	}
+	names := map[string]string{
+		"versioning":        "BucketVersioning",
+		"encryption":        "BucketEncryption",
+		"lifecycle":         "BucketLifecycleConfiguration",
+		"publicAccessBlock": "PublicAccessBlock",
+		"policy":            "BucketPolicy",
+		"tagging":           "BucketTagging",
+		"location":          "BucketLocation",
+	}
+	switch {
+	case subresource == "versions":
+		return "ListObjectVersions"
+	case subresource == "delete":
+		return "DeleteObjects"
+	case subresource == "lifecycle" && method == http.MethodDelete:
+		return "DeleteBucketLifecycle"
+	case subresource != "":
+		verb := map[string]string{http.MethodGet: "Get", http.MethodPut: "Put", http.MethodDelete: "Delete"}[method]
+		return verb + names[subresource]
+	}
+	switch method {
+	case http.MethodPut:
+		return "CreateBucket"
+	case http.MethodHead:
+		return "HeadBucket"
+	case http.MethodDelete:
+		return "DeleteBucket"
+	}
+	return method + "Bucket"
+}
+
+func (s *S3) listBuckets(w http.ResponseWriter) {
+	resp := listBucketsResult{Owner: s3Owner{ID: "awstest"}}
+	names := make([]string, 0, len(s.buckets))
+	for name := range s.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		resp.Buckets = append(resp.Buckets, s3Bucket{
+			Name:         name,
+			CreationDate: s.buckets[name].CreationDate.Format(time.RFC3339),
+		})
+	}
+	writeXML(w, resp)
+}
+
+func (s *S3) createBucket(w http.ResponseWriter, r *http.Request, name string, body []byte) {
+	region := "us-east-1"
+	if len(body) > 0 {
+		var config createBucketConfiguration
+		if !decode(w, body, &config) {
+			return
+		}
+		if config.LocationConstraint != "" {
+			region = config.LocationConstraint
+		}
+	}
+	if _, ok := s.buckets[name]; ok {
+		writeS3Error(w, http.StatusConflict, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it.", r.Method)
+		return
+	}
+	if len(name) < 3 || len(name) > 63 || strings.ToLower(name) != name || strings.ContainsAny(name, "_ ") {
+		writeS3Error(w, http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid.", r.Method)
+		return
+	}
+
+	s.buckets[name] = &Bucket{
+		Name:         name,
+		Region:       region,
+		CreationDate: time.Now().UTC().Truncate(time.Second),
+		objects:      make(map[string][]objectVersion),
+	}
+	w.Header().Set("Location", "/"+name)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *S3) deleteBucket(w http.ResponseWriter, b *Bucket) {
+	if len(b.objects) > 0 {
+		writeS3Error(w, http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty", http.MethodDelete)
+		return
+	}
+	delete(s.buckets, b.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// putObject stores a new version of an object. Unversioned buckets keep a
+// single version with ID "null".
+func (s *S3) putObject(b *Bucket, key string, body []byte) string {
+	if b.Versioning != "Enabled" {
+		b.objects[key] = []objectVersion{{versionID: "null", body: body}}
+		return "null"
+	}
+	s.nextID++
+	v := objectVersion{versionID: fmt.Sprintf("v%06d", s.nextID), body: body}
+	b.objects[key] = append(b.objects[key], v)
+	return v.versionID
+}
+
+func (s *S3) listObjectVersions(w http.ResponseWriter, b *Bucket) {
+	resp := listVersionsResult{Name: b.Name}
+	for _, key := range sortedKeys(b.objects) {
+		versions := b.objects[key]
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := xmlObjectVersion{Key: key, VersionID: versions[i].versionID, IsLatest: i == len(versions)-1}
+			if versions[i].deleteMarker {
+				resp.DeleteMarkers = append(resp.DeleteMarkers, v)
+			} else {
+				v.Size = len(versions[i].body)
+				resp.Versions = append(resp.Versions, v)
+			}
+		}
+	}
+	writeXML(w, resp)
+}
+
+func (s *S3) deleteObjects(w http.ResponseWriter, b *Bucket, body []byte) {
+	var req deleteRequest
+	if !decode(w, body, &req) {
+		return
+	}
+	resp := deleteResult{}
+	for _, obj := range req.Objects {
+		versions := b.objects[obj.Key]
+		switch {
+		case obj.VersionID != "":
+			for i, v := range versions {
+				if v.versionID == obj.VersionID {
+					versions = append(versions[:i], versions[i+1:]...)
+					break
+				}
+			}
+		case b.Versioning == "Enabled":
+			s.nextID++
+			versions = append(versions, objectVersion{versionID: fmt.Sprintf("v%06d", s.nextID), deleteMarker: true})
+		default:
+			versions = nil
+		}
+		if len(versions) == 0 {
+			delete(b.objects, obj.Key)
+		} else {
+			b.objects[obj.Key] = versions
+		}
+		resp.Deleted = append(resp.Deleted, deletedObject{Key: obj.Key, VersionID: obj.VersionID})
+	}
+	writeXML(w, resp)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decode parses an XML request body, answering with MalformedXML if it
+// cannot
+func decode(w http.ResponseWriter, body []byte, v interface{}) bool {
+	if err := xml.Unmarshal(body, v); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", err.Error(), http.MethodPut)
+		return false
+	}
+	return true
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+// writeS3Error writes an S3 error document. HEAD responses have no body,
+// so clients see only the status code.
+func writeS3Error(w http.ResponseWriter, status int, code, message, method string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if method == http.MethodHead {
+		return
+	}
+	_ = xml.NewEncoder(w).Encode(s3Error{Code: code, Message: message})
+}
+
+// VersioningConfiguration is the versioning state of a bucket: Enabled,
+// Suspended, or empty if versioning was never enabled
+type VersioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+// ServerSideEncryptionConfiguration is a bucket's default encryption
+type ServerSideEncryptionConfiguration struct {
+	XMLName xml.Name               `xml:"ServerSideEncryptionConfiguration"`
+	Rules   []ServerSideEncryption `xml:"Rule"`
+}
+
+// ServerSideEncryption is one default encryption rule
+type ServerSideEncryption struct {
+	SSEAlgorithm     string `xml:"ApplyServerSideEncryptionByDefault>SSEAlgorithm"`
+	KMSMasterKeyID   string `xml:"ApplyServerSideEncryptionByDefault>KMSMasterKeyID,omitempty"`
+	BucketKeyEnabled bool   `xml:"BucketKeyEnabled,omitempty"`
+}
+
+// LifecycleConfiguration is a bucket's lifecycle rules
+type LifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration"`
+	Rules   []LifecycleRule `xml:"Rule"`
+}
+
+// LifecycleRule is a single lifecycle rule. Day counts are zero when unset.
+type LifecycleRule struct {
+	Filter                       *LifecycleFilter      `xml:"Filter,omitempty"`
+	ID                           string                `xml:"ID"`
+	Status                       string                `xml:"Status"`
+	Transitions                  []LifecycleTransition `xml:"Transition"`
+	ExpirationDays               int                   `xml:"Expiration>Days,omitempty"`
+	NoncurrentVersionDays        int                   `xml:"NoncurrentVersionExpiration>NoncurrentDays,omitempty"`
+	AbortIncompleteMultipartDays int                   `xml:"AbortIncompleteMultipartUpload>DaysAfterInitiation,omitempty"`
+}
+
+// LifecycleFilter limits a lifecycle rule to keys with a prefix
+type LifecycleFilter struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// LifecycleTransition moves objects to another storage class after a
+// number of days
+type LifecycleTransition struct {
+	StorageClass string `xml:"StorageClass"`
+	Days         int    `xml:"Days"`
+}
+
+// PublicAccessBlockConfiguration is a bucket's public access block
+type PublicAccessBlockConfiguration struct {
+	XMLName               xml.Name `xml:"PublicAccessBlockConfiguration"`
+	BlockPublicAcls       bool     `xml:"BlockPublicAcls"`
+	IgnorePublicAcls      bool     `xml:"IgnorePublicAcls"`
+	BlockPublicPolicy     bool     `xml:"BlockPublicPolicy"`
+	RestrictPublicBuckets bool     `xml:"RestrictPublicBuckets"`
+}
+
+// Tagging is a bucket's tag set
+type Tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  []S3Tag  `xml:"TagSet>Tag"`
+}
+
+// S3Tag is one bucket tag
+type S3Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+type createBucketConfiguration struct {
+	LocationConstraint string `xml:"LocationConstraint"`
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Region  string   `xml:",chardata"`
+}
+
+type s3Owner struct {
+	ID string `xml:"ID"`
+}
+
+type s3Bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listBucketsResult struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type xmlObjectVersion struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId"`
+	IsLatest  bool   `xml:"IsLatest"`
+	Size      int    `xml:"Size,omitempty"`
+}
+
+type listVersionsResult struct {
+	XMLName       xml.Name           `xml:"ListVersionsResult"`
+	Name          string             `xml:"Name"`
+	Versions      []xmlObjectVersion `xml:"Version"`
+	DeleteMarkers []xmlObjectVersion `xml:"DeleteMarker"`
+	IsTruncated   bool               `xml:"IsTruncated"`
+}
+
+type deleteRequest struct {
+	Objects []struct {
+		Key       string `xml:"Key"`
+		VersionID string `xml:"VersionId"`
+	} `xml:"Object"`
+}
+
+type deletedObject struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId,omitempty"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Deleted []deletedObject `xml:"Deleted"`
+}
@@ -0,0 +1,337 @@
+package awstest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// SecurityGroup is a snapshot of a security group held by the stand-in
+type SecurityGroup struct {
+	Tags        map[string]string
+	ID          string
+	Name        string
+	Description string
+	VpcID       string
+	Ingress     []Permission
+	Egress      []Permission
+}
+
+// Permission is a single security group rule with exactly one source or
+// destination: an IPv4 range, an IPv6 range or another group. Ports are zero
+// for protocol -1.
+type Permission struct {
+	Protocol    string
+	CidrIP      string
+	CidrIPv6    string
+	GroupID     string
+	Description string
+	FromPort    int
+	ToPort      int
+}
+
+// SecurityGroup returns a copy of the security group with the given ID
+func (e *EC2) SecurityGroup(id string) (SecurityGroup, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g, ok := e.securityGroups[id]
+	if !ok {
+		return SecurityGroup{}, false
+	}
+	out := *g
+	out.Tags = copyTags(g.Tags)
+	out.Ingress = append([]Permission(nil), g.Ingress...)
+	out.Egress = append([]Permission(nil), g.Egress...)
+	return out, true
+}
+
+func (e *EC2) createSecurityGroup(form url.Values) (interface{}, *apiError) {
+	name := form.Get("GroupName")
+	if name == "" || form.Get("GroupDescription") == "" {
+		return nil, &apiError{Code: "MissingParameter", Message: "The request must contain the parameters GroupName and GroupDescription"}
+	}
+	// The stand-in has no default VPC
+	if form.Get("VpcId") == "" {
+		return nil, &apiError{Code: "VPCIdNotSpecified", Message: "No default VPC for this user"}
+	}
+	vpc, err := e.lookupVpc(form.Get("VpcId"))
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range e.securityGroups {
+		if g.VpcID == vpc.ID && g.Name == name {
+			return nil, &apiError{Code: "InvalidGroup.Duplicate", Message: "The security group '" + name + "' already exists for VPC '" + vpc.ID + "'"}
+		}
+	}
+
+	group := &SecurityGroup{
+		ID:          e.newID("sg"),
+		Name:        name,
+		Description: form.Get("GroupDescription"),
+		VpcID:       vpc.ID,
+		Egress:      []Permission{{Protocol: "-1", CidrIP: "0.0.0.0/0"}},
+		Tags:        tagSpecifications(form, "security-group"),
+	}
+	e.securityGroups[group.ID] = group
+	return createSecurityGroupResponse{GroupID: group.ID, Tags: xmlTags(group.Tags)}, nil
+}
+
+func (e *EC2) lookupSecurityGroup(id string) (*SecurityGroup, *apiError) {
+	group, ok := e.securityGroups[id]
+	if !ok {
+		return nil, &apiError{Code: "InvalidGroup.NotFound", Message: "The security group '" + id + "' does not exist"}
+	}
+	return group, nil
+}
+
+func (e *EC2) describeSecurityGroups(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "GroupId")
+	for _, id := range ids {
+		if _, err := e.lookupSecurityGroup(id); err != nil {
+			return nil, err
+		}
+	}
+	filters := parseFilters(form)
+
+	resp := describeSecurityGroupsResponse{}
+	for _, id := range sortedIDs(e.securityGroups) {
+		group := e.securityGroups[id]
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		if !matchFilters(filters, group.Tags, func(name string) ([]string, bool) {
+			switch name {
+			case "vpc-id":
+				return []string{group.VpcID}, true
+			case "group-name":
+				return []string{group.Name}, true
+			}
+			return nil, false
+		}) {
+			continue
+		}
+		resp.SecurityGroups = append(resp.SecurityGroups, group.xml())
+	}
+	return resp, nil
+}
+
+func (e *EC2) authorize(form url.Values, egress bool) (interface{}, *apiError) {
+	group, err := e.lookupSecurityGroup(form.Get("GroupId"))
+	if err != nil {
+		return nil, err
+	}
+	rules := group.rules(egress)
+	for _, p := range permissions(form) {
+		if p.GroupID != "" {
+			if _, err := e.lookupSecurityGroup(p.GroupID); err != nil {
+				return nil, err
+			}
+		}
+		if findPermission(*rules, p) >= 0 {
+			return nil, &apiError{Code: "InvalidPermission.Duplicate", Message: "the specified rule " + p.String() + " already exists"}
+		}
+		*rules = append(*rules, p)
+	}
+
+	action := "AuthorizeSecurityGroupIngressResponse"
+	if egress {
+		action = "AuthorizeSecurityGroupEgressResponse"
+	}
+	return booleanResponse{XMLName: xml.Name{Local: action}, Return: true}, nil
+}
+
+func (e *EC2) revoke(form url.Values, egress bool) (interface{}, *apiError) {
+	group, err := e.lookupSecurityGroup(form.Get("GroupId"))
+	if err != nil {
+		return nil, err
+	}
+	rules := group.rules(egress)
+	for _, p := range permissions(form) {
+		i := findPermission(*rules, p)
+		if i < 0 {
+			return nil, &apiError{Code: "InvalidPermission.NotFound", Message: "the specified rule " + p.String() + " does not exist in this security group"}
+		}
+		*rules = append((*rules)[:i], (*rules)[i+1:]...)
+	}
+
+	action := "RevokeSecurityGroupIngressResponse"
+	if egress {
+		action = "RevokeSecurityGroupEgressResponse"
+	}
+	return booleanResponse{XMLName: xml.Name{Local: action}, Return: true}, nil
+}
+
+func (e *EC2) updateRuleDescriptions(form url.Values, egress bool) (interface{}, *apiError) {
+	group, err := e.lookupSecurityGroup(form.Get("GroupId"))
+	if err != nil {
+		return nil, err
+	}
+	rules := group.rules(egress)
+	for _, p := range permissions(form) {
+		i := findPermission(*rules, p)
+		if i < 0 {
+			return nil, &apiError{Code: "InvalidPermission.NotFound", Message: "the specified rule " + p.String() + " does not exist in this security group"}
+		}
+		(*rules)[i].Description = p.Description
+	}
+
+	action := "UpdateSecurityGroupRuleDescriptionsIngressResponse"
+	if egress {
+		action = "UpdateSecurityGroupRuleDescriptionsEgressResponse"
+	}
+	return booleanResponse{XMLName: xml.Name{Local: action}, Return: true}, nil
+}
+
+func (e *EC2) deleteSecurityGroup(form url.Values) (interface{}, *apiError) {
+	group, err := e.lookupSecurityGroup(form.Get("GroupId"))
+	if err != nil {
+		return nil, err
+	}
+	if group.Name == "default" {
+		return nil, &apiError{Code: "CannotDelete", Message: "the specified group: \"" + group.ID + "\" name: \"default\" cannot be deleted by a user"}
+	}
+	for _, inst := range e.instances {
+		if inst.State != "terminated" && contains(inst.SecurityGroups, group.ID) {
+			return nil, &apiError{Code: "DependencyViolation", Message: "resource " + group.ID + " has a dependent object"}
+		}
+	}
+	delete(e.securityGroups, group.ID)
+	return booleanResponse{XMLName: xml.Name{Local: "DeleteSecurityGroupResponse"}, Return: true}, nil
+}
+
+func (g *SecurityGroup) rules(egress bool) *[]Permission {
+	if egress {
+		return &g.Egress
+	}
+	return &g.Ingress
+}
+
+func (g *SecurityGroup) xml() xmlSecurityGroup {
+	out := xmlSecurityGroup{
+		GroupID:          g.ID,
+		GroupName:        g.Name,
+		GroupDescription: g.Description,
+		VpcID:            g.VpcID,
+		Tags:             xmlTags(g.Tags),
+	}
+	for _, p := range g.Ingress {
+		out.Ingress = append(out.Ingress, p.xml())
+	}
+	for _, p := range g.Egress {
+		out.Egress = append(out.Egress, p.xml())
+	}
+	return out
+}
+
+// permissions flattens IpPermissions.N parameters into one Permission per
+// source
+func permissions(form url.Values) []Permission {
+	var out []Permission
+	for i := 1; form.Has(fmt.Sprintf("IpPermissions.%d.IpProtocol", i)); i++ {
+		prefix := fmt.Sprintf("IpPermissions.%d.", i)
+		base := Permission{Protocol: form.Get(prefix + "IpProtocol")}
+		if base.Protocol != "-1" {
+			base.FromPort, _ = strconv.Atoi(form.Get(prefix + "FromPort"))
+			base.ToPort, _ = strconv.Atoi(form.Get(prefix + "ToPort"))
+		}
+
+		for j := 1; form.Has(fmt.Sprintf("%sIpRanges.%d.CidrIp", prefix, j)); j++ {
+			p := base
+			p.CidrIP = form.Get(fmt.Sprintf("%sIpRanges.%d.CidrIp", prefix, j))
+			p.Description = form.Get(fmt.Sprintf("%sIpRanges.%d.Description", prefix, j))
+			out = append(out, p)
+		}
+		for j := 1; form.Has(fmt.Sprintf("%sIpv6Ranges.%d.CidrIpv6", prefix, j)); j++ {
+			p := base
+			p.CidrIPv6 = form.Get(fmt.Sprintf("%sIpv6Ranges.%d.CidrIpv6", prefix, j))
+			p.Description = form.Get(fmt.Sprintf("%sIpv6Ranges.%d.Description", prefix, j))
+			out = append(out, p)
+		}
+		for j := 1; form.Has(fmt.Sprintf("%sGroups.%d.GroupId", prefix, j)); j++ {
+			p := base
+			p.GroupID = form.Get(fmt.Sprintf("%sGroups.%d.GroupId", prefix, j))
+			p.Description = form.Get(fmt.Sprintf("%sGroups.%d.Description", prefix, j))
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// findPermission returns the index of the rule matching p, ignoring its
+// description, or -1
+func findPermission(rules []Permission, p Permission) int {
+	for i, r := range rules {
+		r.Description = p.Description
+		if r == p {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p Permission) String() string {
+	source := p.CidrIP + p.CidrIPv6 + p.GroupID
+	return fmt.Sprintf("%s %d-%d %s", p.Protocol, p.FromPort, p.ToPort, source)
+}
+
+func (p Permission) xml() xmlPermission {
+	out := xmlPermission{Protocol: p.Protocol}
+	if p.Protocol != "-1" {
+		out.FromPort, out.ToPort = &p.FromPort, &p.ToPort
+	}
+	switch {
+	case p.CidrIP != "":
+		out.IPRanges = []xmlIPRange{{CidrIP: p.CidrIP, Description: p.Description}}
+	case p.CidrIPv6 != "":
+		out.IPv6Ranges = []xmlIPv6Range{{CidrIPv6: p.CidrIPv6, Description: p.Description}}
+	case p.GroupID != "":
+		out.Groups = []xmlGroupPair{{GroupID: p.GroupID, Description: p.Description}}
+	}
+	return out
+}
+
+type xmlIPRange struct {
+	CidrIP      string `xml:"cidrIp"`
+	Description string `xml:"description,omitempty"`
+}
+
+type xmlIPv6Range struct {
+	CidrIPv6    string `xml:"cidrIpv6"`
+	Description string `xml:"description,omitempty"`
+}
+
+type xmlGroupPair struct {
+	GroupID     string `xml:"groupId"`
+	Description string `xml:"description,omitempty"`
+}
+
+type xmlPermission struct {
+	FromPort   *int           `xml:"fromPort,omitempty"`
+	ToPort     *int           `xml:"toPort,omitempty"`
+	Protocol   string         `xml:"ipProtocol"`
+	IPRanges   []xmlIPRange   `xml:"ipRanges>item"`
+	IPv6Ranges []xmlIPv6Range `xml:"ipv6Ranges>item"`
+	Groups     []xmlGroupPair `xml:"groups>item"`
+}
+
+type xmlSecurityGroup struct {
+	GroupID          string          `xml:"groupId"`
+	GroupName        string          `xml:"groupName"`
+	GroupDescription string          `xml:"groupDescription"`
+	VpcID            string          `xml:"vpcId"`
+	Ingress          []xmlPermission `xml:"ipPermissions>item"`
+	Egress           []xmlPermission `xml:"ipPermissionsEgress>item"`
+	Tags             []xmlTag        `xml:"tagSet>item"`
+}
+
+type createSecurityGroupResponse struct {
+	XMLName xml.Name `xml:"CreateSecurityGroupResponse"`
+	GroupID string   `xml:"groupId"`
+	Tags    []xmlTag `xml:"tagSet>item"`
+}
+
+type describeSecurityGroupsResponse struct {
+	XMLName        xml.Name           `xml:"DescribeSecurityGroupsResponse"`
+	SecurityGroups []xmlSecurityGroup `xml:"securityGroupInfo>item"`
+}
@@ -0,0 +1,257 @@
+package awstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// ssmTargetPrefix starts the X-Amz-Target header of every SSM request. SSM
+// speaks the JSON protocol, so Cloud routes it by that header.
+const ssmTargetPrefix = "AmazonSSM."
+
+// shellDocument is the only SSM document the stand-in runs
+const shellDocument = "AWS-RunShellScript"
+
+// SSM serves the Run Command subset of the Systems Manager API:
+// SendCommand, GetCommandInvocation and CancelCommand. Nothing is run;
+// each command's result comes from the handler set with SetHandler. Like
+// SSM, an invocation does not exist on the first poll after it is sent, is
+// in progress on the next, and has its result from then on.
+type SSM struct {
+	handler  func(instanceID, command string) CommandResult
+	ec2      *EC2
+	commands []*Command
+	mu       sync.Mutex
+}
+
+// CommandResult is what a command printed and the code it exited with.
+// A command that is Running stays in progress until it is cancelled.
+type CommandResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Running  bool
+}
+
+// Command is a snapshot of a command sent to one instance
+type Command struct {
+	Result         CommandResult
+	ID             string
+	InstanceID     string
+	Document       string
+	Status         string
+	Commands       []string
+	TimeoutSeconds int
+	// ExecutionTimeout is the document's executionTimeout parameter
+	ExecutionTimeout string
+	polls            int
+}
+
+// NewSSM returns an SSM stand-in whose commands all succeed with no output
+func NewSSM() *SSM {
+	return &SSM{}
+}
+
+// NewSSMServer starts an SSM stand-in on a local port. Point a client at
+// server.URL and call server.Close when done.
+func NewSSMServer() (*SSM, *httptest.Server) {
+	s := NewSSM()
+	return s, httptest.NewServer(s)
+}
+
+// SetHandler sets the function that decides the result of each command
+// sent afterwards
+func (s *SSM) SetHandler(handler func(instanceID, command string) CommandResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = handler
+}
+
+// Commands returns copies of the commands sent so far, oldest first
+func (s *SSM) Commands() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Command, 0, len(s.commands))
+	for _, c := range s.commands {
+		snapshot := *c
+		snapshot.Commands = append([]string(nil), c.Commands...)
+		out = append(out, snapshot)
+	}
+	return out
+}
+
+func (s *SSM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var resp interface{}
+	var apiErr *ssmError
+	switch action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), ssmTargetPrefix); action {
+	case "SendCommand":
+		resp, apiErr = s.sendCommand(body)
+	case "GetCommandInvocation":
+		resp, apiErr = s.getCommandInvocation(body)
+	case "CancelCommand":
+		resp, apiErr = s.cancelCommand(body)
+	default:
+		apiErr = &ssmError{Type: "UnknownOperationException", Message: "unsupported operation " + action}
+	}
+	if apiErr != nil {
+		writeSSMError(w, apiErr)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *SSM) sendCommand(body []byte) (interface{}, *ssmError) {
+	var in struct {
+		DocumentName   string
+		InstanceIds    []string
+		Parameters     map[string][]string
+		TimeoutSeconds int
+	}
+	if err := decodeSSM(body, &in); err != nil {
+		return nil, err
+	}
+	if in.DocumentName != shellDocument {
+		return nil, &ssmError{Type: "InvalidDocument", Message: "document " + in.DocumentName + " is not supported"}
+	}
+	if len(in.InstanceIds) != 1 {
+		return nil, &ssmError{Type: "ValidationException", Message: "exactly one instance ID is supported"}
+	}
+	commands := in.Parameters["commands"]
+	if len(commands) == 0 {
+		return nil, &ssmError{Type: "InvalidParameters", Message: "the commands parameter is required"}
+	}
+	instanceID := in.InstanceIds[0]
+	if s.ec2 != nil {
+		if inst, ok := s.ec2.Instance(instanceID); !ok || inst.State != "running" {
+			return nil, &ssmError{Type: "InvalidInstanceId", Message: "Instances [[" + instanceID + "]] not in a valid state for account " + accountID}
+		}
+	}
+
+	c := &Command{
+		ID:             fmt.Sprintf("%08d-0000-4000-8000-000000000000", len(s.commands)+1),
+		InstanceID:     instanceID,
+		Document:       in.DocumentName,
+		Commands:       commands,
+		TimeoutSeconds: in.TimeoutSeconds,
+		Status:         "Pending",
+	}
+	if timeout := in.Parameters["executionTimeout"]; len(timeout) > 0 {
+		c.ExecutionTimeout = timeout[0]
+	}
+	if s.handler != nil {
+		c.Result = s.handler(instanceID, strings.Join(commands, "\n"))
+	}
+	s.commands = append(s.commands, c)
+	return map[string]interface{}{
+		"Command": map[string]interface{}{
+			"CommandId":    c.ID,
+			"DocumentName": c.Document,
+			"InstanceIds":  []string{c.InstanceID},
+			"Status":       c.Status,
+		},
+	}, nil
+}
+
+func (s *SSM) getCommandInvocation(body []byte) (interface{}, *ssmError) {
+	var in struct {
+		CommandId  string
+		InstanceId string
+	}
+	if err := decodeSSM(body, &in); err != nil {
+		return nil, err
+	}
+	c := s.find(in.CommandId)
+	if c == nil || c.InstanceID != in.InstanceId {
+		return nil, &ssmError{Type: "InvocationDoesNotExist"}
+	}
+
+	c.polls++
+	switch {
+	case c.Status == "Cancelled":
+	case c.polls == 1:
+		return nil, &ssmError{Type: "InvocationDoesNotExist"}
+	case c.polls == 2, c.Result.Running:
+		c.Status = "InProgress"
+	case c.Result.ExitCode == 0:
+		c.Status = "Success"
+	default:
+		c.Status = "Failed"
+	}
+
+	out := map[string]interface{}{
+		"CommandId":    c.ID,
+		"InstanceId":   c.InstanceID,
+		"DocumentName": c.Document,
+		"PluginName":   "aws:runShellScript",
+		"Status":       c.Status,
+		"ResponseCode": -1,
+	}
+	if c.Status == "Success" || c.Status == "Failed" {
+		out["ResponseCode"] = c.Result.ExitCode
+		out["StandardOutputContent"] = c.Result.Stdout
+		out["StandardErrorContent"] = c.Result.Stderr
+	}
+	return out, nil
+}
+
+// cancelCommand cancels a command that has not finished. Like SSM, it
+// succeeds for commands that already have.
+func (s *SSM) cancelCommand(body []byte) (interface{}, *ssmError) {
+	var in struct {
+		CommandId string
+	}
+	if err := decodeSSM(body, &in); err != nil {
+		return nil, err
+	}
+	c := s.find(in.CommandId)
+	if c == nil {
+		return nil, &ssmError{Type: "InvalidCommandId"}
+	}
+	if c.Status == "Pending" || c.Status == "InProgress" {
+		c.Status = "Cancelled"
+	}
+	return struct{}{}, nil
+}
+
+func (s *SSM) find(id string) *Command {
+	for _, c := range s.commands {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func decodeSSM(body []byte, v interface{}) *ssmError {
+	if err := json.Unmarshal(body, v); err != nil {
+		return &ssmError{Type: "ValidationException", Message: err.Error()}
+	}
+	return nil
+}
+
+// ssmError is a JSON protocol error, identified by its __type
+type ssmError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message,omitempty"`
+}
+
+func writeSSMError(w http.ResponseWriter, err *ssmError) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(err)
+}
@@ -0,0 +1,132 @@
+package awstest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// STS serves GetCallerIdentity and AssumeRole. Requests signed with the
+// credentials of an assumed role are reported as that role; anything else
+// is a fixed IAM user in the stand-in's account.
+type STS struct {
+	mu       sync.Mutex
+	sessions map[string]AssumedRole
+	assumed  []AssumedRole
+}
+
+// AssumedRole records one AssumeRole call
+type AssumedRole struct {
+	RoleArn     string
+	SessionName string
+	ExternalID  string
+}
+
+// NewSTS returns an STS stand-in with no assumed roles
+func NewSTS() *STS {
+	return &STS{sessions: make(map[string]AssumedRole)}
+}
+
+// NewSTSServer starts an STS stand-in on a local port. Point a provider at
+// server.URL and call server.Close when done.
+func NewSTSServer() (*STS, *httptest.Server) {
+	s := NewSTS()
+	return s, httptest.NewServer(s)
+}
+
+// CallerArn is the ARN of the identity requests are made as unless they use
+// assumed role credentials
+const CallerArn = "arn:aws:iam::" + accountID + ":user/duet"
+
+// AssumedRoles returns the AssumeRole calls made so far, oldest first
+func (s *STS) AssumedRoles() []AssumedRole {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AssumedRole(nil), s.assumed...)
+}
+
+// credentialPattern extracts the access key from a SigV4 Authorization header
+var credentialPattern = regexp.MustCompile(`Credential=([^/]+)/`)
+
+func (s *STS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/xml")
+	switch action := r.Form.Get("Action"); action {
+	case "GetCallerIdentity":
+		identity := callerIdentityResponse{
+			Arn:       CallerArn,
+			UserID:    "AIDAAWSTESTUSER",
+			Account:   accountID,
+			RequestID: "awstest",
+		}
+		if m := credentialPattern.FindStringSubmatch(r.Header.Get("Authorization")); m != nil {
+			if session, ok := s.sessions[m[1]]; ok {
+				role := session.RoleArn[strings.LastIndex(session.RoleArn, "/")+1:]
+				identity.Arn = "arn:aws:sts::" + accountID + ":assumed-role/" + role + "/" + session.SessionName
+				identity.UserID = "AROAAWSTESTROLE:" + session.SessionName
+			}
+		}
+		_ = xml.NewEncoder(w).Encode(identity)
+
+	case "AssumeRole":
+		session := AssumedRole{
+			RoleArn:     r.Form.Get("RoleArn"),
+			SessionName: r.Form.Get("RoleSessionName"),
+			ExternalID:  r.Form.Get("ExternalId"),
+		}
+		if session.RoleArn == "" || session.SessionName == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = xml.NewEncoder(w).Encode(iamErrorResponse{
+				Error:     iamError{Type: "Sender", Code: "ValidationError", Message: "RoleArn and RoleSessionName are required"},
+				RequestID: "awstest",
+			})
+			return
+		}
+		s.assumed = append(s.assumed, session)
+		accessKey := fmt.Sprintf("ASIAAWSTEST%05d", len(s.assumed))
+		s.sessions[accessKey] = session
+		_ = xml.NewEncoder(w).Encode(assumeRoleResponse{
+			AccessKeyID:     accessKey,
+			SecretAccessKey: "awstest",
+			SessionToken:    "awstest",
+			Expiration:      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			RequestID:       "awstest",
+		})
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		_ = xml.NewEncoder(w).Encode(iamErrorResponse{
+			Error:     iamError{Type: "Sender", Code: "InvalidAction", Message: "unsupported action " + action},
+			RequestID: "awstest",
+		})
+	}
+}
+
+type callerIdentityResponse struct {
+	XMLName   xml.Name `xml:"GetCallerIdentityResponse"`
+	Arn       string   `xml:"GetCallerIdentityResult>Arn"`
+	UserID    string   `xml:"GetCallerIdentityResult>UserId"`
+	Account   string   `xml:"GetCallerIdentityResult>Account"`
+	RequestID string   `xml:"ResponseMetadata>RequestId"`
+}
+
+type assumeRoleResponse struct {
+	XMLName         xml.Name `xml:"AssumeRoleResponse"`
+	AccessKeyID     string   `xml:"AssumeRoleResult>Credentials>AccessKeyId"`
+	SecretAccessKey string   `xml:"AssumeRoleResult>Credentials>SecretAccessKey"`
+	SessionToken    string   `xml:"AssumeRoleResult>Credentials>SessionToken"`
+	Expiration      string   `xml:"AssumeRoleResult>Credentials>Expiration"`
+	RequestID       string   `xml:"ResponseMetadata>RequestId"`
+}
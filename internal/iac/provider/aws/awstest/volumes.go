@@ -0,0 +1,486 @@
+package awstest
+
+import (
+	"encoding/xml"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// defaultKMSKey stands in for the account's aws/ebs key, used when a volume
+// is encrypted without naming a key
+const defaultKMSKey = "arn:aws:kms:us-east-1:123456789012:key/aws-ebs"
+
+// Volume is a snapshot of an EBS volume held by the stand-in. It is creating
+// until first described. Attachments settle the same way: attaching and
+// detaching finish the next time the volume is described.
+type Volume struct {
+	CreateTime       time.Time
+	Tags             map[string]string
+	Attachment       *VolumeAttachment
+	ID               string
+	AvailabilityZone string
+	VolumeType       string
+	KmsKeyID         string
+	SnapshotID       string
+	State            string
+	clientToken      string
+	Size             int
+	Iops             int
+	Throughput       int
+	Encrypted        bool
+	// Describes left before the latest modification completes
+	modificationPolls int
+	modification      *volumeModification
+}
+
+// VolumeAttachment is the instance a volume is attached to
+type VolumeAttachment struct {
+	InstanceID string
+	Device     string
+	State      string
+}
+
+// volumeModification records the latest ModifyVolume call on a volume
+type volumeModification struct {
+	original xmlVolumeModification
+	state    string
+}
+
+// Volume returns a copy of the volume with the given ID
+func (e *EC2) Volume(id string) (Volume, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	v, ok := e.volumes[id]
+	if !ok {
+		return Volume{}, false
+	}
+	out := *v
+	out.Tags = copyTags(v.Tags)
+	if v.Attachment != nil {
+		attachment := *v.Attachment
+		out.Attachment = &attachment
+	}
+	out.modification = nil
+	return out, true
+}
+
+// serveVolumes handles the EBS volume actions
+func (e *EC2) serveVolumes(action string, form url.Values) (interface{}, *apiError) {
+	switch action {
+	case "CreateVolume":
+		return e.createVolume(form)
+	case "DescribeVolumes":
+		return e.describeVolumes(form)
+	case "ModifyVolume":
+		return e.modifyVolume(form)
+	case "DescribeVolumesModifications":
+		return e.describeVolumesModifications(form)
+	case "AttachVolume":
+		return e.attachVolume(form)
+	case "DetachVolume":
+		return e.detachVolume(form)
+	case "DeleteVolume":
+		return e.deleteVolume(form)
+	default:
+		return e.serveNetwork(action, form)
+	}
+}
+
+func (e *EC2) createVolume(form url.Values) (interface{}, *apiError) {
+	if token := form.Get("ClientToken"); token != "" {
+		for _, id := range sortedIDs(e.volumes) {
+			if v := e.volumes[id]; v.clientToken == token {
+				return createVolumeResponse{xmlVolume: v.xml()}, nil
+			}
+		}
+	}
+	if form.Get("AvailabilityZone") == "" {
+		return nil, &apiError{Code: "MissingParameter", Message: "The request must contain the parameter AvailabilityZone"}
+	}
+
+	v := &Volume{
+		ID:               e.newID("vol"),
+		AvailabilityZone: form.Get("AvailabilityZone"),
+		VolumeType:       form.Get("VolumeType"),
+		SnapshotID:       form.Get("SnapshotId"),
+		KmsKeyID:         form.Get("KmsKeyId"),
+		Encrypted:        form.Get("Encrypted") == "true",
+		State:            "creating",
+		CreateTime:       time.Now().UTC().Truncate(time.Second),
+		Tags:             tagSpecifications(form, "volume"),
+		clientToken:      form.Get("ClientToken"),
+	}
+	v.Size, _ = strconv.Atoi(form.Get("Size"))
+	v.Iops, _ = strconv.Atoi(form.Get("Iops"))
+	v.Throughput, _ = strconv.Atoi(form.Get("Throughput"))
+	if v.Size == 0 {
+		if v.SnapshotID == "" {
+			return nil, &apiError{Code: "MissingParameter", Message: "The request must contain the parameter size or snapshotId"}
+		}
+		v.Size = 8
+	}
+	if v.VolumeType == "" {
+		v.VolumeType = "gp2"
+	}
+	if v.KmsKeyID != "" && !v.Encrypted {
+		return nil, &apiError{Code: "InvalidParameterDependency", Message: "The parameter KmsKeyId requires the parameter Encrypted to be set"}
+	}
+	if v.Encrypted && v.KmsKeyID == "" {
+		v.KmsKeyID = defaultKMSKey
+	}
+	if err := v.applyDefaults(); err != nil {
+		return nil, err
+	}
+
+	e.volumes[v.ID] = v
+	return createVolumeResponse{xmlVolume: v.xml()}, nil
+}
+
+// applyDefaults fills in the performance settings AWS derives from the
+// volume type, and rejects settings the type does not support
+func (v *Volume) applyDefaults() *apiError {
+	switch v.VolumeType {
+	case "gp3":
+		if v.Iops == 0 {
+			v.Iops = 3000
+		}
+		if v.Throughput == 0 {
+			v.Throughput = 125
+		}
+		return nil
+	case "io1", "io2":
+		if v.Iops == 0 {
+			return &apiError{Code: "MissingParameter", Message: "The parameter iops must be specified for " + v.VolumeType + " volumes"}
+		}
+	case "gp2":
+		v.Iops = max(100, 3*v.Size)
+	case "st1", "sc1", "standard":
+		v.Iops = 0
+	default:
+		return &apiError{Code: "InvalidParameterValue", Message: "Value (" + v.VolumeType + ") for parameter volumeType is invalid"}
+	}
+	if v.Throughput != 0 {
+		return &apiError{Code: "InvalidParameterCombination", Message: "The parameter throughput is not supported for " + v.VolumeType + " volumes"}
+	}
+	return nil
+}
+
+func (e *EC2) lookupVolume(id string) (*Volume, *apiError) {
+	v, ok := e.volumes[id]
+	if !ok {
+		return nil, &apiError{Code: "InvalidVolume.NotFound", Message: "The volume '" + id + "' does not exist."}
+	}
+	return v, nil
+}
+
+func (e *EC2) describeVolumes(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "VolumeId")
+	for _, id := range ids {
+		if _, err := e.lookupVolume(id); err != nil {
+			return nil, err
+		}
+	}
+	filters := parseFilters(form)
+
+	resp := describeVolumesResponse{}
+	for _, id := range sortedIDs(e.volumes) {
+		v := e.volumes[id]
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		v.settle()
+		if !matchFilters(filters, v.Tags, func(name string) ([]string, bool) {
+			switch name {
+			case "status":
+				return []string{v.State}, true
+			case "availability-zone":
+				return []string{v.AvailabilityZone}, true
+			case "attachment.instance-id":
+				if v.Attachment == nil {
+					return nil, true
+				}
+				return []string{v.Attachment.InstanceID}, true
+			}
+			return nil, false
+		}) {
+			continue
+		}
+		resp.Volumes = append(resp.Volumes, v.xml())
+	}
+	return resp, nil
+}
+
+// settle moves a volume, its attachment and its latest modification one
+// step along, as if time had passed since it was last looked at
+func (v *Volume) settle() {
+	if v.State == "creating" {
+		v.State = "available"
+	}
+	if a := v.Attachment; a != nil {
+		switch a.State {
+		case "attaching":
+			a.State = "attached"
+		case "detaching":
+			v.Attachment = nil
+			v.State = "available"
+		}
+	}
+	if m := v.modification; m != nil {
+		switch {
+		case v.modificationPolls > 0:
+			v.modificationPolls--
+		case m.state == "modifying":
+			m.state = "optimizing"
+		case m.state == "optimizing":
+			m.state = "completed"
+		}
+	}
+}
+
+func (e *EC2) modifyVolume(form url.Values) (interface{}, *apiError) {
+	v, err := e.lookupVolume(form.Get("VolumeId"))
+	if err != nil {
+		return nil, err
+	}
+	if m := v.modification; m != nil && m.state == "modifying" {
+		return nil, &apiError{Code: "IncorrectModificationState", Message: "Volume " + v.ID + " is already being modified"}
+	}
+
+	original := xmlVolumeModification{
+		VolumeID:           v.ID,
+		OriginalSize:       v.Size,
+		OriginalIops:       v.Iops,
+		OriginalThroughput: v.Throughput,
+		OriginalVolumeType: v.VolumeType,
+	}
+	updated := *v
+	if size := form.Get("Size"); size != "" {
+		updated.Size, _ = strconv.Atoi(size)
+		if updated.Size < v.Size {
+			return nil, &apiError{Code: "InvalidParameterValue", Message: "New size cannot be smaller than existing size"}
+		}
+	}
+	if t := form.Get("VolumeType"); t != "" && t != v.VolumeType {
+		updated.VolumeType = t
+		updated.Iops, updated.Throughput = 0, 0
+	}
+	if iops := form.Get("Iops"); iops != "" {
+		updated.Iops, _ = strconv.Atoi(iops)
+	}
+	if throughput := form.Get("Throughput"); throughput != "" {
+		updated.Throughput, _ = strconv.Atoi(throughput)
+	}
+	if err := updated.applyDefaults(); err != nil {
+		return nil, err
+	}
+
+	v.Size, v.VolumeType, v.Iops, v.Throughput = updated.Size, updated.VolumeType, updated.Iops, updated.Throughput
+	v.modification = &volumeModification{original: original, state: "modifying"}
+	v.modificationPolls = 1
+	return modifyVolumeResponse{Modification: v.modificationXML()}, nil
+}
+
+func (e *EC2) describeVolumesModifications(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "VolumeId")
+	resp := describeVolumesModificationsResponse{}
+	for _, id := range ids {
+		v, err := e.lookupVolume(id)
+		if err != nil {
+			return nil, err
+		}
+		v.settle()
+		if v.modification != nil {
+			resp.Modifications = append(resp.Modifications, v.modificationXML())
+		}
+	}
+	return resp, nil
+}
+
+func (e *EC2) attachVolume(form url.Values) (interface{}, *apiError) {
+	v, err := e.lookupVolume(form.Get("VolumeId"))
+	if err != nil {
+		return nil, err
+	}
+	inst, err := e.lookup(form.Get("InstanceId"))
+	if err != nil {
+		return nil, err
+	}
+	device := form.Get("Device")
+	if device == "" {
+		return nil, &apiError{Code: "MissingParameter", Message: "The request must contain the parameter device"}
+	}
+	if v.Attachment != nil {
+		return nil, &apiError{Code: "VolumeInUse", Message: "vol '" + v.ID + "' is already attached to an instance"}
+	}
+	if v.State != "available" {
+		return nil, &apiError{Code: "IncorrectState", Message: "The volume '" + v.ID + "' is not 'available'"}
+	}
+	if inst.State == "terminated" || inst.State == "shutting-down" {
+		return nil, &apiError{Code: "IncorrectState", Message: "The instance '" + inst.ID + "' is not 'running' or 'stopped'"}
+	}
+	if zone := e.zoneOf(inst); zone != v.AvailabilityZone {
+		return nil, &apiError{Code: "InvalidVolume.ZoneMismatch", Message: "The volume '" + v.ID + "' is not in the same availability zone as instance '" + inst.ID + "'"}
+	}
+	for _, other := range e.volumes {
+		if a := other.Attachment; a != nil && a.InstanceID == inst.ID && a.Device == device {
+			return nil, &apiError{Code: "InvalidParameterValue", Message: "Attachment point " + device + " is already in use"}
+		}
+	}
+
+	v.Attachment = &VolumeAttachment{InstanceID: inst.ID, Device: device, State: "attaching"}
+	v.State = "in-use"
+	return attachmentResponse{XMLName: xml.Name{Local: "AttachVolumeResponse"}, xmlVolumeAttachment: v.attachmentXML()}, nil
+}
+
+func (e *EC2) detachVolume(form url.Values) (interface{}, *apiError) {
+	v, err := e.lookupVolume(form.Get("VolumeId"))
+	if err != nil {
+		return nil, err
+	}
+	a := v.Attachment
+	if a == nil || a.State == "detaching" {
+		return nil, &apiError{Code: "IncorrectState", Message: "Volume '" + v.ID + "' is in the 'available' state."}
+	}
+	if id := form.Get("InstanceId"); id != "" && id != a.InstanceID {
+		return nil, &apiError{Code: "InvalidAttachment.NotFound", Message: "Volume '" + v.ID + "' is not attached to instance '" + id + "'"}
+	}
+	a.State = "detaching"
+	return attachmentResponse{XMLName: xml.Name{Local: "DetachVolumeResponse"}, xmlVolumeAttachment: v.attachmentXML()}, nil
+}
+
+func (e *EC2) deleteVolume(form url.Values) (interface{}, *apiError) {
+	v, err := e.lookupVolume(form.Get("VolumeId"))
+	if err != nil {
+		return nil, err
+	}
+	if v.Attachment != nil {
+		return nil, &apiError{Code: "VolumeInUse", Message: "Volume " + v.ID + " is currently attached to " + v.Attachment.InstanceID}
+	}
+	delete(e.volumes, v.ID)
+	return booleanResponse{XMLName: xml.Name{Local: "DeleteVolumeResponse"}, Return: true}, nil
+}
+
+// detachAll releases the volumes attached to an instance as it terminates
+func (e *EC2) detachAll(instanceID string) {
+	for _, v := range e.volumes {
+		if v.Attachment != nil && v.Attachment.InstanceID == instanceID {
+			v.Attachment = nil
+			v.State = "available"
+		}
+	}
+}
+
+// zoneOf returns the availability zone of an instance: its subnet's when the
+// subnet is known, otherwise the stand-in's default zone
+func (e *EC2) zoneOf(inst *Instance) string {
+	if subnet, ok := e.subnets[inst.SubnetID]; ok {
+		return subnet.AvailabilityZone
+	}
+	return "us-east-1a"
+}
+
+func (v *Volume) xml() xmlVolume {
+	out := xmlVolume{
+		VolumeID:         v.ID,
+		Size:             v.Size,
+		SnapshotID:       v.SnapshotID,
+		AvailabilityZone: v.AvailabilityZone,
+		Status:           v.State,
+		CreateTime:       v.CreateTime.Format(time.RFC3339),
+		VolumeType:       v.VolumeType,
+		Encrypted:        v.Encrypted,
+		KmsKeyID:         v.KmsKeyID,
+		Tags:             xmlTags(v.Tags),
+	}
+	if v.Iops != 0 {
+		out.Iops = &v.Iops
+	}
+	if v.Throughput != 0 {
+		out.Throughput = &v.Throughput
+	}
+	if v.Attachment != nil {
+		out.Attachments = []xmlVolumeAttachment{v.attachmentXML()}
+	}
+	return out
+}
+
+func (v *Volume) attachmentXML() xmlVolumeAttachment {
+	return xmlVolumeAttachment{
+		VolumeID:   v.ID,
+		InstanceID: v.Attachment.InstanceID,
+		Device:     v.Attachment.Device,
+		Status:     v.Attachment.State,
+	}
+}
+
+func (v *Volume) modificationXML() xmlVolumeModification {
+	out := v.modification.original
+	out.ModificationState = v.modification.state
+	out.TargetSize = v.Size
+	out.TargetIops = v.Iops
+	out.TargetThroughput = v.Throughput
+	out.TargetVolumeType = v.VolumeType
+	return out
+}
+
+type xmlVolumeAttachment struct {
+	VolumeID   string `xml:"volumeId"`
+	InstanceID string `xml:"instanceId"`
+	Device     string `xml:"device"`
+	Status     string `xml:"status"`
+}
+
+type xmlVolume struct {
+	Iops             *int                  `xml:"iops,omitempty"`
+	Throughput       *int                  `xml:"throughput,omitempty"`
+	VolumeID         string                `xml:"volumeId"`
+	SnapshotID       string                `xml:"snapshotId"`
+	AvailabilityZone string                `xml:"availabilityZone"`
+	Status           string                `xml:"status"`
+	CreateTime       string                `xml:"createTime"`
+	VolumeType       string                `xml:"volumeType"`
+	KmsKeyID         string                `xml:"kmsKeyId,omitempty"`
+	Attachments      []xmlVolumeAttachment `xml:"attachmentSet>item"`
+	Tags             []xmlTag              `xml:"tagSet>item"`
+	Size             int                   `xml:"size"`
+	Encrypted        bool                  `xml:"encrypted"`
+}
+
+type xmlVolumeModification struct {
+	VolumeID           string `xml:"volumeId"`
+	ModificationState  string `xml:"modificationState"`
+	OriginalVolumeType string `xml:"originalVolumeType"`
+	TargetVolumeType   string `xml:"targetVolumeType"`
+	OriginalSize       int    `xml:"originalSize"`
+	TargetSize         int    `xml:"targetSize"`
+	OriginalIops       int    `xml:"originalIops"`
+	TargetIops         int    `xml:"targetIops"`
+	OriginalThroughput int    `xml:"originalThroughput"`
+	TargetThroughput   int    `xml:"targetThroughput"`
+}
+
+type createVolumeResponse struct {
+	XMLName xml.Name `xml:"CreateVolumeResponse"`
+	xmlVolume
+}
+
+type describeVolumesResponse struct {
+	XMLName xml.Name    `xml:"DescribeVolumesResponse"`
+	Volumes []xmlVolume `xml:"volumeSet>item"`
+}
+
+type modifyVolumeResponse struct {
+	XMLName      xml.Name              `xml:"ModifyVolumeResponse"`
+	Modification xmlVolumeModification `xml:"volumeModification"`
+}
+
+type describeVolumesModificationsResponse struct {
+	XMLName       xml.Name                `xml:"DescribeVolumesModificationsResponse"`
+	Modifications []xmlVolumeModification `xml:"volumeModificationSet>item"`
+}
+
+type attachmentResponse struct {
+	XMLName xml.Name
+	xmlVolumeAttachment
+}
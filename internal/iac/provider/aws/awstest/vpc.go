@@ -0,0 +1,1029 @@
+package awstest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// VPC is a snapshot of a VPC held by the stand-in
+type VPC struct {
+	Tags               map[string]string
+	ID                 string
+	CidrBlock          string
+	EnableDNSSupport   bool
+	EnableDNSHostnames bool
+}
+
+// Subnet is a snapshot of a subnet held by the stand-in
+type Subnet struct {
+	Tags                map[string]string
+	ID                  string
+	VpcID               string
+	CidrBlock           string
+	AvailabilityZone    string
+	MapPublicIPOnLaunch bool
+}
+
+// InternetGateway is a snapshot of an internet gateway held by the stand-in.
+// VpcID is empty while it is detached.
+type InternetGateway struct {
+	Tags  map[string]string
+	ID    string
+	VpcID string
+}
+
+// Address is a snapshot of an Elastic IP allocation held by the stand-in
+type Address struct {
+	Tags         map[string]string
+	AllocationID string
+	PublicIP     string
+}
+
+// NatGateway is a snapshot of a NAT gateway held by the stand-in. It is
+// pending until first described and deleted the first time it is described
+// after DeleteNatGateway.
+type NatGateway struct {
+	CreateTime       time.Time
+	Tags             map[string]string
+	ID               string
+	SubnetID         string
+	VpcID            string
+	AllocationID     string
+	ConnectivityType string
+	State            string
+	PrivateIP        string
+	PublicIP         string
+	clientToken      string
+}
+
+// serveNetwork handles the VPC, route table and security group actions
+func (e *EC2) serveNetwork(action string, form url.Values) (interface{}, *apiError) {
+	switch action {
+	case "CreateVpc":
+		return e.createVpc(form)
+	case "DescribeVpcs":
+		return e.describeVpcs(form)
+	case "ModifyVpcAttribute":
+		return e.modifyVpcAttribute(form)
+	case "DescribeVpcAttribute":
+		return e.describeVpcAttribute(form)
+	case "DeleteVpc":
+		return e.deleteVpc(form)
+	case "CreateSubnet":
+		return e.createSubnet(form)
+	case "DescribeSubnets":
+		return e.describeSubnets(form)
+	case "ModifySubnetAttribute":
+		return e.modifySubnetAttribute(form)
+	case "DeleteSubnet":
+		return e.deleteSubnet(form)
+	case "CreateInternetGateway":
+		return e.createInternetGateway(form)
+	case "AttachInternetGateway":
+		return e.attachInternetGateway(form)
+	case "DetachInternetGateway":
+		return e.detachInternetGateway(form)
+	case "DescribeInternetGateways":
+		return e.describeInternetGateways(form)
+	case "DeleteInternetGateway":
+		return e.deleteInternetGateway(form)
+	case "AllocateAddress":
+		return e.allocateAddress(form)
+	case "DescribeAddresses":
+		return e.describeAddresses(form)
+	case "ReleaseAddress":
+		return e.releaseAddress(form)
+	case "CreateNatGateway":
+		return e.createNatGateway(form)
+	case "DescribeNatGateways":
+		return e.describeNatGateways(form)
+	case "DeleteNatGateway":
+		return e.deleteNatGateway(form)
+	case "CreateRouteTable":
+		return e.createRouteTable(form)
+	case "DescribeRouteTables":
+		return e.describeRouteTables(form)
+	case "CreateRoute":
+		return e.createRoute(form)
+	case "ReplaceRoute":
+		return e.replaceRoute(form)
+	case "DeleteRoute":
+		return e.deleteRoute(form)
+	case "AssociateRouteTable":
+		return e.associateRouteTable(form)
+	case "DisassociateRouteTable":
+		return e.disassociateRouteTable(form)
+	case "DeleteRouteTable":
+		return e.deleteRouteTable(form)
+	case "CreateSecurityGroup":
+		return e.createSecurityGroup(form)
+	case "DescribeSecurityGroups":
+		return e.describeSecurityGroups(form)
+	case "AuthorizeSecurityGroupIngress":
+		return e.authorize(form, false)
+	case "AuthorizeSecurityGroupEgress":
+		return e.authorize(form, true)
+	case "RevokeSecurityGroupIngress":
+		return e.revoke(form, false)
+	case "RevokeSecurityGroupEgress":
+		return e.revoke(form, true)
+	case "UpdateSecurityGroupRuleDescriptionsIngress":
+		return e.updateRuleDescriptions(form, false)
+	case "UpdateSecurityGroupRuleDescriptionsEgress":
+		return e.updateRuleDescriptions(form, true)
+	case "DeleteSecurityGroup":
+		return e.deleteSecurityGroup(form)
+	default:
+		return e.serveImages(action, form)
+	}
+}
+
+// VPC returns a copy of the VPC with the given ID
+func (e *EC2) VPC(id string) (VPC, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	v, ok := e.vpcs[id]
+	if !ok {
+		return VPC{}, false
+	}
+	out := *v
+	out.Tags = copyTags(v.Tags)
+	return out, true
+}
+
+// Subnet returns a copy of the subnet with the given ID
+func (e *EC2) Subnet(id string) (Subnet, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s, ok := e.subnets[id]
+	if !ok {
+		return Subnet{}, false
+	}
+	out := *s
+	out.Tags = copyTags(s.Tags)
+	return out, true
+}
+
+// InternetGateway returns a copy of the internet gateway with the given ID
+func (e *EC2) InternetGateway(id string) (InternetGateway, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g, ok := e.internetGateways[id]
+	if !ok {
+		return InternetGateway{}, false
+	}
+	out := *g
+	out.Tags = copyTags(g.Tags)
+	return out, true
+}
+
+// Address returns a copy of the Elastic IP with the given allocation ID
+func (e *EC2) Address(id string) (Address, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	a, ok := e.addresses[id]
+	if !ok {
+		return Address{}, false
+	}
+	out := *a
+	out.Tags = copyTags(a.Tags)
+	return out, true
+}
+
+// NatGateway returns a copy of the NAT gateway with the given ID
+func (e *EC2) NatGateway(id string) (NatGateway, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n, ok := e.natGateways[id]
+	if !ok {
+		return NatGateway{}, false
+	}
+	out := *n
+	out.Tags = copyTags(n.Tags)
+	return out, true
+}
+
+// overlaps reports whether two CIDR blocks share any address. Blocks that
+// do not parse only overlap if they are equal.
+func overlaps(a, b string) bool {
+	_, na, errA := net.ParseCIDR(a)
+	_, nb, errB := net.ParseCIDR(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return na.Contains(nb.IP) || nb.Contains(na.IP)
+}
+
+func (e *EC2) newID(prefix string) string {
+	e.nextID++
+	return fmt.Sprintf("%s-%017x", prefix, e.nextID)
+}
+
+func (e *EC2) createVpc(form url.Values) (interface{}, *apiError) {
+	if form.Get("CidrBlock") == "" {
+		return nil, &apiError{Code: "MissingParameter", Message: "The request must contain the parameter CidrBlock"}
+	}
+	vpc := &VPC{
+		ID:               e.newID("vpc"),
+		CidrBlock:        form.Get("CidrBlock"),
+		EnableDNSSupport: true,
+		Tags:             tagSpecifications(form, "vpc"),
+	}
+	e.vpcs[vpc.ID] = vpc
+
+	// Every VPC comes with a main route table and a default security group
+	main := &RouteTable{
+		ID:     e.newID("rtb"),
+		VpcID:  vpc.ID,
+		Routes: []Route{{DestinationCidrBlock: vpc.CidrBlock, GatewayID: "local", Origin: "CreateRouteTable"}},
+		Tags:   make(map[string]string),
+	}
+	main.Associations = []RouteTableAssociation{{ID: e.newID("rtbassoc"), Main: true}}
+	e.routeTables[main.ID] = main
+
+	group := &SecurityGroup{
+		ID:          e.newID("sg"),
+		Name:        "default",
+		Description: "default VPC security group",
+		VpcID:       vpc.ID,
+		Egress:      []Permission{{Protocol: "-1", CidrIP: "0.0.0.0/0"}},
+		Tags:        make(map[string]string),
+	}
+	group.Ingress = []Permission{{Protocol: "-1", GroupID: group.ID}}
+	e.securityGroups[group.ID] = group
+
+	return createVpcResponse{Vpc: vpc.xml()}, nil
+}
+
+func (e *EC2) lookupVpc(id string) (*VPC, *apiError) {
+	vpc, ok := e.vpcs[id]
+	if !ok {
+		return nil, &apiError{Code: "InvalidVpcID.NotFound", Message: "The vpc ID '" + id + "' does not exist"}
+	}
+	return vpc, nil
+}
+
+func (e *EC2) describeVpcs(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "VpcId")
+	for _, id := range ids {
+		if _, err := e.lookupVpc(id); err != nil {
+			return nil, err
+		}
+	}
+	filters := parseFilters(form)
+
+	resp := describeVpcsResponse{}
+	for _, id := range sortedIDs(e.vpcs) {
+		vpc := e.vpcs[id]
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		if !matchFilters(filters, vpc.Tags, func(name string) ([]string, bool) {
+			switch name {
+			case "vpc-id":
+				return []string{vpc.ID}, true
+			case "cidr-block":
+				return []string{vpc.CidrBlock}, true
+			case "is-default":
+				// The stand-in has no default VPC
+				return []string{"false"}, true
+			}
+			return nil, false
+		}) {
+			continue
+		}
+		resp.Vpcs = append(resp.Vpcs, vpc.xml())
+	}
+	return resp, nil
+}
+
+func (e *EC2) modifyVpcAttribute(form url.Values) (interface{}, *apiError) {
+	vpc, err := e.lookupVpc(form.Get("VpcId"))
+	if err != nil {
+		return nil, err
+	}
+	if form.Has("EnableDnsSupport.Value") {
+		vpc.EnableDNSSupport = form.Get("EnableDnsSupport.Value") == "true"
+	}
+	if form.Has("EnableDnsHostnames.Value") {
+		vpc.EnableDNSHostnames = form.Get("EnableDnsHostnames.Value") == "true"
+	}
+	return booleanResponse{XMLName: xml.Name{Local: "ModifyVpcAttributeResponse"}, Return: true}, nil
+}
+
+func (e *EC2) describeVpcAttribute(form url.Values) (interface{}, *apiError) {
+	vpc, err := e.lookupVpc(form.Get("VpcId"))
+	if err != nil {
+		return nil, err
+	}
+	resp := describeVpcAttributeResponse{VpcID: vpc.ID}
+	switch form.Get("Attribute") {
+	case "enableDnsSupport":
+		resp.EnableDNSSupport = &xmlBoolValue{Value: vpc.EnableDNSSupport}
+	case "enableDnsHostnames":
+		resp.EnableDNSHostnames = &xmlBoolValue{Value: vpc.EnableDNSHostnames}
+	default:
+		return nil, &apiError{Code: "InvalidParameterValue", Message: "unsupported attribute " + form.Get("Attribute")}
+	}
+	return resp, nil
+}
+
+func (e *EC2) deleteVpc(form url.Values) (interface{}, *apiError) {
+	vpc, err := e.lookupVpc(form.Get("VpcId"))
+	if err != nil {
+		return nil, err
+	}
+
+	inUse := func(what, id string) *apiError {
+		return &apiError{Code: "DependencyViolation", Message: fmt.Sprintf("The vpc '%s' has dependencies and cannot be deleted: %s %s", vpc.ID, what, id)}
+	}
+	for id, s := range e.subnets {
+		if s.VpcID == vpc.ID {
+			return nil, inUse("subnet", id)
+		}
+	}
+	for id, g := range e.internetGateways {
+		if g.VpcID == vpc.ID {
+			return nil, inUse("internet gateway", id)
+		}
+	}
+	for id, rt := range e.routeTables {
+		if rt.VpcID == vpc.ID && !rt.isMain() {
+			return nil, inUse("route table", id)
+		}
+	}
+	for id, g := range e.securityGroups {
+		if g.VpcID == vpc.ID && g.Name != "default" {
+			return nil, inUse("security group", id)
+		}
+	}
+
+	for id, rt := range e.routeTables {
+		if rt.VpcID == vpc.ID {
+			delete(e.routeTables, id)
+		}
+	}
+	for id, g := range e.securityGroups {
+		if g.VpcID == vpc.ID {
+			delete(e.securityGroups, id)
+		}
+	}
+	delete(e.vpcs, vpc.ID)
+	return booleanResponse{XMLName: xml.Name{Local: "DeleteVpcResponse"}, Return: true}, nil
+}
+
+func (e *EC2) createSubnet(form url.Values) (interface{}, *apiError) {
+	vpc, err := e.lookupVpc(form.Get("VpcId"))
+	if err != nil {
+		return nil, err
+	}
+	if form.Get("CidrBlock") == "" {
+		return nil, &apiError{Code: "MissingParameter", Message: "The request must contain the parameter CidrBlock"}
+	}
+	for _, s := range e.subnets {
+		if s.VpcID == vpc.ID && overlaps(s.CidrBlock, form.Get("CidrBlock")) {
+			return nil, &apiError{Code: "InvalidSubnet.Conflict", Message: "The CIDR '" + s.CidrBlock + "' conflicts with another subnet"}
+		}
+	}
+
+	subnet := &Subnet{
+		ID:               e.newID("subnet"),
+		VpcID:            vpc.ID,
+		CidrBlock:        form.Get("CidrBlock"),
+		AvailabilityZone: form.Get("AvailabilityZone"),
+		Tags:             tagSpecifications(form, "subnet"),
+	}
+	if subnet.AvailabilityZone == "" {
+		subnet.AvailabilityZone = "us-east-1a"
+	}
+	e.subnets[subnet.ID] = subnet
+	return createSubnetResponse{Subnet: subnet.xml()}, nil
+}
+
+func (e *EC2) lookupSubnet(id string) (*Subnet, *apiError) {
+	subnet, ok := e.subnets[id]
+	if !ok {
+		return nil, &apiError{Code: "InvalidSubnetID.NotFound", Message: "The subnet ID '" + id + "' does not exist"}
+	}
+	return subnet, nil
+}
+
+func (e *EC2) describeSubnets(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "SubnetId")
+	for _, id := range ids {
+		if _, err := e.lookupSubnet(id); err != nil {
+			return nil, err
+		}
+	}
+	filters := parseFilters(form)
+
+	resp := describeSubnetsResponse{}
+	for _, id := range sortedIDs(e.subnets) {
+		subnet := e.subnets[id]
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		if !matchFilters(filters, subnet.Tags, func(name string) ([]string, bool) {
+			switch name {
+			case "vpc-id":
+				return []string{subnet.VpcID}, true
+			case "availability-zone":
+				return []string{subnet.AvailabilityZone}, true
+			case "cidr-block":
+				return []string{subnet.CidrBlock}, true
+			}
+			return nil, false
+		}) {
+			continue
+		}
+		resp.Subnets = append(resp.Subnets, subnet.xml())
+	}
+	return resp, nil
+}
+
+func (e *EC2) modifySubnetAttribute(form url.Values) (interface{}, *apiError) {
+	subnet, err := e.lookupSubnet(form.Get("SubnetId"))
+	if err != nil {
+		return nil, err
+	}
+	if form.Has("MapPublicIpOnLaunch.Value") {
+		subnet.MapPublicIPOnLaunch = form.Get("MapPublicIpOnLaunch.Value") == "true"
+	}
+	return booleanResponse{XMLName: xml.Name{Local: "ModifySubnetAttributeResponse"}, Return: true}, nil
+}
+
+func (e *EC2) deleteSubnet(form url.Values) (interface{}, *apiError) {
+	subnet, err := e.lookupSubnet(form.Get("SubnetId"))
+	if err != nil {
+		return nil, err
+	}
+	for _, inst := range e.instances {
+		if inst.SubnetID == subnet.ID && inst.State != "terminated" {
+			return nil, &apiError{Code: "DependencyViolation", Message: "The subnet '" + subnet.ID + "' has dependencies and cannot be deleted: instance " + inst.ID}
+		}
+	}
+	for _, nat := range e.natGateways {
+		if nat.SubnetID == subnet.ID && nat.State != "deleted" {
+			return nil, &apiError{Code: "DependencyViolation", Message: "The subnet '" + subnet.ID + "' has dependencies and cannot be deleted: NAT gateway " + nat.ID}
+		}
+	}
+
+	// Explicit route table associations go with the subnet
+	for _, rt := range e.routeTables {
+		kept := rt.Associations[:0]
+		for _, a := range rt.Associations {
+			if a.SubnetID != subnet.ID {
+				kept = append(kept, a)
+			}
+		}
+		rt.Associations = kept
+	}
+	delete(e.subnets, subnet.ID)
+	return booleanResponse{XMLName: xml.Name{Local: "DeleteSubnetResponse"}, Return: true}, nil
+}
+
+func (e *EC2) createInternetGateway(form url.Values) (interface{}, *apiError) {
+	gateway := &InternetGateway{
+		ID:   e.newID("igw"),
+		Tags: tagSpecifications(form, "internet-gateway"),
+	}
+	e.internetGateways[gateway.ID] = gateway
+	return createInternetGatewayResponse{InternetGateway: gateway.xml()}, nil
+}
+
+func (e *EC2) lookupInternetGateway(id string) (*InternetGateway, *apiError) {
+	gateway, ok := e.internetGateways[id]
+	if !ok {
+		return nil, &apiError{Code: "InvalidInternetGatewayID.NotFound", Message: "The internetGateway ID '" + id + "' does not exist"}
+	}
+	return gateway, nil
+}
+
+func (e *EC2) attachInternetGateway(form url.Values) (interface{}, *apiError) {
+	gateway, err := e.lookupInternetGateway(form.Get("InternetGatewayId"))
+	if err != nil {
+		return nil, err
+	}
+	vpc, err := e.lookupVpc(form.Get("VpcId"))
+	if err != nil {
+		return nil, err
+	}
+	if gateway.VpcID != "" {
+		return nil, &apiError{Code: "Resource.AlreadyAssociated", Message: "resource " + gateway.ID + " is already attached to network " + gateway.VpcID}
+	}
+	for _, g := range e.internetGateways {
+		if g.VpcID == vpc.ID {
+			return nil, &apiError{Code: "InvalidParameterValue", Message: "Network " + vpc.ID + " already has an internet gateway attached"}
+		}
+	}
+	gateway.VpcID = vpc.ID
+	return booleanResponse{XMLName: xml.Name{Local: "AttachInternetGatewayResponse"}, Return: true}, nil
+}
+
+func (e *EC2) detachInternetGateway(form url.Values) (interface{}, *apiError) {
+	gateway, err := e.lookupInternetGateway(form.Get("InternetGatewayId"))
+	if err != nil {
+		return nil, err
+	}
+	if gateway.VpcID == "" || gateway.VpcID != form.Get("VpcId") {
+		return nil, &apiError{Code: "Gateway.NotAttached", Message: "resource " + gateway.ID + " is not attached to network " + form.Get("VpcId")}
+	}
+	gateway.VpcID = ""
+	return booleanResponse{XMLName: xml.Name{Local: "DetachInternetGatewayResponse"}, Return: true}, nil
+}
+
+func (e *EC2) describeInternetGateways(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "InternetGatewayId")
+	for _, id := range ids {
+		if _, err := e.lookupInternetGateway(id); err != nil {
+			return nil, err
+		}
+	}
+	filters := parseFilters(form)
+
+	resp := describeInternetGatewaysResponse{}
+	for _, id := range sortedIDs(e.internetGateways) {
+		gateway := e.internetGateways[id]
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		if !matchFilters(filters, gateway.Tags, func(name string) ([]string, bool) {
+			if name == "attachment.vpc-id" {
+				return []string{gateway.VpcID}, true
+			}
+			return nil, false
+		}) {
+			continue
+		}
+		resp.InternetGateways = append(resp.InternetGateways, gateway.xml())
+	}
+	return resp, nil
+}
+
+func (e *EC2) deleteInternetGateway(form url.Values) (interface{}, *apiError) {
+	gateway, err := e.lookupInternetGateway(form.Get("InternetGatewayId"))
+	if err != nil {
+		return nil, err
+	}
+	if gateway.VpcID != "" {
+		return nil, &apiError{Code: "DependencyViolation", Message: "The internetGateway '" + gateway.ID + "' has dependencies and cannot be deleted"}
+	}
+	delete(e.internetGateways, gateway.ID)
+	return booleanResponse{XMLName: xml.Name{Local: "DeleteInternetGatewayResponse"}, Return: true}, nil
+}
+
+func (e *EC2) allocateAddress(form url.Values) (interface{}, *apiError) {
+	address := &Address{
+		AllocationID: e.newID("eipalloc"),
+		Tags:         tagSpecifications(form, "elastic-ip"),
+	}
+	address.PublicIP = fmt.Sprintf("198.51.100.%d", e.nextID%250+1)
+	e.addresses[address.AllocationID] = address
+	return allocateAddressResponse{AllocationID: address.AllocationID, PublicIP: address.PublicIP, Domain: "vpc"}, nil
+}
+
+func (e *EC2) lookupAddress(id string) (*Address, *apiError) {
+	address, ok := e.addresses[id]
+	if !ok {
+		return nil, &apiError{Code: "InvalidAllocationID.NotFound", Message: "The allocation ID '" + id + "' does not exist"}
+	}
+	return address, nil
+}
+
+func (e *EC2) describeAddresses(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "AllocationId")
+	for _, id := range ids {
+		if _, err := e.lookupAddress(id); err != nil {
+			return nil, err
+		}
+	}
+	filters := parseFilters(form)
+
+	resp := describeAddressesResponse{}
+	for _, id := range sortedIDs(e.addresses) {
+		address := e.addresses[id]
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		if !matchFilters(filters, address.Tags, func(string) ([]string, bool) { return nil, false }) {
+			continue
+		}
+		resp.Addresses = append(resp.Addresses, xmlAddress{
+			AllocationID: address.AllocationID,
+			PublicIP:     address.PublicIP,
+			Domain:       "vpc",
+			Tags:         xmlTags(address.Tags),
+		})
+	}
+	return resp, nil
+}
+
+func (e *EC2) releaseAddress(form url.Values) (interface{}, *apiError) {
+	address, err := e.lookupAddress(form.Get("AllocationId"))
+	if err != nil {
+		return nil, err
+	}
+	for _, nat := range e.natGateways {
+		if nat.AllocationID == address.AllocationID && nat.State != "deleted" {
+			return nil, &apiError{Code: "InvalidIPAddress.InUse", Message: "Address " + address.PublicIP + " is in use by " + nat.ID}
+		}
+	}
+	delete(e.addresses, address.AllocationID)
+	return booleanResponse{XMLName: xml.Name{Local: "ReleaseAddressResponse"}, Return: true}, nil
+}
+
+func (e *EC2) createNatGateway(form url.Values) (interface{}, *apiError) {
+	token := form.Get("ClientToken")
+	if token != "" {
+		for _, nat := range e.natGateways {
+			if nat.clientToken == token {
+				return createNatGatewayResponse{ClientToken: token, NatGateway: nat.xml()}, nil
+			}
+		}
+	}
+
+	subnet, err := e.lookupSubnet(form.Get("SubnetId"))
+	if err != nil {
+		return nil, err
+	}
+	nat := &NatGateway{
+		ID:               e.newID("nat"),
+		SubnetID:         subnet.ID,
+		VpcID:            subnet.VpcID,
+		ConnectivityType: form.Get("ConnectivityType"),
+		State:            "pending",
+		PrivateIP:        fmt.Sprintf("10.0.%d.%d", e.nextID/250, e.nextID%250+4),
+		CreateTime:       time.Now().UTC().Truncate(time.Second),
+		Tags:             tagSpecifications(form, "natgateway"),
+		clientToken:      token,
+	}
+	if nat.ConnectivityType == "" {
+		nat.ConnectivityType = "public"
+	}
+	if nat.ConnectivityType == "public" {
+		address, err := e.lookupAddress(form.Get("AllocationId"))
+		if err != nil {
+			return nil, err
+		}
+		nat.AllocationID = address.AllocationID
+		nat.PublicIP = address.PublicIP
+	}
+	e.natGateways[nat.ID] = nat
+	return createNatGatewayResponse{ClientToken: token, NatGateway: nat.xml()}, nil
+}
+
+func (e *EC2) lookupNatGateway(id string) (*NatGateway, *apiError) {
+	nat, ok := e.natGateways[id]
+	if !ok {
+		return nil, &apiError{Code: "NatGatewayNotFound", Message: "The Nat Gateway " + id + " was not found"}
+	}
+	return nat, nil
+}
+
+func (e *EC2) describeNatGateways(form url.Values) (interface{}, *apiError) {
+	ids := indexed(form, "NatGatewayId")
+	for _, id := range ids {
+		if _, err := e.lookupNatGateway(id); err != nil {
+			return nil, err
+		}
+	}
+	filters := parseFilters(form)
+
+	resp := describeNatGatewaysResponse{}
+	for _, id := range sortedIDs(e.natGateways) {
+		nat := e.natGateways[id]
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		// Gateways settle the first time they are looked at
+		switch nat.State {
+		case "pending":
+			nat.State = "available"
+		case "deleting":
+			nat.State = "deleted"
+		}
+		if !matchFilters(filters, nat.Tags, func(name string) ([]string, bool) {
+			switch name {
+			case "state":
+				return []string{nat.State}, true
+			case "subnet-id":
+				return []string{nat.SubnetID}, true
+			case "vpc-id":
+				return []string{nat.VpcID}, true
+			}
+			return nil, false
+		}) {
+			continue
+		}
+		resp.NatGateways = append(resp.NatGateways, nat.xml())
+	}
+	return resp, nil
+}
+
+func (e *EC2) deleteNatGateway(form url.Values) (interface{}, *apiError) {
+	nat, err := e.lookupNatGateway(form.Get("NatGatewayId"))
+	if err != nil {
+		return nil, err
+	}
+	if nat.State != "deleted" {
+		nat.State = "deleting"
+	}
+	return deleteNatGatewayResponse{NatGatewayID: nat.ID}, nil
+}
+
+// tagsOf returns the tags of any resource the stand-in holds
+func (e *EC2) tagsOf(id string) (map[string]string, *apiError) {
+	switch {
+	case e.instances[id] != nil:
+		return e.instances[id].Tags, nil
+	case e.vpcs[id] != nil:
+		return e.vpcs[id].Tags, nil
+	case e.subnets[id] != nil:
+		return e.subnets[id].Tags, nil
+	case e.internetGateways[id] != nil:
+		return e.internetGateways[id].Tags, nil
+	case e.addresses[id] != nil:
+		return e.addresses[id].Tags, nil
+	case e.natGateways[id] != nil:
+		return e.natGateways[id].Tags, nil
+	case e.routeTables[id] != nil:
+		return e.routeTables[id].Tags, nil
+	case e.securityGroups[id] != nil:
+		return e.securityGroups[id].Tags, nil
+	case e.volumes[id] != nil:
+		return e.volumes[id].Tags, nil
+	case e.launchTemplates[id] != nil:
+		return e.launchTemplates[id].Tags, nil
+	case e.keyPairs[id] != nil:
+		return e.keyPairs[id].Tags, nil
+	case strings.HasPrefix(id, "i-"):
+		_, err := e.lookup(id)
+		return nil, err
+	default:
+		return nil, &apiError{Code: "InvalidID", Message: "The ID '" + id + "' is not valid"}
+	}
+}
+
+func (v *VPC) xml() xmlVpc {
+	return xmlVpc{
+		VpcID:     v.ID,
+		CidrBlock: v.CidrBlock,
+		State:     "available",
+		Tags:      xmlTags(v.Tags),
+	}
+}
+
+func (s *Subnet) xml() xmlSubnet {
+	return xmlSubnet{
+		SubnetID:            s.ID,
+		VpcID:               s.VpcID,
+		CidrBlock:           s.CidrBlock,
+		AvailabilityZone:    s.AvailabilityZone,
+		MapPublicIPOnLaunch: s.MapPublicIPOnLaunch,
+		State:               "available",
+		Tags:                xmlTags(s.Tags),
+	}
+}
+
+func (g *InternetGateway) xml() xmlInternetGateway {
+	out := xmlInternetGateway{InternetGatewayID: g.ID, Tags: xmlTags(g.Tags)}
+	if g.VpcID != "" {
+		out.Attachments = []xmlAttachment{{VpcID: g.VpcID, State: "available"}}
+	}
+	return out
+}
+
+func (n *NatGateway) xml() xmlNatGateway {
+	return xmlNatGateway{
+		NatGatewayID:     n.ID,
+		SubnetID:         n.SubnetID,
+		VpcID:            n.VpcID,
+		State:            n.State,
+		ConnectivityType: n.ConnectivityType,
+		CreateTime:       n.CreateTime.Format(time.RFC3339),
+		Addresses: []xmlNatGatewayAddress{{
+			AllocationID: n.AllocationID,
+			PublicIP:     n.PublicIP,
+			PrivateIP:    n.PrivateIP,
+		}},
+		Tags: xmlTags(n.Tags),
+	}
+}
+
+// tagSpecifications returns the tags requested for resourceType through
+// TagSpecification.N parameters
+func tagSpecifications(form url.Values, resourceType string) map[string]string {
+	tags := make(map[string]string)
+	for i := 1; form.Has(fmt.Sprintf("TagSpecification.%d.ResourceType", i)); i++ {
+		prefix := fmt.Sprintf("TagSpecification.%d.", i)
+		if form.Get(prefix+"ResourceType") != resourceType {
+			continue
+		}
+		for k, v := range indexedTags(form, prefix+"Tag") {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+// parseFilters reads Filter.N.Name and Filter.N.Value.M parameters
+func parseFilters(form url.Values) map[string][]string {
+	filters := make(map[string][]string)
+	for i := 1; form.Has(fmt.Sprintf("Filter.%d.Name", i)); i++ {
+		prefix := fmt.Sprintf("Filter.%d.", i)
+		filters[form.Get(prefix+"Name")] = indexed(form, prefix+"Value")
+	}
+	return filters
+}
+
+// matchFilters reports whether a resource passes every filter. Tag filters
+// are checked against tags; field returns the resource's values for any
+// other filter, or false if the stand-in ignores that filter.
+func matchFilters(filters map[string][]string, tags map[string]string, field func(name string) ([]string, bool)) bool {
+	for name, values := range filters {
+		var actual []string
+		if name == "tag-key" {
+			actual = sortedKeys(tags)
+		} else if strings.HasPrefix(name, "tag:") {
+			v, ok := tags[name[4:]]
+			if !ok {
+				return false
+			}
+			actual = []string{v}
+		} else if v, ok := field(name); ok {
+			actual = v
+		} else {
+			continue
+		}
+
+		matched := false
+		for _, a := range actual {
+			if contains(values, a) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedIDs returns the keys of m sorted, so describe calls list resources
+// in creation order
+func sortedIDs[T any](m map[string]*T) []string {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	out := make(map[string]string, len(tags))
+	for k, v := range tags {
+		out[k] = v
+	}
+	return out
+}
+
+func xmlTags(tags map[string]string) []xmlTag {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]xmlTag, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, xmlTag{Key: k, Value: tags[k]})
+	}
+	return out
+}
+
+type xmlBoolValue struct {
+	Value bool `xml:"value"`
+}
+
+type xmlVpc struct {
+	VpcID     string   `xml:"vpcId"`
+	CidrBlock string   `xml:"cidrBlock"`
+	State     string   `xml:"state"`
+	Tags      []xmlTag `xml:"tagSet>item"`
+}
+
+type createVpcResponse struct {
+	XMLName xml.Name `xml:"CreateVpcResponse"`
+	Vpc     xmlVpc   `xml:"vpc"`
+}
+
+type describeVpcsResponse struct {
+	XMLName xml.Name `xml:"DescribeVpcsResponse"`
+	Vpcs    []xmlVpc `xml:"vpcSet>item"`
+}
+
+type describeVpcAttributeResponse struct {
+	XMLName            xml.Name      `xml:"DescribeVpcAttributeResponse"`
+	EnableDNSSupport   *xmlBoolValue `xml:"enableDnsSupport,omitempty"`
+	EnableDNSHostnames *xmlBoolValue `xml:"enableDnsHostnames,omitempty"`
+	VpcID              string        `xml:"vpcId"`
+}
+
+type xmlSubnet struct {
+	SubnetID            string   `xml:"subnetId"`
+	VpcID               string   `xml:"vpcId"`
+	CidrBlock           string   `xml:"cidrBlock"`
+	AvailabilityZone    string   `xml:"availabilityZone"`
+	State               string   `xml:"state"`
+	Tags                []xmlTag `xml:"tagSet>item"`
+	MapPublicIPOnLaunch bool     `xml:"mapPublicIpOnLaunch"`
+}
+
+type createSubnetResponse struct {
+	XMLName xml.Name  `xml:"CreateSubnetResponse"`
+	Subnet  xmlSubnet `xml:"subnet"`
+}
+
+type describeSubnetsResponse struct {
+	XMLName xml.Name    `xml:"DescribeSubnetsResponse"`
+	Subnets []xmlSubnet `xml:"subnetSet>item"`
+}
+
+type xmlAttachment struct {
+	VpcID string `xml:"vpcId"`
+	State string `xml:"state"`
+}
+
+type xmlInternetGateway struct {
+	InternetGatewayID string          `xml:"internetGatewayId"`
+	Attachments       []xmlAttachment `xml:"attachmentSet>item"`
+	Tags              []xmlTag        `xml:"tagSet>item"`
+}
+
+type createInternetGatewayResponse struct {
+	XMLName         xml.Name           `xml:"CreateInternetGatewayResponse"`
+	InternetGateway xmlInternetGateway `xml:"internetGateway"`
+}
+
+type describeInternetGatewaysResponse struct {
+	XMLName          xml.Name             `xml:"DescribeInternetGatewaysResponse"`
+	InternetGateways []xmlInternetGateway `xml:"internetGatewaySet>item"`
+}
+
+type xmlAddress struct {
+	AllocationID string   `xml:"allocationId"`
+	PublicIP     string   `xml:"publicIp"`
+	Domain       string   `xml:"domain"`
+	Tags         []xmlTag `xml:"tagSet>item"`
+}
+
+type allocateAddressResponse struct {
+	XMLName      xml.Name `xml:"AllocateAddressResponse"`
+	AllocationID string   `xml:"allocationId"`
+	PublicIP     string   `xml:"publicIp"`
+	Domain       string   `xml:"domain"`
+}
+
+type describeAddressesResponse struct {
+	XMLName   xml.Name     `xml:"DescribeAddressesResponse"`
+	Addresses []xmlAddress `xml:"addressesSet>item"`
+}
+
+type xmlNatGatewayAddress struct {
+	AllocationID string `xml:"allocationId,omitempty"`
+	PublicIP     string `xml:"publicIp,omitempty"`
+	PrivateIP    string `xml:"privateIp"`
+}
+
+type xmlNatGateway struct {
+	NatGatewayID     string                 `xml:"natGatewayId"`
+	SubnetID         string                 `xml:"subnetId"`
+	VpcID            string                 `xml:"vpcId"`
+	State            string                 `xml:"state"`
+	ConnectivityType string                 `xml:"connectivityType"`
+	CreateTime       string                 `xml:"createTime"`
+	Addresses        []xmlNatGatewayAddress `xml:"natGatewayAddressSet>item"`
+	Tags             []xmlTag               `xml:"tagSet>item"`
+}
+
+type createNatGatewayResponse struct {
+	XMLName     xml.Name      `xml:"CreateNatGatewayResponse"`
+	ClientToken string        `xml:"clientToken,omitempty"`
+	NatGateway  xmlNatGateway `xml:"natGateway"`
+}
+
+type describeNatGatewaysResponse struct {
+	XMLName     xml.Name        `xml:"DescribeNatGatewaysResponse"`
+	NatGateways []xmlNatGateway `xml:"natGatewaySet>item"`
+}
+
+type deleteNatGatewayResponse struct {
+	XMLName      xml.Name `xml:"DeleteNatGatewayResponse"`
+	NatGatewayID string   `xml:"natGatewayId"`
+}
@@ -0,0 +1,249 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// dataSource reads one kind of existing infrastructure without managing it
+type dataSource func(ctx context.Context, config map[string]interface{}) (map[string]interface{}, error)
+
+// ReadData looks up the data source of the given type matching config
+func (p *AWSProvider) ReadData(ctx context.Context, dataType string, config map[string]interface{}) (map[string]interface{}, error) {
+	read, ok := p.dataSources[dataType]
+	if !ok {
+		return nil, fmt.Errorf("aws: unsupported data source %q", dataType)
+	}
+	return read(ctx, config)
+}
+
+// ReadAMI finds an image by owner, name pattern and filters. When several
+// match, most_recent picks the newest; otherwise it is an error, so a
+// lookup never silently changes which image it returns.
+func (c *EC2Client) ReadAMI(ctx context.Context, config map[string]interface{}) (map[string]interface{}, error) {
+	attrs := newAttributes(config)
+	attrs.Require("owners")
+	owners := attrs.StringList("owners")
+	name := attrs.String("name")
+	mostRecent := attrs.Bool("most_recent")
+	filters := attrs.Filters("filters")
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	if name != "" {
+		filters = append(filters, ec2types.Filter{Name: aws.String("name"), Values: []string{name}})
+	}
+
+	result, err := c.client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		Owners:  owners,
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe images: %w", err)
+	}
+
+	images := result.Images
+	switch {
+	case len(images) == 0:
+		return nil, fmt.Errorf("no AMI matches owners %v and name %q", owners, name)
+	case len(images) > 1 && !mostRecent:
+		return nil, fmt.Errorf("%d AMIs match owners %v and name %q; narrow the search or set most_recent", len(images), owners, name)
+	}
+	// Creation dates are ISO 8601 in UTC, so they sort as strings
+	sort.Slice(images, func(i, j int) bool {
+		return aws.ToString(images[i].CreationDate) > aws.ToString(images[j].CreationDate)
+	})
+
+	image := images[0]
+	return map[string]interface{}{
+		"id":                  aws.ToString(image.ImageId),
+		"image_id":            aws.ToString(image.ImageId),
+		"name":                aws.ToString(image.Name),
+		"owner_id":            aws.ToString(image.OwnerId),
+		"description":         aws.ToString(image.Description),
+		"creation_date":       aws.ToString(image.CreationDate),
+		"architecture":        string(image.Architecture),
+		"root_device_name":    aws.ToString(image.RootDeviceName),
+		"virtualization_type": string(image.VirtualizationType),
+		"tags":                tagTable(fromEC2Tags(image.Tags)),
+	}, nil
+}
+
+// ReadAvailabilityZones lists the zones of the provider's region, by
+// default only those that are available
+func (c *EC2Client) ReadAvailabilityZones(ctx context.Context, config map[string]interface{}) (map[string]interface{}, error) {
+	attrs := newAttributes(config)
+	state := attrs.String("state")
+	if state == "" {
+		state = "available"
+	}
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+
+	result, err := c.client.DescribeAvailabilityZones(ctx, &ec2.DescribeAvailabilityZonesInput{
+		Filters: []ec2types.Filter{{Name: aws.String("state"), Values: []string{state}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe availability zones: %w", err)
+	}
+
+	zones := result.AvailabilityZones
+	sort.Slice(zones, func(i, j int) bool {
+		return aws.ToString(zones[i].ZoneName) < aws.ToString(zones[j].ZoneName)
+	})
+	names := make([]interface{}, 0, len(zones))
+	ids := make([]interface{}, 0, len(zones))
+	for _, z := range zones {
+		names = append(names, aws.ToString(z.ZoneName))
+		ids = append(ids, aws.ToString(z.ZoneId))
+	}
+	return map[string]interface{}{
+		"id":       c.client.Options().Region,
+		"names":    names,
+		"zone_ids": ids,
+	}, nil
+}
+
+// ReadVpcData finds exactly one existing VPC by ID, CIDR block, default
+// flag, tags or filters
+func (c *EC2Client) ReadVpcData(ctx context.Context, config map[string]interface{}) (map[string]interface{}, error) {
+	attrs := newAttributes(config)
+	filters := attrs.Filters("filters")
+	filters = append(filters, attributeFilters(attrs, [][2]string{
+		{"id", "vpc-id"},
+		{"cidr_block", "cidr-block"},
+	})...)
+	if attrs.Has("default") {
+		filters = append(filters, ec2types.Filter{Name: aws.String("is-default"), Values: []string{fmt.Sprint(attrs.Bool("default"))}})
+	}
+	filters = append(filters, tagFilters(attrs.StringMap("tags"))...)
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+
+	result, err := c.client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{Filters: filters})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe VPCs: %w", err)
+	}
+	if len(result.Vpcs) != 1 {
+		return nil, fmt.Errorf("expected one VPC to match, found %d", len(result.Vpcs))
+	}
+
+	vpc, err := c.ReadVpc(ctx, aws.ToString(result.Vpcs[0].VpcId))
+	if err != nil {
+		return nil, err
+	}
+	return dataAttributes(vpc), nil
+}
+
+// ReadSubnetData finds exactly one existing subnet by ID, VPC, zone, CIDR
+// block, tags or filters
+func (c *EC2Client) ReadSubnetData(ctx context.Context, config map[string]interface{}) (map[string]interface{}, error) {
+	attrs := newAttributes(config)
+	filters := attrs.Filters("filters")
+	filters = append(filters, attributeFilters(attrs, [][2]string{
+		{"id", "subnet-id"},
+		{"vpc_id", "vpc-id"},
+		{"availability_zone", "availability-zone"},
+		{"cidr_block", "cidr-block"},
+	})...)
+	filters = append(filters, tagFilters(attrs.StringMap("tags"))...)
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+
+	result, err := c.client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{Filters: filters})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe subnets: %w", err)
+	}
+	if len(result.Subnets) != 1 {
+		return nil, fmt.Errorf("expected one subnet to match, found %d", len(result.Subnets))
+	}
+	return dataAttributes(subnetResource(result.Subnets[0])), nil
+}
+
+// readCallerIdentity reports the account and identity the provider's
+// credentials belong to
+func readCallerIdentity(client *sts.Client) dataSource {
+	return func(ctx context.Context, config map[string]interface{}) (map[string]interface{}, error) {
+		result, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get caller identity: %w", err)
+		}
+		return map[string]interface{}{
+			"id":         aws.ToString(result.Account),
+			"account_id": aws.ToString(result.Account),
+			"arn":        aws.ToString(result.Arn),
+			"user_id":    aws.ToString(result.UserId),
+		}, nil
+	}
+}
+
+// Filters returns the table at key as EC2 filters. Each filter name maps to
+// a value or a list of values, e.g. { ["tag:env"] = "prod" }.
+func (a *attributes) Filters(key string) []ec2types.Filter {
+	table := a.Table(key)
+	var filters []ec2types.Filter
+	for _, name := range sortedKeys(table.values) {
+		var values []string
+		if v, ok := table.values[name].(string); ok {
+			values = []string{v}
+		} else {
+			values = table.StringList(name)
+		}
+		filters = append(filters, ec2types.Filter{Name: aws.String(name), Values: values})
+	}
+	a.collectTable(key, table)
+	return filters
+}
+
+// attributeFilters returns a filter for each attribute that is set, from
+// pairs of attribute and filter name
+func attributeFilters(attrs *attributes, pairs [][2]string) []ec2types.Filter {
+	var filters []ec2types.Filter
+	for _, pair := range pairs {
+		if v := attrs.String(pair[0]); v != "" {
+			filters = append(filters, ec2types.Filter{Name: aws.String(pair[1]), Values: []string{v}})
+		}
+	}
+	return filters
+}
+
+// tagFilters returns a filter matching each tag exactly
+func tagFilters(tags map[string]string) []ec2types.Filter {
+	filters := make([]ec2types.Filter, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		filters = append(filters, ec2types.Filter{Name: aws.String("tag:" + k), Values: []string{tags[k]}})
+	}
+	return filters
+}
+
+// dataAttributes flattens a resource into data source attributes: its
+// metadata plus id and tags
+func dataAttributes(r *types.BaseResource) map[string]interface{} {
+	out := make(map[string]interface{}, len(r.Metadata)+2)
+	for k, v := range r.Metadata {
+		out[k] = v
+	}
+	out["id"] = r.ID
+	out["tags"] = tagTable(r.Tags)
+	return out
+}
+
+// tagTable converts tags to the table form Lua values take
+func tagTable(tags map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(tags))
+	for k, v := range tags {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,148 @@
+package aws
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/iac/provider/aws/awstest"
+)
+
+func TestDataSources(t *testing.T) {
+	p, cloud := newCloudTestProvider(t)
+	ctx := context.Background()
+
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i, image := range []awstest.Image{
+		{ID: "ami-old", Name: "ubuntu-jammy-20240501", OwnerID: "099720109477", CreationDate: day.AddDate(0, -1, 0)},
+		{ID: "ami-new", Name: "ubuntu-jammy-20240601", OwnerID: "099720109477", CreationDate: day},
+		{ID: "ami-arm", Name: "ubuntu-jammy-20240602", OwnerID: "099720109477", CreationDate: day.AddDate(0, 0, 1), Architecture: "arm64"},
+		{ID: "ami-fake", Name: "ubuntu-jammy-20240701", OwnerID: "111111111111", CreationDate: day.AddDate(0, 1, 0)},
+	} {
+		image.Tags = map[string]string{"n": string(rune('0' + i))}
+		cloud.EC2.AddImage(image)
+	}
+
+	t.Run("AMI", func(t *testing.T) {
+		ami, err := p.ReadData(ctx, "ami", map[string]interface{}{
+			"owners":      []interface{}{"099720109477"},
+			"name":        "ubuntu-jammy-*",
+			"filters":     map[string]interface{}{"architecture": "x86_64"},
+			"most_recent": true,
+		})
+		if err != nil {
+			t.Fatalf("Failed to read AMI: %v", err)
+		}
+		if ami["id"] != "ami-new" || ami["name"] != "ubuntu-jammy-20240601" {
+			t.Errorf("Expected the newest x86 image, got %v", ami)
+		}
+	})
+
+	t.Run("AMIAmbiguous", func(t *testing.T) {
+		_, err := p.ReadData(ctx, "ami", map[string]interface{}{
+			"owners": []interface{}{"099720109477"},
+			"name":   "ubuntu-jammy-*",
+		})
+		if err == nil || !strings.Contains(err.Error(), "most_recent") {
+			t.Errorf("Expected an error suggesting most_recent, got %v", err)
+		}
+	})
+
+	t.Run("AMINoMatch", func(t *testing.T) {
+		_, err := p.ReadData(ctx, "ami", map[string]interface{}{
+			"owners": []interface{}{"099720109477"},
+			"name":   "debian-*",
+		})
+		if err == nil || !strings.Contains(err.Error(), "no AMI matches") {
+			t.Errorf("Expected a no match error, got %v", err)
+		}
+	})
+
+	t.Run("AMIRequiresOwners", func(t *testing.T) {
+		if _, err := p.ReadData(ctx, "ami", map[string]interface{}{"name": "ubuntu-*"}); err == nil {
+			t.Error("Expected error without owners, got nil")
+		}
+	})
+
+	t.Run("AvailabilityZones", func(t *testing.T) {
+		cloud.EC2.SetAvailabilityZones(
+			awstest.AvailabilityZone{Name: "us-east-1b", ID: "use1-az2", State: "available"},
+			awstest.AvailabilityZone{Name: "us-east-1a", ID: "use1-az1", State: "available"},
+			awstest.AvailabilityZone{Name: "us-east-1e", ID: "use1-az3", State: "impaired"},
+		)
+		zones, err := p.ReadData(ctx, "availability_zones", map[string]interface{}{})
+		if err != nil {
+			t.Fatalf("Failed to read availability zones: %v", err)
+		}
+		if want := []interface{}{"us-east-1a", "us-east-1b"}; !reflect.DeepEqual(zones["names"], want) {
+			t.Errorf("Expected zones %v, got %v", want, zones["names"])
+		}
+		if zones["id"] != "us-east-1" {
+			t.Errorf("Expected ID us-east-1, got %v", zones["id"])
+		}
+	})
+
+	t.Run("CallerIdentity", func(t *testing.T) {
+		identity, err := p.ReadData(ctx, "caller_identity", nil)
+		if err != nil {
+			t.Fatalf("Failed to read caller identity: %v", err)
+		}
+		if identity["account_id"] != "123456789012" || identity["arn"] != awstest.CallerArn {
+			t.Errorf("Unexpected identity: %v", identity)
+		}
+	})
+
+	t.Run("VpcAndSubnet", func(t *testing.T) {
+		for _, name := range []string{"main", "other"} {
+			vpc, err := p.Create(ctx, "vpc", map[string]interface{}{
+				"cidr_block": "10.0.0.0/16",
+				"tags":       map[string]interface{}{"Name": name},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create VPC: %v", err)
+			}
+			if _, err := p.Create(ctx, "subnet", map[string]interface{}{
+				"vpc_id":            vpc.GetID(),
+				"cidr_block":        "10.0.1.0/24",
+				"availability_zone": "us-east-1a",
+				"tags":              map[string]interface{}{"Name": name + "-public"},
+			}); err != nil {
+				t.Fatalf("Failed to create subnet: %v", err)
+			}
+		}
+
+		vpc, err := p.ReadData(ctx, "vpc", map[string]interface{}{"tags": map[string]interface{}{"Name": "main"}})
+		if err != nil {
+			t.Fatalf("Failed to read VPC: %v", err)
+		}
+		if vpc["cidr_block"] != "10.0.0.0/16" || vpc["tags"].(map[string]interface{})["Name"] != "main" {
+			t.Errorf("Unexpected VPC: %v", vpc)
+		}
+
+		subnet, err := p.ReadData(ctx, "subnet", map[string]interface{}{
+			"vpc_id":  vpc["id"],
+			"filters": map[string]interface{}{"availability-zone": []interface{}{"us-east-1a", "us-east-1b"}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to read subnet: %v", err)
+		}
+		if subnet["vpc_id"] != vpc["id"] || subnet["tags"].(map[string]interface{})["Name"] != "main-public" {
+			t.Errorf("Unexpected subnet: %v", subnet)
+		}
+
+		if _, err := p.ReadData(ctx, "vpc", map[string]interface{}{"cidr_block": "10.0.0.0/16"}); err == nil || !strings.Contains(err.Error(), "found 2") {
+			t.Errorf("Expected an error for an ambiguous lookup, got %v", err)
+		}
+		if _, err := p.ReadData(ctx, "subnet", map[string]interface{}{"tags": map[string]interface{}{"Name": "none"}}); err == nil || !strings.Contains(err.Error(), "found 0") {
+			t.Errorf("Expected an error for a lookup with no match, got %v", err)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		if _, err := p.ReadData(ctx, "bucket", nil); err == nil {
+			t.Error("Expected error for an unsupported data source, got nil")
+		}
+	})
+}
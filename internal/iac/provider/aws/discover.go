@@ -0,0 +1,286 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// autoScalingGroupTag is set by Auto Scaling on the instances it launches
+const autoScalingGroupTag = "aws:autoscaling:groupName"
+
+// DiscoverableTypes returns the resource types Discover can list
+func (p *AWSProvider) DiscoverableTypes() []string {
+	var out []string
+	for resourceType, h := range p.handlers {
+		if h.discover != nil {
+			out = append(out, resourceType)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Discover returns the resources of resourceType carrying tags, with the
+// config that declares each. Tags duet sets itself and the provider's
+// default tags are left out of the config, since they are added on apply.
+func (p *AWSProvider) Discover(ctx context.Context, resourceType string, tags map[string]string) ([]provider.Discovered, error) {
+	h, err := p.handler(resourceType)
+	if err != nil {
+		return nil, err
+	}
+	if h.discover == nil {
+		return nil, fmt.Errorf("aws: %s resources cannot be discovered", resourceType)
+	}
+	found, err := h.discover(ctx, discoveryFilters(tags))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]provider.Discovered, 0, len(found))
+	for _, d := range found {
+		configTags := make(map[string]interface{})
+		for k, v := range d.resource.Tags {
+			if dv, ok := p.defaultTags[k]; isReservedTag(k) || (ok && dv == v) {
+				continue
+			}
+			configTags[k] = v
+		}
+		if len(configTags) > 0 {
+			d.config["tags"] = configTags
+		}
+		out = append(out, provider.Discovered{Resource: d.resource, Config: d.config})
+	}
+	return out, nil
+}
+
+// discovered is a resource found by a handler's discover function, before
+// its tags are added to its config
+type discovered struct {
+	resource *types.BaseResource
+	config   map[string]interface{}
+}
+
+// discoveryFilters matches resources with every tag in tags, or just the
+// key where the value is empty
+func discoveryFilters(tags map[string]string) []ec2types.Filter {
+	filters := make([]ec2types.Filter, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		if tags[k] == "" {
+			filters = append(filters, ec2types.Filter{Name: aws.String("tag-key"), Values: []string{k}})
+		} else {
+			filters = append(filters, ec2types.Filter{Name: aws.String("tag:" + k), Values: []string{tags[k]}})
+		}
+	}
+	return filters
+}
+
+// DiscoverInstances returns the instances matching filters that have not
+// been terminated. Instances an Auto Scaling group launched are left out,
+// since the group replaces them as it sees fit.
+func (c *EC2Client) DiscoverInstances(ctx context.Context, filters []ec2types.Filter) ([]discovered, error) {
+	filters = append(filters, ec2types.Filter{
+		Name:   aws.String("instance-state-name"),
+		Values: []string{"pending", "running", "stopping", "stopped"},
+	})
+
+	var out []discovered
+	paginator := ec2.NewDescribeInstancesPaginator(c.client, &ec2.DescribeInstancesInput{Filters: filters})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instances: %w", err)
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				res := instanceResource(instance)
+				if _, ok := res.Tags[autoScalingGroupTag]; ok {
+					continue
+				}
+
+				config := map[string]interface{}{
+					"ami":           aws.ToString(instance.ImageId),
+					"instance_type": string(instance.InstanceType),
+				}
+				setIfNotEmpty(config, "subnet_id", aws.ToString(instance.SubnetId))
+				setIfNotEmpty(config, "key_name", aws.ToString(instance.KeyName))
+				setIfNotEmpty(config, "iam_instance_profile", instanceProfileName(instance))
+				if groups := instanceGroupIDs(instance); len(groups) > 0 {
+					config["security_groups"] = stringList(groups)
+				}
+				// Leaving user data out would clear it on the next update
+				userData, err := c.userData(ctx, res.ID)
+				if err != nil {
+					return nil, err
+				}
+				setIfNotEmpty(config, "user_data", userData)
+
+				out = append(out, discovered{resource: res, config: config})
+			}
+		}
+	}
+	return out, nil
+}
+
+// DiscoverVpcs returns the VPCs matching filters, except default VPCs
+func (c *EC2Client) DiscoverVpcs(ctx context.Context, filters []ec2types.Filter) ([]discovered, error) {
+	var out []discovered
+	paginator := ec2.NewDescribeVpcsPaginator(c.client, &ec2.DescribeVpcsInput{Filters: filters})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list VPCs: %w", err)
+		}
+		for _, vpc := range page.Vpcs {
+			if aws.ToBool(vpc.IsDefault) {
+				continue
+			}
+			id := aws.ToString(vpc.VpcId)
+			details, err := c.vpcDetails(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, discovered{
+				resource: vpcResource(vpc, details),
+				config: map[string]interface{}{
+					"cidr_block":           aws.ToString(vpc.CidrBlock),
+					"enable_dns_support":   details["enable_dns_support"],
+					"enable_dns_hostnames": details["enable_dns_hostnames"],
+				},
+			})
+		}
+	}
+	return out, nil
+}
+
+// DiscoverSecurityGroups returns the security groups matching filters,
+// except the default group of each VPC, which lives and dies with it
+func (c *EC2Client) DiscoverSecurityGroups(ctx context.Context, filters []ec2types.Filter) ([]discovered, error) {
+	var out []discovered
+	paginator := ec2.NewDescribeSecurityGroupsPaginator(c.client, &ec2.DescribeSecurityGroupsInput{Filters: filters})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list security groups: %w", err)
+		}
+		for _, group := range page.SecurityGroups {
+			if aws.ToString(group.GroupName) == "default" {
+				continue
+			}
+			id := aws.ToString(group.GroupId)
+			config := map[string]interface{}{
+				"name":        aws.ToString(group.GroupName),
+				"description": aws.ToString(group.Description),
+			}
+			setIfNotEmpty(config, "vpc_id", aws.ToString(group.VpcId))
+			if ingress := flattenPermissions(group.IpPermissions); len(ingress) > 0 {
+				config["ingress"] = rulesConfig(ingress, id)
+			}
+			// Egress is only managed when set, so the default allow-all
+			// rule is left to AWS
+			if egress := flattenPermissions(group.IpPermissionsEgress); !isDefaultEgress(egress) {
+				config["egress"] = rulesConfig(egress, id)
+			}
+			out = append(out, discovered{resource: securityGroupResource(group), config: config})
+		}
+	}
+	return out, nil
+}
+
+// rulesConfig returns rules in the form a security group config takes
+// them, one per peer. Rules naming the group itself use self = true.
+func rulesConfig(rules []sgRule, groupID string) []interface{} {
+	out := make([]interface{}, 0, len(rules))
+	for _, r := range rules {
+		rule := map[string]interface{}{"protocol": r.protocol}
+		if r.protocol != "-1" {
+			rule["from_port"] = r.fromPort
+			rule["to_port"] = r.toPort
+		}
+		switch {
+		case r.cidrBlock != "":
+			rule["cidr_blocks"] = []interface{}{r.cidrBlock}
+		case r.ipv6Block != "":
+			rule["ipv6_cidr_blocks"] = []interface{}{r.ipv6Block}
+		case r.groupID == groupID:
+			rule["self"] = true
+		default:
+			rule["security_groups"] = []interface{}{r.groupID}
+		}
+		setIfNotEmpty(rule, "description", r.description)
+		out = append(out, rule)
+	}
+	return out
+}
+
+// isDefaultEgress reports whether rules are just the allow-all rule AWS
+// gives every new group
+func isDefaultEgress(rules []sgRule) bool {
+	return len(rules) == 1 && rules[0] == sgRule{protocol: "-1", cidrBlock: "0.0.0.0/0"}
+}
+
+// DiscoverVolumes returns the EBS volumes matching filters. Volumes that
+// are deleted when the instance they are attached to terminates, such as
+// root volumes, are part of that instance and left out.
+func (c *EC2Client) DiscoverVolumes(ctx context.Context, filters []ec2types.Filter) ([]discovered, error) {
+	var out []discovered
+	paginator := ec2.NewDescribeVolumesPaginator(c.client, &ec2.DescribeVolumesInput{Filters: filters})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list volumes: %w", err)
+		}
+	volumes:
+		for _, volume := range page.Volumes {
+			for _, a := range volume.Attachments {
+				if aws.ToBool(a.DeleteOnTermination) {
+					continue volumes
+				}
+			}
+
+			config := map[string]interface{}{
+				"availability_zone": aws.ToString(volume.AvailabilityZone),
+				"size":              int(aws.ToInt32(volume.Size)),
+				"type":              string(volume.VolumeType),
+			}
+			// IOPS and throughput can only be set on the types that allow
+			// choosing them; others report their fixed values
+			switch volume.VolumeType {
+			case ec2types.VolumeTypeGp3:
+				config["iops"] = int(aws.ToInt32(volume.Iops))
+				config["throughput"] = int(aws.ToInt32(volume.Throughput))
+			case ec2types.VolumeTypeIo1, ec2types.VolumeTypeIo2:
+				config["iops"] = int(aws.ToInt32(volume.Iops))
+			}
+			if aws.ToBool(volume.Encrypted) {
+				config["encrypted"] = true
+				setIfNotEmpty(config, "kms_key_id", aws.ToString(volume.KmsKeyId))
+			}
+			setIfNotEmpty(config, "snapshot_id", aws.ToString(volume.SnapshotId))
+
+			out = append(out, discovered{resource: volumeResource(volume), config: config})
+		}
+	}
+	return out, nil
+}
+
+func setIfNotEmpty(config map[string]interface{}, key, value string) {
+	if value != "" {
+		config[key] = value
+	}
+}
+
+func stringList(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
@@ -0,0 +1,146 @@
+package aws
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+func TestDiscover(t *testing.T) {
+	p, ec2Server := newTestProvider(t)
+	p.defaultTags = map[string]string{"cost-center": "42"}
+	ctx := context.Background()
+	team := map[string]interface{}{"team": "x", "Name": "main"}
+
+	create := func(ctx context.Context, resourceType string, config map[string]interface{}) provider.Resource {
+		t.Helper()
+		res, err := p.Create(ctx, resourceType, config)
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", resourceType, err)
+		}
+		return res
+	}
+	vpc := create(ctx, "vpc", map[string]interface{}{"cidr_block": "10.0.0.0/16", "enable_dns_hostnames": true, "tags": team})
+	group := create(ctx, "security_group", map[string]interface{}{
+		"name":   "web",
+		"vpc_id": vpc.GetID(),
+		"ingress": []interface{}{
+			map[string]interface{}{"protocol": "tcp", "from_port": 443, "cidr_blocks": []interface{}{"0.0.0.0/0"}},
+			map[string]interface{}{"protocol": "all", "self": true},
+		},
+		"tags": team,
+	})
+	instance := create(ctx, "instance", map[string]interface{}{
+		"ami":             "ami-12345678",
+		"instance_type":   "t3.micro",
+		"security_groups": []interface{}{group.GetID()},
+		"user_data":       "#!/bin/sh\necho hi\n",
+		"tags":            team,
+	})
+	volume := create(ctx, "volume", map[string]interface{}{"availability_zone": "us-east-1a", "size": 20, "type": "gp3", "tags": team})
+
+	// Other teams' VPCs, one of them created by a duet config
+	create(ctx, "vpc", map[string]interface{}{"cidr_block": "10.1.0.0/16", "tags": map[string]interface{}{"team": "y"}})
+	owned := provider.WithOwnership(ctx, provider.Ownership{Project: "shop", Address: "aws.vpc.shop"})
+	shop := create(owned, "vpc", map[string]interface{}{"cidr_block": "10.2.0.0/16", "tags": map[string]interface{}{"team": "z"}})
+
+	t.Run("Types", func(t *testing.T) {
+		want := []string{"instance", "security_group", "volume", "vpc"}
+		if got := p.DiscoverableTypes(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	})
+
+	for _, tc := range []struct {
+		resourceType string
+		id           string
+		want         map[string]interface{}
+	}{
+		{"vpc", vpc.GetID(), map[string]interface{}{
+			"cidr_block":           "10.0.0.0/16",
+			"enable_dns_support":   true,
+			"enable_dns_hostnames": true,
+		}},
+		{"security_group", group.GetID(), map[string]interface{}{
+			"name":        "web",
+			"description": defaultGroupDescription,
+			"vpc_id":      vpc.GetID(),
+			"ingress": []interface{}{
+				map[string]interface{}{"protocol": "-1", "self": true},
+				map[string]interface{}{"protocol": "tcp", "from_port": 443, "to_port": 443, "cidr_blocks": []interface{}{"0.0.0.0/0"}},
+			},
+		}},
+		{"instance", instance.GetID(), map[string]interface{}{
+			"ami":             "ami-12345678",
+			"instance_type":   "t3.micro",
+			"security_groups": []interface{}{group.GetID()},
+			"user_data":       "#!/bin/sh\necho hi\n",
+		}},
+		{"volume", volume.GetID(), map[string]interface{}{
+			"availability_zone": "us-east-1a",
+			"size":              20,
+			"type":              "gp3",
+			"iops":              3000,
+			"throughput":        125,
+		}},
+	} {
+		t.Run(tc.resourceType, func(t *testing.T) {
+			found, err := p.Discover(ctx, tc.resourceType, map[string]string{"team": "x"})
+			if err != nil {
+				t.Fatalf("Failed to discover: %v", err)
+			}
+			if len(found) != 1 || found[0].Resource.GetID() != tc.id {
+				t.Fatalf("Expected to discover %s only, got %+v", tc.id, found)
+			}
+
+			config := found[0].Config
+			// Duet's own tags and the default tags are left out
+			tc.want["tags"] = map[string]interface{}{"team": "x", "Name": "main"}
+			if !reflect.DeepEqual(config, tc.want) {
+				t.Errorf("Expected config %v, got %v", tc.want, config)
+			}
+			if diags := p.Schema().Resources[tc.resourceType].Validate(config); diags != nil {
+				t.Errorf("Expected the discovered config to match the schema, got %v", diags)
+			}
+
+			// Applying the discovered config changes nothing
+			calls := ec2Server.Calls("ModifyInstanceAttribute") + ec2Server.Calls("CreateTags") + ec2Server.Calls("AuthorizeSecurityGroupIngress")
+			if err := p.Update(ctx, found[0].Resource, config); err != nil {
+				t.Fatalf("Failed to apply discovered config: %v", err)
+			}
+			if after := ec2Server.Calls("ModifyInstanceAttribute") + ec2Server.Calls("CreateTags") + ec2Server.Calls("AuthorizeSecurityGroupIngress"); after != calls {
+				t.Errorf("Expected applying the discovered config to change nothing")
+			}
+		})
+	}
+
+	t.Run("TagKey", func(t *testing.T) {
+		found, err := p.Discover(ctx, "vpc", map[string]string{"team": ""})
+		if err != nil {
+			t.Fatalf("Failed to discover: %v", err)
+		}
+		if len(found) != 3 {
+			t.Fatalf("Expected every VPC with a team tag, got %d", len(found))
+		}
+		// Ownership is left for the caller to check, and kept out of the config
+		for _, d := range found {
+			if d.Resource.GetID() != shop.GetID() {
+				continue
+			}
+			if d.Resource.GetTags()[provider.TagProject] != "shop" {
+				t.Errorf("Expected the ownership tags on the resource, got %v", d.Resource.GetTags())
+			}
+			if want := map[string]interface{}{"team": "z"}; !reflect.DeepEqual(d.Config["tags"], want) {
+				t.Errorf("Expected tags %v in the config, got %v", want, d.Config["tags"])
+			}
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		if _, err := p.Discover(ctx, "subnet", nil); err == nil {
+			t.Error("Expected an error for a type that cannot be discovered, got nil")
+		}
+	})
+}
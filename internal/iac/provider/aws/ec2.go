@@ -3,38 +3,644 @@ package aws
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
 )
 
+// defaultPollInterval is how often instance state is polled while waiting
+const defaultPollInterval = 5 * time.Second
+
 type EC2Client struct {
-	client *ec2.Client
+	client       *ec2.Client
+	pollInterval time.Duration
 }
 
 func NewEC2Client(cfg aws.Config) (*EC2Client, error) {
 	return &EC2Client{
-		client: ec2.NewFromConfig(cfg),
+		client:       ec2.NewFromConfig(cfg),
+		pollInterval: defaultPollInterval,
 	}, nil
 }
 
-func (c *EC2Client) CreateInstance(ctx context.Context, config map[string]interface{}) (string, error) {
-	// Example implementation using the client
+// blockDevice is an EBS volume attached at launch
+type blockDevice struct {
+	deviceName          string
+	volumeType          string
+	kmsKeyID            string
+	volumeSize          int
+	iops                int
+	throughput          int
+	deleteOnTermination bool
+	encrypted           bool
+}
+
+// instanceSpec is the desired configuration of an EC2 instance
+type instanceSpec struct {
+	tags           map[string]string
+	instanceType   string
+	ami            string
+	subnetID       string
+	keyName        string
+	userData       string
+	profile        string
+	securityGroups []string
+	blockDevices   []blockDevice
+	timeouts       timeouts
+	readiness      readiness
+}
+
+func parseInstanceSpec(config map[string]interface{}) (*instanceSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("ami", "instance_type")
+
+	spec := &instanceSpec{
+		instanceType:   attrs.String("instance_type"),
+		ami:            attrs.String("ami"),
+		subnetID:       attrs.String("subnet_id"),
+		keyName:        attrs.String("key_name"),
+		userData:       attrs.String("user_data"),
+		profile:        attrs.String("iam_instance_profile"),
+		securityGroups: attrs.StringList("security_groups"),
+		tags:           attrs.StringMap("tags"),
+		timeouts:       parseTimeouts(attrs),
+		readiness: readiness{
+			statusChecks: true,
+			publicIP:     attrs.Bool("wait_for_public_ip"),
+		},
+	}
+	if attrs.Has("wait_for_status_checks") {
+		spec.readiness.statusChecks = attrs.Bool("wait_for_status_checks")
+	}
+
+	spec.blockDevices = parseBlockDevices(attrs)
+
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// parseBlockDevices reads the block_devices list shared by instances and
+// launch templates
+func parseBlockDevices(attrs *attributes) []blockDevice {
+	var blockDevices []blockDevice
+	devices := attrs.List("block_devices")
+	for _, d := range devices {
+		d.Require("device_name")
+		device := blockDevice{
+			deviceName:          d.String("device_name"),
+			volumeType:          d.String("volume_type"),
+			kmsKeyID:            d.String("kms_key_id"),
+			volumeSize:          d.Int("volume_size"),
+			iops:                d.Int("iops"),
+			throughput:          d.Int("throughput"),
+			deleteOnTermination: true,
+			encrypted:           d.Bool("encrypted"),
+		}
+		if d.Has("delete_on_termination") {
+			device.deleteOnTermination = d.Bool("delete_on_termination")
+		}
+		blockDevices = append(blockDevices, device)
+	}
+	attrs.collect("block_devices", devices...)
+	return blockDevices
+}
+
+// CreateInstance launches a single instance described by config. When the
+// context carries an idempotency token it is used as the client token, so a
+// retried launch returns the original instance.
+func (c *EC2Client) CreateInstance(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseInstanceSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
 	input := &ec2.RunInstancesInput{
-		MaxCount: aws.Int32(1),
-		MinCount: aws.Int32(1),
-		// Add other configuration as needed
+		MaxCount:     aws.Int32(1),
+		MinCount:     aws.Int32(1),
+		ImageId:      aws.String(spec.ami),
+		InstanceType: ec2types.InstanceType(spec.instanceType),
+	}
+	if token := provider.IdempotencyToken(ctx); token != "" {
+		input.ClientToken = aws.String(token)
+	}
+	if spec.subnetID != "" {
+		input.SubnetId = aws.String(spec.subnetID)
+	}
+	if spec.keyName != "" {
+		input.KeyName = aws.String(spec.keyName)
+	}
+	if len(spec.securityGroups) > 0 {
+		input.SecurityGroupIds = spec.securityGroups
+	}
+	if spec.profile != "" {
+		input.IamInstanceProfile = &ec2types.IamInstanceProfileSpecification{Name: aws.String(spec.profile)}
+	}
+	if spec.userData != "" {
+		input.UserData = aws.String(base64.StdEncoding.EncodeToString([]byte(spec.userData)))
+	}
+	for _, d := range spec.blockDevices {
+		ebs := &ec2types.EbsBlockDevice{
+			DeleteOnTermination: aws.Bool(d.deleteOnTermination),
+		}
+		if d.volumeSize > 0 {
+			ebs.VolumeSize = aws.Int32(int32(d.volumeSize))
+		}
+		if d.volumeType != "" {
+			ebs.VolumeType = ec2types.VolumeType(d.volumeType)
+		}
+		if d.iops > 0 {
+			ebs.Iops = aws.Int32(int32(d.iops))
+		}
+		if d.throughput > 0 {
+			ebs.Throughput = aws.Int32(int32(d.throughput))
+		}
+		if d.encrypted {
+			ebs.Encrypted = aws.Bool(true)
+		}
+		if d.kmsKeyID != "" {
+			ebs.KmsKeyId = aws.String(d.kmsKeyID)
+		}
+		input.BlockDeviceMappings = append(input.BlockDeviceMappings, ec2types.BlockDeviceMapping{
+			DeviceName: aws.String(d.deviceName),
+			Ebs:        ebs,
+		})
+	}
+	if len(spec.tags) > 0 {
+		tags := toEC2Tags(spec.tags)
+		input.TagSpecifications = []ec2types.TagSpecification{
+			{ResourceType: ec2types.ResourceTypeInstance, Tags: tags},
+			{ResourceType: ec2types.ResourceTypeVolume, Tags: tags},
+		}
 	}
 
-	result, err := c.client.RunInstances(ctx, input)
+	result, err := c.runInstances(ctx, input, spec.timeouts.create)
 	if err != nil {
-		return "", fmt.Errorf("failed to create instance: %w", err)
+		return nil, fmt.Errorf("failed to create instance: %w", err)
 	}
 
 	if len(result.Instances) == 0 {
-		return "", fmt.Errorf("no instance created")
+		return nil, fmt.Errorf("no instance created")
+	}
+
+	// The instance exists from here on, so it is returned even if waiting
+	// fails, letting the caller track it
+	launched := result.Instances[0]
+	instance, err := c.waitUntilReady(ctx, aws.ToString(launched.InstanceId), spec.readiness, spec.timeouts.create)
+	if err != nil {
+		return instanceResource(launched), err
+	}
+	return instanceResource(*instance), nil
+}
+
+// ReadInstance returns the current state of an instance. Terminated
+// instances are reported as provider.ErrNotFound.
+func (c *EC2Client) ReadInstance(ctx context.Context, id string) (*types.BaseResource, error) {
+	instance, err := c.describeInstance(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return instanceResource(*instance), nil
+}
+
+// FindInstanceByToken returns the live instance launched with the given
+// client token
+func (c *EC2Client) FindInstanceByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		Filters: []ec2types.Filter{{Name: aws.String("client-token"), Values: []string{token}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find instance by token: %w", err)
+	}
+	for _, r := range result.Reservations {
+		for _, instance := range r.Instances {
+			if !isTerminated(instance) {
+				return instanceResource(instance), nil
+			}
+		}
+	}
+	return nil, provider.ErrNotFound
+}
+
+// UpdateInstance brings a running instance in line with config. Instance
+// type and user data changes stop and restart the instance, while the
+// instance profile is swapped while it runs. The AMI, subnet and key pair
+// are fixed at launch and changing them is an error. Block devices are only
+// applied at launch. A restarted instance is waited on as
+// it is after create, bounded by the update timeout.
+func (c *EC2Client) UpdateInstance(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseInstanceSpec(config)
+	if err != nil {
+		return err
+	}
+
+	id := resource.GetID()
+	instance, err := c.describeInstance(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for attr, values := range map[string][2]string{
+		"ami":       {spec.ami, aws.ToString(instance.ImageId)},
+		"subnet_id": {spec.subnetID, aws.ToString(instance.SubnetId)},
+		"key_name":  {spec.keyName, aws.ToString(instance.KeyName)},
+	} {
+		if values[0] != "" && values[0] != values[1] {
+			return fmt.Errorf("changing %s of instance %s requires replacing it", attr, id)
+		}
+	}
+
+	if len(spec.securityGroups) > 0 && !sameStrings(spec.securityGroups, instanceGroupIDs(*instance)) {
+		_, err := c.client.ModifyInstanceAttribute(ctx, &ec2.ModifyInstanceAttributeInput{
+			InstanceId: aws.String(id),
+			Groups:     spec.securityGroups,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update security groups of %s: %w", id, err)
+		}
+	}
+
+	if err := c.updateTags(ctx, id, fromEC2Tags(instance.Tags), spec.tags); err != nil {
+		return err
+	}
+
+	if spec.profile != instanceProfileName(*instance) {
+		if err := c.updateInstanceProfile(ctx, id, spec.profile); err != nil {
+			return err
+		}
+	}
+
+	var stopped []func() (*ec2.ModifyInstanceAttributeInput, string)
+	if spec.instanceType != string(instance.InstanceType) {
+		stopped = append(stopped, func() (*ec2.ModifyInstanceAttributeInput, string) {
+			return &ec2.ModifyInstanceAttributeInput{
+				InstanceId:   aws.String(id),
+				InstanceType: &ec2types.AttributeValue{Value: aws.String(spec.instanceType)},
+			}, "instance type"
+		})
+	}
+	currentUserData, err := c.userData(ctx, id)
+	if err != nil {
+		return err
+	}
+	if spec.userData != currentUserData {
+		stopped = append(stopped, func() (*ec2.ModifyInstanceAttributeInput, string) {
+			return &ec2.ModifyInstanceAttributeInput{
+				InstanceId: aws.String(id),
+				UserData:   &ec2types.BlobAttributeValue{Value: []byte(spec.userData)},
+			}, "user data"
+		})
+	}
+	if len(stopped) == 0 {
+		return nil
+	}
+
+	// These attributes can only be changed while the instance is stopped
+	wasRunning := instance.State != nil && instance.State.Name == ec2types.InstanceStateNameRunning
+	if _, err := c.client.StopInstances(ctx, &ec2.StopInstancesInput{InstanceIds: []string{id}}); err != nil {
+		return fmt.Errorf("failed to stop instance %s: %w", id, err)
+	}
+	if err := c.waitForState(ctx, id, ec2types.InstanceStateNameStopped, spec.timeouts.update); err != nil {
+		return err
+	}
+
+	for _, modify := range stopped {
+		input, what := modify()
+		if _, err := c.client.ModifyInstanceAttribute(ctx, input); err != nil {
+			return fmt.Errorf("failed to update %s of %s: %w", what, id, err)
+		}
+	}
+
+	if !wasRunning {
+		return nil
+	}
+	if _, err := c.client.StartInstances(ctx, &ec2.StartInstancesInput{InstanceIds: []string{id}}); err != nil {
+		return fmt.Errorf("failed to start instance %s: %w", id, err)
+	}
+	_, err = c.waitUntilReady(ctx, id, spec.readiness, spec.timeouts.update)
+	return err
+}
+
+// DeleteInstance terminates an instance. Instances that are already gone
+// are not an error.
+func (c *EC2Client) DeleteInstance(ctx context.Context, resource provider.Resource) error {
+	_, err := c.client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []string{resource.GetID()},
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to terminate instance %s: %w", resource.GetID(), err)
+	}
+	return nil
+}
+
+// runInstances launches an instance, retrying while EC2 does not yet know
+// its instance profile. A profile created moments earlier takes a few
+// seconds to reach EC2.
+func (c *EC2Client) runInstances(ctx context.Context, input *ec2.RunInstancesInput, timeout time.Duration) (*ec2.RunInstancesOutput, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		result, err := c.client.RunInstances(ctx, input)
+		if err == nil || input.IamInstanceProfile == nil || !isProfilePropagating(err) {
+			return result, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out after %s waiting for instance profile %s: %w", timeout, aws.ToString(input.IamInstanceProfile.Name), err)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// updateInstanceProfile associates, replaces or removes the instance
+// profile of a running instance
+func (c *EC2Client) updateInstanceProfile(ctx context.Context, id, profile string) error {
+	result, err := c.client.DescribeIamInstanceProfileAssociations(ctx, &ec2.DescribeIamInstanceProfileAssociationsInput{
+		Filters: []ec2types.Filter{{Name: aws.String("instance-id"), Values: []string{id}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read instance profile of %s: %w", id, err)
+	}
+	var associationID *string
+	for _, a := range result.IamInstanceProfileAssociations {
+		if a.State == ec2types.IamInstanceProfileAssociationStateAssociated || a.State == ec2types.IamInstanceProfileAssociationStateAssociating {
+			associationID = a.AssociationId
+		}
+	}
+
+	spec := &ec2types.IamInstanceProfileSpecification{Name: aws.String(profile)}
+	switch {
+	case associationID == nil && profile != "":
+		_, err = c.client.AssociateIamInstanceProfile(ctx, &ec2.AssociateIamInstanceProfileInput{
+			InstanceId:         aws.String(id),
+			IamInstanceProfile: spec,
+		})
+	case associationID != nil && profile != "":
+		_, err = c.client.ReplaceIamInstanceProfileAssociation(ctx, &ec2.ReplaceIamInstanceProfileAssociationInput{
+			AssociationId:      associationID,
+			IamInstanceProfile: spec,
+		})
+	case associationID != nil:
+		_, err = c.client.DisassociateIamInstanceProfile(ctx, &ec2.DisassociateIamInstanceProfileInput{
+			AssociationId: associationID,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update instance profile of %s: %w", id, err)
+	}
+	return nil
+}
+
+// isProfilePropagating reports whether EC2 rejected an instance profile it
+// does not know about yet
+func isProfilePropagating(err error) bool {
+	return errorCode(err) == "InvalidParameterValue" && strings.Contains(strings.ToLower(err.Error()), "instance profile")
+}
+
+func (c *EC2Client) describeInstance(ctx context.Context, id string) (*ec2types.Instance, error) {
+	result, err := c.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{id},
+	})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("instance %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe instance %s: %w", id, err)
+	}
+	for _, r := range result.Reservations {
+		for _, instance := range r.Instances {
+			if aws.ToString(instance.InstanceId) == id && !isTerminated(instance) {
+				return &instance, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("instance %s: %w", id, provider.ErrNotFound)
+}
+
+func (c *EC2Client) userData(ctx context.Context, id string) (string, error) {
+	result, err := c.client.DescribeInstanceAttribute(ctx, &ec2.DescribeInstanceAttributeInput{
+		InstanceId: aws.String(id),
+		Attribute:  ec2types.InstanceAttributeNameUserData,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read user data of %s: %w", id, err)
+	}
+	if result.UserData == nil || result.UserData.Value == nil {
+		return "", nil
+	}
+	data, err := base64.StdEncoding.DecodeString(*result.UserData.Value)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode user data of %s: %w", id, err)
+	}
+	return string(data), nil
+}
+
+// updateTags applies the difference between current and desired tags.
+// AWS-reserved tags are never removed.
+func (c *EC2Client) updateTags(ctx context.Context, id string, current, desired map[string]string) error {
+	if desired == nil {
+		return nil
+	}
+
+	changed := make(map[string]string)
+	for k, v := range desired {
+		if cur, ok := current[k]; !ok || cur != v {
+			changed[k] = v
+		}
+	}
+	var removed []ec2types.Tag
+	for _, k := range sortedKeys(current) {
+		if _, ok := desired[k]; !ok && !isReservedTag(k) {
+			removed = append(removed, ec2types.Tag{Key: aws.String(k)})
+		}
+	}
+
+	if len(changed) > 0 {
+		_, err := c.client.CreateTags(ctx, &ec2.CreateTagsInput{
+			Resources: []string{id},
+			Tags:      toEC2Tags(changed),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to tag %s: %w", id, err)
+		}
+	}
+	if len(removed) > 0 {
+		_, err := c.client.DeleteTags(ctx, &ec2.DeleteTagsInput{
+			Resources: []string{id},
+			Tags:      removed,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to remove tags from %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// instanceResource converts an EC2 instance into a duet resource
+func instanceResource(instance ec2types.Instance) *types.BaseResource {
+	var devices []interface{}
+	for _, m := range instance.BlockDeviceMappings {
+		device := map[string]interface{}{"device_name": aws.ToString(m.DeviceName)}
+		if m.Ebs != nil {
+			device["volume_id"] = aws.ToString(m.Ebs.VolumeId)
+			device["delete_on_termination"] = aws.ToBool(m.Ebs.DeleteOnTermination)
+		}
+		devices = append(devices, device)
 	}
 
-	return *result.Instances[0].InstanceId, nil
+	groups := make([]interface{}, 0, len(instance.SecurityGroups))
+	for _, id := range instanceGroupIDs(instance) {
+		groups = append(groups, id)
+	}
+
+	state := ""
+	if instance.State != nil {
+		state = string(instance.State.Name)
+	}
+	az := ""
+	if instance.Placement != nil {
+		az = aws.ToString(instance.Placement.AvailabilityZone)
+	}
+
+	// host is the address config management connects to
+	host := aws.ToString(instance.PublicIpAddress)
+	if host == "" {
+		host = aws.ToString(instance.PrivateIpAddress)
+	}
+
+	launched := aws.ToTime(instance.LaunchTime)
+	return &types.BaseResource{
+		ID:       aws.ToString(instance.InstanceId),
+		Type:     types.ResourceTypeInstance,
+		Provider: "aws",
+		Status:   instanceStatus(state),
+		Metadata: map[string]interface{}{
+			"instance_id":          aws.ToString(instance.InstanceId),
+			"instance_type":        string(instance.InstanceType),
+			"ami":                  aws.ToString(instance.ImageId),
+			"subnet_id":            aws.ToString(instance.SubnetId),
+			"vpc_id":               aws.ToString(instance.VpcId),
+			"key_name":             aws.ToString(instance.KeyName),
+			"iam_instance_profile": instanceProfileName(instance),
+			"security_groups":      groups,
+			"availability_zone":    az,
+			"private_ip":           aws.ToString(instance.PrivateIpAddress),
+			"public_ip":            aws.ToString(instance.PublicIpAddress),
+			"private_dns":          aws.ToString(instance.PrivateDnsName),
+			"public_dns":           aws.ToString(instance.PublicDnsName),
+			"host":                 host,
+			"state":                state,
+			"block_devices":        devices,
+		},
+		Tags:      fromEC2Tags(instance.Tags),
+		CreatedAt: launched,
+		UpdatedAt: time.Now(),
+	}
+}
+
+// instanceProfileName returns the name of an instance's instance profile,
+// which EC2 only reports as an ARN
+func instanceProfileName(instance ec2types.Instance) string {
+	if instance.IamInstanceProfile == nil {
+		return ""
+	}
+	arn := aws.ToString(instance.IamInstanceProfile.Arn)
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+func instanceStatus(state string) types.ResourceStatus {
+	switch ec2types.InstanceStateName(state) {
+	case ec2types.InstanceStateNamePending:
+		return types.StatusCreating
+	case ec2types.InstanceStateNameRunning:
+		return types.StatusRunning
+	case ec2types.InstanceStateNameShuttingDown:
+		return types.StatusDeleting
+	case ec2types.InstanceStateNameTerminated:
+		return types.StatusDeleted
+	default:
+		return types.StatusUnavailable
+	}
+}
+
+func instanceGroupIDs(instance ec2types.Instance) []string {
+	ids := make([]string, 0, len(instance.SecurityGroups))
+	for _, g := range instance.SecurityGroups {
+		ids = append(ids, aws.ToString(g.GroupId))
+	}
+	return ids
+}
+
+func isTerminated(instance ec2types.Instance) bool {
+	return instance.State != nil && instance.State.Name == ec2types.InstanceStateNameTerminated
+}
+
+func toEC2Tags(tags map[string]string) []ec2types.Tag {
+	out := make([]ec2types.Tag, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		out = append(out, ec2types.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return out
+}
+
+func fromEC2Tags(tags []ec2types.Tag) map[string]string {
+	out := make(map[string]string, len(tags))
+	for _, t := range tags {
+		out[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return out
+}
+
+// isReservedTag reports whether key is in the aws: namespace, which users
+// cannot modify, or the duet: namespace, which duet manages itself
+func isReservedTag(key string) bool {
+	return strings.HasPrefix(key, "aws:") || strings.HasPrefix(key, "duet:")
+}
+
+// sameStrings reports whether a and b hold the same strings in any order
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, s := range a {
+		counts[s]++
+	}
+	for _, s := range b {
+		counts[s]--
+		if counts[s] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isNotFound reports whether err is an AWS "does not exist" error, such as
+// InvalidVpcID.NotFound or NatGatewayNotFound
+func isNotFound(err error) bool {
+	code := errorCode(err)
+	return code == "InvalidInstanceID.Malformed" || strings.HasSuffix(code, "NotFound")
+}
+
+// errorCode returns the AWS error code of err, or "" if it is not an API
+// error
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return ""
+	}
+	return apiErr.ErrorCode()
 }
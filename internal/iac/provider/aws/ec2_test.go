@@ -0,0 +1,223 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/internal/iac/provider/aws/awstest"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// newTestProvider returns a provider pointed at a fresh EC2 stand-in, with
+// static credentials so no real AWS configuration is consulted
+func newTestProvider(t *testing.T) (*AWSProvider, *awstest.EC2) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+
+	ec2Server, server := awstest.NewEC2Server()
+	t.Cleanup(server.Close)
+
+	p, err := NewAWSProvider(context.Background(), "us-east-1", WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	p.ec2Client.pollInterval = time.Millisecond
+	return p, ec2Server
+}
+
+func TestEC2Instances(t *testing.T) {
+	p, ec2Server := newTestProvider(t)
+	ctx := context.Background()
+
+	config := map[string]interface{}{
+		"ami":             "ami-12345678",
+		"instance_type":   "t3.micro",
+		"subnet_id":       "subnet-1",
+		"key_name":        "deployer",
+		"security_groups": []interface{}{"sg-1", "sg-2"},
+		"user_data":       "#!/bin/sh\necho hello\n",
+		"tags":            map[string]interface{}{"Name": "web", "env": "test"},
+		"block_devices": []interface{}{
+			map[string]interface{}{"device_name": "/dev/xvda", "volume_size": 20, "volume_type": "gp3"},
+		},
+	}
+
+	var id string
+	t.Run("Create", func(t *testing.T) {
+		ctx := provider.WithIdempotencyToken(ctx, "run-1-web")
+		res, err := p.Create(ctx, "instance", config)
+		if err != nil {
+			t.Fatalf("Failed to create instance: %v", err)
+		}
+		id = res.GetID()
+
+		inst, ok := ec2Server.Instance(id)
+		if !ok {
+			t.Fatalf("Expected instance %s to exist", id)
+		}
+		if inst.ImageID != "ami-12345678" || inst.InstanceType != "t3.micro" || inst.SubnetID != "subnet-1" || inst.KeyName != "deployer" {
+			t.Errorf("Unexpected instance attributes: %+v", inst)
+		}
+		if inst.UserData != "#!/bin/sh\necho hello\n" {
+			t.Errorf("Expected user data to round-trip, got %q", inst.UserData)
+		}
+		if len(inst.SecurityGroups) != 2 || inst.SecurityGroups[0] != "sg-1" {
+			t.Errorf("Expected security groups [sg-1 sg-2], got %v", inst.SecurityGroups)
+		}
+		if inst.Tags["Name"] != "web" || inst.Tags["env"] != "test" {
+			t.Errorf("Expected tags to be applied, got %v", inst.Tags)
+		}
+		if len(inst.BlockDevices) != 1 || inst.BlockDevices[0].DeviceName != "/dev/xvda" || !inst.BlockDevices[0].DeleteOnTermination {
+			t.Errorf("Unexpected block devices: %+v", inst.BlockDevices)
+		}
+		if inst.ClientToken != "run-1-web" {
+			t.Errorf("Expected client token run-1-web, got %q", inst.ClientToken)
+		}
+
+		if res.GetType() != types.ResourceTypeInstance || res.GetProvider() != "aws" {
+			t.Errorf("Unexpected resource identity: %s/%s", res.GetProvider(), res.GetType())
+		}
+		if res.GetStatus() != types.StatusRunning {
+			t.Errorf("Expected status running, got %s", res.GetStatus())
+		}
+		meta := res.GetMetadata()
+		if meta["instance_id"] != id || meta["ami"] != "ami-12345678" || meta["private_ip"] == "" {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+		if res.GetTags()["Name"] != "web" {
+			t.Errorf("Expected Name tag on resource, got %v", res.GetTags())
+		}
+	})
+
+	t.Run("CreateIsIdempotent", func(t *testing.T) {
+		ctx := provider.WithIdempotencyToken(ctx, "run-1-web")
+		res, err := p.Create(ctx, "instance", config)
+		if err != nil {
+			t.Fatalf("Failed to retry create: %v", err)
+		}
+		if res.GetID() != id {
+			t.Errorf("Expected retried create to return %s, got %s", id, res.GetID())
+		}
+	})
+
+	t.Run("FindByToken", func(t *testing.T) {
+		res, err := p.FindByToken(ctx, "instance", "run-1-web")
+		if err != nil {
+			t.Fatalf("Failed to find instance by token: %v", err)
+		}
+		if res.GetID() != id {
+			t.Errorf("Expected %s, got %s", id, res.GetID())
+		}
+		if _, err := p.FindByToken(ctx, "instance", "unknown"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Read", func(t *testing.T) {
+		res, err := p.Read(ctx, "instance", id)
+		if err != nil {
+			t.Fatalf("Failed to read instance: %v", err)
+		}
+		if res.GetMetadata()["instance_type"] != "t3.micro" {
+			t.Errorf("Expected instance_type t3.micro, got %v", res.GetMetadata()["instance_type"])
+		}
+		if _, err := p.Read(ctx, "instance", "i-missing"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		res, err := p.Read(ctx, "instance", id)
+		if err != nil {
+			t.Fatalf("Failed to read instance: %v", err)
+		}
+
+		updated := make(map[string]interface{})
+		for k, v := range config {
+			updated[k] = v
+		}
+		updated["instance_type"] = "t3.small"
+		updated["security_groups"] = []interface{}{"sg-3"}
+		updated["tags"] = map[string]interface{}{"Name": "web-1"}
+
+		if err := p.Update(ctx, res, updated); err != nil {
+			t.Fatalf("Failed to update instance: %v", err)
+		}
+
+		inst, _ := ec2Server.Instance(id)
+		if inst.InstanceType != "t3.small" {
+			t.Errorf("Expected instance type t3.small, got %s", inst.InstanceType)
+		}
+		if inst.State != "running" {
+			t.Errorf("Expected instance to be restarted, got %s", inst.State)
+		}
+		if len(inst.SecurityGroups) != 1 || inst.SecurityGroups[0] != "sg-3" {
+			t.Errorf("Expected security groups [sg-3], got %v", inst.SecurityGroups)
+		}
+		if len(inst.Tags) != 1 || inst.Tags["Name"] != "web-1" {
+			t.Errorf("Expected tags {Name: web-1}, got %v", inst.Tags)
+		}
+		if ec2Server.Calls("StopInstances") != 1 || ec2Server.Calls("StartInstances") != 1 {
+			t.Errorf("Expected one stop and one start, got %d and %d", ec2Server.Calls("StopInstances"), ec2Server.Calls("StartInstances"))
+		}
+
+		// Nothing changed, so nothing should be stopped
+		if err := p.Update(ctx, res, updated); err != nil {
+			t.Fatalf("Failed to update instance: %v", err)
+		}
+		if ec2Server.Calls("StopInstances") != 1 {
+			t.Errorf("Expected no-op update not to stop the instance")
+		}
+	})
+
+	t.Run("UpdateRequiresReplacement", func(t *testing.T) {
+		res, err := p.Read(ctx, "instance", id)
+		if err != nil {
+			t.Fatalf("Failed to read instance: %v", err)
+		}
+		err = p.Update(ctx, res, map[string]interface{}{"ami": "ami-87654321", "instance_type": "t3.small"})
+		if err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected replacement error, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		res, err := p.Read(ctx, "instance", id)
+		if err != nil {
+			t.Fatalf("Failed to read instance: %v", err)
+		}
+		if err := p.Delete(ctx, res); err != nil {
+			t.Fatalf("Failed to delete instance: %v", err)
+		}
+		if _, err := p.Read(ctx, "instance", id); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected terminated instance to be not found, got %v", err)
+		}
+		if err := p.Delete(ctx, res); err != nil {
+			t.Errorf("Expected deleting a terminated instance to succeed, got %v", err)
+		}
+		if _, err := p.FindByToken(ctx, "instance", "run-1-web"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected terminated instance not to be found by token, got %v", err)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		if _, err := p.Create(ctx, "instance", map[string]interface{}{"instance_type": "t3.micro"}); err == nil {
+			t.Error("Expected error for missing ami, got nil")
+		}
+		if _, err := p.Create(ctx, "instance", map[string]interface{}{"ami": "ami-1", "instance_type": 3}); err == nil {
+			t.Error("Expected error for non-string instance_type, got nil")
+		}
+		if _, err := p.Create(ctx, "database", map[string]interface{}{}); err == nil {
+			t.Error("Expected error for unsupported resource type, got nil")
+		}
+	})
+}
@@ -0,0 +1,1553 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elb "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbtypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// maxTagARNs is how many resources one DescribeTags call accepts
+const maxTagARNs = 20
+
+type ELBClient struct {
+	client       *elb.Client
+	pollInterval time.Duration
+}
+
+func NewELBClient(cfg aws.Config) (*ELBClient, error) {
+	return &ELBClient{
+		client:       elb.NewFromConfig(cfg),
+		pollInterval: defaultPollInterval,
+	}, nil
+}
+
+// loadBalancerSpec is the desired configuration of an application or
+// network load balancer
+type loadBalancerSpec struct {
+	tags           map[string]string
+	name           string
+	lbType         string
+	subnets        []string
+	securityGroups []string
+	timeouts       timeouts
+	internal       bool
+}
+
+func parseLoadBalancerSpec(config map[string]interface{}) (*loadBalancerSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("name", "subnets")
+
+	spec := &loadBalancerSpec{
+		name:           attrs.String("name"),
+		lbType:         attrs.String("load_balancer_type"),
+		internal:       attrs.Bool("internal"),
+		subnets:        attrs.StringList("subnets"),
+		securityGroups: attrs.StringList("security_groups"),
+		tags:           attrs.StringMap("tags"),
+		timeouts:       parseTimeouts(attrs),
+	}
+	if spec.lbType == "" {
+		spec.lbType = string(elbtypes.LoadBalancerTypeEnumApplication)
+	}
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	switch elbtypes.LoadBalancerTypeEnum(spec.lbType) {
+	case elbtypes.LoadBalancerTypeEnumApplication:
+	case elbtypes.LoadBalancerTypeEnumNetwork:
+		if len(spec.securityGroups) > 0 {
+			return nil, fmt.Errorf("security_groups can only be set on application load balancers")
+		}
+	default:
+		return nil, fmt.Errorf("load_balancer_type must be application or network, got %q", spec.lbType)
+	}
+	return spec, nil
+}
+
+func (s *loadBalancerSpec) scheme() elbtypes.LoadBalancerSchemeEnum {
+	if s.internal {
+		return elbtypes.LoadBalancerSchemeEnumInternal
+	}
+	return elbtypes.LoadBalancerSchemeEnumInternetFacing
+}
+
+// CreateLoadBalancer creates a load balancer and waits for it to become
+// active. Load balancers are named by the config, so the idempotency token
+// tag only lets a retried create adopt its own load balancer.
+func (c *ELBClient) CreateLoadBalancer(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseLoadBalancerSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var arn string
+	result, err := c.client.CreateLoadBalancer(ctx, &elb.CreateLoadBalancerInput{
+		Name:           aws.String(spec.name),
+		Type:           elbtypes.LoadBalancerTypeEnum(spec.lbType),
+		Scheme:         spec.scheme(),
+		Subnets:        spec.subnets,
+		SecurityGroups: spec.securityGroups,
+		Tags:           elbCreateTags(ctx, spec.tags),
+	})
+	switch {
+	case errorCode(err) == "DuplicateLoadBalancerName":
+		existing, err := adopt(ctx, "load balancer", spec.name, c.readLoadBalancerByName)
+		if err != nil {
+			return nil, err
+		}
+		arn = existing.ID
+	case err != nil:
+		return nil, fmt.Errorf("failed to create load balancer %s: %w", spec.name, err)
+	case len(result.LoadBalancers) == 0:
+		return nil, fmt.Errorf("failed to create load balancer %s: no load balancer returned", spec.name)
+	default:
+		arn = aws.ToString(result.LoadBalancers[0].LoadBalancerArn)
+	}
+
+	if _, err := c.waitForLoadBalancer(ctx, arn, spec.timeouts.create); err != nil {
+		return &types.BaseResource{
+			ID:       arn,
+			Type:     types.ResourceTypeLoadBalancer,
+			Provider: "aws",
+			Status:   types.StatusCreating,
+		}, err
+	}
+	return c.ReadLoadBalancer(ctx, arn)
+}
+
+// ReadLoadBalancer returns the current state of a load balancer
+func (c *ELBClient) ReadLoadBalancer(ctx context.Context, arn string) (*types.BaseResource, error) {
+	lb, err := c.describeLoadBalancer(ctx, arn)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := c.describeTags(ctx, arn)
+	if err != nil {
+		return nil, err
+	}
+	return loadBalancerResource(*lb, tags[arn]), nil
+}
+
+func (c *ELBClient) readLoadBalancerByName(ctx context.Context, name string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeLoadBalancers(ctx, &elb.DescribeLoadBalancersInput{Names: []string{name}})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("load balancer %s: %w", name, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read load balancer %s: %w", name, err)
+	}
+	if len(result.LoadBalancers) == 0 {
+		return nil, fmt.Errorf("load balancer %s: %w", name, provider.ErrNotFound)
+	}
+	return c.ReadLoadBalancer(ctx, aws.ToString(result.LoadBalancers[0].LoadBalancerArn))
+}
+
+// FindLoadBalancerByToken returns the load balancer created with the given
+// idempotency token. Load balancers cannot be filtered by tag, so their
+// tags are read in batches.
+func (c *ELBClient) FindLoadBalancerByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	arns, err := c.loadBalancerARNs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find load balancer by token: %w", err)
+	}
+	arn, err := c.findTagged(ctx, arns, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find load balancer by token: %w", err)
+	}
+	if arn == "" {
+		return nil, provider.ErrNotFound
+	}
+	return c.ReadLoadBalancer(ctx, arn)
+}
+
+// UpdateLoadBalancer applies changes to a load balancer's subnets,
+// security groups and tags. Its name, type and scheme are fixed at
+// creation.
+func (c *ELBClient) UpdateLoadBalancer(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseLoadBalancerSpec(config)
+	if err != nil {
+		return err
+	}
+
+	arn := resource.GetID()
+	lb, err := c.describeLoadBalancer(ctx, arn)
+	if err != nil {
+		return err
+	}
+	for attr, values := range map[string][2]string{
+		"name":               {spec.name, aws.ToString(lb.LoadBalancerName)},
+		"load_balancer_type": {spec.lbType, string(lb.Type)},
+		"internal":           {string(spec.scheme()), string(lb.Scheme)},
+	} {
+		if values[0] != values[1] {
+			return fmt.Errorf("changing %s of load balancer %s requires replacing it", attr, spec.name)
+		}
+	}
+
+	if !sameStrings(spec.subnets, loadBalancerSubnets(*lb)) {
+		_, err := c.client.SetSubnets(ctx, &elb.SetSubnetsInput{
+			LoadBalancerArn: aws.String(arn),
+			Subnets:         spec.subnets,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set subnets of load balancer %s: %w", spec.name, err)
+		}
+	}
+	if lb.Type == elbtypes.LoadBalancerTypeEnumApplication && !sameStrings(spec.securityGroups, lb.SecurityGroups) {
+		_, err := c.client.SetSecurityGroups(ctx, &elb.SetSecurityGroupsInput{
+			LoadBalancerArn: aws.String(arn),
+			SecurityGroups:  spec.securityGroups,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set security groups of load balancer %s: %w", spec.name, err)
+		}
+	}
+
+	tags, err := c.describeTags(ctx, arn)
+	if err != nil {
+		return err
+	}
+	return c.updateTags(ctx, arn, tags[arn], spec.tags)
+}
+
+// DeleteLoadBalancer deletes a load balancer, along with its listeners,
+// and waits until it is gone
+func (c *ELBClient) DeleteLoadBalancer(ctx context.Context, resource provider.Resource) error {
+	arn := resource.GetID()
+	_, err := c.client.DeleteLoadBalancer(ctx, &elb.DeleteLoadBalancerInput{LoadBalancerArn: aws.String(arn)})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete load balancer %s: %w", arn, err)
+	}
+	return c.waitForLoadBalancerDeleted(ctx, arn, defaultDeleteTimeout)
+}
+
+func (c *ELBClient) describeLoadBalancer(ctx context.Context, arn string) (*elbtypes.LoadBalancer, error) {
+	result, err := c.client.DescribeLoadBalancers(ctx, &elb.DescribeLoadBalancersInput{LoadBalancerArns: []string{arn}})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("load balancer %s: %w", arn, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read load balancer %s: %w", arn, err)
+	}
+	if len(result.LoadBalancers) == 0 {
+		return nil, fmt.Errorf("load balancer %s: %w", arn, provider.ErrNotFound)
+	}
+	return &result.LoadBalancers[0], nil
+}
+
+func (c *ELBClient) loadBalancerARNs(ctx context.Context) ([]string, error) {
+	var arns []string
+	pages := elb.NewDescribeLoadBalancersPaginator(c.client, &elb.DescribeLoadBalancersInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, lb := range page.LoadBalancers {
+			arns = append(arns, aws.ToString(lb.LoadBalancerArn))
+		}
+	}
+	return arns, nil
+}
+
+func loadBalancerSubnets(lb elbtypes.LoadBalancer) []string {
+	subnets := make([]string, 0, len(lb.AvailabilityZones))
+	for _, z := range lb.AvailabilityZones {
+		subnets = append(subnets, aws.ToString(z.SubnetId))
+	}
+	return subnets
+}
+
+func loadBalancerStatus(lb elbtypes.LoadBalancer) types.ResourceStatus {
+	if lb.State == nil {
+		return types.StatusPending
+	}
+	switch lb.State.Code {
+	case elbtypes.LoadBalancerStateEnumActive:
+		return types.StatusRunning
+	case elbtypes.LoadBalancerStateEnumProvisioning:
+		return types.StatusCreating
+	case elbtypes.LoadBalancerStateEnumActiveImpaired:
+		return types.StatusUnavailable
+	default:
+		return types.StatusFailed
+	}
+}
+
+func loadBalancerResource(lb elbtypes.LoadBalancer, tags map[string]string) *types.BaseResource {
+	state := ""
+	if lb.State != nil {
+		state = string(lb.State.Code)
+	}
+	return &types.BaseResource{
+		ID:       aws.ToString(lb.LoadBalancerArn),
+		Type:     types.ResourceTypeLoadBalancer,
+		Provider: "aws",
+		Status:   loadBalancerStatus(lb),
+		Metadata: map[string]interface{}{
+			"arn":                aws.ToString(lb.LoadBalancerArn),
+			"name":               aws.ToString(lb.LoadBalancerName),
+			"dns_name":           aws.ToString(lb.DNSName),
+			"zone_id":            aws.ToString(lb.CanonicalHostedZoneId),
+			"vpc_id":             aws.ToString(lb.VpcId),
+			"load_balancer_type": string(lb.Type),
+			"internal":           lb.Scheme == elbtypes.LoadBalancerSchemeEnumInternal,
+			"subnets":            interfaceList(loadBalancerSubnets(lb)),
+			"security_groups":    interfaceList(lb.SecurityGroups),
+			"state":              state,
+		},
+		Tags:      tags,
+		CreatedAt: aws.ToTime(lb.CreatedTime),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// targetGroupSpec is the desired configuration of a target group
+type targetGroupSpec struct {
+	tags        map[string]string
+	healthCheck healthCheckSpec
+	name        string
+	protocol    string
+	vpcID       string
+	targetType  string
+	port        int
+}
+
+// healthCheckSpec holds the health check settings given in the config.
+// Settings left out keep their AWS defaults.
+type healthCheckSpec struct {
+	enabled            *bool
+	protocol           string
+	port               string
+	path               string
+	matcher            string
+	interval           int
+	timeout            int
+	healthyThreshold   int
+	unhealthyThreshold int
+}
+
+func parseTargetGroupSpec(config map[string]interface{}) (*targetGroupSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("name", "port", "vpc_id")
+
+	spec := &targetGroupSpec{
+		name:       attrs.String("name"),
+		port:       attrs.Int("port"),
+		protocol:   attrs.String("protocol"),
+		vpcID:      attrs.String("vpc_id"),
+		targetType: attrs.String("target_type"),
+		tags:       attrs.StringMap("tags"),
+	}
+	if spec.protocol == "" {
+		spec.protocol = string(elbtypes.ProtocolEnumHttp)
+	}
+	if spec.targetType == "" {
+		spec.targetType = string(elbtypes.TargetTypeEnumInstance)
+	}
+
+	hc := attrs.Table("health_check")
+	spec.healthCheck = healthCheckSpec{
+		protocol:           hc.String("protocol"),
+		port:               hc.String("port"),
+		path:               hc.String("path"),
+		matcher:            hc.String("matcher"),
+		interval:           hc.Int("interval"),
+		timeout:            hc.Int("timeout"),
+		healthyThreshold:   hc.Int("healthy_threshold"),
+		unhealthyThreshold: hc.Int("unhealthy_threshold"),
+	}
+	if hc.Has("enabled") {
+		enabled := hc.Bool("enabled")
+		spec.healthCheck.enabled = &enabled
+	}
+	attrs.collectTable("health_check", hc)
+
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// differs reports whether any setting given in the config differs from the
+// target group's
+func (h healthCheckSpec) differs(tg elbtypes.TargetGroup) bool {
+	matcher := ""
+	if tg.Matcher != nil {
+		matcher = aws.ToString(tg.Matcher.HttpCode)
+	}
+	for _, s := range [][2]string{
+		{h.protocol, string(tg.HealthCheckProtocol)},
+		{h.port, aws.ToString(tg.HealthCheckPort)},
+		{h.path, aws.ToString(tg.HealthCheckPath)},
+		{h.matcher, matcher},
+	} {
+		if s[0] != "" && s[0] != s[1] {
+			return true
+		}
+	}
+	for _, n := range [][2]int{
+		{h.interval, int(aws.ToInt32(tg.HealthCheckIntervalSeconds))},
+		{h.timeout, int(aws.ToInt32(tg.HealthCheckTimeoutSeconds))},
+		{h.healthyThreshold, int(aws.ToInt32(tg.HealthyThresholdCount))},
+		{h.unhealthyThreshold, int(aws.ToInt32(tg.UnhealthyThresholdCount))},
+	} {
+		if n[0] != 0 && n[0] != n[1] {
+			return true
+		}
+	}
+	return h.enabled != nil && *h.enabled != aws.ToBool(tg.HealthCheckEnabled)
+}
+
+func (h healthCheckSpec) matcherInput() *elbtypes.Matcher {
+	if h.matcher == "" {
+		return nil
+	}
+	return &elbtypes.Matcher{HttpCode: aws.String(h.matcher)}
+}
+
+// CreateTargetGroup creates a target group for load balancers to forward to
+func (c *ELBClient) CreateTargetGroup(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseTargetGroupSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	hc := spec.healthCheck
+	result, err := c.client.CreateTargetGroup(ctx, &elb.CreateTargetGroupInput{
+		Name:                       aws.String(spec.name),
+		Port:                       aws.Int32(int32(spec.port)),
+		Protocol:                   elbtypes.ProtocolEnum(spec.protocol),
+		VpcId:                      aws.String(spec.vpcID),
+		TargetType:                 elbtypes.TargetTypeEnum(spec.targetType),
+		HealthCheckEnabled:         hc.enabled,
+		HealthCheckProtocol:        elbtypes.ProtocolEnum(hc.protocol),
+		HealthCheckPort:            optionalString(hc.port),
+		HealthCheckPath:            optionalString(hc.path),
+		HealthCheckIntervalSeconds: optionalInt32(hc.interval),
+		HealthCheckTimeoutSeconds:  optionalInt32(hc.timeout),
+		HealthyThresholdCount:      optionalInt32(hc.healthyThreshold),
+		UnhealthyThresholdCount:    optionalInt32(hc.unhealthyThreshold),
+		Matcher:                    hc.matcherInput(),
+		Tags:                       elbCreateTags(ctx, spec.tags),
+	})
+	switch {
+	case errorCode(err) == "DuplicateTargetGroupName":
+		return adopt(ctx, "target group", spec.name, c.readTargetGroupByName)
+	case err != nil:
+		return nil, fmt.Errorf("failed to create target group %s: %w", spec.name, err)
+	case len(result.TargetGroups) == 0:
+		return nil, fmt.Errorf("failed to create target group %s: no target group returned", spec.name)
+	}
+	return c.ReadTargetGroup(ctx, aws.ToString(result.TargetGroups[0].TargetGroupArn))
+}
+
+// ReadTargetGroup returns the current state of a target group
+func (c *ELBClient) ReadTargetGroup(ctx context.Context, arn string) (*types.BaseResource, error) {
+	tg, err := c.describeTargetGroup(ctx, arn)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := c.describeTags(ctx, arn)
+	if err != nil {
+		return nil, err
+	}
+	return targetGroupResource(*tg, tags[arn]), nil
+}
+
+func (c *ELBClient) readTargetGroupByName(ctx context.Context, name string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeTargetGroups(ctx, &elb.DescribeTargetGroupsInput{Names: []string{name}})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("target group %s: %w", name, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target group %s: %w", name, err)
+	}
+	if len(result.TargetGroups) == 0 {
+		return nil, fmt.Errorf("target group %s: %w", name, provider.ErrNotFound)
+	}
+	return c.ReadTargetGroup(ctx, aws.ToString(result.TargetGroups[0].TargetGroupArn))
+}
+
+// FindTargetGroupByToken returns the target group created with the given
+// idempotency token
+func (c *ELBClient) FindTargetGroupByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	var arns []string
+	pages := elb.NewDescribeTargetGroupsPaginator(c.client, &elb.DescribeTargetGroupsInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find target group by token: %w", err)
+		}
+		for _, tg := range page.TargetGroups {
+			arns = append(arns, aws.ToString(tg.TargetGroupArn))
+		}
+	}
+	arn, err := c.findTagged(ctx, arns, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find target group by token: %w", err)
+	}
+	if arn == "" {
+		return nil, provider.ErrNotFound
+	}
+	return c.ReadTargetGroup(ctx, arn)
+}
+
+// UpdateTargetGroup applies changes to a target group's health check and
+// tags. Its name, port, protocol, VPC and target type are fixed at
+// creation.
+func (c *ELBClient) UpdateTargetGroup(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseTargetGroupSpec(config)
+	if err != nil {
+		return err
+	}
+
+	arn := resource.GetID()
+	tg, err := c.describeTargetGroup(ctx, arn)
+	if err != nil {
+		return err
+	}
+	for attr, values := range map[string][2]string{
+		"name":        {spec.name, aws.ToString(tg.TargetGroupName)},
+		"port":        {strconv.Itoa(spec.port), strconv.Itoa(int(aws.ToInt32(tg.Port)))},
+		"protocol":    {spec.protocol, string(tg.Protocol)},
+		"vpc_id":      {spec.vpcID, aws.ToString(tg.VpcId)},
+		"target_type": {spec.targetType, string(tg.TargetType)},
+	} {
+		if values[0] != values[1] {
+			return fmt.Errorf("changing %s of target group %s requires replacing it", attr, spec.name)
+		}
+	}
+
+	if hc := spec.healthCheck; hc.differs(*tg) {
+		_, err := c.client.ModifyTargetGroup(ctx, &elb.ModifyTargetGroupInput{
+			TargetGroupArn:             aws.String(arn),
+			HealthCheckEnabled:         hc.enabled,
+			HealthCheckProtocol:        elbtypes.ProtocolEnum(hc.protocol),
+			HealthCheckPort:            optionalString(hc.port),
+			HealthCheckPath:            optionalString(hc.path),
+			HealthCheckIntervalSeconds: optionalInt32(hc.interval),
+			HealthCheckTimeoutSeconds:  optionalInt32(hc.timeout),
+			HealthyThresholdCount:      optionalInt32(hc.healthyThreshold),
+			UnhealthyThresholdCount:    optionalInt32(hc.unhealthyThreshold),
+			Matcher:                    hc.matcherInput(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to modify health check of target group %s: %w", spec.name, err)
+		}
+	}
+
+	tags, err := c.describeTags(ctx, arn)
+	if err != nil {
+		return err
+	}
+	return c.updateTags(ctx, arn, tags[arn], spec.tags)
+}
+
+// DeleteTargetGroup deletes a target group. AWS refuses while a listener
+// forwards to it, so the delete is retried while listeners being deleted
+// alongside it go away.
+func (c *ELBClient) DeleteTargetGroup(ctx context.Context, resource provider.Resource) error {
+	arn := resource.GetID()
+	err := c.retryResourceInUse(ctx, arn, func() error {
+		_, err := c.client.DeleteTargetGroup(ctx, &elb.DeleteTargetGroupInput{TargetGroupArn: aws.String(arn)})
+		return err
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete target group %s: %w", arn, err)
+	}
+	return nil
+}
+
+func (c *ELBClient) describeTargetGroup(ctx context.Context, arn string) (*elbtypes.TargetGroup, error) {
+	result, err := c.client.DescribeTargetGroups(ctx, &elb.DescribeTargetGroupsInput{TargetGroupArns: []string{arn}})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("target group %s: %w", arn, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target group %s: %w", arn, err)
+	}
+	if len(result.TargetGroups) == 0 {
+		return nil, fmt.Errorf("target group %s: %w", arn, provider.ErrNotFound)
+	}
+	return &result.TargetGroups[0], nil
+}
+
+func targetGroupResource(tg elbtypes.TargetGroup, tags map[string]string) *types.BaseResource {
+	matcher := ""
+	if tg.Matcher != nil {
+		matcher = aws.ToString(tg.Matcher.HttpCode)
+	}
+	return &types.BaseResource{
+		ID:       aws.ToString(tg.TargetGroupArn),
+		Type:     types.ResourceTypeLoadBalancerTargetGroup,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"arn":         aws.ToString(tg.TargetGroupArn),
+			"name":        aws.ToString(tg.TargetGroupName),
+			"port":        int(aws.ToInt32(tg.Port)),
+			"protocol":    string(tg.Protocol),
+			"vpc_id":      aws.ToString(tg.VpcId),
+			"target_type": string(tg.TargetType),
+			"health_check": map[string]interface{}{
+				"enabled":             aws.ToBool(tg.HealthCheckEnabled),
+				"protocol":            string(tg.HealthCheckProtocol),
+				"port":                aws.ToString(tg.HealthCheckPort),
+				"path":                aws.ToString(tg.HealthCheckPath),
+				"matcher":             matcher,
+				"interval":            int(aws.ToInt32(tg.HealthCheckIntervalSeconds)),
+				"timeout":             int(aws.ToInt32(tg.HealthCheckTimeoutSeconds)),
+				"healthy_threshold":   int(aws.ToInt32(tg.HealthyThresholdCount)),
+				"unhealthy_threshold": int(aws.ToInt32(tg.UnhealthyThresholdCount)),
+			},
+			"load_balancer_arns": interfaceList(tg.LoadBalancerArns),
+		},
+		Tags:      tags,
+		UpdatedAt: time.Now(),
+	}
+}
+
+// actionSpec is what a listener or rule does with requests: forward them
+// to a target group, redirect them or answer them directly. Redirects
+// default every part of the URL to the request's own, as AWS does, so
+// specs compare equal to the actions AWS reports back.
+type actionSpec struct {
+	kind           string
+	targetGroupArn string
+	statusCode     string
+	protocol       string
+	port           string
+	host           string
+	path           string
+	query          string
+	contentType    string
+	messageBody    string
+}
+
+func parseActionSpec(attrs *attributes) actionSpec {
+	attrs.Require("type")
+	a := actionSpec{kind: attrs.String("type")}
+	switch a.kind {
+	case "forward":
+		attrs.Require("target_group_arn")
+		a.targetGroupArn = attrs.String("target_group_arn")
+	case "redirect":
+		a.statusCode = attrs.String("status_code")
+		a.protocol = attrs.String("protocol")
+		a.port = attrs.String("port")
+		a.host = attrs.String("host")
+		a.path = attrs.String("path")
+		a.query = attrs.String("query")
+		for field, fallback := range map[*string]string{
+			&a.statusCode: string(elbtypes.RedirectActionStatusCodeEnumHttp301),
+			&a.protocol:   "#{protocol}",
+			&a.port:       "#{port}",
+			&a.host:       "#{host}",
+			&a.path:       "/#{path}",
+			&a.query:      "#{query}",
+		} {
+			if *field == "" {
+				*field = fallback
+			}
+		}
+	case "fixed_response":
+		attrs.Require("status_code")
+		a.statusCode = attrs.String("status_code")
+		a.contentType = attrs.String("content_type")
+		a.messageBody = attrs.String("message_body")
+	default:
+		if attrs.Err() == nil {
+			attrs.err = fmt.Errorf("action type must be forward, redirect or fixed_response, got %q", a.kind)
+		}
+	}
+	return a
+}
+
+func (a actionSpec) toELB() elbtypes.Action {
+	switch a.kind {
+	case "redirect":
+		return elbtypes.Action{
+			Type: elbtypes.ActionTypeEnumRedirect,
+			RedirectConfig: &elbtypes.RedirectActionConfig{
+				StatusCode: elbtypes.RedirectActionStatusCodeEnum(a.statusCode),
+				Protocol:   aws.String(a.protocol),
+				Port:       aws.String(a.port),
+				Host:       aws.String(a.host),
+				Path:       aws.String(a.path),
+				Query:      aws.String(a.query),
+			},
+		}
+	case "fixed_response":
+		return elbtypes.Action{
+			Type: elbtypes.ActionTypeEnumFixedResponse,
+			FixedResponseConfig: &elbtypes.FixedResponseActionConfig{
+				StatusCode:  aws.String(a.statusCode),
+				ContentType: optionalString(a.contentType),
+				MessageBody: optionalString(a.messageBody),
+			},
+		}
+	default:
+		return elbtypes.Action{
+			Type:           elbtypes.ActionTypeEnumForward,
+			TargetGroupArn: aws.String(a.targetGroupArn),
+		}
+	}
+}
+
+// actionFromELB converts the first of a listener's or rule's actions back
+// to a spec. duet gives each exactly one.
+func actionFromELB(actions []elbtypes.Action) actionSpec {
+	if len(actions) == 0 {
+		return actionSpec{}
+	}
+	action := actions[0]
+	switch action.Type {
+	case elbtypes.ActionTypeEnumRedirect:
+		a := actionSpec{kind: "redirect"}
+		if r := action.RedirectConfig; r != nil {
+			a.statusCode = string(r.StatusCode)
+			a.protocol = aws.ToString(r.Protocol)
+			a.port = aws.ToString(r.Port)
+			a.host = aws.ToString(r.Host)
+			a.path = aws.ToString(r.Path)
+			a.query = aws.ToString(r.Query)
+		}
+		return a
+	case elbtypes.ActionTypeEnumFixedResponse:
+		a := actionSpec{kind: "fixed_response"}
+		if r := action.FixedResponseConfig; r != nil {
+			a.statusCode = aws.ToString(r.StatusCode)
+			a.contentType = aws.ToString(r.ContentType)
+			a.messageBody = aws.ToString(r.MessageBody)
+		}
+		return a
+	default:
+		return actionSpec{kind: string(action.Type), targetGroupArn: aws.ToString(action.TargetGroupArn)}
+	}
+}
+
+func (a actionSpec) metadata() map[string]interface{} {
+	out := map[string]interface{}{"type": a.kind}
+	for key, value := range map[string]string{
+		"target_group_arn": a.targetGroupArn,
+		"status_code":      a.statusCode,
+		"protocol":         a.protocol,
+		"port":             a.port,
+		"host":             a.host,
+		"path":             a.path,
+		"query":            a.query,
+		"content_type":     a.contentType,
+		"message_body":     a.messageBody,
+	} {
+		if value != "" {
+			out[key] = value
+		}
+	}
+	return out
+}
+
+// ruleSpec is a listener rule: requests matching all of its conditions
+// get its action instead of the listener's default one
+type ruleSpec struct {
+	action       actionSpec
+	pathPatterns []string
+	hostHeaders  []string
+	priority     int
+}
+
+func (r ruleSpec) same(other ruleSpec) bool {
+	return r.priority == other.priority && r.action == other.action &&
+		sameStrings(r.pathPatterns, other.pathPatterns) && sameStrings(r.hostHeaders, other.hostHeaders)
+}
+
+func (r ruleSpec) conditions() []elbtypes.RuleCondition {
+	var conditions []elbtypes.RuleCondition
+	if len(r.pathPatterns) > 0 {
+		conditions = append(conditions, elbtypes.RuleCondition{
+			Field:             aws.String("path-pattern"),
+			PathPatternConfig: &elbtypes.PathPatternConditionConfig{Values: r.pathPatterns},
+		})
+	}
+	if len(r.hostHeaders) > 0 {
+		conditions = append(conditions, elbtypes.RuleCondition{
+			Field:            aws.String("host-header"),
+			HostHeaderConfig: &elbtypes.HostHeaderConditionConfig{Values: r.hostHeaders},
+		})
+	}
+	return conditions
+}
+
+func ruleFromELB(rule elbtypes.Rule) (ruleSpec, error) {
+	priority, err := strconv.Atoi(aws.ToString(rule.Priority))
+	if err != nil {
+		return ruleSpec{}, fmt.Errorf("rule %s has priority %q: %w", aws.ToString(rule.RuleArn), aws.ToString(rule.Priority), err)
+	}
+	r := ruleSpec{priority: priority, action: actionFromELB(rule.Actions)}
+	for _, c := range rule.Conditions {
+		switch aws.ToString(c.Field) {
+		case "path-pattern":
+			if c.PathPatternConfig != nil {
+				r.pathPatterns = append(r.pathPatterns, c.PathPatternConfig.Values...)
+			} else {
+				r.pathPatterns = append(r.pathPatterns, c.Values...)
+			}
+		case "host-header":
+			if c.HostHeaderConfig != nil {
+				r.hostHeaders = append(r.hostHeaders, c.HostHeaderConfig.Values...)
+			} else {
+				r.hostHeaders = append(r.hostHeaders, c.Values...)
+			}
+		}
+	}
+	return r, nil
+}
+
+// listenerSpec is the desired configuration of a listener and its rules
+type listenerSpec struct {
+	tags            map[string]string
+	loadBalancerArn string
+	protocol        string
+	certificateArn  string
+	sslPolicy       string
+	defaultAction   actionSpec
+	rules           []ruleSpec
+	port            int
+}
+
+func parseListenerSpec(config map[string]interface{}) (*listenerSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("load_balancer_arn", "port", "default_action")
+
+	spec := &listenerSpec{
+		loadBalancerArn: attrs.String("load_balancer_arn"),
+		port:            attrs.Int("port"),
+		protocol:        attrs.String("protocol"),
+		certificateArn:  attrs.String("certificate_arn"),
+		sslPolicy:       attrs.String("ssl_policy"),
+		tags:            attrs.StringMap("tags"),
+	}
+	if spec.protocol == "" {
+		spec.protocol = string(elbtypes.ProtocolEnumHttp)
+	}
+
+	action := attrs.Table("default_action")
+	spec.defaultAction = parseActionSpec(action)
+	attrs.collectTable("default_action", action)
+
+	rules := attrs.List("rules")
+	priorities := make(map[int]bool, len(rules))
+	for _, r := range rules {
+		r.Require("priority", "action")
+		rule := ruleSpec{
+			priority:     r.Int("priority"),
+			pathPatterns: r.StringList("path_patterns"),
+			hostHeaders:  r.StringList("host_headers"),
+		}
+		action := r.Table("action")
+		rule.action = parseActionSpec(action)
+		r.collectTable("action", action)
+		if r.Err() == nil && len(rule.pathPatterns)+len(rule.hostHeaders) == 0 {
+			r.err = fmt.Errorf("a rule needs path_patterns or host_headers to match")
+		}
+		if r.Err() == nil && priorities[rule.priority] {
+			r.err = fmt.Errorf("priority %d is used by more than one rule", rule.priority)
+		}
+		priorities[rule.priority] = true
+		spec.rules = append(spec.rules, rule)
+	}
+	attrs.collect("rules", rules...)
+
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+func (s *listenerSpec) certificates() []elbtypes.Certificate {
+	if s.certificateArn == "" {
+		return nil
+	}
+	return []elbtypes.Certificate{{CertificateArn: aws.String(s.certificateArn)}}
+}
+
+// CreateListener adds a listener to a load balancer, then its rules. A
+// listener is identified by its load balancer and port, so a retried
+// create adopts the listener on that port if its token matches.
+func (c *ELBClient) CreateListener(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseListenerSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var arn string
+	result, err := c.client.CreateListener(ctx, &elb.CreateListenerInput{
+		LoadBalancerArn: aws.String(spec.loadBalancerArn),
+		Port:            aws.Int32(int32(spec.port)),
+		Protocol:        elbtypes.ProtocolEnum(spec.protocol),
+		Certificates:    spec.certificates(),
+		SslPolicy:       optionalString(spec.sslPolicy),
+		DefaultActions:  []elbtypes.Action{spec.defaultAction.toELB()},
+		Tags:            elbCreateTags(ctx, spec.tags),
+	})
+	switch {
+	case errorCode(err) == "DuplicateListener":
+		existing, err := adopt(ctx, "listener on port", strconv.Itoa(spec.port), func(ctx context.Context, _ string) (*types.BaseResource, error) {
+			return c.readListenerOnPort(ctx, spec.loadBalancerArn, spec.port)
+		})
+		if err != nil {
+			return nil, err
+		}
+		arn = existing.ID
+	case err != nil:
+		return nil, fmt.Errorf("failed to create listener on port %d: %w", spec.port, err)
+	case len(result.Listeners) == 0:
+		return nil, fmt.Errorf("failed to create listener on port %d: no listener returned", spec.port)
+	default:
+		arn = aws.ToString(result.Listeners[0].ListenerArn)
+	}
+
+	// The listener exists from here on, so it is returned even if adding
+	// its rules fails, letting the caller track it
+	if err := c.syncRules(ctx, arn, spec.rules); err != nil {
+		listener, _ := c.ReadListener(ctx, arn)
+		return listener, err
+	}
+	return c.ReadListener(ctx, arn)
+}
+
+// ReadListener returns the current state of a listener and its rules
+func (c *ELBClient) ReadListener(ctx context.Context, arn string) (*types.BaseResource, error) {
+	listener, err := c.describeListener(ctx, arn)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := c.describeRules(ctx, arn)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := c.describeTags(ctx, arn)
+	if err != nil {
+		return nil, err
+	}
+	return listenerResource(*listener, rules, tags[arn]), nil
+}
+
+func (c *ELBClient) readListenerOnPort(ctx context.Context, loadBalancerArn string, port int) (*types.BaseResource, error) {
+	result, err := c.client.DescribeListeners(ctx, &elb.DescribeListenersInput{LoadBalancerArn: aws.String(loadBalancerArn)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read listeners of %s: %w", loadBalancerArn, err)
+	}
+	for _, l := range result.Listeners {
+		if int(aws.ToInt32(l.Port)) == port {
+			return c.ReadListener(ctx, aws.ToString(l.ListenerArn))
+		}
+	}
+	return nil, fmt.Errorf("listener on port %d of %s: %w", port, loadBalancerArn, provider.ErrNotFound)
+}
+
+// FindListenerByToken returns the listener created with the given
+// idempotency token, looking through the listeners of every load balancer
+func (c *ELBClient) FindListenerByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	lbs, err := c.loadBalancerARNs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find listener by token: %w", err)
+	}
+	var arns []string
+	for _, lb := range lbs {
+		pages := elb.NewDescribeListenersPaginator(c.client, &elb.DescribeListenersInput{LoadBalancerArn: aws.String(lb)})
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find listener by token: %w", err)
+			}
+			for _, l := range page.Listeners {
+				arns = append(arns, aws.ToString(l.ListenerArn))
+			}
+		}
+	}
+	arn, err := c.findTagged(ctx, arns, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find listener by token: %w", err)
+	}
+	if arn == "" {
+		return nil, provider.ErrNotFound
+	}
+	return c.ReadListener(ctx, arn)
+}
+
+// UpdateListener applies changes to a listener's port, protocol,
+// certificate, default action, rules and tags. Its load balancer is fixed
+// at creation.
+func (c *ELBClient) UpdateListener(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseListenerSpec(config)
+	if err != nil {
+		return err
+	}
+
+	arn := resource.GetID()
+	listener, err := c.describeListener(ctx, arn)
+	if err != nil {
+		return err
+	}
+	if spec.loadBalancerArn != aws.ToString(listener.LoadBalancerArn) {
+		return fmt.Errorf("changing load_balancer_arn of listener %s requires replacing it", arn)
+	}
+
+	if spec.port != int(aws.ToInt32(listener.Port)) ||
+		spec.protocol != string(listener.Protocol) ||
+		spec.certificateArn != listenerCertificate(*listener) ||
+		(spec.sslPolicy != "" && spec.sslPolicy != aws.ToString(listener.SslPolicy)) ||
+		spec.defaultAction != actionFromELB(listener.DefaultActions) {
+		_, err := c.client.ModifyListener(ctx, &elb.ModifyListenerInput{
+			ListenerArn:    aws.String(arn),
+			Port:           aws.Int32(int32(spec.port)),
+			Protocol:       elbtypes.ProtocolEnum(spec.protocol),
+			Certificates:   spec.certificates(),
+			SslPolicy:      optionalString(spec.sslPolicy),
+			DefaultActions: []elbtypes.Action{spec.defaultAction.toELB()},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to modify listener %s: %w", arn, err)
+		}
+	}
+
+	if err := c.syncRules(ctx, arn, spec.rules); err != nil {
+		return err
+	}
+
+	tags, err := c.describeTags(ctx, arn)
+	if err != nil {
+		return err
+	}
+	return c.updateTags(ctx, arn, tags[arn], spec.tags)
+}
+
+// DeleteListener deletes a listener, which takes its rules with it
+func (c *ELBClient) DeleteListener(ctx context.Context, resource provider.Resource) error {
+	arn := resource.GetID()
+	_, err := c.client.DeleteListener(ctx, &elb.DeleteListenerInput{ListenerArn: aws.String(arn)})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete listener %s: %w", arn, err)
+	}
+	return nil
+}
+
+// syncRules brings a listener's rules in line with the desired ones,
+// matching them up by priority. Rules that go away are deleted first so
+// their priorities are free for the rules that are created.
+func (c *ELBClient) syncRules(ctx context.Context, listenerArn string, desired []ruleSpec) error {
+	current, err := c.describeRules(ctx, listenerArn)
+	if err != nil {
+		return err
+	}
+	existing := make(map[int]elbtypes.Rule, len(current))
+	for _, rule := range current {
+		r, err := ruleFromELB(rule)
+		if err != nil {
+			return err
+		}
+		existing[r.priority] = rule
+	}
+	wanted := make(map[int]bool, len(desired))
+	for _, r := range desired {
+		wanted[r.priority] = true
+	}
+
+	for priority, rule := range existing {
+		if wanted[priority] {
+			continue
+		}
+		_, err := c.client.DeleteRule(ctx, &elb.DeleteRuleInput{RuleArn: rule.RuleArn})
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to delete rule %d of listener %s: %w", priority, listenerArn, err)
+		}
+	}
+
+	for _, r := range desired {
+		rule, ok := existing[r.priority]
+		if !ok {
+			_, err := c.client.CreateRule(ctx, &elb.CreateRuleInput{
+				ListenerArn: aws.String(listenerArn),
+				Priority:    aws.Int32(int32(r.priority)),
+				Conditions:  r.conditions(),
+				Actions:     []elbtypes.Action{r.action.toELB()},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create rule %d of listener %s: %w", r.priority, listenerArn, err)
+			}
+			continue
+		}
+		if current, err := ruleFromELB(rule); err == nil && current.same(r) {
+			continue
+		}
+		_, err := c.client.ModifyRule(ctx, &elb.ModifyRuleInput{
+			RuleArn:    rule.RuleArn,
+			Conditions: r.conditions(),
+			Actions:    []elbtypes.Action{r.action.toELB()},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to modify rule %d of listener %s: %w", r.priority, listenerArn, err)
+		}
+	}
+	return nil
+}
+
+func (c *ELBClient) describeListener(ctx context.Context, arn string) (*elbtypes.Listener, error) {
+	result, err := c.client.DescribeListeners(ctx, &elb.DescribeListenersInput{ListenerArns: []string{arn}})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("listener %s: %w", arn, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read listener %s: %w", arn, err)
+	}
+	if len(result.Listeners) == 0 {
+		return nil, fmt.Errorf("listener %s: %w", arn, provider.ErrNotFound)
+	}
+	return &result.Listeners[0], nil
+}
+
+// describeRules returns a listener's rules apart from its default one,
+// which is its default action
+func (c *ELBClient) describeRules(ctx context.Context, listenerArn string) ([]elbtypes.Rule, error) {
+	var rules []elbtypes.Rule
+	input := &elb.DescribeRulesInput{ListenerArn: aws.String(listenerArn)}
+	for {
+		result, err := c.client.DescribeRules(ctx, input)
+		if isNotFound(err) {
+			return nil, fmt.Errorf("listener %s: %w", listenerArn, provider.ErrNotFound)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rules of listener %s: %w", listenerArn, err)
+		}
+		for _, rule := range result.Rules {
+			if !aws.ToBool(rule.IsDefault) {
+				rules = append(rules, rule)
+			}
+		}
+		if aws.ToString(result.NextMarker) == "" {
+			return rules, nil
+		}
+		input.Marker = result.NextMarker
+	}
+}
+
+func listenerCertificate(listener elbtypes.Listener) string {
+	if len(listener.Certificates) == 0 {
+		return ""
+	}
+	return aws.ToString(listener.Certificates[0].CertificateArn)
+}
+
+func listenerResource(listener elbtypes.Listener, rules []elbtypes.Rule, tags map[string]string) *types.BaseResource {
+	ruleList := make([]interface{}, 0, len(rules))
+	for _, rule := range rules {
+		r, err := ruleFromELB(rule)
+		if err != nil {
+			continue
+		}
+		ruleList = append(ruleList, map[string]interface{}{
+			"arn":           aws.ToString(rule.RuleArn),
+			"priority":      r.priority,
+			"path_patterns": interfaceList(r.pathPatterns),
+			"host_headers":  interfaceList(r.hostHeaders),
+			"action":        r.action.metadata(),
+		})
+	}
+	return &types.BaseResource{
+		ID:       aws.ToString(listener.ListenerArn),
+		Type:     types.ResourceTypeLoadBalancerListener,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"arn":               aws.ToString(listener.ListenerArn),
+			"load_balancer_arn": aws.ToString(listener.LoadBalancerArn),
+			"port":              int(aws.ToInt32(listener.Port)),
+			"protocol":          string(listener.Protocol),
+			"certificate_arn":   listenerCertificate(listener),
+			"ssl_policy":        aws.ToString(listener.SslPolicy),
+			"default_action":    actionFromELB(listener.DefaultActions).metadata(),
+			"rules":             ruleList,
+		},
+		Tags:      tags,
+		UpdatedAt: time.Now(),
+	}
+}
+
+// targetAttachmentSpec is the desired registration of a target, such as an
+// instance, with a target group. Nothing about it can change in place.
+type targetAttachmentSpec struct {
+	targetGroupArn string
+	targetID       string
+	port           int
+}
+
+func parseTargetAttachmentSpec(config map[string]interface{}) (*targetAttachmentSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("target_group_arn", "target_id")
+
+	spec := &targetAttachmentSpec{
+		targetGroupArn: attrs.String("target_group_arn"),
+		targetID:       attrs.String("target_id"),
+		port:           attrs.Int("port"),
+	}
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// targetAttachmentID identifies a registration by its target group, target
+// and port, since AWS gives registrations no ID of their own. ARNs hold
+// colons, so the parts are separated by commas.
+func targetAttachmentID(spec targetAttachmentSpec) string {
+	id := spec.targetGroupArn + "," + spec.targetID
+	if spec.port != 0 {
+		id += "," + strconv.Itoa(spec.port)
+	}
+	return id
+}
+
+func parseTargetAttachmentID(id string) (targetAttachmentSpec, error) {
+	parts := strings.Split(id, ",")
+	invalid := fmt.Errorf("invalid target group attachment ID %q: expected <target-group-arn>,<target-id>[,<port>]", id)
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return targetAttachmentSpec{}, invalid
+	}
+	spec := targetAttachmentSpec{targetGroupArn: parts[0], targetID: parts[1]}
+	if len(parts) == 3 {
+		port, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return targetAttachmentSpec{}, invalid
+		}
+		spec.port = port
+	}
+	return spec, nil
+}
+
+func (s targetAttachmentSpec) target() elbtypes.TargetDescription {
+	target := elbtypes.TargetDescription{Id: aws.String(s.targetID)}
+	if s.port != 0 {
+		target.Port = aws.Int32(int32(s.port))
+	}
+	return target
+}
+
+// CreateTargetGroupAttachment registers a target with a target group.
+// Registering a target twice is harmless, so a retried create simply
+// registers it again. It does not wait for the target to pass its health
+// checks, which depends on what runs on it.
+func (c *ELBClient) CreateTargetGroupAttachment(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseTargetAttachmentSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.client.RegisterTargets(ctx, &elb.RegisterTargetsInput{
+		TargetGroupArn: aws.String(spec.targetGroupArn),
+		Targets:        []elbtypes.TargetDescription{spec.target()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to register %s with target group %s: %w", spec.targetID, spec.targetGroupArn, err)
+	}
+	return c.ReadTargetGroupAttachment(ctx, targetAttachmentID(*spec))
+}
+
+// ReadTargetGroupAttachment returns the current state of a registration.
+// A target that is no longer registered, or is draining, is reported as
+// provider.ErrNotFound.
+func (c *ELBClient) ReadTargetGroupAttachment(ctx context.Context, id string) (*types.BaseResource, error) {
+	spec, err := parseTargetAttachmentID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.client.DescribeTargetHealth(ctx, &elb.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(spec.targetGroupArn),
+		Targets:        []elbtypes.TargetDescription{spec.target()},
+	})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("target group attachment %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target group attachment %s: %w", id, err)
+	}
+
+	for _, d := range result.TargetHealthDescriptions {
+		if d.Target == nil || aws.ToString(d.Target.Id) != spec.targetID || d.TargetHealth == nil {
+			continue
+		}
+		state := d.TargetHealth.State
+		if state == elbtypes.TargetHealthStateEnumDraining ||
+			(state == elbtypes.TargetHealthStateEnumUnused && d.TargetHealth.Reason == elbtypes.TargetHealthReasonEnumNotRegistered) {
+			return nil, fmt.Errorf("target group attachment %s: %w", id, provider.ErrNotFound)
+		}
+		return targetAttachmentResource(spec, state), nil
+	}
+	return nil, fmt.Errorf("target group attachment %s: %w", id, provider.ErrNotFound)
+}
+
+// FindTargetGroupAttachmentByToken always reports provider.ErrNotFound:
+// registrations cannot be tagged, and an interrupted one is finished by
+// the next create instead
+func (c *ELBClient) FindTargetGroupAttachmentByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	return nil, provider.ErrNotFound
+}
+
+// UpdateTargetGroupAttachment rejects any change; moving a target means
+// deregistering and registering it again
+func (c *ELBClient) UpdateTargetGroupAttachment(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseTargetAttachmentSpec(config)
+	if err != nil {
+		return err
+	}
+	current, err := parseTargetAttachmentID(resource.GetID())
+	if err != nil {
+		return err
+	}
+	for attr, values := range map[string][2]string{
+		"target_group_arn": {spec.targetGroupArn, current.targetGroupArn},
+		"target_id":        {spec.targetID, current.targetID},
+		"port":             {strconv.Itoa(spec.port), strconv.Itoa(current.port)},
+	} {
+		if values[0] != values[1] {
+			return fmt.Errorf("changing %s of target group attachment %s requires replacing it", attr, resource.GetID())
+		}
+	}
+	return nil
+}
+
+// DeleteTargetGroupAttachment deregisters the target. It does not wait for
+// connections to drain. Targets whose group is already gone are left
+// alone.
+func (c *ELBClient) DeleteTargetGroupAttachment(ctx context.Context, resource provider.Resource) error {
+	spec, err := parseTargetAttachmentID(resource.GetID())
+	if err != nil {
+		return err
+	}
+	_, err = c.client.DeregisterTargets(ctx, &elb.DeregisterTargetsInput{
+		TargetGroupArn: aws.String(spec.targetGroupArn),
+		Targets:        []elbtypes.TargetDescription{spec.target()},
+	})
+	if err != nil && !isNotFound(err) && errorCode(err) != "InvalidTarget" {
+		return fmt.Errorf("failed to deregister %s from target group %s: %w", spec.targetID, spec.targetGroupArn, err)
+	}
+	return nil
+}
+
+func targetAttachmentResource(spec targetAttachmentSpec, health elbtypes.TargetHealthStateEnum) *types.BaseResource {
+	return &types.BaseResource{
+		ID:       targetAttachmentID(spec),
+		Type:     types.ResourceTypeLoadBalancerTargetGroupAttachment,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"target_group_arn": spec.targetGroupArn,
+			"target_id":        spec.targetID,
+			"port":             spec.port,
+			"health":           string(health),
+		},
+		UpdatedAt: time.Now(),
+	}
+}
+
+// describeTags returns the tags of each of the given resources by ARN
+func (c *ELBClient) describeTags(ctx context.Context, arns ...string) (map[string]map[string]string, error) {
+	out := make(map[string]map[string]string, len(arns))
+	for start := 0; start < len(arns); start += maxTagARNs {
+		end := min(start+maxTagARNs, len(arns))
+		result, err := c.client.DescribeTags(ctx, &elb.DescribeTagsInput{ResourceArns: arns[start:end]})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tags: %w", err)
+		}
+		for _, d := range result.TagDescriptions {
+			out[aws.ToString(d.ResourceArn)] = fromELBTags(d.Tags)
+		}
+	}
+	return out, nil
+}
+
+// findTagged returns the first of arns tagged with the idempotency token,
+// or "" if none is
+func (c *ELBClient) findTagged(ctx context.Context, arns []string, token string) (string, error) {
+	tags, err := c.describeTags(ctx, arns...)
+	if err != nil {
+		return "", err
+	}
+	for _, arn := range arns {
+		if tags[arn][tokenTag] == token {
+			return arn, nil
+		}
+	}
+	return "", nil
+}
+
+// updateTags applies the difference between current and desired tags.
+// Reserved tags are never removed.
+func (c *ELBClient) updateTags(ctx context.Context, arn string, current, desired map[string]string) error {
+	if desired == nil {
+		return nil
+	}
+
+	changed := make(map[string]string)
+	for k, v := range desired {
+		if cur, ok := current[k]; !ok || cur != v {
+			changed[k] = v
+		}
+	}
+	var removed []string
+	for _, k := range sortedKeys(current) {
+		if _, ok := desired[k]; !ok && !isReservedTag(k) {
+			removed = append(removed, k)
+		}
+	}
+
+	if len(changed) > 0 {
+		_, err := c.client.AddTags(ctx, &elb.AddTagsInput{
+			ResourceArns: []string{arn},
+			Tags:         toELBTags(changed),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to tag %s: %w", arn, err)
+		}
+	}
+	if len(removed) > 0 {
+		_, err := c.client.RemoveTags(ctx, &elb.RemoveTagsInput{
+			ResourceArns: []string{arn},
+			TagKeys:      removed,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to remove tags from %s: %w", arn, err)
+		}
+	}
+	return nil
+}
+
+// elbCreateTags returns tags plus the idempotency token tag, if any
+func elbCreateTags(ctx context.Context, tags map[string]string) []elbtypes.Tag {
+	all := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		all[k] = v
+	}
+	if token := provider.IdempotencyToken(ctx); token != "" {
+		all[tokenTag] = token
+	}
+	return toELBTags(all)
+}
+
+func toELBTags(tags map[string]string) []elbtypes.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	out := make([]elbtypes.Tag, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		out = append(out, elbtypes.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return out
+}
+
+func fromELBTags(tags []elbtypes.Tag) map[string]string {
+	out := make(map[string]string, len(tags))
+	for _, t := range tags {
+		out[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return out
+}
+
+func optionalInt32(n int) *int32 {
+	if n == 0 {
+		return nil
+	}
+	return aws.Int32(int32(n))
+}
+
+// interfaceList converts strings to the list form Lua values take
+func interfaceList(list []string) []interface{} {
+	out := make([]interface{}, 0, len(list))
+	for _, s := range list {
+		out = append(out, s)
+	}
+	return out
+}
+
+// waitForLoadBalancer polls until the load balancer is active. One that
+// fails to provision is an error straight away.
+func (c *ELBClient) waitForLoadBalancer(ctx context.Context, arn string, timeout time.Duration) (*elbtypes.LoadBalancer, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		lb, err := c.describeLoadBalancer(ctx, arn)
+		if err != nil {
+			return nil, err
+		}
+		if lb.State != nil {
+			switch lb.State.Code {
+			case elbtypes.LoadBalancerStateEnumActive:
+				return lb, nil
+			case elbtypes.LoadBalancerStateEnumFailed:
+				return nil, fmt.Errorf("load balancer %s failed: %s", arn, aws.ToString(lb.State.Reason))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out after %s waiting for load balancer %s to be active", timeout, arn)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// waitForLoadBalancerDeleted polls until the load balancer can no longer
+// be found
+func (c *ELBClient) waitForLoadBalancerDeleted(ctx context.Context, arn string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		_, err := c.describeLoadBalancer(ctx, arn)
+		if errors.Is(err, provider.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for load balancer %s to be deleted", timeout, arn)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// retryResourceInUse retries a delete that AWS refuses while something
+// still uses the resource, such as a target group whose listener is being
+// deleted
+func (c *ELBClient) retryResourceInUse(ctx context.Context, what string, del func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultDeleteTimeout)
+	defer cancel()
+
+	for {
+		err := del()
+		if err == nil || errorCode(err) != "ResourceInUse" {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for resources using %s to be deleted: %w", defaultDeleteTimeout, what, err)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/internal/iac/provider/aws/awstest"
+)
+
+// newLoadBalancerNetwork creates a VPC with subnets in two zones, which an
+// application load balancer needs, and returns their IDs
+func newLoadBalancerNetwork(t *testing.T, p *AWSProvider) (vpcID string, subnets []interface{}) {
+	t.Helper()
+	ctx := context.Background()
+	vpc, err := p.Create(ctx, "vpc", map[string]interface{}{"cidr_block": "10.0.0.0/16"})
+	if err != nil {
+		t.Fatalf("Failed to create VPC: %v", err)
+	}
+	for i, zone := range []string{"us-east-1a", "us-east-1b", "us-east-1c"} {
+		subnet, err := p.Create(ctx, "subnet", map[string]interface{}{
+			"vpc_id":            vpc.GetID(),
+			"cidr_block":        "10.0." + string(rune('1'+i)) + ".0/24",
+			"availability_zone": zone,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create subnet: %v", err)
+		}
+		subnets = append(subnets, subnet.GetID())
+	}
+	return vpc.GetID(), subnets
+}
+
+func TestLoadBalancers(t *testing.T) {
+	p, cloud := newCloudTestProvider(t)
+	ctx := context.Background()
+	vpcID, subnets := newLoadBalancerNetwork(t, p)
+
+	config := map[string]interface{}{
+		"name":    "web",
+		"subnets": subnets[:2],
+		"tags":    map[string]interface{}{"team": "web"},
+	}
+
+	var lb provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		lb, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-web"), "lb", config)
+		if err != nil {
+			t.Fatalf("Failed to create load balancer: %v", err)
+		}
+
+		meta := lb.GetMetadata()
+		if meta["state"] != "active" || meta["vpc_id"] != vpcID || meta["load_balancer_type"] != "application" || meta["internal"] != false {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+		if !strings.HasSuffix(meta["dns_name"].(string), ".elb.amazonaws.com") || meta["zone_id"] != "Z35SXDOTRQ7X7K" {
+			t.Errorf("Expected a DNS name and hosted zone, got %v", meta)
+		}
+		if lb.GetTags()["team"] != "web" || lb.GetTags()[tokenTag] != "run-1-web" {
+			t.Errorf("Unexpected tags: %v", lb.GetTags())
+		}
+
+		again, err := p.Create(provider.WithIdempotencyToken(ctx, "run-1-web"), "lb", config)
+		if err != nil {
+			t.Fatalf("Failed to retry create: %v", err)
+		}
+		if again.GetID() != lb.GetID() {
+			t.Errorf("Expected retried create to return %s, got %s", lb.GetID(), again.GetID())
+		}
+		if _, err := p.Create(provider.WithIdempotencyToken(ctx, "run-2-web"), "lb", config); err == nil || !strings.Contains(err.Error(), "not managed by this run") {
+			t.Errorf("Expected an error for a name taken by another run, got %v", err)
+		}
+
+		found, err := p.FindByToken(ctx, "lb", "run-1-web")
+		if err != nil || found.GetID() != lb.GetID() {
+			t.Errorf("Expected to find %s by token, got %v, %v", lb.GetID(), found, err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		sg, err := p.Create(ctx, "security_group", map[string]interface{}{"name": "lb", "vpc_id": vpcID})
+		if err != nil {
+			t.Fatalf("Failed to create security group: %v", err)
+		}
+		updated := map[string]interface{}{
+			"name":            "web",
+			"subnets":         subnets,
+			"security_groups": []interface{}{sg.GetID()},
+			"tags":            map[string]interface{}{"team": "platform"},
+		}
+		if err := p.Update(ctx, lb, updated); err != nil {
+			t.Fatalf("Failed to update load balancer: %v", err)
+		}
+		got, _ := cloud.ELB.LoadBalancer("web")
+		if len(got.Subnets) != 3 || !reflect.DeepEqual(got.SecurityGroups, []string{sg.GetID()}) {
+			t.Errorf("Expected three subnets and the security group, got %+v", got)
+		}
+		if got.Tags["team"] != "platform" || got.Tags[tokenTag] != "run-1-web" {
+			t.Errorf("Expected the team tag to change and the token to stay, got %v", got.Tags)
+		}
+
+		if err := p.Update(ctx, lb, updated); err != nil {
+			t.Fatalf("Failed to reapply config: %v", err)
+		}
+		if n := cloud.ELB.Calls("SetSubnets"); n != 1 {
+			t.Errorf("Expected one SetSubnets call, got %d", n)
+		}
+	})
+
+	t.Run("ReplaceRequired", func(t *testing.T) {
+		for attr, value := range map[string]interface{}{
+			"name":               "api",
+			"internal":           true,
+			"load_balancer_type": "network",
+		} {
+			changed := map[string]interface{}{"name": "web", "subnets": subnets}
+			changed[attr] = value
+			err := p.Update(ctx, lb, changed)
+			if err == nil || !strings.Contains(err.Error(), "requires replacing") {
+				t.Errorf("Expected changing %s to require replacement, got %v", attr, err)
+			}
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		for name, config := range map[string]map[string]interface{}{
+			"NoSubnets":     {"name": "x"},
+			"UnknownType":   {"name": "x", "subnets": subnets, "load_balancer_type": "gateway"},
+			"NetworkGroups": {"name": "x", "subnets": subnets, "load_balancer_type": "network", "security_groups": []interface{}{"sg-1"}},
+		} {
+			if _, err := p.Create(ctx, "lb", config); err == nil {
+				t.Errorf("%s: expected error, got nil", name)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, lb); err != nil {
+			t.Fatalf("Failed to delete load balancer: %v", err)
+		}
+		if _, ok := cloud.ELB.LoadBalancer("web"); ok {
+			t.Error("Expected load balancer to be deleted")
+		}
+		if _, err := p.Read(ctx, "lb", lb.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		if err := p.Delete(ctx, lb); err != nil {
+			t.Errorf("Expected deleting again to succeed, got %v", err)
+		}
+	})
+}
+
+func TestTargetGroups(t *testing.T) {
+	p, cloud := newCloudTestProvider(t)
+	ctx := context.Background()
+
+	config := map[string]interface{}{
+		"name":     "web",
+		"port":     8080,
+		"protocol": "HTTP",
+		"vpc_id":   "vpc-1",
+		"health_check": map[string]interface{}{
+			"path":     "/healthz",
+			"interval": 15,
+		},
+	}
+
+	var tg provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		tg, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-tg"), "lb_target_group", config)
+		if err != nil {
+			t.Fatalf("Failed to create target group: %v", err)
+		}
+		meta := tg.GetMetadata()
+		if meta["port"] != 8080 || meta["target_type"] != "instance" || meta["arn"] != tg.GetID() {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+		hc := meta["health_check"].(map[string]interface{})
+		if hc["path"] != "/healthz" || hc["interval"] != 15 || hc["healthy_threshold"] != 5 {
+			t.Errorf("Expected the given health check over the defaults, got %v", hc)
+		}
+
+		found, err := p.FindByToken(ctx, "lb_target_group", "run-1-tg")
+		if err != nil || found.GetID() != tg.GetID() {
+			t.Errorf("Expected to find %s by token, got %v, %v", tg.GetID(), found, err)
+		}
+	})
+
+	t.Run("UpdateHealthCheck", func(t *testing.T) {
+		if err := p.Update(ctx, tg, config); err != nil {
+			t.Fatalf("Failed to reapply config: %v", err)
+		}
+		if n := cloud.ELB.Calls("ModifyTargetGroup"); n != 0 {
+			t.Errorf("Expected no ModifyTargetGroup call for an unchanged config, got %d", n)
+		}
+
+		updated := map[string]interface{}{
+			"name":   "web",
+			"port":   8080,
+			"vpc_id": "vpc-1",
+			"health_check": map[string]interface{}{
+				"path":    "/ready",
+				"matcher": "200-299",
+			},
+		}
+		if err := p.Update(ctx, tg, updated); err != nil {
+			t.Fatalf("Failed to update target group: %v", err)
+		}
+		got, _ := cloud.ELB.TargetGroup("web")
+		if got.HealthCheck.Path != "/ready" || got.HealthCheck.Matcher != "200-299" || got.HealthCheck.Interval != 15 {
+			t.Errorf("Unexpected health check: %+v", got.HealthCheck)
+		}
+
+		updated["port"] = 9090
+		if err := p.Update(ctx, tg, updated); err == nil || !strings.Contains(err.Error(), "changing port") {
+			t.Errorf("Expected changing port to require replacement, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, tg); err != nil {
+			t.Fatalf("Failed to delete target group: %v", err)
+		}
+		if _, err := p.Read(ctx, "lb_target_group", tg.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestListeners(t *testing.T) {
+	p, cloud := newCloudTestProvider(t)
+	ctx := context.Background()
+	vpcID, subnets := newLoadBalancerNetwork(t, p)
+
+	lb, err := p.Create(ctx, "lb", map[string]interface{}{"name": "web", "subnets": subnets})
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	groups := make(map[string]string)
+	for _, name := range []string{"web", "api"} {
+		tg, err := p.Create(ctx, "lb_target_group", map[string]interface{}{"name": name, "port": 80, "vpc_id": vpcID})
+		if err != nil {
+			t.Fatalf("Failed to create target group: %v", err)
+		}
+		groups[name] = tg.GetID()
+	}
+
+	forward := func(group string) map[string]interface{} {
+		return map[string]interface{}{"type": "forward", "target_group_arn": groups[group]}
+	}
+	config := map[string]interface{}{
+		"load_balancer_arn": lb.GetID(),
+		"port":              80,
+		"default_action":    forward("web"),
+		"rules": []interface{}{
+			map[string]interface{}{"priority": 10, "path_patterns": []interface{}{"/api/*"}, "action": forward("api")},
+			map[string]interface{}{
+				"priority":     20,
+				"host_headers": []interface{}{"old.example.com"},
+				"action":       map[string]interface{}{"type": "redirect", "host": "example.com"},
+			},
+		},
+	}
+
+	var listener provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		listener, err = p.Create(ctx, "lb_listener", config)
+		if err != nil {
+			t.Fatalf("Failed to create listener: %v", err)
+		}
+		got := cloud.ELB.Listeners("web")
+		if len(got) != 1 || got[0].Port != 80 || got[0].DefaultActions[0].TargetGroupArn != groups["web"] {
+			t.Fatalf("Unexpected listeners: %+v", got)
+		}
+		if rules := got[0].Rules; len(rules) != 2 || rules[0].PathPatterns[0] != "/api/*" || rules[1].Actions[0].Config["RedirectConfig.Host"] != "example.com" {
+			t.Errorf("Unexpected rules: %+v", rules)
+		}
+
+		meta := listener.GetMetadata()
+		if meta["protocol"] != "HTTP" || len(meta["rules"].([]interface{})) != 2 {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+		tg, _ := p.Read(ctx, "lb_target_group", groups["api"])
+		if arns := tg.GetMetadata()["load_balancer_arns"]; !reflect.DeepEqual(arns, []interface{}{lb.GetID()}) {
+			t.Errorf("Expected the target group to report its load balancer, got %v", arns)
+		}
+	})
+
+	t.Run("UpdateRules", func(t *testing.T) {
+		if err := p.Update(ctx, listener, config); err != nil {
+			t.Fatalf("Failed to reapply config: %v", err)
+		}
+		for _, action := range []string{"ModifyListener", "ModifyRule", "CreateRule", "DeleteRule"} {
+			if want, got := map[string]int{"CreateRule": 2}[action], cloud.ELB.Calls(action); got != want {
+				t.Errorf("Expected %d %s calls for an unchanged config, got %d", want, action, got)
+			}
+		}
+
+		updated := map[string]interface{}{
+			"load_balancer_arn": lb.GetID(),
+			"port":              8080,
+			"default_action":    map[string]interface{}{"type": "fixed_response", "status_code": "404"},
+			"rules": []interface{}{
+				map[string]interface{}{"priority": 10, "path_patterns": []interface{}{"/v2/*"}, "action": forward("api")},
+				map[string]interface{}{"priority": 30, "path_patterns": []interface{}{"/static/*"}, "action": forward("web")},
+			},
+		}
+		if err := p.Update(ctx, listener, updated); err != nil {
+			t.Fatalf("Failed to update listener: %v", err)
+		}
+		got := cloud.ELB.Listeners("web")[0]
+		if got.Port != 8080 || got.DefaultActions[0].Type != "fixed-response" {
+			t.Errorf("Expected a fixed response on port 8080, got %+v", got)
+		}
+		var priorities []int
+		for _, r := range got.Rules {
+			priorities = append(priorities, r.Priority)
+		}
+		if !reflect.DeepEqual(priorities, []int{10, 30}) || got.Rules[0].PathPatterns[0] != "/v2/*" {
+			t.Errorf("Expected rules 10 and 30, got %+v", got.Rules)
+		}
+		if cloud.ELB.Calls("ModifyRule") != 1 || cloud.ELB.Calls("DeleteRule") != 1 {
+			t.Errorf("Expected one rule modified and one deleted, got %d and %d", cloud.ELB.Calls("ModifyRule"), cloud.ELB.Calls("DeleteRule"))
+		}
+
+		moved := map[string]interface{}{"load_balancer_arn": "arn:other", "port": 8080, "default_action": forward("web")}
+		if err := p.Update(ctx, listener, moved); err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected moving the listener to require replacement, got %v", err)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		for name, rules := range map[string][]interface{}{
+			"NoConditions":      {map[string]interface{}{"priority": 1, "action": forward("web")}},
+			"DuplicatePriority": {config["rules"].([]interface{})[0], config["rules"].([]interface{})[0]},
+			"UnknownAction":     {map[string]interface{}{"priority": 1, "path_patterns": []interface{}{"/"}, "action": map[string]interface{}{"type": "drop"}}},
+		} {
+			bad := map[string]interface{}{"load_balancer_arn": lb.GetID(), "port": 81, "default_action": forward("web"), "rules": rules}
+			if _, err := p.Create(ctx, "lb_listener", bad); err == nil {
+				t.Errorf("%s: expected error, got nil", name)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		tg, _ := p.Read(ctx, "lb_target_group", groups["api"])
+		short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		if err := p.Delete(short, tg); err == nil {
+			t.Fatal("Expected deleting a target group in use to fail, got nil")
+		}
+
+		if err := p.Delete(ctx, listener); err != nil {
+			t.Fatalf("Failed to delete listener: %v", err)
+		}
+		if got := cloud.ELB.Listeners("web"); len(got) != 0 {
+			t.Errorf("Expected no listeners, got %+v", got)
+		}
+		if err := p.Delete(ctx, tg); err != nil {
+			t.Errorf("Failed to delete target group after its listener: %v", err)
+		}
+	})
+}
+
+func TestTargetGroupAttachments(t *testing.T) {
+	p, cloud := newCloudTestProvider(t)
+	ctx := context.Background()
+
+	tg, err := p.Create(ctx, "lb_target_group", map[string]interface{}{"name": "web", "port": 80, "vpc_id": "vpc-1"})
+	if err != nil {
+		t.Fatalf("Failed to create target group: %v", err)
+	}
+	instance, err := p.Create(ctx, "instance", map[string]interface{}{"ami": "ami-1", "instance_type": "t3.micro"})
+	if err != nil {
+		t.Fatalf("Failed to create instance: %v", err)
+	}
+
+	config := map[string]interface{}{"target_group_arn": tg.GetID(), "target_id": instance.GetID(), "port": 8080}
+	var attachment provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		attachment, err = p.Create(ctx, "lb_target_group_attachment", config)
+		if err != nil {
+			t.Fatalf("Failed to register target: %v", err)
+		}
+		if want := tg.GetID() + "," + instance.GetID() + ",8080"; attachment.GetID() != want {
+			t.Errorf("Expected ID %s, got %s", want, attachment.GetID())
+		}
+		if attachment.GetMetadata()["health"] != "healthy" {
+			t.Errorf("Unexpected metadata: %v", attachment.GetMetadata())
+		}
+		got, _ := cloud.ELB.TargetGroup("web")
+		if !reflect.DeepEqual(got.Targets, []awstest.Target{{ID: instance.GetID(), Port: 8080}}) {
+			t.Errorf("Unexpected targets: %+v", got.Targets)
+		}
+
+		if _, err := p.Create(ctx, "lb_target_group_attachment", map[string]interface{}{"target_group_arn": tg.GetID(), "target_id": "i-missing"}); err == nil {
+			t.Error("Expected error registering an instance that does not exist, got nil")
+		}
+	})
+
+	t.Run("ReplaceRequired", func(t *testing.T) {
+		if err := p.Update(ctx, attachment, config); err != nil {
+			t.Errorf("Expected an unchanged config to succeed, got %v", err)
+		}
+		moved := map[string]interface{}{"target_group_arn": tg.GetID(), "target_id": instance.GetID(), "port": 9090}
+		if err := p.Update(ctx, attachment, moved); err == nil || !strings.Contains(err.Error(), "changing port") {
+			t.Errorf("Expected changing port to require replacement, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, attachment); err != nil {
+			t.Fatalf("Failed to deregister target: %v", err)
+		}
+		if _, err := p.Read(ctx, "lb_target_group_attachment", attachment.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		if err := p.Delete(ctx, attachment); err != nil {
+			t.Errorf("Expected deregistering again to succeed, got %v", err)
+		}
+	})
+
+	t.Run("InvalidID", func(t *testing.T) {
+		if _, err := p.Read(ctx, "lb_target_group_attachment", "no-separator"); err == nil {
+			t.Error("Expected error for an invalid ID, got nil")
+		}
+	})
+}
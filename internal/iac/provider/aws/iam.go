@@ -0,0 +1,1032 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// maxPolicyVersions is how many versions IAM keeps of a managed policy
+const maxPolicyVersions = 5
+
+type IAMClient struct {
+	client *iam.Client
+}
+
+func NewIAMClient(cfg aws.Config) (*IAMClient, error) {
+	return &IAMClient{client: iam.NewFromConfig(cfg)}, nil
+}
+
+// roleSpec is the desired configuration of an IAM role
+type roleSpec struct {
+	tags                map[string]string
+	name                string
+	path                string
+	description         string
+	assumeRolePolicy    string
+	permissionsBoundary string
+	maxSessionDuration  int
+}
+
+func parseRoleSpec(config map[string]interface{}) (*roleSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("name", "assume_role_policy")
+
+	spec := &roleSpec{
+		name:                attrs.String("name"),
+		path:                attrs.String("path"),
+		description:         attrs.String("description"),
+		assumeRolePolicy:    attrs.Policy("assume_role_policy"),
+		permissionsBoundary: attrs.String("permissions_boundary"),
+		maxSessionDuration:  attrs.Int("max_session_duration"),
+		tags:                attrs.StringMap("tags"),
+	}
+	if spec.path == "" {
+		spec.path = "/"
+	}
+	if spec.maxSessionDuration == 0 {
+		spec.maxSessionDuration = 3600
+	}
+
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	if spec.maxSessionDuration < 3600 || spec.maxSessionDuration > 43200 {
+		return nil, fmt.Errorf("max_session_duration must be between 3600 and 43200 seconds, got %d", spec.maxSessionDuration)
+	}
+	return spec, nil
+}
+
+// CreateRole creates an IAM role. Roles are named by the config, so the
+// idempotency token tag only lets a retried create adopt its own role.
+func (c *IAMClient) CreateRole(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseRoleSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.client.CreateRole(ctx, &iam.CreateRoleInput{
+		RoleName:                 aws.String(spec.name),
+		Path:                     aws.String(spec.path),
+		AssumeRolePolicyDocument: aws.String(spec.assumeRolePolicy),
+		Description:              optionalString(spec.description),
+		MaxSessionDuration:       aws.Int32(int32(spec.maxSessionDuration)),
+		PermissionsBoundary:      optionalString(spec.permissionsBoundary),
+		Tags:                     iamCreateTags(ctx, spec.tags),
+	})
+	if errorCode(err) == "EntityAlreadyExists" {
+		return adopt(ctx, "role", spec.name, c.ReadRole)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create role %s: %w", spec.name, err)
+	}
+	return c.ReadRole(ctx, spec.name)
+}
+
+// ReadRole returns the current state of a role
+func (c *IAMClient) ReadRole(ctx context.Context, name string) (*types.BaseResource, error) {
+	role, err := c.getRole(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return roleResource(*role)
+}
+
+// FindRoleByToken returns the role created with the given idempotency
+// token. IAM cannot filter by tag, so each role's tags are read in turn.
+func (c *IAMClient) FindRoleByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	pages := iam.NewListRolesPaginator(c.client, &iam.ListRolesInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find role by token: %w", err)
+		}
+		for _, role := range page.Roles {
+			result, err := c.client.ListRoleTags(ctx, &iam.ListRoleTagsInput{RoleName: role.RoleName})
+			if err != nil {
+				continue
+			}
+			if fromIAMTags(result.Tags)[tokenTag] == token {
+				return c.ReadRole(ctx, aws.ToString(role.RoleName))
+			}
+		}
+	}
+	return nil, provider.ErrNotFound
+}
+
+// UpdateRole applies changes to a role's trust policy, description,
+// session duration, permissions boundary and tags. Its name and path are
+// fixed at creation.
+func (c *IAMClient) UpdateRole(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseRoleSpec(config)
+	if err != nil {
+		return err
+	}
+
+	name := resource.GetID()
+	role, err := c.getRole(ctx, name)
+	if err != nil {
+		return err
+	}
+	for attr, values := range map[string][2]string{
+		"name": {spec.name, aws.ToString(role.RoleName)},
+		"path": {spec.path, aws.ToString(role.Path)},
+	} {
+		if values[0] != values[1] {
+			return fmt.Errorf("changing %s of role %s requires replacing it", attr, name)
+		}
+	}
+
+	current, err := decodePolicy(aws.ToString(role.AssumeRolePolicyDocument))
+	if err != nil {
+		return err
+	}
+	if spec.assumeRolePolicy != current {
+		_, err := c.client.UpdateAssumeRolePolicy(ctx, &iam.UpdateAssumeRolePolicyInput{
+			RoleName:       aws.String(name),
+			PolicyDocument: aws.String(spec.assumeRolePolicy),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update trust policy of role %s: %w", name, err)
+		}
+	}
+
+	if spec.description != aws.ToString(role.Description) || int32(spec.maxSessionDuration) != aws.ToInt32(role.MaxSessionDuration) {
+		_, err := c.client.UpdateRole(ctx, &iam.UpdateRoleInput{
+			RoleName:           aws.String(name),
+			Description:        aws.String(spec.description),
+			MaxSessionDuration: aws.Int32(int32(spec.maxSessionDuration)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update role %s: %w", name, err)
+		}
+	}
+
+	boundary := ""
+	if role.PermissionsBoundary != nil {
+		boundary = aws.ToString(role.PermissionsBoundary.PermissionsBoundaryArn)
+	}
+	if spec.permissionsBoundary != boundary {
+		if spec.permissionsBoundary == "" {
+			_, err = c.client.DeleteRolePermissionsBoundary(ctx, &iam.DeleteRolePermissionsBoundaryInput{RoleName: aws.String(name)})
+		} else {
+			_, err = c.client.PutRolePermissionsBoundary(ctx, &iam.PutRolePermissionsBoundaryInput{
+				RoleName:            aws.String(name),
+				PermissionsBoundary: aws.String(spec.permissionsBoundary),
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set permissions boundary of role %s: %w", name, err)
+		}
+	}
+
+	return updateIAMTags(fromIAMTags(role.Tags), spec.tags,
+		func(tags []iamtypes.Tag) error {
+			_, err := c.client.TagRole(ctx, &iam.TagRoleInput{RoleName: aws.String(name), Tags: tags})
+			return err
+		},
+		func(keys []string) error {
+			_, err := c.client.UntagRole(ctx, &iam.UntagRoleInput{RoleName: aws.String(name), TagKeys: keys})
+			return err
+		})
+}
+
+// DeleteRole deletes a role. IAM refuses while policies are attached to it
+// or it is in an instance profile; duet removes those first when it manages
+// them, as they reference the role.
+func (c *IAMClient) DeleteRole(ctx context.Context, resource provider.Resource) error {
+	name := resource.GetID()
+	_, err := c.client.DeleteRole(ctx, &iam.DeleteRoleInput{RoleName: aws.String(name)})
+	if err != nil && !isIAMNotFound(err) {
+		return fmt.Errorf("failed to delete role %s: %w", name, err)
+	}
+	return nil
+}
+
+func (c *IAMClient) getRole(ctx context.Context, name string) (*iamtypes.Role, error) {
+	result, err := c.client.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(name)})
+	if isIAMNotFound(err) {
+		return nil, fmt.Errorf("role %s: %w", name, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read role %s: %w", name, err)
+	}
+	return result.Role, nil
+}
+
+func roleResource(role iamtypes.Role) (*types.BaseResource, error) {
+	trust, err := decodePolicy(aws.ToString(role.AssumeRolePolicyDocument))
+	if err != nil {
+		return nil, err
+	}
+	boundary := ""
+	if role.PermissionsBoundary != nil {
+		boundary = aws.ToString(role.PermissionsBoundary.PermissionsBoundaryArn)
+	}
+	return &types.BaseResource{
+		ID:       aws.ToString(role.RoleName),
+		Type:     types.ResourceTypeIAMRole,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"name":                 aws.ToString(role.RoleName),
+			"arn":                  aws.ToString(role.Arn),
+			"unique_id":            aws.ToString(role.RoleId),
+			"path":                 aws.ToString(role.Path),
+			"description":          aws.ToString(role.Description),
+			"assume_role_policy":   trust,
+			"max_session_duration": int(aws.ToInt32(role.MaxSessionDuration)),
+			"permissions_boundary": boundary,
+		},
+		Tags:      fromIAMTags(role.Tags),
+		CreatedAt: aws.ToTime(role.CreateDate),
+		UpdatedAt: time.Now(),
+	}, nil
+}
+
+// policySpec is the desired configuration of a customer managed policy
+type policySpec struct {
+	tags        map[string]string
+	name        string
+	path        string
+	description string
+	document    string
+}
+
+func parsePolicySpec(config map[string]interface{}) (*policySpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("name", "policy")
+
+	spec := &policySpec{
+		name:        attrs.String("name"),
+		path:        attrs.String("path"),
+		description: attrs.String("description"),
+		document:    attrs.Policy("policy"),
+		tags:        attrs.StringMap("tags"),
+	}
+	if spec.path == "" {
+		spec.path = "/"
+	}
+
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// CreatePolicy creates a customer managed policy. Its ID is its ARN.
+func (c *IAMClient) CreatePolicy(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parsePolicySpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.client.CreatePolicy(ctx, &iam.CreatePolicyInput{
+		PolicyName:     aws.String(spec.name),
+		Path:           aws.String(spec.path),
+		PolicyDocument: aws.String(spec.document),
+		Description:    optionalString(spec.description),
+		Tags:           iamCreateTags(ctx, spec.tags),
+	})
+	if errorCode(err) == "EntityAlreadyExists" {
+		found, err := c.FindPolicyByToken(ctx, provider.IdempotencyToken(ctx))
+		if err != nil || provider.IdempotencyToken(ctx) == "" {
+			return nil, fmt.Errorf("policy %s already exists and is not managed by this run", spec.name)
+		}
+		return found, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create policy %s: %w", spec.name, err)
+	}
+	return c.ReadPolicy(ctx, aws.ToString(result.Policy.Arn))
+}
+
+// ReadPolicy returns the current state of a managed policy, including its
+// default version's document
+func (c *IAMClient) ReadPolicy(ctx context.Context, arn string) (*types.BaseResource, error) {
+	policy, document, err := c.getPolicy(ctx, arn)
+	if err != nil {
+		return nil, err
+	}
+	return policyResource(*policy, document), nil
+}
+
+// FindPolicyByToken returns the policy created with the given idempotency
+// token
+func (c *IAMClient) FindPolicyByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	pages := iam.NewListPoliciesPaginator(c.client, &iam.ListPoliciesInput{Scope: iamtypes.PolicyScopeTypeLocal})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find policy by token: %w", err)
+		}
+		for _, policy := range page.Policies {
+			result, err := c.client.ListPolicyTags(ctx, &iam.ListPolicyTagsInput{PolicyArn: policy.Arn})
+			if err != nil {
+				continue
+			}
+			if fromIAMTags(result.Tags)[tokenTag] == token {
+				return c.ReadPolicy(ctx, aws.ToString(policy.Arn))
+			}
+		}
+	}
+	return nil, provider.ErrNotFound
+}
+
+// UpdatePolicy publishes a changed document as a new default version,
+// deleting the oldest version first if IAM's limit is reached. The name,
+// path and description are fixed at creation.
+func (c *IAMClient) UpdatePolicy(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parsePolicySpec(config)
+	if err != nil {
+		return err
+	}
+
+	arn := resource.GetID()
+	policy, document, err := c.getPolicy(ctx, arn)
+	if err != nil {
+		return err
+	}
+	for attr, values := range map[string][2]string{
+		"name":        {spec.name, aws.ToString(policy.PolicyName)},
+		"path":        {spec.path, aws.ToString(policy.Path)},
+		"description": {spec.description, aws.ToString(policy.Description)},
+	} {
+		if values[0] != values[1] {
+			return fmt.Errorf("changing %s of policy %s requires replacing it", attr, arn)
+		}
+	}
+
+	if spec.document != document {
+		versions, err := c.policyVersions(ctx, arn)
+		if err != nil {
+			return err
+		}
+		// The default version counts towards the limit too
+		if excess := len(versions) + 1 - (maxPolicyVersions - 1); excess > 0 {
+			if err := c.deletePolicyVersions(ctx, arn, versions[:excess]); err != nil {
+				return err
+			}
+		}
+		_, err = c.client.CreatePolicyVersion(ctx, &iam.CreatePolicyVersionInput{
+			PolicyArn:      aws.String(arn),
+			PolicyDocument: aws.String(spec.document),
+			SetAsDefault:   true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update policy %s: %w", arn, err)
+		}
+	}
+
+	return updateIAMTags(fromIAMTags(policy.Tags), spec.tags,
+		func(tags []iamtypes.Tag) error {
+			_, err := c.client.TagPolicy(ctx, &iam.TagPolicyInput{PolicyArn: aws.String(arn), Tags: tags})
+			return err
+		},
+		func(keys []string) error {
+			_, err := c.client.UntagPolicy(ctx, &iam.UntagPolicyInput{PolicyArn: aws.String(arn), TagKeys: keys})
+			return err
+		})
+}
+
+// DeletePolicy deletes a managed policy and its old versions
+func (c *IAMClient) DeletePolicy(ctx context.Context, resource provider.Resource) error {
+	arn := resource.GetID()
+	versions, err := c.policyVersions(ctx, arn)
+	if isIAMNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.deletePolicyVersions(ctx, arn, versions); err != nil {
+		return err
+	}
+
+	_, err = c.client.DeletePolicy(ctx, &iam.DeletePolicyInput{PolicyArn: aws.String(arn)})
+	if err != nil && !isIAMNotFound(err) {
+		return fmt.Errorf("failed to delete policy %s: %w", arn, err)
+	}
+	return nil
+}
+
+func (c *IAMClient) getPolicy(ctx context.Context, arn string) (*iamtypes.Policy, string, error) {
+	result, err := c.client.GetPolicy(ctx, &iam.GetPolicyInput{PolicyArn: aws.String(arn)})
+	if isIAMNotFound(err) {
+		return nil, "", fmt.Errorf("policy %s: %w", arn, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read policy %s: %w", arn, err)
+	}
+
+	version, err := c.client.GetPolicyVersion(ctx, &iam.GetPolicyVersionInput{
+		PolicyArn: aws.String(arn),
+		VersionId: result.Policy.DefaultVersionId,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read document of policy %s: %w", arn, err)
+	}
+	document, err := decodePolicy(aws.ToString(version.PolicyVersion.Document))
+	if err != nil {
+		return nil, "", err
+	}
+
+	// GetPolicy does not report tags everywhere, so they are listed
+	if result.Policy.Tags == nil {
+		tags, err := c.client.ListPolicyTags(ctx, &iam.ListPolicyTagsInput{PolicyArn: aws.String(arn)})
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read tags of policy %s: %w", arn, err)
+		}
+		result.Policy.Tags = tags.Tags
+	}
+	return result.Policy, document, nil
+}
+
+// policyVersions returns the versions of a policy other than the default,
+// oldest first
+func (c *IAMClient) policyVersions(ctx context.Context, arn string) ([]iamtypes.PolicyVersion, error) {
+	result, err := c.client.ListPolicyVersions(ctx, &iam.ListPolicyVersionsInput{PolicyArn: aws.String(arn)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of policy %s: %w", arn, err)
+	}
+	var versions []iamtypes.PolicyVersion
+	for _, v := range result.Versions {
+		if !v.IsDefaultVersion {
+			versions = append(versions, v)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return aws.ToTime(versions[i].CreateDate).Before(aws.ToTime(versions[j].CreateDate)) ||
+			aws.ToTime(versions[i].CreateDate).Equal(aws.ToTime(versions[j].CreateDate)) && versionNumber(versions[i]) < versionNumber(versions[j])
+	})
+	return versions, nil
+}
+
+func (c *IAMClient) deletePolicyVersions(ctx context.Context, arn string, versions []iamtypes.PolicyVersion) error {
+	for _, v := range versions {
+		_, err := c.client.DeletePolicyVersion(ctx, &iam.DeletePolicyVersionInput{
+			PolicyArn: aws.String(arn),
+			VersionId: v.VersionId,
+		})
+		if err != nil && !isIAMNotFound(err) {
+			return fmt.Errorf("failed to delete version %s of policy %s: %w", aws.ToString(v.VersionId), arn, err)
+		}
+	}
+	return nil
+}
+
+// versionNumber orders versions created within the same second
+func versionNumber(v iamtypes.PolicyVersion) int {
+	var n int
+	fmt.Sscanf(aws.ToString(v.VersionId), "v%d", &n)
+	return n
+}
+
+func policyResource(policy iamtypes.Policy, document string) *types.BaseResource {
+	return &types.BaseResource{
+		ID:       aws.ToString(policy.Arn),
+		Type:     types.ResourceTypeIAMPolicy,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"name":               aws.ToString(policy.PolicyName),
+			"arn":                aws.ToString(policy.Arn),
+			"policy_id":          aws.ToString(policy.PolicyId),
+			"path":               aws.ToString(policy.Path),
+			"description":        aws.ToString(policy.Description),
+			"default_version_id": aws.ToString(policy.DefaultVersionId),
+			"policy":             document,
+		},
+		Tags:      fromIAMTags(policy.Tags),
+		CreatedAt: aws.ToTime(policy.CreateDate),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// rolePolicySpec is the desired configuration of a role's inline policy
+type rolePolicySpec struct {
+	role     string
+	name     string
+	document string
+}
+
+func parseRolePolicySpec(config map[string]interface{}) (*rolePolicySpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("role", "name", "policy")
+
+	spec := &rolePolicySpec{
+		role:     attrs.String("role"),
+		name:     attrs.String("name"),
+		document: attrs.Policy("policy"),
+	}
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// CreateRolePolicy puts an inline policy on a role. Its ID is
+// "role:policy".
+func (c *IAMClient) CreateRolePolicy(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseRolePolicySpec(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.putRolePolicy(ctx, spec); err != nil {
+		return nil, err
+	}
+	return c.ReadRolePolicy(ctx, spec.role+":"+spec.name)
+}
+
+// ReadRolePolicy returns the current state of an inline policy
+func (c *IAMClient) ReadRolePolicy(ctx context.Context, id string) (*types.BaseResource, error) {
+	role, name, ok := strings.Cut(id, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid role policy ID %q, expected role:policy", id)
+	}
+	result, err := c.client.GetRolePolicy(ctx, &iam.GetRolePolicyInput{
+		RoleName:   aws.String(role),
+		PolicyName: aws.String(name),
+	})
+	if isIAMNotFound(err) {
+		return nil, fmt.Errorf("role policy %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read role policy %s: %w", id, err)
+	}
+	document, err := decodePolicy(aws.ToString(result.PolicyDocument))
+	if err != nil {
+		return nil, err
+	}
+	return &types.BaseResource{
+		ID:       id,
+		Type:     types.ResourceTypeIAMRolePolicy,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"role":   role,
+			"name":   name,
+			"policy": document,
+		},
+		UpdatedAt: time.Now(),
+	}, nil
+}
+
+// FindRolePolicyByToken always reports provider.ErrNotFound. Inline
+// policies cannot be tagged, but putting one is idempotent, so a retried
+// create is safe.
+func (c *IAMClient) FindRolePolicyByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	return nil, provider.ErrNotFound
+}
+
+// UpdateRolePolicy replaces the document of an inline policy. Moving it to
+// another role or renaming it requires replacing it.
+func (c *IAMClient) UpdateRolePolicy(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseRolePolicySpec(config)
+	if err != nil {
+		return err
+	}
+	id := resource.GetID()
+	if spec.role+":"+spec.name != id {
+		return fmt.Errorf("changing role or name of role policy %s requires replacing it", id)
+	}
+
+	current, err := c.ReadRolePolicy(ctx, id)
+	if err != nil {
+		return err
+	}
+	if current.Metadata["policy"] == spec.document {
+		return nil
+	}
+	return c.putRolePolicy(ctx, spec)
+}
+
+// DeleteRolePolicy removes an inline policy from its role
+func (c *IAMClient) DeleteRolePolicy(ctx context.Context, resource provider.Resource) error {
+	id := resource.GetID()
+	role, name, _ := strings.Cut(id, ":")
+	_, err := c.client.DeleteRolePolicy(ctx, &iam.DeleteRolePolicyInput{
+		RoleName:   aws.String(role),
+		PolicyName: aws.String(name),
+	})
+	if err != nil && !isIAMNotFound(err) {
+		return fmt.Errorf("failed to delete role policy %s: %w", id, err)
+	}
+	return nil
+}
+
+func (c *IAMClient) putRolePolicy(ctx context.Context, spec *rolePolicySpec) error {
+	_, err := c.client.PutRolePolicy(ctx, &iam.PutRolePolicyInput{
+		RoleName:       aws.String(spec.role),
+		PolicyName:     aws.String(spec.name),
+		PolicyDocument: aws.String(spec.document),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put policy %s on role %s: %w", spec.name, spec.role, err)
+	}
+	return nil
+}
+
+// CreateRolePolicyAttachment attaches a managed policy to a role. Its ID is
+// "role:policy_arn"; role names cannot contain a colon.
+func (c *IAMClient) CreateRolePolicyAttachment(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	attrs := newAttributes(config)
+	attrs.Require("role", "policy_arn")
+	role, arn := attrs.String("role"), attrs.String("policy_arn")
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err := c.client.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
+		RoleName:  aws.String(role),
+		PolicyArn: aws.String(arn),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach policy %s to role %s: %w", arn, role, err)
+	}
+	return c.ReadRolePolicyAttachment(ctx, role+":"+arn)
+}
+
+// ReadRolePolicyAttachment reports whether a managed policy is still
+// attached to a role
+func (c *IAMClient) ReadRolePolicyAttachment(ctx context.Context, id string) (*types.BaseResource, error) {
+	role, arn, ok := strings.Cut(id, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid role policy attachment ID %q, expected role:policy_arn", id)
+	}
+
+	pages := iam.NewListAttachedRolePoliciesPaginator(c.client, &iam.ListAttachedRolePoliciesInput{RoleName: aws.String(role)})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if isIAMNotFound(err) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list policies attached to role %s: %w", role, err)
+		}
+		for _, p := range page.AttachedPolicies {
+			if aws.ToString(p.PolicyArn) == arn {
+				return &types.BaseResource{
+					ID:       id,
+					Type:     types.ResourceTypeIAMRolePolicyAttachment,
+					Provider: "aws",
+					Status:   types.StatusRunning,
+					Metadata: map[string]interface{}{
+						"role":        role,
+						"policy_arn":  arn,
+						"policy_name": aws.ToString(p.PolicyName),
+					},
+					UpdatedAt: time.Now(),
+				}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("role policy attachment %s: %w", id, provider.ErrNotFound)
+}
+
+// FindRolePolicyAttachmentByToken always reports provider.ErrNotFound.
+// Attaching a policy twice is harmless, so a retried create is safe.
+func (c *IAMClient) FindRolePolicyAttachmentByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	return nil, provider.ErrNotFound
+}
+
+// UpdateRolePolicyAttachment rejects any change, as an attachment is only
+// its role and policy
+func (c *IAMClient) UpdateRolePolicyAttachment(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	attrs := newAttributes(config)
+	attrs.Require("role", "policy_arn")
+	if err := attrs.Err(); err != nil {
+		return err
+	}
+	if id := attrs.String("role") + ":" + attrs.String("policy_arn"); id != resource.GetID() {
+		return fmt.Errorf("changing role policy attachment %s requires replacing it", resource.GetID())
+	}
+	return nil
+}
+
+// DeleteRolePolicyAttachment detaches a managed policy from a role
+func (c *IAMClient) DeleteRolePolicyAttachment(ctx context.Context, resource provider.Resource) error {
+	id := resource.GetID()
+	role, arn, _ := strings.Cut(id, ":")
+	_, err := c.client.DetachRolePolicy(ctx, &iam.DetachRolePolicyInput{
+		RoleName:  aws.String(role),
+		PolicyArn: aws.String(arn),
+	})
+	if err != nil && !isIAMNotFound(err) {
+		return fmt.Errorf("failed to detach policy %s from role %s: %w", arn, role, err)
+	}
+	return nil
+}
+
+// instanceProfileSpec is the desired configuration of an instance profile
+type instanceProfileSpec struct {
+	tags map[string]string
+	name string
+	path string
+	role string
+}
+
+func parseInstanceProfileSpec(config map[string]interface{}) (*instanceProfileSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("name")
+
+	spec := &instanceProfileSpec{
+		name: attrs.String("name"),
+		path: attrs.String("path"),
+		role: attrs.String("role"),
+		tags: attrs.StringMap("tags"),
+	}
+	if spec.path == "" {
+		spec.path = "/"
+	}
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// CreateInstanceProfile creates an instance profile holding at most one
+// role, which is how EC2 instances assume a role
+func (c *IAMClient) CreateInstanceProfile(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseInstanceProfileSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.client.CreateInstanceProfile(ctx, &iam.CreateInstanceProfileInput{
+		InstanceProfileName: aws.String(spec.name),
+		Path:                aws.String(spec.path),
+		Tags:                iamCreateTags(ctx, spec.tags),
+	})
+	if errorCode(err) == "EntityAlreadyExists" {
+		existing, err := adopt(ctx, "instance profile", spec.name, c.ReadInstanceProfile)
+		if err != nil {
+			return nil, err
+		}
+		if existing.Metadata["role"] == spec.role {
+			return existing, nil
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to create instance profile %s: %w", spec.name, err)
+	}
+
+	// The profile exists from here on, so it is returned even if adding
+	// the role fails, letting the caller track it
+	if spec.role != "" {
+		_, err := c.client.AddRoleToInstanceProfile(ctx, &iam.AddRoleToInstanceProfileInput{
+			InstanceProfileName: aws.String(spec.name),
+			RoleName:            aws.String(spec.role),
+		})
+		if err != nil {
+			profile, _ := c.ReadInstanceProfile(ctx, spec.name)
+			return profile, fmt.Errorf("failed to add role %s to instance profile %s: %w", spec.role, spec.name, err)
+		}
+	}
+	return c.ReadInstanceProfile(ctx, spec.name)
+}
+
+// ReadInstanceProfile returns the current state of an instance profile
+func (c *IAMClient) ReadInstanceProfile(ctx context.Context, name string) (*types.BaseResource, error) {
+	profile, err := c.getInstanceProfile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return instanceProfileResource(*profile), nil
+}
+
+// FindInstanceProfileByToken returns the instance profile created with the
+// given idempotency token
+func (c *IAMClient) FindInstanceProfileByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	pages := iam.NewListInstanceProfilesPaginator(c.client, &iam.ListInstanceProfilesInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find instance profile by token: %w", err)
+		}
+		for _, profile := range page.InstanceProfiles {
+			result, err := c.client.ListInstanceProfileTags(ctx, &iam.ListInstanceProfileTagsInput{
+				InstanceProfileName: profile.InstanceProfileName,
+			})
+			if err != nil {
+				continue
+			}
+			if fromIAMTags(result.Tags)[tokenTag] == token {
+				return c.ReadInstanceProfile(ctx, aws.ToString(profile.InstanceProfileName))
+			}
+		}
+	}
+	return nil, provider.ErrNotFound
+}
+
+// UpdateInstanceProfile swaps the profile's role and applies tag changes.
+// Its name and path are fixed at creation.
+func (c *IAMClient) UpdateInstanceProfile(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseInstanceProfileSpec(config)
+	if err != nil {
+		return err
+	}
+
+	name := resource.GetID()
+	profile, err := c.getInstanceProfile(ctx, name)
+	if err != nil {
+		return err
+	}
+	for attr, values := range map[string][2]string{
+		"name": {spec.name, aws.ToString(profile.InstanceProfileName)},
+		"path": {spec.path, aws.ToString(profile.Path)},
+	} {
+		if values[0] != values[1] {
+			return fmt.Errorf("changing %s of instance profile %s requires replacing it", attr, name)
+		}
+	}
+
+	current := profileRole(*profile)
+	if spec.role != current {
+		if current != "" {
+			_, err := c.client.RemoveRoleFromInstanceProfile(ctx, &iam.RemoveRoleFromInstanceProfileInput{
+				InstanceProfileName: aws.String(name),
+				RoleName:            aws.String(current),
+			})
+			if err != nil && !isIAMNotFound(err) {
+				return fmt.Errorf("failed to remove role %s from instance profile %s: %w", current, name, err)
+			}
+		}
+		if spec.role != "" {
+			_, err := c.client.AddRoleToInstanceProfile(ctx, &iam.AddRoleToInstanceProfileInput{
+				InstanceProfileName: aws.String(name),
+				RoleName:            aws.String(spec.role),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to add role %s to instance profile %s: %w", spec.role, name, err)
+			}
+		}
+	}
+
+	return updateIAMTags(fromIAMTags(profile.Tags), spec.tags,
+		func(tags []iamtypes.Tag) error {
+			_, err := c.client.TagInstanceProfile(ctx, &iam.TagInstanceProfileInput{InstanceProfileName: aws.String(name), Tags: tags})
+			return err
+		},
+		func(keys []string) error {
+			_, err := c.client.UntagInstanceProfile(ctx, &iam.UntagInstanceProfileInput{InstanceProfileName: aws.String(name), TagKeys: keys})
+			return err
+		})
+}
+
+// DeleteInstanceProfile removes the profile's role, which IAM requires,
+// then deletes the profile
+func (c *IAMClient) DeleteInstanceProfile(ctx context.Context, resource provider.Resource) error {
+	name := resource.GetID()
+	profile, err := c.getInstanceProfile(ctx, name)
+	if err != nil {
+		if errors.Is(err, provider.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	for _, role := range profile.Roles {
+		_, err := c.client.RemoveRoleFromInstanceProfile(ctx, &iam.RemoveRoleFromInstanceProfileInput{
+			InstanceProfileName: aws.String(name),
+			RoleName:            role.RoleName,
+		})
+		if err != nil && !isIAMNotFound(err) {
+			return fmt.Errorf("failed to remove role %s from instance profile %s: %w", aws.ToString(role.RoleName), name, err)
+		}
+	}
+
+	_, err = c.client.DeleteInstanceProfile(ctx, &iam.DeleteInstanceProfileInput{InstanceProfileName: aws.String(name)})
+	if err != nil && !isIAMNotFound(err) {
+		return fmt.Errorf("failed to delete instance profile %s: %w", name, err)
+	}
+	return nil
+}
+
+func (c *IAMClient) getInstanceProfile(ctx context.Context, name string) (*iamtypes.InstanceProfile, error) {
+	result, err := c.client.GetInstanceProfile(ctx, &iam.GetInstanceProfileInput{InstanceProfileName: aws.String(name)})
+	if isIAMNotFound(err) {
+		return nil, fmt.Errorf("instance profile %s: %w", name, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance profile %s: %w", name, err)
+	}
+	return result.InstanceProfile, nil
+}
+
+func profileRole(profile iamtypes.InstanceProfile) string {
+	if len(profile.Roles) == 0 {
+		return ""
+	}
+	return aws.ToString(profile.Roles[0].RoleName)
+}
+
+func instanceProfileResource(profile iamtypes.InstanceProfile) *types.BaseResource {
+	return &types.BaseResource{
+		ID:       aws.ToString(profile.InstanceProfileName),
+		Type:     types.ResourceTypeIAMInstanceProfile,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"name":      aws.ToString(profile.InstanceProfileName),
+			"arn":       aws.ToString(profile.Arn),
+			"unique_id": aws.ToString(profile.InstanceProfileId),
+			"path":      aws.ToString(profile.Path),
+			"role":      profileRole(profile),
+		},
+		Tags:      fromIAMTags(profile.Tags),
+		CreatedAt: aws.ToTime(profile.CreateDate),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// adopt returns an existing named resource if this run's idempotency token
+// created it, and an error otherwise
+func adopt(ctx context.Context, what, name string, read func(context.Context, string) (*types.BaseResource, error)) (*types.BaseResource, error) {
+	existing, err := read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	token := provider.IdempotencyToken(ctx)
+	if token == "" || existing.Tags[tokenTag] != token {
+		return nil, fmt.Errorf("%s %s already exists and is not managed by this run", what, name)
+	}
+	return existing, nil
+}
+
+// iamCreateTags returns tags plus the idempotency token tag, if any
+func iamCreateTags(ctx context.Context, tags map[string]string) []iamtypes.Tag {
+	all := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		all[k] = v
+	}
+	if token := provider.IdempotencyToken(ctx); token != "" {
+		all[tokenTag] = token
+	}
+	return toIAMTags(all)
+}
+
+// updateIAMTags applies the difference between current and desired tags
+// with the given tag and untag calls. Reserved tags are never removed.
+func updateIAMTags(current, desired map[string]string, tag func([]iamtypes.Tag) error, untag func([]string) error) error {
+	if desired == nil {
+		return nil
+	}
+
+	changed := make(map[string]string)
+	for k, v := range desired {
+		if cur, ok := current[k]; !ok || cur != v {
+			changed[k] = v
+		}
+	}
+	var removed []string
+	for _, k := range sortedKeys(current) {
+		if _, ok := desired[k]; !ok && !isReservedTag(k) {
+			removed = append(removed, k)
+		}
+	}
+
+	if len(changed) > 0 {
+		if err := tag(toIAMTags(changed)); err != nil {
+			return fmt.Errorf("failed to tag: %w", err)
+		}
+	}
+	if len(removed) > 0 {
+		if err := untag(removed); err != nil {
+			return fmt.Errorf("failed to remove tags: %w", err)
+		}
+	}
+	return nil
+}
+
+func toIAMTags(tags map[string]string) []iamtypes.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	out := make([]iamtypes.Tag, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		out = append(out, iamtypes.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return out
+}
+
+func fromIAMTags(tags []iamtypes.Tag) map[string]string {
+	out := make(map[string]string, len(tags))
+	for _, t := range tags {
+		out[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return out
+}
+
+// isIAMNotFound reports whether err means an IAM entity does not exist
+func isIAMNotFound(err error) bool {
+	return errorCode(err) == "NoSuchEntity"
+}
@@ -0,0 +1,478 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/internal/iac/provider/aws/awstest"
+)
+
+// newCloudTestProvider returns a provider whose EC2, IAM, ELB, Auto Scaling,
+// Route 53 and S3 calls all go to one in-memory stand-in
+func newCloudTestProvider(t *testing.T) (*AWSProvider, *awstest.Cloud) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+
+	cloud, server := awstest.NewCloudServer()
+	t.Cleanup(server.Close)
+
+	p, err := NewAWSProvider(context.Background(), "us-east-1", WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	p.ec2Client.pollInterval = time.Millisecond
+	p.elbClient.pollInterval = time.Millisecond
+	p.asgClient.pollInterval = time.Millisecond
+	p.r53Client.pollInterval = time.Millisecond
+	return p, cloud
+}
+
+// trustPolicy lets EC2 assume a role, written as a Lua table would arrive
+var trustPolicy = map[string]interface{}{
+	"Version": "2012-10-17",
+	"Statement": []interface{}{
+		map[string]interface{}{
+			"Effect":    "Allow",
+			"Principal": map[string]interface{}{"Service": "ec2.amazonaws.com"},
+			"Action":    "sts:AssumeRole",
+		},
+	},
+}
+
+func TestIAMRoles(t *testing.T) {
+	p, cloud := newCloudTestProvider(t)
+	ctx := context.Background()
+
+	config := map[string]interface{}{
+		"name":               "app",
+		"assume_role_policy": trustPolicy,
+		"description":        "application servers",
+		"tags":               map[string]interface{}{"team": "web"},
+	}
+
+	var role provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		role, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-app"), "iam_role", config)
+		if err != nil {
+			t.Fatalf("Failed to create role: %v", err)
+		}
+		if role.GetID() != "app" {
+			t.Errorf("Expected role ID app, got %s", role.GetID())
+		}
+		if arn := role.GetMetadata()["arn"]; arn != "arn:aws:iam::123456789012:role/app" {
+			t.Errorf("Unexpected ARN %v", arn)
+		}
+
+		got, ok := cloud.IAM.Role("app")
+		if !ok {
+			t.Fatal("Expected role app to exist")
+		}
+		if !strings.Contains(got.AssumeRolePolicy, `"Service":["ec2.amazonaws.com"]`) {
+			t.Errorf("Expected a normalised trust policy, got %s", got.AssumeRolePolicy)
+		}
+		if got.Tags["team"] != "web" || got.Tags[tokenTag] != "run-1-app" {
+			t.Errorf("Unexpected tags: %v", got.Tags)
+		}
+	})
+
+	t.Run("FindByToken", func(t *testing.T) {
+		found, err := p.FindByToken(ctx, "iam_role", "run-1-app")
+		if err != nil {
+			t.Fatalf("Failed to find role: %v", err)
+		}
+		if found.GetID() != "app" {
+			t.Errorf("Expected role app, got %s", found.GetID())
+		}
+		if _, err := p.FindByToken(ctx, "iam_role", "run-9"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("RetriedCreateAdopts", func(t *testing.T) {
+		again, err := p.Create(provider.WithIdempotencyToken(ctx, "run-1-app"), "iam_role", config)
+		if err != nil {
+			t.Fatalf("Failed to retry create: %v", err)
+		}
+		if again.GetID() != "app" {
+			t.Errorf("Expected role app, got %s", again.GetID())
+		}
+		if _, err := p.Create(provider.WithIdempotencyToken(ctx, "run-2-app"), "iam_role", config); err == nil {
+			t.Error("Expected error creating a role owned by another run")
+		}
+	})
+
+	t.Run("EquivalentTrustPolicyMakesNoCalls", func(t *testing.T) {
+		rewritten := map[string]interface{}{}
+		for k, v := range config {
+			rewritten[k] = v
+		}
+		rewritten["assume_role_policy"] = `{
+			"Statement": {"Action": ["sts:AssumeRole"], "Principal": {"Service": "ec2.amazonaws.com"}, "Effect": "Allow"},
+			"Version": "2012-10-17"
+		}`
+
+		before := cloud.IAM.Calls("UpdateAssumeRolePolicy") + cloud.IAM.Calls("UpdateRole") + cloud.IAM.Calls("TagRole")
+		if err := p.Update(ctx, role, rewritten); err != nil {
+			t.Fatalf("Failed to update role: %v", err)
+		}
+		after := cloud.IAM.Calls("UpdateAssumeRolePolicy") + cloud.IAM.Calls("UpdateRole") + cloud.IAM.Calls("TagRole")
+		if after != before {
+			t.Errorf("Expected no write calls, got %d", after-before)
+		}
+
+		normalized := p.NormalizeConfig("iam_role", rewritten)
+		if normalized["assume_role_policy"] != p.NormalizeConfig("iam_role", config)["assume_role_policy"] {
+			t.Error("Expected equivalent trust policies to normalise to the same document")
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		updated := map[string]interface{}{
+			"name":                 "app",
+			"assume_role_policy":   `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"lambda.amazonaws.com"},"Action":"sts:AssumeRole"}]}`,
+			"description":          "application servers and functions",
+			"max_session_duration": 7200,
+			"permissions_boundary": "arn:aws:iam::aws:policy/PowerUserAccess",
+			"tags":                 map[string]interface{}{"env": "prod"},
+		}
+		if err := p.Update(ctx, role, updated); err != nil {
+			t.Fatalf("Failed to update role: %v", err)
+		}
+
+		got, _ := cloud.IAM.Role("app")
+		if !strings.Contains(got.AssumeRolePolicy, "lambda.amazonaws.com") {
+			t.Errorf("Expected trust policy to change, got %s", got.AssumeRolePolicy)
+		}
+		if got.Description != "application servers and functions" || got.MaxSessionDuration != 7200 {
+			t.Errorf("Unexpected role: %+v", got)
+		}
+		if got.PermissionsBoundary != "arn:aws:iam::aws:policy/PowerUserAccess" {
+			t.Errorf("Expected permissions boundary, got %q", got.PermissionsBoundary)
+		}
+		if _, ok := got.Tags["team"]; ok || got.Tags["env"] != "prod" || got.Tags[tokenTag] == "" {
+			t.Errorf("Unexpected tags: %v", got.Tags)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		tests := map[string]map[string]interface{}{
+			"MissingTrustPolicy":  {"name": "bad"},
+			"InvalidJSON":         {"name": "bad", "assume_role_policy": "{"},
+			"NotAnObject":         {"name": "bad", "assume_role_policy": `["sts:AssumeRole"]`},
+			"ShortSession":        {"name": "bad", "assume_role_policy": trustPolicy, "max_session_duration": 60},
+			"PolicyOfWrongType":   {"name": "bad", "assume_role_policy": 42},
+			"RenameNeedsReplaced": nil,
+		}
+		for name, config := range tests {
+			t.Run(name, func(t *testing.T) {
+				var err error
+				if config == nil {
+					err = p.Update(ctx, role, map[string]interface{}{"name": "other", "assume_role_policy": trustPolicy})
+				} else {
+					_, err = p.Create(ctx, "iam_role", config)
+				}
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+			})
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, role); err != nil {
+			t.Fatalf("Failed to delete role: %v", err)
+		}
+		if _, err := p.Read(ctx, "iam_role", "app"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound after delete, got %v", err)
+		}
+		if err := p.Delete(ctx, role); err != nil {
+			t.Errorf("Expected deleting a deleted role to succeed, got %v", err)
+		}
+	})
+}
+
+func TestIAMPolicies(t *testing.T) {
+	p, cloud := newCloudTestProvider(t)
+	ctx := context.Background()
+
+	document := func(bucket string) map[string]interface{} {
+		return map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []interface{}{
+				map[string]interface{}{
+					"Effect":   "Allow",
+					"Action":   []interface{}{"s3:PutObject", "s3:GetObject"},
+					"Resource": "arn:aws:s3:::" + bucket + "/*",
+				},
+			},
+		}
+	}
+
+	var policy provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		policy, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-assets"), "iam_policy", map[string]interface{}{
+			"name":   "assets",
+			"policy": document("assets-1"),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create policy: %v", err)
+		}
+		if policy.GetID() != "arn:aws:iam::123456789012:policy/assets" {
+			t.Errorf("Unexpected policy ID %s", policy.GetID())
+		}
+		if doc := policy.GetMetadata()["policy"].(string); !strings.Contains(doc, `"Action":["s3:GetObject","s3:PutObject"]`) {
+			t.Errorf("Expected sorted actions, got %s", doc)
+		}
+
+		found, err := p.FindByToken(ctx, "iam_policy", "run-1-assets")
+		if err != nil || found.GetID() != policy.GetID() {
+			t.Errorf("Expected to find the policy by token, got %v, %v", found, err)
+		}
+	})
+
+	t.Run("UnchangedMakesNoCalls", func(t *testing.T) {
+		reordered := document("assets-1")
+		reordered["Statement"].([]interface{})[0].(map[string]interface{})["Action"] = []interface{}{"s3:GetObject", "s3:PutObject"}
+		if err := p.Update(ctx, policy, map[string]interface{}{"name": "assets", "policy": reordered}); err != nil {
+			t.Fatalf("Failed to update policy: %v", err)
+		}
+		if n := cloud.IAM.Calls("CreatePolicyVersion"); n != 0 {
+			t.Errorf("Expected no new versions, got %d", n)
+		}
+	})
+
+	t.Run("UpdatePrunesVersions", func(t *testing.T) {
+		for i := 2; i <= 7; i++ {
+			bucket := "assets-" + string(rune('0'+i))
+			if err := p.Update(ctx, policy, map[string]interface{}{"name": "assets", "policy": document(bucket)}); err != nil {
+				t.Fatalf("Failed to update policy to version %d: %v", i, err)
+			}
+		}
+
+		got, _ := cloud.IAM.Policy(policy.GetID())
+		if len(got.Versions) != 5 {
+			t.Errorf("Expected 5 versions, got %d", len(got.Versions))
+		}
+		current, err := p.Read(ctx, "iam_policy", policy.GetID())
+		if err != nil {
+			t.Fatalf("Failed to read policy: %v", err)
+		}
+		if doc := current.GetMetadata()["policy"].(string); !strings.Contains(doc, "assets-7") {
+			t.Errorf("Expected the latest document to be the default, got %s", doc)
+		}
+	})
+
+	t.Run("DescriptionNeedsReplacing", func(t *testing.T) {
+		err := p.Update(ctx, policy, map[string]interface{}{"name": "assets", "policy": document("assets-7"), "description": "new"})
+		if err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected a replacement error, got %v", err)
+		}
+	})
+
+	t.Run("Attachment", func(t *testing.T) {
+		if _, err := p.Create(ctx, "iam_role", map[string]interface{}{"name": "app", "assume_role_policy": trustPolicy}); err != nil {
+			t.Fatalf("Failed to create role: %v", err)
+		}
+
+		var attachments []provider.Resource
+		for _, arn := range []string{policy.GetID(), "arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"} {
+			attachment, err := p.Create(ctx, "iam_role_policy_attachment", map[string]interface{}{"role": "app", "policy_arn": arn})
+			if err != nil {
+				t.Fatalf("Failed to attach %s: %v", arn, err)
+			}
+			if attachment.GetID() != "app:"+arn {
+				t.Errorf("Unexpected attachment ID %s", attachment.GetID())
+			}
+			attachments = append(attachments, attachment)
+		}
+		if _, err := p.FindByToken(ctx, "iam_role_policy_attachment", "anything"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+
+		if err := p.Delete(ctx, policy); err == nil {
+			t.Error("Expected deleting an attached policy to fail")
+		}
+
+		for _, attachment := range attachments {
+			if err := p.Delete(ctx, attachment); err != nil {
+				t.Fatalf("Failed to detach policy: %v", err)
+			}
+			if _, err := p.Read(ctx, "iam_role_policy_attachment", attachment.GetID()); !errors.Is(err, provider.ErrNotFound) {
+				t.Errorf("Expected ErrNotFound after detaching, got %v", err)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, policy); err != nil {
+			t.Fatalf("Failed to delete policy: %v", err)
+		}
+		if _, ok := cloud.IAM.Policy(policy.GetID()); ok {
+			t.Error("Expected policy to be deleted")
+		}
+	})
+}
+
+func TestIAMRolePolicies(t *testing.T) {
+	p, cloud := newCloudTestProvider(t)
+	ctx := context.Background()
+
+	role, err := p.Create(ctx, "iam_role", map[string]interface{}{"name": "app", "assume_role_policy": trustPolicy})
+	if err != nil {
+		t.Fatalf("Failed to create role: %v", err)
+	}
+
+	config := map[string]interface{}{
+		"role":   "app",
+		"name":   "logs",
+		"policy": `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"logs:PutLogEvents","Resource":"*"}]}`,
+	}
+	inline, err := p.Create(ctx, "iam_role_policy", config)
+	if err != nil {
+		t.Fatalf("Failed to put role policy: %v", err)
+	}
+	if inline.GetID() != "app:logs" {
+		t.Errorf("Expected ID app:logs, got %s", inline.GetID())
+	}
+
+	t.Run("UnchangedMakesNoCalls", func(t *testing.T) {
+		before := cloud.IAM.Calls("PutRolePolicy")
+		if err := p.Update(ctx, inline, map[string]interface{}{
+			"role": "app",
+			"name": "logs",
+			"policy": map[string]interface{}{
+				"Version":   "2012-10-17",
+				"Statement": map[string]interface{}{"Resource": []interface{}{"*"}, "Action": "logs:PutLogEvents", "Effect": "Allow"},
+			},
+		}); err != nil {
+			t.Fatalf("Failed to update role policy: %v", err)
+		}
+		if n := cloud.IAM.Calls("PutRolePolicy") - before; n != 0 {
+			t.Errorf("Expected no PutRolePolicy calls, got %d", n)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		config["policy"] = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"logs:*","Resource":"*"}]}`
+		if err := p.Update(ctx, inline, config); err != nil {
+			t.Fatalf("Failed to update role policy: %v", err)
+		}
+		got, _ := cloud.IAM.Role("app")
+		if !strings.Contains(got.InlinePolicies["logs"], "logs:*") {
+			t.Errorf("Expected the new document, got %s", got.InlinePolicies["logs"])
+		}
+	})
+
+	t.Run("DeleteRoleWithPolicyConflicts", func(t *testing.T) {
+		if err := p.Delete(ctx, role); err == nil {
+			t.Error("Expected deleting a role with an inline policy to fail")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, inline); err != nil {
+			t.Fatalf("Failed to delete role policy: %v", err)
+		}
+		if _, err := p.Read(ctx, "iam_role_policy", "app:logs"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound after delete, got %v", err)
+		}
+		if err := p.Delete(ctx, role); err != nil {
+			t.Errorf("Failed to delete role: %v", err)
+		}
+	})
+}
+
+func TestIAMInstanceProfiles(t *testing.T) {
+	p, cloud := newCloudTestProvider(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"app", "worker"} {
+		if _, err := p.Create(ctx, "iam_role", map[string]interface{}{"name": name, "assume_role_policy": trustPolicy}); err != nil {
+			t.Fatalf("Failed to create role %s: %v", name, err)
+		}
+	}
+
+	var profile provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		profile, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-app"), "iam_instance_profile", map[string]interface{}{
+			"name": "app",
+			"role": "app",
+		})
+		if err != nil {
+			t.Fatalf("Failed to create instance profile: %v", err)
+		}
+		if role := profile.GetMetadata()["role"]; role != "app" {
+			t.Errorf("Expected role app, got %v", role)
+		}
+		found, err := p.FindByToken(ctx, "iam_instance_profile", "run-1-app")
+		if err != nil || found.GetID() != "app" {
+			t.Errorf("Expected to find the profile by token, got %v, %v", found, err)
+		}
+	})
+
+	var instance provider.Resource
+	t.Run("Instance", func(t *testing.T) {
+		config := map[string]interface{}{
+			"ami":                    "ami-12345678",
+			"instance_type":          "t3.micro",
+			"iam_instance_profile":   "app",
+			"wait_for_status_checks": false,
+		}
+		var err error
+		instance, err = p.Create(ctx, "instance", config)
+		if err != nil {
+			t.Fatalf("Failed to create instance: %v", err)
+		}
+		if name := instance.GetMetadata()["iam_instance_profile"]; name != "app" {
+			t.Errorf("Expected instance profile app, got %v", name)
+		}
+
+		for _, name := range []string{"", "app"} {
+			config["iam_instance_profile"] = name
+			if err := p.Update(ctx, instance, config); err != nil {
+				t.Fatalf("Failed to set instance profile to %q: %v", name, err)
+			}
+			got, _ := cloud.EC2.Instance(instance.GetID())
+			if want := map[string]string{"": "", "app": "arn:aws:iam::123456789012:instance-profile/app"}[name]; got.IamInstanceProfile != want {
+				t.Errorf("Expected instance profile %q, got %q", want, got.IamInstanceProfile)
+			}
+		}
+	})
+
+	t.Run("SwapRole", func(t *testing.T) {
+		if err := p.Update(ctx, profile, map[string]interface{}{"name": "app", "role": "worker"}); err != nil {
+			t.Fatalf("Failed to update instance profile: %v", err)
+		}
+		got, _ := cloud.IAM.InstanceProfile("app")
+		if len(got.Roles) != 1 || got.Roles[0] != "worker" {
+			t.Errorf("Expected role worker, got %v", got.Roles)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, instance); err != nil {
+			t.Fatalf("Failed to delete instance: %v", err)
+		}
+		if err := p.Delete(ctx, profile); err != nil {
+			t.Fatalf("Failed to delete instance profile: %v", err)
+		}
+		if _, ok := cloud.IAM.InstanceProfile("app"); ok {
+			t.Error("Expected instance profile to be deleted")
+		}
+		if err := p.Delete(ctx, profile); err != nil {
+			t.Errorf("Expected deleting a deleted profile to succeed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,271 @@
+package aws
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// Algorithms duet can generate key pairs with
+const (
+	keyAlgorithmED25519 = "ed25519"
+	keyAlgorithmRSA     = "rsa"
+)
+
+// defaultRSABits is the size of generated RSA keys
+const defaultRSABits = 4096
+
+// keyPairSpec is the desired configuration of a key pair: either a public
+// key to import, or the algorithm to generate a new key with
+type keyPairSpec struct {
+	tags      map[string]string
+	name      string
+	publicKey string
+	algorithm string
+	rsaBits   int
+}
+
+func parseKeyPairSpec(config map[string]interface{}) (*keyPairSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("key_name")
+
+	spec := &keyPairSpec{
+		name:      attrs.String("key_name"),
+		publicKey: strings.TrimSpace(attrs.String("public_key")),
+		algorithm: attrs.String("algorithm"),
+		rsaBits:   attrs.Int("rsa_bits"),
+		tags:      attrs.StringMap("tags"),
+	}
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+
+	if spec.publicKey != "" {
+		if spec.algorithm != "" || spec.rsaBits != 0 {
+			return nil, fmt.Errorf("set public_key to import a key, or algorithm to generate one, not both")
+		}
+		if _, err := normalizePublicKey(spec.publicKey); err != nil {
+			return nil, fmt.Errorf("public_key: %w", err)
+		}
+		return spec, nil
+	}
+
+	if spec.algorithm == "" {
+		spec.algorithm = keyAlgorithmED25519
+	}
+	switch spec.algorithm {
+	case keyAlgorithmED25519:
+		if spec.rsaBits != 0 {
+			return nil, fmt.Errorf("rsa_bits only applies to rsa keys")
+		}
+	case keyAlgorithmRSA:
+		if spec.rsaBits == 0 {
+			spec.rsaBits = defaultRSABits
+		}
+		if spec.rsaBits < 2048 || spec.rsaBits > 4096 {
+			return nil, fmt.Errorf("rsa_bits must be between 2048 and 4096, got %d", spec.rsaBits)
+		}
+	default:
+		return nil, fmt.Errorf("algorithm must be %s or %s, got %q", keyAlgorithmED25519, keyAlgorithmRSA, spec.algorithm)
+	}
+	return spec, nil
+}
+
+// generateKey creates a private key for spec, returning it in OpenSSH PEM
+// form with its public key in authorized_keys form
+func generateKey(spec *keyPairSpec) (privateKey, publicKey string, err error) {
+	var key interface{}
+	switch spec.algorithm {
+	case keyAlgorithmRSA:
+		key, err = rsa.GenerateKey(rand.Reader, spec.rsaBits)
+	default:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate %s key: %w", spec.algorithm, err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate %s key: %w", spec.algorithm, err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, spec.name)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode %s key: %w", spec.algorithm, err)
+	}
+	return string(pem.EncodeToMemory(block)), authorizedKey(signer.PublicKey()), nil
+}
+
+// normalizePublicKey returns an OpenSSH public key without its comment, so
+// keys can be compared however they were written
+func normalizePublicKey(key string) (string, error) {
+	public, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return "", fmt.Errorf("not an OpenSSH public key: %w", err)
+	}
+	return authorizedKey(public), nil
+}
+
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// CreateKeyPair imports the configured public key, or generates a key pair
+// and imports its public half. Keys are generated by duet rather than EC2
+// so the same call works whichever way the key is supplied; the private key
+// is returned as the private_key attribute, which is sensitive. Key pairs
+// are named by the config, so a retried create adopts the key pair tagged
+// with its idempotency token, though a generated key adopted this way has
+// no private key.
+func (c *EC2Client) CreateKeyPair(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseKeyPairSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, privateKey := spec.publicKey, ""
+	if publicKey == "" {
+		if privateKey, publicKey, err = generateKey(spec); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := c.client.ImportKeyPair(ctx, &ec2.ImportKeyPairInput{
+		KeyName:           aws.String(spec.name),
+		PublicKeyMaterial: []byte(publicKey),
+		TagSpecifications: tagSpecification(ctx, ec2types.ResourceTypeKeyPair, spec.tags),
+	})
+	switch {
+	case errorCode(err) == "InvalidKeyPair.Duplicate":
+		return adopt(ctx, "key pair", spec.name, c.readKeyPairByName)
+	case err != nil:
+		return nil, fmt.Errorf("failed to import key pair %s: %w", spec.name, err)
+	}
+
+	res, err := c.ReadKeyPair(ctx, aws.ToString(result.KeyPairId))
+	if err != nil {
+		return nil, err
+	}
+	if privateKey != "" {
+		res.Metadata["private_key"] = privateKey
+	}
+	return res, nil
+}
+
+// ReadKeyPair returns the current state of a key pair. EC2 does not keep
+// private keys, so the result never has one.
+func (c *EC2Client) ReadKeyPair(ctx context.Context, id string) (*types.BaseResource, error) {
+	return c.describeKeyPair(ctx, &ec2.DescribeKeyPairsInput{KeyPairIds: []string{id}}, id)
+}
+
+func (c *EC2Client) readKeyPairByName(ctx context.Context, name string) (*types.BaseResource, error) {
+	return c.describeKeyPair(ctx, &ec2.DescribeKeyPairsInput{KeyNames: []string{name}}, name)
+}
+
+// FindKeyPairByToken returns the key pair tagged with the given idempotency
+// token
+func (c *EC2Client) FindKeyPairByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeKeyPairs(ctx, &ec2.DescribeKeyPairsInput{
+		Filters:          tokenFilter(token),
+		IncludePublicKey: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find key pair by token: %w", err)
+	}
+	if len(result.KeyPairs) == 0 {
+		return nil, provider.ErrNotFound
+	}
+	return keyPairResource(result.KeyPairs[0]), nil
+}
+
+// UpdateKeyPair updates a key pair's tags. The key itself cannot change.
+func (c *EC2Client) UpdateKeyPair(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseKeyPairSpec(config)
+	if err != nil {
+		return err
+	}
+
+	id := resource.GetID()
+	current, err := c.ReadKeyPair(ctx, id)
+	if err != nil {
+		return err
+	}
+	if spec.name != current.Metadata["key_name"] {
+		return fmt.Errorf("changing key_name of key pair %s requires replacing it", id)
+	}
+	if spec.publicKey != "" {
+		want, _ := normalizePublicKey(spec.publicKey)
+		if want != current.Metadata["public_key"] {
+			return fmt.Errorf("changing public_key of key pair %s requires replacing it", id)
+		}
+	} else if spec.algorithm != current.Metadata["key_type"] {
+		return fmt.Errorf("changing algorithm of key pair %s requires replacing it", id)
+	}
+
+	return c.updateTags(ctx, id, current.Tags, spec.tags)
+}
+
+// DeleteKeyPair deletes a key pair. Instances launched with it keep the
+// public key they were given.
+func (c *EC2Client) DeleteKeyPair(ctx context.Context, resource provider.Resource) error {
+	_, err := c.client.DeleteKeyPair(ctx, &ec2.DeleteKeyPairInput{
+		KeyPairId: aws.String(resource.GetID()),
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete key pair %s: %w", resource.GetID(), err)
+	}
+	return nil
+}
+
+func (c *EC2Client) describeKeyPair(ctx context.Context, input *ec2.DescribeKeyPairsInput, ref string) (*types.BaseResource, error) {
+	input.IncludePublicKey = aws.Bool(true)
+	result, err := c.client.DescribeKeyPairs(ctx, input)
+	if isNotFound(err) {
+		return nil, fmt.Errorf("key pair %s: %w", ref, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key pair %s: %w", ref, err)
+	}
+	if len(result.KeyPairs) == 0 {
+		return nil, fmt.Errorf("key pair %s: %w", ref, provider.ErrNotFound)
+	}
+	return keyPairResource(result.KeyPairs[0]), nil
+}
+
+func keyPairResource(key ec2types.KeyPairInfo) *types.BaseResource {
+	// EC2 appends the key name to the public key; drop it so the key
+	// compares equal to the one in the config
+	publicKey := aws.ToString(key.PublicKey)
+	if normalized, err := normalizePublicKey(publicKey); err == nil {
+		publicKey = normalized
+	}
+	return &types.BaseResource{
+		ID:       aws.ToString(key.KeyPairId),
+		Type:     types.ResourceTypeKeyPair,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"key_pair_id": aws.ToString(key.KeyPairId),
+			"key_name":    aws.ToString(key.KeyName),
+			"key_type":    string(key.KeyType),
+			"fingerprint": aws.ToString(key.KeyFingerprint),
+			"public_key":  publicKey,
+		},
+		Tags:      fromEC2Tags(key.Tags),
+		CreatedAt: aws.ToTime(key.CreateTime),
+		UpdatedAt: time.Now(),
+	}
+}
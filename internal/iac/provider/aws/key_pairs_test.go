@@ -0,0 +1,173 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+func TestKeyPairs(t *testing.T) {
+	p, ec2Server := newTestProvider(t)
+	ctx := context.Background()
+
+	config := map[string]interface{}{
+		"key_name": "deployer",
+		"tags":     map[string]interface{}{"team": "ops"},
+	}
+
+	var generated provider.Resource
+	t.Run("Generate", func(t *testing.T) {
+		var err error
+		generated, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-key"), "key_pair", config)
+		if err != nil {
+			t.Fatalf("Failed to create key pair: %v", err)
+		}
+
+		meta := generated.GetMetadata()
+		if meta["key_name"] != "deployer" || meta["key_type"] != "ed25519" || meta["fingerprint"] == "" {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+		privateKey, _ := meta["private_key"].(string)
+		signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+		if err != nil {
+			t.Fatalf("Failed to parse generated private key: %v", err)
+		}
+		if authorizedKey(signer.PublicKey()) != meta["public_key"] {
+			t.Errorf("Expected private key to match public key %v", meta["public_key"])
+		}
+
+		got, ok := ec2Server.KeyPair(generated.GetID())
+		if !ok {
+			t.Fatalf("Key pair %s was not imported", generated.GetID())
+		}
+		if !strings.HasPrefix(got.PublicKey, meta["public_key"].(string)) || got.Tags["team"] != "ops" || got.Tags[tokenTag] != "run-1-key" {
+			t.Errorf("Unexpected key pair: %+v", got)
+		}
+
+		if p.SensitiveAttributes("key_pair")[0] != "private_key" {
+			t.Errorf("Expected private_key to be sensitive, got %v", p.SensitiveAttributes("key_pair"))
+		}
+	})
+
+	t.Run("ReadHasNoPrivateKey", func(t *testing.T) {
+		res, err := p.Read(ctx, "key_pair", generated.GetID())
+		if err != nil {
+			t.Fatalf("Failed to read key pair: %v", err)
+		}
+		if _, ok := res.GetMetadata()["private_key"]; ok {
+			t.Error("Expected no private key from a read")
+		}
+		if res.GetMetadata()["public_key"] != generated.GetMetadata()["public_key"] {
+			t.Errorf("Expected public key %v, got %v", generated.GetMetadata()["public_key"], res.GetMetadata()["public_key"])
+		}
+	})
+
+	t.Run("Adopt", func(t *testing.T) {
+		again, err := p.Create(provider.WithIdempotencyToken(ctx, "run-1-key"), "key_pair", config)
+		if err != nil || again.GetID() != generated.GetID() {
+			t.Errorf("Expected retried create to return %s, got %v, %v", generated.GetID(), again, err)
+		}
+		if _, err := p.Create(provider.WithIdempotencyToken(ctx, "run-2-key"), "key_pair", config); err == nil || !strings.Contains(err.Error(), "not managed by this run") {
+			t.Errorf("Expected an error for a name taken by another run, got %v", err)
+		}
+
+		found, err := p.FindByToken(ctx, "key_pair", "run-1-key")
+		if err != nil || found.GetID() != generated.GetID() {
+			t.Errorf("Expected to find %s by token, got %v, %v", generated.GetID(), found, err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		retagged := map[string]interface{}{
+			"key_name": "deployer",
+			"tags":     map[string]interface{}{"team": "platform"},
+		}
+		if err := p.Update(ctx, generated, retagged); err != nil {
+			t.Fatalf("Failed to update key pair: %v", err)
+		}
+		if got, _ := ec2Server.KeyPair(generated.GetID()); got.Tags["team"] != "platform" {
+			t.Errorf("Expected team tag to be updated, got %v", got.Tags)
+		}
+
+		for key, value := range map[string]interface{}{
+			"key_name":  "other",
+			"algorithm": "rsa",
+		} {
+			changed := map[string]interface{}{"key_name": "deployer", key: value}
+			if err := p.Update(ctx, generated, changed); err == nil || !strings.Contains(err.Error(), "requires replacing") {
+				t.Errorf("Expected changing %s to require replacement, got %v", key, err)
+			}
+		}
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		publicKey := generated.GetMetadata()["public_key"].(string) + " someone@laptop"
+		imported, err := p.Create(ctx, "key_pair", map[string]interface{}{
+			"key_name":   "laptop",
+			"public_key": publicKey,
+		})
+		if err != nil {
+			t.Fatalf("Failed to import key pair: %v", err)
+		}
+		meta := imported.GetMetadata()
+		if _, ok := meta["private_key"]; ok {
+			t.Error("Expected no private key for an imported key pair")
+		}
+		if meta["public_key"] != generated.GetMetadata()["public_key"] || meta["fingerprint"] != generated.GetMetadata()["fingerprint"] {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+
+		// The comment does not count as a change
+		if err := p.Update(ctx, imported, map[string]interface{}{"key_name": "laptop", "public_key": meta["public_key"]}); err != nil {
+			t.Errorf("Failed to reapply config: %v", err)
+		}
+	})
+
+	t.Run("RSA", func(t *testing.T) {
+		res, err := p.Create(ctx, "key_pair", map[string]interface{}{
+			"key_name":  "legacy",
+			"algorithm": "rsa",
+			"rsa_bits":  2048,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create key pair: %v", err)
+		}
+		if res.GetMetadata()["key_type"] != "rsa" || !strings.HasPrefix(res.GetMetadata()["public_key"].(string), "ssh-rsa ") {
+			t.Errorf("Unexpected metadata: %v", res.GetMetadata())
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, config := range map[string]map[string]interface{}{
+			"missing name":   {"algorithm": "ed25519"},
+			"bad algorithm":  {"key_name": "x", "algorithm": "dsa"},
+			"bad public key": {"key_name": "x", "public_key": "not a key"},
+			"both":           {"key_name": "x", "public_key": "ssh-ed25519 AAAA", "algorithm": "rsa"},
+			"small rsa":      {"key_name": "x", "algorithm": "rsa", "rsa_bits": 1024},
+		} {
+			if _, err := p.Create(ctx, "key_pair", config); err == nil {
+				t.Errorf("Expected an error for %s, got nil", name)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, generated); err != nil {
+			t.Fatalf("Failed to delete key pair: %v", err)
+		}
+		if _, ok := ec2Server.KeyPair(generated.GetID()); ok {
+			t.Error("Expected key pair to be deleted")
+		}
+		if _, err := p.Read(ctx, "key_pair", generated.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		if err := p.Delete(ctx, generated); err != nil {
+			t.Errorf("Expected deleting a missing key pair to succeed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,363 @@
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// launchTemplateData is the instance configuration held by one version of
+// a launch template
+type launchTemplateData struct {
+	instanceTags   map[string]string
+	ami            string
+	instanceType   string
+	keyName        string
+	userData       string
+	profile        string
+	securityGroups []string
+	blockDevices   []blockDevice
+}
+
+// launchTemplateSpec is the desired configuration of a launch template
+type launchTemplateSpec struct {
+	tags        map[string]string
+	name        string
+	description string
+	data        launchTemplateData
+}
+
+func parseLaunchTemplateSpec(config map[string]interface{}) (*launchTemplateSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("name", "ami", "instance_type")
+
+	spec := &launchTemplateSpec{
+		name:        attrs.String("name"),
+		description: attrs.String("description"),
+		tags:        attrs.StringMap("tags"),
+		data: launchTemplateData{
+			ami:            attrs.String("ami"),
+			instanceType:   attrs.String("instance_type"),
+			keyName:        attrs.String("key_name"),
+			userData:       attrs.String("user_data"),
+			profile:        attrs.String("iam_instance_profile"),
+			securityGroups: attrs.StringList("security_groups"),
+			instanceTags:   attrs.StringMap("instance_tags"),
+			blockDevices:   parseBlockDevices(attrs),
+		},
+	}
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// same reports whether two versions would launch the same instances
+func (d launchTemplateData) same(other launchTemplateData) bool {
+	return d.ami == other.ami &&
+		d.instanceType == other.instanceType &&
+		d.keyName == other.keyName &&
+		d.userData == other.userData &&
+		d.profile == other.profile &&
+		sameStrings(d.securityGroups, other.securityGroups) &&
+		maps.Equal(d.instanceTags, other.instanceTags) &&
+		slices.Equal(d.blockDevices, other.blockDevices)
+}
+
+func (d launchTemplateData) request() *ec2types.RequestLaunchTemplateData {
+	data := &ec2types.RequestLaunchTemplateData{
+		ImageId:      aws.String(d.ami),
+		InstanceType: ec2types.InstanceType(d.instanceType),
+	}
+	if d.keyName != "" {
+		data.KeyName = aws.String(d.keyName)
+	}
+	if d.userData != "" {
+		data.UserData = aws.String(base64.StdEncoding.EncodeToString([]byte(d.userData)))
+	}
+	if len(d.securityGroups) > 0 {
+		data.SecurityGroupIds = d.securityGroups
+	}
+	switch {
+	case strings.HasPrefix(d.profile, "arn:"):
+		data.IamInstanceProfile = &ec2types.LaunchTemplateIamInstanceProfileSpecificationRequest{Arn: aws.String(d.profile)}
+	case d.profile != "":
+		data.IamInstanceProfile = &ec2types.LaunchTemplateIamInstanceProfileSpecificationRequest{Name: aws.String(d.profile)}
+	}
+	for _, b := range d.blockDevices {
+		ebs := &ec2types.LaunchTemplateEbsBlockDeviceRequest{
+			DeleteOnTermination: aws.Bool(b.deleteOnTermination),
+			VolumeSize:          optionalInt32(b.volumeSize),
+			Iops:                optionalInt32(b.iops),
+			Throughput:          optionalInt32(b.throughput),
+		}
+		if b.volumeType != "" {
+			ebs.VolumeType = ec2types.VolumeType(b.volumeType)
+		}
+		if b.encrypted {
+			ebs.Encrypted = aws.Bool(true)
+		}
+		if b.kmsKeyID != "" {
+			ebs.KmsKeyId = aws.String(b.kmsKeyID)
+		}
+		data.BlockDeviceMappings = append(data.BlockDeviceMappings, ec2types.LaunchTemplateBlockDeviceMappingRequest{
+			DeviceName: aws.String(b.deviceName),
+			Ebs:        ebs,
+		})
+	}
+	if len(d.instanceTags) > 0 {
+		tags := toEC2Tags(d.instanceTags)
+		data.TagSpecifications = []ec2types.LaunchTemplateTagSpecificationRequest{
+			{ResourceType: ec2types.ResourceTypeInstance, Tags: tags},
+			{ResourceType: ec2types.ResourceTypeVolume, Tags: tags},
+		}
+	}
+	return data
+}
+
+// templateDataFromEC2 converts the data of a launch template version back
+// into the form the config gives it in
+func templateDataFromEC2(data *ec2types.ResponseLaunchTemplateData) launchTemplateData {
+	if data == nil {
+		return launchTemplateData{}
+	}
+	d := launchTemplateData{
+		ami:            aws.ToString(data.ImageId),
+		instanceType:   string(data.InstanceType),
+		keyName:        aws.ToString(data.KeyName),
+		securityGroups: data.SecurityGroupIds,
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(aws.ToString(data.UserData)); err == nil {
+		d.userData = string(decoded)
+	}
+	if p := data.IamInstanceProfile; p != nil {
+		d.profile = aws.ToString(p.Name)
+		if d.profile == "" {
+			d.profile = aws.ToString(p.Arn)
+		}
+	}
+	for _, m := range data.BlockDeviceMappings {
+		b := blockDevice{deviceName: aws.ToString(m.DeviceName)}
+		if ebs := m.Ebs; ebs != nil {
+			b.volumeType = string(ebs.VolumeType)
+			b.kmsKeyID = aws.ToString(ebs.KmsKeyId)
+			b.volumeSize = int(aws.ToInt32(ebs.VolumeSize))
+			b.iops = int(aws.ToInt32(ebs.Iops))
+			b.throughput = int(aws.ToInt32(ebs.Throughput))
+			b.deleteOnTermination = aws.ToBool(ebs.DeleteOnTermination)
+			b.encrypted = aws.ToBool(ebs.Encrypted)
+		}
+		d.blockDevices = append(d.blockDevices, b)
+	}
+	for _, ts := range data.TagSpecifications {
+		if ts.ResourceType == ec2types.ResourceTypeInstance {
+			d.instanceTags = fromEC2Tags(ts.Tags)
+		}
+	}
+	return d
+}
+
+// CreateLaunchTemplate creates a launch template with its first version.
+// Launch templates are named by the config; the idempotency token is used
+// as the client token and tag so a retried create returns the original.
+func (c *EC2Client) CreateLaunchTemplate(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseLaunchTemplateSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &ec2.CreateLaunchTemplateInput{
+		LaunchTemplateName: aws.String(spec.name),
+		LaunchTemplateData: spec.data.request(),
+		TagSpecifications:  tagSpecification(ctx, ec2types.ResourceTypeLaunchTemplate, spec.tags),
+	}
+	if spec.description != "" {
+		input.VersionDescription = aws.String(spec.description)
+	}
+	if token := provider.IdempotencyToken(ctx); token != "" {
+		input.ClientToken = aws.String(token)
+	}
+
+	result, err := c.client.CreateLaunchTemplate(ctx, input)
+	switch {
+	case errorCode(err) == "InvalidLaunchTemplateName.AlreadyExistsException":
+		return adopt(ctx, "launch template", spec.name, c.readLaunchTemplateByName)
+	case err != nil:
+		return nil, fmt.Errorf("failed to create launch template %s: %w", spec.name, err)
+	case result.LaunchTemplate == nil:
+		return nil, fmt.Errorf("failed to create launch template %s: no launch template returned", spec.name)
+	}
+	return c.ReadLaunchTemplate(ctx, aws.ToString(result.LaunchTemplate.LaunchTemplateId))
+}
+
+// ReadLaunchTemplate returns the current state of a launch template, with
+// the data of its latest version
+func (c *EC2Client) ReadLaunchTemplate(ctx context.Context, id string) (*types.BaseResource, error) {
+	template, err := c.describeLaunchTemplate(ctx, &ec2.DescribeLaunchTemplatesInput{LaunchTemplateIds: []string{id}}, id)
+	if err != nil {
+		return nil, err
+	}
+	version, err := c.describeLaunchTemplateVersion(ctx, id, "$Latest")
+	if err != nil {
+		return nil, err
+	}
+	return launchTemplateResource(*template, *version), nil
+}
+
+func (c *EC2Client) readLaunchTemplateByName(ctx context.Context, name string) (*types.BaseResource, error) {
+	template, err := c.describeLaunchTemplate(ctx, &ec2.DescribeLaunchTemplatesInput{LaunchTemplateNames: []string{name}}, name)
+	if err != nil {
+		return nil, err
+	}
+	return c.ReadLaunchTemplate(ctx, aws.ToString(template.LaunchTemplateId))
+}
+
+// FindLaunchTemplateByToken returns the launch template tagged with the
+// given idempotency token
+func (c *EC2Client) FindLaunchTemplateByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeLaunchTemplates(ctx, &ec2.DescribeLaunchTemplatesInput{
+		Filters: tokenFilter(token),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find launch template by token: %w", err)
+	}
+	if len(result.LaunchTemplates) == 0 {
+		return nil, provider.ErrNotFound
+	}
+	return c.ReadLaunchTemplate(ctx, aws.ToString(result.LaunchTemplates[0].LaunchTemplateId))
+}
+
+// UpdateLaunchTemplate applies changes to a launch template. Versions are
+// immutable, so changed instance settings become a new version, which is
+// made the default. Tags are updated in place; the name is fixed.
+func (c *EC2Client) UpdateLaunchTemplate(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseLaunchTemplateSpec(config)
+	if err != nil {
+		return err
+	}
+
+	id := resource.GetID()
+	template, err := c.describeLaunchTemplate(ctx, &ec2.DescribeLaunchTemplatesInput{LaunchTemplateIds: []string{id}}, id)
+	if err != nil {
+		return err
+	}
+	if spec.name != aws.ToString(template.LaunchTemplateName) {
+		return fmt.Errorf("changing name of launch template %s requires replacing it", id)
+	}
+
+	latest, err := c.describeLaunchTemplateVersion(ctx, id, "$Latest")
+	if err != nil {
+		return err
+	}
+	if !spec.data.same(templateDataFromEC2(latest.LaunchTemplateData)) {
+		input := &ec2.CreateLaunchTemplateVersionInput{
+			LaunchTemplateId:   aws.String(id),
+			LaunchTemplateData: spec.data.request(),
+		}
+		if spec.description != "" {
+			input.VersionDescription = aws.String(spec.description)
+		}
+		result, err := c.client.CreateLaunchTemplateVersion(ctx, input)
+		if err != nil {
+			return fmt.Errorf("failed to create version of launch template %s: %w", id, err)
+		}
+		if result.LaunchTemplateVersion == nil {
+			return fmt.Errorf("failed to create version of launch template %s: no version returned", id)
+		}
+		latest = result.LaunchTemplateVersion
+	}
+
+	if version := aws.ToInt64(latest.VersionNumber); version != aws.ToInt64(template.DefaultVersionNumber) {
+		_, err := c.client.ModifyLaunchTemplate(ctx, &ec2.ModifyLaunchTemplateInput{
+			LaunchTemplateId: aws.String(id),
+			DefaultVersion:   aws.String(strconv.FormatInt(version, 10)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set default version of launch template %s: %w", id, err)
+		}
+	}
+
+	return c.updateTags(ctx, id, fromEC2Tags(template.Tags), spec.tags)
+}
+
+// DeleteLaunchTemplate deletes a launch template and all its versions.
+// Templates that are already gone are not an error.
+func (c *EC2Client) DeleteLaunchTemplate(ctx context.Context, resource provider.Resource) error {
+	_, err := c.client.DeleteLaunchTemplate(ctx, &ec2.DeleteLaunchTemplateInput{
+		LaunchTemplateId: aws.String(resource.GetID()),
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete launch template %s: %w", resource.GetID(), err)
+	}
+	return nil
+}
+
+// describeLaunchTemplate returns the one launch template input selects.
+// Unknown IDs and names are reported with different error codes, both
+// meaning it does not exist.
+func (c *EC2Client) describeLaunchTemplate(ctx context.Context, input *ec2.DescribeLaunchTemplatesInput, ref string) (*ec2types.LaunchTemplate, error) {
+	result, err := c.client.DescribeLaunchTemplates(ctx, input)
+	if strings.Contains(errorCode(err), "NotFound") {
+		return nil, fmt.Errorf("launch template %s: %w", ref, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read launch template %s: %w", ref, err)
+	}
+	if len(result.LaunchTemplates) == 0 {
+		return nil, fmt.Errorf("launch template %s: %w", ref, provider.ErrNotFound)
+	}
+	return &result.LaunchTemplates[0], nil
+}
+
+func (c *EC2Client) describeLaunchTemplateVersion(ctx context.Context, id, version string) (*ec2types.LaunchTemplateVersion, error) {
+	result, err := c.client.DescribeLaunchTemplateVersions(ctx, &ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateId: aws.String(id),
+		Versions:         []string{version},
+	})
+	if strings.Contains(errorCode(err), "NotFound") {
+		return nil, fmt.Errorf("launch template %s version %s: %w", id, version, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read version %s of launch template %s: %w", version, id, err)
+	}
+	if len(result.LaunchTemplateVersions) == 0 {
+		return nil, fmt.Errorf("launch template %s version %s: %w", id, version, provider.ErrNotFound)
+	}
+	return &result.LaunchTemplateVersions[0], nil
+}
+
+func launchTemplateResource(template ec2types.LaunchTemplate, latest ec2types.LaunchTemplateVersion) *types.BaseResource {
+	data := templateDataFromEC2(latest.LaunchTemplateData)
+	return &types.BaseResource{
+		ID:       aws.ToString(template.LaunchTemplateId),
+		Type:     types.ResourceTypeLaunchTemplate,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"name":                 aws.ToString(template.LaunchTemplateName),
+			"latest_version":       aws.ToInt64(template.LatestVersionNumber),
+			"default_version":      aws.ToInt64(template.DefaultVersionNumber),
+			"ami":                  data.ami,
+			"instance_type":        data.instanceType,
+			"key_name":             data.keyName,
+			"iam_instance_profile": data.profile,
+			"security_groups":      interfaceList(data.securityGroups),
+			"instance_tags":        tagTable(data.instanceTags),
+		},
+		Tags:      fromEC2Tags(template.Tags),
+		CreatedAt: aws.ToTime(template.CreateTime),
+		UpdatedAt: time.Now(),
+	}
+}
@@ -0,0 +1,131 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+func TestLaunchTemplates(t *testing.T) {
+	p, cloud := newCloudTestProvider(t)
+	ctx := context.Background()
+
+	config := map[string]interface{}{
+		"name":                 "web",
+		"ami":                  "ami-12345678",
+		"instance_type":        "t3.micro",
+		"user_data":            "#!/bin/sh\necho hello\n",
+		"iam_instance_profile": "web",
+		"security_groups":      []interface{}{"sg-1"},
+		"block_devices": []interface{}{
+			map[string]interface{}{"device_name": "/dev/xvda", "volume_size": 20, "encrypted": true},
+		},
+		"instance_tags": map[string]interface{}{"role": "web"},
+		"tags":          map[string]interface{}{"team": "web"},
+	}
+
+	var lt provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		lt, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-lt"), "launch_template", config)
+		if err != nil {
+			t.Fatalf("Failed to create launch template: %v", err)
+		}
+
+		meta := lt.GetMetadata()
+		if meta["latest_version"] != int64(1) || meta["default_version"] != int64(1) || meta["ami"] != "ami-12345678" {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+		if lt.GetTags()["team"] != "web" || lt.GetTags()[tokenTag] != "run-1-lt" {
+			t.Errorf("Unexpected tags: %v", lt.GetTags())
+		}
+
+		got, _ := cloud.EC2.LaunchTemplate(lt.GetID())
+		v := got.Versions[0]
+		if v.UserData != "#!/bin/sh\necho hello\n" || v.IamInstanceProfile != "web" || v.Tags["role"] != "web" {
+			t.Errorf("Unexpected version data: %+v", v)
+		}
+		if len(v.BlockDevices) != 1 || v.BlockDevices[0].VolumeSize != 20 || !v.BlockDevices[0].DeleteOnTermination {
+			t.Errorf("Unexpected block devices: %+v", v.BlockDevices)
+		}
+
+		again, err := p.Create(provider.WithIdempotencyToken(ctx, "run-1-lt"), "launch_template", config)
+		if err != nil || again.GetID() != lt.GetID() {
+			t.Errorf("Expected retried create to return %s, got %v, %v", lt.GetID(), again, err)
+		}
+		if _, err := p.Create(provider.WithIdempotencyToken(ctx, "run-2-lt"), "launch_template", config); err == nil || !strings.Contains(err.Error(), "not managed by this run") {
+			t.Errorf("Expected an error for a name taken by another run, got %v", err)
+		}
+
+		found, err := p.FindByToken(ctx, "launch_template", "run-1-lt")
+		if err != nil || found.GetID() != lt.GetID() {
+			t.Errorf("Expected to find %s by token, got %v, %v", lt.GetID(), found, err)
+		}
+	})
+
+	t.Run("UnchangedKeepsVersion", func(t *testing.T) {
+		if err := p.Update(ctx, lt, config); err != nil {
+			t.Fatalf("Failed to reapply config: %v", err)
+		}
+		if n := cloud.EC2.Calls("CreateLaunchTemplateVersion"); n != 0 {
+			t.Errorf("Expected no new version for an unchanged config, got %d calls", n)
+		}
+	})
+
+	t.Run("ChangeCreatesVersion", func(t *testing.T) {
+		changed := map[string]interface{}{}
+		for k, v := range config {
+			changed[k] = v
+		}
+		changed["instance_type"] = "t3.small"
+		changed["tags"] = map[string]interface{}{"team": "platform"}
+		if err := p.Update(ctx, lt, changed); err != nil {
+			t.Fatalf("Failed to update launch template: %v", err)
+		}
+
+		got, _ := cloud.EC2.LaunchTemplate(lt.GetID())
+		if got.LatestVersion() != 2 || got.DefaultVersion != 2 {
+			t.Errorf("Expected version 2 to be the latest and default, got %d and %d", got.LatestVersion(), got.DefaultVersion)
+		}
+		if got.Versions[0].InstanceType != "t3.micro" || got.Versions[1].InstanceType != "t3.small" {
+			t.Errorf("Expected the first version to be kept, got %+v", got.Versions)
+		}
+		if got.Tags["team"] != "platform" || got.Tags[tokenTag] != "run-1-lt" {
+			t.Errorf("Expected the team tag to change and the token to stay, got %v", got.Tags)
+		}
+
+		read, err := p.Read(ctx, "launch_template", lt.GetID())
+		if err != nil {
+			t.Fatalf("Failed to read launch template: %v", err)
+		}
+		if meta := read.GetMetadata(); meta["latest_version"] != int64(2) || meta["instance_type"] != "t3.small" {
+			t.Errorf("Expected metadata of version 2, got %v", meta)
+		}
+		if groups := read.GetMetadata()["security_groups"]; !reflect.DeepEqual(groups, []interface{}{"sg-1"}) {
+			t.Errorf("Expected security groups to carry over, got %v", groups)
+		}
+	})
+
+	t.Run("ReplaceRequired", func(t *testing.T) {
+		renamed := map[string]interface{}{"name": "api", "ami": "ami-12345678", "instance_type": "t3.small"}
+		if err := p.Update(ctx, lt, renamed); err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected renaming to require replacement, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, lt); err != nil {
+			t.Fatalf("Failed to delete launch template: %v", err)
+		}
+		if _, err := p.Read(ctx, "launch_template", lt.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		if err := p.Delete(ctx, lt); err != nil {
+			t.Errorf("Expected deleting again to succeed, got %v", err)
+		}
+	})
+}
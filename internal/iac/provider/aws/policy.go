@@ -0,0 +1,193 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// policyAttributes lists the attributes of each resource type that hold
+// IAM policy documents
+var policyAttributes = map[string][]string{
+	"storage":         {"policy"},
+	"iam_role":        {"assume_role_policy"},
+	"iam_policy":      {"policy"},
+	"iam_role_policy": {"policy"},
+}
+
+// policyListKeys are statement elements that may be a single value or a list
+var policyListKeys = map[string]bool{
+	"Action":      true,
+	"NotAction":   true,
+	"Resource":    true,
+	"NotResource": true,
+}
+
+// NormalizeConfig rewrites policy documents in config to their normalised
+// JSON, so documents that differ only in formatting, key order or
+// single-value lists compare equal
+func (p *AWSProvider) NormalizeConfig(resourceType string, config map[string]interface{}) map[string]interface{} {
+	keys := policyAttributes[resourceType]
+	if len(keys) == 0 {
+		return config
+	}
+	out := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		out[k] = v
+	}
+	for _, key := range keys {
+		attrs := newAttributes(config)
+		if doc := attrs.Policy(key); attrs.Err() == nil && doc != "" {
+			out[key] = doc
+		}
+	}
+	return out
+}
+
+// Policy returns the policy document at key as normalised JSON, or "" if
+// unset. Documents may be written as JSON strings or as tables.
+func (a *attributes) Policy(key string) string {
+	v, ok := a.values[key]
+	if !ok || v == nil {
+		return ""
+	}
+
+	var doc string
+	switch p := v.(type) {
+	case string:
+		if p == "" {
+			return ""
+		}
+		doc = p
+	case map[string]interface{}:
+		data, err := json.Marshal(p)
+		if err != nil {
+			a.fail(key, "a policy document", v)
+			return ""
+		}
+		doc = string(data)
+	default:
+		a.fail(key, "a policy document", v)
+		return ""
+	}
+
+	normalized, err := normalizePolicy(doc)
+	if err != nil && a.err == nil {
+		a.err = fmt.Errorf("attribute %s: %w", key, err)
+	}
+	return normalized
+}
+
+// normalizePolicy re-encodes a JSON policy document so equivalent documents
+// are byte for byte equal: keys are sorted, whitespace is dropped, a single
+// statement becomes a list, and actions, resources, principals and
+// condition values become sorted lists
+func normalizePolicy(policy string) (string, error) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		return "", fmt.Errorf("invalid JSON policy: %w", err)
+	}
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("policy must be a JSON object")
+	}
+
+	switch statements := m["Statement"].(type) {
+	case map[string]interface{}:
+		if len(statements) == 0 {
+			m["Statement"] = []interface{}{}
+		} else {
+			m["Statement"] = []interface{}{normalizeStatement(statements)}
+		}
+	case []interface{}:
+		for i, s := range statements {
+			if stmt, ok := s.(map[string]interface{}); ok {
+				statements[i] = normalizeStatement(stmt)
+			}
+		}
+	}
+
+	out, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func normalizeStatement(stmt map[string]interface{}) map[string]interface{} {
+	for key, v := range stmt {
+		switch {
+		case policyListKeys[key]:
+			stmt[key] = policyList(v)
+		case key == "Principal" || key == "NotPrincipal":
+			// "*" means anyone and has no list form
+			if principals, ok := v.(map[string]interface{}); ok {
+				for k, p := range principals {
+					principals[k] = policyList(p)
+				}
+			}
+		case key == "Condition":
+			if operators, ok := v.(map[string]interface{}); ok {
+				for _, c := range operators {
+					if conditions, ok := c.(map[string]interface{}); ok {
+						for k, value := range conditions {
+							conditions[k] = policyList(value)
+						}
+					}
+				}
+			}
+		}
+	}
+	return stmt
+}
+
+// policyList turns a single value or list into a sorted list of strings.
+// IAM treats these as sets, and stores true and "true" alike.
+func policyList(v interface{}) interface{} {
+	var items []interface{}
+	switch list := v.(type) {
+	case []interface{}:
+		items = list
+	case map[string]interface{}:
+		// An empty Lua table
+		if len(list) != 0 {
+			return v
+		}
+	default:
+		items = []interface{}{v}
+	}
+
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		switch s := item.(type) {
+		case string:
+			out = append(out, s)
+		case map[string]interface{}, []interface{}:
+			return v
+		default:
+			out = append(out, fmt.Sprint(s))
+		}
+	}
+	sort.Strings(out)
+	result := make([]interface{}, len(out))
+	for i, s := range out {
+		result[i] = s
+	}
+	return result
+}
+
+// decodePolicy reverses the URL encoding IAM applies to the policy
+// documents it returns, then normalises the result
+func decodePolicy(encoded string) (string, error) {
+	doc := encoded
+	if !strings.HasPrefix(strings.TrimSpace(encoded), "{") {
+		decoded, err := url.PathUnescape(encoded)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode policy: %w", err)
+		}
+		doc = decoded
+	}
+	return normalizePolicy(doc)
+}
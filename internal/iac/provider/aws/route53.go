@@ -0,0 +1,871 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// defaultRecordTTL is the TTL of records whose config sets none
+const defaultRecordTTL = 300
+
+// maxTXTStringLength is the longest character string a TXT value may hold;
+// longer values are split into several strings
+const maxTXTStringLength = 255
+
+type Route53Client struct {
+	client       *route53.Client
+	pollInterval time.Duration
+}
+
+func NewRoute53Client(cfg aws.Config) (*Route53Client, error) {
+	return &Route53Client{
+		client:       route53.NewFromConfig(cfg),
+		pollInterval: defaultPollInterval,
+	}, nil
+}
+
+// hostedZoneSpec is the desired configuration of a hosted zone. Zones with
+// a VPC are private to it.
+type hostedZoneSpec struct {
+	tags         map[string]string
+	name         string
+	comment      string
+	vpcID        string
+	vpcRegion    string
+	forceDestroy bool
+	timeouts     timeouts
+}
+
+func parseHostedZoneSpec(config map[string]interface{}) (*hostedZoneSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("name")
+
+	spec := &hostedZoneSpec{
+		name:         recordName(attrs.String("name")),
+		comment:      attrs.String("comment"),
+		vpcID:        attrs.String("vpc_id"),
+		vpcRegion:    attrs.String("vpc_region"),
+		forceDestroy: attrs.Bool("force_destroy"),
+		tags:         attrs.StringMap("tags"),
+		timeouts:     parseTimeouts(attrs),
+	}
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	if spec.vpcRegion != "" && spec.vpcID == "" {
+		return nil, fmt.Errorf("vpc_region requires vpc_id")
+	}
+	return spec, nil
+}
+
+// CreateHostedZone creates a public hosted zone or, given a VPC, a private
+// one, and waits until Route 53 has propagated it. The idempotency token is
+// the zone's caller reference, so a retried create finds the zone it made.
+func (c *Route53Client) CreateHostedZone(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseHostedZoneSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	reference := provider.IdempotencyToken(ctx)
+	if reference == "" {
+		reference = fmt.Sprintf("duet-%d", time.Now().UnixNano())
+	}
+	input := &route53.CreateHostedZoneInput{
+		Name:            aws.String(spec.name),
+		CallerReference: aws.String(reference),
+	}
+	if spec.comment != "" {
+		input.HostedZoneConfig = &r53types.HostedZoneConfig{Comment: aws.String(spec.comment)}
+	}
+	if spec.vpcID != "" {
+		input.VPC = &r53types.VPC{VPCId: aws.String(spec.vpcID)}
+		if spec.vpcRegion != "" {
+			input.VPC.VPCRegion = r53types.VPCRegion(spec.vpcRegion)
+		}
+	}
+
+	var id string
+	result, err := c.client.CreateHostedZone(ctx, input)
+	switch {
+	case errorCode(err) == "HostedZoneAlreadyExists":
+		existing, err := c.findZoneByReference(ctx, reference)
+		if err != nil {
+			return nil, err
+		}
+		id = existing
+	case err != nil:
+		return nil, fmt.Errorf("failed to create hosted zone %s: %w", spec.name, err)
+	default:
+		id = zoneID(aws.ToString(result.HostedZone.Id))
+		if err := c.waitForChange(ctx, result.ChangeInfo, spec.timeouts.create); err != nil {
+			return &types.BaseResource{
+				ID:       id,
+				Type:     types.ResourceTypeRoute53Zone,
+				Provider: "aws",
+				Status:   types.StatusCreating,
+			}, err
+		}
+	}
+
+	if err := c.updateZoneTags(ctx, id, nil, spec.zoneTags(ctx)); err != nil {
+		return nil, err
+	}
+	return c.ReadHostedZone(ctx, id)
+}
+
+// zoneTags returns the tags a zone should carry: the configured tags plus
+// the idempotency token and force_destroy markers
+func (s *hostedZoneSpec) zoneTags(ctx context.Context) map[string]string {
+	tags := mergeTags(s.tags, nil)
+	if token := provider.IdempotencyToken(ctx); token != "" {
+		tags[tokenTag] = token
+	}
+	if s.forceDestroy {
+		tags[forceDestroyTag] = "true"
+	}
+	return tags
+}
+
+// ReadHostedZone returns the current state of a hosted zone
+func (c *Route53Client) ReadHostedZone(ctx context.Context, id string) (*types.BaseResource, error) {
+	result, err := c.client.GetHostedZone(ctx, &route53.GetHostedZoneInput{Id: aws.String(id)})
+	if isZoneNotFound(err) {
+		return nil, fmt.Errorf("hosted zone %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hosted zone %s: %w", id, err)
+	}
+	tags, err := c.zoneTags(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return hostedZoneResource(result, tags), nil
+}
+
+// FindHostedZoneByToken returns the zone created with the given idempotency
+// token as its caller reference
+func (c *Route53Client) FindHostedZoneByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	id, err := c.findZoneByReference(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return c.ReadHostedZone(ctx, id)
+}
+
+func (c *Route53Client) findZoneByReference(ctx context.Context, reference string) (string, error) {
+	pages := route53.NewListHostedZonesPaginator(c.client, &route53.ListHostedZonesInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to list hosted zones: %w", err)
+		}
+		for _, z := range page.HostedZones {
+			if aws.ToString(z.CallerReference) == reference {
+				return zoneID(aws.ToString(z.Id)), nil
+			}
+		}
+	}
+	return "", provider.ErrNotFound
+}
+
+// UpdateHostedZone changes a zone's comment, tags and force_destroy. The
+// name and VPC are fixed at creation.
+func (c *Route53Client) UpdateHostedZone(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseHostedZoneSpec(config)
+	if err != nil {
+		return err
+	}
+
+	id := resource.GetID()
+	current, err := c.ReadHostedZone(ctx, id)
+	if err != nil {
+		return err
+	}
+	meta := current.Metadata
+	for attr, values := range map[string][2]string{
+		"name":   {spec.name, meta["name"].(string)},
+		"vpc_id": {spec.vpcID, meta["vpc_id"].(string)},
+	} {
+		if values[0] != values[1] {
+			return fmt.Errorf("changing %s of hosted zone %s requires replacing it", attr, id)
+		}
+	}
+
+	if spec.comment != meta["comment"] {
+		_, err := c.client.UpdateHostedZoneComment(ctx, &route53.UpdateHostedZoneCommentInput{
+			Id:      aws.String(id),
+			Comment: aws.String(spec.comment),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update comment of hosted zone %s: %w", id, err)
+		}
+	}
+
+	desired := mergeTags(reservedTags(current.Tags), spec.tags)
+	if spec.forceDestroy {
+		desired[forceDestroyTag] = "true"
+	}
+	return c.updateZoneTags(ctx, id, current.Tags, desired)
+}
+
+// DeleteHostedZone deletes a zone. A zone still holding records other than
+// its own SOA and NS records is only emptied first when force_destroy is
+// set. Zones that are already gone are not an error.
+func (c *Route53Client) DeleteHostedZone(ctx context.Context, resource provider.Resource) error {
+	id := resource.GetID()
+	_, err := c.client.DeleteHostedZone(ctx, &route53.DeleteHostedZoneInput{Id: aws.String(id)})
+	if errorCode(err) == "HostedZoneNotEmpty" {
+		tags, tagErr := c.zoneTags(ctx, id)
+		if tagErr != nil {
+			return tagErr
+		}
+		if tags[forceDestroyTag] != "true" {
+			return fmt.Errorf("hosted zone %s still has records; delete them or set force_destroy = true and apply before deleting it", id)
+		}
+		if err := c.emptyZone(ctx, id); err != nil {
+			return err
+		}
+		_, err = c.client.DeleteHostedZone(ctx, &route53.DeleteHostedZoneInput{Id: aws.String(id)})
+	}
+	if err != nil && !isZoneNotFound(err) {
+		return fmt.Errorf("failed to delete hosted zone %s: %w", id, err)
+	}
+	return nil
+}
+
+// emptyZone deletes every record in a zone except its own SOA and NS
+// records, which Route 53 removes with the zone
+func (c *Route53Client) emptyZone(ctx context.Context, id string) error {
+	result, err := c.client.GetHostedZone(ctx, &route53.GetHostedZoneInput{Id: aws.String(id)})
+	if err != nil {
+		return fmt.Errorf("failed to read hosted zone %s: %w", id, err)
+	}
+	apex := recordName(aws.ToString(result.HostedZone.Name))
+
+	var changes []r53types.Change
+	pages := route53.NewListResourceRecordSetsPaginator(c.client, &route53.ListResourceRecordSetsInput{HostedZoneId: aws.String(id)})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list records of hosted zone %s: %w", id, err)
+		}
+		for _, rs := range page.ResourceRecordSets {
+			if recordName(aws.ToString(rs.Name)) == apex && (rs.Type == r53types.RRTypeSoa || rs.Type == r53types.RRTypeNs) {
+				continue
+			}
+			changes = append(changes, r53types.Change{Action: r53types.ChangeActionDelete, ResourceRecordSet: &rs})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	_, err = c.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(id),
+		ChangeBatch:  &r53types.ChangeBatch{Changes: changes},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to empty hosted zone %s: %w", id, changeBatchError(err))
+	}
+	return nil
+}
+
+func (c *Route53Client) zoneTags(ctx context.Context, id string) (map[string]string, error) {
+	result, err := c.client.ListTagsForResource(ctx, &route53.ListTagsForResourceInput{
+		ResourceId:   aws.String(id),
+		ResourceType: r53types.TagResourceTypeHostedzone,
+	})
+	if isZoneNotFound(err) {
+		return nil, fmt.Errorf("hosted zone %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tags of hosted zone %s: %w", id, err)
+	}
+	tags := make(map[string]string)
+	if result.ResourceTagSet != nil {
+		for _, t := range result.ResourceTagSet.Tags {
+			tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+		}
+	}
+	return tags, nil
+}
+
+// updateZoneTags applies the difference between current and desired tags.
+// Reserved tags are never removed, except the force_destroy marker.
+func (c *Route53Client) updateZoneTags(ctx context.Context, id string, current, desired map[string]string) error {
+	input := &route53.ChangeTagsForResourceInput{
+		ResourceId:   aws.String(id),
+		ResourceType: r53types.TagResourceTypeHostedzone,
+	}
+	for _, k := range sortedKeys(desired) {
+		if cur, ok := current[k]; !ok || cur != desired[k] {
+			input.AddTags = append(input.AddTags, r53types.Tag{Key: aws.String(k), Value: aws.String(desired[k])})
+		}
+	}
+	for _, k := range sortedKeys(current) {
+		if _, ok := desired[k]; !ok && (!isReservedTag(k) || k == forceDestroyTag) {
+			input.RemoveTagKeys = append(input.RemoveTagKeys, k)
+		}
+	}
+	if len(input.AddTags) == 0 && len(input.RemoveTagKeys) == 0 {
+		return nil
+	}
+	if _, err := c.client.ChangeTagsForResource(ctx, input); err != nil {
+		return fmt.Errorf("failed to tag hosted zone %s: %w", id, err)
+	}
+	return nil
+}
+
+func hostedZoneResource(result *route53.GetHostedZoneOutput, tags map[string]string) *types.BaseResource {
+	z := result.HostedZone
+	var comment string
+	var private bool
+	if z.Config != nil {
+		comment = aws.ToString(z.Config.Comment)
+		private = z.Config.PrivateZone
+	}
+	var nameServers []interface{}
+	if result.DelegationSet != nil {
+		nameServers = interfaceList(result.DelegationSet.NameServers)
+	}
+	var vpcID, vpcRegion string
+	if len(result.VPCs) > 0 {
+		vpcID = aws.ToString(result.VPCs[0].VPCId)
+		vpcRegion = string(result.VPCs[0].VPCRegion)
+	}
+
+	id := zoneID(aws.ToString(z.Id))
+	return &types.BaseResource{
+		ID:       id,
+		Type:     types.ResourceTypeRoute53Zone,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"zone_id":       id,
+			"name":          recordName(aws.ToString(z.Name)),
+			"name_servers":  nameServers,
+			"comment":       comment,
+			"private":       private,
+			"vpc_id":        vpcID,
+			"vpc_region":    vpcRegion,
+			"record_count":  aws.ToInt64(z.ResourceRecordSetCount),
+			"force_destroy": tags[forceDestroyTag] == "true",
+		},
+		Tags:      tags,
+		UpdatedAt: time.Now(),
+	}
+}
+
+// recordSetSpec is the desired configuration of one record set. Plain
+// records have a TTL and values; alias records point at another AWS
+// resource instead. Weighted records share a name and type and are told
+// apart by their set identifier.
+type recordSetSpec struct {
+	alias         *aliasSpec
+	weight        *int64
+	zoneID        string
+	name          string
+	recordType    string
+	setIdentifier string
+	ttl           int64
+	records       []string
+	timeouts      timeouts
+}
+
+// aliasSpec is the target of an alias record, such as a load balancer
+type aliasSpec struct {
+	name                 string
+	zoneID               string
+	evaluateTargetHealth bool
+}
+
+// recordTypes are the record types route53_record manages
+var recordTypes = []string{"A", "AAAA", "CNAME", "TXT"}
+
+func parseRecordSetSpec(config map[string]interface{}) (*recordSetSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("zone_id", "name", "type")
+
+	spec := &recordSetSpec{
+		zoneID:        zoneID(attrs.String("zone_id")),
+		name:          recordName(attrs.String("name")),
+		recordType:    strings.ToUpper(attrs.String("type")),
+		setIdentifier: attrs.String("set_identifier"),
+		records:       attrs.StringList("records"),
+		timeouts:      parseTimeouts(attrs),
+	}
+	if attrs.Has("ttl") {
+		spec.ttl = int64(attrs.Int("ttl"))
+	}
+	if attrs.Has("weight") {
+		weight := int64(attrs.Int("weight"))
+		spec.weight = &weight
+	}
+	if attrs.Has("alias") {
+		alias := attrs.Table("alias")
+		alias.Require("name", "zone_id")
+		spec.alias = &aliasSpec{
+			name:                 recordName(alias.String("name")),
+			zoneID:               zoneID(alias.String("zone_id")),
+			evaluateTargetHealth: alias.Bool("evaluate_target_health"),
+		}
+		attrs.collectTable("alias", alias)
+	}
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(recordTypes, spec.recordType) {
+		return nil, fmt.Errorf("type must be one of %s, got %q", strings.Join(recordTypes, ", "), spec.recordType)
+	}
+	if spec.alias != nil {
+		if attrs.Has("ttl") || len(spec.records) > 0 {
+			return nil, fmt.Errorf("alias records take no ttl or records")
+		}
+		if spec.recordType != "A" && spec.recordType != "AAAA" {
+			return nil, fmt.Errorf("alias records must be of type A or AAAA, got %s", spec.recordType)
+		}
+	} else {
+		if len(spec.records) == 0 {
+			return nil, fmt.Errorf("records or alias is required")
+		}
+		if spec.recordType == "CNAME" && len(spec.records) > 1 {
+			return nil, fmt.Errorf("a CNAME record takes exactly one value, got %d", len(spec.records))
+		}
+		if !attrs.Has("ttl") {
+			spec.ttl = defaultRecordTTL
+		}
+	}
+	if (spec.setIdentifier == "") != (spec.weight == nil) {
+		return nil, fmt.Errorf("set_identifier and weight must be set together")
+	}
+	if spec.recordType == "TXT" {
+		for i, v := range spec.records {
+			spec.records[i] = txtValue(v)
+		}
+	}
+	return spec, nil
+}
+
+// qualify makes the record name absolute within the zone: names outside
+// it, such as "www", are taken to be relative to it
+func (s *recordSetSpec) qualify(zoneName string) {
+	if s.name == "" || s.name == "@" {
+		s.name = zoneName
+	} else if s.name != zoneName && !strings.HasSuffix(s.name, "."+zoneName) {
+		s.name += "." + zoneName
+	}
+}
+
+func (s *recordSetSpec) recordSet() *r53types.ResourceRecordSet {
+	rs := &r53types.ResourceRecordSet{
+		Name:   aws.String(s.name),
+		Type:   r53types.RRType(s.recordType),
+		Weight: s.weight,
+	}
+	if s.setIdentifier != "" {
+		rs.SetIdentifier = aws.String(s.setIdentifier)
+	}
+	if s.alias != nil {
+		rs.AliasTarget = &r53types.AliasTarget{
+			DNSName:              aws.String(s.alias.name),
+			HostedZoneId:         aws.String(s.alias.zoneID),
+			EvaluateTargetHealth: s.alias.evaluateTargetHealth,
+		}
+		return rs
+	}
+	rs.TTL = aws.Int64(s.ttl)
+	for _, v := range s.records {
+		rs.ResourceRecords = append(rs.ResourceRecords, r53types.ResourceRecord{Value: aws.String(v)})
+	}
+	return rs
+}
+
+// same reports whether rs already matches the spec
+func (s *recordSetSpec) same(rs r53types.ResourceRecordSet) bool {
+	if aws.ToInt64(s.weight) != aws.ToInt64(rs.Weight) {
+		return false
+	}
+	if s.alias != nil || rs.AliasTarget != nil {
+		a := rs.AliasTarget
+		return s.alias != nil && a != nil &&
+			s.alias.name == recordName(aws.ToString(a.DNSName)) &&
+			s.alias.zoneID == zoneID(aws.ToString(a.HostedZoneId)) &&
+			s.alias.evaluateTargetHealth == a.EvaluateTargetHealth
+	}
+	return s.ttl == aws.ToInt64(rs.TTL) && sameStrings(s.records, recordValues(rs))
+}
+
+// recordSetID identifies a record set by its zone, name, type and, for
+// weighted records, set identifier, since Route 53 gives record sets no ID
+// of their own
+func recordSetID(zone, name, recordType, setIdentifier string) string {
+	id := zone + "," + name + "," + recordType
+	if setIdentifier != "" {
+		id += "," + setIdentifier
+	}
+	return id
+}
+
+func (s *recordSetSpec) id() string {
+	return recordSetID(s.zoneID, s.name, s.recordType, s.setIdentifier)
+}
+
+func parseRecordSetID(id string) (recordSetSpec, error) {
+	parts := strings.SplitN(id, ",", 4)
+	if len(parts) < 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return recordSetSpec{}, fmt.Errorf("invalid record ID %q: expected <zone-id>,<name>,<type>[,<set-identifier>]", id)
+	}
+	spec := recordSetSpec{zoneID: parts[0], name: parts[1], recordType: parts[2]}
+	if len(parts) == 4 {
+		spec.setIdentifier = parts[3]
+	}
+	return spec, nil
+}
+
+// CreateRecordSet creates a record set and waits until Route 53 has
+// propagated it. Records cannot be tagged, so a retried create adopts a
+// record that already holds exactly the configured values; one holding
+// anything else is an error rather than being overwritten.
+func (c *Route53Client) CreateRecordSet(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseRecordSetSpec(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.qualify(ctx, spec); err != nil {
+		return nil, err
+	}
+
+	result, err := c.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(spec.zoneID),
+		ChangeBatch: &r53types.ChangeBatch{Changes: []r53types.Change{
+			{Action: r53types.ChangeActionCreate, ResourceRecordSet: spec.recordSet()},
+		}},
+	})
+	if errorCode(err) == "InvalidChangeBatch" {
+		existing, readErr := c.findRecordSet(ctx, *spec)
+		if readErr == nil && spec.same(*existing) {
+			return recordSetResource(spec.zoneID, *existing), nil
+		}
+		if readErr == nil {
+			return nil, fmt.Errorf("record %s %s already exists with other values", spec.name, spec.recordType)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create record %s %s: %w", spec.name, spec.recordType, changeBatchError(err))
+	}
+
+	if err := c.waitForChange(ctx, result.ChangeInfo, spec.timeouts.create); err != nil {
+		return &types.BaseResource{
+			ID:       spec.id(),
+			Type:     types.ResourceTypeRoute53Record,
+			Provider: "aws",
+			Status:   types.StatusCreating,
+		}, err
+	}
+	return c.ReadRecordSet(ctx, spec.id())
+}
+
+// ReadRecordSet returns the current state of a record set
+func (c *Route53Client) ReadRecordSet(ctx context.Context, id string) (*types.BaseResource, error) {
+	key, err := parseRecordSetID(id)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := c.findRecordSet(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return recordSetResource(key.zoneID, *rs), nil
+}
+
+// FindRecordSetByToken always reports provider.ErrNotFound: record sets
+// cannot be tagged, and an interrupted create is finished by the next one,
+// which adopts a record already holding the configured values
+func (c *Route53Client) FindRecordSetByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	return nil, provider.ErrNotFound
+}
+
+// UpdateRecordSet changes a record's TTL, values, alias target or weight
+// in place. The zone, name, type and set identifier identify the record,
+// so changing them replaces it.
+func (c *Route53Client) UpdateRecordSet(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseRecordSetSpec(config)
+	if err != nil {
+		return err
+	}
+	if err := c.qualify(ctx, spec); err != nil {
+		return err
+	}
+
+	id := resource.GetID()
+	current, err := parseRecordSetID(id)
+	if err != nil {
+		return err
+	}
+	for attr, values := range map[string][2]string{
+		"zone_id":        {spec.zoneID, current.zoneID},
+		"name":           {spec.name, current.name},
+		"type":           {spec.recordType, current.recordType},
+		"set_identifier": {spec.setIdentifier, current.setIdentifier},
+	} {
+		if values[0] != values[1] {
+			return fmt.Errorf("changing %s of record %s requires replacing it", attr, id)
+		}
+	}
+
+	rs, err := c.findRecordSet(ctx, current)
+	if err != nil && !errors.Is(err, provider.ErrNotFound) {
+		return err
+	}
+	if err == nil && spec.same(*rs) {
+		return nil
+	}
+
+	result, err := c.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(spec.zoneID),
+		ChangeBatch: &r53types.ChangeBatch{Changes: []r53types.Change{
+			{Action: r53types.ChangeActionUpsert, ResourceRecordSet: spec.recordSet()},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update record %s: %w", id, changeBatchError(err))
+	}
+	return c.waitForChange(ctx, result.ChangeInfo, spec.timeouts.update)
+}
+
+// DeleteRecordSet deletes a record set. Route 53 only deletes a record
+// given its exact current values, so they are read first. Records or zones
+// that are already gone are not an error.
+func (c *Route53Client) DeleteRecordSet(ctx context.Context, resource provider.Resource) error {
+	id := resource.GetID()
+	key, err := parseRecordSetID(id)
+	if err != nil {
+		return err
+	}
+	rs, err := c.findRecordSet(ctx, key)
+	if errors.Is(err, provider.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := c.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(key.zoneID),
+		ChangeBatch: &r53types.ChangeBatch{Changes: []r53types.Change{
+			{Action: r53types.ChangeActionDelete, ResourceRecordSet: rs},
+		}},
+	})
+	if isZoneNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete record %s: %w", id, changeBatchError(err))
+	}
+	return c.waitForChange(ctx, result.ChangeInfo, defaultDeleteTimeout)
+}
+
+// qualify makes the spec's record name absolute within its zone
+func (c *Route53Client) qualify(ctx context.Context, spec *recordSetSpec) error {
+	result, err := c.client.GetHostedZone(ctx, &route53.GetHostedZoneInput{Id: aws.String(spec.zoneID)})
+	if isZoneNotFound(err) {
+		return fmt.Errorf("hosted zone %s: %w", spec.zoneID, provider.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read hosted zone %s: %w", spec.zoneID, err)
+	}
+	spec.qualify(recordName(aws.ToString(result.HostedZone.Name)))
+	return nil
+}
+
+// findRecordSet returns the record set a spec identifies. Listing starts
+// at its name and type, which Route 53 sorts together, and stops as soon
+// as they are passed.
+func (c *Route53Client) findRecordSet(ctx context.Context, key recordSetSpec) (*r53types.ResourceRecordSet, error) {
+	input := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(key.zoneID),
+		StartRecordName: aws.String(key.name),
+		StartRecordType: r53types.RRType(key.recordType),
+	}
+	if key.setIdentifier != "" {
+		input.StartRecordIdentifier = aws.String(key.setIdentifier)
+	}
+	notFound := fmt.Errorf("record %s: %w", key.id(), provider.ErrNotFound)
+
+	pages := route53.NewListResourceRecordSetsPaginator(c.client, input)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if isZoneNotFound(err) {
+			return nil, notFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read record %s: %w", key.id(), err)
+		}
+		for _, rs := range page.ResourceRecordSets {
+			if recordName(aws.ToString(rs.Name)) != key.name || string(rs.Type) != key.recordType {
+				return nil, notFound
+			}
+			if aws.ToString(rs.SetIdentifier) == key.setIdentifier {
+				return &rs, nil
+			}
+		}
+	}
+	return nil, notFound
+}
+
+// waitForChange polls until Route 53 reports a change as propagated to all
+// of its name servers
+func (c *Route53Client) waitForChange(ctx context.Context, change *r53types.ChangeInfo, timeout time.Duration) error {
+	if change == nil || change.Status == r53types.ChangeStatusInsync {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	id := aws.ToString(change.Id)
+	for {
+		result, err := c.client.GetChange(ctx, &route53.GetChangeInput{Id: aws.String(id)})
+		if err != nil {
+			return fmt.Errorf("failed to read change %s: %w", id, err)
+		}
+		if result.ChangeInfo.Status == r53types.ChangeStatusInsync {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for change %s to propagate", timeout, id)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+func recordSetResource(zone string, rs r53types.ResourceRecordSet) *types.BaseResource {
+	name := recordName(aws.ToString(rs.Name))
+	metadata := map[string]interface{}{
+		"zone_id":        zone,
+		"name":           name,
+		"fqdn":           name,
+		"type":           string(rs.Type),
+		"set_identifier": aws.ToString(rs.SetIdentifier),
+		"records":        interfaceList(recordValues(rs)),
+	}
+	if rs.TTL != nil {
+		metadata["ttl"] = aws.ToInt64(rs.TTL)
+	}
+	if rs.Weight != nil {
+		metadata["weight"] = aws.ToInt64(rs.Weight)
+	}
+	if a := rs.AliasTarget; a != nil {
+		metadata["alias"] = map[string]interface{}{
+			"name":                   recordName(aws.ToString(a.DNSName)),
+			"zone_id":                zoneID(aws.ToString(a.HostedZoneId)),
+			"evaluate_target_health": a.EvaluateTargetHealth,
+		}
+	}
+	return &types.BaseResource{
+		ID:        recordSetID(zone, name, string(rs.Type), aws.ToString(rs.SetIdentifier)),
+		Type:      types.ResourceTypeRoute53Record,
+		Provider:  "aws",
+		Status:    types.StatusRunning,
+		Metadata:  metadata,
+		UpdatedAt: time.Now(),
+	}
+}
+
+func recordValues(rs r53types.ResourceRecordSet) []string {
+	values := make([]string, 0, len(rs.ResourceRecords))
+	for _, r := range rs.ResourceRecords {
+		values = append(values, aws.ToString(r.Value))
+	}
+	sort.Strings(values)
+	return values
+}
+
+// recordName normalises a DNS name the way duet keeps it: lower case,
+// without the trailing dot and with the wildcard label Route 53 escapes
+// as \052 spelled out
+func recordName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.ReplaceAll(name, `\052`, "*")), ".")
+}
+
+// zoneID strips the /hostedzone/ prefix Route 53 returns zone IDs with
+func zoneID(id string) string {
+	return strings.TrimPrefix(id, "/hostedzone/")
+}
+
+// txtValue quotes a TXT value as Route 53 requires, splitting values too
+// long for one character string into several. Values that are already
+// quoted are passed through unchanged.
+func txtValue(v string) string {
+	if strings.HasPrefix(v, `"`) {
+		return v
+	}
+	var parts []string
+	for len(v) > maxTXTStringLength {
+		parts = append(parts, strconv.Quote(v[:maxTXTStringLength]))
+		v = v[maxTXTStringLength:]
+	}
+	parts = append(parts, strconv.Quote(v))
+	return strings.Join(parts, " ")
+}
+
+// changeBatchError adds the reasons Route 53 gives for rejecting a change
+// batch, which its error message leaves out
+func changeBatchError(err error) error {
+	var batch *r53types.InvalidChangeBatch
+	if errors.As(err, &batch) && len(batch.Messages) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.Join(batch.Messages, "; "))
+	}
+	return err
+}
+
+func isZoneNotFound(err error) bool {
+	return errorCode(err) == "NoSuchHostedZone"
+}
+
+// ReadHostedZoneData looks up a hosted zone by name, for records in zones
+// managed elsewhere. Public and private zones may share a name, so private
+// picks between them.
+func (c *Route53Client) ReadHostedZoneData(ctx context.Context, config map[string]interface{}) (map[string]interface{}, error) {
+	attrs := newAttributes(config)
+	attrs.Require("name")
+	name := recordName(attrs.String("name"))
+	private := attrs.Bool("private")
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+
+	result, err := c.client.ListHostedZonesByName(ctx, &route53.ListHostedZonesByNameInput{DNSName: aws.String(name)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up hosted zone %s: %w", name, err)
+	}
+	for _, z := range result.HostedZones {
+		if recordName(aws.ToString(z.Name)) != name {
+			break
+		}
+		if z.Config != nil && z.Config.PrivateZone != private {
+			continue
+		}
+		zone, err := c.ReadHostedZone(ctx, zoneID(aws.ToString(z.Id)))
+		if err != nil {
+			return nil, err
+		}
+		return dataAttributes(zone), nil
+	}
+	return nil, fmt.Errorf("hosted zone %s: %w", name, provider.ErrNotFound)
+}
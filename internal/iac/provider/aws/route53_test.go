@@ -0,0 +1,289 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+func TestHostedZones(t *testing.T) {
+	p, cloud := newCloudTestProvider(t)
+	ctx := context.Background()
+
+	config := map[string]interface{}{
+		"name":    "Example.com.",
+		"comment": "public",
+		"tags":    map[string]interface{}{"team": "dns"},
+	}
+
+	var zone provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		zone, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-zone"), "route53_zone", config)
+		if err != nil {
+			t.Fatalf("Failed to create hosted zone: %v", err)
+		}
+		meta := zone.GetMetadata()
+		if meta["name"] != "example.com" || meta["zone_id"] != zone.GetID() || meta["private"] != false {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+		if ns := meta["name_servers"].([]interface{}); len(ns) != 4 {
+			t.Errorf("Expected four name servers, got %v", ns)
+		}
+		if zone.GetTags()["team"] != "dns" || zone.GetTags()[tokenTag] != "run-1-zone" {
+			t.Errorf("Unexpected tags: %v", zone.GetTags())
+		}
+		if n := cloud.Route53.Calls("GetChange"); n == 0 {
+			t.Error("Expected create to wait for the change to propagate")
+		}
+
+		again, err := p.Create(provider.WithIdempotencyToken(ctx, "run-1-zone"), "route53_zone", config)
+		if err != nil || again.GetID() != zone.GetID() {
+			t.Errorf("Expected retried create to return %s, got %v, %v", zone.GetID(), again, err)
+		}
+		found, err := p.FindByToken(ctx, "route53_zone", "run-1-zone")
+		if err != nil || found.GetID() != zone.GetID() {
+			t.Errorf("Expected to find %s by token, got %v, %v", zone.GetID(), found, err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		config["comment"] = "managed by duet"
+		config["tags"] = map[string]interface{}{"team": "platform"}
+		if err := p.Update(ctx, zone, config); err != nil {
+			t.Fatalf("Failed to update hosted zone: %v", err)
+		}
+		got, _ := cloud.Route53.HostedZone(zone.GetID())
+		if got.Comment != "managed by duet" {
+			t.Errorf("Expected the comment to change, got %q", got.Comment)
+		}
+		if got.Tags["team"] != "platform" || got.Tags[tokenTag] != "run-1-zone" {
+			t.Errorf("Expected the team tag to change and the token to stay, got %v", got.Tags)
+		}
+
+		renamed := map[string]interface{}{"name": "example.org"}
+		if err := p.Update(ctx, zone, renamed); err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected renaming to require replacement, got %v", err)
+		}
+	})
+
+	t.Run("Private", func(t *testing.T) {
+		vpc, err := p.Create(ctx, "vpc", map[string]interface{}{"cidr_block": "10.0.0.0/16"})
+		if err != nil {
+			t.Fatalf("Failed to create VPC: %v", err)
+		}
+		private, err := p.Create(ctx, "route53_zone", map[string]interface{}{"name": "example.com", "vpc_id": vpc.GetID()})
+		if err != nil {
+			t.Fatalf("Failed to create private hosted zone: %v", err)
+		}
+		if meta := private.GetMetadata(); meta["private"] != true || meta["vpc_id"] != vpc.GetID() {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+
+		data, err := p.ReadData(ctx, "route53_zone", map[string]interface{}{"name": "example.com", "private": true})
+		if err != nil || data["id"] != private.GetID() {
+			t.Errorf("Expected the data source to find the private zone, got %v, %v", data, err)
+		}
+		data, err = p.ReadData(ctx, "route53_zone", map[string]interface{}{"name": "example.com"})
+		if err != nil || data["id"] != zone.GetID() {
+			t.Errorf("Expected the data source to find the public zone, got %v, %v", data, err)
+		}
+		if _, err := p.ReadData(ctx, "route53_zone", map[string]interface{}{"name": "example.net"}); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown zone, got %v", err)
+		}
+		if err := p.Delete(ctx, private); err != nil {
+			t.Errorf("Failed to delete private hosted zone: %v", err)
+		}
+	})
+
+	t.Run("DeleteNotEmpty", func(t *testing.T) {
+		record := map[string]interface{}{"zone_id": zone.GetID(), "name": "www", "type": "A", "records": []interface{}{"10.0.0.1"}}
+		if _, err := p.Create(ctx, "route53_record", record); err != nil {
+			t.Fatalf("Failed to create record: %v", err)
+		}
+		if err := p.Delete(ctx, zone); err == nil || !strings.Contains(err.Error(), "force_destroy") {
+			t.Errorf("Expected deleting a zone with records to fail, got %v", err)
+		}
+
+		config["force_destroy"] = true
+		if err := p.Update(ctx, zone, config); err != nil {
+			t.Fatalf("Failed to set force_destroy: %v", err)
+		}
+		if err := p.Delete(ctx, zone); err != nil {
+			t.Fatalf("Failed to delete hosted zone: %v", err)
+		}
+		if _, err := p.Read(ctx, "route53_zone", zone.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		if err := p.Delete(ctx, zone); err != nil {
+			t.Errorf("Expected deleting again to succeed, got %v", err)
+		}
+	})
+}
+
+func TestRecordSets(t *testing.T) {
+	p, cloud := newCloudTestProvider(t)
+	ctx := context.Background()
+
+	zone, err := p.Create(ctx, "route53_zone", map[string]interface{}{"name": "example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create hosted zone: %v", err)
+	}
+	zoneID := zone.GetID()
+
+	var www provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		config := map[string]interface{}{"zone_id": zoneID, "name": "www", "type": "A", "records": []interface{}{"10.0.0.2", "10.0.0.1"}}
+		www, err = p.Create(ctx, "route53_record", config)
+		if err != nil {
+			t.Fatalf("Failed to create record: %v", err)
+		}
+		if www.GetID() != zoneID+",www.example.com,A" {
+			t.Errorf("Unexpected ID %s", www.GetID())
+		}
+		meta := www.GetMetadata()
+		if meta["ttl"] != int64(300) || !reflect.DeepEqual(meta["records"], []interface{}{"10.0.0.1", "10.0.0.2"}) {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+
+		again, err := p.Create(ctx, "route53_record", config)
+		if err != nil || again.GetID() != www.GetID() {
+			t.Errorf("Expected creating an identical record to adopt it, got %v, %v", again, err)
+		}
+		other := map[string]interface{}{"zone_id": zoneID, "name": "www.example.com", "type": "A", "records": []interface{}{"10.0.0.9"}}
+		if _, err := p.Create(ctx, "route53_record", other); err == nil || !strings.Contains(err.Error(), "already exists") {
+			t.Errorf("Expected an error for a record holding other values, got %v", err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		config := map[string]interface{}{"zone_id": zoneID, "name": "www", "type": "A", "records": []interface{}{"10.0.0.1", "10.0.0.2"}}
+		before := cloud.Route53.Calls("ChangeResourceRecordSets")
+		if err := p.Update(ctx, www, config); err != nil {
+			t.Fatalf("Failed to reapply record: %v", err)
+		}
+		if n := cloud.Route53.Calls("ChangeResourceRecordSets"); n != before {
+			t.Errorf("Expected no change for an unchanged record, got %d", n-before)
+		}
+
+		config["records"] = []interface{}{"10.0.0.3"}
+		config["ttl"] = 60
+		if err := p.Update(ctx, www, config); err != nil {
+			t.Fatalf("Failed to update record: %v", err)
+		}
+		read, err := p.Read(ctx, "route53_record", www.GetID())
+		if err != nil {
+			t.Fatalf("Failed to read record: %v", err)
+		}
+		if meta := read.GetMetadata(); meta["ttl"] != int64(60) || !reflect.DeepEqual(meta["records"], []interface{}{"10.0.0.3"}) {
+			t.Errorf("Unexpected metadata after update: %v", meta)
+		}
+
+		config["type"] = "AAAA"
+		if err := p.Update(ctx, www, config); err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected changing the type to require replacement, got %v", err)
+		}
+	})
+
+	t.Run("TXT", func(t *testing.T) {
+		long := strings.Repeat("a", 300)
+		txt, err := p.Create(ctx, "route53_record", map[string]interface{}{
+			"zone_id": zoneID, "name": "example.com", "type": "TXT",
+			"records": []interface{}{"v=spf1 -all", long},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create TXT record: %v", err)
+		}
+		want := []interface{}{`"` + long[:255] + `" "` + long[255:] + `"`, `"v=spf1 -all"`}
+		if got := txt.GetMetadata()["records"]; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected quoted values %v, got %v", want, got)
+		}
+	})
+
+	t.Run("Weighted", func(t *testing.T) {
+		for _, w := range []struct {
+			id     string
+			weight int
+			ip     string
+		}{{"blue", 90, "10.0.1.1"}, {"green", 10, "10.0.2.1"}} {
+			_, err := p.Create(ctx, "route53_record", map[string]interface{}{
+				"zone_id": zoneID, "name": "api", "type": "A", "records": []interface{}{w.ip},
+				"set_identifier": w.id, "weight": w.weight,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create weighted record %s: %v", w.id, err)
+			}
+		}
+		green, err := p.Read(ctx, "route53_record", zoneID+",api.example.com,A,green")
+		if err != nil {
+			t.Fatalf("Failed to read weighted record: %v", err)
+		}
+		if meta := green.GetMetadata(); meta["weight"] != int64(10) || meta["set_identifier"] != "green" {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+
+		if _, err := p.Create(ctx, "route53_record", map[string]interface{}{
+			"zone_id": zoneID, "name": "api", "type": "A", "records": []interface{}{"10.0.3.1"}, "weight": 5,
+		}); err == nil {
+			t.Error("Expected a weight without a set identifier to be rejected")
+		}
+	})
+
+	t.Run("Alias", func(t *testing.T) {
+		config := map[string]interface{}{
+			"zone_id": zoneID, "name": "example.com", "type": "A",
+			"alias": map[string]interface{}{"name": "web-1234.us-east-1.elb.amazonaws.com", "zone_id": "Z35SXDOTRQ7X7K", "evaluate_target_health": true},
+		}
+		apex, err := p.Create(ctx, "route53_record", config)
+		if err != nil {
+			t.Fatalf("Failed to create alias record: %v", err)
+		}
+		alias := apex.GetMetadata()["alias"].(map[string]interface{})
+		if alias["name"] != "web-1234.us-east-1.elb.amazonaws.com" || alias["evaluate_target_health"] != true {
+			t.Errorf("Unexpected alias: %v", alias)
+		}
+		if _, ok := apex.GetMetadata()["ttl"]; ok {
+			t.Errorf("Expected no TTL on an alias record, got %v", apex.GetMetadata())
+		}
+
+		config["ttl"] = 60
+		if _, err := p.Create(ctx, "route53_record", config); err == nil {
+			t.Error("Expected a TTL on an alias record to be rejected")
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		for name, config := range map[string]map[string]interface{}{
+			"MissingType":   {"zone_id": zoneID, "name": "a", "records": []interface{}{"10.0.0.1"}},
+			"BadType":       {"zone_id": zoneID, "name": "a", "type": "SRV", "records": []interface{}{"1 1 80 a"}},
+			"NoRecords":     {"zone_id": zoneID, "name": "a", "type": "A"},
+			"LongCNAME":     {"zone_id": zoneID, "name": "a", "type": "CNAME", "records": []interface{}{"b", "c"}},
+			"CNAMEConflict": {"zone_id": zoneID, "name": "www", "type": "CNAME", "records": []interface{}{"example.org"}},
+		} {
+			if _, err := p.Create(ctx, "route53_record", config); err == nil {
+				t.Errorf("%s: expected error, got nil", name)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, www); err != nil {
+			t.Fatalf("Failed to delete record: %v", err)
+		}
+		if _, err := p.Read(ctx, "route53_record", www.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		if err := p.Delete(ctx, www); err != nil {
+			t.Errorf("Expected deleting again to succeed, got %v", err)
+		}
+		for _, rs := range cloud.Route53.Records(zoneID) {
+			if rs.Name == "www.example.com." {
+				t.Errorf("Expected www to be gone, got %+v", rs)
+			}
+		}
+	})
+}
@@ -0,0 +1,421 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// route is one route in a route table, sending a CIDR block to exactly one
+// target
+type route struct {
+	cidrBlock    string
+	gatewayID    string
+	natGatewayID string
+}
+
+// routeTableSpec is the desired configuration of a route table. Routes are
+// managed as a whole: any route not listed is removed, except the VPC's
+// local route.
+type routeTableSpec struct {
+	tags   map[string]string
+	vpcID  string
+	routes []route
+}
+
+func parseRouteTableSpec(config map[string]interface{}) (*routeTableSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("vpc_id")
+
+	spec := &routeTableSpec{
+		vpcID: attrs.String("vpc_id"),
+		tags:  attrs.StringMap("tags"),
+	}
+
+	routes := attrs.List("routes")
+	for _, r := range routes {
+		r.Require("cidr_block")
+		spec.routes = append(spec.routes, route{
+			cidrBlock:    r.String("cidr_block"),
+			gatewayID:    r.String("gateway_id"),
+			natGatewayID: r.String("nat_gateway_id"),
+		})
+	}
+	attrs.collect("routes", routes...)
+
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(spec.routes))
+	for i, r := range spec.routes {
+		if (r.gatewayID == "") == (r.natGatewayID == "") {
+			return nil, fmt.Errorf("routes[%d]: exactly one of gateway_id and nat_gateway_id is required", i+1)
+		}
+		if seen[r.cidrBlock] {
+			return nil, fmt.Errorf("routes[%d]: more than one route for %s", i+1, r.cidrBlock)
+		}
+		seen[r.cidrBlock] = true
+	}
+	return spec, nil
+}
+
+// CreateRouteTable creates a route table in a VPC and adds its routes
+func (c *EC2Client) CreateRouteTable(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseRouteTableSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.client.CreateRouteTable(ctx, &ec2.CreateRouteTableInput{
+		VpcId:             aws.String(spec.vpcID),
+		TagSpecifications: tagSpecification(ctx, ec2types.ResourceTypeRouteTable, spec.tags),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create route table: %w", err)
+	}
+
+	// The table exists from here on, so it is returned even if adding its
+	// routes fails, letting the caller track it
+	table := *result.RouteTable
+	if err := c.syncRoutes(ctx, table, spec.routes); err != nil {
+		return routeTableResource(table), err
+	}
+	return c.ReadRouteTable(ctx, aws.ToString(table.RouteTableId))
+}
+
+// ReadRouteTable returns the current state of a route table
+func (c *EC2Client) ReadRouteTable(ctx context.Context, id string) (*types.BaseResource, error) {
+	table, err := c.describeRouteTable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return routeTableResource(*table), nil
+}
+
+// FindRouteTableByToken returns the route table created with the given
+// idempotency token
+func (c *EC2Client) FindRouteTableByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{Filters: tokenFilter(token)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find route table by token: %w", err)
+	}
+	if len(result.RouteTables) == 0 {
+		return nil, provider.ErrNotFound
+	}
+	return routeTableResource(result.RouteTables[0]), nil
+}
+
+// UpdateRouteTable brings the table's routes and tags in line with config.
+// The VPC is fixed at creation.
+func (c *EC2Client) UpdateRouteTable(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseRouteTableSpec(config)
+	if err != nil {
+		return err
+	}
+
+	id := resource.GetID()
+	table, err := c.describeRouteTable(ctx, id)
+	if err != nil {
+		return err
+	}
+	if spec.vpcID != aws.ToString(table.VpcId) {
+		return fmt.Errorf("changing vpc_id of route table %s requires replacing it", id)
+	}
+
+	if err := c.syncRoutes(ctx, *table, spec.routes); err != nil {
+		return err
+	}
+	return c.updateTags(ctx, id, fromEC2Tags(table.Tags), spec.tags)
+}
+
+// DeleteRouteTable deletes a route table, waiting out associations that are
+// still being removed
+func (c *EC2Client) DeleteRouteTable(ctx context.Context, resource provider.Resource) error {
+	id := resource.GetID()
+	err := c.retryDependencyViolation(ctx, "route table "+id, func() error {
+		_, err := c.client.DeleteRouteTable(ctx, &ec2.DeleteRouteTableInput{RouteTableId: aws.String(id)})
+		return err
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete route table %s: %w", id, err)
+	}
+	return nil
+}
+
+// syncRoutes adds, retargets and removes routes so the table holds exactly
+// the desired ones. Routes AWS manages, such as the local route and routes
+// propagated from gateways, are left alone.
+func (c *EC2Client) syncRoutes(ctx context.Context, table ec2types.RouteTable, desired []route) error {
+	id := aws.ToString(table.RouteTableId)
+	current := make(map[string]route)
+	for _, r := range managedRoutes(table) {
+		current[r.cidrBlock] = r
+	}
+
+	for _, want := range desired {
+		have, ok := current[want.cidrBlock]
+		delete(current, want.cidrBlock)
+		switch {
+		case !ok:
+			_, err := c.client.CreateRoute(ctx, &ec2.CreateRouteInput{
+				RouteTableId:         aws.String(id),
+				DestinationCidrBlock: aws.String(want.cidrBlock),
+				GatewayId:            optionalString(want.gatewayID),
+				NatGatewayId:         optionalString(want.natGatewayID),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to add route %s to %s: %w", want.cidrBlock, id, err)
+			}
+		case have != want:
+			_, err := c.client.ReplaceRoute(ctx, &ec2.ReplaceRouteInput{
+				RouteTableId:         aws.String(id),
+				DestinationCidrBlock: aws.String(want.cidrBlock),
+				GatewayId:            optionalString(want.gatewayID),
+				NatGatewayId:         optionalString(want.natGatewayID),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to replace route %s in %s: %w", want.cidrBlock, id, err)
+			}
+		}
+	}
+
+	removed := make([]string, 0, len(current))
+	for cidr := range current {
+		removed = append(removed, cidr)
+	}
+	sort.Strings(removed)
+	for _, cidr := range removed {
+		_, err := c.client.DeleteRoute(ctx, &ec2.DeleteRouteInput{
+			RouteTableId:         aws.String(id),
+			DestinationCidrBlock: aws.String(cidr),
+		})
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to remove route %s from %s: %w", cidr, id, err)
+		}
+	}
+	return nil
+}
+
+func (c *EC2Client) describeRouteTable(ctx context.Context, id string) (*ec2types.RouteTable, error) {
+	result, err := c.client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{RouteTableIds: []string{id}})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("route table %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe route table %s: %w", id, err)
+	}
+	if len(result.RouteTables) == 0 {
+		return nil, fmt.Errorf("route table %s: %w", id, provider.ErrNotFound)
+	}
+	return &result.RouteTables[0], nil
+}
+
+// managedRoutes returns the IPv4 routes added with CreateRoute
+func managedRoutes(table ec2types.RouteTable) []route {
+	var routes []route
+	for _, r := range table.Routes {
+		if r.Origin != ec2types.RouteOriginCreateRoute || r.DestinationCidrBlock == nil {
+			continue
+		}
+		routes = append(routes, route{
+			cidrBlock:    aws.ToString(r.DestinationCidrBlock),
+			gatewayID:    aws.ToString(r.GatewayId),
+			natGatewayID: aws.ToString(r.NatGatewayId),
+		})
+	}
+	return routes
+}
+
+func routeTableResource(table ec2types.RouteTable) *types.BaseResource {
+	routes := make([]interface{}, 0, len(table.Routes))
+	for _, r := range managedRoutes(table) {
+		entry := map[string]interface{}{"cidr_block": r.cidrBlock}
+		if r.gatewayID != "" {
+			entry["gateway_id"] = r.gatewayID
+		}
+		if r.natGatewayID != "" {
+			entry["nat_gateway_id"] = r.natGatewayID
+		}
+		routes = append(routes, entry)
+	}
+	subnets := make([]interface{}, 0, len(table.Associations))
+	for _, a := range table.Associations {
+		if a.SubnetId != nil {
+			subnets = append(subnets, aws.ToString(a.SubnetId))
+		}
+	}
+
+	return &types.BaseResource{
+		ID:       aws.ToString(table.RouteTableId),
+		Type:     types.ResourceTypeRouteTable,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"route_table_id": aws.ToString(table.RouteTableId),
+			"vpc_id":         aws.ToString(table.VpcId),
+			"routes":         routes,
+			"subnet_ids":     subnets,
+		},
+		Tags:      fromEC2Tags(table.Tags),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// associationSpec is the desired association of a subnet with a route table
+type associationSpec struct {
+	routeTableID string
+	subnetID     string
+}
+
+func parseAssociationSpec(config map[string]interface{}) (*associationSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("route_table_id", "subnet_id")
+
+	spec := &associationSpec{
+		routeTableID: attrs.String("route_table_id"),
+		subnetID:     attrs.String("subnet_id"),
+	}
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// CreateRouteTableAssociation associates a subnet with a route table. If
+// the subnet is already associated with that table, the existing
+// association is returned, so retrying an interrupted create is safe.
+func (c *EC2Client) CreateRouteTableAssociation(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseAssociationSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := c.client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+		RouteTableIds: []string{spec.routeTableID},
+		Filters:       []ec2types.Filter{{Name: aws.String("association.subnet-id"), Values: []string{spec.subnetID}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up associations of %s: %w", spec.routeTableID, err)
+	}
+	for _, table := range existing.RouteTables {
+		for _, a := range table.Associations {
+			if aws.ToString(a.SubnetId) == spec.subnetID {
+				return associationResource(a), nil
+			}
+		}
+	}
+
+	result, err := c.client.AssociateRouteTable(ctx, &ec2.AssociateRouteTableInput{
+		RouteTableId: aws.String(spec.routeTableID),
+		SubnetId:     aws.String(spec.subnetID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to associate %s with %s: %w", spec.subnetID, spec.routeTableID, err)
+	}
+	return associationResource(ec2types.RouteTableAssociation{
+		RouteTableAssociationId: result.AssociationId,
+		RouteTableId:            aws.String(spec.routeTableID),
+		SubnetId:                aws.String(spec.subnetID),
+	}), nil
+}
+
+// ReadRouteTableAssociation returns the current state of an association
+func (c *EC2Client) ReadRouteTableAssociation(ctx context.Context, id string) (*types.BaseResource, error) {
+	association, err := c.describeAssociation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return associationResource(*association), nil
+}
+
+// FindRouteTableAssociationByToken always reports provider.ErrNotFound.
+// Associations cannot be tagged, but creating one is idempotent, so the
+// next run picks up an association an interrupted run made.
+func (c *EC2Client) FindRouteTableAssociationByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	return nil, provider.ErrNotFound
+}
+
+// UpdateRouteTableAssociation checks that the association still matches
+// config. Both ends are fixed, so any change requires replacing it.
+func (c *EC2Client) UpdateRouteTableAssociation(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseAssociationSpec(config)
+	if err != nil {
+		return err
+	}
+
+	id := resource.GetID()
+	association, err := c.describeAssociation(ctx, id)
+	if err != nil {
+		return err
+	}
+	for attr, values := range map[string][2]string{
+		"route_table_id": {spec.routeTableID, aws.ToString(association.RouteTableId)},
+		"subnet_id":      {spec.subnetID, aws.ToString(association.SubnetId)},
+	} {
+		if values[0] != values[1] {
+			return fmt.Errorf("changing %s of route table association %s requires replacing it", attr, id)
+		}
+	}
+	return nil
+}
+
+// DeleteRouteTableAssociation disassociates the subnet, which falls back to
+// the VPC's main route table
+func (c *EC2Client) DeleteRouteTableAssociation(ctx context.Context, resource provider.Resource) error {
+	_, err := c.client.DisassociateRouteTable(ctx, &ec2.DisassociateRouteTableInput{
+		AssociationId: aws.String(resource.GetID()),
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete route table association %s: %w", resource.GetID(), err)
+	}
+	return nil
+}
+
+func (c *EC2Client) describeAssociation(ctx context.Context, id string) (*ec2types.RouteTableAssociation, error) {
+	result, err := c.client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+		Filters: []ec2types.Filter{{Name: aws.String("association.route-table-association-id"), Values: []string{id}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe route table association %s: %w", id, err)
+	}
+	for _, table := range result.RouteTables {
+		for _, a := range table.Associations {
+			if aws.ToString(a.RouteTableAssociationId) == id {
+				return &a, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("route table association %s: %w", id, provider.ErrNotFound)
+}
+
+func associationResource(association ec2types.RouteTableAssociation) *types.BaseResource {
+	return &types.BaseResource{
+		ID:       aws.ToString(association.RouteTableAssociationId),
+		Type:     types.ResourceTypeRouteTableAssociation,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"association_id": aws.ToString(association.RouteTableAssociationId),
+			"route_table_id": aws.ToString(association.RouteTableId),
+			"subnet_id":      aws.ToString(association.SubnetId),
+		},
+		UpdatedAt: time.Now(),
+	}
+}
+
+// optionalString returns nil for an empty string, for optional API fields
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
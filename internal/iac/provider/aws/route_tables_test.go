@@ -0,0 +1,161 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+func TestRouteTables(t *testing.T) {
+	p, ec2Server := newTestProvider(t)
+	ctx := context.Background()
+
+	create := func(t *testing.T, resourceType string, config map[string]interface{}) provider.Resource {
+		t.Helper()
+		res, err := p.Create(ctx, resourceType, config)
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", resourceType, err)
+		}
+		return res
+	}
+	vpc := create(t, "vpc", map[string]interface{}{"cidr_block": "10.0.0.0/16"})
+	subnet := create(t, "subnet", map[string]interface{}{"vpc_id": vpc.GetID(), "cidr_block": "10.0.1.0/24"})
+	gateway := create(t, "internet_gateway", map[string]interface{}{"vpc_id": vpc.GetID()})
+	eip := create(t, "eip", map[string]interface{}{})
+	nat := create(t, "nat_gateway", map[string]interface{}{"subnet_id": subnet.GetID(), "allocation_id": eip.GetID()})
+
+	var table provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		table, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-rt"), "route_table", map[string]interface{}{
+			"vpc_id": vpc.GetID(),
+			"routes": []interface{}{
+				map[string]interface{}{"cidr_block": "0.0.0.0/0", "gateway_id": gateway.GetID()},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create route table: %v", err)
+		}
+
+		got, _ := ec2Server.RouteTable(table.GetID())
+		if len(got.Routes) != 2 || got.Routes[1].DestinationCidrBlock != "0.0.0.0/0" || got.Routes[1].GatewayID != gateway.GetID() {
+			t.Errorf("Expected local route and default route, got %+v", got.Routes)
+		}
+		routes, _ := table.GetMetadata()["routes"].([]interface{})
+		if len(routes) != 1 {
+			t.Errorf("Expected only the managed route in metadata, got %v", table.GetMetadata()["routes"])
+		}
+
+		res, err := p.FindByToken(ctx, "route_table", "run-1-rt")
+		if err != nil || res.GetID() != table.GetID() {
+			t.Errorf("Expected to find %s by token, got %v, %v", table.GetID(), res, err)
+		}
+	})
+
+	t.Run("UpdateRoutes", func(t *testing.T) {
+		err := p.Update(ctx, table, map[string]interface{}{
+			"vpc_id": vpc.GetID(),
+			"routes": []interface{}{
+				map[string]interface{}{"cidr_block": "0.0.0.0/0", "nat_gateway_id": nat.GetID()},
+				map[string]interface{}{"cidr_block": "192.168.0.0/16", "gateway_id": gateway.GetID()},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to update route table: %v", err)
+		}
+		got, _ := ec2Server.RouteTable(table.GetID())
+		if len(got.Routes) != 3 {
+			t.Fatalf("Expected three routes, got %+v", got.Routes)
+		}
+		if got.Routes[1].NatGatewayID != nat.GetID() || got.Routes[1].GatewayID != "" {
+			t.Errorf("Expected default route to be replaced with the NAT gateway, got %+v", got.Routes[1])
+		}
+		if ec2Server.Calls("ReplaceRoute") != 1 || ec2Server.Calls("CreateRoute") != 2 {
+			t.Errorf("Expected one replace and one more create, got %d and %d", ec2Server.Calls("ReplaceRoute"), ec2Server.Calls("CreateRoute"))
+		}
+
+		err = p.Update(ctx, table, map[string]interface{}{
+			"vpc_id": vpc.GetID(),
+			"routes": []interface{}{
+				map[string]interface{}{"cidr_block": "0.0.0.0/0", "nat_gateway_id": nat.GetID()},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to update route table: %v", err)
+		}
+		got, _ = ec2Server.RouteTable(table.GetID())
+		if len(got.Routes) != 2 || got.Routes[0].GatewayID != "local" {
+			t.Errorf("Expected the extra route to be removed and the local route kept, got %+v", got.Routes)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		for name, routes := range map[string][]interface{}{
+			"NoTarget":       {map[string]interface{}{"cidr_block": "0.0.0.0/0"}},
+			"TwoTargets":     {map[string]interface{}{"cidr_block": "0.0.0.0/0", "gateway_id": "igw-1", "nat_gateway_id": "nat-1"}},
+			"DuplicateCidrs": {map[string]interface{}{"cidr_block": "0.0.0.0/0", "gateway_id": "igw-1"}, map[string]interface{}{"cidr_block": "0.0.0.0/0", "gateway_id": "igw-2"}},
+		} {
+			if _, err := p.Create(ctx, "route_table", map[string]interface{}{"vpc_id": vpc.GetID(), "routes": routes}); err == nil {
+				t.Errorf("%s: expected error, got nil", name)
+			}
+		}
+
+		err := p.Update(ctx, table, map[string]interface{}{"vpc_id": "vpc-other"})
+		if err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected replacement error, got %v", err)
+		}
+	})
+
+	var association provider.Resource
+	t.Run("Associate", func(t *testing.T) {
+		config := map[string]interface{}{"route_table_id": table.GetID(), "subnet_id": subnet.GetID()}
+		var err error
+		association, err = p.Create(ctx, "route_table_association", config)
+		if err != nil {
+			t.Fatalf("Failed to associate route table: %v", err)
+		}
+		got, _ := ec2Server.RouteTable(table.GetID())
+		if len(got.Associations) != 1 || got.Associations[0].SubnetID != subnet.GetID() || got.Associations[0].ID != association.GetID() {
+			t.Errorf("Expected association with %s, got %+v", subnet.GetID(), got.Associations)
+		}
+
+		// Creating it again adopts the existing association
+		again, err := p.Create(ctx, "route_table_association", config)
+		if err != nil {
+			t.Fatalf("Failed to retry association: %v", err)
+		}
+		if again.GetID() != association.GetID() {
+			t.Errorf("Expected retried association to return %s, got %s", association.GetID(), again.GetID())
+		}
+
+		if _, err := p.FindByToken(ctx, "route_table_association", "anything"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		err = p.Update(ctx, association, map[string]interface{}{"route_table_id": table.GetID(), "subnet_id": "subnet-other"})
+		if err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected replacement error, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, association); err != nil {
+			t.Fatalf("Failed to delete association: %v", err)
+		}
+		if _, err := p.Read(ctx, "route_table_association", association.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected association to be gone, got %v", err)
+		}
+		if err := p.Delete(ctx, association); err != nil {
+			t.Errorf("Expected deleting a deleted association to succeed, got %v", err)
+		}
+
+		if err := p.Delete(ctx, table); err != nil {
+			t.Fatalf("Failed to delete route table: %v", err)
+		}
+		if _, ok := ec2Server.RouteTable(table.GetID()); ok {
+			t.Errorf("Expected route table %s to be gone", table.GetID())
+		}
+	})
+}
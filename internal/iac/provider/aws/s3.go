@@ -0,0 +1,753 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// forceDestroyTag records force_destroy on the bucket itself, since Delete
+// is given only the resource and not its config
+const forceDestroyTag = "duet:force_destroy"
+
+type S3Client struct {
+	client *s3.Client
+	region string
+}
+
+// NewS3Client returns an S3 client. Buckets are addressed by path when an
+// endpoint override is set, as local stand-ins have no per-bucket hostnames.
+func NewS3Client(cfg aws.Config) (*S3Client, error) {
+	return &S3Client{
+		client: s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = cfg.BaseEndpoint != nil
+		}),
+		region: cfg.Region,
+	}, nil
+}
+
+// bucketEncryption is a bucket's default encryption
+type bucketEncryption struct {
+	algorithm        string
+	kmsKeyID         string
+	bucketKeyEnabled bool
+}
+
+// lifecycleTransition moves objects to another storage class
+type lifecycleTransition struct {
+	storageClass string
+	days         int
+}
+
+// lifecycleRule is one bucket lifecycle rule. Day counts are zero when
+// unset.
+type lifecycleRule struct {
+	id                  string
+	prefix              string
+	transitions         []lifecycleTransition
+	expirationDays      int
+	noncurrentDays      int
+	abortIncompleteDays int
+	enabled             bool
+}
+
+// publicAccessBlock is a bucket's public access block
+type publicAccessBlock struct {
+	blockPublicAcls       bool
+	ignorePublicAcls      bool
+	blockPublicPolicy     bool
+	restrictPublicBuckets bool
+}
+
+// bucketConfig is the configuration of a bucket, either desired or as read
+// back from S3. Nil or empty fields mean unset.
+type bucketConfig struct {
+	encryption        *bucketEncryption
+	publicAccessBlock *publicAccessBlock
+	tags              map[string]string
+	versioning        string
+	policy            string
+	region            string
+	lifecycleRules    []lifecycleRule
+}
+
+// bucketSpec is the desired configuration of a bucket. Each setting is only
+// managed if it appears in the config, so settings made outside duet are
+// left alone.
+type bucketSpec struct {
+	bucketConfig
+	bucket                  string
+	manageVersioning        bool
+	manageEncryption        bool
+	manageLifecycle         bool
+	managePublicAccessBlock bool
+	managePolicy            bool
+	forceDestroy            bool
+}
+
+func parseBucketSpec(config map[string]interface{}) (*bucketSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("bucket")
+
+	spec := &bucketSpec{
+		bucket:                  attrs.String("bucket"),
+		forceDestroy:            attrs.Bool("force_destroy"),
+		manageVersioning:        attrs.Has("versioning"),
+		manageEncryption:        attrs.Has("encryption"),
+		manageLifecycle:         attrs.Has("lifecycle_rules"),
+		managePublicAccessBlock: attrs.Has("public_access_block"),
+		managePolicy:            attrs.Has("policy"),
+	}
+	spec.tags = attrs.StringMap("tags")
+
+	if spec.manageVersioning {
+		spec.versioning = string(s3types.BucketVersioningStatusSuspended)
+		if attrs.Bool("versioning") {
+			spec.versioning = string(s3types.BucketVersioningStatusEnabled)
+		}
+	}
+
+	if table := attrs.Table("encryption"); len(table.values) > 0 {
+		spec.encryption = &bucketEncryption{
+			algorithm:        table.String("algorithm"),
+			kmsKeyID:         table.String("kms_key_id"),
+			bucketKeyEnabled: table.Bool("bucket_key_enabled"),
+		}
+		if spec.encryption.algorithm == "" {
+			spec.encryption.algorithm = string(s3types.ServerSideEncryptionAes256)
+		}
+		switch {
+		case table.Err() != nil:
+			attrs.collectTable("encryption", table)
+		case spec.encryption.algorithm != string(s3types.ServerSideEncryptionAes256) && spec.encryption.algorithm != string(s3types.ServerSideEncryptionAwsKms):
+			return nil, fmt.Errorf("encryption: unsupported algorithm %q, expected AES256 or aws:kms", spec.encryption.algorithm)
+		case spec.encryption.kmsKeyID != "" && spec.encryption.algorithm != string(s3types.ServerSideEncryptionAwsKms):
+			return nil, fmt.Errorf("encryption: kms_key_id requires algorithm aws:kms")
+		}
+	}
+
+	rules := attrs.List("lifecycle_rules")
+	for i, r := range rules {
+		rule := lifecycleRule{
+			id:                  r.String("id"),
+			prefix:              r.String("prefix"),
+			expirationDays:      r.Int("expiration_days"),
+			noncurrentDays:      r.Int("noncurrent_version_expiration_days"),
+			abortIncompleteDays: r.Int("abort_incomplete_multipart_upload_days"),
+			enabled:             true,
+		}
+		if r.Has("enabled") {
+			rule.enabled = r.Bool("enabled")
+		}
+		transitions := r.List("transitions")
+		for _, t := range transitions {
+			t.Require("days", "storage_class")
+			rule.transitions = append(rule.transitions, lifecycleTransition{
+				days:         t.Int("days"),
+				storageClass: t.String("storage_class"),
+			})
+		}
+		r.collect("transitions", transitions...)
+		if rule.id == "" && r.Err() == nil {
+			return nil, fmt.Errorf("lifecycle_rules[%d]: attribute id is required", i+1)
+		}
+		if rule.expirationDays == 0 && rule.noncurrentDays == 0 && rule.abortIncompleteDays == 0 && len(rule.transitions) == 0 && r.Err() == nil {
+			return nil, fmt.Errorf("lifecycle_rules[%d]: rule %s has no action", i+1, rule.id)
+		}
+		spec.lifecycleRules = append(spec.lifecycleRules, rule)
+	}
+	attrs.collect("lifecycle_rules", rules...)
+
+	if table := attrs.Table("public_access_block"); len(table.values) > 0 {
+		spec.publicAccessBlock = &publicAccessBlock{
+			blockPublicAcls:       table.Bool("block_public_acls"),
+			ignorePublicAcls:      table.Bool("ignore_public_acls"),
+			blockPublicPolicy:     table.Bool("block_public_policy"),
+			restrictPublicBuckets: table.Bool("restrict_public_buckets"),
+		}
+		attrs.collectTable("public_access_block", table)
+	}
+
+	spec.policy = attrs.Policy("policy")
+
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// CreateBucket creates an S3 bucket in the provider's region and applies
+// its configuration
+func (c *S3Client) CreateBucket(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseBucketSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &s3.CreateBucketInput{Bucket: aws.String(spec.bucket)}
+	if c.region != "" && c.region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3types.CreateBucketConfiguration{
+			LocationConstraint: s3types.BucketLocationConstraint(c.region),
+		}
+	}
+	current := &bucketConfig{region: c.region}
+	if _, err := c.client.CreateBucket(ctx, input); err != nil {
+		if errorCode(err) != "BucketAlreadyOwnedByYou" {
+			return nil, fmt.Errorf("failed to create bucket %s: %w", spec.bucket, err)
+		}
+		// A bucket we own already has this name. It is only taken over if
+		// an interrupted run with the same token created it.
+		current, err = c.readBucketConfig(ctx, spec.bucket)
+		if err != nil {
+			return nil, err
+		}
+		token := provider.IdempotencyToken(ctx)
+		if token == "" || current.tags[tokenTag] != token {
+			return nil, fmt.Errorf("bucket %s already exists and is not managed by this run", spec.bucket)
+		}
+	}
+
+	// The bucket exists from here on, so it is returned even if a later
+	// step fails, letting the caller track it. The token is tagged first so
+	// a retried run can find the bucket again.
+	if token := provider.IdempotencyToken(ctx); token != "" && current.tags[tokenTag] == "" {
+		if err := c.putBucketTags(ctx, spec.bucket, map[string]string{tokenTag: token}); err != nil {
+			return bucketResource(spec.bucket, current), err
+		}
+		current.tags = map[string]string{tokenTag: token}
+	}
+	if err := c.applyBucketConfig(ctx, spec, current); err != nil {
+		return bucketResource(spec.bucket, current), err
+	}
+	return c.ReadBucket(ctx, spec.bucket)
+}
+
+// ReadBucket returns the current state of a bucket
+func (c *S3Client) ReadBucket(ctx context.Context, name string) (*types.BaseResource, error) {
+	current, err := c.readBucketConfig(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return bucketResource(name, current), nil
+}
+
+// FindBucketByToken returns the bucket created with the given idempotency
+// token. S3 cannot filter buckets by tag, so each bucket's tags are read in
+// turn.
+func (c *S3Client) FindBucketByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	result, err := c.client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+	for _, b := range result.Buckets {
+		name := aws.ToString(b.Name)
+		tags, err := c.bucketTags(ctx, name)
+		if err != nil {
+			continue
+		}
+		if tags[tokenTag] == token {
+			return c.ReadBucket(ctx, name)
+		}
+	}
+	return nil, provider.ErrNotFound
+}
+
+// UpdateBucket brings each managed setting of a bucket in line with config.
+// The bucket name is fixed at creation.
+func (c *S3Client) UpdateBucket(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseBucketSpec(config)
+	if err != nil {
+		return err
+	}
+
+	name := resource.GetID()
+	if spec.bucket != name {
+		return fmt.Errorf("changing bucket of bucket %s requires replacing it", name)
+	}
+	current, err := c.readBucketConfig(ctx, name)
+	if err != nil {
+		return err
+	}
+	return c.applyBucketConfig(ctx, spec, current)
+}
+
+// DeleteBucket deletes a bucket. A bucket that still holds objects is only
+// emptied and deleted if it was created with force_destroy.
+func (c *S3Client) DeleteBucket(ctx context.Context, resource provider.Resource) error {
+	name := resource.GetID()
+	_, err := c.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(name)})
+	if err == nil || isS3NotFound(err) {
+		return nil
+	}
+	if errorCode(err) != "BucketNotEmpty" {
+		return fmt.Errorf("failed to delete bucket %s: %w", name, err)
+	}
+
+	tags, err := c.bucketTags(ctx, name)
+	if err != nil {
+		return err
+	}
+	if tags[forceDestroyTag] != "true" {
+		return fmt.Errorf("bucket %s is not empty; empty it or set force_destroy = true and apply before deleting it", name)
+	}
+	if err := c.emptyBucket(ctx, name); err != nil {
+		return err
+	}
+	if _, err := c.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(name)}); err != nil && !isS3NotFound(err) {
+		return fmt.Errorf("failed to delete bucket %s: %w", name, err)
+	}
+	return nil
+}
+
+// emptyBucket deletes every object version and delete marker in a bucket
+func (c *S3Client) emptyBucket(ctx context.Context, name string) error {
+	for {
+		result, err := c.client.ListObjectVersions(ctx, &s3.ListObjectVersionsInput{Bucket: aws.String(name)})
+		if err != nil {
+			return fmt.Errorf("failed to list objects in bucket %s: %w", name, err)
+		}
+		var objects []s3types.ObjectIdentifier
+		for _, v := range result.Versions {
+			objects = append(objects, s3types.ObjectIdentifier{Key: v.Key, VersionId: v.VersionId})
+		}
+		for _, m := range result.DeleteMarkers {
+			objects = append(objects, s3types.ObjectIdentifier{Key: m.Key, VersionId: m.VersionId})
+		}
+		if len(objects) == 0 {
+			return nil
+		}
+
+		deleted, err := c.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(name),
+			Delete: &s3types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to empty bucket %s: %w", name, err)
+		}
+		if len(deleted.Errors) > 0 {
+			e := deleted.Errors[0]
+			return fmt.Errorf("failed to delete %s from bucket %s: %s", aws.ToString(e.Key), name, aws.ToString(e.Message))
+		}
+	}
+}
+
+// applyBucketConfig makes the managed settings of spec match the bucket's
+// current configuration, calling S3 only for settings that differ. current
+// is updated as each setting is applied.
+func (c *S3Client) applyBucketConfig(ctx context.Context, spec *bucketSpec, current *bucketConfig) error {
+	name := spec.bucket
+
+	tags := spec.tags
+	if tags == nil {
+		tags = userTags(current.tags)
+	}
+	if spec.forceDestroy {
+		tags = mergeTags(map[string]string{forceDestroyTag: "true"}, tags)
+	}
+	desiredTags := mergeTags(reservedTags(current.tags), tags)
+	if !reflect.DeepEqual(desiredTags, mergeTags(current.tags, nil)) {
+		if err := c.putBucketTags(ctx, name, desiredTags); err != nil {
+			return err
+		}
+		current.tags = desiredTags
+	}
+
+	// Versioning can be suspended but never turned off, so a bucket that
+	// never had it needs no call to keep it off
+	if spec.manageVersioning && spec.versioning != current.versioning &&
+		!(current.versioning == "" && spec.versioning == string(s3types.BucketVersioningStatusSuspended)) {
+		_, err := c.client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{
+			Bucket:                  aws.String(name),
+			VersioningConfiguration: &s3types.VersioningConfiguration{Status: s3types.BucketVersioningStatus(spec.versioning)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set versioning of bucket %s: %w", name, err)
+		}
+		current.versioning = spec.versioning
+	}
+
+	if spec.manageEncryption && !sameEncryption(spec.encryption, current.encryption) {
+		var err error
+		if spec.encryption == nil {
+			_, err = c.client.DeleteBucketEncryption(ctx, &s3.DeleteBucketEncryptionInput{Bucket: aws.String(name)})
+		} else {
+			_, err = c.client.PutBucketEncryption(ctx, &s3.PutBucketEncryptionInput{
+				Bucket: aws.String(name),
+				ServerSideEncryptionConfiguration: &s3types.ServerSideEncryptionConfiguration{
+					Rules: []s3types.ServerSideEncryptionRule{{
+						ApplyServerSideEncryptionByDefault: &s3types.ServerSideEncryptionByDefault{
+							SSEAlgorithm:   s3types.ServerSideEncryption(spec.encryption.algorithm),
+							KMSMasterKeyID: optionalString(spec.encryption.kmsKeyID),
+						},
+						BucketKeyEnabled: aws.Bool(spec.encryption.bucketKeyEnabled),
+					}},
+				},
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set encryption of bucket %s: %w", name, err)
+		}
+		current.encryption = spec.encryption
+	}
+
+	if spec.manageLifecycle && !reflect.DeepEqual(spec.lifecycleRules, current.lifecycleRules) {
+		var err error
+		if len(spec.lifecycleRules) == 0 {
+			_, err = c.client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{Bucket: aws.String(name)})
+		} else {
+			_, err = c.client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+				Bucket:                 aws.String(name),
+				LifecycleConfiguration: &s3types.BucketLifecycleConfiguration{Rules: toS3LifecycleRules(spec.lifecycleRules)},
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set lifecycle rules of bucket %s: %w", name, err)
+		}
+		current.lifecycleRules = spec.lifecycleRules
+	}
+
+	if spec.managePublicAccessBlock && !reflect.DeepEqual(spec.publicAccessBlock, current.publicAccessBlock) {
+		var err error
+		if spec.publicAccessBlock == nil {
+			_, err = c.client.DeletePublicAccessBlock(ctx, &s3.DeletePublicAccessBlockInput{Bucket: aws.String(name)})
+		} else {
+			b := spec.publicAccessBlock
+			_, err = c.client.PutPublicAccessBlock(ctx, &s3.PutPublicAccessBlockInput{
+				Bucket: aws.String(name),
+				PublicAccessBlockConfiguration: &s3types.PublicAccessBlockConfiguration{
+					BlockPublicAcls:       aws.Bool(b.blockPublicAcls),
+					IgnorePublicAcls:      aws.Bool(b.ignorePublicAcls),
+					BlockPublicPolicy:     aws.Bool(b.blockPublicPolicy),
+					RestrictPublicBuckets: aws.Bool(b.restrictPublicBuckets),
+				},
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set public access block of bucket %s: %w", name, err)
+		}
+		current.publicAccessBlock = spec.publicAccessBlock
+	}
+
+	if spec.managePolicy && spec.policy != current.policy {
+		var err error
+		if spec.policy == "" {
+			_, err = c.client.DeleteBucketPolicy(ctx, &s3.DeleteBucketPolicyInput{Bucket: aws.String(name)})
+		} else {
+			_, err = c.client.PutBucketPolicy(ctx, &s3.PutBucketPolicyInput{
+				Bucket: aws.String(name),
+				Policy: aws.String(spec.policy),
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set policy of bucket %s: %w", name, err)
+		}
+		current.policy = spec.policy
+	}
+	return nil
+}
+
+// readBucketConfig reads every setting duet manages on a bucket. Settings
+// that were never made read back as unset.
+func (c *S3Client) readBucketConfig(ctx context.Context, name string) (*bucketConfig, error) {
+	_, err := c.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(name)})
+	if isS3NotFound(err) {
+		return nil, fmt.Errorf("bucket %s: %w", name, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bucket %s: %w", name, err)
+	}
+	current := &bucketConfig{}
+
+	location, err := c.client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(name)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read location of bucket %s: %w", name, err)
+	}
+	current.region = string(location.LocationConstraint)
+	if current.region == "" {
+		current.region = "us-east-1"
+	}
+
+	if current.tags, err = c.bucketTags(ctx, name); err != nil {
+		return nil, err
+	}
+
+	versioning, err := c.client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(name)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read versioning of bucket %s: %w", name, err)
+	}
+	current.versioning = string(versioning.Status)
+
+	encryption, err := c.client.GetBucketEncryption(ctx, &s3.GetBucketEncryptionInput{Bucket: aws.String(name)})
+	switch {
+	case isS3NotFound(err):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read encryption of bucket %s: %w", name, err)
+	case encryption.ServerSideEncryptionConfiguration != nil && len(encryption.ServerSideEncryptionConfiguration.Rules) > 0:
+		rule := encryption.ServerSideEncryptionConfiguration.Rules[0]
+		current.encryption = &bucketEncryption{bucketKeyEnabled: aws.ToBool(rule.BucketKeyEnabled)}
+		if d := rule.ApplyServerSideEncryptionByDefault; d != nil {
+			current.encryption.algorithm = string(d.SSEAlgorithm)
+			current.encryption.kmsKeyID = aws.ToString(d.KMSMasterKeyID)
+		}
+	}
+
+	lifecycle, err := c.client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(name)})
+	switch {
+	case isS3NotFound(err):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read lifecycle rules of bucket %s: %w", name, err)
+	default:
+		current.lifecycleRules = fromS3LifecycleRules(lifecycle.Rules)
+	}
+
+	block, err := c.client.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{Bucket: aws.String(name)})
+	switch {
+	case isS3NotFound(err):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read public access block of bucket %s: %w", name, err)
+	case block.PublicAccessBlockConfiguration != nil:
+		b := block.PublicAccessBlockConfiguration
+		current.publicAccessBlock = &publicAccessBlock{
+			blockPublicAcls:       aws.ToBool(b.BlockPublicAcls),
+			ignorePublicAcls:      aws.ToBool(b.IgnorePublicAcls),
+			blockPublicPolicy:     aws.ToBool(b.BlockPublicPolicy),
+			restrictPublicBuckets: aws.ToBool(b.RestrictPublicBuckets),
+		}
+	}
+
+	policy, err := c.client.GetBucketPolicy(ctx, &s3.GetBucketPolicyInput{Bucket: aws.String(name)})
+	switch {
+	case isS3NotFound(err):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read policy of bucket %s: %w", name, err)
+	default:
+		if current.policy, err = normalizePolicy(aws.ToString(policy.Policy)); err != nil {
+			return nil, fmt.Errorf("failed to read policy of bucket %s: %w", name, err)
+		}
+	}
+	return current, nil
+}
+
+// bucketTags returns the tags of a bucket, which S3 reports as an error when
+// there are none
+func (c *S3Client) bucketTags(ctx context.Context, name string) (map[string]string, error) {
+	result, err := c.client.GetBucketTagging(ctx, &s3.GetBucketTaggingInput{Bucket: aws.String(name)})
+	if isS3NotFound(err) && errorCode(err) != "NoSuchBucket" {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tags of bucket %s: %w", name, err)
+	}
+	tags := make(map[string]string, len(result.TagSet))
+	for _, t := range result.TagSet {
+		tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return tags, nil
+}
+
+// putBucketTags replaces the tags of a bucket. Tags in the aws: namespace
+// cannot be written and are skipped.
+func (c *S3Client) putBucketTags(ctx context.Context, name string, tags map[string]string) error {
+	var tagSet []s3types.Tag
+	for _, k := range sortedKeys(tags) {
+		if strings.HasPrefix(k, "aws:") {
+			continue
+		}
+		tagSet = append(tagSet, s3types.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+
+	var err error
+	if len(tagSet) == 0 {
+		_, err = c.client.DeleteBucketTagging(ctx, &s3.DeleteBucketTaggingInput{Bucket: aws.String(name)})
+	} else {
+		_, err = c.client.PutBucketTagging(ctx, &s3.PutBucketTaggingInput{
+			Bucket:  aws.String(name),
+			Tagging: &s3types.Tagging{TagSet: tagSet},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to tag bucket %s: %w", name, err)
+	}
+	return nil
+}
+
+// userTags returns the tags of m that users manage
+func userTags(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if !isReservedTag(k) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// reservedTags returns the tags of m that duet or AWS manage, except
+// force_destroy, which is set from the config on every apply
+func reservedTags(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if isReservedTag(k) && k != forceDestroyTag {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// mergeTags returns a new map holding the tags of a overlaid with b
+func mergeTags(a, b map[string]string) map[string]string {
+	out := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		out[k] = v
+	}
+	return out
+}
+
+// sameEncryption reports whether two encryption settings are equivalent.
+// S3 encrypts every bucket with AES256 when nothing else is set, so that
+// default is the same as no setting.
+func sameEncryption(a, b *bucketEncryption) bool {
+	defaultEncryption := &bucketEncryption{algorithm: string(s3types.ServerSideEncryptionAes256)}
+	if a == nil {
+		a = defaultEncryption
+	}
+	if b == nil {
+		b = defaultEncryption
+	}
+	return *a == *b
+}
+
+func toS3LifecycleRules(rules []lifecycleRule) []s3types.LifecycleRule {
+	out := make([]s3types.LifecycleRule, 0, len(rules))
+	for _, r := range rules {
+		rule := s3types.LifecycleRule{
+			ID:     aws.String(r.id),
+			Status: s3types.ExpirationStatusDisabled,
+			Filter: &s3types.LifecycleRuleFilter{Prefix: aws.String(r.prefix)},
+		}
+		if r.enabled {
+			rule.Status = s3types.ExpirationStatusEnabled
+		}
+		if r.expirationDays > 0 {
+			rule.Expiration = &s3types.LifecycleExpiration{Days: aws.Int32(int32(r.expirationDays))}
+		}
+		if r.noncurrentDays > 0 {
+			rule.NoncurrentVersionExpiration = &s3types.NoncurrentVersionExpiration{NoncurrentDays: aws.Int32(int32(r.noncurrentDays))}
+		}
+		if r.abortIncompleteDays > 0 {
+			rule.AbortIncompleteMultipartUpload = &s3types.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int32(int32(r.abortIncompleteDays))}
+		}
+		for _, t := range r.transitions {
+			rule.Transitions = append(rule.Transitions, s3types.Transition{
+				Days:         aws.Int32(int32(t.days)),
+				StorageClass: s3types.TransitionStorageClass(t.storageClass),
+			})
+		}
+		out = append(out, rule)
+	}
+	return out
+}
+
+func fromS3LifecycleRules(rules []s3types.LifecycleRule) []lifecycleRule {
+	var out []lifecycleRule
+	for _, r := range rules {
+		rule := lifecycleRule{
+			id:      aws.ToString(r.ID),
+			enabled: r.Status == s3types.ExpirationStatusEnabled,
+		}
+		if r.Filter != nil {
+			rule.prefix = aws.ToString(r.Filter.Prefix)
+		}
+		if r.Expiration != nil {
+			rule.expirationDays = int(aws.ToInt32(r.Expiration.Days))
+		}
+		if r.NoncurrentVersionExpiration != nil {
+			rule.noncurrentDays = int(aws.ToInt32(r.NoncurrentVersionExpiration.NoncurrentDays))
+		}
+		if r.AbortIncompleteMultipartUpload != nil {
+			rule.abortIncompleteDays = int(aws.ToInt32(r.AbortIncompleteMultipartUpload.DaysAfterInitiation))
+		}
+		for _, t := range r.Transitions {
+			rule.transitions = append(rule.transitions, lifecycleTransition{
+				days:         int(aws.ToInt32(t.Days)),
+				storageClass: string(t.StorageClass),
+			})
+		}
+		out = append(out, rule)
+	}
+	return out
+}
+
+func bucketResource(name string, current *bucketConfig) *types.BaseResource {
+	metadata := map[string]interface{}{
+		"bucket":             name,
+		"arn":                "arn:aws:s3:::" + name,
+		"region":             current.region,
+		"bucket_domain_name": name + ".s3.amazonaws.com",
+		"versioning":         current.versioning == string(s3types.BucketVersioningStatusEnabled),
+		"force_destroy":      current.tags[forceDestroyTag] == "true",
+		"policy":             current.policy,
+	}
+	if e := current.encryption; e != nil {
+		metadata["encryption"] = map[string]interface{}{
+			"algorithm":          e.algorithm,
+			"kms_key_id":         e.kmsKeyID,
+			"bucket_key_enabled": e.bucketKeyEnabled,
+		}
+	}
+	if b := current.publicAccessBlock; b != nil {
+		metadata["public_access_block"] = map[string]interface{}{
+			"block_public_acls":       b.blockPublicAcls,
+			"ignore_public_acls":      b.ignorePublicAcls,
+			"block_public_policy":     b.blockPublicPolicy,
+			"restrict_public_buckets": b.restrictPublicBuckets,
+		}
+	}
+	if len(current.lifecycleRules) > 0 {
+		rules := make([]interface{}, 0, len(current.lifecycleRules))
+		for _, r := range current.lifecycleRules {
+			transitions := make([]interface{}, 0, len(r.transitions))
+			for _, t := range r.transitions {
+				transitions = append(transitions, map[string]interface{}{"days": t.days, "storage_class": t.storageClass})
+			}
+			rules = append(rules, map[string]interface{}{
+				"id":                                     r.id,
+				"enabled":                                r.enabled,
+				"prefix":                                 r.prefix,
+				"expiration_days":                        r.expirationDays,
+				"noncurrent_version_expiration_days":     r.noncurrentDays,
+				"abort_incomplete_multipart_upload_days": r.abortIncompleteDays,
+				"transitions":                            transitions,
+			})
+		}
+		metadata["lifecycle_rules"] = rules
+	}
+	return &types.BaseResource{
+		ID:        name,
+		Type:      types.ResourceTypeStorage,
+		Provider:  "aws",
+		Status:    types.StatusRunning,
+		Metadata:  metadata,
+		Tags:      current.tags,
+		UpdatedAt: time.Now(),
+	}
+}
+
+// isS3NotFound reports whether err means a bucket, or a setting on one, does
+// not exist
+func isS3NotFound(err error) bool {
+	code := errorCode(err)
+	return strings.HasPrefix(code, "NoSuch") || strings.HasSuffix(code, "NotFound") || strings.HasSuffix(code, "NotFoundError")
+}
@@ -0,0 +1,262 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/internal/iac/provider/aws/awstest"
+)
+
+func newS3TestProvider(t *testing.T, region string) (*AWSProvider, *awstest.S3) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+
+	s3Server, server := awstest.NewS3Server()
+	t.Cleanup(server.Close)
+
+	p, err := NewAWSProvider(context.Background(), region, WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	return p, s3Server
+}
+
+func TestBuckets(t *testing.T) {
+	p, s3Server := newS3TestProvider(t, "us-east-1")
+	ctx := context.Background()
+
+	config := map[string]interface{}{
+		"bucket":     "duet-assets",
+		"versioning": true,
+		"encryption": map[string]interface{}{"algorithm": "aws:kms", "kms_key_id": "key-1", "bucket_key_enabled": true},
+		"lifecycle_rules": []interface{}{
+			map[string]interface{}{
+				"id":              "logs",
+				"prefix":          "logs/",
+				"expiration_days": 90,
+				"transitions":     []interface{}{map[string]interface{}{"days": 30, "storage_class": "STANDARD_IA"}},
+			},
+		},
+		"public_access_block": map[string]interface{}{
+			"block_public_acls":       true,
+			"ignore_public_acls":      true,
+			"block_public_policy":     true,
+			"restrict_public_buckets": true,
+		},
+		"policy": `{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::duet-assets/*", "Condition": {"Bool": {"aws:SecureTransport": "false"}}}]}`,
+		"tags":   map[string]interface{}{"team": "web"},
+	}
+
+	var bucket provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		bucket, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-assets"), "storage", config)
+		if err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+		if bucket.GetID() != "duet-assets" {
+			t.Errorf("Expected bucket ID duet-assets, got %s", bucket.GetID())
+		}
+
+		got, ok := s3Server.Bucket("duet-assets")
+		if !ok {
+			t.Fatal("Expected bucket duet-assets to exist")
+		}
+		if got.Versioning != "Enabled" {
+			t.Errorf("Expected versioning to be enabled, got %q", got.Versioning)
+		}
+		if got.Encryption == nil || got.Encryption.Rules[0].SSEAlgorithm != "aws:kms" || got.Encryption.Rules[0].KMSMasterKeyID != "key-1" {
+			t.Errorf("Unexpected encryption: %+v", got.Encryption)
+		}
+		if got.Lifecycle == nil || len(got.Lifecycle.Rules) != 1 || got.Lifecycle.Rules[0].ExpirationDays != 90 {
+			t.Errorf("Unexpected lifecycle: %+v", got.Lifecycle)
+		}
+		if got.PublicAccessBlock == nil || !got.PublicAccessBlock.RestrictPublicBuckets {
+			t.Errorf("Unexpected public access block: %+v", got.PublicAccessBlock)
+		}
+		if !strings.Contains(got.Policy, "aws:SecureTransport") {
+			t.Errorf("Expected policy to be set, got %q", got.Policy)
+		}
+		if got.Tags["team"] != "web" || got.Tags[tokenTag] != "run-1-assets" {
+			t.Errorf("Unexpected tags: %v", got.Tags)
+		}
+
+		meta := bucket.GetMetadata()
+		if meta["arn"] != "arn:aws:s3:::duet-assets" || meta["region"] != "us-east-1" || meta["versioning"] != true {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+		if rules, _ := meta["lifecycle_rules"].([]interface{}); len(rules) != 1 {
+			t.Errorf("Expected one lifecycle rule in metadata, got %v", meta["lifecycle_rules"])
+		}
+
+		again, err := p.Create(provider.WithIdempotencyToken(ctx, "run-1-assets"), "storage", config)
+		if err != nil {
+			t.Fatalf("Failed to retry create: %v", err)
+		}
+		if again.GetID() != bucket.GetID() {
+			t.Errorf("Expected retried create to return %s, got %s", bucket.GetID(), again.GetID())
+		}
+		if _, err := p.Create(provider.WithIdempotencyToken(ctx, "run-2-assets"), "storage", config); err == nil {
+			t.Error("Expected error creating a bucket that another run created, got nil")
+		}
+
+		res, err := p.FindByToken(ctx, "storage", "run-1-assets")
+		if err != nil || res.GetID() != bucket.GetID() {
+			t.Errorf("Expected to find %s by token, got %v, %v", bucket.GetID(), res, err)
+		}
+		if _, err := p.FindByToken(ctx, "storage", "run-9"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected unknown token to be not found, got %v", err)
+		}
+	})
+
+	t.Run("UnchangedMakesNoCalls", func(t *testing.T) {
+		before := s3Server.Calls("PutBucketPolicy") + s3Server.Calls("PutBucketLifecycleConfiguration") +
+			s3Server.Calls("PutBucketEncryption") + s3Server.Calls("PutBucketTagging")
+
+		// Reformatting the policy is not a change
+		reformatted := map[string]interface{}{}
+		for k, v := range config {
+			reformatted[k] = v
+		}
+		reformatted["policy"] = `{
+			"Statement": [{"Action": "s3:*", "Condition": {"Bool": {"aws:SecureTransport": "false"}}, "Effect": "Deny", "Principal": "*", "Resource": "arn:aws:s3:::duet-assets/*"}],
+			"Version": "2012-10-17"
+		}`
+		if err := p.Update(ctx, bucket, reformatted); err != nil {
+			t.Fatalf("Failed to update bucket: %v", err)
+		}
+		after := s3Server.Calls("PutBucketPolicy") + s3Server.Calls("PutBucketLifecycleConfiguration") +
+			s3Server.Calls("PutBucketEncryption") + s3Server.Calls("PutBucketTagging")
+		if after != before {
+			t.Errorf("Expected no changes to an unchanged bucket, got %d calls", after-before)
+		}
+	})
+
+	t.Run("UpdateSettingsSeparately", func(t *testing.T) {
+		updated := map[string]interface{}{
+			"bucket":              "duet-assets",
+			"versioning":          false,
+			"lifecycle_rules":     []interface{}{},
+			"public_access_block": map[string]interface{}{"block_public_acls": true},
+			"tags":                map[string]interface{}{"team": "data"},
+		}
+		if err := p.Update(ctx, bucket, updated); err != nil {
+			t.Fatalf("Failed to update bucket: %v", err)
+		}
+
+		got, _ := s3Server.Bucket("duet-assets")
+		if got.Versioning != "Suspended" {
+			t.Errorf("Expected versioning to be suspended, got %q", got.Versioning)
+		}
+		if got.Lifecycle != nil {
+			t.Errorf("Expected lifecycle rules to be removed, got %+v", got.Lifecycle)
+		}
+		if got.PublicAccessBlock == nil || !got.PublicAccessBlock.BlockPublicAcls || got.PublicAccessBlock.RestrictPublicBuckets {
+			t.Errorf("Unexpected public access block: %+v", got.PublicAccessBlock)
+		}
+		// Settings left out of the config are left alone
+		if got.Encryption == nil || got.Policy == "" {
+			t.Errorf("Expected encryption and policy to be kept, got %+v, %q", got.Encryption, got.Policy)
+		}
+		if got.Tags["team"] != "data" || got.Tags[tokenTag] != "run-1-assets" {
+			t.Errorf("Unexpected tags: %v", got.Tags)
+		}
+
+		if err := p.Update(ctx, bucket, map[string]interface{}{"bucket": "duet-assets", "policy": ""}); err != nil {
+			t.Fatalf("Failed to remove policy: %v", err)
+		}
+		if got, _ := s3Server.Bucket("duet-assets"); got.Policy != "" {
+			t.Errorf("Expected policy to be removed, got %q", got.Policy)
+		}
+
+		err := p.Update(ctx, bucket, map[string]interface{}{"bucket": "duet-other"})
+		if err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected replacement error, got %v", err)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		for name, config := range map[string]map[string]interface{}{
+			"MissingBucket":  {"versioning": true},
+			"BadAlgorithm":   {"bucket": "b-1", "encryption": map[string]interface{}{"algorithm": "rot13"}},
+			"KeyWithoutKMS":  {"bucket": "b-1", "encryption": map[string]interface{}{"kms_key_id": "key-1"}},
+			"RuleWithoutID":  {"bucket": "b-1", "lifecycle_rules": []interface{}{map[string]interface{}{"expiration_days": 1}}},
+			"RuleNoAction":   {"bucket": "b-1", "lifecycle_rules": []interface{}{map[string]interface{}{"id": "r"}}},
+			"PolicyNotJSON":  {"bucket": "b-1", "policy": "allow everything"},
+			"TransitionDays": {"bucket": "b-1", "lifecycle_rules": []interface{}{map[string]interface{}{"id": "r", "transitions": []interface{}{map[string]interface{}{"storage_class": "GLACIER"}}}}},
+		} {
+			if _, err := p.Create(ctx, "storage", config); err == nil {
+				t.Errorf("%s: expected error, got nil", name)
+			}
+		}
+	})
+
+	t.Run("DeleteRefusesNonEmpty", func(t *testing.T) {
+		if err := s3Server.PutObject("duet-assets", "index.html", []byte("hello")); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+		err := p.Delete(ctx, bucket)
+		if err == nil || !strings.Contains(err.Error(), "force_destroy") {
+			t.Fatalf("Expected non-empty bucket to be refused, got %v", err)
+		}
+		if _, ok := s3Server.Bucket("duet-assets"); !ok {
+			t.Error("Expected bucket to be kept")
+		}
+	})
+
+	t.Run("ForceDestroy", func(t *testing.T) {
+		if err := p.Update(ctx, bucket, map[string]interface{}{"bucket": "duet-assets", "versioning": true, "force_destroy": true}); err != nil {
+			t.Fatalf("Failed to set force_destroy: %v", err)
+		}
+		for _, body := range []string{"v1", "v2"} {
+			if err := s3Server.PutObject("duet-assets", "app.js", []byte(body)); err != nil {
+				t.Fatalf("Failed to put object: %v", err)
+			}
+		}
+
+		if err := p.Delete(ctx, bucket); err != nil {
+			t.Fatalf("Failed to delete bucket: %v", err)
+		}
+		if _, ok := s3Server.Bucket("duet-assets"); ok {
+			t.Error("Expected bucket to be deleted")
+		}
+		if _, err := p.Read(ctx, "storage", "duet-assets"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected deleted bucket to be not found, got %v", err)
+		}
+		if err := p.Delete(ctx, bucket); err != nil {
+			t.Errorf("Expected deleting a deleted bucket to succeed, got %v", err)
+		}
+	})
+}
+
+func TestBucketRegion(t *testing.T) {
+	p, s3Server := newS3TestProvider(t, "eu-west-1")
+	ctx := context.Background()
+
+	bucket, err := p.Create(ctx, "storage", map[string]interface{}{"bucket": "duet-eu"})
+	if err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if got, _ := s3Server.Bucket("duet-eu"); got.Region != "eu-west-1" {
+		t.Errorf("Expected bucket in eu-west-1, got %q", got.Region)
+	}
+	if bucket.GetMetadata()["region"] != "eu-west-1" {
+		t.Errorf("Expected region eu-west-1 in metadata, got %v", bucket.GetMetadata()["region"])
+	}
+	if s3Server.Calls("PutBucketVersioning") != 0 {
+		t.Error("Expected versioning to be left alone when not configured")
+	}
+
+	// An empty bucket needs no force_destroy
+	if err := p.Delete(ctx, bucket); err != nil {
+		t.Fatalf("Failed to delete bucket: %v", err)
+	}
+}
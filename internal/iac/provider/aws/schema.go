@@ -0,0 +1,504 @@
+package aws
+
+import (
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+// attributeMap is shorthand for the attributes of a schema or an object
+type attributeMap = map[string]*provider.Attribute
+
+// Attributes shared by several resource types. They are never modified, so
+// schemas can share them.
+var (
+	stringListAttribute = &provider.Attribute{Type: provider.TypeList, Elem: &provider.Attribute{Type: provider.TypeString}}
+
+	tagsAttribute = &provider.Attribute{
+		Type:        provider.TypeMap,
+		Elem:        &provider.Attribute{Type: provider.TypeString},
+		Description: "Tags for the resource, merged over the provider's default tags",
+	}
+
+	timeoutsAttribute = &provider.Attribute{
+		Type:        provider.TypeObject,
+		Description: "How long to wait for the resource to become ready",
+		Attributes: attributeMap{
+			"create": {Type: provider.TypeDuration, Default: "10m"},
+			"update": {Type: provider.TypeDuration, Default: "10m"},
+		},
+	}
+
+	blockDevicesAttribute = &provider.Attribute{
+		Type: provider.TypeList,
+		Elem: &provider.Attribute{Type: provider.TypeObject, Attributes: attributeMap{
+			"device_name":           {Type: provider.TypeString, Required: true},
+			"volume_type":           {Type: provider.TypeString},
+			"volume_size":           {Type: provider.TypeInt},
+			"iops":                  {Type: provider.TypeInt},
+			"throughput":            {Type: provider.TypeInt},
+			"encrypted":             {Type: provider.TypeBool},
+			"kms_key_id":            {Type: provider.TypeString},
+			"delete_on_termination": {Type: provider.TypeBool, Default: true},
+		}},
+	}
+
+	ruleAttribute = &provider.Attribute{
+		Type: provider.TypeList,
+		Elem: &provider.Attribute{Type: provider.TypeObject, Attributes: attributeMap{
+			"protocol":         {Type: provider.TypeString, Required: true},
+			"from_port":        {Type: provider.TypeInt},
+			"to_port":          {Type: provider.TypeInt},
+			"description":      {Type: provider.TypeString},
+			"cidr_blocks":      stringListAttribute,
+			"ipv6_cidr_blocks": stringListAttribute,
+			"security_groups":  stringListAttribute,
+			"self":             {Type: provider.TypeBool},
+		}},
+	}
+
+	actionAttribute = attributeMap{
+		"type":             {Type: provider.TypeString, Required: true, Description: "forward, redirect or fixed_response"},
+		"target_group_arn": {Type: provider.TypeString},
+		"status_code":      {Type: provider.TypeString},
+		"protocol":         {Type: provider.TypeString},
+		"port":             {Type: provider.TypeString},
+		"host":             {Type: provider.TypeString},
+		"path":             {Type: provider.TypeString},
+		"query":            {Type: provider.TypeString},
+		"content_type":     {Type: provider.TypeString},
+		"message_body":     {Type: provider.TypeString},
+	}
+)
+
+var instanceSchema = &provider.ResourceSchema{
+	Description: "An EC2 instance",
+	Attributes: attributeMap{
+		"ami":                    {Type: provider.TypeString, Required: true, ForceNew: true},
+		"instance_type":          {Type: provider.TypeString, Required: true},
+		"subnet_id":              {Type: provider.TypeString, ForceNew: true},
+		"key_name":               {Type: provider.TypeString, ForceNew: true},
+		"user_data":              {Type: provider.TypeString},
+		"iam_instance_profile":   {Type: provider.TypeString},
+		"security_groups":        stringListAttribute,
+		"wait_for_public_ip":     {Type: provider.TypeBool},
+		"wait_for_status_checks": {Type: provider.TypeBool, Default: true},
+		"block_devices":          blockDevicesAttribute,
+		"timeouts":               timeoutsAttribute,
+		"tags":                   tagsAttribute,
+		"instance_id":            {Type: provider.TypeString, Computed: true},
+		"vpc_id":                 {Type: provider.TypeString, Computed: true},
+		"availability_zone":      {Type: provider.TypeString, Computed: true},
+		"private_ip":             {Type: provider.TypeString, Computed: true},
+		"public_ip":              {Type: provider.TypeString, Computed: true},
+		"private_dns":            {Type: provider.TypeString, Computed: true},
+		"public_dns":             {Type: provider.TypeString, Computed: true},
+		"host":                   {Type: provider.TypeString, Computed: true},
+		"state":                  {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var volumeSchema = &provider.ResourceSchema{
+	Description: "An EBS volume",
+	Attributes: attributeMap{
+		"availability_zone": {Type: provider.TypeString, Required: true, ForceNew: true},
+		"size":              {Type: provider.TypeInt},
+		"type":              {Type: provider.TypeString, Default: defaultVolumeType},
+		"iops":              {Type: provider.TypeInt},
+		"throughput":        {Type: provider.TypeInt},
+		"encrypted":         {Type: provider.TypeBool, ForceNew: true, Default: false},
+		"kms_key_id":        {Type: provider.TypeString, ForceNew: true},
+		"snapshot_id":       {Type: provider.TypeString, ForceNew: true},
+		"timeouts":          timeoutsAttribute,
+		"tags":              tagsAttribute,
+		"volume_id":         {Type: provider.TypeString, Computed: true},
+		"instance_id":       {Type: provider.TypeString, Computed: true},
+		"state":             {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var volumeAttachmentSchema = &provider.ResourceSchema{
+	Description: "An EBS volume attached to an instance",
+	Attributes: attributeMap{
+		"volume_id":   {Type: provider.TypeString, Required: true, ForceNew: true},
+		"instance_id": {Type: provider.TypeString, Required: true, ForceNew: true},
+		"device_name": {Type: provider.TypeString, Required: true, ForceNew: true},
+		"timeouts":    timeoutsAttribute,
+		"state":       {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var keyPairSchema = &provider.ResourceSchema{
+	Description: "An EC2 key pair, imported from public_key or generated",
+	Attributes: attributeMap{
+		"key_name":    {Type: provider.TypeString, Required: true, ForceNew: true},
+		"public_key":  {Type: provider.TypeString, ForceNew: true},
+		"algorithm":   {Type: provider.TypeString, ForceNew: true, Default: keyAlgorithmED25519, Description: "ed25519 or rsa"},
+		"rsa_bits":    {Type: provider.TypeInt},
+		"tags":        tagsAttribute,
+		"key_pair_id": {Type: provider.TypeString, Computed: true},
+		"key_type":    {Type: provider.TypeString, Computed: true},
+		"fingerprint": {Type: provider.TypeString, Computed: true},
+		"private_key": {Type: provider.TypeString, Computed: true, Sensitive: true},
+	},
+}
+
+var vpcSchema = &provider.ResourceSchema{
+	Description: "A VPC",
+	Attributes: attributeMap{
+		"cidr_block":                {Type: provider.TypeString, Required: true, ForceNew: true},
+		"enable_dns_hostnames":      {Type: provider.TypeBool},
+		"enable_dns_support":        {Type: provider.TypeBool, Default: true},
+		"tags":                      tagsAttribute,
+		"vpc_id":                    {Type: provider.TypeString, Computed: true},
+		"state":                     {Type: provider.TypeString, Computed: true},
+		"main_route_table_id":       {Type: provider.TypeString, Computed: true},
+		"default_security_group_id": {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var subnetSchema = &provider.ResourceSchema{
+	Description: "A subnet of a VPC",
+	Attributes: attributeMap{
+		"vpc_id":                  {Type: provider.TypeString, Required: true, ForceNew: true},
+		"cidr_block":              {Type: provider.TypeString, Required: true, ForceNew: true},
+		"availability_zone":       {Type: provider.TypeString, ForceNew: true},
+		"map_public_ip_on_launch": {Type: provider.TypeBool},
+		"tags":                    tagsAttribute,
+		"subnet_id":               {Type: provider.TypeString, Computed: true},
+		"state":                   {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var internetGatewaySchema = &provider.ResourceSchema{
+	Description: "An internet gateway, attached to vpc_id if set",
+	Attributes: attributeMap{
+		"vpc_id":              {Type: provider.TypeString},
+		"tags":                tagsAttribute,
+		"internet_gateway_id": {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var elasticIPSchema = &provider.ResourceSchema{
+	Description: "An Elastic IP address",
+	Attributes: attributeMap{
+		"tags":          tagsAttribute,
+		"allocation_id": {Type: provider.TypeString, Computed: true},
+		"public_ip":     {Type: provider.TypeString, Computed: true},
+		"domain":        {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var natGatewaySchema = &provider.ResourceSchema{
+	Description: "A NAT gateway",
+	Attributes: attributeMap{
+		"subnet_id":         {Type: provider.TypeString, Required: true, ForceNew: true},
+		"allocation_id":     {Type: provider.TypeString, ForceNew: true},
+		"connectivity_type": {Type: provider.TypeString, ForceNew: true, Default: "public", Description: "public or private"},
+		"timeouts":          timeoutsAttribute,
+		"tags":              tagsAttribute,
+		"nat_gateway_id":    {Type: provider.TypeString, Computed: true},
+		"vpc_id":            {Type: provider.TypeString, Computed: true},
+		"public_ip":         {Type: provider.TypeString, Computed: true},
+		"private_ip":        {Type: provider.TypeString, Computed: true},
+		"state":             {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var routeTableSchema = &provider.ResourceSchema{
+	Description: "A route table and its routes",
+	Attributes: attributeMap{
+		"vpc_id": {Type: provider.TypeString, Required: true, ForceNew: true},
+		"routes": {Type: provider.TypeList, Elem: &provider.Attribute{Type: provider.TypeObject, Attributes: attributeMap{
+			"cidr_block":     {Type: provider.TypeString, Required: true},
+			"gateway_id":     {Type: provider.TypeString},
+			"nat_gateway_id": {Type: provider.TypeString},
+		}}},
+		"tags":           tagsAttribute,
+		"route_table_id": {Type: provider.TypeString, Computed: true},
+		"subnet_ids":     {Type: provider.TypeList, Computed: true, Elem: &provider.Attribute{Type: provider.TypeString}},
+	},
+}
+
+var routeTableAssociationSchema = &provider.ResourceSchema{
+	Description: "The association of a subnet with a route table",
+	Attributes: attributeMap{
+		"route_table_id": {Type: provider.TypeString, Required: true, ForceNew: true},
+		"subnet_id":      {Type: provider.TypeString, Required: true, ForceNew: true},
+		"association_id": {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var securityGroupSchema = &provider.ResourceSchema{
+	Description: "A security group and its rules",
+	Attributes: attributeMap{
+		"name":        {Type: provider.TypeString, Required: true, ForceNew: true},
+		"description": {Type: provider.TypeString, ForceNew: true, Default: defaultGroupDescription},
+		"vpc_id":      {Type: provider.TypeString, ForceNew: true},
+		"ingress":     ruleAttribute,
+		"egress":      ruleAttribute,
+		"tags":        tagsAttribute,
+		"group_id":    {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var bucketSchema = &provider.ResourceSchema{
+	Description: "An S3 bucket",
+	Attributes: attributeMap{
+		"bucket":        {Type: provider.TypeString, Required: true, ForceNew: true},
+		"force_destroy": {Type: provider.TypeBool},
+		"versioning":    {Type: provider.TypeBool},
+		"policy":        {Type: provider.TypeDocument},
+		"encryption": {Type: provider.TypeObject, Attributes: attributeMap{
+			"algorithm":          {Type: provider.TypeString},
+			"kms_key_id":         {Type: provider.TypeString},
+			"bucket_key_enabled": {Type: provider.TypeBool},
+		}},
+		"lifecycle_rules": {Type: provider.TypeList, Elem: &provider.Attribute{Type: provider.TypeObject, Attributes: attributeMap{
+			"id":                                     {Type: provider.TypeString},
+			"prefix":                                 {Type: provider.TypeString},
+			"enabled":                                {Type: provider.TypeBool, Default: true},
+			"expiration_days":                        {Type: provider.TypeInt},
+			"noncurrent_version_expiration_days":     {Type: provider.TypeInt},
+			"abort_incomplete_multipart_upload_days": {Type: provider.TypeInt},
+			"transitions": {Type: provider.TypeList, Elem: &provider.Attribute{Type: provider.TypeObject, Attributes: attributeMap{
+				"days":          {Type: provider.TypeInt, Required: true},
+				"storage_class": {Type: provider.TypeString, Required: true},
+			}}},
+		}}},
+		"public_access_block": {Type: provider.TypeObject, Attributes: attributeMap{
+			"block_public_acls":       {Type: provider.TypeBool},
+			"ignore_public_acls":      {Type: provider.TypeBool},
+			"block_public_policy":     {Type: provider.TypeBool},
+			"restrict_public_buckets": {Type: provider.TypeBool},
+		}},
+		"tags":               tagsAttribute,
+		"arn":                {Type: provider.TypeString, Computed: true},
+		"region":             {Type: provider.TypeString, Computed: true},
+		"bucket_domain_name": {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var roleSchema = &provider.ResourceSchema{
+	Description: "An IAM role",
+	Attributes: attributeMap{
+		"name":                 {Type: provider.TypeString, Required: true, ForceNew: true},
+		"assume_role_policy":   {Type: provider.TypeDocument, Required: true},
+		"path":                 {Type: provider.TypeString, ForceNew: true, Default: "/"},
+		"description":          {Type: provider.TypeString},
+		"permissions_boundary": {Type: provider.TypeString},
+		"max_session_duration": {Type: provider.TypeInt, Default: 3600},
+		"tags":                 tagsAttribute,
+		"arn":                  {Type: provider.TypeString, Computed: true},
+		"unique_id":            {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var policySchema = &provider.ResourceSchema{
+	Description: "A managed IAM policy",
+	Attributes: attributeMap{
+		"name":               {Type: provider.TypeString, Required: true, ForceNew: true},
+		"policy":             {Type: provider.TypeDocument, Required: true},
+		"path":               {Type: provider.TypeString, ForceNew: true, Default: "/"},
+		"description":        {Type: provider.TypeString, ForceNew: true},
+		"tags":               tagsAttribute,
+		"arn":                {Type: provider.TypeString, Computed: true},
+		"policy_id":          {Type: provider.TypeString, Computed: true},
+		"default_version_id": {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var rolePolicySchema = &provider.ResourceSchema{
+	Description: "An inline policy of an IAM role",
+	Attributes: attributeMap{
+		"role":   {Type: provider.TypeString, Required: true, ForceNew: true},
+		"name":   {Type: provider.TypeString, Required: true, ForceNew: true},
+		"policy": {Type: provider.TypeDocument, Required: true},
+	},
+}
+
+var rolePolicyAttachmentSchema = &provider.ResourceSchema{
+	Description: "A managed policy attached to an IAM role",
+	Attributes: attributeMap{
+		"role":       {Type: provider.TypeString, Required: true, ForceNew: true},
+		"policy_arn": {Type: provider.TypeString, Required: true, ForceNew: true},
+	},
+}
+
+var instanceProfileSchema = &provider.ResourceSchema{
+	Description: "An IAM instance profile",
+	Attributes: attributeMap{
+		"name":      {Type: provider.TypeString, Required: true, ForceNew: true},
+		"path":      {Type: provider.TypeString, ForceNew: true, Default: "/"},
+		"role":      {Type: provider.TypeString},
+		"tags":      tagsAttribute,
+		"arn":       {Type: provider.TypeString, Computed: true},
+		"unique_id": {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var loadBalancerSchema = &provider.ResourceSchema{
+	Description: "An application or network load balancer",
+	Attributes: attributeMap{
+		"name":               {Type: provider.TypeString, Required: true, ForceNew: true},
+		"subnets":            {Type: provider.TypeList, Required: true, Elem: &provider.Attribute{Type: provider.TypeString}},
+		"load_balancer_type": {Type: provider.TypeString, ForceNew: true, Default: "application", Description: "application or network"},
+		"internal":           {Type: provider.TypeBool, ForceNew: true, Default: false},
+		"security_groups":    stringListAttribute,
+		"timeouts":           timeoutsAttribute,
+		"tags":               tagsAttribute,
+		"arn":                {Type: provider.TypeString, Computed: true},
+		"dns_name":           {Type: provider.TypeString, Computed: true},
+		"zone_id":            {Type: provider.TypeString, Computed: true},
+		"vpc_id":             {Type: provider.TypeString, Computed: true},
+		"state":              {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var targetGroupSchema = &provider.ResourceSchema{
+	Description: "A load balancer target group",
+	Attributes: attributeMap{
+		"name":        {Type: provider.TypeString, Required: true, ForceNew: true},
+		"port":        {Type: provider.TypeInt, Required: true, ForceNew: true},
+		"vpc_id":      {Type: provider.TypeString, Required: true, ForceNew: true},
+		"protocol":    {Type: provider.TypeString, ForceNew: true, Default: "HTTP"},
+		"target_type": {Type: provider.TypeString, ForceNew: true, Default: "instance"},
+		"health_check": {Type: provider.TypeObject, Attributes: attributeMap{
+			"enabled":             {Type: provider.TypeBool},
+			"protocol":            {Type: provider.TypeString},
+			"port":                {Type: provider.TypeString},
+			"path":                {Type: provider.TypeString},
+			"matcher":             {Type: provider.TypeString},
+			"interval":            {Type: provider.TypeInt},
+			"timeout":             {Type: provider.TypeInt},
+			"healthy_threshold":   {Type: provider.TypeInt},
+			"unhealthy_threshold": {Type: provider.TypeInt},
+		}},
+		"tags":               tagsAttribute,
+		"arn":                {Type: provider.TypeString, Computed: true},
+		"load_balancer_arns": {Type: provider.TypeList, Computed: true, Elem: &provider.Attribute{Type: provider.TypeString}},
+	},
+}
+
+var listenerSchema = &provider.ResourceSchema{
+	Description: "A load balancer listener and its rules",
+	Attributes: attributeMap{
+		"load_balancer_arn": {Type: provider.TypeString, Required: true, ForceNew: true},
+		"port":              {Type: provider.TypeInt, Required: true},
+		"protocol":          {Type: provider.TypeString},
+		"certificate_arn":   {Type: provider.TypeString},
+		"ssl_policy":        {Type: provider.TypeString},
+		"default_action":    {Type: provider.TypeObject, Required: true, Attributes: actionAttribute},
+		"rules": {Type: provider.TypeList, Elem: &provider.Attribute{Type: provider.TypeObject, Attributes: attributeMap{
+			"priority":      {Type: provider.TypeInt, Required: true},
+			"path_patterns": stringListAttribute,
+			"host_headers":  stringListAttribute,
+			"action":        {Type: provider.TypeObject, Required: true, Attributes: actionAttribute},
+		}}},
+		"tags": tagsAttribute,
+		"arn":  {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var targetGroupAttachmentSchema = &provider.ResourceSchema{
+	Description: "A target registered with a target group",
+	Attributes: attributeMap{
+		"target_group_arn": {Type: provider.TypeString, Required: true, ForceNew: true},
+		"target_id":        {Type: provider.TypeString, Required: true, ForceNew: true},
+		"port":             {Type: provider.TypeInt, ForceNew: true},
+		"health":           {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var launchTemplateSchema = &provider.ResourceSchema{
+	Description: "An EC2 launch template; changes create new versions",
+	Attributes: attributeMap{
+		"name":                 {Type: provider.TypeString, Required: true, ForceNew: true},
+		"ami":                  {Type: provider.TypeString, Required: true},
+		"instance_type":        {Type: provider.TypeString, Required: true},
+		"description":          {Type: provider.TypeString},
+		"key_name":             {Type: provider.TypeString},
+		"user_data":            {Type: provider.TypeString},
+		"iam_instance_profile": {Type: provider.TypeString},
+		"security_groups":      stringListAttribute,
+		"block_devices":        blockDevicesAttribute,
+		"instance_tags":        {Type: provider.TypeMap, Elem: &provider.Attribute{Type: provider.TypeString}},
+		"tags":                 tagsAttribute,
+		"latest_version":       {Type: provider.TypeInt, Computed: true},
+		"default_version":      {Type: provider.TypeInt, Computed: true},
+	},
+}
+
+var autoScalingGroupSchema = &provider.ResourceSchema{
+	Description: "An auto scaling group",
+	Attributes: attributeMap{
+		"name":     {Type: provider.TypeString, Required: true, ForceNew: true},
+		"min_size": {Type: provider.TypeInt, Required: true},
+		"max_size": {Type: provider.TypeInt, Required: true},
+		"subnets":  {Type: provider.TypeList, Required: true, Elem: &provider.Attribute{Type: provider.TypeString}},
+		"launch_template": {Type: provider.TypeObject, Required: true, Attributes: attributeMap{
+			"id":      {Type: provider.TypeString},
+			"name":    {Type: provider.TypeString},
+			"version": {Type: provider.TypeAny, Default: defaultLaunchTemplateVersion, Description: "A version number, $Latest or $Default"},
+		}},
+		"desired_capacity":          {Type: provider.TypeInt},
+		"target_group_arns":         stringListAttribute,
+		"health_check_type":         {Type: provider.TypeString},
+		"health_check_grace_period": {Type: provider.TypeInt},
+		"wait_for_capacity":         {Type: provider.TypeBool},
+		"instance_refresh": {Type: provider.TypeObject, Attributes: attributeMap{
+			"instance_warmup":        {Type: provider.TypeInt},
+			"min_healthy_percentage": {Type: provider.TypeInt, Default: 90},
+			"skip_matching":          {Type: provider.TypeBool},
+			"wait":                   {Type: provider.TypeBool},
+		}},
+		"timeouts":     timeoutsAttribute,
+		"tags":         tagsAttribute,
+		"arn":          {Type: provider.TypeString, Computed: true},
+		"instance_ids": {Type: provider.TypeList, Computed: true, Elem: &provider.Attribute{Type: provider.TypeString}},
+	},
+}
+
+var hostedZoneSchema = &provider.ResourceSchema{
+	Description: "A Route 53 hosted zone, private to vpc_id if set",
+	Attributes: attributeMap{
+		"name":          {Type: provider.TypeString, Required: true, ForceNew: true},
+		"comment":       {Type: provider.TypeString},
+		"vpc_id":        {Type: provider.TypeString, ForceNew: true},
+		"vpc_region":    {Type: provider.TypeString},
+		"force_destroy": {Type: provider.TypeBool},
+		"timeouts":      timeoutsAttribute,
+		"tags":          tagsAttribute,
+		"zone_id":       {Type: provider.TypeString, Computed: true},
+		"name_servers":  {Type: provider.TypeList, Computed: true, Elem: &provider.Attribute{Type: provider.TypeString}},
+		"private":       {Type: provider.TypeBool, Computed: true},
+		"record_count":  {Type: provider.TypeInt, Computed: true},
+	},
+}
+
+var recordSetSchema = &provider.ResourceSchema{
+	Description: "A Route 53 record set",
+	Attributes: attributeMap{
+		"zone_id":        {Type: provider.TypeString, Required: true, ForceNew: true},
+		"name":           {Type: provider.TypeString, Required: true, ForceNew: true},
+		"type":           {Type: provider.TypeString, Required: true, ForceNew: true},
+		"set_identifier": {Type: provider.TypeString, ForceNew: true},
+		"records":        stringListAttribute,
+		"ttl":            {Type: provider.TypeInt},
+		"weight":         {Type: provider.TypeInt},
+		"alias": {Type: provider.TypeObject, Attributes: attributeMap{
+			"name":                   {Type: provider.TypeString, Required: true},
+			"zone_id":                {Type: provider.TypeString, Required: true},
+			"evaluate_target_health": {Type: provider.TypeBool},
+		}},
+		"timeouts": timeoutsAttribute,
+		"fqdn":     {Type: provider.TypeString, Computed: true},
+	},
+}
+
+// Schema describes the attributes of every resource type
+func (p *AWSProvider) Schema() *provider.Schema {
+	schema := &provider.Schema{Resources: make(map[string]*provider.ResourceSchema, len(p.handlers))}
+	for resourceType, h := range p.handlers {
+		schema.Resources[resourceType] = h.schema
+	}
+	return schema
+}
@@ -0,0 +1,109 @@
+package aws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+func TestSchema(t *testing.T) {
+	p, _ := newTestProvider(t)
+	schema := p.Schema()
+
+	t.Run("Handlers", func(t *testing.T) {
+		if len(schema.Resources) != len(p.handlers) {
+			t.Errorf("Expected a schema for each of %d resource types, got %d", len(p.handlers), len(schema.Resources))
+		}
+		for resourceType, r := range schema.Resources {
+			if r == nil || len(r.Attributes) == 0 {
+				t.Errorf("Expected a schema for %s", resourceType)
+			}
+		}
+	})
+
+	t.Run("Defaults", func(t *testing.T) {
+		var check func(path string, attrs map[string]*provider.Attribute)
+		check = func(path string, attrs map[string]*provider.Attribute) {
+			for name, attr := range attrs {
+				if attr.Default != nil {
+					r := &provider.ResourceSchema{Attributes: map[string]*provider.Attribute{name: attr}}
+					if diags := r.Validate(map[string]interface{}{name: attr.Default}); diags != nil {
+						t.Errorf("Expected the default of %s%s to be valid, got %v", path, name, diags)
+					}
+				}
+				if attr.Elem != nil && attr.Elem.Attributes != nil {
+					check(path+name+"[].", attr.Elem.Attributes)
+				}
+				check(path+name+".", attr.Attributes)
+			}
+		}
+		for resourceType, r := range schema.Resources {
+			check(resourceType+".", r.Attributes)
+		}
+	})
+
+	t.Run("Sensitive", func(t *testing.T) {
+		if got := p.SensitiveAttributes("key_pair"); !reflect.DeepEqual(got, []string{"private_key"}) {
+			t.Errorf("Expected [private_key], got %v", got)
+		}
+		if got := p.SensitiveAttributes("vpc"); len(got) != 0 {
+			t.Errorf("Expected no sensitive attributes, got %v", got)
+		}
+	})
+
+	t.Run("Configs", func(t *testing.T) {
+		for _, tc := range []struct {
+			resourceType string
+			config       map[string]interface{}
+		}{
+			{"instance", map[string]interface{}{
+				"ami":             "ami-12345678",
+				"instance_type":   "t3.micro",
+				"security_groups": []interface{}{"${aws.security_group.web.id}"},
+				"block_devices":   []interface{}{map[string]interface{}{"device_name": "/dev/xvda", "volume_size": 20}},
+				"timeouts":        map[string]interface{}{"create": "15m", "update": 300},
+				"tags":            map[string]interface{}{"Name": "web"},
+			}},
+			{"storage", map[string]interface{}{
+				"bucket": "assets",
+				"policy": map[string]interface{}{"Version": "2012-10-17", "Statement": []interface{}{}},
+				"lifecycle_rules": []interface{}{map[string]interface{}{
+					"id":          "archive",
+					"transitions": []interface{}{map[string]interface{}{"days": 30, "storage_class": "GLACIER"}},
+				}},
+				"public_access_block": map[string]interface{}{"block_public_acls": true},
+			}},
+			{"lb_listener", map[string]interface{}{
+				"load_balancer_arn": "${aws.lb.web.arn}",
+				"port":              443,
+				"default_action":    map[string]interface{}{"type": "forward", "target_group_arn": "${aws.lb_target_group.web.arn}"},
+				"rules": []interface{}{map[string]interface{}{
+					"priority":      10,
+					"path_patterns": []interface{}{"/api/*"},
+					"action":        map[string]interface{}{"type": "fixed_response", "status_code": "404"},
+				}},
+			}},
+			{"autoscaling_group", map[string]interface{}{
+				"name":             "web",
+				"min_size":         1,
+				"max_size":         3,
+				"subnets":          []interface{}{"subnet-1"},
+				"launch_template":  map[string]interface{}{"id": "lt-1", "version": 2},
+				"instance_refresh": map[string]interface{}{"min_healthy_percentage": 50},
+			}},
+		} {
+			if diags := schema.Resources[tc.resourceType].Validate(tc.config); diags != nil {
+				t.Errorf("Expected a valid %s config, got %v", tc.resourceType, diags)
+			}
+		}
+	})
+
+	t.Run("Typo", func(t *testing.T) {
+		diags := schema.Resources["instance"].Validate(map[string]interface{}{"ami": "ami-1", "instnace_type": "t3.micro"})
+		want := "attribute instance_type: required but not set; attribute instnace_type: unknown attribute, did you mean instance_type?"
+		if diags == nil || diags.Error() != want {
+			t.Errorf("Expected %q, got %v", want, diags)
+		}
+	})
+}
@@ -0,0 +1,476 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// defaultGroupDescription is used when a security group sets none, since
+// AWS requires one
+const defaultGroupDescription = "Managed by duet"
+
+// sgRule is a single security group rule with exactly one peer: an IPv4
+// range, an IPv6 range or another security group. Rules in config that list
+// several peers are split into one sgRule per peer, which is also how rule
+// changes are diffed. Ports are zero for protocol -1.
+type sgRule struct {
+	protocol    string
+	cidrBlock   string
+	ipv6Block   string
+	groupID     string
+	description string
+	fromPort    int
+	toPort      int
+}
+
+// key identifies a rule to AWS. The description is not part of it and can
+// be changed in place.
+func (r sgRule) key() string {
+	return fmt.Sprintf("%s/%d/%d/%s/%s/%s", r.protocol, r.fromPort, r.toPort, r.cidrBlock, r.ipv6Block, r.groupID)
+}
+
+// securityGroupSpec is the desired configuration of a security group.
+// Ingress rules are always managed; egress rules only when set, so a group
+// that does not mention egress keeps AWS's default allow-all rule.
+type securityGroupSpec struct {
+	tags          map[string]string
+	name          string
+	description   string
+	vpcID         string
+	ingress       []sgRule
+	egress        []sgRule
+	manageEgress  bool
+	selfReference bool
+}
+
+func parseSecurityGroupSpec(config map[string]interface{}) (*securityGroupSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("name")
+
+	spec := &securityGroupSpec{
+		name:         attrs.String("name"),
+		description:  attrs.String("description"),
+		vpcID:        attrs.String("vpc_id"),
+		tags:         attrs.StringMap("tags"),
+		manageEgress: attrs.Has("egress"),
+	}
+	if spec.description == "" {
+		spec.description = defaultGroupDescription
+	}
+
+	for _, direction := range []string{"ingress", "egress"} {
+		rules := attrs.List(direction)
+		for i, r := range rules {
+			parsed, self, err := parseRule(r)
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]: %w", direction, i+1, err)
+			}
+			spec.selfReference = spec.selfReference || self
+			if direction == "ingress" {
+				spec.ingress = append(spec.ingress, parsed...)
+			} else {
+				spec.egress = append(spec.egress, parsed...)
+			}
+		}
+	}
+
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// parseRule splits one configured rule into a rule per peer. A rule with
+// self = true refers to the group itself, whose ID is filled in by
+// resolveSelf once it is known.
+func parseRule(r *attributes) ([]sgRule, bool, error) {
+	r.Require("protocol")
+	protocol := normalizeProtocol(r.String("protocol"))
+	fromPort := r.Int("from_port")
+	toPort := fromPort
+	if r.Has("to_port") {
+		toPort = r.Int("to_port")
+	}
+	description := r.String("description")
+	cidrs := r.StringList("cidr_blocks")
+	ipv6 := r.StringList("ipv6_cidr_blocks")
+	groups := r.StringList("security_groups")
+	self := r.Bool("self")
+	if err := r.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if protocol == "-1" {
+		fromPort, toPort = 0, 0
+	} else if !r.Has("from_port") && (protocol == "tcp" || protocol == "udp") {
+		return nil, false, fmt.Errorf("attribute from_port is required for protocol %s", protocol)
+	}
+
+	base := sgRule{protocol: protocol, fromPort: fromPort, toPort: toPort, description: description}
+	var rules []sgRule
+	for _, cidr := range cidrs {
+		rule := base
+		rule.cidrBlock = cidr
+		rules = append(rules, rule)
+	}
+	for _, cidr := range ipv6 {
+		rule := base
+		rule.ipv6Block = cidr
+		rules = append(rules, rule)
+	}
+	for _, group := range groups {
+		rule := base
+		rule.groupID = group
+		rules = append(rules, rule)
+	}
+	if self {
+		rules = append(rules, base)
+	}
+	if len(rules) == 0 {
+		return nil, false, fmt.Errorf("one of cidr_blocks, ipv6_cidr_blocks, security_groups or self is required")
+	}
+	return rules, self, nil
+}
+
+// normalizeProtocol converts a protocol to the form AWS reports it in
+func normalizeProtocol(protocol string) string {
+	switch p := strings.ToLower(protocol); p {
+	case "all", "-1":
+		return "-1"
+	case "6":
+		return "tcp"
+	case "17":
+		return "udp"
+	case "1":
+		return "icmp"
+	default:
+		return p
+	}
+}
+
+// resolveSelf fills in the group's own ID for rules that refer to it
+func resolveSelf(rules []sgRule, id string) []sgRule {
+	out := make([]sgRule, len(rules))
+	for i, r := range rules {
+		if r.cidrBlock == "" && r.ipv6Block == "" && r.groupID == "" {
+			r.groupID = id
+		}
+		out[i] = r
+	}
+	return out
+}
+
+// CreateSecurityGroup creates a security group and authorizes its rules
+func (c *EC2Client) CreateSecurityGroup(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseSecurityGroupSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &ec2.CreateSecurityGroupInput{
+		GroupName:         aws.String(spec.name),
+		Description:       aws.String(spec.description),
+		TagSpecifications: tagSpecification(ctx, ec2types.ResourceTypeSecurityGroup, spec.tags),
+	}
+	if spec.vpcID != "" {
+		input.VpcId = aws.String(spec.vpcID)
+	}
+	result, err := c.client.CreateSecurityGroup(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create security group %s: %w", spec.name, err)
+	}
+
+	// The group exists from here on, so it is returned even if authorizing
+	// its rules fails, letting the caller track it
+	id := aws.ToString(result.GroupId)
+	group, err := c.describeSecurityGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.syncSecurityGroupRules(ctx, *group, spec); err != nil {
+		return securityGroupResource(*group), err
+	}
+	return c.ReadSecurityGroup(ctx, id)
+}
+
+// ReadSecurityGroup returns the current state of a security group
+func (c *EC2Client) ReadSecurityGroup(ctx context.Context, id string) (*types.BaseResource, error) {
+	group, err := c.describeSecurityGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return securityGroupResource(*group), nil
+}
+
+// FindSecurityGroupByToken returns the security group created with the given
+// idempotency token
+func (c *EC2Client) FindSecurityGroupByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{Filters: tokenFilter(token)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find security group by token: %w", err)
+	}
+	if len(result.SecurityGroups) == 0 {
+		return nil, provider.ErrNotFound
+	}
+	return securityGroupResource(result.SecurityGroups[0]), nil
+}
+
+// UpdateSecurityGroup authorizes, revokes and redescribes individual rules
+// so the group matches config, without touching rules that are unchanged.
+// The name, description and VPC are fixed at creation.
+func (c *EC2Client) UpdateSecurityGroup(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseSecurityGroupSpec(config)
+	if err != nil {
+		return err
+	}
+
+	id := resource.GetID()
+	group, err := c.describeSecurityGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	for attr, values := range map[string][2]string{
+		"name":        {spec.name, aws.ToString(group.GroupName)},
+		"description": {spec.description, aws.ToString(group.Description)},
+		"vpc_id":      {spec.vpcID, aws.ToString(group.VpcId)},
+	} {
+		if values[0] != "" && values[0] != values[1] {
+			return fmt.Errorf("changing %s of security group %s requires replacing it", attr, id)
+		}
+	}
+
+	if err := c.syncSecurityGroupRules(ctx, *group, spec); err != nil {
+		return err
+	}
+	return c.updateTags(ctx, id, fromEC2Tags(group.Tags), spec.tags)
+}
+
+// DeleteSecurityGroup deletes a security group, waiting out instances using
+// it that are still terminating
+func (c *EC2Client) DeleteSecurityGroup(ctx context.Context, resource provider.Resource) error {
+	id := resource.GetID()
+	err := c.retryDependencyViolation(ctx, "security group "+id, func() error {
+		_, err := c.client.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{GroupId: aws.String(id)})
+		return err
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete security group %s: %w", id, err)
+	}
+	return nil
+}
+
+func (c *EC2Client) syncSecurityGroupRules(ctx context.Context, group ec2types.SecurityGroup, spec *securityGroupSpec) error {
+	id := aws.ToString(group.GroupId)
+	if err := c.syncRules(ctx, id, false, flattenPermissions(group.IpPermissions), resolveSelf(spec.ingress, id)); err != nil {
+		return err
+	}
+	if !spec.manageEgress {
+		return nil
+	}
+	return c.syncRules(ctx, id, true, flattenPermissions(group.IpPermissionsEgress), resolveSelf(spec.egress, id))
+}
+
+// syncRules diffs one direction of a group's rules. New rules are
+// authorized before stale ones are revoked, so traffic allowed both before
+// and after is never interrupted; rules whose description alone changed are
+// updated in place.
+func (c *EC2Client) syncRules(ctx context.Context, id string, egress bool, current, desired []sgRule) error {
+	have := make(map[string]sgRule, len(current))
+	for _, r := range current {
+		have[r.key()] = r
+	}
+	want := make(map[string]sgRule, len(desired))
+	for _, r := range desired {
+		want[r.key()] = r
+	}
+
+	var authorize, revoke, redescribe []sgRule
+	for _, r := range desired {
+		existing, ok := have[r.key()]
+		switch {
+		case !ok:
+			authorize = append(authorize, r)
+		case existing.description != r.description:
+			redescribe = append(redescribe, r)
+		}
+	}
+	for _, r := range current {
+		if _, ok := want[r.key()]; !ok {
+			revoke = append(revoke, r)
+		}
+	}
+
+	direction := "ingress"
+	if egress {
+		direction = "egress"
+	}
+	if len(authorize) > 0 {
+		var err error
+		if egress {
+			_, err = c.client.AuthorizeSecurityGroupEgress(ctx, &ec2.AuthorizeSecurityGroupEgressInput{
+				GroupId: aws.String(id), IpPermissions: toIPPermissions(authorize),
+			})
+		} else {
+			_, err = c.client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+				GroupId: aws.String(id), IpPermissions: toIPPermissions(authorize),
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to authorize %s rules of %s: %w", direction, id, err)
+		}
+	}
+	if len(revoke) > 0 {
+		var err error
+		if egress {
+			_, err = c.client.RevokeSecurityGroupEgress(ctx, &ec2.RevokeSecurityGroupEgressInput{
+				GroupId: aws.String(id), IpPermissions: toIPPermissions(revoke),
+			})
+		} else {
+			_, err = c.client.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+				GroupId: aws.String(id), IpPermissions: toIPPermissions(revoke),
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to revoke %s rules of %s: %w", direction, id, err)
+		}
+	}
+	if len(redescribe) > 0 {
+		var err error
+		if egress {
+			_, err = c.client.UpdateSecurityGroupRuleDescriptionsEgress(ctx, &ec2.UpdateSecurityGroupRuleDescriptionsEgressInput{
+				GroupId: aws.String(id), IpPermissions: toIPPermissions(redescribe),
+			})
+		} else {
+			_, err = c.client.UpdateSecurityGroupRuleDescriptionsIngress(ctx, &ec2.UpdateSecurityGroupRuleDescriptionsIngressInput{
+				GroupId: aws.String(id), IpPermissions: toIPPermissions(redescribe),
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update %s rule descriptions of %s: %w", direction, id, err)
+		}
+	}
+	return nil
+}
+
+func (c *EC2Client) describeSecurityGroup(ctx context.Context, id string) (*ec2types.SecurityGroup, error) {
+	result, err := c.client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: []string{id}})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("security group %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe security group %s: %w", id, err)
+	}
+	if len(result.SecurityGroups) == 0 {
+		return nil, fmt.Errorf("security group %s: %w", id, provider.ErrNotFound)
+	}
+	return &result.SecurityGroups[0], nil
+}
+
+// toIPPermissions converts rules to the API form, one permission per rule
+func toIPPermissions(rules []sgRule) []ec2types.IpPermission {
+	out := make([]ec2types.IpPermission, 0, len(rules))
+	for _, r := range rules {
+		p := ec2types.IpPermission{IpProtocol: aws.String(r.protocol)}
+		if r.protocol != "-1" {
+			p.FromPort = aws.Int32(int32(r.fromPort))
+			p.ToPort = aws.Int32(int32(r.toPort))
+		}
+		description := optionalString(r.description)
+		switch {
+		case r.cidrBlock != "":
+			p.IpRanges = []ec2types.IpRange{{CidrIp: aws.String(r.cidrBlock), Description: description}}
+		case r.ipv6Block != "":
+			p.Ipv6Ranges = []ec2types.Ipv6Range{{CidrIpv6: aws.String(r.ipv6Block), Description: description}}
+		default:
+			p.UserIdGroupPairs = []ec2types.UserIdGroupPair{{GroupId: aws.String(r.groupID), Description: description}}
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// flattenPermissions splits permissions as AWS reports them into one rule
+// per peer, sorted for stable metadata
+func flattenPermissions(permissions []ec2types.IpPermission) []sgRule {
+	var rules []sgRule
+	for _, p := range permissions {
+		base := sgRule{protocol: normalizeProtocol(aws.ToString(p.IpProtocol))}
+		if base.protocol != "-1" {
+			base.fromPort = int(aws.ToInt32(p.FromPort))
+			base.toPort = int(aws.ToInt32(p.ToPort))
+		}
+		for _, r := range p.IpRanges {
+			rule := base
+			rule.cidrBlock = aws.ToString(r.CidrIp)
+			rule.description = aws.ToString(r.Description)
+			rules = append(rules, rule)
+		}
+		for _, r := range p.Ipv6Ranges {
+			rule := base
+			rule.ipv6Block = aws.ToString(r.CidrIpv6)
+			rule.description = aws.ToString(r.Description)
+			rules = append(rules, rule)
+		}
+		for _, g := range p.UserIdGroupPairs {
+			rule := base
+			rule.groupID = aws.ToString(g.GroupId)
+			rule.description = aws.ToString(g.Description)
+			rules = append(rules, rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].key() < rules[j].key() })
+	return rules
+}
+
+func rulesMetadata(rules []sgRule) []interface{} {
+	out := make([]interface{}, 0, len(rules))
+	for _, r := range rules {
+		entry := map[string]interface{}{
+			"protocol":  r.protocol,
+			"from_port": r.fromPort,
+			"to_port":   r.toPort,
+		}
+		switch {
+		case r.cidrBlock != "":
+			entry["cidr_block"] = r.cidrBlock
+		case r.ipv6Block != "":
+			entry["ipv6_cidr_block"] = r.ipv6Block
+		default:
+			entry["security_group"] = r.groupID
+		}
+		if r.description != "" {
+			entry["description"] = r.description
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+func securityGroupResource(group ec2types.SecurityGroup) *types.BaseResource {
+	return &types.BaseResource{
+		ID:       aws.ToString(group.GroupId),
+		Type:     types.ResourceTypeSecurityGroup,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"group_id":    aws.ToString(group.GroupId),
+			"name":        aws.ToString(group.GroupName),
+			"description": aws.ToString(group.Description),
+			"vpc_id":      aws.ToString(group.VpcId),
+			"ingress":     rulesMetadata(flattenPermissions(group.IpPermissions)),
+			"egress":      rulesMetadata(flattenPermissions(group.IpPermissionsEgress)),
+		},
+		Tags:      fromEC2Tags(group.Tags),
+		UpdatedAt: time.Now(),
+	}
+}
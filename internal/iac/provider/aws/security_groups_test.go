@@ -0,0 +1,168 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/internal/iac/provider/aws/awstest"
+)
+
+func TestSecurityGroups(t *testing.T) {
+	p, ec2Server := newTestProvider(t)
+	ctx := context.Background()
+
+	vpc, err := p.Create(ctx, "vpc", map[string]interface{}{"cidr_block": "10.0.0.0/16"})
+	if err != nil {
+		t.Fatalf("Failed to create vpc: %v", err)
+	}
+
+	base := func(ingress ...interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"name":    "web",
+			"vpc_id":  vpc.GetID(),
+			"ingress": ingress,
+		}
+	}
+	https := map[string]interface{}{"protocol": "tcp", "from_port": 443, "cidr_blocks": []interface{}{"0.0.0.0/0", "10.0.0.0/8"}}
+
+	var group provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		group, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-sg"), "security_group", base(https,
+			map[string]interface{}{"protocol": "all", "self": true, "description": "cluster"},
+		))
+		if err != nil {
+			t.Fatalf("Failed to create security group: %v", err)
+		}
+
+		got, _ := ec2Server.SecurityGroup(group.GetID())
+		if got.Name != "web" || got.Description != defaultGroupDescription || got.VpcID != vpc.GetID() {
+			t.Errorf("Unexpected security group attributes: %+v", got)
+		}
+		want := []awstest.Permission{
+			{Protocol: "tcp", FromPort: 443, ToPort: 443, CidrIP: "0.0.0.0/0"},
+			{Protocol: "tcp", FromPort: 443, ToPort: 443, CidrIP: "10.0.0.0/8"},
+			{Protocol: "-1", GroupID: group.GetID(), Description: "cluster"},
+		}
+		if len(got.Ingress) != len(want) {
+			t.Fatalf("Expected %d ingress rules, got %+v", len(want), got.Ingress)
+		}
+		for i := range want {
+			if got.Ingress[i] != want[i] {
+				t.Errorf("Expected ingress rule %+v, got %+v", want[i], got.Ingress[i])
+			}
+		}
+		if len(got.Egress) != 1 || got.Egress[0].Protocol != "-1" {
+			t.Errorf("Expected default egress rule to be kept, got %+v", got.Egress)
+		}
+
+		meta := group.GetMetadata()
+		ingress, _ := meta["ingress"].([]interface{})
+		if meta["group_id"] != group.GetID() || len(ingress) != 3 {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+
+		res, err := p.FindByToken(ctx, "security_group", "run-1-sg")
+		if err != nil || res.GetID() != group.GetID() {
+			t.Errorf("Expected to find %s by token, got %v, %v", group.GetID(), res, err)
+		}
+	})
+
+	t.Run("UpdateDiffsRules", func(t *testing.T) {
+		authorized := ec2Server.Calls("AuthorizeSecurityGroupIngress")
+
+		// Drop one range, add a port and describe the self rule differently
+		err := p.Update(ctx, group, base(
+			map[string]interface{}{"protocol": "tcp", "from_port": 443, "cidr_blocks": []interface{}{"0.0.0.0/0"}},
+			map[string]interface{}{"protocol": "tcp", "from_port": 8000, "to_port": 8080, "cidr_blocks": []interface{}{"10.0.0.0/8"}},
+			map[string]interface{}{"protocol": "-1", "self": true, "description": "cluster members"},
+		))
+		if err != nil {
+			t.Fatalf("Failed to update security group: %v", err)
+		}
+
+		got, _ := ec2Server.SecurityGroup(group.GetID())
+		want := []awstest.Permission{
+			{Protocol: "tcp", FromPort: 443, ToPort: 443, CidrIP: "0.0.0.0/0"},
+			{Protocol: "-1", GroupID: group.GetID(), Description: "cluster members"},
+			{Protocol: "tcp", FromPort: 8000, ToPort: 8080, CidrIP: "10.0.0.0/8"},
+		}
+		if len(got.Ingress) != len(want) {
+			t.Fatalf("Expected %d ingress rules, got %+v", len(want), got.Ingress)
+		}
+		for i := range want {
+			if got.Ingress[i] != want[i] {
+				t.Errorf("Expected ingress rule %+v, got %+v", want[i], got.Ingress[i])
+			}
+		}
+		if n := ec2Server.Calls("AuthorizeSecurityGroupIngress") - authorized; n != 1 {
+			t.Errorf("Expected one authorize call, got %d", n)
+		}
+		if n := ec2Server.Calls("RevokeSecurityGroupIngress"); n != 1 {
+			t.Errorf("Expected one revoke call, got %d", n)
+		}
+		if n := ec2Server.Calls("UpdateSecurityGroupRuleDescriptionsIngress"); n != 1 {
+			t.Errorf("Expected one description update, got %d", n)
+		}
+
+		// Applying the same config again changes nothing
+		calls := ec2Server.Calls("AuthorizeSecurityGroupIngress") + ec2Server.Calls("RevokeSecurityGroupIngress")
+		if err := p.Update(ctx, group, base(
+			map[string]interface{}{"protocol": "6", "from_port": 443, "cidr_blocks": []interface{}{"0.0.0.0/0"}},
+			map[string]interface{}{"protocol": "tcp", "from_port": 8000, "to_port": 8080, "cidr_blocks": []interface{}{"10.0.0.0/8"}},
+			map[string]interface{}{"protocol": "all", "self": true, "description": "cluster members"},
+		)); err != nil {
+			t.Fatalf("Failed to update security group: %v", err)
+		}
+		if ec2Server.Calls("AuthorizeSecurityGroupIngress")+ec2Server.Calls("RevokeSecurityGroupIngress") != calls {
+			t.Error("Expected unchanged rules not to be authorized or revoked")
+		}
+	})
+
+	t.Run("ManagedEgress", func(t *testing.T) {
+		config := base(https)
+		config["egress"] = []interface{}{
+			map[string]interface{}{"protocol": "tcp", "from_port": 443, "cidr_blocks": []interface{}{"0.0.0.0/0"}},
+		}
+		if err := p.Update(ctx, group, config); err != nil {
+			t.Fatalf("Failed to update security group: %v", err)
+		}
+		got, _ := ec2Server.SecurityGroup(group.GetID())
+		if len(got.Egress) != 1 || got.Egress[0].Protocol != "tcp" || got.Egress[0].FromPort != 443 {
+			t.Errorf("Expected only the https egress rule, got %+v", got.Egress)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		for name, config := range map[string]map[string]interface{}{
+			"MissingName": {"vpc_id": vpc.GetID()},
+			"NoPeer":      base(map[string]interface{}{"protocol": "tcp", "from_port": 22}),
+			"NoPort":      base(map[string]interface{}{"protocol": "tcp", "cidr_blocks": []interface{}{"0.0.0.0/0"}}),
+		} {
+			if _, err := p.Create(ctx, "security_group", config); err == nil {
+				t.Errorf("%s: expected error, got nil", name)
+			}
+		}
+
+		config := base(https)
+		config["description"] = "something else"
+		err := p.Update(ctx, group, config)
+		if err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected replacement error, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, group); err != nil {
+			t.Fatalf("Failed to delete security group: %v", err)
+		}
+		if _, err := p.Read(ctx, "security_group", group.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected security group to be gone, got %v", err)
+		}
+		if err := p.Delete(ctx, group); err != nil {
+			t.Errorf("Expected deleting a deleted security group to succeed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,482 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// defaultVolumeType is used when a volume does not set one
+const defaultVolumeType = "gp3"
+
+// volumeSpec is the desired configuration of an EBS volume. Size, type,
+// IOPS and throughput can be modified in place; the rest is fixed at
+// creation. Zero IOPS or throughput leaves AWS's default for the type.
+type volumeSpec struct {
+	tags             map[string]string
+	availabilityZone string
+	volumeType       string
+	kmsKeyID         string
+	snapshotID       string
+	timeouts         timeouts
+	size             int
+	iops             int
+	throughput       int
+	encrypted        bool
+}
+
+func parseVolumeSpec(config map[string]interface{}) (*volumeSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("availability_zone")
+
+	spec := &volumeSpec{
+		availabilityZone: attrs.String("availability_zone"),
+		size:             attrs.Int("size"),
+		volumeType:       attrs.String("type"),
+		iops:             attrs.Int("iops"),
+		throughput:       attrs.Int("throughput"),
+		encrypted:        attrs.Bool("encrypted"),
+		kmsKeyID:         attrs.String("kms_key_id"),
+		snapshotID:       attrs.String("snapshot_id"),
+		tags:             attrs.StringMap("tags"),
+		timeouts:         parseTimeouts(attrs),
+	}
+	if spec.volumeType == "" {
+		spec.volumeType = defaultVolumeType
+	}
+
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	if spec.size <= 0 && spec.snapshotID == "" {
+		return nil, fmt.Errorf("attribute size is required unless snapshot_id is set")
+	}
+	if spec.kmsKeyID != "" && !spec.encrypted {
+		return nil, fmt.Errorf("attribute kms_key_id requires encrypted = true")
+	}
+	switch spec.volumeType {
+	case "gp3":
+	case "io1", "io2":
+		if spec.iops <= 0 {
+			return nil, fmt.Errorf("attribute iops is required for %s volumes", spec.volumeType)
+		}
+	default:
+		if spec.iops > 0 {
+			return nil, fmt.Errorf("attribute iops is not supported for %s volumes", spec.volumeType)
+		}
+	}
+	if spec.throughput > 0 && spec.volumeType != "gp3" {
+		return nil, fmt.Errorf("attribute throughput is only supported for gp3 volumes")
+	}
+	return spec, nil
+}
+
+// CreateVolume creates an EBS volume and waits for it to be available,
+// bounded by the create timeout
+func (c *EC2Client) CreateVolume(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseVolumeSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &ec2.CreateVolumeInput{
+		AvailabilityZone:  aws.String(spec.availabilityZone),
+		VolumeType:        ec2types.VolumeType(spec.volumeType),
+		TagSpecifications: tagSpecification(ctx, ec2types.ResourceTypeVolume, spec.tags),
+	}
+	if spec.size > 0 {
+		input.Size = aws.Int32(int32(spec.size))
+	}
+	if spec.iops > 0 {
+		input.Iops = aws.Int32(int32(spec.iops))
+	}
+	if spec.throughput > 0 {
+		input.Throughput = aws.Int32(int32(spec.throughput))
+	}
+	if spec.encrypted {
+		input.Encrypted = aws.Bool(true)
+		input.KmsKeyId = optionalString(spec.kmsKeyID)
+	}
+	input.SnapshotId = optionalString(spec.snapshotID)
+	if token := provider.IdempotencyToken(ctx); token != "" {
+		input.ClientToken = aws.String(token)
+	}
+
+	result, err := c.client.CreateVolume(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create volume: %w", err)
+	}
+
+	id := aws.ToString(result.VolumeId)
+	volume, err := c.waitForVolume(ctx, id, ec2types.VolumeStateAvailable, spec.timeouts.create)
+	if err != nil {
+		return &types.BaseResource{ID: id, Type: types.ResourceTypeVolume, Provider: "aws", Status: types.StatusCreating}, err
+	}
+	return volumeResource(*volume), nil
+}
+
+// ReadVolume returns the current state of a volume
+func (c *EC2Client) ReadVolume(ctx context.Context, id string) (*types.BaseResource, error) {
+	volume, err := c.describeVolume(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return volumeResource(*volume), nil
+}
+
+// FindVolumeByToken returns the volume created with the given idempotency
+// token
+func (c *EC2Client) FindVolumeByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{Filters: tokenFilter(token)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find volume by token: %w", err)
+	}
+	for _, volume := range result.Volumes {
+		if volume.State != ec2types.VolumeStateDeleting && volume.State != ec2types.VolumeStateDeleted {
+			return volumeResource(volume), nil
+		}
+	}
+	return nil, provider.ErrNotFound
+}
+
+// UpdateVolume grows the volume or changes its type and performance in
+// place with a single ModifyVolume call, waiting until the new settings are
+// in effect. Volumes cannot shrink, and their zone, encryption and source
+// snapshot are fixed at creation.
+func (c *EC2Client) UpdateVolume(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseVolumeSpec(config)
+	if err != nil {
+		return err
+	}
+
+	id := resource.GetID()
+	volume, err := c.describeVolume(ctx, id)
+	if err != nil {
+		return err
+	}
+	for attr, values := range map[string][2]string{
+		"availability_zone": {spec.availabilityZone, aws.ToString(volume.AvailabilityZone)},
+		"encrypted":         {fmt.Sprint(spec.encrypted), fmt.Sprint(aws.ToBool(volume.Encrypted))},
+		"kms_key_id":        {spec.kmsKeyID, aws.ToString(volume.KmsKeyId)},
+		"snapshot_id":       {spec.snapshotID, aws.ToString(volume.SnapshotId)},
+	} {
+		if values[0] != "" && values[0] != values[1] {
+			return fmt.Errorf("changing %s of volume %s requires replacing it", attr, id)
+		}
+	}
+
+	size := int(aws.ToInt32(volume.Size))
+	if spec.size > 0 && spec.size < size {
+		return fmt.Errorf("volume %s cannot shrink from %d GiB to %d GiB", id, size, spec.size)
+	}
+
+	input := &ec2.ModifyVolumeInput{VolumeId: aws.String(id)}
+	modified := false
+	if spec.size > size {
+		input.Size = aws.Int32(int32(spec.size))
+		modified = true
+	}
+	if spec.volumeType != string(volume.VolumeType) {
+		input.VolumeType = ec2types.VolumeType(spec.volumeType)
+		modified = true
+	}
+	if spec.iops > 0 && spec.iops != int(aws.ToInt32(volume.Iops)) {
+		input.Iops = aws.Int32(int32(spec.iops))
+		modified = true
+	}
+	if spec.throughput > 0 && spec.throughput != int(aws.ToInt32(volume.Throughput)) {
+		input.Throughput = aws.Int32(int32(spec.throughput))
+		modified = true
+	}
+	if modified {
+		if _, err := c.client.ModifyVolume(ctx, input); err != nil {
+			return fmt.Errorf("failed to modify volume %s: %w", id, err)
+		}
+		if err := c.waitForVolumeModification(ctx, id, spec.timeouts.update); err != nil {
+			return err
+		}
+	}
+
+	return c.updateTags(ctx, id, fromEC2Tags(volume.Tags), spec.tags)
+}
+
+// DeleteVolume deletes a volume, waiting out attachments that are still
+// being released, and waits until it is gone
+func (c *EC2Client) DeleteVolume(ctx context.Context, resource provider.Resource) error {
+	id := resource.GetID()
+	err := c.retryDependencyViolation(ctx, "volume "+id, func() error {
+		_, err := c.client.DeleteVolume(ctx, &ec2.DeleteVolumeInput{VolumeId: aws.String(id)})
+		return err
+	})
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete volume %s: %w", id, err)
+	}
+	_, err = c.waitForVolume(ctx, id, ec2types.VolumeStateDeleted, defaultDeleteTimeout)
+	return err
+}
+
+// describeVolume returns a volume, reporting deleted volumes as
+// provider.ErrNotFound
+func (c *EC2Client) describeVolume(ctx context.Context, id string) (*ec2types.Volume, error) {
+	result, err := c.client.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{VolumeIds: []string{id}})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("volume %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe volume %s: %w", id, err)
+	}
+	if len(result.Volumes) == 0 || result.Volumes[0].State == ec2types.VolumeStateDeleted {
+		return nil, fmt.Errorf("volume %s: %w", id, provider.ErrNotFound)
+	}
+	return &result.Volumes[0], nil
+}
+
+func volumeStatus(state ec2types.VolumeState) types.ResourceStatus {
+	switch state {
+	case ec2types.VolumeStateCreating:
+		return types.StatusCreating
+	case ec2types.VolumeStateAvailable, ec2types.VolumeStateInUse:
+		return types.StatusRunning
+	case ec2types.VolumeStateDeleting:
+		return types.StatusDeleting
+	case ec2types.VolumeStateDeleted:
+		return types.StatusDeleted
+	case ec2types.VolumeStateError:
+		return types.StatusFailed
+	default:
+		return types.StatusUnavailable
+	}
+}
+
+func volumeResource(volume ec2types.Volume) *types.BaseResource {
+	instanceID := ""
+	for _, a := range volume.Attachments {
+		instanceID = aws.ToString(a.InstanceId)
+	}
+	return &types.BaseResource{
+		ID:       aws.ToString(volume.VolumeId),
+		Type:     types.ResourceTypeVolume,
+		Provider: "aws",
+		Status:   volumeStatus(volume.State),
+		Metadata: map[string]interface{}{
+			"volume_id":         aws.ToString(volume.VolumeId),
+			"availability_zone": aws.ToString(volume.AvailabilityZone),
+			"size":              int(aws.ToInt32(volume.Size)),
+			"type":              string(volume.VolumeType),
+			"iops":              int(aws.ToInt32(volume.Iops)),
+			"throughput":        int(aws.ToInt32(volume.Throughput)),
+			"encrypted":         aws.ToBool(volume.Encrypted),
+			"kms_key_id":        aws.ToString(volume.KmsKeyId),
+			"snapshot_id":       aws.ToString(volume.SnapshotId),
+			"instance_id":       instanceID,
+			"state":             string(volume.State),
+		},
+		Tags:      fromEC2Tags(volume.Tags),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// attachmentSpec is the desired attachment of a volume to an instance.
+// Nothing about an attachment can change in place.
+type attachmentSpec struct {
+	volumeID   string
+	instanceID string
+	deviceName string
+	timeouts   timeouts
+}
+
+func parseAttachmentSpec(config map[string]interface{}) (*attachmentSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("volume_id", "instance_id", "device_name")
+
+	spec := &attachmentSpec{
+		volumeID:   attrs.String("volume_id"),
+		instanceID: attrs.String("instance_id"),
+		deviceName: attrs.String("device_name"),
+		timeouts:   parseTimeouts(attrs),
+	}
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// attachmentID identifies an attachment by its volume and instance, since
+// AWS gives attachments no ID of their own
+func attachmentID(volumeID, instanceID string) string {
+	return volumeID + ":" + instanceID
+}
+
+func parseAttachmentID(id string) (volumeID, instanceID string, err error) {
+	volumeID, instanceID, ok := strings.Cut(id, ":")
+	if !ok || volumeID == "" || instanceID == "" {
+		return "", "", fmt.Errorf("invalid volume attachment ID %q: expected <volume-id>:<instance-id>", id)
+	}
+	return volumeID, instanceID, nil
+}
+
+// CreateVolumeAttachment attaches a volume to an instance and waits for the
+// attachment to complete. Attachments cannot be tagged, so instead of
+// recovering one by token a retried create adopts a matching attachment.
+func (c *EC2Client) CreateVolumeAttachment(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseAttachmentSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	volume, err := c.describeVolume(ctx, spec.volumeID)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range volume.Attachments {
+		if aws.ToString(a.InstanceId) != spec.instanceID {
+			return nil, fmt.Errorf("volume %s is already attached to %s", spec.volumeID, aws.ToString(a.InstanceId))
+		}
+		if aws.ToString(a.Device) != spec.deviceName {
+			return nil, fmt.Errorf("volume %s is already attached to %s as %s", spec.volumeID, spec.instanceID, aws.ToString(a.Device))
+		}
+	}
+
+	if len(volume.Attachments) == 0 {
+		_, err := c.client.AttachVolume(ctx, &ec2.AttachVolumeInput{
+			VolumeId:   aws.String(spec.volumeID),
+			InstanceId: aws.String(spec.instanceID),
+			Device:     aws.String(spec.deviceName),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to attach volume %s to %s: %w", spec.volumeID, spec.instanceID, err)
+		}
+	}
+
+	attachment, err := c.waitForAttachment(ctx, spec.volumeID, spec.instanceID, ec2types.VolumeAttachmentStateAttached, spec.timeouts.create)
+	if err != nil {
+		return &types.BaseResource{
+			ID:       attachmentID(spec.volumeID, spec.instanceID),
+			Type:     types.ResourceTypeVolumeAttachment,
+			Provider: "aws",
+			Status:   types.StatusCreating,
+		}, err
+	}
+	return attachmentResource(*attachment), nil
+}
+
+// ReadVolumeAttachment returns the current state of an attachment. A volume
+// no longer attached to the instance is reported as provider.ErrNotFound.
+func (c *EC2Client) ReadVolumeAttachment(ctx context.Context, id string) (*types.BaseResource, error) {
+	volumeID, instanceID, err := parseAttachmentID(id)
+	if err != nil {
+		return nil, err
+	}
+	attachment, err := c.describeAttachment(ctx, volumeID, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return attachmentResource(*attachment), nil
+}
+
+// FindVolumeAttachmentByToken always reports provider.ErrNotFound: an
+// interrupted attach is finished by the next create instead
+func (c *EC2Client) FindVolumeAttachmentByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	return nil, provider.ErrNotFound
+}
+
+// UpdateVolumeAttachment rejects any change; moving a volume means
+// detaching and attaching it again
+func (c *EC2Client) UpdateVolumeAttachment(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseAttachmentSpec(config)
+	if err != nil {
+		return err
+	}
+
+	volumeID, instanceID, err := parseAttachmentID(resource.GetID())
+	if err != nil {
+		return err
+	}
+	attachment, err := c.describeAttachment(ctx, volumeID, instanceID)
+	if err != nil {
+		return err
+	}
+	for attr, values := range map[string][2]string{
+		"volume_id":   {spec.volumeID, volumeID},
+		"instance_id": {spec.instanceID, instanceID},
+		"device_name": {spec.deviceName, aws.ToString(attachment.Device)},
+	} {
+		if values[0] != values[1] {
+			return fmt.Errorf("changing %s of volume attachment %s requires replacing it", attr, resource.GetID())
+		}
+	}
+	return nil
+}
+
+// DeleteVolumeAttachment detaches the volume and waits until it is free.
+// The detach is never forced, so AWS waits for the instance to release the
+// device rather than risk corrupting a mounted filesystem. Volumes already
+// detached, for example because the instance terminated, are left alone.
+func (c *EC2Client) DeleteVolumeAttachment(ctx context.Context, resource provider.Resource) error {
+	volumeID, instanceID, err := parseAttachmentID(resource.GetID())
+	if err != nil {
+		return err
+	}
+
+	_, err = c.client.DetachVolume(ctx, &ec2.DetachVolumeInput{
+		VolumeId:   aws.String(volumeID),
+		InstanceId: aws.String(instanceID),
+	})
+	switch {
+	case isNotFound(err), errorCode(err) == "IncorrectState":
+		// Already detached or detaching; wait below in case it is the latter
+	case err != nil:
+		return fmt.Errorf("failed to detach volume %s from %s: %w", volumeID, instanceID, err)
+	}
+
+	_, err = c.waitForAttachment(ctx, volumeID, instanceID, ec2types.VolumeAttachmentStateDetached, defaultDeleteTimeout)
+	return err
+}
+
+// describeAttachment returns the attachment of a volume to an instance
+func (c *EC2Client) describeAttachment(ctx context.Context, volumeID, instanceID string) (*ec2types.VolumeAttachment, error) {
+	volume, err := c.describeVolume(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range volume.Attachments {
+		if aws.ToString(a.InstanceId) == instanceID && a.State != ec2types.VolumeAttachmentStateDetached {
+			a.VolumeId = volume.VolumeId
+			return &a, nil
+		}
+	}
+	return nil, fmt.Errorf("volume %s attached to %s: %w", volumeID, instanceID, provider.ErrNotFound)
+}
+
+func attachmentResource(attachment ec2types.VolumeAttachment) *types.BaseResource {
+	volumeID := aws.ToString(attachment.VolumeId)
+	instanceID := aws.ToString(attachment.InstanceId)
+	status := types.StatusRunning
+	if attachment.State != ec2types.VolumeAttachmentStateAttached {
+		status = types.StatusUpdating
+	}
+	return &types.BaseResource{
+		ID:       attachmentID(volumeID, instanceID),
+		Type:     types.ResourceTypeVolumeAttachment,
+		Provider: "aws",
+		Status:   status,
+		Metadata: map[string]interface{}{
+			"volume_id":   volumeID,
+			"instance_id": instanceID,
+			"device_name": aws.ToString(attachment.Device),
+			"state":       string(attachment.State),
+		},
+		UpdatedAt: time.Now(),
+	}
+}
@@ -0,0 +1,211 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+func TestVolumes(t *testing.T) {
+	p, ec2Server := newTestProvider(t)
+	ctx := context.Background()
+
+	config := map[string]interface{}{
+		"availability_zone": "us-east-1a",
+		"size":              20,
+		"type":              "gp3",
+		"encrypted":         true,
+		"tags":              map[string]interface{}{"Name": "data"},
+	}
+
+	var volume provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		volume, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-data"), "volume", config)
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+
+		got, ok := ec2Server.Volume(volume.GetID())
+		if !ok {
+			t.Fatalf("Expected volume %s to exist", volume.GetID())
+		}
+		if got.Size != 20 || got.VolumeType != "gp3" || !got.Encrypted || got.KmsKeyID == "" || got.State != "available" {
+			t.Errorf("Unexpected volume attributes: %+v", got)
+		}
+		meta := volume.GetMetadata()
+		if meta["size"] != 20 || meta["iops"] != 3000 || meta["throughput"] != 125 || meta["state"] != "available" {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+
+		again, err := p.Create(provider.WithIdempotencyToken(ctx, "run-1-data"), "volume", config)
+		if err != nil {
+			t.Fatalf("Failed to retry create: %v", err)
+		}
+		if again.GetID() != volume.GetID() {
+			t.Errorf("Expected retried create to return %s, got %s", volume.GetID(), again.GetID())
+		}
+		res, err := p.FindByToken(ctx, "volume", "run-1-data")
+		if err != nil || res.GetID() != volume.GetID() {
+			t.Errorf("Expected to find %s by token, got %v, %v", volume.GetID(), res, err)
+		}
+	})
+
+	t.Run("ModifyInPlace", func(t *testing.T) {
+		updated := map[string]interface{}{
+			"availability_zone": "us-east-1a",
+			"size":              50,
+			"type":              "io2",
+			"iops":              4000,
+			"encrypted":         true,
+			"tags":              map[string]interface{}{"Name": "data", "tier": "db"},
+		}
+		if err := p.Update(ctx, volume, updated); err != nil {
+			t.Fatalf("Failed to update volume: %v", err)
+		}
+		got, _ := ec2Server.Volume(volume.GetID())
+		if got.Size != 50 || got.VolumeType != "io2" || got.Iops != 4000 {
+			t.Errorf("Expected a 50 GiB io2 volume with 4000 IOPS, got %+v", got)
+		}
+		if ec2Server.Calls("ModifyVolume") != 1 {
+			t.Errorf("Expected one ModifyVolume call, got %d", ec2Server.Calls("ModifyVolume"))
+		}
+		if got.Tags["tier"] != "db" {
+			t.Errorf("Expected tier tag, got %v", got.Tags)
+		}
+
+		if err := p.Update(ctx, volume, updated); err != nil {
+			t.Fatalf("Failed to update volume: %v", err)
+		}
+		if ec2Server.Calls("ModifyVolume") != 1 {
+			t.Error("Expected an unchanged volume not to be modified")
+		}
+	})
+
+	t.Run("UpdateErrors", func(t *testing.T) {
+		err := p.Update(ctx, volume, map[string]interface{}{"availability_zone": "us-east-1a", "size": 10, "type": "io2", "iops": 4000, "encrypted": true})
+		if err == nil || !strings.Contains(err.Error(), "cannot shrink") {
+			t.Errorf("Expected shrink error, got %v", err)
+		}
+		err = p.Update(ctx, volume, map[string]interface{}{"availability_zone": "us-east-1b", "size": 50, "type": "io2", "iops": 4000, "encrypted": true})
+		if err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected replacement error, got %v", err)
+		}
+		err = p.Update(ctx, volume, map[string]interface{}{"availability_zone": "us-east-1a", "size": 50, "type": "io2", "iops": 4000})
+		if err == nil || !strings.Contains(err.Error(), "encrypted") {
+			t.Errorf("Expected replacement error for encryption, got %v", err)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		for name, config := range map[string]map[string]interface{}{
+			"MissingZone":     {"size": 10},
+			"MissingSize":     {"availability_zone": "us-east-1a"},
+			"KeyUnencrypted":  {"availability_zone": "us-east-1a", "size": 10, "kms_key_id": "key-1"},
+			"IopsRequired":    {"availability_zone": "us-east-1a", "size": 10, "type": "io1"},
+			"ThroughputOnGp2": {"availability_zone": "us-east-1a", "size": 10, "type": "gp2", "throughput": 250},
+		} {
+			if _, err := p.Create(ctx, "volume", config); err == nil {
+				t.Errorf("%s: expected error, got nil", name)
+			}
+		}
+	})
+
+	var instance, attachment provider.Resource
+	attachConfig := func() map[string]interface{} {
+		return map[string]interface{}{"volume_id": volume.GetID(), "instance_id": instance.GetID(), "device_name": "/dev/sdf"}
+	}
+	t.Run("Attach", func(t *testing.T) {
+		var err error
+		instance, err = p.Create(ctx, "instance", map[string]interface{}{"ami": "ami-12345678", "instance_type": "t3.micro"})
+		if err != nil {
+			t.Fatalf("Failed to create instance: %v", err)
+		}
+
+		attachment, err = p.Create(ctx, "volume_attachment", attachConfig())
+		if err != nil {
+			t.Fatalf("Failed to attach volume: %v", err)
+		}
+		if attachment.GetID() != volume.GetID()+":"+instance.GetID() {
+			t.Errorf("Unexpected attachment ID %s", attachment.GetID())
+		}
+		got, _ := ec2Server.Volume(volume.GetID())
+		if got.Attachment == nil || got.Attachment.InstanceID != instance.GetID() || got.Attachment.State != "attached" {
+			t.Errorf("Expected volume to be attached to %s, got %+v", instance.GetID(), got.Attachment)
+		}
+		if attachment.GetMetadata()["device_name"] != "/dev/sdf" || attachment.GetMetadata()["state"] != "attached" {
+			t.Errorf("Unexpected metadata: %v", attachment.GetMetadata())
+		}
+
+		// Creating it again adopts the existing attachment
+		again, err := p.Create(ctx, "volume_attachment", attachConfig())
+		if err != nil {
+			t.Fatalf("Failed to retry attach: %v", err)
+		}
+		if again.GetID() != attachment.GetID() || ec2Server.Calls("AttachVolume") != 1 {
+			t.Errorf("Expected retried attach to adopt %s", attachment.GetID())
+		}
+
+		moved := attachConfig()
+		moved["device_name"] = "/dev/sdg"
+		if err := p.Update(ctx, attachment, moved); err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected replacement error, got %v", err)
+		}
+		if _, err := p.Create(ctx, "volume_attachment", moved); err == nil {
+			t.Error("Expected error attaching an attached volume at another device, got nil")
+		}
+	})
+
+	t.Run("DeleteWaitsForDetach", func(t *testing.T) {
+		short, cancel := context.WithTimeout(ctx, 0)
+		defer cancel()
+		if err := p.Delete(short, volume); err == nil {
+			t.Error("Expected deleting an attached volume to fail, got nil")
+		}
+
+		if err := p.Delete(ctx, attachment); err != nil {
+			t.Fatalf("Failed to detach volume: %v", err)
+		}
+		got, _ := ec2Server.Volume(volume.GetID())
+		if got.Attachment != nil || got.State != "available" {
+			t.Errorf("Expected volume to be detached, got %+v", got)
+		}
+		if ec2Server.Calls("DetachVolume") != 1 {
+			t.Errorf("Expected one DetachVolume call, got %d", ec2Server.Calls("DetachVolume"))
+		}
+		if _, err := p.Read(ctx, "volume_attachment", attachment.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected detached attachment to be not found, got %v", err)
+		}
+		if err := p.Delete(ctx, attachment); err != nil {
+			t.Errorf("Expected deleting a detached attachment to succeed, got %v", err)
+		}
+	})
+
+	t.Run("DetachedByTermination", func(t *testing.T) {
+		attachment, err := p.Create(ctx, "volume_attachment", attachConfig())
+		if err != nil {
+			t.Fatalf("Failed to attach volume: %v", err)
+		}
+		if err := p.Delete(ctx, instance); err != nil {
+			t.Fatalf("Failed to delete instance: %v", err)
+		}
+		if err := p.Delete(ctx, attachment); err != nil {
+			t.Errorf("Expected deleting the attachment of a terminated instance to succeed, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, volume); err != nil {
+			t.Fatalf("Failed to delete volume: %v", err)
+		}
+		if _, err := p.Read(ctx, "volume", volume.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected deleted volume to be not found, got %v", err)
+		}
+		if err := p.Delete(ctx, volume); err != nil {
+			t.Errorf("Expected deleting a deleted volume to succeed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,921 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// tokenTag holds the idempotency token of the run that created a networking
+// resource. Unlike instances these have no client token, so the tag is how
+// an interrupted create is found again.
+const tokenTag = "duet:token"
+
+// vpcSpec is the desired configuration of a VPC
+type vpcSpec struct {
+	tags               map[string]string
+	cidrBlock          string
+	enableDNSSupport   bool
+	enableDNSHostnames bool
+}
+
+func parseVpcSpec(config map[string]interface{}) (*vpcSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("cidr_block")
+
+	spec := &vpcSpec{
+		cidrBlock:          attrs.String("cidr_block"),
+		enableDNSSupport:   true,
+		enableDNSHostnames: attrs.Bool("enable_dns_hostnames"),
+		tags:               attrs.StringMap("tags"),
+	}
+	if attrs.Has("enable_dns_support") {
+		spec.enableDNSSupport = attrs.Bool("enable_dns_support")
+	}
+
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// CreateVpc creates a VPC. Its main route table and default security group
+// are reported in its metadata.
+func (c *EC2Client) CreateVpc(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseVpcSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.client.CreateVpc(ctx, &ec2.CreateVpcInput{
+		CidrBlock:         aws.String(spec.cidrBlock),
+		TagSpecifications: tagSpecification(ctx, ec2types.ResourceTypeVpc, spec.tags),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VPC: %w", err)
+	}
+
+	// The VPC exists from here on, so it is returned even if a later step
+	// fails, letting the caller track it
+	id := aws.ToString(result.Vpc.VpcId)
+	if err := c.setVpcDNS(ctx, id, spec, true, false); err != nil {
+		return vpcResource(*result.Vpc, nil), err
+	}
+	return c.ReadVpc(ctx, id)
+}
+
+// ReadVpc returns the current state of a VPC
+func (c *EC2Client) ReadVpc(ctx context.Context, id string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{VpcIds: []string{id}})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("VPC %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe VPC %s: %w", id, err)
+	}
+	if len(result.Vpcs) == 0 {
+		return nil, fmt.Errorf("VPC %s: %w", id, provider.ErrNotFound)
+	}
+
+	extra, err := c.vpcDetails(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return vpcResource(result.Vpcs[0], extra), nil
+}
+
+// FindVpcByToken returns the VPC created with the given idempotency token
+func (c *EC2Client) FindVpcByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{Filters: tokenFilter(token)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find VPC by token: %w", err)
+	}
+	if len(result.Vpcs) == 0 {
+		return nil, provider.ErrNotFound
+	}
+	return c.ReadVpc(ctx, aws.ToString(result.Vpcs[0].VpcId))
+}
+
+// UpdateVpc applies DNS setting and tag changes. The CIDR block is fixed at
+// creation.
+func (c *EC2Client) UpdateVpc(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseVpcSpec(config)
+	if err != nil {
+		return err
+	}
+
+	id := resource.GetID()
+	result, err := c.client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{VpcIds: []string{id}})
+	if isNotFound(err) || (err == nil && len(result.Vpcs) == 0) {
+		return fmt.Errorf("VPC %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to describe VPC %s: %w", id, err)
+	}
+	vpc := result.Vpcs[0]
+
+	if spec.cidrBlock != aws.ToString(vpc.CidrBlock) {
+		return fmt.Errorf("changing cidr_block of VPC %s requires replacing it", id)
+	}
+
+	details, err := c.vpcDetails(ctx, id)
+	if err != nil {
+		return err
+	}
+	support, _ := details["enable_dns_support"].(bool)
+	hostnames, _ := details["enable_dns_hostnames"].(bool)
+	if err := c.setVpcDNS(ctx, id, spec, support, hostnames); err != nil {
+		return err
+	}
+
+	return c.updateTags(ctx, id, fromEC2Tags(vpc.Tags), spec.tags)
+}
+
+// DeleteVpc deletes a VPC, waiting out resources inside it that are still
+// being deleted
+func (c *EC2Client) DeleteVpc(ctx context.Context, resource provider.Resource) error {
+	id := resource.GetID()
+	err := c.retryDependencyViolation(ctx, "VPC "+id, func() error {
+		_, err := c.client.DeleteVpc(ctx, &ec2.DeleteVpcInput{VpcId: aws.String(id)})
+		return err
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete VPC %s: %w", id, err)
+	}
+	return nil
+}
+
+// setVpcDNS brings the VPC's DNS attributes from the current values to the
+// spec. Each attribute needs its own call.
+func (c *EC2Client) setVpcDNS(ctx context.Context, id string, spec *vpcSpec, support, hostnames bool) error {
+	if spec.enableDNSSupport != support {
+		_, err := c.client.ModifyVpcAttribute(ctx, &ec2.ModifyVpcAttributeInput{
+			VpcId:            aws.String(id),
+			EnableDnsSupport: &ec2types.AttributeBooleanValue{Value: aws.Bool(spec.enableDNSSupport)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set DNS support of VPC %s: %w", id, err)
+		}
+	}
+	if spec.enableDNSHostnames != hostnames {
+		_, err := c.client.ModifyVpcAttribute(ctx, &ec2.ModifyVpcAttributeInput{
+			VpcId:              aws.String(id),
+			EnableDnsHostnames: &ec2types.AttributeBooleanValue{Value: aws.Bool(spec.enableDNSHostnames)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set DNS hostnames of VPC %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// vpcDetails reads the VPC attributes DescribeVpcs leaves out: its DNS
+// settings, main route table and default security group
+func (c *EC2Client) vpcDetails(ctx context.Context, id string) (map[string]interface{}, error) {
+	details := make(map[string]interface{})
+	for key, attr := range map[string]ec2types.VpcAttributeName{
+		"enable_dns_support":   ec2types.VpcAttributeNameEnableDnsSupport,
+		"enable_dns_hostnames": ec2types.VpcAttributeNameEnableDnsHostnames,
+	} {
+		result, err := c.client.DescribeVpcAttribute(ctx, &ec2.DescribeVpcAttributeInput{
+			VpcId:     aws.String(id),
+			Attribute: attr,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s of VPC %s: %w", attr, id, err)
+		}
+		value := result.EnableDnsSupport
+		if attr == ec2types.VpcAttributeNameEnableDnsHostnames {
+			value = result.EnableDnsHostnames
+		}
+		details[key] = value != nil && aws.ToBool(value.Value)
+	}
+
+	tables, err := c.client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+		Filters: []ec2types.Filter{
+			{Name: aws.String("vpc-id"), Values: []string{id}},
+			{Name: aws.String("association.main"), Values: []string{"true"}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find main route table of VPC %s: %w", id, err)
+	}
+	details["main_route_table_id"] = ""
+	if len(tables.RouteTables) > 0 {
+		details["main_route_table_id"] = aws.ToString(tables.RouteTables[0].RouteTableId)
+	}
+
+	groups, err := c.client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: []ec2types.Filter{
+			{Name: aws.String("vpc-id"), Values: []string{id}},
+			{Name: aws.String("group-name"), Values: []string{"default"}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find default security group of VPC %s: %w", id, err)
+	}
+	details["default_security_group_id"] = ""
+	if len(groups.SecurityGroups) > 0 {
+		details["default_security_group_id"] = aws.ToString(groups.SecurityGroups[0].GroupId)
+	}
+	return details, nil
+}
+
+func vpcResource(vpc ec2types.Vpc, details map[string]interface{}) *types.BaseResource {
+	metadata := map[string]interface{}{
+		"vpc_id":     aws.ToString(vpc.VpcId),
+		"cidr_block": aws.ToString(vpc.CidrBlock),
+		"state":      string(vpc.State),
+	}
+	for k, v := range details {
+		metadata[k] = v
+	}
+	return &types.BaseResource{
+		ID:        aws.ToString(vpc.VpcId),
+		Type:      types.ResourceTypeVPC,
+		Provider:  "aws",
+		Status:    networkStatus(string(vpc.State)),
+		Metadata:  metadata,
+		Tags:      fromEC2Tags(vpc.Tags),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// subnetSpec is the desired configuration of a subnet
+type subnetSpec struct {
+	tags                map[string]string
+	vpcID               string
+	cidrBlock           string
+	availabilityZone    string
+	mapPublicIPOnLaunch bool
+}
+
+func parseSubnetSpec(config map[string]interface{}) (*subnetSpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("vpc_id", "cidr_block")
+
+	spec := &subnetSpec{
+		vpcID:               attrs.String("vpc_id"),
+		cidrBlock:           attrs.String("cidr_block"),
+		availabilityZone:    attrs.String("availability_zone"),
+		mapPublicIPOnLaunch: attrs.Bool("map_public_ip_on_launch"),
+		tags:                attrs.StringMap("tags"),
+	}
+
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// CreateSubnet creates a subnet in a VPC
+func (c *EC2Client) CreateSubnet(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseSubnetSpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &ec2.CreateSubnetInput{
+		VpcId:             aws.String(spec.vpcID),
+		CidrBlock:         aws.String(spec.cidrBlock),
+		TagSpecifications: tagSpecification(ctx, ec2types.ResourceTypeSubnet, spec.tags),
+	}
+	if spec.availabilityZone != "" {
+		input.AvailabilityZone = aws.String(spec.availabilityZone)
+	}
+	result, err := c.client.CreateSubnet(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create subnet: %w", err)
+	}
+
+	subnet := *result.Subnet
+	if spec.mapPublicIPOnLaunch {
+		if err := c.setMapPublicIP(ctx, aws.ToString(subnet.SubnetId), true); err != nil {
+			return subnetResource(subnet), err
+		}
+		subnet.MapPublicIpOnLaunch = aws.Bool(true)
+	}
+	return subnetResource(subnet), nil
+}
+
+// ReadSubnet returns the current state of a subnet
+func (c *EC2Client) ReadSubnet(ctx context.Context, id string) (*types.BaseResource, error) {
+	subnet, err := c.describeSubnet(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return subnetResource(*subnet), nil
+}
+
+// FindSubnetByToken returns the subnet created with the given idempotency
+// token
+func (c *EC2Client) FindSubnetByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{Filters: tokenFilter(token)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find subnet by token: %w", err)
+	}
+	if len(result.Subnets) == 0 {
+		return nil, provider.ErrNotFound
+	}
+	return subnetResource(result.Subnets[0]), nil
+}
+
+// UpdateSubnet applies public IP mapping and tag changes. The VPC, CIDR
+// block and availability zone are fixed at creation.
+func (c *EC2Client) UpdateSubnet(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseSubnetSpec(config)
+	if err != nil {
+		return err
+	}
+
+	id := resource.GetID()
+	subnet, err := c.describeSubnet(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for attr, values := range map[string][2]string{
+		"vpc_id":            {spec.vpcID, aws.ToString(subnet.VpcId)},
+		"cidr_block":        {spec.cidrBlock, aws.ToString(subnet.CidrBlock)},
+		"availability_zone": {spec.availabilityZone, aws.ToString(subnet.AvailabilityZone)},
+	} {
+		if values[0] != "" && values[0] != values[1] {
+			return fmt.Errorf("changing %s of subnet %s requires replacing it", attr, id)
+		}
+	}
+
+	if spec.mapPublicIPOnLaunch != aws.ToBool(subnet.MapPublicIpOnLaunch) {
+		if err := c.setMapPublicIP(ctx, id, spec.mapPublicIPOnLaunch); err != nil {
+			return err
+		}
+	}
+	return c.updateTags(ctx, id, fromEC2Tags(subnet.Tags), spec.tags)
+}
+
+// DeleteSubnet deletes a subnet, waiting out instances and gateways in it
+// that are still being deleted
+func (c *EC2Client) DeleteSubnet(ctx context.Context, resource provider.Resource) error {
+	id := resource.GetID()
+	err := c.retryDependencyViolation(ctx, "subnet "+id, func() error {
+		_, err := c.client.DeleteSubnet(ctx, &ec2.DeleteSubnetInput{SubnetId: aws.String(id)})
+		return err
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete subnet %s: %w", id, err)
+	}
+	return nil
+}
+
+func (c *EC2Client) describeSubnet(ctx context.Context, id string) (*ec2types.Subnet, error) {
+	result, err := c.client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{SubnetIds: []string{id}})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("subnet %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe subnet %s: %w", id, err)
+	}
+	if len(result.Subnets) == 0 {
+		return nil, fmt.Errorf("subnet %s: %w", id, provider.ErrNotFound)
+	}
+	return &result.Subnets[0], nil
+}
+
+func (c *EC2Client) setMapPublicIP(ctx context.Context, id string, enabled bool) error {
+	_, err := c.client.ModifySubnetAttribute(ctx, &ec2.ModifySubnetAttributeInput{
+		SubnetId:            aws.String(id),
+		MapPublicIpOnLaunch: &ec2types.AttributeBooleanValue{Value: aws.Bool(enabled)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set public IP mapping of subnet %s: %w", id, err)
+	}
+	return nil
+}
+
+func subnetResource(subnet ec2types.Subnet) *types.BaseResource {
+	return &types.BaseResource{
+		ID:       aws.ToString(subnet.SubnetId),
+		Type:     types.ResourceTypeSubnet,
+		Provider: "aws",
+		Status:   networkStatus(string(subnet.State)),
+		Metadata: map[string]interface{}{
+			"subnet_id":               aws.ToString(subnet.SubnetId),
+			"vpc_id":                  aws.ToString(subnet.VpcId),
+			"cidr_block":              aws.ToString(subnet.CidrBlock),
+			"availability_zone":       aws.ToString(subnet.AvailabilityZone),
+			"map_public_ip_on_launch": aws.ToBool(subnet.MapPublicIpOnLaunch),
+			"state":                   string(subnet.State),
+		},
+		Tags:      fromEC2Tags(subnet.Tags),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// CreateInternetGateway creates an internet gateway and attaches it to
+// vpc_id, if set
+func (c *EC2Client) CreateInternetGateway(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	attrs := newAttributes(config)
+	vpcID := attrs.String("vpc_id")
+	tags := attrs.StringMap("tags")
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+
+	result, err := c.client.CreateInternetGateway(ctx, &ec2.CreateInternetGatewayInput{
+		TagSpecifications: tagSpecification(ctx, ec2types.ResourceTypeInternetGateway, tags),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create internet gateway: %w", err)
+	}
+
+	gateway := *result.InternetGateway
+	if vpcID != "" {
+		id := aws.ToString(gateway.InternetGatewayId)
+		if err := c.attachInternetGateway(ctx, id, vpcID); err != nil {
+			return internetGatewayResource(gateway), err
+		}
+		gateway.Attachments = []ec2types.InternetGatewayAttachment{{VpcId: aws.String(vpcID)}}
+	}
+	return internetGatewayResource(gateway), nil
+}
+
+// ReadInternetGateway returns the current state of an internet gateway
+func (c *EC2Client) ReadInternetGateway(ctx context.Context, id string) (*types.BaseResource, error) {
+	gateway, err := c.describeInternetGateway(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return internetGatewayResource(*gateway), nil
+}
+
+// FindInternetGatewayByToken returns the internet gateway created with the
+// given idempotency token
+func (c *EC2Client) FindInternetGatewayByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeInternetGateways(ctx, &ec2.DescribeInternetGatewaysInput{Filters: tokenFilter(token)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find internet gateway by token: %w", err)
+	}
+	if len(result.InternetGateways) == 0 {
+		return nil, provider.ErrNotFound
+	}
+	return internetGatewayResource(result.InternetGateways[0]), nil
+}
+
+// UpdateInternetGateway moves the gateway to the configured VPC and applies
+// tag changes
+func (c *EC2Client) UpdateInternetGateway(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	attrs := newAttributes(config)
+	vpcID := attrs.String("vpc_id")
+	tags := attrs.StringMap("tags")
+	if err := attrs.Err(); err != nil {
+		return err
+	}
+
+	id := resource.GetID()
+	gateway, err := c.describeInternetGateway(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if current := attachedVpc(*gateway); current != vpcID {
+		if current != "" {
+			if err := c.detachInternetGateway(ctx, id, current); err != nil {
+				return err
+			}
+		}
+		if vpcID != "" {
+			if err := c.attachInternetGateway(ctx, id, vpcID); err != nil {
+				return err
+			}
+		}
+	}
+	return c.updateTags(ctx, id, fromEC2Tags(gateway.Tags), tags)
+}
+
+// DeleteInternetGateway detaches an internet gateway from its VPC and
+// deletes it
+func (c *EC2Client) DeleteInternetGateway(ctx context.Context, resource provider.Resource) error {
+	id := resource.GetID()
+	gateway, err := c.describeInternetGateway(ctx, id)
+	if err != nil {
+		if errors.Is(err, provider.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if vpcID := attachedVpc(*gateway); vpcID != "" {
+		if err := c.detachInternetGateway(ctx, id, vpcID); err != nil {
+			return err
+		}
+	}
+
+	_, err = c.client.DeleteInternetGateway(ctx, &ec2.DeleteInternetGatewayInput{InternetGatewayId: aws.String(id)})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete internet gateway %s: %w", id, err)
+	}
+	return nil
+}
+
+func (c *EC2Client) describeInternetGateway(ctx context.Context, id string) (*ec2types.InternetGateway, error) {
+	result, err := c.client.DescribeInternetGateways(ctx, &ec2.DescribeInternetGatewaysInput{InternetGatewayIds: []string{id}})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("internet gateway %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe internet gateway %s: %w", id, err)
+	}
+	if len(result.InternetGateways) == 0 {
+		return nil, fmt.Errorf("internet gateway %s: %w", id, provider.ErrNotFound)
+	}
+	return &result.InternetGateways[0], nil
+}
+
+func (c *EC2Client) attachInternetGateway(ctx context.Context, id, vpcID string) error {
+	_, err := c.client.AttachInternetGateway(ctx, &ec2.AttachInternetGatewayInput{
+		InternetGatewayId: aws.String(id),
+		VpcId:             aws.String(vpcID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach internet gateway %s to %s: %w", id, vpcID, err)
+	}
+	return nil
+}
+
+// detachInternetGateway detaches a gateway, waiting out public addresses in
+// the VPC that are still being released
+func (c *EC2Client) detachInternetGateway(ctx context.Context, id, vpcID string) error {
+	err := c.retryDependencyViolation(ctx, "internet gateway "+id, func() error {
+		_, err := c.client.DetachInternetGateway(ctx, &ec2.DetachInternetGatewayInput{
+			InternetGatewayId: aws.String(id),
+			VpcId:             aws.String(vpcID),
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to detach internet gateway %s from %s: %w", id, vpcID, err)
+	}
+	return nil
+}
+
+func attachedVpc(gateway ec2types.InternetGateway) string {
+	for _, a := range gateway.Attachments {
+		if a.State != ec2types.AttachmentStatusDetached && a.State != ec2types.AttachmentStatusDetaching {
+			return aws.ToString(a.VpcId)
+		}
+	}
+	return ""
+}
+
+func internetGatewayResource(gateway ec2types.InternetGateway) *types.BaseResource {
+	return &types.BaseResource{
+		ID:       aws.ToString(gateway.InternetGatewayId),
+		Type:     types.ResourceTypeInternetGateway,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"internet_gateway_id": aws.ToString(gateway.InternetGatewayId),
+			"vpc_id":              attachedVpc(gateway),
+		},
+		Tags:      fromEC2Tags(gateway.Tags),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// CreateElasticIP allocates an Elastic IP address for use in a VPC, for
+// example by a NAT gateway
+func (c *EC2Client) CreateElasticIP(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	attrs := newAttributes(config)
+	tags := attrs.StringMap("tags")
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+
+	result, err := c.client.AllocateAddress(ctx, &ec2.AllocateAddressInput{
+		Domain:            ec2types.DomainTypeVpc,
+		TagSpecifications: tagSpecification(ctx, ec2types.ResourceTypeElasticIp, tags),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate Elastic IP: %w", err)
+	}
+	return c.ReadElasticIP(ctx, aws.ToString(result.AllocationId))
+}
+
+// ReadElasticIP returns the current state of an Elastic IP allocation
+func (c *EC2Client) ReadElasticIP(ctx context.Context, id string) (*types.BaseResource, error) {
+	address, err := c.describeAddress(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return elasticIPResource(*address), nil
+}
+
+// FindElasticIPByToken returns the Elastic IP allocated with the given
+// idempotency token
+func (c *EC2Client) FindElasticIPByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{Filters: tokenFilter(token)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find Elastic IP by token: %w", err)
+	}
+	if len(result.Addresses) == 0 {
+		return nil, provider.ErrNotFound
+	}
+	return elasticIPResource(result.Addresses[0]), nil
+}
+
+// UpdateElasticIP applies tag changes
+func (c *EC2Client) UpdateElasticIP(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	attrs := newAttributes(config)
+	tags := attrs.StringMap("tags")
+	if err := attrs.Err(); err != nil {
+		return err
+	}
+
+	address, err := c.describeAddress(ctx, resource.GetID())
+	if err != nil {
+		return err
+	}
+	return c.updateTags(ctx, resource.GetID(), fromEC2Tags(address.Tags), tags)
+}
+
+// DeleteElasticIP releases an Elastic IP allocation
+func (c *EC2Client) DeleteElasticIP(ctx context.Context, resource provider.Resource) error {
+	_, err := c.client.ReleaseAddress(ctx, &ec2.ReleaseAddressInput{AllocationId: aws.String(resource.GetID())})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to release Elastic IP %s: %w", resource.GetID(), err)
+	}
+	return nil
+}
+
+func (c *EC2Client) describeAddress(ctx context.Context, id string) (*ec2types.Address, error) {
+	result, err := c.client.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{AllocationIds: []string{id}})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("Elastic IP %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe Elastic IP %s: %w", id, err)
+	}
+	if len(result.Addresses) == 0 {
+		return nil, fmt.Errorf("Elastic IP %s: %w", id, provider.ErrNotFound)
+	}
+	return &result.Addresses[0], nil
+}
+
+func elasticIPResource(address ec2types.Address) *types.BaseResource {
+	return &types.BaseResource{
+		ID:       aws.ToString(address.AllocationId),
+		Type:     types.ResourceTypeElasticIP,
+		Provider: "aws",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{
+			"allocation_id": aws.ToString(address.AllocationId),
+			"public_ip":     aws.ToString(address.PublicIp),
+			"domain":        string(address.Domain),
+		},
+		Tags:      fromEC2Tags(address.Tags),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// natGatewaySpec is the desired configuration of a NAT gateway
+type natGatewaySpec struct {
+	tags             map[string]string
+	subnetID         string
+	allocationID     string
+	connectivityType string
+	timeouts         timeouts
+}
+
+func parseNatGatewaySpec(config map[string]interface{}) (*natGatewaySpec, error) {
+	attrs := newAttributes(config)
+	attrs.Require("subnet_id")
+
+	spec := &natGatewaySpec{
+		subnetID:         attrs.String("subnet_id"),
+		allocationID:     attrs.String("allocation_id"),
+		connectivityType: attrs.String("connectivity_type"),
+		tags:             attrs.StringMap("tags"),
+		timeouts:         parseTimeouts(attrs),
+	}
+	if spec.connectivityType == "" {
+		spec.connectivityType = string(ec2types.ConnectivityTypePublic)
+	}
+
+	if err := attrs.Err(); err != nil {
+		return nil, err
+	}
+	switch ec2types.ConnectivityType(spec.connectivityType) {
+	case ec2types.ConnectivityTypePublic:
+		if spec.allocationID == "" {
+			return nil, fmt.Errorf("attribute allocation_id is required for a public NAT gateway")
+		}
+	case ec2types.ConnectivityTypePrivate:
+	default:
+		return nil, fmt.Errorf("attribute connectivity_type: expected public or private, got %q", spec.connectivityType)
+	}
+	return spec, nil
+}
+
+// CreateNatGateway creates a NAT gateway and waits for it to be available,
+// bounded by the create timeout
+func (c *EC2Client) CreateNatGateway(ctx context.Context, config map[string]interface{}) (*types.BaseResource, error) {
+	spec, err := parseNatGatewaySpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &ec2.CreateNatGatewayInput{
+		SubnetId:          aws.String(spec.subnetID),
+		ConnectivityType:  ec2types.ConnectivityType(spec.connectivityType),
+		TagSpecifications: tagSpecification(ctx, ec2types.ResourceTypeNatgateway, spec.tags),
+	}
+	if spec.allocationID != "" {
+		input.AllocationId = aws.String(spec.allocationID)
+	}
+	if token := provider.IdempotencyToken(ctx); token != "" {
+		input.ClientToken = aws.String(token)
+	}
+	result, err := c.client.CreateNatGateway(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create NAT gateway: %w", err)
+	}
+
+	created := *result.NatGateway
+	nat, err := c.waitForNatGateway(ctx, aws.ToString(created.NatGatewayId), ec2types.NatGatewayStateAvailable, spec.timeouts.create)
+	if err != nil {
+		return natGatewayResource(created), err
+	}
+	return natGatewayResource(*nat), nil
+}
+
+// ReadNatGateway returns the current state of a NAT gateway. Deleted
+// gateways are reported as provider.ErrNotFound.
+func (c *EC2Client) ReadNatGateway(ctx context.Context, id string) (*types.BaseResource, error) {
+	nat, err := c.describeNatGateway(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	return natGatewayResource(*nat), nil
+}
+
+// FindNatGatewayByToken returns the live NAT gateway created with the given
+// idempotency token
+func (c *EC2Client) FindNatGatewayByToken(ctx context.Context, token string) (*types.BaseResource, error) {
+	result, err := c.client.DescribeNatGateways(ctx, &ec2.DescribeNatGatewaysInput{Filter: tokenFilter(token)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find NAT gateway by token: %w", err)
+	}
+	for _, nat := range result.NatGateways {
+		if !natGatewayGone(nat) {
+			return natGatewayResource(nat), nil
+		}
+	}
+	return nil, provider.ErrNotFound
+}
+
+// UpdateNatGateway applies tag changes. Everything else is fixed at
+// creation.
+func (c *EC2Client) UpdateNatGateway(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	spec, err := parseNatGatewaySpec(config)
+	if err != nil {
+		return err
+	}
+
+	id := resource.GetID()
+	nat, err := c.describeNatGateway(ctx, id, false)
+	if err != nil {
+		return err
+	}
+
+	allocationID := ""
+	for _, a := range nat.NatGatewayAddresses {
+		if a.AllocationId != nil {
+			allocationID = aws.ToString(a.AllocationId)
+		}
+	}
+	for attr, values := range map[string][2]string{
+		"subnet_id":         {spec.subnetID, aws.ToString(nat.SubnetId)},
+		"allocation_id":     {spec.allocationID, allocationID},
+		"connectivity_type": {spec.connectivityType, string(nat.ConnectivityType)},
+	} {
+		if values[0] != values[1] {
+			return fmt.Errorf("changing %s of NAT gateway %s requires replacing it", attr, id)
+		}
+	}
+	return c.updateTags(ctx, id, fromEC2Tags(nat.Tags), spec.tags)
+}
+
+// DeleteNatGateway deletes a NAT gateway and waits until it is gone, so its
+// subnet and Elastic IP can be deleted next
+func (c *EC2Client) DeleteNatGateway(ctx context.Context, resource provider.Resource) error {
+	id := resource.GetID()
+	_, err := c.client.DeleteNatGateway(ctx, &ec2.DeleteNatGatewayInput{NatGatewayId: aws.String(id)})
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete NAT gateway %s: %w", id, err)
+	}
+	_, err = c.waitForNatGateway(ctx, id, ec2types.NatGatewayStateDeleted, defaultDeleteTimeout)
+	return err
+}
+
+// describeNatGateway returns a NAT gateway. Deleted gateways linger for a
+// while and are reported as not found unless includeDeleted is set.
+func (c *EC2Client) describeNatGateway(ctx context.Context, id string, includeDeleted bool) (*ec2types.NatGateway, error) {
+	result, err := c.client.DescribeNatGateways(ctx, &ec2.DescribeNatGatewaysInput{NatGatewayIds: []string{id}})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("NAT gateway %s: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe NAT gateway %s: %w", id, err)
+	}
+	for _, nat := range result.NatGateways {
+		if aws.ToString(nat.NatGatewayId) == id && (includeDeleted || !natGatewayGone(nat)) {
+			return &nat, nil
+		}
+	}
+	return nil, fmt.Errorf("NAT gateway %s: %w", id, provider.ErrNotFound)
+}
+
+func natGatewayGone(nat ec2types.NatGateway) bool {
+	return nat.State == ec2types.NatGatewayStateDeleted || nat.State == ec2types.NatGatewayStateFailed
+}
+
+func natGatewayResource(nat ec2types.NatGateway) *types.BaseResource {
+	var allocationID, publicIP, privateIP string
+	for _, a := range nat.NatGatewayAddresses {
+		if a.AllocationId != nil {
+			allocationID = aws.ToString(a.AllocationId)
+			publicIP = aws.ToString(a.PublicIp)
+		}
+		if privateIP == "" {
+			privateIP = aws.ToString(a.PrivateIp)
+		}
+	}
+
+	return &types.BaseResource{
+		ID:       aws.ToString(nat.NatGatewayId),
+		Type:     types.ResourceTypeNATGateway,
+		Provider: "aws",
+		Status:   networkStatus(string(nat.State)),
+		Metadata: map[string]interface{}{
+			"nat_gateway_id":    aws.ToString(nat.NatGatewayId),
+			"subnet_id":         aws.ToString(nat.SubnetId),
+			"vpc_id":            aws.ToString(nat.VpcId),
+			"allocation_id":     allocationID,
+			"public_ip":         publicIP,
+			"private_ip":        privateIP,
+			"connectivity_type": string(nat.ConnectivityType),
+			"state":             string(nat.State),
+		},
+		Tags:      fromEC2Tags(nat.Tags),
+		CreatedAt: aws.ToTime(nat.CreateTime),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// networkStatus maps the states shared by networking resources
+func networkStatus(state string) types.ResourceStatus {
+	switch state {
+	case "pending":
+		return types.StatusCreating
+	case "available":
+		return types.StatusRunning
+	case "deleting":
+		return types.StatusDeleting
+	case "deleted":
+		return types.StatusDeleted
+	case "failed":
+		return types.StatusFailed
+	default:
+		return types.StatusUnavailable
+	}
+}
+
+// tagSpecification tags a new resource with the configured tags and, when
+// the context carries one, the idempotency token
+func tagSpecification(ctx context.Context, resourceType ec2types.ResourceType, tags map[string]string) []ec2types.TagSpecification {
+	all := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		all[k] = v
+	}
+	if token := provider.IdempotencyToken(ctx); token != "" {
+		all[tokenTag] = token
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	return []ec2types.TagSpecification{{ResourceType: resourceType, Tags: toEC2Tags(all)}}
+}
+
+// tokenFilter matches resources created with the given idempotency token
+func tokenFilter(token string) []ec2types.Filter {
+	return []ec2types.Filter{{Name: aws.String("tag:" + tokenTag), Values: []string{token}}}
+}
@@ -0,0 +1,238 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+func TestVpcs(t *testing.T) {
+	p, ec2Server := newTestProvider(t)
+	ctx := context.Background()
+
+	var vpc, subnet provider.Resource
+	t.Run("CreateVpc", func(t *testing.T) {
+		var err error
+		vpc, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-vpc"), "vpc", map[string]interface{}{
+			"cidr_block":           "10.0.0.0/16",
+			"enable_dns_hostnames": true,
+			"tags":                 map[string]interface{}{"Name": "main"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create vpc: %v", err)
+		}
+
+		got, ok := ec2Server.VPC(vpc.GetID())
+		if !ok {
+			t.Fatalf("Expected vpc %s to exist", vpc.GetID())
+		}
+		if got.CidrBlock != "10.0.0.0/16" || !got.EnableDNSSupport || !got.EnableDNSHostnames {
+			t.Errorf("Unexpected vpc attributes: %+v", got)
+		}
+		if got.Tags["Name"] != "main" || got.Tags[tokenTag] != "run-1-vpc" {
+			t.Errorf("Expected Name and token tags, got %v", got.Tags)
+		}
+
+		meta := vpc.GetMetadata()
+		if vpc.GetType() != types.ResourceTypeVPC || meta["vpc_id"] != vpc.GetID() || meta["cidr_block"] != "10.0.0.0/16" {
+			t.Errorf("Unexpected resource: %s %v", vpc.GetType(), meta)
+		}
+		if meta["main_route_table_id"] == "" || meta["default_security_group_id"] == "" {
+			t.Errorf("Expected main route table and default security group in metadata, got %v", meta)
+		}
+	})
+
+	t.Run("FindVpcByToken", func(t *testing.T) {
+		res, err := p.FindByToken(ctx, "vpc", "run-1-vpc")
+		if err != nil {
+			t.Fatalf("Failed to find vpc by token: %v", err)
+		}
+		if res.GetID() != vpc.GetID() {
+			t.Errorf("Expected %s, got %s", vpc.GetID(), res.GetID())
+		}
+		if _, err := p.FindByToken(ctx, "vpc", "unknown"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("UpdateVpc", func(t *testing.T) {
+		err := p.Update(ctx, vpc, map[string]interface{}{
+			"cidr_block":           "10.0.0.0/16",
+			"enable_dns_hostnames": false,
+			"tags":                 map[string]interface{}{"Name": "primary"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to update vpc: %v", err)
+		}
+		got, _ := ec2Server.VPC(vpc.GetID())
+		if got.EnableDNSHostnames {
+			t.Error("Expected DNS hostnames to be disabled")
+		}
+		if got.Tags["Name"] != "primary" || got.Tags[tokenTag] != "run-1-vpc" {
+			t.Errorf("Expected Name tag to change and token tag to stay, got %v", got.Tags)
+		}
+
+		err = p.Update(ctx, vpc, map[string]interface{}{"cidr_block": "10.1.0.0/16"})
+		if err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected replacement error, got %v", err)
+		}
+	})
+
+	t.Run("CreateSubnet", func(t *testing.T) {
+		var err error
+		subnet, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-subnet"), "subnet", map[string]interface{}{
+			"vpc_id":                  vpc.GetID(),
+			"cidr_block":              "10.0.1.0/24",
+			"availability_zone":       "us-east-1a",
+			"map_public_ip_on_launch": true,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create subnet: %v", err)
+		}
+		got, ok := ec2Server.Subnet(subnet.GetID())
+		if !ok {
+			t.Fatalf("Expected subnet %s to exist", subnet.GetID())
+		}
+		if got.VpcID != vpc.GetID() || got.CidrBlock != "10.0.1.0/24" || got.AvailabilityZone != "us-east-1a" || !got.MapPublicIPOnLaunch {
+			t.Errorf("Unexpected subnet attributes: %+v", got)
+		}
+
+		if _, err := p.Create(ctx, "subnet", map[string]interface{}{"vpc_id": vpc.GetID(), "cidr_block": "10.0.1.128/25"}); err == nil {
+			t.Error("Expected error for overlapping subnet, got nil")
+		}
+		if _, err := p.Create(ctx, "subnet", map[string]interface{}{"cidr_block": "10.0.2.0/24"}); err == nil {
+			t.Error("Expected error for missing vpc_id, got nil")
+		}
+	})
+
+	t.Run("UpdateSubnet", func(t *testing.T) {
+		err := p.Update(ctx, subnet, map[string]interface{}{
+			"vpc_id":     vpc.GetID(),
+			"cidr_block": "10.0.1.0/24",
+			"tags":       map[string]interface{}{"tier": "public"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to update subnet: %v", err)
+		}
+		got, _ := ec2Server.Subnet(subnet.GetID())
+		if got.MapPublicIPOnLaunch {
+			t.Error("Expected map_public_ip_on_launch to be turned off")
+		}
+		if got.Tags["tier"] != "public" {
+			t.Errorf("Expected tier tag, got %v", got.Tags)
+		}
+
+		err = p.Update(ctx, subnet, map[string]interface{}{"vpc_id": vpc.GetID(), "cidr_block": "10.0.9.0/24"})
+		if err == nil || !strings.Contains(err.Error(), "requires replacing") {
+			t.Errorf("Expected replacement error, got %v", err)
+		}
+	})
+
+	var gateway provider.Resource
+	t.Run("InternetGateway", func(t *testing.T) {
+		var err error
+		gateway, err = p.Create(ctx, "internet_gateway", map[string]interface{}{"vpc_id": vpc.GetID()})
+		if err != nil {
+			t.Fatalf("Failed to create internet gateway: %v", err)
+		}
+		got, _ := ec2Server.InternetGateway(gateway.GetID())
+		if got.VpcID != vpc.GetID() {
+			t.Errorf("Expected gateway attached to %s, got %q", vpc.GetID(), got.VpcID)
+		}
+
+		if err := p.Update(ctx, gateway, map[string]interface{}{}); err != nil {
+			t.Fatalf("Failed to detach internet gateway: %v", err)
+		}
+		got, _ = ec2Server.InternetGateway(gateway.GetID())
+		if got.VpcID != "" {
+			t.Errorf("Expected gateway to be detached, got %q", got.VpcID)
+		}
+		if err := p.Update(ctx, gateway, map[string]interface{}{"vpc_id": vpc.GetID()}); err != nil {
+			t.Fatalf("Failed to attach internet gateway: %v", err)
+		}
+		res, err := p.Read(ctx, "internet_gateway", gateway.GetID())
+		if err != nil {
+			t.Fatalf("Failed to read internet gateway: %v", err)
+		}
+		if res.GetMetadata()["vpc_id"] != vpc.GetID() {
+			t.Errorf("Expected vpc_id %s in metadata, got %v", vpc.GetID(), res.GetMetadata())
+		}
+	})
+
+	var eip, nat provider.Resource
+	t.Run("NatGateway", func(t *testing.T) {
+		var err error
+		eip, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-eip"), "eip", map[string]interface{}{})
+		if err != nil {
+			t.Fatalf("Failed to allocate elastic ip: %v", err)
+		}
+		if eip.GetMetadata()["public_ip"] == "" {
+			t.Errorf("Expected a public ip, got %v", eip.GetMetadata())
+		}
+
+		config := map[string]interface{}{"subnet_id": subnet.GetID(), "allocation_id": eip.GetID()}
+		nat, err = p.Create(provider.WithIdempotencyToken(ctx, "run-1-nat"), "nat_gateway", config)
+		if err != nil {
+			t.Fatalf("Failed to create NAT gateway: %v", err)
+		}
+		if nat.GetStatus() != types.StatusRunning || nat.GetMetadata()["state"] != "available" {
+			t.Errorf("Expected NAT gateway to be available, got %s %v", nat.GetStatus(), nat.GetMetadata())
+		}
+		if nat.GetMetadata()["public_ip"] != eip.GetMetadata()["public_ip"] {
+			t.Errorf("Expected NAT gateway to use %v, got %v", eip.GetMetadata()["public_ip"], nat.GetMetadata()["public_ip"])
+		}
+
+		again, err := p.Create(provider.WithIdempotencyToken(ctx, "run-1-nat"), "nat_gateway", config)
+		if err != nil {
+			t.Fatalf("Failed to retry NAT gateway create: %v", err)
+		}
+		if again.GetID() != nat.GetID() {
+			t.Errorf("Expected retried create to return %s, got %s", nat.GetID(), again.GetID())
+		}
+		if _, err := p.Create(ctx, "nat_gateway", map[string]interface{}{"subnet_id": subnet.GetID()}); err == nil {
+			t.Error("Expected error for public NAT gateway without allocation_id, got nil")
+		}
+	})
+
+	t.Run("DeleteWaitsForDependants", func(t *testing.T) {
+		inst, err := p.Create(ctx, "instance", map[string]interface{}{
+			"ami": "ami-12345678", "instance_type": "t3.micro", "subnet_id": subnet.GetID(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create instance: %v", err)
+		}
+
+		short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		err = p.Delete(short, subnet)
+		if err == nil || !strings.Contains(err.Error(), "DependencyViolation") {
+			t.Errorf("Expected dependency violation while the instance is running, got %v", err)
+		}
+
+		if err := p.Delete(ctx, inst); err != nil {
+			t.Fatalf("Failed to delete instance: %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		for _, res := range []provider.Resource{nat, eip, subnet, gateway, vpc} {
+			if err := p.Delete(ctx, res); err != nil {
+				t.Fatalf("Failed to delete %s %s: %v", res.GetType(), res.GetID(), err)
+			}
+			if _, err := p.Read(ctx, string(res.GetType()), res.GetID()); !errors.Is(err, provider.ErrNotFound) {
+				t.Errorf("Expected deleted %s to be not found, got %v", res.GetType(), err)
+			}
+		}
+		if got, _ := ec2Server.NatGateway(nat.GetID()); got.State != "deleted" {
+			t.Errorf("Expected NAT gateway %s to be deleted, got %q", nat.GetID(), got.State)
+		}
+		if err := p.Delete(ctx, vpc); err != nil {
+			t.Errorf("Expected deleting a deleted vpc to succeed, got %v", err)
+		}
+	})
+}
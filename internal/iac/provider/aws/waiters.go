@@ -0,0 +1,312 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+// Default timeouts, overridable per resource with a timeouts table
+const (
+	defaultCreateTimeout = 10 * time.Minute
+	defaultUpdateTimeout = 10 * time.Minute
+)
+
+// defaultDeleteTimeout bounds waiting for a deletion to finish. Deletes are
+// given no config, so unlike the others it cannot be overridden.
+const defaultDeleteTimeout = 10 * time.Minute
+
+// timeouts bounds how long an operation waits for a resource to settle
+type timeouts struct {
+	create time.Duration
+	update time.Duration
+}
+
+// parseTimeouts reads the optional timeouts table, e.g.
+// timeouts = { create = "15m", update = "5m" }
+func parseTimeouts(attrs *attributes) timeouts {
+	table := attrs.Table("timeouts")
+	t := timeouts{
+		create: table.Duration("create"),
+		update: table.Duration("update"),
+	}
+	attrs.collectTable("timeouts", table)
+
+	if t.create <= 0 {
+		t.create = defaultCreateTimeout
+	}
+	if t.update <= 0 {
+		t.update = defaultUpdateTimeout
+	}
+	return t
+}
+
+// readiness describes when a launched instance is considered usable
+type readiness struct {
+	statusChecks bool
+	publicIP     bool
+}
+
+// waitUntilReady polls until the instance is running, has the IP address
+// config management needs and, unless disabled, passes its status checks.
+// It fails early if the instance stops or terminates while waiting.
+func (c *EC2Client) waitUntilReady(ctx context.Context, id string, want readiness, timeout time.Duration) (*ec2types.Instance, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	waiting := "instance to be described"
+	for {
+		instance, err := c.describeInstance(ctx, id)
+		switch {
+		case err == nil:
+			var ready bool
+			ready, waiting, err = c.instanceReady(ctx, instance, want)
+			if err != nil {
+				return nil, err
+			}
+			if ready {
+				return instance, nil
+			}
+		case errors.Is(err, provider.ErrNotFound) && ctx.Err() == nil:
+			// A new instance may not be visible to DescribeInstances yet
+		case ctx.Err() == nil:
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out after %s waiting for %s (instance %s)", timeout, waiting, id)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// instanceReady reports whether instance satisfies want, and if not what it
+// is still waiting for
+func (c *EC2Client) instanceReady(ctx context.Context, instance *ec2types.Instance, want readiness) (bool, string, error) {
+	id := aws.ToString(instance.InstanceId)
+
+	state := ec2types.InstanceStateNamePending
+	if instance.State != nil {
+		state = instance.State.Name
+	}
+	switch state {
+	case ec2types.InstanceStateNameRunning:
+	case ec2types.InstanceStateNamePending:
+		return false, "instance to be running", nil
+	default:
+		return false, "", fmt.Errorf("instance %s entered state %s while waiting for it to be running", id, state)
+	}
+
+	if want.publicIP && aws.ToString(instance.PublicIpAddress) == "" {
+		return false, "a public IP address", nil
+	}
+	if aws.ToString(instance.PrivateIpAddress) == "" && aws.ToString(instance.PublicIpAddress) == "" {
+		return false, "an IP address", nil
+	}
+
+	if !want.statusChecks {
+		return true, "", nil
+	}
+	result, err := c.client.DescribeInstanceStatus(ctx, &ec2.DescribeInstanceStatusInput{
+		InstanceIds: []string{id},
+	})
+	if err != nil {
+		return false, "", fmt.Errorf("failed to read status checks of %s: %w", id, err)
+	}
+	for _, s := range result.InstanceStatuses {
+		if aws.ToString(s.InstanceId) != id {
+			continue
+		}
+		if s.InstanceStatus != nil && s.InstanceStatus.Status == ec2types.SummaryStatusOk &&
+			s.SystemStatus != nil && s.SystemStatus.Status == ec2types.SummaryStatusOk {
+			return true, "", nil
+		}
+	}
+	return false, "status checks to pass", nil
+}
+
+// waitForState polls until the instance reaches the given state
+func (c *EC2Client) waitForState(ctx context.Context, id string, want ec2types.InstanceStateName, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		instance, err := c.describeInstance(ctx, id)
+		if err != nil {
+			return err
+		}
+		if instance.State != nil && instance.State.Name == want {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for instance %s to be %s", timeout, id, want)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// waitForNatGateway polls until the NAT gateway reaches the given state. A
+// gateway that fails to provision is an error straight away. Waiting for
+// deleted also ends if the gateway can no longer be found.
+func (c *EC2Client) waitForNatGateway(ctx context.Context, id string, want ec2types.NatGatewayState, timeout time.Duration) (*ec2types.NatGateway, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		nat, err := c.describeNatGateway(ctx, id, true)
+		switch {
+		case errors.Is(err, provider.ErrNotFound) && want == ec2types.NatGatewayStateDeleted:
+			return nil, nil
+		case err != nil:
+			return nil, err
+		case nat.State == want:
+			return nat, nil
+		case nat.State == ec2types.NatGatewayStateFailed:
+			return nil, fmt.Errorf("NAT gateway %s failed: %s", id, aws.ToString(nat.FailureMessage))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out after %s waiting for NAT gateway %s to be %s", timeout, id, want)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// waitForVolume polls until the volume reaches the given state. A volume
+// that fails is an error straight away; waiting for deleted also ends once
+// the volume can no longer be found. An attached volume counts as
+// available.
+func (c *EC2Client) waitForVolume(ctx context.Context, id string, want ec2types.VolumeState, timeout time.Duration) (*ec2types.Volume, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		volume, err := c.describeVolume(ctx, id)
+		switch {
+		case errors.Is(err, provider.ErrNotFound) && want == ec2types.VolumeStateDeleted:
+			return nil, nil
+		case err != nil:
+			return nil, err
+		case volume.State == want,
+			want == ec2types.VolumeStateAvailable && volume.State == ec2types.VolumeStateInUse:
+			return volume, nil
+		case volume.State == ec2types.VolumeStateError:
+			return nil, fmt.Errorf("volume %s failed", id)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out after %s waiting for volume %s to be %s", timeout, id, want)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// waitForVolumeModification polls until the latest modification of a volume
+// has taken effect. Volumes are usable at their new size and performance
+// while AWS is still optimizing them, so that is as long as it waits.
+func (c *EC2Client) waitForVolumeModification(ctx context.Context, id string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		result, err := c.client.DescribeVolumesModifications(ctx, &ec2.DescribeVolumesModificationsInput{VolumeIds: []string{id}})
+		if err != nil {
+			return fmt.Errorf("failed to describe modifications of volume %s: %w", id, err)
+		}
+		var latest *ec2types.VolumeModification
+		for i, m := range result.VolumesModifications {
+			if latest == nil || aws.ToTime(m.StartTime).After(aws.ToTime(latest.StartTime)) {
+				latest = &result.VolumesModifications[i]
+			}
+		}
+		if latest != nil {
+			switch latest.ModificationState {
+			case ec2types.VolumeModificationStateOptimizing, ec2types.VolumeModificationStateCompleted:
+				return nil
+			case ec2types.VolumeModificationStateFailed:
+				return fmt.Errorf("modifying volume %s failed: %s", id, aws.ToString(latest.StatusMessage))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for volume %s to be modified", timeout, id)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// waitForAttachment polls until the volume's attachment to the instance
+// reaches the given state. Waiting for detached ends once the attachment is
+// gone; waiting for attached fails if it disappears.
+func (c *EC2Client) waitForAttachment(ctx context.Context, volumeID, instanceID string, want ec2types.VolumeAttachmentState, timeout time.Duration) (*ec2types.VolumeAttachment, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		attachment, err := c.describeAttachment(ctx, volumeID, instanceID)
+		switch {
+		case errors.Is(err, provider.ErrNotFound) && want == ec2types.VolumeAttachmentStateDetached:
+			return nil, nil
+		case errors.Is(err, provider.ErrNotFound):
+			return nil, fmt.Errorf("volume %s was detached from %s while waiting for it to be %s", volumeID, instanceID, want)
+		case err != nil:
+			return nil, err
+		case attachment.State == want:
+			return attachment, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out after %s waiting for volume %s on %s to be %s", timeout, volumeID, instanceID, want)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// retryDependencyViolation retries a delete that AWS refuses while resources
+// depending on it are still going away, such as a subnet whose instances are
+// still terminating
+func (c *EC2Client) retryDependencyViolation(ctx context.Context, what string, del func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultDeleteTimeout)
+	defer cancel()
+
+	var violation error
+	for {
+		err := del()
+		if err != nil && violation != nil && ctx.Err() != nil {
+			// Report what was being waited for rather than the cancelled call
+			err = violation
+		}
+		if err == nil || !isDependencyViolation(err) {
+			return err
+		}
+		violation = err
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for resources using %s to be deleted: %w", defaultDeleteTimeout, what, err)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// isDependencyViolation reports whether AWS refused a delete because
+// something still uses the resource. EBS reports an attached volume as
+// VolumeInUse rather than DependencyViolation.
+func isDependencyViolation(err error) bool {
+	code := errorCode(err)
+	return code == "DependencyViolation" || code == "VolumeInUse"
+}
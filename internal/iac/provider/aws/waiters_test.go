@@ -0,0 +1,114 @@
+package aws
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestInstanceWaiters(t *testing.T) {
+	ctx := context.Background()
+	base := func(extra map[string]interface{}) map[string]interface{} {
+		config := map[string]interface{}{"ami": "ami-1", "instance_type": "t3.micro"}
+		for k, v := range extra {
+			config[k] = v
+		}
+		return config
+	}
+
+	t.Run("WaitsUntilReady", func(t *testing.T) {
+		p, ec2Server := newTestProvider(t)
+		ec2Server.SetLaunchDelay(3)
+
+		res, err := p.Create(ctx, "instance", base(nil))
+		if err != nil {
+			t.Fatalf("Failed to create instance: %v", err)
+		}
+
+		meta := res.GetMetadata()
+		if meta["state"] != "running" {
+			t.Errorf("Expected instance to be running, got %v", meta["state"])
+		}
+		if meta["public_ip"] == "" || meta["private_ip"] == "" {
+			t.Errorf("Expected IP addresses in metadata, got %v", meta)
+		}
+		if meta["host"] != meta["public_ip"] {
+			t.Errorf("Expected host to be the public IP, got %v", meta["host"])
+		}
+		if n := ec2Server.Calls("DescribeInstanceStatus"); n != 4 {
+			t.Errorf("Expected status checks to be polled 4 times, got %d", n)
+		}
+	})
+
+	t.Run("StatusChecksDisabled", func(t *testing.T) {
+		p, ec2Server := newTestProvider(t)
+		ec2Server.SetLaunchDelay(2)
+
+		res, err := p.Create(ctx, "instance", base(map[string]interface{}{"wait_for_status_checks": false}))
+		if err != nil {
+			t.Fatalf("Failed to create instance: %v", err)
+		}
+		if res.GetMetadata()["state"] != "running" {
+			t.Errorf("Expected instance to be running, got %v", res.GetMetadata()["state"])
+		}
+		if n := ec2Server.Calls("DescribeInstanceStatus"); n != 0 {
+			t.Errorf("Expected no status check polls, got %d", n)
+		}
+	})
+
+	t.Run("PrivateHost", func(t *testing.T) {
+		p, _ := newTestProvider(t)
+
+		res, err := p.Create(ctx, "instance", base(map[string]interface{}{"subnet_id": "subnet-private"}))
+		if err != nil {
+			t.Fatalf("Failed to create instance: %v", err)
+		}
+		meta := res.GetMetadata()
+		if meta["public_ip"] != "" || meta["host"] != meta["private_ip"] {
+			t.Errorf("Expected host to be the private IP, got %v", meta)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		p, ec2Server := newTestProvider(t)
+		ec2Server.SetLaunchDelay(1_000_000)
+
+		res, err := p.Create(ctx, "instance", base(map[string]interface{}{
+			"timeouts": map[string]interface{}{"create": "20ms"},
+		}))
+		if err == nil || !strings.Contains(err.Error(), "timed out after 20ms waiting for instance to be running") {
+			t.Fatalf("Expected timeout error, got %v", err)
+		}
+		// The instance was launched, so it is still returned for tracking
+		if res == nil || res.GetID() == "" {
+			t.Fatal("Expected the launched instance to be returned")
+		}
+		if _, ok := ec2Server.Instance(res.GetID()); !ok {
+			t.Errorf("Expected instance %s to exist", res.GetID())
+		}
+	})
+
+	t.Run("PublicIPTimeout", func(t *testing.T) {
+		p, _ := newTestProvider(t)
+
+		_, err := p.Create(ctx, "instance", base(map[string]interface{}{
+			"subnet_id":          "subnet-private",
+			"wait_for_public_ip": true,
+			"timeouts":           map[string]interface{}{"create": "20ms"},
+		}))
+		if err == nil || !strings.Contains(err.Error(), "a public IP address") {
+			t.Errorf("Expected public IP timeout, got %v", err)
+		}
+	})
+
+	t.Run("InvalidTimeout", func(t *testing.T) {
+		p, _ := newTestProvider(t)
+
+		_, err := p.Create(ctx, "instance", base(map[string]interface{}{
+			"timeouts": map[string]interface{}{"create": "soon"},
+		}))
+		if err == nil || !strings.Contains(err.Error(), "timeouts") {
+			t.Errorf("Expected invalid timeout error, got %v", err)
+		}
+	})
+}
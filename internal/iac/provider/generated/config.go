@@ -0,0 +1,168 @@
+package generated
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+// Config reads typed values from a resource config, falling back to the
+// defaults in its schema. Config values come from Lua or from JSON in
+// state, so numbers may be int or float64. The first problem is kept and
+// reported by Err.
+type Config struct {
+	schema *provider.ResourceSchema
+	values map[string]interface{}
+	err    error
+}
+
+// NewConfig returns a reader of config for a resource described by schema
+func NewConfig(schema *provider.ResourceSchema, config map[string]interface{}) *Config {
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	return &Config{schema: schema, values: config}
+}
+
+// Err returns the first error encountered while reading values
+func (c *Config) Err() error {
+	return c.err
+}
+
+// Has reports whether key is set in the config itself
+func (c *Config) Has(key string) bool {
+	v, ok := c.values[key]
+	return ok && v != nil
+}
+
+// value returns the value at key or its default, nil if it has neither
+func (c *Config) value(key string) interface{} {
+	if v, ok := c.values[key]; ok && v != nil {
+		return v
+	}
+	if attr, ok := c.schema.Attributes[key]; ok {
+		return attr.Default
+	}
+	return nil
+}
+
+func (c *Config) fail(key string, want string, got interface{}) {
+	if c.err == nil {
+		c.err = fmt.Errorf("attribute %s: expected %s, got %v", key, want, got)
+	}
+}
+
+// Require records an error for each key that is not set
+func (c *Config) Require(keys ...string) {
+	for _, key := range keys {
+		if c.value(key) == nil && c.err == nil {
+			c.err = fmt.Errorf("attribute %s is required", key)
+		}
+	}
+}
+
+// String returns the string at key, or "" if unset
+func (c *Config) String(key string) string {
+	v := c.value(key)
+	if v == nil {
+		return ""
+	}
+	s, ok := v.(string)
+	if !ok {
+		c.fail(key, "a string", v)
+	}
+	return s
+}
+
+// Int returns the whole number at key, or 0 if unset
+func (c *Config) Int(key string) int {
+	switch n := c.value(key).(type) {
+	case nil:
+		return 0
+	case int:
+		return n
+	case float64:
+		if n == math.Trunc(n) {
+			return int(n)
+		}
+	}
+	c.fail(key, "a whole number", c.value(key))
+	return 0
+}
+
+// Bool returns the boolean at key, or false if unset
+func (c *Config) Bool(key string) bool {
+	v := c.value(key)
+	if v == nil {
+		return false
+	}
+	b, ok := v.(bool)
+	if !ok {
+		c.fail(key, "a boolean", v)
+	}
+	return b
+}
+
+// Duration returns the duration at key, given as a string such as "24h" or
+// a number of seconds, or 0 if unset
+func (c *Config) Duration(key string) time.Duration {
+	v := c.value(key)
+	if s, ok := v.(string); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			c.fail(key, "a duration", v)
+		}
+		return d
+	}
+	return time.Duration(c.Int(key)) * time.Second
+}
+
+// StringList returns the list of strings at key, or nil if unset. An empty
+// table decodes as a map, so it is accepted as an empty list.
+func (c *Config) StringList(key string) []string {
+	switch list := c.value(key).(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				c.fail(key, "a list of strings", list)
+				return nil
+			}
+			out = append(out, s)
+		}
+		return out
+	case map[string]interface{}:
+		if len(list) == 0 {
+			return nil
+		}
+	}
+	c.fail(key, "a list of strings", c.value(key))
+	return nil
+}
+
+// Object returns a reader of the table at key, described by the attribute
+// of the same name in the schema. An unset table reads as empty. Problems
+// inside the table are reported by the returned reader's Err.
+func (c *Config) Object(key string) *Config {
+	attr := c.schema.Attributes[key]
+	object := &Config{schema: &provider.ResourceSchema{}}
+	if attr != nil {
+		object.schema.Attributes = attr.Attributes
+	}
+	switch v := c.value(key).(type) {
+	case nil:
+	case map[string]interface{}:
+		object.values = v
+	default:
+		c.fail(key, "a table", v)
+	}
+	if object.values == nil {
+		object.values = map[string]interface{}{}
+	}
+	return object
+}
@@ -0,0 +1,65 @@
+package generated
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+func TestConfig(t *testing.T) {
+	schema := &provider.ResourceSchema{Attributes: map[string]*provider.Attribute{
+		"length":   {Type: provider.TypeInt, Default: 16},
+		"upper":    {Type: provider.TypeBool, Default: true},
+		"validity": {Type: provider.TypeDuration, Default: "24h"},
+		"names":    {Type: provider.TypeList, Elem: &provider.Attribute{Type: provider.TypeString}},
+		"subject": {Type: provider.TypeObject, Attributes: map[string]*provider.Attribute{
+			"country": {Type: provider.TypeString, Default: "NZ"},
+		}},
+	}}
+
+	t.Run("Values", func(t *testing.T) {
+		c := NewConfig(schema, map[string]interface{}{
+			"length":  float64(20),
+			"names":   []interface{}{"a", "b"},
+			"subject": map[string]interface{}{},
+		})
+		if c.Int("length") != 20 || !c.Bool("upper") || c.Duration("validity") != 24*time.Hour {
+			t.Errorf("Expected values and defaults, got %d, %v, %v", c.Int("length"), c.Bool("upper"), c.Duration("validity"))
+		}
+		if got := c.StringList("names"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+			t.Errorf("Expected [a b], got %v", got)
+		}
+		if got := c.Object("subject").String("country"); got != "NZ" {
+			t.Errorf("Expected the default country, got %q", got)
+		}
+		if c.Has("upper") || !c.Has("length") {
+			t.Error("Expected Has to report only values set in the config")
+		}
+		if err := c.Err(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("Seconds", func(t *testing.T) {
+		if d := NewConfig(schema, map[string]interface{}{"validity": 90}).Duration("validity"); d != 90*time.Second {
+			t.Errorf("Expected 90s, got %v", d)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		c := NewConfig(schema, map[string]interface{}{"length": 1.5, "upper": "yes"})
+		c.Int("length")
+		c.Bool("upper")
+		if err := c.Err(); err == nil || err.Error() != "attribute length: expected a whole number, got 1.5" {
+			t.Errorf("Expected the first error, got %v", err)
+		}
+
+		c = NewConfig(schema, nil)
+		c.Require("names")
+		if err := c.Err(); err == nil || err.Error() != "attribute names is required" {
+			t.Errorf("Expected a required error, got %v", err)
+		}
+	})
+}
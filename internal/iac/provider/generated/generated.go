@@ -0,0 +1,281 @@
+// Package generated implements providers whose resources are values made up
+// by duet, such as random passwords and TLS keys. Those values exist nowhere
+// but in state, so the providers read them back from the state store, with
+// their sensitive attributes opened, rather than from an API.
+package generated
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// Lookup returns the attributes of another resource of the same provider,
+// such as the key a certificate is signed with, sensitive ones included
+type Lookup func(ctx context.Context, resourceType, id string) (map[string]interface{}, error)
+
+// Generator makes the values of one resource type
+type Generator struct {
+	// Schema describes the resource type. Its attributes that are not
+	// computed are the inputs the value is generated from.
+	Schema *provider.ResourceSchema
+	// Generate returns the computed attributes of a new value for config
+	Generate func(ctx context.Context, config map[string]interface{}, lookup Lookup) (map[string]interface{}, error)
+	// Validate optionally checks what the schema cannot
+	Validate func(config map[string]interface{}) error
+}
+
+// Provider keeps generated values in state. A value is generated when its
+// resource is created and kept until one of its inputs changes, such as its
+// keepers: a table of arbitrary values whose only purpose is to be watched.
+type Provider struct {
+	name       string
+	store      *state.Store
+	generators map[string]Generator
+
+	mu sync.Mutex
+	// regenerated holds values replaced by this process, which state only
+	// records once the update has been applied
+	regenerated map[string]*types.BaseResource
+}
+
+// NewProvider returns a provider named name for the given resource types
+// whose values are read back from store
+func NewProvider(name string, store *state.Store, generators map[string]Generator) *Provider {
+	return &Provider{
+		name:        name,
+		store:       store,
+		generators:  generators,
+		regenerated: make(map[string]*types.BaseResource),
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) generator(resourceType string) (Generator, error) {
+	g, ok := p.generators[resourceType]
+	if !ok {
+		return Generator{}, fmt.Errorf("%s: unsupported resource type %q", p.name, resourceType)
+	}
+	return g, nil
+}
+
+// Create generates a new value
+func (p *Provider) Create(ctx context.Context, resourceType string, config map[string]interface{}) (provider.Resource, error) {
+	g, err := p.generator(resourceType)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newID(resourceType)
+	if err != nil {
+		return nil, err
+	}
+	return p.generate(ctx, g, resourceType, id, config)
+}
+
+func (p *Provider) generate(ctx context.Context, g Generator, resourceType, id string, config map[string]interface{}) (*types.BaseResource, error) {
+	if g.Validate != nil {
+		if err := g.Validate(config); err != nil {
+			return nil, err
+		}
+	}
+	computed, err := g.Generate(ctx, config, p.lookup)
+	if err != nil {
+		return nil, err
+	}
+	metadata := inputs(g.Schema, config)
+	for k, v := range computed {
+		metadata[k] = v
+	}
+	return &types.BaseResource{
+		ID:       id,
+		Type:     types.ResourceType(resourceType),
+		Provider: p.name,
+		Status:   types.StatusRunning,
+		Metadata: metadata,
+	}, nil
+}
+
+// Read returns a value as this process last generated it or, failing that,
+// as state records it
+func (p *Provider) Read(ctx context.Context, resourceType string, id string) (provider.Resource, error) {
+	if _, err := p.generator(resourceType); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	r, ok := p.regenerated[id]
+	p.mu.Unlock()
+	if ok {
+		return r, nil
+	}
+	if p.store == nil {
+		return nil, provider.ErrNotFound
+	}
+
+	record, err := p.store.GetResource(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, provider.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from state: %w", id, err)
+	}
+	if record.Provider != p.name || record.Type != resourceType {
+		return nil, provider.ErrNotFound
+	}
+	resource, err := record.ToResource()
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := p.store.OpenSecrets(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range secrets {
+		resource.Metadata[k] = v
+	}
+	return resource, nil
+}
+
+// Update generates a new value if the inputs in config differ from those
+// the current value was generated from, and otherwise keeps it. The new
+// value keeps the resource's ID.
+func (p *Provider) Update(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	resourceType := string(resource.GetType())
+	g, err := p.generator(resourceType)
+	if err != nil {
+		return err
+	}
+	same, err := sameInputs(g.Schema, resource.GetMetadata(), config)
+	if err != nil || same {
+		return err
+	}
+	r, err := p.generate(ctx, g, resourceType, resource.GetID(), config)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.regenerated[r.ID] = r
+	p.mu.Unlock()
+	return nil
+}
+
+// Delete forgets a value. Removing it from state is all that is needed.
+func (p *Provider) Delete(ctx context.Context, resource provider.Resource) error {
+	if _, err := p.generator(string(resource.GetType())); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	delete(p.regenerated, resource.GetID())
+	p.mu.Unlock()
+	return nil
+}
+
+// FindByToken always returns provider.ErrNotFound: a value whose create
+// was interrupted was never recorded anywhere, so there is nothing to
+// reconcile
+func (p *Provider) FindByToken(ctx context.Context, resourceType string, token string) (provider.Resource, error) {
+	if _, err := p.generator(resourceType); err != nil {
+		return nil, err
+	}
+	return nil, provider.ErrNotFound
+}
+
+// ValidateConfig runs the resource type's own checks
+func (p *Provider) ValidateConfig(ctx context.Context, resourceType string, config map[string]interface{}) error {
+	g, err := p.generator(resourceType)
+	if err != nil {
+		return err
+	}
+	if g.Validate == nil {
+		return nil
+	}
+	return g.Validate(config)
+}
+
+// Schema describes the attributes of each resource type
+func (p *Provider) Schema() *provider.Schema {
+	schema := &provider.Schema{Resources: make(map[string]*provider.ResourceSchema, len(p.generators))}
+	for resourceType, g := range p.generators {
+		schema.Resources[resourceType] = g.Schema
+	}
+	return schema
+}
+
+// SensitiveAttributes returns the attributes of a resource type that are
+// sealed in state, such as passwords and private keys
+func (p *Provider) SensitiveAttributes(resourceType string) []string {
+	return p.generators[resourceType].Schema.SensitiveAttributes()
+}
+
+// lookup reads another resource of this provider
+func (p *Provider) lookup(ctx context.Context, resourceType, id string) (map[string]interface{}, error) {
+	r, err := p.Read(ctx, resourceType, id)
+	if errors.Is(err, provider.ErrNotFound) {
+		return nil, fmt.Errorf("%s %s not found in state", resourceType, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.GetMetadata(), nil
+}
+
+// inputs returns the attributes of config a value is generated from, with
+// their defaults where unset
+func inputs(schema *provider.ResourceSchema, config map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{})
+	for name, attr := range schema.Attributes {
+		if attr.Computed {
+			continue
+		}
+		if v, ok := config[name]; ok && v != nil {
+			values[name] = v
+		} else if attr.Default != nil {
+			values[name] = attr.Default
+		}
+	}
+	return values
+}
+
+// sameInputs reports whether config has the inputs recorded in metadata.
+// Values are compared as JSON, since those in state were decoded from it.
+func sameInputs(schema *provider.ResourceSchema, metadata, config map[string]interface{}) (bool, error) {
+	recorded, err := json.Marshal(inputs(schema, metadata))
+	if err != nil {
+		return false, fmt.Errorf("failed to encode recorded inputs: %w", err)
+	}
+	desired, err := json.Marshal(inputs(schema, config))
+	if err != nil {
+		return false, fmt.Errorf("failed to encode inputs: %w", err)
+	}
+	return string(recorded) == string(desired), nil
+}
+
+// newID returns a random ID for a new resource of resourceType. IDs say
+// nothing about the value, which can change while the ID stays.
+func newID(resourceType string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return strings.ReplaceAll(resourceType, "_", "-") + "-" + hex.EncodeToString(b), nil
+}
+
+// KeepersAttribute is the keepers attribute shared by every resource type
+var KeepersAttribute = &provider.Attribute{
+	Type:        provider.TypeMap,
+	Elem:        &provider.Attribute{Type: provider.TypeAny},
+	Description: "Arbitrary values that generate a new value when they change",
+}
@@ -0,0 +1,219 @@
+package generated
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+var tokenSchema = &provider.ResourceSchema{
+	Attributes: map[string]*provider.Attribute{
+		"length":  {Type: provider.TypeInt, Default: 8},
+		"keepers": KeepersAttribute,
+		"value":   {Type: provider.TypeString, Computed: true},
+		"secret":  {Type: provider.TypeString, Computed: true, Sensitive: true},
+	},
+}
+
+// newTokenProvider returns a provider of tokens, counting how many it has
+// generated, and of signatures that read a token's secret
+func newTokenProvider(store *state.Store) (*Provider, *int) {
+	count := 0
+	return NewProvider("test", store, map[string]Generator{
+		"token": {
+			Schema: tokenSchema,
+			Generate: func(ctx context.Context, config map[string]interface{}, lookup Lookup) (map[string]interface{}, error) {
+				count++
+				return map[string]interface{}{
+					"value":  fmt.Sprintf("token-%d", count),
+					"secret": fmt.Sprintf("secret-%d", count),
+				}, nil
+			},
+			Validate: func(config map[string]interface{}) error {
+				if NewConfig(tokenSchema, config).Int("length") < 1 {
+					return fmt.Errorf("attribute length: expected at least 1")
+				}
+				return nil
+			},
+		},
+		"signature": {
+			Schema: &provider.ResourceSchema{Attributes: map[string]*provider.Attribute{
+				"token_id":  {Type: provider.TypeString, Required: true},
+				"signature": {Type: provider.TypeString, Computed: true},
+			}},
+			Generate: func(ctx context.Context, config map[string]interface{}, lookup Lookup) (map[string]interface{}, error) {
+				id, _ := config["token_id"].(string)
+				token, err := lookup(ctx, "token", id)
+				if err != nil {
+					return nil, err
+				}
+				return map[string]interface{}{"signature": fmt.Sprintf("signed(%v)", token["secret"])}, nil
+			},
+		},
+	}), &count
+}
+
+func newStore(t *testing.T) *state.Store {
+	t.Helper()
+	store, err := state.NewStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.SetKeySource(func() (string, error) { return "passphrase", nil })
+	return store
+}
+
+// save records a resource in state the way the applier does, with its
+// sensitive attributes sealed
+func save(t *testing.T, store *state.Store, p *Provider, r provider.Resource) {
+	t.Helper()
+	metadata := make(map[string]interface{})
+	secrets := make(map[string]interface{})
+	sensitive := p.SensitiveAttributes(string(r.GetType()))
+	for k, v := range r.GetMetadata() {
+		metadata[k] = v
+	}
+	for _, name := range sensitive {
+		if v, ok := metadata[name]; ok {
+			secrets[name] = v
+			delete(metadata, name)
+		}
+	}
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatalf("Failed to encode metadata: %v", err)
+	}
+	record := &state.Resource{ID: r.GetID(), Type: string(r.GetType()), Name: "main", Provider: r.GetProvider(), Metadata: encoded}
+	if err := store.SealSecrets(context.Background(), record, secrets); err != nil {
+		t.Fatalf("Failed to seal secrets: %v", err)
+	}
+	if err := store.SaveResource(context.Background(), record); err != nil {
+		t.Fatalf("Failed to save resource: %v", err)
+	}
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	p, count := newTokenProvider(store)
+	config := map[string]interface{}{"keepers": map[string]interface{}{"ami": "ami-1", "version": 2}}
+
+	var token provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		token, err = p.Create(ctx, "token", config)
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+		if !strings.HasPrefix(token.GetID(), "token-") || token.GetProvider() != "test" {
+			t.Errorf("Unexpected resource: %+v", token)
+		}
+		meta := token.GetMetadata()
+		if meta["value"] != "token-1" || meta["secret"] != "secret-1" || meta["length"] != 8 {
+			t.Errorf("Expected the generated values and inputs with defaults, got %v", meta)
+		}
+
+		if _, err := p.Create(ctx, "token", map[string]interface{}{"length": 0}); err == nil {
+			t.Error("Expected the generator's validation to fail, got nil")
+		}
+		if _, err := p.Create(ctx, "nonce", nil); err == nil {
+			t.Error("Expected error for an unsupported resource type, got nil")
+		}
+	})
+
+	t.Run("Read", func(t *testing.T) {
+		if _, err := p.Read(ctx, "token", token.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected a value not yet in state to be missing, got %v", err)
+		}
+		save(t, store, p, token)
+
+		got, err := p.Read(ctx, "token", token.GetID())
+		if err != nil {
+			t.Fatalf("Failed to read token: %v", err)
+		}
+		if meta := got.GetMetadata(); meta["value"] != "token-1" || meta["secret"] != "secret-1" {
+			t.Errorf("Expected the values from state with secrets opened, got %v", meta)
+		}
+		if _, err := p.Read(ctx, "signature", token.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected a resource of another type to be missing, got %v", err)
+		}
+	})
+
+	t.Run("Lookup", func(t *testing.T) {
+		sig, err := p.Create(ctx, "signature", map[string]interface{}{"token_id": token.GetID()})
+		if err != nil {
+			t.Fatalf("Failed to create signature: %v", err)
+		}
+		if sig.GetMetadata()["signature"] != "signed(secret-1)" {
+			t.Errorf("Expected the token's secret to be read from state, got %v", sig.GetMetadata())
+		}
+		if _, err := p.Create(ctx, "signature", map[string]interface{}{"token_id": "token-missing"}); err == nil {
+			t.Error("Expected error for a missing token, got nil")
+		}
+	})
+
+	t.Run("UpdateKeeps", func(t *testing.T) {
+		// The same inputs, decoded from state, with the default written out
+		same := map[string]interface{}{"length": 8, "keepers": map[string]interface{}{"version": float64(2), "ami": "ami-1"}}
+		current, _ := p.Read(ctx, "token", token.GetID())
+		if err := p.Update(ctx, current, same); err != nil {
+			t.Fatalf("Failed to update token: %v", err)
+		}
+		got, _ := p.Read(ctx, "token", token.GetID())
+		if got.GetMetadata()["value"] != "token-1" || *count != 1 {
+			t.Errorf("Expected the value to be kept while the keepers are the same, got %v", got.GetMetadata())
+		}
+	})
+
+	t.Run("UpdateRegenerates", func(t *testing.T) {
+		changed := map[string]interface{}{"keepers": map[string]interface{}{"ami": "ami-2", "version": 2}}
+		current, _ := p.Read(ctx, "token", token.GetID())
+		if err := p.Update(ctx, current, changed); err != nil {
+			t.Fatalf("Failed to update token: %v", err)
+		}
+		got, err := p.Read(ctx, "token", token.GetID())
+		if err != nil {
+			t.Fatalf("Failed to read token: %v", err)
+		}
+		if got.GetID() != token.GetID() || got.GetMetadata()["value"] != "token-2" || got.GetMetadata()["secret"] != "secret-2" {
+			t.Errorf("Expected a new value with the same ID when the keepers change, got %+v", got)
+		}
+	})
+
+	t.Run("FindByToken", func(t *testing.T) {
+		if _, err := p.FindByToken(ctx, "token", "run-1"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, token); err != nil {
+			t.Fatalf("Failed to delete token: %v", err)
+		}
+		if err := store.DeleteResource(ctx, token.GetID()); err != nil {
+			t.Fatalf("Failed to delete token from state: %v", err)
+		}
+		if _, err := p.Read(ctx, "token", token.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected a deleted value to be missing, got %v", err)
+		}
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		if got := p.SensitiveAttributes("token"); len(got) != 1 || got[0] != "secret" {
+			t.Errorf("Expected [secret], got %v", got)
+		}
+		if schema := p.Schema(); schema.Resources["token"] != tokenSchema || len(schema.Resources) != 2 {
+			t.Errorf("Expected a schema for each resource type, got %v", schema.Resources)
+		}
+		if err := p.ValidateConfig(ctx, "token", map[string]interface{}{"length": -1}); err == nil {
+			t.Error("Expected the config to be rejected, got nil")
+		}
+	})
+}
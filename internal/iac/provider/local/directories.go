@@ -0,0 +1,91 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// defaultDirectoryMode is the mode of directories whose config does not
+// give one, and of parent directories created for files
+const defaultDirectoryMode os.FileMode = 0o755
+
+var directorySchema = &provider.ResourceSchema{
+	Description: "A directory, created with any missing parents",
+	Attributes: map[string]*provider.Attribute{
+		"path": {Type: provider.TypeString, Required: true, ForceNew: true, Description: "Relative paths are resolved against the provider's root"},
+		"mode": {Type: provider.TypeString, Default: formatMode(defaultDirectoryMode), Description: "Permissions in octal"},
+	},
+}
+
+func validateDirectory(config map[string]interface{}) error {
+	return validateMode(config, "mode")
+}
+
+// createDirectory creates a directory, adopting one already at path
+func createDirectory(path string, config map[string]interface{}) (*types.BaseResource, error) {
+	mode, err := fileMode(config, "mode", defaultDirectoryMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path, defaultDirectoryMode); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return nil, fmt.Errorf("failed to change mode of %s: %w", path, err)
+	}
+	return readDirectory(path)
+}
+
+func readDirectory(path string) (*types.BaseResource, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, provider.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", path, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", path)
+	}
+	return newResource(types.ResourceTypeDirectory, path, map[string]interface{}{
+		"mode": formatMode(info.Mode()),
+	}), nil
+}
+
+func expectDirectory(config map[string]interface{}) (map[string]interface{}, error) {
+	mode, err := fileMode(config, "mode", defaultDirectoryMode)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"mode": formatMode(mode)}, nil
+}
+
+// updateDirectory corrects a directory's mode, recreating it if it was
+// removed
+func updateDirectory(path string, config map[string]interface{}) error {
+	_, err := createDirectory(path, config)
+	return err
+}
+
+// deleteDirectory removes a directory if it is empty. Its contents are left
+// to the resources that manage them, which are deleted first when they
+// refer to the directory.
+func deleteDirectory(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		entries, readErr := os.ReadDir(path)
+		if readErr == nil && len(entries) > 0 {
+			return fmt.Errorf("directory %s is not empty; remove its contents before deleting it", path)
+		}
+		return fmt.Errorf("failed to delete directory %s: %w", path, err)
+	}
+	return nil
+}
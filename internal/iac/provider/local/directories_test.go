@@ -0,0 +1,82 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+func TestDirectories(t *testing.T) {
+	ctx := context.Background()
+	p, root := newTestProvider(t)
+	path := filepath.Join(root, "inventory", "group_vars")
+
+	var dir provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		dir, err = p.Create(ctx, "directory", map[string]interface{}{"path": path, "mode": "0750"})
+		if err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() || info.Mode().Perm() != 0o750 {
+			t.Errorf("Expected a directory with mode 0750, got %v, %v", info, err)
+		}
+		if dir.GetMetadata()["mode"] != "0750" {
+			t.Errorf("Unexpected metadata: %v", dir.GetMetadata())
+		}
+
+		again, err := p.Create(ctx, "directory", map[string]interface{}{"path": path})
+		if err != nil {
+			t.Fatalf("Expected an existing directory to be adopted, got %v", err)
+		}
+		if again.GetMetadata()["mode"] != "0755" {
+			t.Errorf("Expected the default mode, got %v", again.GetMetadata())
+		}
+	})
+
+	t.Run("Read", func(t *testing.T) {
+		if _, err := p.Read(ctx, "directory", path); err != nil {
+			t.Errorf("Failed to read directory: %v", err)
+		}
+		if _, err := p.Read(ctx, "directory", filepath.Join(root, "missing")); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		if err := p.Update(ctx, dir, map[string]interface{}{"path": path, "mode": "0700"}); err != nil {
+			t.Fatalf("Failed to update directory: %v", err)
+		}
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0o700 {
+			t.Errorf("Expected mode 0700, got %o", info.Mode().Perm())
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		file := filepath.Join(path, "all.yml")
+		if err := os.WriteFile(file, nil, 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := p.Delete(ctx, dir); err == nil {
+			t.Error("Expected error deleting a directory that is not empty, got nil")
+		}
+
+		if err := os.Remove(file); err != nil {
+			t.Fatalf("Failed to remove file: %v", err)
+		}
+		if err := p.Delete(ctx, dir); err != nil {
+			t.Fatalf("Failed to delete directory: %v", err)
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected the directory to be removed, got %v", err)
+		}
+		if err := p.Delete(ctx, dir); err != nil {
+			t.Errorf("Expected deleting a missing directory to succeed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package local
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// defaultFileMode is the mode of files whose config does not give one
+const defaultFileMode os.FileMode = 0o644
+
+var fileSchema = &provider.ResourceSchema{
+	Description: "A file written with the given content",
+	Attributes: map[string]*provider.Attribute{
+		"path":           {Type: provider.TypeString, Required: true, ForceNew: true, Description: "Relative paths are resolved against the provider's root"},
+		"content":        {Type: provider.TypeString, Description: "The file's content as text"},
+		"content_base64": {Type: provider.TypeString, Description: "The file's content, base64 encoded, for binary files"},
+		"mode":           {Type: provider.TypeString, Default: formatMode(defaultFileMode), Description: "Permissions in octal"},
+		"sha256":         {Type: provider.TypeString, Computed: true},
+		"size":           {Type: provider.TypeInt, Computed: true},
+	},
+}
+
+// validateFile checks that at most one kind of content is given and that
+// the mode is valid
+func validateFile(config map[string]interface{}) error {
+	if config["content"] != nil && config["content_base64"] != nil {
+		return fmt.Errorf("only one of content and content_base64 can be set")
+	}
+	return validateMode(config, "mode")
+}
+
+// fileContent returns the content config gives a file, empty if it gives
+// none
+func fileContent(config map[string]interface{}) ([]byte, error) {
+	if err := validateFile(config); err != nil {
+		return nil, err
+	}
+	encoded, err := stringValue(config, "content_base64")
+	if err != nil {
+		return nil, err
+	}
+	if encoded != "" {
+		content, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("attribute content_base64: %w", err)
+		}
+		return content, nil
+	}
+	content, err := stringValue(config, "content")
+	return []byte(content), err
+}
+
+// createFile writes a file, replacing whatever file is already at path.
+// Missing parent directories are created.
+func createFile(path string, config map[string]interface{}) (*types.BaseResource, error) {
+	content, err := fileContent(config)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := fileMode(config, "mode", defaultFileMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), defaultDirectoryMode); err != nil {
+		return nil, fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	if err := writeFile(path, content, mode); err != nil {
+		return nil, err
+	}
+	return readFile(path)
+}
+
+func readFile(path string) (*types.BaseResource, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, provider.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	sum, err := checksum(path)
+	if err != nil {
+		return nil, err
+	}
+	return newResource(types.ResourceTypeFile, path, map[string]interface{}{
+		"sha256": sum,
+		"size":   info.Size(),
+		"mode":   formatMode(info.Mode()),
+	}), nil
+}
+
+// expectFile returns the checksum and mode of the file config describes
+func expectFile(config map[string]interface{}) (map[string]interface{}, error) {
+	content, err := fileContent(config)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := fileMode(config, "mode", defaultFileMode)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"sha256": hexSum(content), "mode": formatMode(mode)}, nil
+}
+
+// updateFile rewrites a file whose checksum differs from the new content's,
+// including one changed or removed since it was written, and corrects its
+// mode. A file that already matches is left alone.
+func updateFile(path string, config map[string]interface{}) error {
+	content, err := fileContent(config)
+	if err != nil {
+		return err
+	}
+	mode, err := fileMode(config, "mode", defaultFileMode)
+	if err != nil {
+		return err
+	}
+
+	current, err := readFile(path)
+	if errors.Is(err, provider.ErrNotFound) {
+		_, err = createFile(path, config)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if current.Metadata["sha256"] != hexSum(content) {
+		return writeFile(path, content, mode)
+	}
+	if current.Metadata["mode"] != formatMode(mode) {
+		if err := os.Chmod(path, mode); err != nil {
+			return fmt.Errorf("failed to change mode of %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// deleteFile removes a file, refusing to remove anything else that has
+// taken its place
+func deleteFile(path string) error {
+	if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if _, err := readFile(path); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete file %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeFile replaces path with content through a temporary file in the same
+// directory, so readers never see it half written
+func writeFile(path string, content []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write file %s: %w", path, err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// Set explicitly, since the mode given on creation is masked by
+		// the umask
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file %s: %w", path, err)
+	}
+	return nil
+}
+
+// checksum returns the hex encoded SHA-256 of the file at path
+func checksum(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	return hexSum(content), nil
+}
+
+func hexSum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
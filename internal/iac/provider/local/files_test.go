@@ -0,0 +1,206 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+func TestFiles(t *testing.T) {
+	ctx := context.Background()
+	p, root := newTestProvider(t)
+	path := filepath.Join(root, "ssh", "config")
+
+	readContent := func(t *testing.T) string {
+		t.Helper()
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		return string(content)
+	}
+	modeOf := func(t *testing.T) os.FileMode {
+		t.Helper()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		return info.Mode().Perm()
+	}
+
+	var file provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		file, err = p.Create(ctx, "file", map[string]interface{}{
+			"path":    path,
+			"content": "Host web\n",
+			"mode":    "0600",
+		})
+		if err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		if got := readContent(t); got != "Host web\n" {
+			t.Errorf("Expected the content to be written, got %q", got)
+		}
+		if got := modeOf(t); got != 0o600 {
+			t.Errorf("Expected mode 0600, got %o", got)
+		}
+
+		meta := file.GetMetadata()
+		if meta["sha256"] != hexSum([]byte("Host web\n")) || meta["size"] != int64(9) || meta["mode"] != "0600" {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+	})
+
+	t.Run("DefaultMode", func(t *testing.T) {
+		res, err := p.Create(ctx, "file", map[string]interface{}{"path": "empty"})
+		if err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		if meta := res.GetMetadata(); meta["mode"] != "0644" || meta["size"] != int64(0) {
+			t.Errorf("Expected an empty file with mode 0644, got %v", meta)
+		}
+	})
+
+	t.Run("Base64", func(t *testing.T) {
+		res, err := p.Create(ctx, "file", map[string]interface{}{"path": "blob", "content_base64": "AAEC/w=="})
+		if err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		content, _ := os.ReadFile(res.GetID())
+		if string(content) != "\x00\x01\x02\xff" {
+			t.Errorf("Expected the decoded content, got %q", content)
+		}
+		if _, err := p.Create(ctx, "file", map[string]interface{}{"path": "bad", "content_base64": "not base64"}); err == nil {
+			t.Error("Expected error for invalid base64, got nil")
+		}
+	})
+
+	t.Run("Read", func(t *testing.T) {
+		res, err := p.Read(ctx, "file", path)
+		if err != nil {
+			t.Fatalf("Failed to read file: %v", err)
+		}
+		if res.GetMetadata()["sha256"] != file.GetMetadata()["sha256"] {
+			t.Errorf("Expected the checksum of the written content, got %v", res.GetMetadata())
+		}
+		if _, err := p.Read(ctx, "file", filepath.Join(root, "missing")); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		if _, err := p.Read(ctx, "file", root); err == nil {
+			t.Error("Expected error reading a directory as a file, got nil")
+		}
+	})
+
+	t.Run("UpdateUnchanged", func(t *testing.T) {
+		past := time.Now().Add(-time.Hour).Truncate(time.Second)
+		if err := os.Chtimes(path, past, past); err != nil {
+			t.Fatalf("Failed to set times: %v", err)
+		}
+		if err := p.Update(ctx, file, map[string]interface{}{"path": path, "content": "Host web\n", "mode": "0600"}); err != nil {
+			t.Fatalf("Failed to update file: %v", err)
+		}
+		info, _ := os.Stat(path)
+		if !info.ModTime().Equal(past) {
+			t.Errorf("Expected a file with the same checksum to be left alone, modified at %v", info.ModTime())
+		}
+	})
+
+	t.Run("UpdateMode", func(t *testing.T) {
+		if err := p.Update(ctx, file, map[string]interface{}{"path": path, "content": "Host web\n", "mode": "0640"}); err != nil {
+			t.Fatalf("Failed to update file: %v", err)
+		}
+		if got := modeOf(t); got != 0o640 {
+			t.Errorf("Expected mode 0640, got %o", got)
+		}
+	})
+
+	t.Run("UpdateContent", func(t *testing.T) {
+		if err := p.Update(ctx, file, map[string]interface{}{"path": path, "content": "Host api\n", "mode": "0640"}); err != nil {
+			t.Fatalf("Failed to update file: %v", err)
+		}
+		if got := readContent(t); got != "Host api\n" {
+			t.Errorf("Expected the new content, got %q", got)
+		}
+		if got := modeOf(t); got != 0o640 {
+			t.Errorf("Expected mode 0640 to be kept, got %o", got)
+		}
+		entries, _ := os.ReadDir(filepath.Dir(path))
+		if len(entries) != 1 {
+			t.Errorf("Expected no temporary files to be left, got %v", entries)
+		}
+	})
+
+	t.Run("UpdateDrifted", func(t *testing.T) {
+		if err := os.WriteFile(path, []byte("edited by hand\n"), 0o640); err != nil {
+			t.Fatalf("Failed to edit file: %v", err)
+		}
+		if err := p.Update(ctx, file, map[string]interface{}{"path": path, "content": "Host api\n", "mode": "0640"}); err != nil {
+			t.Fatalf("Failed to update file: %v", err)
+		}
+		if got := readContent(t); got != "Host api\n" {
+			t.Errorf("Expected the edit to be overwritten, got %q", got)
+		}
+
+		if err := os.Remove(path); err != nil {
+			t.Fatalf("Failed to remove file: %v", err)
+		}
+		if err := p.Update(ctx, file, map[string]interface{}{"path": path, "content": "Host api\n"}); err != nil {
+			t.Fatalf("Failed to update file: %v", err)
+		}
+		if got := readContent(t); got != "Host api\n" {
+			t.Errorf("Expected a removed file to be written again, got %q", got)
+		}
+	})
+
+	t.Run("Drifted", func(t *testing.T) {
+		config := map[string]interface{}{"path": path, "content": "Host api\n"}
+		if drifted, err := p.Drifted(ctx, file, config); err != nil || drifted {
+			t.Errorf("Expected a matching file not to have drifted, got %v, %v", drifted, err)
+		}
+		if drifted, _ := p.Drifted(ctx, file, map[string]interface{}{"path": path, "content": "Host api\n", "mode": "0600"}); !drifted {
+			t.Error("Expected a file with another mode to have drifted")
+		}
+
+		if err := os.WriteFile(path, []byte("edited by hand\n"), 0o644); err != nil {
+			t.Fatalf("Failed to edit file: %v", err)
+		}
+		if drifted, err := p.Drifted(ctx, file, config); err != nil || !drifted {
+			t.Errorf("Expected an edited file to have drifted, got %v, %v", drifted, err)
+		}
+
+		if err := os.Remove(path); err != nil {
+			t.Fatalf("Failed to remove file: %v", err)
+		}
+		if _, err := p.Drifted(ctx, file, config); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a removed file, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, file); err != nil {
+			t.Fatalf("Failed to delete file: %v", err)
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected the file to be removed, got %v", err)
+		}
+		if err := p.Delete(ctx, file); err != nil {
+			t.Errorf("Expected deleting a missing file to succeed, got %v", err)
+		}
+
+		if err := os.Mkdir(path, 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := p.Delete(ctx, file); err == nil {
+			t.Error("Expected error deleting a directory that replaced the file, got nil")
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected the directory to be kept, got %v", err)
+		}
+	})
+}
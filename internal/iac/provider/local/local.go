@@ -0,0 +1,284 @@
+// Package local implements a provider for files, directories and symlinks on
+// the machine running duet, such as inventories and SSH configs generated
+// from the outputs of other resources
+package local
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// resourceHandler implements the provider operations for one resource type.
+// Resources are identified by their absolute path. expect returns the
+// metadata read should find for config, to detect drift.
+type resourceHandler struct {
+	create   func(path string, config map[string]interface{}) (*types.BaseResource, error)
+	read     func(path string) (*types.BaseResource, error)
+	update   func(path string, config map[string]interface{}) error
+	delete   func(path string) error
+	expect   func(config map[string]interface{}) (map[string]interface{}, error)
+	validate func(config map[string]interface{}) error
+	schema   *provider.ResourceSchema
+}
+
+// LocalProvider manages files, directories and symlinks on the local
+// filesystem
+type LocalProvider struct {
+	root     string
+	handlers map[string]resourceHandler
+}
+
+// Option configures a LocalProvider
+type Option func(*LocalProvider)
+
+// WithRoot resolves relative paths against root instead of the working
+// directory
+func WithRoot(root string) Option {
+	return func(p *LocalProvider) {
+		p.root = root
+	}
+}
+
+// NewLocalProvider returns a provider for the local filesystem
+func NewLocalProvider(opts ...Option) (*LocalProvider, error) {
+	p := &LocalProvider{}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.root == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("local: failed to get working directory: %w", err)
+		}
+		p.root = wd
+	}
+	root, err := filepath.Abs(p.root)
+	if err != nil {
+		return nil, fmt.Errorf("local: invalid root %q: %w", p.root, err)
+	}
+	p.root = root
+
+	p.handlers = map[string]resourceHandler{
+		string(types.ResourceTypeFile): {
+			create:   createFile,
+			read:     readFile,
+			update:   updateFile,
+			delete:   deleteFile,
+			expect:   expectFile,
+			validate: validateFile,
+			schema:   fileSchema,
+		},
+		string(types.ResourceTypeDirectory): {
+			create:   createDirectory,
+			read:     readDirectory,
+			update:   updateDirectory,
+			delete:   deleteDirectory,
+			expect:   expectDirectory,
+			validate: validateDirectory,
+			schema:   directorySchema,
+		},
+		string(types.ResourceTypeSymlink): {
+			create: createSymlink,
+			read:   readSymlink,
+			update: updateSymlink,
+			delete: deleteSymlink,
+			expect: expectSymlink,
+			schema: symlinkSchema,
+		},
+	}
+	return p, nil
+}
+
+func (p *LocalProvider) Name() string {
+	return "local"
+}
+
+func (p *LocalProvider) handler(resourceType string) (resourceHandler, error) {
+	h, ok := p.handlers[resourceType]
+	if !ok {
+		return resourceHandler{}, fmt.Errorf("local: unsupported resource type %q", resourceType)
+	}
+	return h, nil
+}
+
+// path returns the absolute path a config refers to
+func (p *LocalProvider) path(config map[string]interface{}) (string, error) {
+	path, _ := config["path"].(string)
+	if path == "" {
+		return "", fmt.Errorf("attribute path is required")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.root, path)
+	}
+	return filepath.Clean(path), nil
+}
+
+// Create creates a resource of the given type
+func (p *LocalProvider) Create(ctx context.Context, resourceType string, config map[string]interface{}) (provider.Resource, error) {
+	h, err := p.handler(resourceType)
+	if err != nil {
+		return nil, err
+	}
+	path, err := p.path(config)
+	if err != nil {
+		return nil, err
+	}
+	return asResource(h.create(path, config))
+}
+
+// Read returns the current state of a resource, or provider.ErrNotFound
+func (p *LocalProvider) Read(ctx context.Context, resourceType string, id string) (provider.Resource, error) {
+	h, err := p.handler(resourceType)
+	if err != nil {
+		return nil, err
+	}
+	return asResource(h.read(id))
+}
+
+// FindByConfig returns the resource at the path config names, or
+// provider.ErrNotFound. Creating adopts or replaces whatever is at the path,
+// so an interrupted create adopts it too; a later plan corrects any drift.
+func (p *LocalProvider) FindByConfig(ctx context.Context, resourceType string, config map[string]interface{}) (provider.Resource, error) {
+	h, err := p.handler(resourceType)
+	if err != nil {
+		return nil, err
+	}
+	path, err := p.path(config)
+	if err != nil {
+		return nil, err
+	}
+	return asResource(h.read(path))
+}
+
+// Update brings an existing resource in line with config. Its path cannot
+// change, so the resource's ID is used rather than the path in config.
+func (p *LocalProvider) Update(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	h, err := p.handler(string(resource.GetType()))
+	if err != nil {
+		return err
+	}
+	return h.update(resource.GetID(), config)
+}
+
+// Delete removes a resource. One that is already gone is not an error.
+func (p *LocalProvider) Delete(ctx context.Context, resource provider.Resource) error {
+	h, err := p.handler(string(resource.GetType()))
+	if err != nil {
+		return err
+	}
+	return h.delete(resource.GetID())
+}
+
+// Drifted reports whether a resource was changed since it was written, such
+// as a file edited by hand, or returns provider.ErrNotFound if it is gone
+func (p *LocalProvider) Drifted(ctx context.Context, resource provider.Resource, config map[string]interface{}) (bool, error) {
+	h, err := p.handler(string(resource.GetType()))
+	if err != nil {
+		return false, err
+	}
+	want, err := h.expect(config)
+	if err != nil {
+		return false, err
+	}
+	current, err := h.read(resource.GetID())
+	if err != nil {
+		return false, err
+	}
+	for key, value := range want {
+		if current.Metadata[key] != value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ValidateConfig checks the values the schema cannot, such as modes
+func (p *LocalProvider) ValidateConfig(ctx context.Context, resourceType string, config map[string]interface{}) error {
+	h, err := p.handler(resourceType)
+	if err != nil {
+		return err
+	}
+	if h.validate == nil {
+		return nil
+	}
+	return h.validate(config)
+}
+
+// Schema describes the attributes of each resource type
+func (p *LocalProvider) Schema() *provider.Schema {
+	schema := &provider.Schema{Resources: make(map[string]*provider.ResourceSchema, len(p.handlers))}
+	for resourceType, h := range p.handlers {
+		schema.Resources[resourceType] = h.schema
+	}
+	return schema
+}
+
+// asResource converts a handler result to the interface, keeping a nil
+// pointer from becoming a non-nil provider.Resource
+func asResource(r *types.BaseResource, err error) (provider.Resource, error) {
+	if r == nil {
+		return nil, err
+	}
+	return r, err
+}
+
+// stringValue returns the string at key, or "" if unset
+func stringValue(config map[string]interface{}, key string) (string, error) {
+	v, ok := config[key]
+	if !ok || v == nil {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("attribute %s: expected a string, got %T", key, v)
+	}
+	return s, nil
+}
+
+// fileMode returns the permissions at key, written in octal such as "0644",
+// or def if unset
+func fileMode(config map[string]interface{}, key string, def os.FileMode) (os.FileMode, error) {
+	s, err := stringValue(config, key)
+	if err != nil || s == "" {
+		return def, err
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("attribute %s: expected permissions in octal such as \"0644\", got %q", key, s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// validateMode checks the mode at key, unless it is a reference that has
+// not been resolved yet
+func validateMode(config map[string]interface{}, key string) error {
+	if s, _ := config[key].(string); strings.Contains(s, "${") {
+		return nil
+	}
+	_, err := fileMode(config, key, 0)
+	return err
+}
+
+// formatMode writes permissions the way configs give them
+func formatMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+// newResource returns a resource for path with the given metadata
+func newResource(resourceType types.ResourceType, path string, metadata map[string]interface{}) *types.BaseResource {
+	metadata["path"] = path
+	return &types.BaseResource{
+		ID:       path,
+		Type:     resourceType,
+		Provider: "local",
+		Status:   types.StatusRunning,
+		Metadata: metadata,
+	}
+}
@@ -0,0 +1,120 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+// newTestProvider returns a provider rooted in a temporary directory
+func newTestProvider(t *testing.T) (*LocalProvider, string) {
+	t.Helper()
+	root := t.TempDir()
+	p, err := NewLocalProvider(WithRoot(root))
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	return p, root
+}
+
+func TestLocalProvider(t *testing.T) {
+	ctx := context.Background()
+	p, root := newTestProvider(t)
+
+	t.Run("Interfaces", func(t *testing.T) {
+		var _ provider.Provider = p
+		var _ provider.ConfigValidator = p
+		var _ provider.SchemaDescriber = p
+		var _ provider.DriftDetector = p
+		var _ provider.ConfigFinder = p
+		if p.Name() != "local" {
+			t.Errorf("Expected name local, got %q", p.Name())
+		}
+	})
+
+	t.Run("RelativePaths", func(t *testing.T) {
+		res, err := p.Create(ctx, "file", map[string]interface{}{"path": "out/hosts.ini", "content": "[web]\n"})
+		if err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		want := filepath.Join(root, "out", "hosts.ini")
+		if res.GetID() != want || res.GetMetadata()["path"] != want {
+			t.Errorf("Expected the file at %s, got %s", want, res.GetID())
+		}
+	})
+
+	t.Run("WorkingDirectory", func(t *testing.T) {
+		p, err := NewLocalProvider()
+		if err != nil {
+			t.Fatalf("Failed to create provider: %v", err)
+		}
+		wd, _ := os.Getwd()
+		if p.root != wd {
+			t.Errorf("Expected paths relative to %s, got %s", wd, p.root)
+		}
+	})
+
+	t.Run("UnsupportedType", func(t *testing.T) {
+		if _, err := p.Create(ctx, "pipe", map[string]interface{}{"path": "p"}); err == nil {
+			t.Error("Expected error for an unsupported resource type, got nil")
+		}
+	})
+
+	t.Run("MissingPath", func(t *testing.T) {
+		if _, err := p.Create(ctx, "directory", map[string]interface{}{}); err == nil {
+			t.Error("Expected error without a path, got nil")
+		}
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		schema := p.Schema()
+		if len(schema.Resources) != len(p.handlers) {
+			t.Errorf("Expected a schema for each of %d resource types, got %d", len(p.handlers), len(schema.Resources))
+		}
+		for resourceType, r := range schema.Resources {
+			if !r.Attributes["path"].ForceNew {
+				t.Errorf("Expected changing the path of a %s to require replacing it", resourceType)
+			}
+			for name, attr := range r.Attributes {
+				if attr.Default == nil {
+					continue
+				}
+				config := map[string]interface{}{"path": "p", name: attr.Default}
+				if diags := r.Validate(config); diags != nil {
+					t.Errorf("Expected the default of %s.%s to be valid, got %v", resourceType, name, diags)
+				}
+				if err := p.ValidateConfig(ctx, resourceType, config); err != nil {
+					t.Errorf("Expected the default of %s.%s to be accepted, got %v", resourceType, name, err)
+				}
+			}
+		}
+	})
+
+	t.Run("ValidateConfig", func(t *testing.T) {
+		tests := []struct {
+			name         string
+			resourceType string
+			config       map[string]interface{}
+			wantErr      bool
+		}{
+			{"Mode", "file", map[string]interface{}{"path": "a", "mode": "0600"}, false},
+			{"ReferencedMode", "file", map[string]interface{}{"path": "a", "mode": "${local.file.b.mode}"}, false},
+			{"DecimalMode", "directory", map[string]interface{}{"path": "a", "mode": "755"}, false},
+			{"InvalidMode", "directory", map[string]interface{}{"path": "a", "mode": "rwxr-xr-x"}, true},
+			{"ModeTooLarge", "file", map[string]interface{}{"path": "a", "mode": "17777"}, true},
+			{"BothContents", "file", map[string]interface{}{"path": "a", "content": "x", "content_base64": "eA=="}, true},
+			{"Symlink", "symlink", map[string]interface{}{"path": "a", "target": "b"}, false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := p.ValidateConfig(ctx, tt.resourceType, tt.config)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+				}
+			})
+		}
+	})
+}
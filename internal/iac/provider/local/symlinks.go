@@ -0,0 +1,105 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+var symlinkSchema = &provider.ResourceSchema{
+	Description: "A symbolic link",
+	Attributes: map[string]*provider.Attribute{
+		"path":   {Type: provider.TypeString, Required: true, ForceNew: true, Description: "Relative paths are resolved against the provider's root"},
+		"target": {Type: provider.TypeString, Required: true, Description: "What the link points to, written into it as given"},
+	},
+}
+
+// createSymlink creates a link at path, replacing a link already there. Any
+// other file at path is an error.
+func createSymlink(path string, config map[string]interface{}) (*types.BaseResource, error) {
+	if err := updateSymlink(path, config); err != nil {
+		return nil, err
+	}
+	return readSymlink(path)
+}
+
+func readSymlink(path string) (*types.BaseResource, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, provider.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read symlink %s: %w", path, err)
+	}
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return nil, fmt.Errorf("%s is not a symlink", path)
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read symlink %s: %w", path, err)
+	}
+	return newResource(types.ResourceTypeSymlink, path, map[string]interface{}{
+		"target": target,
+	}), nil
+}
+
+func expectSymlink(config map[string]interface{}) (map[string]interface{}, error) {
+	target, err := stringValue(config, "target")
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"target": target}, nil
+}
+
+// updateSymlink points the link at path to the configured target. The new
+// link is renamed over the old one, so the path always resolves.
+func updateSymlink(path string, config map[string]interface{}) error {
+	target, err := stringValue(config, "target")
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		return fmt.Errorf("attribute target is required")
+	}
+
+	current, err := readSymlink(path)
+	switch {
+	case errors.Is(err, provider.ErrNotFound):
+		if err := os.MkdirAll(filepath.Dir(path), defaultDirectoryMode); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+	case err != nil:
+		return err
+	case current.Metadata["target"] == target:
+		return nil
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".duet-link")
+	_ = os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return fmt.Errorf("failed to create symlink %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to create symlink %s: %w", path, err)
+	}
+	return nil
+}
+
+func deleteSymlink(path string) error {
+	if _, err := readSymlink(path); err != nil {
+		if errors.Is(err, provider.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete symlink %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+func TestSymlinks(t *testing.T) {
+	ctx := context.Background()
+	p, root := newTestProvider(t)
+	path := filepath.Join(root, "kube", "config")
+
+	var link provider.Resource
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		link, err = p.Create(ctx, "symlink", map[string]interface{}{"path": path, "target": "../clusters/prod.yaml"})
+		if err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+		if target, err := os.Readlink(path); err != nil || target != "../clusters/prod.yaml" {
+			t.Errorf("Expected a link to ../clusters/prod.yaml, got %q, %v", target, err)
+		}
+		if link.GetMetadata()["target"] != "../clusters/prod.yaml" {
+			t.Errorf("Unexpected metadata: %v", link.GetMetadata())
+		}
+
+		if _, err := p.Create(ctx, "symlink", map[string]interface{}{"path": "link"}); err == nil {
+			t.Error("Expected error without a target, got nil")
+		}
+	})
+
+	t.Run("Read", func(t *testing.T) {
+		if _, err := p.Read(ctx, "symlink", filepath.Join(root, "missing")); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		if _, err := p.Read(ctx, "symlink", root); err == nil {
+			t.Error("Expected error reading a directory as a symlink, got nil")
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		if err := p.Update(ctx, link, map[string]interface{}{"path": path, "target": "../clusters/staging.yaml"}); err != nil {
+			t.Fatalf("Failed to update symlink: %v", err)
+		}
+		if target, _ := os.Readlink(path); target != "../clusters/staging.yaml" {
+			t.Errorf("Expected the link to be repointed, got %q", target)
+		}
+		entries, _ := os.ReadDir(filepath.Dir(path))
+		if len(entries) != 1 {
+			t.Errorf("Expected no temporary links to be left, got %v", entries)
+		}
+	})
+
+	t.Run("Drifted", func(t *testing.T) {
+		config := map[string]interface{}{"path": path, "target": "../clusters/staging.yaml"}
+		if drifted, err := p.Drifted(ctx, link, config); err != nil || drifted {
+			t.Errorf("Expected a matching link not to have drifted, got %v, %v", drifted, err)
+		}
+		config["target"] = "../clusters/prod.yaml"
+		if drifted, err := p.Drifted(ctx, link, config); err != nil || !drifted {
+			t.Errorf("Expected a link to another target to have drifted, got %v, %v", drifted, err)
+		}
+	})
+
+	t.Run("NotALink", func(t *testing.T) {
+		file := filepath.Join(root, "file")
+		if err := os.WriteFile(file, []byte("data"), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if _, err := p.Create(ctx, "symlink", map[string]interface{}{"path": file, "target": "x"}); err == nil {
+			t.Error("Expected error replacing a regular file with a link, got nil")
+		}
+		if content, _ := os.ReadFile(file); string(content) != "data" {
+			t.Errorf("Expected the file to be kept, got %q", content)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := p.Delete(ctx, link); err != nil {
+			t.Fatalf("Failed to delete symlink: %v", err)
+		}
+		if _, err := os.Lstat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected the link to be removed, got %v", err)
+		}
+		if err := p.Delete(ctx, link); err != nil {
+			t.Errorf("Expected deleting a missing symlink to succeed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,401 @@
+// Package plugin runs provider plugins, programs outside duet that serve
+// providers it does not have built in, and talks to them in the protocol
+// defined by pkg/plugin
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	sdk "github.com/rebelopsio/duet/pkg/plugin"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// closeTimeout is how long Close waits for a plugin to exit on its own
+const closeTimeout = 5 * time.Second
+
+// Client is a provider served by a plugin. It implements provider.Provider
+// and each optional provider interface, which fail or do nothing for what
+// the plugin does not support.
+type Client struct {
+	name              string
+	capabilities      map[string]bool
+	schema            sdk.SchemaResult
+	discoverableTypes []string
+	conn              *conn
+	cmd               *exec.Cmd
+}
+
+// Launch starts the plugin at path and configures the provider it serves,
+// which must be called name, with settings. Its standard error goes to
+// duet's. Close stops it.
+func Launch(ctx context.Context, path, name string, settings map[string]interface{}) (*Client, error) {
+	cmd := exec.Command(path)
+	cmd.Env = append(os.Environ(), sdk.EnvProtocol+"="+strconv.Itoa(sdk.ProtocolVersion))
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", path, err)
+	}
+
+	c, err := NewClient(ctx, name, stdout, stdin, settings)
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("plugin %s: %w", path, err)
+	}
+	c.cmd = cmd
+	return c, nil
+}
+
+// NewClient talks to a plugin that reads w and writes r, and configures
+// the provider it serves, which must be called name, with settings
+func NewClient(ctx context.Context, name string, r io.Reader, w io.WriteCloser, settings map[string]interface{}) (*Client, error) {
+	c := &Client{
+		name: name,
+		conn: &conn{
+			w:       w,
+			enc:     json.NewEncoder(w),
+			pending: make(map[int64]chan *sdk.Message),
+		},
+	}
+	go c.conn.read(r)
+
+	var init sdk.InitializeResult
+	err := c.conn.call(ctx, sdk.MethodInitialize, &sdk.InitializeParams{ProtocolVersion: sdk.ProtocolVersion, Settings: settings}, &init)
+	if err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	if init.ProtocolVersion != sdk.ProtocolVersion {
+		_ = w.Close()
+		return nil, fmt.Errorf("plugin speaks protocol %d, but duet speaks %d", init.ProtocolVersion, sdk.ProtocolVersion)
+	}
+	if init.Name != name {
+		_ = w.Close()
+		return nil, fmt.Errorf("plugin serves provider %q, not %q", init.Name, name)
+	}
+	c.capabilities = make(map[string]bool, len(init.Capabilities))
+	for _, capability := range init.Capabilities {
+		c.capabilities[capability] = true
+	}
+
+	if err := c.conn.call(ctx, sdk.MethodSchema, nil, &c.schema); err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	if c.capabilities[sdk.CapabilityDiscover] {
+		var result sdk.TypesResult
+		if err := c.conn.call(ctx, sdk.MethodDiscoverableTypes, nil, &result); err != nil {
+			_ = w.Close()
+			return nil, err
+		}
+		c.discoverableTypes = result.Types
+	}
+	return c, nil
+}
+
+// Close asks the plugin to exit by closing its input, and kills it if it
+// has not within a few seconds
+func (c *Client) Close() error {
+	err := c.conn.w.Close()
+	if c.cmd == nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.cmd.Wait() }()
+	select {
+	case err = <-done:
+	case <-time.After(closeTimeout):
+		_ = c.cmd.Process.Kill()
+		<-done
+		err = fmt.Errorf("plugin %s did not exit and was killed", c.name)
+	}
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", c.name, err)
+	}
+	return nil
+}
+
+// Name returns the provider's name
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Create creates a resource, passing on the idempotency token and
+// ownership in ctx. A resource the plugin created before failing is
+// returned with the error.
+func (c *Client) Create(ctx context.Context, resourceType string, config map[string]interface{}) (provider.Resource, error) {
+	params := &sdk.CreateParams{
+		Type:             resourceType,
+		Config:           config,
+		IdempotencyToken: provider.IdempotencyToken(ctx),
+	}
+	if o, ok := provider.OwnershipFromContext(ctx); ok {
+		params.Ownership = &o
+	}
+
+	var result sdk.ResourceResult
+	err := c.conn.call(ctx, sdk.MethodCreate, params, &result)
+	var e *sdk.Error
+	if errors.As(err, &e) && len(e.Data) > 0 {
+		if decodeErr := sdk.Decode(e.Data, &result); decodeErr == nil && result.Resource != nil {
+			return result.Resource, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.resource(result.Resource)
+}
+
+// Read retrieves an existing resource
+func (c *Client) Read(ctx context.Context, resourceType string, id string) (provider.Resource, error) {
+	var result sdk.ResourceResult
+	if err := c.conn.call(ctx, sdk.MethodRead, &sdk.ReadParams{Type: resourceType, ID: id}, &result); err != nil {
+		return nil, err
+	}
+	return c.resource(result.Resource)
+}
+
+// Update updates an existing resource, passing on the ownership in ctx
+func (c *Client) Update(ctx context.Context, resource provider.Resource, config map[string]interface{}) error {
+	params := &sdk.UpdateParams{Resource: sdk.ToBaseResource(resource), Config: config}
+	if o, ok := provider.OwnershipFromContext(ctx); ok {
+		params.Ownership = &o
+	}
+	return c.conn.call(ctx, sdk.MethodUpdate, params, nil)
+}
+
+// Delete removes an existing resource
+func (c *Client) Delete(ctx context.Context, resource provider.Resource) error {
+	params := &sdk.DeleteParams{Resource: sdk.ToBaseResource(resource)}
+	if o, ok := provider.OwnershipFromContext(ctx); ok {
+		params.Ownership = &o
+	}
+	return c.conn.call(ctx, sdk.MethodDelete, params, nil)
+}
+
+// FindByToken returns the resource created with token
+func (c *Client) FindByToken(ctx context.Context, resourceType string, token string) (provider.Resource, error) {
+	if !c.capabilities[sdk.CapabilityFindByToken] {
+		return nil, fmt.Errorf("provider %s cannot look up resources by token", c.name)
+	}
+	var result sdk.ResourceResult
+	if err := c.conn.call(ctx, sdk.MethodFindByToken, &sdk.FindByTokenParams{Type: resourceType, Token: token}, &result); err != nil {
+		return nil, err
+	}
+	return c.resource(result.Resource)
+}
+
+// ReadData returns the attributes of a data source
+func (c *Client) ReadData(ctx context.Context, dataType string, config map[string]interface{}) (map[string]interface{}, error) {
+	if !c.capabilities[sdk.CapabilityReadData] {
+		return nil, fmt.Errorf("provider %s has no data sources", c.name)
+	}
+	var result sdk.ConfigResult
+	if err := c.conn.call(ctx, sdk.MethodReadData, &sdk.ConfigParams{Type: dataType, Config: config}, &result); err != nil {
+		return nil, err
+	}
+	return result.Config, nil
+}
+
+// NormalizeConfig returns config in the plugin's canonical form, or as it
+// is if the plugin has none or fails
+func (c *Client) NormalizeConfig(resourceType string, config map[string]interface{}) map[string]interface{} {
+	if !c.capabilities[sdk.CapabilityNormalizeConfig] {
+		return config
+	}
+	var result sdk.ConfigResult
+	if err := c.conn.call(context.Background(), sdk.MethodNormalizeConfig, &sdk.ConfigParams{Type: resourceType, Config: config}, &result); err != nil {
+		return config
+	}
+	return result.Config
+}
+
+// ValidateConfig checks that the plugin has resourceType, and has the
+// plugin check config where it can
+func (c *Client) ValidateConfig(ctx context.Context, resourceType string, config map[string]interface{}) error {
+	if !slices.Contains(c.schema.ResourceTypes, resourceType) {
+		return fmt.Errorf("provider %s has no resource type %q", c.name, resourceType)
+	}
+	if !c.capabilities[sdk.CapabilityValidateConfig] {
+		return nil
+	}
+	return c.conn.call(ctx, sdk.MethodValidateConfig, &sdk.ConfigParams{Type: resourceType, Config: config}, nil)
+}
+
+// Schema returns the attributes of the plugin's resource types, or nil if
+// it does not describe them
+func (c *Client) Schema() *provider.Schema {
+	if len(c.schema.Resources) == 0 {
+		return nil
+	}
+	return &provider.Schema{Resources: c.schema.Resources}
+}
+
+// SensitiveAttributes returns the secret attributes of resourceType
+func (c *Client) SensitiveAttributes(resourceType string) []string {
+	return c.schema.Sensitive[resourceType]
+}
+
+// DiscoverableTypes returns the resource types Discover can list
+func (c *Client) DiscoverableTypes() []string {
+	return c.discoverableTypes
+}
+
+// Discover returns the existing resources of resourceType carrying tags
+func (c *Client) Discover(ctx context.Context, resourceType string, tags map[string]string) ([]provider.Discovered, error) {
+	if !c.capabilities[sdk.CapabilityDiscover] {
+		return nil, fmt.Errorf("provider %s cannot discover resources", c.name)
+	}
+	var result sdk.DiscoverResult
+	if err := c.conn.call(ctx, sdk.MethodDiscover, &sdk.DiscoverParams{Type: resourceType, Tags: tags}, &result); err != nil {
+		return nil, err
+	}
+	out := make([]provider.Discovered, len(result.Resources))
+	for i, d := range result.Resources {
+		res, err := c.resource(d.Resource)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = provider.Discovered{Resource: res, Config: d.Config}
+	}
+	return out, nil
+}
+
+// resource checks that a result holds a resource
+func (c *Client) resource(r *types.BaseResource) (provider.Resource, error) {
+	if r == nil {
+		return nil, fmt.Errorf("plugin %s returned no resource", c.name)
+	}
+	return r, nil
+}
+
+// conn sends requests to a plugin and matches up its responses
+type conn struct {
+	w io.WriteCloser
+
+	mu      sync.Mutex
+	enc     *json.Encoder
+	nextID  int64
+	pending map[int64]chan *sdk.Message
+	// err is why the plugin can no longer be read from, once it cannot
+	err error
+}
+
+// read delivers responses until r ends, then fails what is still pending
+func (c *conn) read(r io.Reader) {
+	dec := json.NewDecoder(r)
+	for {
+		var msg sdk.Message
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("plugin exited")
+			} else {
+				err = fmt.Errorf("failed to read from plugin: %w", err)
+			}
+			c.mu.Lock()
+			c.err = err
+			for id, ch := range c.pending {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+			return
+		}
+		if msg.ID == nil {
+			continue
+		}
+
+		c.mu.Lock()
+		ch := c.pending[*msg.ID]
+		delete(c.pending, *msg.ID)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- &msg
+		}
+	}
+}
+
+// call sends a request and decodes its result into result. If ctx ends
+// first, the plugin is asked to cancel the request and ctx's error is
+// returned without waiting for it.
+func (c *conn) call(ctx context.Context, method string, params, result interface{}) error {
+	msg := sdk.Message{JSONRPC: sdk.JSONRPCVersion, Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s request: %w", method, err)
+		}
+		msg.Params = data
+	}
+	ch := make(chan *sdk.Message, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	msg.ID = &id
+	c.pending[id] = ch
+	err := c.enc.Encode(msg)
+	if err != nil {
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to send %s request to plugin: %w", method, err)
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.err
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil {
+			return nil
+		}
+		if err := sdk.Decode(resp.Result, result); err != nil {
+			return fmt.Errorf("failed to decode %s response from plugin: %w", method, err)
+		}
+		return nil
+	case <-ctx.Done():
+		c.cancel(id)
+		return ctx.Err()
+	}
+}
+
+// cancel asks the plugin to give up on request id
+func (c *conn) cancel(id int64) {
+	params, _ := json.Marshal(&sdk.CancelParams{ID: id})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A plugin that cannot be written to is not running the request either
+	_ = c.enc.Encode(sdk.Message{JSONRPC: sdk.JSONRPCVersion, Method: sdk.MethodCancel, Params: params})
+}
@@ -0,0 +1,352 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	sdk "github.com/rebelopsio/duet/pkg/plugin"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// envServe makes the test binary serve memoryPlugin instead of running
+// tests, so Launch can start it
+const envServe = "DUET_TEST_SERVE_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(envServe) != "" {
+		if err := sdk.Serve(memoryPlugin); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+var memoryPlugin = sdk.Plugin{
+	Name:          "memory",
+	ResourceTypes: []string{"thing", "slow"},
+	DataTypes:     []string{"echo"},
+	New: func(ctx context.Context, settings map[string]interface{}) (sdk.Provider, error) {
+		prefix, _ := settings["prefix"].(string)
+		if prefix == "" {
+			return nil, errors.New("prefix is required")
+		}
+		return &memoryProvider{prefix: prefix, resources: make(map[string]*types.BaseResource)}, nil
+	},
+}
+
+// memoryProvider keeps things in memory. Slow things are created only once
+// their create is cancelled; things without a size are created but fail.
+type memoryProvider struct {
+	prefix    string
+	mu        sync.Mutex
+	resources map[string]*types.BaseResource
+	tokens    map[string]string
+}
+
+func (p *memoryProvider) Name() string { return "memory" }
+
+func (p *memoryProvider) Create(ctx context.Context, resourceType string, config map[string]interface{}) (sdk.Resource, error) {
+	if resourceType == "slow" {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := &types.BaseResource{
+		ID:       fmt.Sprintf("%s-%d", p.prefix, len(p.resources)+1),
+		Type:     types.ResourceType(resourceType),
+		Provider: "memory",
+		Status:   types.StatusRunning,
+		Metadata: map[string]interface{}{"config": config, "secret": "hunter2"},
+		Tags:     map[string]string{},
+	}
+	if o, ok := sdk.OwnershipFromContext(ctx); ok {
+		res.Tags = o.Tags()
+	}
+	if token := sdk.IdempotencyToken(ctx); token != "" {
+		if p.tokens == nil {
+			p.tokens = make(map[string]string)
+		}
+		p.tokens[token] = res.ID
+	}
+	p.resources[res.ID] = res
+	if _, ok := config["size"]; !ok {
+		return res, errors.New("thing never became ready")
+	}
+	return res, nil
+}
+
+func (p *memoryProvider) Read(ctx context.Context, resourceType string, id string) (sdk.Resource, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res, ok := p.resources[id]
+	if !ok {
+		return nil, sdk.ErrNotFound
+	}
+	return res, nil
+}
+
+func (p *memoryProvider) Update(ctx context.Context, resource sdk.Resource, config map[string]interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res, ok := p.resources[resource.GetID()]
+	if !ok {
+		return sdk.ErrNotFound
+	}
+	res.Metadata["config"] = config
+	return nil
+}
+
+func (p *memoryProvider) Delete(ctx context.Context, resource sdk.Resource) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.resources, resource.GetID())
+	return nil
+}
+
+func (p *memoryProvider) FindByToken(ctx context.Context, resourceType string, token string) (sdk.Resource, error) {
+	p.mu.Lock()
+	id, ok := p.tokens[token]
+	p.mu.Unlock()
+	if !ok {
+		return nil, sdk.ErrNotFound
+	}
+	return p.Read(ctx, resourceType, id)
+}
+
+func (p *memoryProvider) ReadData(ctx context.Context, dataType string, config map[string]interface{}) (map[string]interface{}, error) {
+	return config, nil
+}
+
+func (p *memoryProvider) ValidateConfig(ctx context.Context, resourceType string, config map[string]interface{}) error {
+	if size, ok := config["size"]; ok {
+		if _, ok := size.(int); !ok {
+			return fmt.Errorf("size: expected a whole number, got %v", size)
+		}
+	}
+	return nil
+}
+
+func (p *memoryProvider) SensitiveAttributes(resourceType string) []string {
+	return []string{"secret"}
+}
+
+func (p *memoryProvider) Schema() *sdk.Schema {
+	return &sdk.Schema{Resources: map[string]*sdk.ResourceSchema{
+		"thing": {Attributes: map[string]*sdk.Attribute{
+			"size":  {Type: sdk.TypeInt, Default: 1},
+			"ratio": {Type: sdk.TypeNumber},
+			"names": {Type: sdk.TypeList, Elem: &sdk.Attribute{Type: sdk.TypeString}},
+		}},
+		"slow": {Attributes: map[string]*sdk.Attribute{}},
+	}}
+}
+
+// connect serves memoryPlugin in process and returns a client for it
+func connect(t *testing.T, settings map[string]interface{}) (*Client, error) {
+	t.Helper()
+	toPlugin, fromClient := io.Pipe()
+	toClient, fromPlugin := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = sdk.ServeConn(context.Background(), memoryPlugin, toPlugin, fromPlugin)
+		_ = fromPlugin.Close()
+	}()
+	t.Cleanup(func() {
+		_ = fromClient.Close()
+		<-done
+	})
+	return NewClient(context.Background(), "memory", toClient, fromClient, settings)
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c, err := connect(t, map[string]interface{}{"prefix": "thing"})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	owner := provider.Ownership{Project: "shop", Address: "memory.thing.a"}
+	created, err := c.Create(provider.WithOwnership(provider.WithIdempotencyToken(ctx, "token-1"), owner), "thing", map[string]interface{}{
+		"size":  3,
+		"ratio": 0.5,
+		"names": []interface{}{"a", "b"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		want := map[string]interface{}{"size": 3, "ratio": 0.5, "names": []interface{}{"a", "b"}}
+		if got := created.GetMetadata()["config"]; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected config %v to round-trip with its number types, got %v", want, got)
+		}
+		if !reflect.DeepEqual(created.GetTags(), owner.Tags()) {
+			t.Errorf("Expected ownership tags %v, got %v", owner.Tags(), created.GetTags())
+		}
+	})
+
+	t.Run("NotReady", func(t *testing.T) {
+		res, err := c.Create(ctx, "thing", map[string]interface{}{})
+		if err == nil || err.Error() != "thing never became ready" {
+			t.Fatalf("Expected the provider's error, got %v", err)
+		}
+		if res == nil || res.GetID() == "" {
+			t.Error("Expected the created resource to be returned with the error")
+		}
+	})
+
+	t.Run("ReadUpdateDelete", func(t *testing.T) {
+		if err := c.Update(ctx, created, map[string]interface{}{"size": 4}); err != nil {
+			t.Fatalf("Failed to update: %v", err)
+		}
+		res, err := c.Read(ctx, "thing", created.GetID())
+		if err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+		if got := res.GetMetadata()["config"]; !reflect.DeepEqual(got, map[string]interface{}{"size": 4}) {
+			t.Errorf("Expected the updated config, got %v", got)
+		}
+		if err := c.Delete(ctx, res); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+		if _, err := c.Read(ctx, "thing", created.GetID()); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound after delete, got %v", err)
+		}
+	})
+
+	t.Run("FindByToken", func(t *testing.T) {
+		if _, err := c.FindByToken(ctx, "thing", "token-2"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown token, got %v", err)
+		}
+	})
+
+	t.Run("ReadData", func(t *testing.T) {
+		attributes, err := c.ReadData(ctx, "echo", map[string]interface{}{"id": "x"})
+		if err != nil || attributes["id"] != "x" {
+			t.Errorf("Expected the data source's attributes, got %v, %v", attributes, err)
+		}
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		if got := c.SensitiveAttributes("thing"); !reflect.DeepEqual(got, []string{"secret"}) {
+			t.Errorf("Expected sensitive attributes [secret], got %v", got)
+		}
+		if err := c.ValidateConfig(ctx, "thing", map[string]interface{}{"size": 2}); err != nil {
+			t.Errorf("Expected a valid config, got %v", err)
+		}
+		if err := c.ValidateConfig(ctx, "thing", map[string]interface{}{"size": "big"}); err == nil {
+			t.Error("Expected the plugin to reject the config, got nil")
+		}
+		if err := c.ValidateConfig(ctx, "widget", nil); err == nil {
+			t.Error("Expected an error for a resource type the plugin does not have, got nil")
+		}
+
+		schema := c.Schema()
+		if schema == nil || len(schema.Resources) != 2 {
+			t.Fatalf("Expected the schema of both resource types, got %+v", schema)
+		}
+		size := schema.Resources["thing"].Attributes["size"]
+		if size.Type != provider.TypeInt || size.Default != 1 {
+			t.Errorf("Expected size to be an int defaulting to 1, got %+v", size)
+		}
+		if diags := schema.Resources["thing"].Validate(map[string]interface{}{"names": []interface{}{"a", 2}}); len(diags) != 1 {
+			t.Errorf("Expected one problem with names, got %v", diags)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		config := map[string]interface{}{"a": 1}
+		if got := c.NormalizeConfig("thing", config); !reflect.DeepEqual(got, config) {
+			t.Errorf("Expected config to be left as it is, got %v", got)
+		}
+		if types := c.DiscoverableTypes(); len(types) != 0 {
+			t.Errorf("Expected no discoverable types, got %v", types)
+		}
+		if _, err := c.Discover(ctx, "thing", nil); err == nil {
+			t.Error("Expected an error discovering, got nil")
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		if _, err := c.Create(ctx, "slow", map[string]interface{}{}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected the deadline to end the call, got %v", err)
+		}
+		// The plugin carries on serving other requests
+		if _, err := c.Read(context.Background(), "thing", "none"); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestNewClient(t *testing.T) {
+	t.Run("SettingsError", func(t *testing.T) {
+		if _, err := connect(t, nil); err == nil || err.Error() != "prefix is required" {
+			t.Errorf("Expected the plugin's error, got %v", err)
+		}
+	})
+
+	t.Run("WrongName", func(t *testing.T) {
+		toPlugin, fromClient := io.Pipe()
+		toClient, fromPlugin := io.Pipe()
+		go func() {
+			_ = sdk.ServeConn(context.Background(), memoryPlugin, toPlugin, fromPlugin)
+			_ = fromPlugin.Close()
+		}()
+		_, err := NewClient(context.Background(), "other", toClient, fromClient, map[string]interface{}{"prefix": "x"})
+		if err == nil || err.Error() != `plugin serves provider "memory", not "other"` {
+			t.Errorf("Expected a name mismatch error, got %v", err)
+		}
+	})
+}
+
+func TestLaunch(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Failed to find test binary: %v", err)
+	}
+	t.Setenv(envServe, "1")
+	ctx := context.Background()
+
+	c, err := Launch(ctx, exe, "memory", map[string]interface{}{"prefix": "proc"})
+	if err != nil {
+		t.Fatalf("Failed to launch plugin: %v", err)
+	}
+	res, err := c.Create(ctx, "thing", map[string]interface{}{"size": 1})
+	if err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	if res.GetID() != "proc-1" {
+		t.Errorf("Expected ID proc-1, got %s", res.GetID())
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Expected the plugin to exit cleanly, got %v", err)
+	}
+	if _, err := c.Read(ctx, "thing", res.GetID()); err == nil {
+		t.Error("Expected an error calling a closed plugin, got nil")
+	}
+
+	t.Run("NotAPlugin", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "duet-provider-memory")
+		if err := os.WriteFile(path, []byte("#!/bin/sh\necho hello\n"), 0o755); err != nil {
+			t.Fatalf("Failed to write script: %v", err)
+		}
+		if _, err := Launch(ctx, path, "memory", nil); err == nil {
+			t.Error("Expected an error launching something that is not a plugin, got nil")
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// BinaryPrefix starts the file name of every plugin; the name of the
+// provider it serves follows
+const BinaryPrefix = "duet-provider-"
+
+// EnvPath lists directories to search for plugins before the default ones
+const EnvPath = "DUET_PLUGIN_PATH"
+
+// ErrNotInstalled is returned by Find when no directory holds the plugin
+var ErrNotInstalled = errors.New("plugin not installed")
+
+// namePattern matches the provider names a plugin can serve
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Dirs returns the directories searched for plugins, in order: those
+// listed in DUET_PLUGIN_PATH, then .duet/plugins in the home directory. The
+// working directory is never searched unless listed, so planning in a
+// checkout cannot run a plugin the checkout ships.
+func Dirs() []string {
+	var dirs []string
+	for _, dir := range filepath.SplitList(os.Getenv(EnvPath)) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".duet", "plugins"))
+	}
+	return dirs
+}
+
+// Find returns the path of the first executable in dirs named after the
+// provider, such as duet-provider-example for provider "example"
+func Find(name string, dirs []string) (string, error) {
+	if !namePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid provider name %q", name)
+	}
+	binary := BinaryPrefix + name
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, binary)
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm()&0o111 == 0 {
+			return "", fmt.Errorf("plugin %s is not executable", path)
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("%w: no %s in %s", ErrNotInstalled, binary, strings.Join(dirs, ", "))
+}
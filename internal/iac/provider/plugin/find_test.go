@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	write := func(dir, name string, mode os.FileMode) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+		return path
+	}
+	want := write(second, "duet-provider-example", 0o755)
+	write(first, "duet-provider-other", 0o644)
+	if err := os.Mkdir(filepath.Join(first, "duet-provider-dir"), 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	dirs := []string{first, second}
+
+	t.Run("Found", func(t *testing.T) {
+		if path, err := Find("example", dirs); err != nil || path != want {
+			t.Errorf("Expected %s, got %s, %v", want, path, err)
+		}
+	})
+
+	t.Run("FirstWins", func(t *testing.T) {
+		path := write(first, "duet-provider-example", 0o755)
+		defer func() { _ = os.Remove(path) }()
+		if got, err := Find("example", dirs); err != nil || got != path {
+			t.Errorf("Expected %s, got %s, %v", path, got, err)
+		}
+	})
+
+	t.Run("NotInstalled", func(t *testing.T) {
+		for _, name := range []string{"missing", "dir"} {
+			if _, err := Find(name, dirs); !errors.Is(err, ErrNotInstalled) {
+				t.Errorf("Expected ErrNotInstalled for %s, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("NotExecutable", func(t *testing.T) {
+		if _, err := Find("other", dirs); err == nil || errors.Is(err, ErrNotInstalled) {
+			t.Errorf("Expected an error for a plugin that is not executable, got %v", err)
+		}
+	})
+
+	t.Run("InvalidName", func(t *testing.T) {
+		if _, err := Find("../example", dirs); err == nil {
+			t.Error("Expected an error for a name with a path in it, got nil")
+		}
+	})
+}
+
+func TestDirs(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(EnvPath, "/opt/duet"+string(os.PathListSeparator)+"/usr/lib/duet")
+
+	want := []string{"/opt/duet", "/usr/lib/duet", filepath.Join(home, ".duet", "plugins")}
+	if got := Dirs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
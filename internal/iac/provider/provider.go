@@ -2,6 +2,8 @@ package provider
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/rebelopsio/duet/pkg/types"
 )
@@ -9,6 +11,9 @@ import (
 // Resource is an alias for types.Resource to maintain package consistency
 type Resource = types.Resource
 
+// ErrNotFound is returned by Read when the resource no longer exists
+var ErrNotFound = errors.New("resource not found")
+
 // Provider defines the interface for infrastructure providers
 type Provider interface {
 	// Name returns the provider's name
@@ -26,3 +31,175 @@ type Provider interface {
 	// Delete removes an existing resource
 	Delete(ctx context.Context, resource Resource) error
 }
+
+// TokenFinder is implemented by providers that can locate a resource from the
+// idempotency token it was created with. It lets an interrupted create be
+// reconciled even though its ID was never recorded.
+type TokenFinder interface {
+	// FindByToken returns the resource created with token, or ErrNotFound
+	FindByToken(ctx context.Context, resourceType string, token string) (Resource, error)
+}
+
+// ConfigFinder is implemented by providers whose resource IDs follow from
+// their config, such as a file's path, and that have no token to find a
+// resource by. It lets an interrupted create be reconciled all the same.
+type ConfigFinder interface {
+	// FindByConfig returns the resource that creating config would make or
+	// adopt, or ErrNotFound
+	FindByConfig(ctx context.Context, resourceType string, config map[string]interface{}) (Resource, error)
+}
+
+// DataReader is implemented by providers with read-only data sources, which
+// look up existing infrastructure, such as an AMI or a VPC created
+// elsewhere, without managing it
+type DataReader interface {
+	// ReadData returns the attributes of the data source of the given type
+	// matching config. The "id" attribute identifies what was found.
+	ReadData(ctx context.Context, dataType string, config map[string]interface{}) (map[string]interface{}, error)
+}
+
+// ConfigNormalizer is implemented by providers whose configs hold values
+// that can be written several equivalent ways, such as JSON documents. The
+// planner compares normalised configs, so rewriting a value without changing
+// its meaning does not plan an update.
+type ConfigNormalizer interface {
+	// NormalizeConfig returns config with such values in a canonical form.
+	// Values it cannot parse are left as they are.
+	NormalizeConfig(resourceType string, config map[string]interface{}) map[string]interface{}
+}
+
+// DriftDetector is implemented by providers that can cheaply tell whether a
+// resource was changed outside duet. The planner asks about resources whose
+// config has not changed, and plans an update for those that drifted and a
+// create for those that are gone.
+type DriftDetector interface {
+	// Drifted reports whether resource no longer matches config, with its
+	// references resolved. It returns ErrNotFound if the resource is gone.
+	Drifted(ctx context.Context, resource Resource, config map[string]interface{}) (bool, error)
+}
+
+// ConfigValidator is implemented by providers that can check a resource's
+// config before it is planned, so mistakes are reported before anything is
+// changed rather than halfway through an apply
+type ConfigValidator interface {
+	// ValidateConfig returns an error describing what is wrong with config
+	// for resourceType. References to other resources that have not been
+	// resolved yet are strings of the form ${provider.type.name.attribute}.
+	ValidateConfig(ctx context.Context, resourceType string, config map[string]interface{}) error
+}
+
+// SensitiveMarker is implemented by providers whose resources return
+// secrets, such as generated private keys, in their metadata. Those
+// attributes are encrypted in state instead of being stored with the rest
+// of the metadata, and are kept across updates, since reading a resource
+// back usually cannot return them.
+type SensitiveMarker interface {
+	// SensitiveAttributes returns the names of the metadata attributes of
+	// resourceType that are secret
+	SensitiveAttributes(resourceType string) []string
+}
+
+// Discovered is an existing resource found by a Discoverer, with the config
+// that declares it as it is
+type Discovered struct {
+	Resource Resource
+	Config   map[string]interface{}
+}
+
+// Discoverer is implemented by providers that can list the resources in an
+// account, so ones created outside duet can be brought under management
+type Discoverer interface {
+	// DiscoverableTypes returns the resource types Discover can list
+	DiscoverableTypes() []string
+
+	// Discover returns the resources of resourceType carrying every tag in
+	// tags, where an empty value matches any value. Resources that only
+	// exist as part of another resource are left out; ownership tags are
+	// left for the caller to check. Applying the config of each changes
+	// nothing.
+	Discover(ctx context.Context, resourceType string, tags map[string]string) ([]Discovered, error)
+}
+
+type idempotencyTokenKey struct{}
+
+type ownershipKey struct{}
+
+// WithIdempotencyToken returns a context carrying the token a provider should
+// attach to the resource it creates, where the underlying API supports it
+func WithIdempotencyToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, idempotencyTokenKey{}, token)
+}
+
+// IdempotencyToken returns the token set by WithIdempotencyToken, if any
+func IdempotencyToken(ctx context.Context) string {
+	token, _ := ctx.Value(idempotencyTokenKey{}).(string)
+	return token
+}
+
+// Tags duet stamps on resources it creates or updates, on providers that
+// support tags
+const (
+	TagProject   = "duet:project"
+	TagWorkspace = "duet:workspace"
+	TagAddress   = "duet:address"
+	TagRunID     = "duet:run-id"
+)
+
+// Ownership identifies the configuration a resource belongs to: the
+// project, the workspace, its address and the run that last wrote it
+type Ownership struct {
+	Project   string
+	Workspace string
+	Address   string
+	RunID     string
+}
+
+// Tags returns the ownership tags for the fields that are set
+func (o Ownership) Tags() map[string]string {
+	tags := make(map[string]string, 4)
+	for key, value := range map[string]string{
+		TagProject:   o.Project,
+		TagWorkspace: o.Workspace,
+		TagAddress:   o.Address,
+		TagRunID:     o.RunID,
+	} {
+		if value != "" {
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
+// Check returns an error unless tags carry the project, workspace and
+// address of o, ignoring fields that are not set. The run ID is not
+// compared, since every run that writes a resource replaces it. It lets
+// imports refuse resources duet did not create for this configuration.
+func (o Ownership) Check(tags map[string]string) error {
+	for _, want := range [][2]string{
+		{TagProject, o.Project},
+		{TagWorkspace, o.Workspace},
+		{TagAddress, o.Address},
+	} {
+		if want[1] == "" {
+			continue
+		}
+		if got, ok := tags[want[0]]; !ok {
+			return fmt.Errorf("not tagged %s=%s", want[0], want[1])
+		} else if got != want[1] {
+			return fmt.Errorf("tagged %s=%s, not %s", want[0], got, want[1])
+		}
+	}
+	return nil
+}
+
+// WithOwnership returns a context carrying the ownership a provider should
+// tag the resource it creates or updates with
+func WithOwnership(ctx context.Context, o Ownership) context.Context {
+	return context.WithValue(ctx, ownershipKey{}, o)
+}
+
+// OwnershipFromContext returns the ownership set by WithOwnership, if any
+func OwnershipFromContext(ctx context.Context) (Ownership, bool) {
+	o, ok := ctx.Value(ownershipKey{}).(Ownership)
+	return o, ok
+}
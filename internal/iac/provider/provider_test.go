@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOwnership(t *testing.T) {
+	owner := Ownership{Project: "shop", Workspace: "prod", Address: "aws.vpc.main", RunID: "run-1"}
+
+	t.Run("Tags", func(t *testing.T) {
+		want := map[string]string{
+			TagProject:   "shop",
+			TagWorkspace: "prod",
+			TagAddress:   "aws.vpc.main",
+			TagRunID:     "run-1",
+		}
+		if tags := owner.Tags(); !reflect.DeepEqual(tags, want) {
+			t.Errorf("Expected tags %v, got %v", want, tags)
+		}
+		if tags := (Ownership{Workspace: "prod"}).Tags(); len(tags) != 1 {
+			t.Errorf("Expected unset fields to be left out, got %v", tags)
+		}
+	})
+
+	t.Run("Check", func(t *testing.T) {
+		tags := owner.Tags()
+		tags[TagRunID] = "run-2"
+		if err := owner.Check(tags); err != nil {
+			t.Errorf("Expected tags from another run to pass, got %v", err)
+		}
+		if err := (Ownership{Project: "shop"}).Check(tags); err != nil {
+			t.Errorf("Expected a project-only check to pass, got %v", err)
+		}
+
+		tests := []struct {
+			name  string
+			owner Ownership
+			tags  map[string]string
+			want  string
+		}{
+			{name: "OtherWorkspace", owner: Ownership{Project: "shop", Workspace: "staging"}, tags: tags, want: "tagged duet:workspace=prod, not staging"},
+			{name: "Untagged", owner: Ownership{Project: "shop"}, tags: map[string]string{"Name": "main"}, want: "not tagged duet:project=shop"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if err := tt.owner.Check(tt.tags); err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("Expected error containing %q, got %v", tt.want, err)
+				}
+			})
+		}
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		if _, ok := OwnershipFromContext(context.Background()); ok {
+			t.Error("Expected no ownership on a plain context")
+		}
+		got, ok := OwnershipFromContext(WithOwnership(context.Background(), owner))
+		if !ok || got != owner {
+			t.Errorf("Expected ownership %+v, got %+v", owner, got)
+		}
+	})
+}
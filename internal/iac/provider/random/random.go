@@ -0,0 +1,268 @@
+// Package random implements the random provider, which generates strings,
+// passwords and UUIDs once and keeps them in state
+package random
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/internal/iac/provider/generated"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// Character classes strings are made of
+const (
+	lowerChars   = "abcdefghijklmnopqrstuvwxyz"
+	upperChars   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	numericChars = "0123456789"
+	specialChars = "!@#$%&*()-_=+[]{}<>:?"
+)
+
+// maxLength bounds the length of generated strings
+const maxLength = 1024
+
+// stringAttributes returns the inputs of string and password resources,
+// which differ only in whether special characters are used by default
+func stringAttributes(special bool) map[string]*provider.Attribute {
+	return map[string]*provider.Attribute{
+		"length":           {Type: provider.TypeInt, Required: true},
+		"lower":            {Type: provider.TypeBool, Default: true},
+		"upper":            {Type: provider.TypeBool, Default: true},
+		"numeric":          {Type: provider.TypeBool, Default: true},
+		"special":          {Type: provider.TypeBool, Default: special},
+		"override_special": {Type: provider.TypeString, Description: "The special characters to use instead of " + specialChars},
+		"min_lower":        {Type: provider.TypeInt, Default: 0},
+		"min_upper":        {Type: provider.TypeInt, Default: 0},
+		"min_numeric":      {Type: provider.TypeInt, Default: 0},
+		"min_special":      {Type: provider.TypeInt, Default: 0},
+		"keepers":          generated.KeepersAttribute,
+	}
+}
+
+var stringSchema = func() *provider.ResourceSchema {
+	attrs := stringAttributes(false)
+	attrs["result"] = &provider.Attribute{Type: provider.TypeString, Computed: true}
+	return &provider.ResourceSchema{
+		Description: "A random string, such as a suffix for names that must be unique",
+		Attributes:  attrs,
+	}
+}()
+
+var passwordSchema = func() *provider.ResourceSchema {
+	attrs := stringAttributes(true)
+	attrs["result"] = &provider.Attribute{Type: provider.TypeString, Computed: true, Sensitive: true}
+	attrs["bcrypt_hash"] = &provider.Attribute{Type: provider.TypeString, Computed: true, Description: "The password hashed with bcrypt"}
+	return &provider.ResourceSchema{
+		Description: "A random password, sealed in state",
+		Attributes:  attrs,
+	}
+}()
+
+var uuidSchema = &provider.ResourceSchema{
+	Description: "A random version 4 UUID",
+	Attributes: map[string]*provider.Attribute{
+		"keepers": generated.KeepersAttribute,
+		"result":  {Type: provider.TypeString, Computed: true},
+	},
+}
+
+// NewRandomProvider returns the random provider, which reads the values it
+// generated back from store
+func NewRandomProvider(store *state.Store) *generated.Provider {
+	return generated.NewProvider("random", store, map[string]generated.Generator{
+		string(types.ResourceTypeRandomString): {
+			Schema:   stringSchema,
+			Generate: generateString,
+			Validate: validateString(stringSchema),
+		},
+		string(types.ResourceTypeRandomPassword): {
+			Schema:   passwordSchema,
+			Generate: generatePassword,
+			Validate: validateString(passwordSchema),
+		},
+		string(types.ResourceTypeRandomUUID): {
+			Schema:   uuidSchema,
+			Generate: generateUUID,
+		},
+	})
+}
+
+// charset is one class of characters and how many of them a string needs
+type charset struct {
+	name  string
+	chars string
+	min   int
+}
+
+// stringSpec describes a string to generate
+type stringSpec struct {
+	length   int
+	charsets []charset
+}
+
+// parseString reads the inputs of a string or password, with the defaults
+// of schema
+func parseString(schema *provider.ResourceSchema, config map[string]interface{}) (*stringSpec, error) {
+	c := generated.NewConfig(schema, config)
+	c.Require("length")
+	spec := &stringSpec{length: c.Int("length")}
+	special := specialChars
+	if c.Has("override_special") {
+		special = c.String("override_special")
+	}
+	classes := []charset{
+		{name: "lower", chars: lowerChars, min: c.Int("min_lower")},
+		{name: "upper", chars: upperChars, min: c.Int("min_upper")},
+		{name: "numeric", chars: numericChars, min: c.Int("min_numeric")},
+		{name: "special", chars: special, min: c.Int("min_special")},
+	}
+	enabled := map[string]bool{}
+	for _, class := range classes {
+		enabled[class.name] = c.Bool(class.name)
+	}
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	if spec.length < 1 || spec.length > maxLength {
+		return nil, fmt.Errorf("attribute length: expected 1 to %d, got %d", maxLength, spec.length)
+	}
+
+	total := 0
+	for _, class := range classes {
+		if class.min < 0 {
+			return nil, fmt.Errorf("attribute min_%s: expected at least 0, got %d", class.name, class.min)
+		}
+		if !enabled[class.name] || class.chars == "" {
+			if class.min > 0 {
+				return nil, fmt.Errorf("attribute min_%s: %s characters are not used", class.name, class.name)
+			}
+			continue
+		}
+		total += class.min
+		spec.charsets = append(spec.charsets, class)
+	}
+	if len(spec.charsets) == 0 {
+		return nil, fmt.Errorf("at least one of lower, upper, numeric and special must be used")
+	}
+	if total > spec.length {
+		return nil, fmt.Errorf("the minimum counts of each class of characters add up to %d, more than the length %d", total, spec.length)
+	}
+	return spec, nil
+}
+
+// validateString returns a check of string or password configs against
+// schema. Configs holding references that have not been resolved yet are
+// checked when they are applied.
+func validateString(schema *provider.ResourceSchema) func(map[string]interface{}) error {
+	return func(config map[string]interface{}) error {
+		for _, v := range config {
+			if s, ok := v.(string); ok && strings.Contains(s, "${") {
+				return nil
+			}
+		}
+		_, err := parseString(schema, config)
+		return err
+	}
+}
+
+// generate returns a random string of spec's length with at least the
+// minimum number of characters of each class
+func (spec *stringSpec) generate() (string, error) {
+	out := make([]byte, 0, spec.length)
+	all := ""
+	for _, c := range spec.charsets {
+		for i := 0; i < c.min; i++ {
+			b, err := randomChar(c.chars)
+			if err != nil {
+				return "", err
+			}
+			out = append(out, b)
+		}
+		all += c.chars
+	}
+	for len(out) < spec.length {
+		b, err := randomChar(all)
+		if err != nil {
+			return "", err
+		}
+		out = append(out, b)
+	}
+
+	// Shuffle, so the required characters are not always first
+	for i := len(out) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out), nil
+}
+
+func randomChar(chars string) (byte, error) {
+	i, err := randomInt(len(chars))
+	if err != nil {
+		return 0, err
+	}
+	return chars[i], nil
+}
+
+func randomInt(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate random number: %w", err)
+	}
+	return int(i.Int64()), nil
+}
+
+func generateString(ctx context.Context, config map[string]interface{}, lookup generated.Lookup) (map[string]interface{}, error) {
+	spec, err := parseString(stringSchema, config)
+	if err != nil {
+		return nil, err
+	}
+	result, err := spec.generate()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"result": result}, nil
+}
+
+func generatePassword(ctx context.Context, config map[string]interface{}, lookup generated.Lookup) (map[string]interface{}, error) {
+	spec, err := parseString(passwordSchema, config)
+	if err != nil {
+		return nil, err
+	}
+	result, err := spec.generate()
+	if err != nil {
+		return nil, err
+	}
+	// bcrypt only reads the first 72 bytes
+	hashed := []byte(result)
+	if len(hashed) > 72 {
+		hashed = hashed[:72]
+	}
+	hash, err := bcrypt.GenerateFromPassword(hashed, bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+	return map[string]interface{}{"result": result, "bcrypt_hash": string(hash)}, nil
+}
+
+func generateUUID(ctx context.Context, config map[string]interface{}, lookup generated.Lookup) (map[string]interface{}, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate UUID: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return map[string]interface{}{
+		"result": fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]),
+	}, nil
+}
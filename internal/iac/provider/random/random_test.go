@@ -0,0 +1,141 @@
+package random
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRandomProvider(t *testing.T) {
+	ctx := context.Background()
+	p := NewRandomProvider(nil)
+
+	t.Run("String", func(t *testing.T) {
+		res, err := p.Create(ctx, "string", map[string]interface{}{"length": 12, "upper": false})
+		if err != nil {
+			t.Fatalf("Failed to create string: %v", err)
+		}
+		result, _ := res.GetMetadata()["result"].(string)
+		if !regexp.MustCompile(`^[a-z0-9]{12}$`).MatchString(result) {
+			t.Errorf("Expected 12 lowercase letters and digits, got %q", result)
+		}
+		if got := p.SensitiveAttributes("string"); len(got) != 0 {
+			t.Errorf("Expected strings not to be sensitive, got %v", got)
+		}
+	})
+
+	t.Run("Minimums", func(t *testing.T) {
+		config := map[string]interface{}{
+			"length":           8,
+			"lower":            false,
+			"min_upper":        3,
+			"min_numeric":      3,
+			"special":          true,
+			"override_special": "-_",
+			"min_special":      2,
+		}
+		for i := 0; i < 20; i++ {
+			res, err := p.Create(ctx, "string", config)
+			if err != nil {
+				t.Fatalf("Failed to create string: %v", err)
+			}
+			result := res.GetMetadata()["result"].(string)
+			counts := map[string]int{}
+			for _, r := range result {
+				switch {
+				case strings.ContainsRune(upperChars, r):
+					counts["upper"]++
+				case strings.ContainsRune(numericChars, r):
+					counts["numeric"]++
+				case strings.ContainsRune("-_", r):
+					counts["special"]++
+				default:
+					t.Fatalf("Unexpected character %q in %q", r, result)
+				}
+			}
+			if counts["upper"] < 3 || counts["numeric"] < 3 || counts["special"] < 2 {
+				t.Fatalf("Expected the minimum of each class in %q", result)
+			}
+		}
+	})
+
+	t.Run("Password", func(t *testing.T) {
+		res, err := p.Create(ctx, "password", map[string]interface{}{"length": 24})
+		if err != nil {
+			t.Fatalf("Failed to create password: %v", err)
+		}
+		meta := res.GetMetadata()
+		result, _ := meta["result"].(string)
+		if len(result) != 24 {
+			t.Errorf("Expected a password of 24 characters, got %q", result)
+		}
+		hash, _ := meta["bcrypt_hash"].(string)
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(result)); err != nil {
+			t.Errorf("Expected the bcrypt hash to match the password: %v", err)
+		}
+		if got := p.SensitiveAttributes("password"); len(got) != 1 || got[0] != "result" {
+			t.Errorf("Expected [result], got %v", got)
+		}
+	})
+
+	t.Run("UUID", func(t *testing.T) {
+		res, err := p.Create(ctx, "uuid", nil)
+		if err != nil {
+			t.Fatalf("Failed to create UUID: %v", err)
+		}
+		result, _ := res.GetMetadata()["result"].(string)
+		if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(result) {
+			t.Errorf("Expected a version 4 UUID, got %q", result)
+		}
+	})
+
+	t.Run("Keepers", func(t *testing.T) {
+		config := map[string]interface{}{"length": 8, "keepers": map[string]interface{}{"ami": "ami-1"}}
+		res, err := p.Create(ctx, "string", config)
+		if err != nil {
+			t.Fatalf("Failed to create string: %v", err)
+		}
+		first := res.GetMetadata()["result"]
+
+		config["keepers"] = map[string]interface{}{"ami": "ami-2"}
+		if err := p.Update(ctx, res, config); err != nil {
+			t.Fatalf("Failed to update string: %v", err)
+		}
+		got, err := p.Read(ctx, "string", res.GetID())
+		if err != nil {
+			t.Fatalf("Failed to read string: %v", err)
+		}
+		if got.GetMetadata()["result"] == first {
+			t.Errorf("Expected a new value when the keepers change, got %v again", first)
+		}
+	})
+
+	t.Run("ValidateConfig", func(t *testing.T) {
+		tests := []struct {
+			name         string
+			resourceType string
+			config       map[string]interface{}
+			wantErr      bool
+		}{
+			{"Valid", "string", map[string]interface{}{"length": 8}, false},
+			{"Missing", "string", map[string]interface{}{}, true},
+			{"TooShort", "password", map[string]interface{}{"length": 0}, true},
+			{"NoClasses", "string", map[string]interface{}{"length": 8, "lower": false, "upper": false, "numeric": false}, true},
+			{"UnusedMinimum", "string", map[string]interface{}{"length": 8, "min_special": 1}, true},
+			{"PasswordSpecial", "password", map[string]interface{}{"length": 8, "min_special": 1}, false},
+			{"MinimumsTooLong", "password", map[string]interface{}{"length": 4, "min_upper": 3, "min_lower": 3}, true},
+			{"Reference", "string", map[string]interface{}{"length": "${random.string.other.length}"}, false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := p.ValidateConfig(ctx, tt.resourceType, tt.config)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,410 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AttributeType is the kind of value an attribute holds
+type AttributeType string
+
+const (
+	TypeString AttributeType = "string"
+	// TypeInt is a whole number
+	TypeInt    AttributeType = "int"
+	TypeNumber AttributeType = "number"
+	TypeBool   AttributeType = "bool"
+	// TypeDuration is a string such as "90s" or "10m", or a number of
+	// seconds
+	TypeDuration AttributeType = "duration"
+	// TypeDocument is a JSON document, written as a string or as a table
+	TypeDocument AttributeType = "document"
+	// TypeList is a list of values described by Elem
+	TypeList AttributeType = "list"
+	// TypeMap is a table of values described by Elem, keyed by strings
+	TypeMap AttributeType = "map"
+	// TypeObject is a table with the fields described by Attributes
+	TypeObject AttributeType = "object"
+	// TypeAny is any value; the provider checks it
+	TypeAny AttributeType = "any"
+)
+
+// Attribute describes one attribute of a resource type
+type Attribute struct {
+	Type        AttributeType `json:"type"`
+	Description string        `json:"description,omitempty"`
+	// Required attributes must be set
+	Required bool `json:"required,omitempty"`
+	// Computed attributes are set by the provider in the resource's
+	// metadata and cannot be configured
+	Computed bool `json:"computed,omitempty"`
+	// Sensitive attributes hold secrets; see SensitiveMarker
+	Sensitive bool `json:"sensitive,omitempty"`
+	// ForceNew attributes cannot change once the resource exists
+	ForceNew bool `json:"force_new,omitempty"`
+	// Default is the value the provider uses when the attribute is not set
+	Default interface{} `json:"default,omitempty"`
+	// Elem describes the items of a list or the values of a map
+	Elem *Attribute `json:"elem,omitempty"`
+	// Attributes describes the fields of an object
+	Attributes map[string]*Attribute `json:"attributes,omitempty"`
+}
+
+// ResourceSchema describes the attributes of a resource type
+type ResourceSchema struct {
+	Description string                `json:"description,omitempty"`
+	Attributes  map[string]*Attribute `json:"attributes"`
+}
+
+// Schema describes the resource types of a provider
+type Schema struct {
+	Resources map[string]*ResourceSchema `json:"resources"`
+}
+
+// SchemaDescriber is implemented by providers that describe the attributes
+// of their resource types. The planner checks configs against the schema
+// before the provider sees them, so a misspelt or mistyped attribute is
+// reported instead of being ignored, and refuses to plan an update that
+// changes a ForceNew attribute.
+type SchemaDescriber interface {
+	// Schema returns the provider's schema
+	Schema() *Schema
+}
+
+// ResourceTypes returns the resource types the schema describes, sorted
+func (s *Schema) ResourceTypes() []string {
+	names := make([]string, 0, len(s.Resources))
+	for name := range s.Resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resource returns the schema of resourceType, or an error suggesting the
+// closest type the schema does describe
+func (s *Schema) Resource(resourceType string) (*ResourceSchema, error) {
+	if r, ok := s.Resources[resourceType]; ok {
+		return r, nil
+	}
+	if match := suggest(resourceType, s.ResourceTypes()); match != "" {
+		return nil, fmt.Errorf("unknown resource type %q, did you mean %q?", resourceType, match)
+	}
+	return nil, fmt.Errorf("unknown resource type %q", resourceType)
+}
+
+// SensitiveAttributes returns the names of the sensitive attributes, sorted
+func (s *ResourceSchema) SensitiveAttributes() []string {
+	if s == nil {
+		return nil
+	}
+	var names []string
+	for name, attr := range s.Attributes {
+		if attr.Sensitive {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForceNewChanges returns the ForceNew attributes that differ between the
+// current attributes of a resource and the desired config, sorted. An
+// attribute that is not set takes its default; one that is not set in
+// desired and has no default is left as it is.
+func (s *ResourceSchema) ForceNewChanges(current, desired map[string]interface{}) []string {
+	var changed []string
+	for name, attr := range s.Attributes {
+		if !attr.ForceNew {
+			continue
+		}
+		want := valueOrDefault(desired[name], attr)
+		if want == nil {
+			continue
+		}
+		if !sameValue(valueOrDefault(current[name], attr), want) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func valueOrDefault(v interface{}, attr *Attribute) interface{} {
+	if v == nil {
+		return attr.Default
+	}
+	return v
+}
+
+// sameValue compares values by their JSON encoding, so numbers read back
+// from state equal those written in Lua
+func sameValue(a, b interface{}) bool {
+	x, errX := json.Marshal(a)
+	y, errY := json.Marshal(b)
+	return errX == nil && errY == nil && string(x) == string(y)
+}
+
+// Diagnostic is a problem with one attribute of a config. Path locates
+// the attribute, with list items numbered from 1 as in Lua, such as
+// block_devices[2].volume_size.
+type Diagnostic struct {
+	Path    string
+	Message string
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("attribute %s: %s", d.Path, d.Message)
+}
+
+// Diagnostics lists every problem found in a config, ordered by path
+type Diagnostics []Diagnostic
+
+func (d Diagnostics) Error() string {
+	msgs := make([]string, len(d))
+	for i, diag := range d {
+		msgs[i] = diag.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks config against the schema and returns what is wrong
+// with it, or nil. Strings holding ${...} references may stand for a value
+// of any type, since they are only resolved when the resource is applied.
+func (s *ResourceSchema) Validate(config map[string]interface{}) Diagnostics {
+	v := &validator{}
+	v.object("", s.Attributes, config)
+	sort.SliceStable(v.diags, func(i, j int) bool { return v.diags[i].Path < v.diags[j].Path })
+	return v.diags
+}
+
+type validator struct {
+	diags Diagnostics
+}
+
+func (v *validator) add(path, format string, args ...interface{}) {
+	v.diags = append(v.diags, Diagnostic{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) object(prefix string, attrs map[string]*Attribute, values map[string]interface{}) {
+	var settable []string
+	for name, attr := range attrs {
+		if !attr.Computed {
+			settable = append(settable, name)
+		}
+	}
+	sort.Strings(settable)
+
+	for name, value := range values {
+		path := prefix + name
+		attr, ok := attrs[name]
+		switch {
+		case !ok:
+			if match := suggest(name, settable); match != "" {
+				v.add(path, "unknown attribute, did you mean %s?", match)
+			} else {
+				v.add(path, "unknown attribute")
+			}
+		case attr.Computed:
+			v.add(path, "set by the provider and cannot be configured")
+		case value != nil:
+			v.value(path, attr, value)
+		}
+	}
+	for _, name := range settable {
+		if attrs[name].Required && values[name] == nil {
+			v.add(prefix+name, "required but not set")
+		}
+	}
+}
+
+func (v *validator) value(path string, attr *Attribute, value interface{}) {
+	if s, ok := value.(string); ok && strings.Contains(s, "${") {
+		return
+	}
+
+	switch attr.Type {
+	case TypeString:
+		if _, ok := value.(string); !ok {
+			v.add(path, "expected a string, got %T", value)
+		}
+	case TypeInt:
+		if !isWhole(value) {
+			v.add(path, "expected a whole number, got %s", describe(value))
+		}
+	case TypeNumber:
+		if _, ok := number(value); !ok {
+			v.add(path, "expected a number, got %T", value)
+		}
+	case TypeBool:
+		if _, ok := value.(bool); !ok {
+			v.add(path, "expected a boolean, got %T", value)
+		}
+	case TypeDuration:
+		if s, ok := value.(string); ok {
+			if _, err := time.ParseDuration(s); err != nil {
+				v.add(path, "expected a duration such as \"10m\", got %q", s)
+			}
+		} else if !isWhole(value) {
+			v.add(path, "expected a duration or a number of seconds, got %s", describe(value))
+		}
+	case TypeDocument:
+		switch value.(type) {
+		case string, map[string]interface{}:
+		default:
+			v.add(path, "expected a document as a string or a table, got %T", value)
+		}
+	case TypeList:
+		items, ok := listItems(value)
+		if !ok {
+			v.add(path, "expected a list, got %s", describe(value))
+			return
+		}
+		if attr.Elem != nil {
+			for i, item := range items {
+				v.item(fmt.Sprintf("%s[%d]", path, i+1), attr.Elem, item)
+			}
+		}
+	case TypeMap:
+		entries, ok := mapEntries(value)
+		if !ok {
+			v.add(path, "expected a table, got %s", describe(value))
+			return
+		}
+		if attr.Elem != nil {
+			for key, item := range entries {
+				v.item(path+"."+key, attr.Elem, item)
+			}
+		}
+	case TypeObject:
+		fields, ok := mapEntries(value)
+		if !ok {
+			v.add(path, "expected a table, got %s", describe(value))
+			return
+		}
+		v.object(path+".", attr.Attributes, fields)
+	}
+}
+
+// item checks a list item or map value, which unlike an attribute cannot
+// be left out by setting it to nil
+func (v *validator) item(path string, attr *Attribute, value interface{}) {
+	if value == nil {
+		v.add(path, "expected a value, got nil")
+		return
+	}
+	v.value(path, attr, value)
+}
+
+// listItems returns the items of a list. An empty Lua table converts to an
+// empty map, so that is an empty list too.
+func listItems(value interface{}) ([]interface{}, bool) {
+	switch list := value.(type) {
+	case []interface{}:
+		return list, true
+	case []string:
+		items := make([]interface{}, len(list))
+		for i, s := range list {
+			items[i] = s
+		}
+		return items, true
+	case []map[string]interface{}:
+		items := make([]interface{}, len(list))
+		for i, m := range list {
+			items[i] = m
+		}
+		return items, true
+	case map[string]interface{}:
+		return nil, len(list) == 0
+	}
+	return nil, false
+}
+
+// mapEntries returns the entries of a table. A table with nothing in it may
+// have been read as an empty list.
+func mapEntries(value interface{}) (map[string]interface{}, bool) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[string]string:
+		entries := make(map[string]interface{}, len(m))
+		for k, s := range m {
+			entries[k] = s
+		}
+		return entries, true
+	case []interface{}:
+		return map[string]interface{}{}, len(m) == 0
+	}
+	return nil, false
+}
+
+func number(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func isWhole(value interface{}) bool {
+	n, ok := number(value)
+	return ok && n == math.Trunc(n)
+}
+
+// describe names the type of value for a diagnostic, showing numbers
+// themselves so 1.5 is not reported as merely a float64
+func describe(value interface{}) string {
+	if n, ok := value.(float64); ok {
+		return fmt.Sprint(n)
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// suggest returns the candidate closest to name, if any is close enough
+// to be a likely typo
+func suggest(name string, candidates []string) string {
+	best, bestDistance := "", max(2, len(name)/3)+1
+	for _, c := range candidates {
+		if d := distance(name, c); d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	return best
+}
+
+// distance returns the Levenshtein distance between a and b, counting the
+// transposition of two adjacent characters as one edit
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,143 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+)
+
+var serverSchema = &ResourceSchema{
+	Attributes: map[string]*Attribute{
+		"name":          {Type: TypeString, Required: true, ForceNew: true},
+		"instance_type": {Type: TypeString, Required: true},
+		"zone":          {Type: TypeString, ForceNew: true, Default: "a"},
+		"count":         {Type: TypeInt},
+		"ratio":         {Type: TypeNumber},
+		"public":        {Type: TypeBool},
+		"timeout":       {Type: TypeDuration},
+		"policy":        {Type: TypeDocument},
+		"groups":        {Type: TypeList, Elem: &Attribute{Type: TypeString}},
+		"tags":          {Type: TypeMap, Elem: &Attribute{Type: TypeString}},
+		"disks": {Type: TypeList, Elem: &Attribute{Type: TypeObject, Attributes: map[string]*Attribute{
+			"device": {Type: TypeString, Required: true},
+			"size":   {Type: TypeInt},
+		}}},
+		"address":  {Type: TypeString, Computed: true},
+		"password": {Type: TypeString, Computed: true, Sensitive: true},
+	},
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		config := map[string]interface{}{
+			"name":          "web",
+			"instance_type": "t3.micro",
+			"count":         float64(2),
+			"ratio":         0.5,
+			"public":        true,
+			"timeout":       "10m",
+			"policy":        map[string]interface{}{"Version": "2012-10-17"},
+			"groups":        map[string]interface{}{},
+			"tags":          map[string]interface{}{"env": "prod"},
+			"disks":         []interface{}{map[string]interface{}{"device": "/dev/sdf", "size": 20}},
+			"zone":          nil,
+		}
+		if diags := serverSchema.Validate(config); diags != nil {
+			t.Errorf("Expected a valid config, got %v", diags)
+		}
+	})
+
+	t.Run("References", func(t *testing.T) {
+		config := map[string]interface{}{
+			"name":          "web",
+			"instance_type": "t3.micro",
+			"count":         "${aws.asg.web.desired_capacity}",
+			"groups":        []interface{}{"${aws.security_group.web.id}"},
+		}
+		if diags := serverSchema.Validate(config); diags != nil {
+			t.Errorf("Expected references to stand for any type, got %v", diags)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		config := map[string]interface{}{
+			"name":          "web",
+			"instnace_type": "t3.micro",
+			"count":         1.5,
+			"timeout":       "soon",
+			"groups":        []interface{}{"sg-1", 2},
+			"tags":          map[string]interface{}{"env": true},
+			"disks":         []interface{}{map[string]interface{}{"size": "big"}},
+			"address":       "10.0.0.1",
+			"colour":        "blue",
+		}
+		want := Diagnostics{
+			{Path: "address", Message: "set by the provider and cannot be configured"},
+			{Path: "colour", Message: "unknown attribute"},
+			{Path: "count", Message: "expected a whole number, got 1.5"},
+			{Path: "disks[1].device", Message: "required but not set"},
+			{Path: "disks[1].size", Message: "expected a whole number, got string"},
+			{Path: "groups[2]", Message: "expected a string, got int"},
+			{Path: "instance_type", Message: "required but not set"},
+			{Path: "instnace_type", Message: "unknown attribute, did you mean instance_type?"},
+			{Path: "tags.env", Message: "expected a string, got bool"},
+			{Path: "timeout", Message: `expected a duration such as "10m", got "soon"`},
+		}
+		if diags := serverSchema.Validate(config); !reflect.DeepEqual(diags, want) {
+			t.Errorf("Expected diagnostics\n%v\ngot\n%v", want, diags)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		diags := serverSchema.Validate(map[string]interface{}{"name": "web"})
+		if err := error(diags); err.Error() != "attribute instance_type: required but not set" {
+			t.Errorf("Expected the diagnostic as the error, got %q", err)
+		}
+	})
+}
+
+func TestForceNewChanges(t *testing.T) {
+	recorded := map[string]interface{}{"name": "web", "instance_type": "t3.micro"}
+	tests := []struct {
+		name    string
+		desired map[string]interface{}
+		want    []string
+	}{
+		{name: "InPlace", desired: map[string]interface{}{"name": "web", "instance_type": "t3.large"}},
+		{name: "Default", desired: map[string]interface{}{"name": "web", "zone": "a"}},
+		{name: "Unset", desired: map[string]interface{}{}},
+		{name: "Changed", desired: map[string]interface{}{"name": "api", "zone": "b"}, want: []string{"name", "zone"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serverSchema.ForceNewChanges(recorded, tt.desired); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema := &Schema{Resources: map[string]*ResourceSchema{"server": serverSchema, "volume": {}}}
+
+	t.Run("Resource", func(t *testing.T) {
+		if r, err := schema.Resource("server"); err != nil || r != serverSchema {
+			t.Errorf("Expected the server schema, got %v, %v", r, err)
+		}
+		if _, err := schema.Resource("sevrer"); err == nil || err.Error() != `unknown resource type "sevrer", did you mean "server"?` {
+			t.Errorf("Expected a suggestion, got %v", err)
+		}
+		if _, err := schema.Resource("database"); err == nil || err.Error() != `unknown resource type "database"` {
+			t.Errorf("Expected an unknown type error, got %v", err)
+		}
+	})
+
+	t.Run("SensitiveAttributes", func(t *testing.T) {
+		if got := serverSchema.SensitiveAttributes(); !reflect.DeepEqual(got, []string{"password"}) {
+			t.Errorf("Expected [password], got %v", got)
+		}
+		var missing *ResourceSchema
+		if got := missing.SensitiveAttributes(); got != nil {
+			t.Errorf("Expected no sensitive attributes without a schema, got %v", got)
+		}
+	})
+}
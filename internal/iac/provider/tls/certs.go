@@ -0,0 +1,274 @@
+package tls
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/internal/iac/provider/generated"
+)
+
+var selfSignedCertSchema = &provider.ResourceSchema{
+	Description: "A certificate signed by its own private key",
+	Attributes: map[string]*provider.Attribute{
+		"private_key_id":      {Type: provider.TypeString, Required: true, Description: "The ID of the tls private_key to sign with"},
+		"subject":             subjectAttribute,
+		"dns_names":           stringListAttribute,
+		"ip_addresses":        stringListAttribute,
+		"validity":            {Type: provider.TypeDuration, Default: "8760h", Description: "How long the certificate is valid for"},
+		"is_ca_certificate":   {Type: provider.TypeBool},
+		"allowed_uses":        stringListAttribute,
+		"keepers":             generated.KeepersAttribute,
+		"cert_pem":            {Type: provider.TypeString, Computed: true},
+		"serial_number":       {Type: provider.TypeString, Computed: true},
+		"validity_start_time": {Type: provider.TypeString, Computed: true},
+		"validity_end_time":   {Type: provider.TypeString, Computed: true},
+	},
+}
+
+var certRequestSchema = &provider.ResourceSchema{
+	Description: "A certificate signing request, for a certificate authority to sign",
+	Attributes: map[string]*provider.Attribute{
+		"private_key_id":   {Type: provider.TypeString, Required: true, Description: "The ID of the tls private_key to sign with"},
+		"subject":          subjectAttribute,
+		"dns_names":        stringListAttribute,
+		"ip_addresses":     stringListAttribute,
+		"keepers":          generated.KeepersAttribute,
+		"cert_request_pem": {Type: provider.TypeString, Computed: true},
+	},
+}
+
+// keyUsages and extKeyUsages are the names of the uses a certificate can
+// be allowed
+var (
+	keyUsages = map[string]x509.KeyUsage{
+		"digital_signature":  x509.KeyUsageDigitalSignature,
+		"content_commitment": x509.KeyUsageContentCommitment,
+		"key_encipherment":   x509.KeyUsageKeyEncipherment,
+		"data_encipherment":  x509.KeyUsageDataEncipherment,
+		"key_agreement":      x509.KeyUsageKeyAgreement,
+		"cert_signing":       x509.KeyUsageCertSign,
+		"crl_signing":        x509.KeyUsageCRLSign,
+	}
+	extKeyUsages = map[string]x509.ExtKeyUsage{
+		"any_extended":     x509.ExtKeyUsageAny,
+		"server_auth":      x509.ExtKeyUsageServerAuth,
+		"client_auth":      x509.ExtKeyUsageClientAuth,
+		"code_signing":     x509.ExtKeyUsageCodeSigning,
+		"email_protection": x509.ExtKeyUsageEmailProtection,
+		"timestamping":     x509.ExtKeyUsageTimeStamping,
+		"ocsp_signing":     x509.ExtKeyUsageOCSPSigning,
+	}
+)
+
+// request holds what certificates and requests have in common
+type request struct {
+	privateKeyID string
+	subject      pkix.Name
+	dnsNames     []string
+	ipAddresses  []net.IP
+}
+
+func parseRequest(c *generated.Config) (*request, error) {
+	c.Require("private_key_id")
+	r := &request{
+		privateKeyID: c.String("private_key_id"),
+		dnsNames:     c.StringList("dns_names"),
+	}
+	ips := c.StringList("ip_addresses")
+	subject := c.Object("subject")
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range ips {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("attribute ip_addresses: %q is not an IP address", s)
+		}
+		r.ipAddresses = append(r.ipAddresses, ip)
+	}
+
+	r.subject = pkix.Name{CommonName: subject.String("common_name")}
+	for key, field := range map[string]*[]string{
+		"organization":        &r.subject.Organization,
+		"organizational_unit": &r.subject.OrganizationalUnit,
+		"country":             &r.subject.Country,
+		"province":            &r.subject.Province,
+		"locality":            &r.subject.Locality,
+		"street_address":      &r.subject.StreetAddress,
+		"postal_code":         &r.subject.PostalCode,
+	} {
+		if s := subject.String(key); s != "" {
+			*field = []string{s}
+		}
+	}
+	if err := subject.Err(); err != nil {
+		return nil, fmt.Errorf("attribute subject: %w", err)
+	}
+	return r, nil
+}
+
+// certSpec describes a self-signed certificate to generate
+type certSpec struct {
+	*request
+	validity     time.Duration
+	isCA         bool
+	keyUsage     x509.KeyUsage
+	extKeyUsages []x509.ExtKeyUsage
+}
+
+func parseCertSpec(config map[string]interface{}) (*certSpec, error) {
+	c := generated.NewConfig(selfSignedCertSchema, config)
+	r, err := parseRequest(c)
+	if err != nil {
+		return nil, err
+	}
+	spec := &certSpec{
+		request:  r,
+		validity: c.Duration("validity"),
+		isCA:     c.Bool("is_ca_certificate"),
+	}
+	uses := c.StringList("allowed_uses")
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	if spec.validity <= 0 {
+		return nil, fmt.Errorf("attribute validity: expected a positive duration, got %s", spec.validity)
+	}
+
+	for _, use := range uses {
+		if usage, ok := keyUsages[use]; ok {
+			spec.keyUsage |= usage
+		} else if usage, ok := extKeyUsages[use]; ok {
+			spec.extKeyUsages = append(spec.extKeyUsages, usage)
+		} else {
+			return nil, fmt.Errorf("attribute allowed_uses: unknown use %q, expected one of %s", use, strings.Join(allowedUses(), ", "))
+		}
+	}
+	return spec, nil
+}
+
+// allowedUses returns the names of every use, sorted
+func allowedUses() []string {
+	names := make([]string, 0, len(keyUsages)+len(extKeyUsages))
+	for name := range keyUsages {
+		names = append(names, name)
+	}
+	for name := range extKeyUsages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateSelfSignedCert(config map[string]interface{}) error {
+	if hasReferences(config, "validity") || hasListReferences(config, "ip_addresses", "allowed_uses") {
+		return nil
+	}
+	_, err := parseCertSpec(config)
+	return err
+}
+
+func validateCertRequest(config map[string]interface{}) error {
+	if hasListReferences(config, "ip_addresses") {
+		return nil
+	}
+	_, err := parseRequest(generated.NewConfig(certRequestSchema, config))
+	return err
+}
+
+// hasListReferences reports whether the lists at keys hold references that
+// have not been resolved yet
+func hasListReferences(config map[string]interface{}, keys ...string) bool {
+	for _, key := range keys {
+		if hasReferences(config, key) {
+			return true
+		}
+		list, _ := config[key].([]interface{})
+		for _, item := range list {
+			if s, ok := item.(string); ok && strings.Contains(s, "${") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func generateSelfSignedCert(ctx context.Context, config map[string]interface{}, lookup generated.Lookup) (map[string]interface{}, error) {
+	spec, err := parseCertSpec(config)
+	if err != nil {
+		return nil, err
+	}
+	key, err := signer(ctx, lookup, spec.privateKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	subjectKeyID := sha1.Sum(publicDER)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               spec.subject,
+		DNSNames:              spec.dnsNames,
+		IPAddresses:           spec.ipAddresses,
+		NotBefore:             now,
+		NotAfter:              now.Add(spec.validity),
+		KeyUsage:              spec.keyUsage,
+		ExtKeyUsage:           spec.extKeyUsages,
+		BasicConstraintsValid: true,
+		IsCA:                  spec.isCA,
+		SubjectKeyId:          subjectKeyID[:],
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return map[string]interface{}{
+		"cert_pem":            string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		"serial_number":       serial.String(),
+		"validity_start_time": template.NotBefore.Format(time.RFC3339),
+		"validity_end_time":   template.NotAfter.Format(time.RFC3339),
+	}, nil
+}
+
+func generateCertRequest(ctx context.Context, config map[string]interface{}, lookup generated.Lookup) (map[string]interface{}, error) {
+	r, err := parseRequest(generated.NewConfig(certRequestSchema, config))
+	if err != nil {
+		return nil, err
+	}
+	key, err := signer(ctx, lookup, r.privateKeyID)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     r.subject,
+		DNSNames:    r.dnsNames,
+		IPAddresses: r.ipAddresses,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+	return map[string]interface{}{
+		"cert_request_pem": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})),
+	}, nil
+}
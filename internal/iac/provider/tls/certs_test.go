@@ -0,0 +1,120 @@
+package tls
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCertificates(t *testing.T) {
+	ctx := context.Background()
+	p, store := newTestProvider(t)
+
+	key, err := p.Create(ctx, "private_key", map[string]interface{}{"algorithm": "ecdsa"})
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	save(t, store, p, key)
+
+	decode := func(t *testing.T, encoded interface{}, blockType string) []byte {
+		t.Helper()
+		s, _ := encoded.(string)
+		block, _ := pem.Decode([]byte(s))
+		if block == nil || block.Type != blockType {
+			t.Fatalf("Expected a PEM encoded %s, got %q", blockType, s)
+		}
+		return block.Bytes
+	}
+
+	t.Run("SelfSigned", func(t *testing.T) {
+		res, err := p.Create(ctx, "self_signed_cert", map[string]interface{}{
+			"private_key_id":    key.GetID(),
+			"subject":           map[string]interface{}{"common_name": "ca.internal", "organization": "Example"},
+			"dns_names":         []interface{}{"ca.internal"},
+			"ip_addresses":      []interface{}{"10.0.0.1"},
+			"validity":          "720h",
+			"is_ca_certificate": true,
+			"allowed_uses":      []interface{}{"cert_signing", "digital_signature", "server_auth"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create certificate: %v", err)
+		}
+		meta := res.GetMetadata()
+		cert, err := x509.ParseCertificate(decode(t, meta["cert_pem"], "CERTIFICATE"))
+		if err != nil {
+			t.Fatalf("Failed to parse certificate: %v", err)
+		}
+		if err := cert.CheckSignatureFrom(cert); err != nil {
+			t.Errorf("Expected the certificate to be signed by its own key: %v", err)
+		}
+		if cert.Subject.CommonName != "ca.internal" || cert.Subject.Organization[0] != "Example" {
+			t.Errorf("Unexpected subject: %v", cert.Subject)
+		}
+		if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 || len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+			t.Errorf("Expected a CA certificate with the allowed uses, got %v, %v, %v", cert.IsCA, cert.KeyUsage, cert.ExtKeyUsage)
+		}
+		if len(cert.IPAddresses) != 1 || !cert.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")) || cert.DNSNames[0] != "ca.internal" {
+			t.Errorf("Unexpected names: %v, %v", cert.DNSNames, cert.IPAddresses)
+		}
+		if got := cert.NotAfter.Sub(cert.NotBefore); got != 720*time.Hour {
+			t.Errorf("Expected a validity of 720h, got %v", got)
+		}
+		if meta["serial_number"] != cert.SerialNumber.String() || meta["validity_end_time"] != cert.NotAfter.Format(time.RFC3339) {
+			t.Errorf("Unexpected metadata: %v", meta)
+		}
+	})
+
+	t.Run("CertRequest", func(t *testing.T) {
+		res, err := p.Create(ctx, "cert_request", map[string]interface{}{
+			"private_key_id": key.GetID(),
+			"subject":        map[string]interface{}{"common_name": "web.internal"},
+			"dns_names":      []interface{}{"web.internal", "www.internal"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create certificate request: %v", err)
+		}
+		csr, err := x509.ParseCertificateRequest(decode(t, res.GetMetadata()["cert_request_pem"], "CERTIFICATE REQUEST"))
+		if err != nil {
+			t.Fatalf("Failed to parse certificate request: %v", err)
+		}
+		if err := csr.CheckSignature(); err != nil {
+			t.Errorf("Expected a valid signature: %v", err)
+		}
+		if csr.Subject.CommonName != "web.internal" || len(csr.DNSNames) != 2 {
+			t.Errorf("Unexpected request: %v, %v", csr.Subject, csr.DNSNames)
+		}
+	})
+
+	t.Run("MissingKey", func(t *testing.T) {
+		if _, err := p.Create(ctx, "cert_request", map[string]interface{}{"private_key_id": "private-key-missing"}); err == nil {
+			t.Error("Expected error for a key that is not in state, got nil")
+		}
+	})
+
+	t.Run("ValidateConfig", func(t *testing.T) {
+		tests := []struct {
+			name         string
+			resourceType string
+			config       map[string]interface{}
+			wantErr      bool
+		}{
+			{"Valid", "self_signed_cert", map[string]interface{}{"private_key_id": "${tls.private_key.ca.id}"}, false},
+			{"MissingKey", "cert_request", map[string]interface{}{}, true},
+			{"BadIP", "cert_request", map[string]interface{}{"private_key_id": "k", "ip_addresses": []interface{}{"10.0.0"}}, true},
+			{"ReferencedIP", "cert_request", map[string]interface{}{"private_key_id": "k", "ip_addresses": []interface{}{"${aws.eip.web.public_ip}"}}, false},
+			{"UnknownUse", "self_signed_cert", map[string]interface{}{"private_key_id": "k", "allowed_uses": []interface{}{"server"}}, true},
+			{"NegativeValidity", "self_signed_cert", map[string]interface{}{"private_key_id": "k", "validity": "-1h"}, true},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := p.ValidateConfig(ctx, tt.resourceType, tt.config)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+				}
+			})
+		}
+	})
+}
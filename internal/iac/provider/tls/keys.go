@@ -0,0 +1,162 @@
+package tls
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/internal/iac/provider/generated"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// Key algorithms
+const (
+	algorithmRSA     = "rsa"
+	algorithmECDSA   = "ecdsa"
+	algorithmED25519 = "ed25519"
+)
+
+// curves are the ECDSA curves keys can use
+var curves = map[string]elliptic.Curve{
+	"P224": elliptic.P224(),
+	"P256": elliptic.P256(),
+	"P384": elliptic.P384(),
+	"P521": elliptic.P521(),
+}
+
+var privateKeySchema = &provider.ResourceSchema{
+	Description: "A private key, sealed in state",
+	Attributes: map[string]*provider.Attribute{
+		"algorithm":                     {Type: provider.TypeString, Default: algorithmRSA, Description: "rsa, ecdsa or ed25519"},
+		"rsa_bits":                      {Type: provider.TypeInt, Default: 2048},
+		"ecdsa_curve":                   {Type: provider.TypeString, Default: "P256", Description: "P224, P256, P384 or P521"},
+		"keepers":                       generated.KeepersAttribute,
+		"private_key_pem":               {Type: provider.TypeString, Computed: true, Sensitive: true},
+		"public_key_pem":                {Type: provider.TypeString, Computed: true},
+		"public_key_openssh":            {Type: provider.TypeString, Computed: true},
+		"public_key_fingerprint_sha256": {Type: provider.TypeString, Computed: true},
+	},
+}
+
+// keySpec describes a private key to generate
+type keySpec struct {
+	algorithm string
+	rsaBits   int
+	curve     elliptic.Curve
+}
+
+func parseKeySpec(config map[string]interface{}) (*keySpec, error) {
+	c := generated.NewConfig(privateKeySchema, config)
+	spec := &keySpec{algorithm: strings.ToLower(c.String("algorithm")), rsaBits: c.Int("rsa_bits")}
+	curve := c.String("ecdsa_curve")
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+
+	switch spec.algorithm {
+	case algorithmRSA:
+		if spec.rsaBits < 2048 || spec.rsaBits > 8192 {
+			return nil, fmt.Errorf("attribute rsa_bits: expected 2048 to 8192, got %d", spec.rsaBits)
+		}
+	case algorithmECDSA:
+		if spec.curve = curves[strings.ToUpper(curve)]; spec.curve == nil {
+			return nil, fmt.Errorf("attribute ecdsa_curve: expected P224, P256, P384 or P521, got %q", curve)
+		}
+	case algorithmED25519:
+	default:
+		return nil, fmt.Errorf("attribute algorithm: expected rsa, ecdsa or ed25519, got %q", spec.algorithm)
+	}
+	return spec, nil
+}
+
+func validatePrivateKey(config map[string]interface{}) error {
+	if hasReferences(config, "algorithm", "rsa_bits", "ecdsa_curve") {
+		return nil
+	}
+	_, err := parseKeySpec(config)
+	return err
+}
+
+func generatePrivateKey(ctx context.Context, config map[string]interface{}, lookup generated.Lookup) (map[string]interface{}, error) {
+	spec, err := parseKeySpec(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var key crypto.Signer
+	switch spec.algorithm {
+	case algorithmRSA:
+		key, err = rsa.GenerateKey(rand.Reader, spec.rsaBits)
+	case algorithmECDSA:
+		key, err = ecdsa.GenerateKey(spec.curve, rand.Reader)
+	case algorithmED25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s key: %w", spec.algorithm, err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	attributes := map[string]interface{}{
+		"private_key_pem": string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"public_key_pem":  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+	}
+
+	// OpenSSH has no P224 keys
+	if sshKey, err := ssh.NewPublicKey(key.Public()); err == nil {
+		attributes["public_key_openssh"] = string(ssh.MarshalAuthorizedKey(sshKey))
+		attributes["public_key_fingerprint_sha256"] = ssh.FingerprintSHA256(sshKey)
+	}
+	return attributes, nil
+}
+
+// signer returns the private key of the private_key resource with id
+func signer(ctx context.Context, lookup generated.Lookup, id string) (crypto.Signer, error) {
+	attributes, err := lookup(ctx, string(types.ResourceTypePrivateKey), id)
+	if err != nil {
+		return nil, fmt.Errorf("attribute private_key_id: %w", err)
+	}
+	encoded, _ := attributes["private_key_pem"].(string)
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, fmt.Errorf("private key %s has no PEM encoded key; its secrets may not be readable with this state key", id)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", id, err)
+	}
+	s, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key %s cannot sign", id)
+	}
+	return s, nil
+}
+
+// hasReferences reports whether any of the attributes of config hold
+// references that have not been resolved yet
+func hasReferences(config map[string]interface{}, keys ...string) bool {
+	for _, key := range keys {
+		if s, ok := config[key].(string); ok && strings.Contains(s, "${") {
+			return true
+		}
+	}
+	return false
+}
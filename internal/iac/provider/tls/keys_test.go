@@ -0,0 +1,100 @@
+package tls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestPrivateKeys(t *testing.T) {
+	ctx := context.Background()
+	p, _ := newTestProvider(t)
+
+	parse := func(t *testing.T, meta map[string]interface{}) interface{} {
+		t.Helper()
+		encoded, _ := meta["private_key_pem"].(string)
+		block, _ := pem.Decode([]byte(encoded))
+		if block == nil || block.Type != "PRIVATE KEY" {
+			t.Fatalf("Expected a PEM encoded private key, got %q", encoded)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			t.Fatalf("Failed to parse private key: %v", err)
+		}
+		return key
+	}
+
+	t.Run("RSA", func(t *testing.T) {
+		res, err := p.Create(ctx, "private_key", map[string]interface{}{})
+		if err != nil {
+			t.Fatalf("Failed to create key: %v", err)
+		}
+		meta := res.GetMetadata()
+		key, ok := parse(t, meta).(*rsa.PrivateKey)
+		if !ok || key.N.BitLen() != 2048 {
+			t.Errorf("Expected a 2048 bit RSA key by default, got %T", key)
+		}
+		if !strings.HasPrefix(meta["public_key_pem"].(string), "-----BEGIN PUBLIC KEY-----") {
+			t.Errorf("Expected a PEM encoded public key, got %v", meta["public_key_pem"])
+		}
+		if !strings.HasPrefix(meta["public_key_openssh"].(string), "ssh-rsa ") {
+			t.Errorf("Expected an OpenSSH public key, got %v", meta["public_key_openssh"])
+		}
+		if !strings.HasPrefix(meta["public_key_fingerprint_sha256"].(string), "SHA256:") {
+			t.Errorf("Expected a SHA256 fingerprint, got %v", meta["public_key_fingerprint_sha256"])
+		}
+	})
+
+	t.Run("ECDSA", func(t *testing.T) {
+		res, err := p.Create(ctx, "private_key", map[string]interface{}{"algorithm": "ecdsa", "ecdsa_curve": "P384"})
+		if err != nil {
+			t.Fatalf("Failed to create key: %v", err)
+		}
+		key, ok := parse(t, res.GetMetadata()).(*ecdsa.PrivateKey)
+		if !ok || key.Curve != elliptic.P384() {
+			t.Errorf("Expected a P384 ECDSA key, got %T", key)
+		}
+	})
+
+	t.Run("ED25519", func(t *testing.T) {
+		res, err := p.Create(ctx, "private_key", map[string]interface{}{"algorithm": "ed25519"})
+		if err != nil {
+			t.Fatalf("Failed to create key: %v", err)
+		}
+		if _, ok := parse(t, res.GetMetadata()).(ed25519.PrivateKey); !ok {
+			t.Error("Expected an ed25519 key")
+		}
+		if !strings.HasPrefix(res.GetMetadata()["public_key_openssh"].(string), "ssh-ed25519 ") {
+			t.Errorf("Expected an OpenSSH public key, got %v", res.GetMetadata()["public_key_openssh"])
+		}
+	})
+
+	t.Run("ValidateConfig", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			config  map[string]interface{}
+			wantErr bool
+		}{
+			{"Default", map[string]interface{}{}, false},
+			{"RSABits", map[string]interface{}{"rsa_bits": 4096}, false},
+			{"TooFewBits", map[string]interface{}{"rsa_bits": 1024}, true},
+			{"UnknownAlgorithm", map[string]interface{}{"algorithm": "dsa"}, true},
+			{"UnknownCurve", map[string]interface{}{"algorithm": "ecdsa", "ecdsa_curve": "P192"}, true},
+			{"Reference", map[string]interface{}{"algorithm": "${tls.private_key.other.algorithm}"}, false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := p.ValidateConfig(ctx, "private_key", tt.config)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,50 @@
+// Package tls implements the tls provider, which generates private keys,
+// self-signed certificates and certificate signing requests once and keeps
+// them in state
+package tls
+
+import (
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/internal/iac/provider/generated"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// subjectAttribute is the distinguished name of a certificate or request
+var subjectAttribute = &provider.Attribute{
+	Type: provider.TypeObject,
+	Attributes: map[string]*provider.Attribute{
+		"common_name":         {Type: provider.TypeString},
+		"organization":        {Type: provider.TypeString},
+		"organizational_unit": {Type: provider.TypeString},
+		"country":             {Type: provider.TypeString},
+		"province":            {Type: provider.TypeString},
+		"locality":            {Type: provider.TypeString},
+		"street_address":      {Type: provider.TypeString},
+		"postal_code":         {Type: provider.TypeString},
+	},
+}
+
+var stringListAttribute = &provider.Attribute{Type: provider.TypeList, Elem: &provider.Attribute{Type: provider.TypeString}}
+
+// NewTLSProvider returns the tls provider, which reads the keys and
+// certificates it generated back from store
+func NewTLSProvider(store *state.Store) *generated.Provider {
+	return generated.NewProvider("tls", store, map[string]generated.Generator{
+		string(types.ResourceTypePrivateKey): {
+			Schema:   privateKeySchema,
+			Generate: generatePrivateKey,
+			Validate: validatePrivateKey,
+		},
+		string(types.ResourceTypeSelfSignedCert): {
+			Schema:   selfSignedCertSchema,
+			Generate: generateSelfSignedCert,
+			Validate: validateSelfSignedCert,
+		},
+		string(types.ResourceTypeCertRequest): {
+			Schema:   certRequestSchema,
+			Generate: generateCertRequest,
+			Validate: validateCertRequest,
+		},
+	})
+}
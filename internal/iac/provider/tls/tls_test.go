@@ -0,0 +1,76 @@
+package tls
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/core/state"
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/internal/iac/provider/generated"
+)
+
+// newTestProvider returns a provider backed by a fresh state store
+func newTestProvider(t *testing.T) (*generated.Provider, *state.Store) {
+	t.Helper()
+	store, err := state.NewStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.SetKeySource(func() (string, error) { return "passphrase", nil })
+	return NewTLSProvider(store), store
+}
+
+// save records a resource in state the way the applier does, with its
+// sensitive attributes sealed
+func save(t *testing.T, store *state.Store, p *generated.Provider, r provider.Resource) {
+	t.Helper()
+	metadata := make(map[string]interface{})
+	secrets := make(map[string]interface{})
+	for k, v := range r.GetMetadata() {
+		metadata[k] = v
+	}
+	for _, name := range p.SensitiveAttributes(string(r.GetType())) {
+		if v, ok := metadata[name]; ok {
+			secrets[name] = v
+			delete(metadata, name)
+		}
+	}
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatalf("Failed to encode metadata: %v", err)
+	}
+	record := &state.Resource{ID: r.GetID(), Type: string(r.GetType()), Name: r.GetID(), Provider: r.GetProvider(), Metadata: encoded}
+	if err := store.SealSecrets(context.Background(), record, secrets); err != nil {
+		t.Fatalf("Failed to seal secrets: %v", err)
+	}
+	if err := store.SaveResource(context.Background(), record); err != nil {
+		t.Fatalf("Failed to save resource: %v", err)
+	}
+}
+
+func TestTLSProvider(t *testing.T) {
+	p, _ := newTestProvider(t)
+
+	t.Run("Name", func(t *testing.T) {
+		if p.Name() != "tls" {
+			t.Errorf("Expected name tls, got %q", p.Name())
+		}
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		schema := p.Schema()
+		for _, resourceType := range []string{"private_key", "self_signed_cert", "cert_request"} {
+			if schema.Resources[resourceType] == nil {
+				t.Errorf("Expected a schema for %s", resourceType)
+			}
+		}
+		if got := p.SensitiveAttributes("private_key"); len(got) != 1 || got[0] != "private_key_pem" {
+			t.Errorf("Expected [private_key_pem], got %v", got)
+		}
+		if got := p.SensitiveAttributes("self_signed_cert"); len(got) != 0 {
+			t.Errorf("Expected certificates not to be sensitive, got %v", got)
+		}
+	})
+}
@@ -0,0 +1,309 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// ProtocolVersion is the version of the protocol spoken by this package.
+// Duet refuses plugins that answer initialize with another version.
+const ProtocolVersion = 1
+
+// EnvProtocol is set to the protocol version in the environment of plugins
+// duet starts. Serve refuses to run without it, since a plugin talks to
+// duet over its standard input and output rather than to a person.
+const EnvProtocol = "DUET_PLUGIN_PROTOCOL"
+
+// Methods of the protocol. Each takes and returns the params and result
+// types named after it; $/cancel is a notification without a response.
+const (
+	MethodInitialize        = "initialize"
+	MethodSchema            = "schema"
+	MethodValidateConfig    = "validate_config"
+	MethodCreate            = "create"
+	MethodRead              = "read"
+	MethodUpdate            = "update"
+	MethodDelete            = "delete"
+	MethodFindByToken       = "find_by_token"
+	MethodReadData          = "read_data"
+	MethodNormalizeConfig   = "normalize_config"
+	MethodDiscoverableTypes = "discoverable_types"
+	MethodDiscover          = "discover"
+	MethodCancel            = "$/cancel"
+)
+
+// JSONRPCVersion is the jsonrpc member of every message
+const JSONRPCVersion = "2.0"
+
+// Capabilities a plugin reports from initialize, one for each optional
+// provider interface it implements
+const (
+	CapabilityValidateConfig  = "validate_config"
+	CapabilityFindByToken     = "find_by_token"
+	CapabilityReadData        = "read_data"
+	CapabilityNormalizeConfig = "normalize_config"
+	CapabilityDiscover        = "discover"
+)
+
+// Error codes. Those above -32000 are defined by JSON-RPC 2.0.
+const (
+	CodeParseError       = -32700
+	CodeInvalidRequest   = -32600
+	CodeMethodNotFound   = -32601
+	CodeInvalidParams    = -32602
+	CodeProviderError    = -32000
+	CodeNotFound         = -32001
+	CodeRequestCancelled = -32800
+)
+
+// Message is a JSON-RPC 2.0 request, notification or response. Messages
+// are written one per line.
+type Message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is the error of a failed request. A create that made its resource
+// but failed afterwards returns it in Data as a ResourceResult.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports a CodeNotFound error as provider.ErrNotFound
+func (e *Error) Is(target error) bool {
+	return e.Code == CodeNotFound && target == provider.ErrNotFound
+}
+
+// toError converts a provider's error to the one sent back to duet
+func toError(err error) *Error {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, provider.ErrNotFound):
+		return &Error{Code: CodeNotFound, Message: err.Error()}
+	default:
+		return &Error{Code: CodeProviderError, Message: err.Error()}
+	}
+}
+
+// CancelParams cancels the request with ID, which then fails with
+// CodeRequestCancelled
+type CancelParams struct {
+	ID int64 `json:"id"`
+}
+
+// InitializeParams configures the provider. It is the first request.
+type InitializeParams struct {
+	ProtocolVersion int                    `json:"protocol_version"`
+	Settings        map[string]interface{} `json:"settings"`
+}
+
+// InitializeResult names the provider and what it supports
+type InitializeResult struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	Name            string   `json:"name"`
+	Capabilities    []string `json:"capabilities"`
+}
+
+// SchemaResult describes the resources and data sources a provider has.
+// Sensitive lists the secret metadata attributes of each resource type, and
+// Resources the attributes of each, if the provider describes them.
+type SchemaResult struct {
+	ResourceTypes []string                            `json:"resource_types"`
+	DataTypes     []string                            `json:"data_types,omitempty"`
+	Sensitive     map[string][]string                 `json:"sensitive,omitempty"`
+	Resources     map[string]*provider.ResourceSchema `json:"resources,omitempty"`
+}
+
+// ConfigParams carries a resource or data source config
+type ConfigParams struct {
+	Type   string                 `json:"type"`
+	Config map[string]interface{} `json:"config"`
+}
+
+// CreateParams creates a resource. The token and ownership are those the
+// applier sets on the context of an in-process provider.
+type CreateParams struct {
+	Type             string                 `json:"type"`
+	Config           map[string]interface{} `json:"config"`
+	IdempotencyToken string                 `json:"idempotency_token,omitempty"`
+	Ownership        *provider.Ownership    `json:"ownership,omitempty"`
+}
+
+// ReadParams reads a resource by ID
+type ReadParams struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// UpdateParams updates a resource to config
+type UpdateParams struct {
+	Resource  *types.BaseResource    `json:"resource"`
+	Config    map[string]interface{} `json:"config"`
+	Ownership *provider.Ownership    `json:"ownership,omitempty"`
+}
+
+// DeleteParams deletes a resource
+type DeleteParams struct {
+	Resource  *types.BaseResource `json:"resource"`
+	Ownership *provider.Ownership `json:"ownership,omitempty"`
+}
+
+// FindByTokenParams finds the resource created with a token
+type FindByTokenParams struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+}
+
+// DiscoverParams lists existing resources carrying tags
+type DiscoverParams struct {
+	Type string            `json:"type"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// ResourceResult returns a resource
+type ResourceResult struct {
+	Resource *types.BaseResource `json:"resource"`
+}
+
+// ConfigResult returns a normalised config, or a data source's attributes
+type ConfigResult struct {
+	Config map[string]interface{} `json:"config"`
+}
+
+// TypesResult returns resource types
+type TypesResult struct {
+	Types []string `json:"types"`
+}
+
+// DiscoveredResource is a resource returned by discover
+type DiscoveredResource struct {
+	Resource *types.BaseResource    `json:"resource"`
+	Config   map[string]interface{} `json:"config"`
+}
+
+// DiscoverResult returns discovered resources
+type DiscoverResult struct {
+	Resources []DiscoveredResource `json:"resources"`
+}
+
+// ToBaseResource copies any resource into the form it is sent in
+func ToBaseResource(r types.Resource) *types.BaseResource {
+	if r == nil {
+		return nil
+	}
+	if b, ok := r.(*types.BaseResource); ok {
+		return b
+	}
+	return &types.BaseResource{
+		ID:        r.GetID(),
+		Type:      r.GetType(),
+		Provider:  r.GetProvider(),
+		Status:    r.GetStatus(),
+		Metadata:  r.GetMetadata(),
+		Tags:      r.GetTags(),
+		CreatedAt: r.GetCreatedAt(),
+		UpdatedAt: r.GetUpdatedAt(),
+	}
+}
+
+// Decode unmarshals data into v. Whole numbers in maps and lists become
+// int, like those in configs read from Lua, and others float64, so values
+// compare equal on both sides of the protocol.
+func Decode(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+	fixNumbers(reflect.ValueOf(v))
+	return nil
+}
+
+// fixNumbers replaces the json.Numbers held in interface values under v
+func fixNumbers(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			fixNumbers(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fixNumbers(v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			fixNumbers(v.Index(i))
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.Interface {
+			for _, k := range v.MapKeys() {
+				fixNumbers(v.MapIndex(k))
+			}
+			return
+		}
+		for _, k := range v.MapKeys() {
+			if item := v.MapIndex(k); !item.IsNil() {
+				v.SetMapIndex(k, reflect.ValueOf(fixValue(item.Interface())))
+			}
+		}
+	case reflect.Interface:
+		if !v.IsNil() && v.CanSet() {
+			v.Set(reflect.ValueOf(fixValue(v.Interface())))
+		}
+	}
+}
+
+func fixValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = fixValue(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = fixValue(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// encode encodes the params or result of a message
+func encode(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,41 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+func TestDecode(t *testing.T) {
+	var params UpdateParams
+	data := []byte(`{"resource":{"id":"r-1","metadata":{"port":443,"weight":0.25,"empty":null}},"config":{"size":20,"rules":[{"port":22}],"big":1e3}}`)
+	if err := Decode(data, &params); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"size":  20,
+		"rules": []interface{}{map[string]interface{}{"port": 22}},
+		"big":   float64(1000),
+	}
+	if !reflect.DeepEqual(params.Config, want) {
+		t.Errorf("Expected config %v, got %v", want, params.Config)
+	}
+	metadata := map[string]interface{}{"port": 443, "weight": 0.25, "empty": nil}
+	if !reflect.DeepEqual(params.Resource.Metadata, metadata) {
+		t.Errorf("Expected metadata %v, got %v", metadata, params.Resource.Metadata)
+	}
+}
+
+func TestError(t *testing.T) {
+	notFound := toError(fmt.Errorf("instance i-1: %w", provider.ErrNotFound))
+	if notFound.Code != CodeNotFound || !errors.Is(notFound, provider.ErrNotFound) {
+		t.Errorf("Expected a not found error to survive the round trip, got %+v", notFound)
+	}
+	if other := toError(errors.New("boom")); other.Code != CodeProviderError || errors.Is(other, provider.ErrNotFound) {
+		t.Errorf("Expected a provider error, got %+v", other)
+	}
+}
@@ -0,0 +1,453 @@
+// Package plugin is the SDK for provider plugins: programs that give duet
+// providers it does not have built in, without forking it. A plugin
+// implements Provider, and any of the optional provider interfaces, and
+// hands it to Serve from main:
+//
+//	func main() {
+//		err := plugin.Serve(plugin.Plugin{
+//			Name:          "example",
+//			ResourceTypes: []string{"thing"},
+//			New: func(ctx context.Context, settings map[string]interface{}) (plugin.Provider, error) {
+//				return newExampleProvider(settings)
+//			},
+//		})
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+//
+// Built as duet-provider-example and placed in a plugin directory, it is
+// started by duet for configs that use provider "example". The two talk
+// JSON-RPC 2.0 over the plugin's standard input and output, one message per
+// line; standard error is passed through to duet's, so plugins log there.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/rebelopsio/duet/internal/iac/provider"
+)
+
+// The provider interfaces a plugin implements
+type (
+	Provider         = provider.Provider
+	Resource         = provider.Resource
+	TokenFinder      = provider.TokenFinder
+	DataReader       = provider.DataReader
+	ConfigNormalizer = provider.ConfigNormalizer
+	ConfigValidator  = provider.ConfigValidator
+	SensitiveMarker  = provider.SensitiveMarker
+	SchemaDescriber  = provider.SchemaDescriber
+	Discoverer       = provider.Discoverer
+	Discovered       = provider.Discovered
+	Ownership        = provider.Ownership
+)
+
+// The schema a SchemaDescriber returns
+type (
+	Schema         = provider.Schema
+	ResourceSchema = provider.ResourceSchema
+	Attribute      = provider.Attribute
+	AttributeType  = provider.AttributeType
+)
+
+// The types of attributes
+const (
+	TypeString   = provider.TypeString
+	TypeInt      = provider.TypeInt
+	TypeNumber   = provider.TypeNumber
+	TypeBool     = provider.TypeBool
+	TypeDuration = provider.TypeDuration
+	TypeDocument = provider.TypeDocument
+	TypeList     = provider.TypeList
+	TypeMap      = provider.TypeMap
+	TypeObject   = provider.TypeObject
+	TypeAny      = provider.TypeAny
+)
+
+// ErrNotFound is returned by Read and FindByToken when there is no such
+// resource
+var ErrNotFound = provider.ErrNotFound
+
+// IdempotencyToken returns the token the resource being created should be
+// tagged with, where the underlying API supports it
+func IdempotencyToken(ctx context.Context) string {
+	return provider.IdempotencyToken(ctx)
+}
+
+// OwnershipFromContext returns the configuration the resource being created
+// or updated belongs to, which providers with tags stamp on it
+func OwnershipFromContext(ctx context.Context) (Ownership, bool) {
+	return provider.OwnershipFromContext(ctx)
+}
+
+// Plugin describes the provider a plugin serves
+type Plugin struct {
+	// Name is the provider's name, which configs refer to it by
+	Name string
+	// ResourceTypes and DataTypes list the resource types it manages and
+	// the data sources it reads
+	ResourceTypes []string
+	DataTypes     []string
+	// New configures a provider from its settings table
+	New func(ctx context.Context, settings map[string]interface{}) (Provider, error)
+}
+
+// Serve serves p to duet over standard input and output until duet closes
+// them. Anything the plugin prints to standard output goes to standard
+// error instead, so it cannot corrupt the protocol.
+func Serve(p Plugin) error {
+	if os.Getenv(EnvProtocol) == "" {
+		return fmt.Errorf("%s is a duet provider plugin and is run by duet for configs that use provider %q", os.Args[0], p.Name)
+	}
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	return ServeConn(context.Background(), p, os.Stdin, out)
+}
+
+// ServeConn serves p over r and w until r ends. Requests are handled
+// concurrently; those in flight when r ends are cancelled.
+func ServeConn(ctx context.Context, p Plugin, r io.Reader, w io.Writer) error {
+	if p.New == nil {
+		return errors.New("plugin: New is required")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s := &server{
+		plugin:  p,
+		enc:     json.NewEncoder(w),
+		cancels: make(map[int64]context.CancelFunc),
+	}
+	defer func() {
+		cancel()
+		s.wg.Wait()
+	}()
+
+	dec := json.NewDecoder(r)
+	for {
+		var msg Message
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			s.reply(nil, nil, &Error{Code: CodeParseError, Message: err.Error()})
+			return fmt.Errorf("plugin: failed to read message: %w", err)
+		}
+
+		if msg.ID == nil {
+			s.notify(msg)
+			continue
+		}
+		// Everything else needs the configured provider, so initialize is
+		// handled before reading on
+		if msg.Method == MethodInitialize {
+			result, err := s.initialize(ctx, msg.Params)
+			s.reply(msg.ID, result, err)
+			continue
+		}
+
+		reqCtx, reqCancel := context.WithCancel(ctx)
+		s.mu.Lock()
+		s.cancels[*msg.ID] = reqCancel
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func(msg Message) {
+			defer s.wg.Done()
+			result, err := s.handle(reqCtx, msg)
+			if err != nil && reqCtx.Err() != nil && ctx.Err() == nil {
+				err = &Error{Code: CodeRequestCancelled, Message: err.Error()}
+			}
+			s.mu.Lock()
+			delete(s.cancels, *msg.ID)
+			s.mu.Unlock()
+			reqCancel()
+			s.reply(msg.ID, result, err)
+		}(msg)
+	}
+}
+
+type server struct {
+	plugin Plugin
+	prov   Provider
+
+	mu      sync.Mutex
+	enc     *json.Encoder
+	cancels map[int64]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// reply sends the response to request id
+func (s *server) reply(id *int64, result interface{}, err error) {
+	msg := Message{JSONRPC: JSONRPCVersion, ID: id}
+	if err != nil {
+		msg.Error = toError(err)
+	} else {
+		data, encErr := encode(result)
+		if encErr != nil {
+			msg.Error = &Error{Code: CodeProviderError, Message: encErr.Error()}
+		} else {
+			msg.Result = data
+		}
+		if msg.Result == nil {
+			msg.Result = json.RawMessage("{}")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// There is nobody to tell if duet stopped listening
+	_ = s.enc.Encode(msg)
+}
+
+// notify handles a notification, which gets no response
+func (s *server) notify(msg Message) {
+	if msg.Method != MethodCancel {
+		return
+	}
+	var params CancelParams
+	if err := Decode(msg.Params, &params); err != nil {
+		return
+	}
+	s.mu.Lock()
+	cancel := s.cancels[params.ID]
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (s *server) initialize(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	if s.prov != nil {
+		return nil, &Error{Code: CodeInvalidRequest, Message: "plugin: already initialized"}
+	}
+	var params InitializeParams
+	if err := Decode(raw, &params); err != nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	if params.ProtocolVersion != ProtocolVersion {
+		return nil, &Error{
+			Code:    CodeInvalidRequest,
+			Message: fmt.Sprintf("duet speaks plugin protocol %d, but plugin %s speaks %d", params.ProtocolVersion, s.plugin.Name, ProtocolVersion),
+		}
+	}
+
+	prov, err := s.plugin.New(ctx, params.Settings)
+	if err != nil {
+		return nil, err
+	}
+	s.prov = prov
+
+	var capabilities []string
+	for _, c := range []struct {
+		name string
+		ok   bool
+	}{
+		{CapabilityValidateConfig, is[ConfigValidator](prov)},
+		{CapabilityFindByToken, is[TokenFinder](prov)},
+		{CapabilityReadData, is[DataReader](prov)},
+		{CapabilityNormalizeConfig, is[ConfigNormalizer](prov)},
+		{CapabilityDiscover, is[Discoverer](prov)},
+	} {
+		if c.ok {
+			capabilities = append(capabilities, c.name)
+		}
+	}
+	return &InitializeResult{ProtocolVersion: ProtocolVersion, Name: s.plugin.Name, Capabilities: capabilities}, nil
+}
+
+func is[T any](v interface{}) bool {
+	_, ok := v.(T)
+	return ok
+}
+
+// handle calls the provider method a request names
+func (s *server) handle(ctx context.Context, msg Message) (interface{}, error) {
+	if s.prov == nil {
+		return nil, &Error{Code: CodeInvalidRequest, Message: "plugin: initialize must be called first"}
+	}
+	decode := func(v interface{}) error {
+		if err := Decode(msg.Params, v); err != nil {
+			return &Error{Code: CodeInvalidParams, Message: err.Error()}
+		}
+		return nil
+	}
+	unsupported := &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("provider %s does not support %s", s.plugin.Name, msg.Method)}
+
+	switch msg.Method {
+	case MethodSchema:
+		result := &SchemaResult{ResourceTypes: s.plugin.ResourceTypes, DataTypes: s.plugin.DataTypes}
+		if describer, ok := s.prov.(SchemaDescriber); ok {
+			if schema := describer.Schema(); schema != nil {
+				result.Resources = schema.Resources
+			}
+		}
+		if marker, ok := s.prov.(SensitiveMarker); ok {
+			for _, resourceType := range s.plugin.ResourceTypes {
+				if attributes := marker.SensitiveAttributes(resourceType); len(attributes) > 0 {
+					if result.Sensitive == nil {
+						result.Sensitive = make(map[string][]string)
+					}
+					result.Sensitive[resourceType] = attributes
+				}
+			}
+		} else {
+			for resourceType, r := range result.Resources {
+				if attributes := r.SensitiveAttributes(); len(attributes) > 0 {
+					if result.Sensitive == nil {
+						result.Sensitive = make(map[string][]string)
+					}
+					result.Sensitive[resourceType] = attributes
+				}
+			}
+		}
+		return result, nil
+
+	case MethodValidateConfig:
+		var params ConfigParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		validator, ok := s.prov.(ConfigValidator)
+		if !ok {
+			return nil, unsupported
+		}
+		return nil, validator.ValidateConfig(ctx, params.Type, params.Config)
+
+	case MethodCreate:
+		var params CreateParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		if params.IdempotencyToken != "" {
+			ctx = provider.WithIdempotencyToken(ctx, params.IdempotencyToken)
+		}
+		if params.Ownership != nil {
+			ctx = provider.WithOwnership(ctx, *params.Ownership)
+		}
+		res, err := s.prov.Create(ctx, params.Type, params.Config)
+		if err != nil && res != nil {
+			// Duet keeps track of resources that were created but failed
+			// afterwards, such as by never becoming ready
+			e := *toError(err)
+			e.Data, _ = encode(&ResourceResult{Resource: ToBaseResource(res)})
+			return nil, &e
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &ResourceResult{Resource: ToBaseResource(res)}, nil
+
+	case MethodRead:
+		var params ReadParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		res, err := s.prov.Read(ctx, params.Type, params.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &ResourceResult{Resource: ToBaseResource(res)}, nil
+
+	case MethodUpdate:
+		var params UpdateParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		if params.Resource == nil {
+			return nil, &Error{Code: CodeInvalidParams, Message: "resource is required"}
+		}
+		if params.Ownership != nil {
+			ctx = provider.WithOwnership(ctx, *params.Ownership)
+		}
+		return nil, s.prov.Update(ctx, params.Resource, params.Config)
+
+	case MethodDelete:
+		var params DeleteParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		if params.Resource == nil {
+			return nil, &Error{Code: CodeInvalidParams, Message: "resource is required"}
+		}
+		if params.Ownership != nil {
+			ctx = provider.WithOwnership(ctx, *params.Ownership)
+		}
+		return nil, s.prov.Delete(ctx, params.Resource)
+
+	case MethodFindByToken:
+		var params FindByTokenParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		finder, ok := s.prov.(TokenFinder)
+		if !ok {
+			return nil, unsupported
+		}
+		res, err := finder.FindByToken(ctx, params.Type, params.Token)
+		if err != nil {
+			return nil, err
+		}
+		return &ResourceResult{Resource: ToBaseResource(res)}, nil
+
+	case MethodReadData:
+		var params ConfigParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		reader, ok := s.prov.(DataReader)
+		if !ok {
+			return nil, unsupported
+		}
+		attributes, err := reader.ReadData(ctx, params.Type, params.Config)
+		if err != nil {
+			return nil, err
+		}
+		return &ConfigResult{Config: attributes}, nil
+
+	case MethodNormalizeConfig:
+		var params ConfigParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		normalizer, ok := s.prov.(ConfigNormalizer)
+		if !ok {
+			return nil, unsupported
+		}
+		return &ConfigResult{Config: normalizer.NormalizeConfig(params.Type, params.Config)}, nil
+
+	case MethodDiscoverableTypes:
+		discoverer, ok := s.prov.(Discoverer)
+		if !ok {
+			return nil, unsupported
+		}
+		return &TypesResult{Types: discoverer.DiscoverableTypes()}, nil
+
+	case MethodDiscover:
+		var params DiscoverParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		discoverer, ok := s.prov.(Discoverer)
+		if !ok {
+			return nil, unsupported
+		}
+		found, err := discoverer.Discover(ctx, params.Type, params.Tags)
+		if err != nil {
+			return nil, err
+		}
+		result := &DiscoverResult{Resources: make([]DiscoveredResource, len(found))}
+		for i, d := range found {
+			result.Resources[i] = DiscoveredResource{Resource: ToBaseResource(d.Resource), Config: d.Config}
+		}
+		return result, nil
+
+	default:
+		return nil, &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("unknown method %q", msg.Method)}
+	}
+}
@@ -0,0 +1,243 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/rebelopsio/duet/pkg/types"
+)
+
+// echoProvider creates resources holding their config. Creates of type
+// "broken" fail after creating; creates of type "wait" wait to be cancelled.
+type echoProvider struct{}
+
+func (echoProvider) Name() string { return "echo" }
+
+func (echoProvider) Create(ctx context.Context, resourceType string, config map[string]interface{}) (Resource, error) {
+	res := &types.BaseResource{ID: "echo-1", Type: types.ResourceType(resourceType), Metadata: config}
+	if token := IdempotencyToken(ctx); token != "" {
+		res.Tags = map[string]string{"token": token}
+	}
+	switch resourceType {
+	case "broken":
+		return res, errors.New("not ready")
+	case "wait":
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return res, nil
+}
+
+func (echoProvider) Read(ctx context.Context, resourceType string, id string) (Resource, error) {
+	return nil, ErrNotFound
+}
+
+func (echoProvider) Update(ctx context.Context, resource Resource, config map[string]interface{}) error {
+	return nil
+}
+
+func (echoProvider) Delete(ctx context.Context, resource Resource) error {
+	return nil
+}
+
+func (echoProvider) SensitiveAttributes(resourceType string) []string {
+	if resourceType == "secret" {
+		return []string{"value"}
+	}
+	return nil
+}
+
+var echoPlugin = Plugin{
+	Name:          "echo",
+	ResourceTypes: []string{"thing", "secret"},
+	New: func(ctx context.Context, settings map[string]interface{}) (Provider, error) {
+		return echoProvider{}, nil
+	},
+}
+
+// session exchanges raw messages with a served plugin
+type session struct {
+	t      *testing.T
+	w      io.WriteCloser
+	r      *bufio.Scanner
+	nextID int64
+}
+
+func newSession(t *testing.T) *session {
+	t.Helper()
+	toPlugin, w := io.Pipe()
+	r, fromPlugin := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeConn(context.Background(), echoPlugin, toPlugin, fromPlugin)
+		_ = fromPlugin.Close()
+	}()
+	t.Cleanup(func() {
+		_ = w.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Expected the plugin to stop cleanly, got %v", err)
+		}
+	})
+	return &session{t: t, w: w, r: bufio.NewScanner(r)}
+}
+
+// send writes a request and returns its ID
+func (s *session) send(method string, params interface{}) int64 {
+	s.t.Helper()
+	s.nextID++
+	id := s.nextID
+	data, err := json.Marshal(params)
+	if err != nil {
+		s.t.Fatalf("Failed to encode params: %v", err)
+	}
+	s.write(Message{JSONRPC: JSONRPCVersion, ID: &id, Method: method, Params: data})
+	return id
+}
+
+func (s *session) write(msg Message) {
+	s.t.Helper()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		s.t.Fatalf("Failed to encode message: %v", err)
+	}
+	if _, err := s.w.Write(append(data, '\n')); err != nil {
+		s.t.Fatalf("Failed to write message: %v", err)
+	}
+}
+
+// receive reads the next response
+func (s *session) receive() Message {
+	s.t.Helper()
+	if !s.r.Scan() {
+		s.t.Fatalf("Failed to read response: %v", s.r.Err())
+	}
+	var msg Message
+	if err := json.Unmarshal(s.r.Bytes(), &msg); err != nil {
+		s.t.Fatalf("Failed to decode response %q: %v", s.r.Text(), err)
+	}
+	return msg
+}
+
+// call sends a request and returns its response
+func (s *session) call(method string, params interface{}) Message {
+	s.t.Helper()
+	id := s.send(method, params)
+	msg := s.receive()
+	if msg.ID == nil || *msg.ID != id {
+		s.t.Fatalf("Expected the response to request %d, got %+v", id, msg)
+	}
+	return msg
+}
+
+func (s *session) initialize() {
+	s.t.Helper()
+	msg := s.call(MethodInitialize, &InitializeParams{ProtocolVersion: ProtocolVersion})
+	if msg.Error != nil {
+		s.t.Fatalf("Failed to initialize: %v", msg.Error)
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	t.Run("Initialize", func(t *testing.T) {
+		s := newSession(t)
+		if msg := s.call(MethodSchema, nil); msg.Error == nil || msg.Error.Code != CodeInvalidRequest {
+			t.Errorf("Expected an error before initialize, got %+v", msg)
+		}
+		if msg := s.call(MethodInitialize, &InitializeParams{ProtocolVersion: 99}); msg.Error == nil || msg.Error.Code != CodeInvalidRequest {
+			t.Errorf("Expected an error for another protocol version, got %+v", msg)
+		}
+
+		msg := s.call(MethodInitialize, &InitializeParams{ProtocolVersion: ProtocolVersion})
+		var result InitializeResult
+		if err := Decode(msg.Result, &result); err != nil {
+			t.Fatalf("Failed to decode result: %v", err)
+		}
+		if result.Name != "echo" || result.ProtocolVersion != ProtocolVersion || len(result.Capabilities) != 0 {
+			t.Errorf("Expected provider echo without capabilities, got %+v", result)
+		}
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		s := newSession(t)
+		s.initialize()
+		var result SchemaResult
+		if err := Decode(s.call(MethodSchema, nil).Result, &result); err != nil {
+			t.Fatalf("Failed to decode result: %v", err)
+		}
+		if len(result.ResourceTypes) != 2 || len(result.Sensitive) != 1 || result.Sensitive["secret"][0] != "value" {
+			t.Errorf("Expected two resource types and one sensitive attribute, got %+v", result)
+		}
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		s := newSession(t)
+		s.initialize()
+		msg := s.call(MethodCreate, &CreateParams{Type: "thing", Config: map[string]interface{}{"size": 1}, IdempotencyToken: "t-1"})
+		var result ResourceResult
+		if err := Decode(msg.Result, &result); err != nil {
+			t.Fatalf("Failed to decode result: %v", err)
+		}
+		if result.Resource.ID != "echo-1" || result.Resource.Tags["token"] != "t-1" || result.Resource.Metadata["size"] != 1 {
+			t.Errorf("Expected the created resource with its token, got %+v", result.Resource)
+		}
+
+		msg = s.call(MethodCreate, &CreateParams{Type: "broken"})
+		if msg.Error == nil || msg.Error.Message != "not ready" {
+			t.Fatalf("Expected the provider's error, got %+v", msg)
+		}
+		if err := Decode(msg.Error.Data, &result); err != nil || result.Resource == nil {
+			t.Errorf("Expected the created resource with the error, got %s", msg.Error.Data)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		s := newSession(t)
+		s.initialize()
+		for _, tt := range []struct {
+			method string
+			params interface{}
+			code   int
+		}{
+			{MethodRead, &ReadParams{Type: "thing", ID: "x"}, CodeNotFound},
+			{MethodReadData, &ConfigParams{Type: "thing"}, CodeMethodNotFound},
+			{"teleport", nil, CodeMethodNotFound},
+			{MethodUpdate, map[string]interface{}{"config": "x"}, CodeInvalidParams},
+			{MethodDelete, map[string]interface{}{}, CodeInvalidParams},
+		} {
+			if msg := s.call(tt.method, tt.params); msg.Error == nil || msg.Error.Code != tt.code {
+				t.Errorf("Expected %s to fail with code %d, got %+v", tt.method, tt.code, msg)
+			}
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		s := newSession(t)
+		s.initialize()
+		id := s.send(MethodCreate, &CreateParams{Type: "wait"})
+		params, _ := json.Marshal(&CancelParams{ID: id})
+		s.write(Message{JSONRPC: JSONRPCVersion, Method: MethodCancel, Params: params})
+
+		done := make(chan Message, 1)
+		go func() { done <- s.receive() }()
+		select {
+		case msg := <-done:
+			if msg.Error == nil || msg.Error.Code != CodeRequestCancelled {
+				t.Errorf("Expected the request to be cancelled, got %+v", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected a response to the cancelled request")
+		}
+	})
+}
+
+func TestServe(t *testing.T) {
+	t.Setenv(EnvProtocol, "")
+	if err := Serve(echoPlugin); err == nil {
+		t.Error("Expected an error running outside duet, got nil")
+	}
+}
@@ -12,10 +12,78 @@ type ResourceType string
 
 // Common resource types
 const (
-	ResourceTypeInstance ResourceType = "instance"
-	ResourceTypeVolume   ResourceType = "volume"
-	ResourceTypeNetwork  ResourceType = "network"
-	ResourceTypeStorage  ResourceType = "storage"
+	ResourceTypeInstance         ResourceType = "instance"
+	ResourceTypeVolume           ResourceType = "volume"
+	ResourceTypeVolumeAttachment ResourceType = "volume_attachment"
+	ResourceTypeNetwork          ResourceType = "network"
+	ResourceTypeStorage          ResourceType = "storage"
+)
+
+// Network resource types
+const (
+	ResourceTypeVPC                   ResourceType = "vpc"
+	ResourceTypeSubnet                ResourceType = "subnet"
+	ResourceTypeRouteTable            ResourceType = "route_table"
+	ResourceTypeRouteTableAssociation ResourceType = "route_table_association"
+	ResourceTypeInternetGateway       ResourceType = "internet_gateway"
+	ResourceTypeNATGateway            ResourceType = "nat_gateway"
+	ResourceTypeElasticIP             ResourceType = "eip"
+	ResourceTypeSecurityGroup         ResourceType = "security_group"
+)
+
+// IAM resource types
+const (
+	ResourceTypeIAMRole                 ResourceType = "iam_role"
+	ResourceTypeIAMPolicy               ResourceType = "iam_policy"
+	ResourceTypeIAMRolePolicy           ResourceType = "iam_role_policy"
+	ResourceTypeIAMRolePolicyAttachment ResourceType = "iam_role_policy_attachment"
+	ResourceTypeIAMInstanceProfile      ResourceType = "iam_instance_profile"
+)
+
+// Load balancing resource types
+const (
+	ResourceTypeLoadBalancer                      ResourceType = "lb"
+	ResourceTypeLoadBalancerTargetGroup           ResourceType = "lb_target_group"
+	ResourceTypeLoadBalancerListener              ResourceType = "lb_listener"
+	ResourceTypeLoadBalancerTargetGroupAttachment ResourceType = "lb_target_group_attachment"
+)
+
+// Auto scaling resource types
+const (
+	ResourceTypeLaunchTemplate   ResourceType = "launch_template"
+	ResourceTypeAutoScalingGroup ResourceType = "autoscaling_group"
+)
+
+// Route 53 resource types
+const (
+	ResourceTypeRoute53Zone   ResourceType = "route53_zone"
+	ResourceTypeRoute53Record ResourceType = "route53_record"
+)
+
+// Access resource types
+const (
+	ResourceTypeKeyPair ResourceType = "key_pair"
+)
+
+// Local resource types
+const (
+	ResourceTypeFile      ResourceType = "file"
+	ResourceTypeDirectory ResourceType = "directory"
+	ResourceTypeSymlink   ResourceType = "symlink"
+)
+
+// Random resource types
+const (
+	ResourceTypeRandomString   ResourceType = "string"
+	ResourceTypeRandomPassword ResourceType = "password"
+	ResourceTypeRandomUUID     ResourceType = "uuid"
+)
+
+// TLS resource types
+const (
+	ResourceTypePrivateKey     ResourceType = "private_key"
+	ResourceTypeSelfSignedCert ResourceType = "self_signed_cert"
+	ResourceTypeCertRequest    ResourceType = "cert_request"
 )
 
 // ResourceStatus represents the current state of a resource